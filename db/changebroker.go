@@ -0,0 +1,127 @@
+package db
+
+import (
+	"context"
+	"dockerrestapi/lib"
+	"strconv"
+	"sync"
+)
+
+var (
+	ChangeHistorySize  = 1024 // events kept by in-memory backends for resuming streams
+	changeStreamBuffer = 256  // events a slow subscriber may fall behind before its stream is closed
+)
+
+// changeBroker fans change events of an in-memory backend out to its streams, keeping a bounded history to resume from.
+// Tokens are the decimal sequence number of an event.
+type changeBroker struct {
+	lock        sync.Mutex
+	sequence    uint64
+	history     []lib.ChangeEvent
+	subscribers map[*brokerStream]struct{}
+	closed      bool // once the library is dropped
+}
+
+// brokerStream is a subscription to a changeBroker
+type brokerStream struct {
+	broker *changeBroker
+	events chan lib.ChangeEvent
+	err    error // why the stream was closed, read once events is closed
+}
+
+func newChangeBroker() *changeBroker {
+	return &changeBroker{subscribers: map[*brokerStream]struct{}{}}
+}
+
+// publish assigns the next token to an event and delivers it without blocking, dropping subscribers too far behind
+func (b *changeBroker) publish(event lib.ChangeEvent) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.sequence++
+	event.Token = strconv.FormatUint(b.sequence, 10)
+	b.history = append(b.history, event)
+	if len(b.history) > ChangeHistorySize {
+		b.history = b.history[len(b.history)-ChangeHistorySize:]
+	}
+	for stream := range b.subscribers {
+		select {
+		case stream.events <- event:
+		default:
+			b.unsubscribe(stream, lib.ChangeStreamLagging)
+		}
+	}
+}
+
+// closeAll ends every stream of a dropped library and refuses new ones. Streams already closed are left alone.
+func (b *changeBroker) closeAll() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.closed = true
+	for stream := range b.subscribers {
+		b.unsubscribe(stream, lib.ChangeStreamClosed)
+	}
+}
+
+// unsubscribe closes a stream, Next returning err once its pending events are read. Caller must hold the lock.
+func (b *changeBroker) unsubscribe(stream *brokerStream, err error) {
+	if _, subscribed := b.subscribers[stream]; subscribed {
+		delete(b.subscribers, stream)
+		stream.err = err
+		close(stream.events)
+	}
+}
+
+// subscribe opens a stream of events after the resume token, or of future events only when the token is empty
+func (b *changeBroker) subscribe(resumeToken string) (*brokerStream, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.closed {
+		return nil, lib.NoMatchingLibrary
+	}
+	var missed []lib.ChangeEvent
+	if resumeToken != "" {
+		after, err := strconv.ParseUint(resumeToken, 10, 64)
+		if err != nil || after > b.sequence {
+			return nil, lib.IncorrectResumeToken
+		}
+		oldest := b.sequence - uint64(len(b.history)) // sequence of the event just before the history
+		if after < oldest {
+			return nil, lib.ResumeTokenExpired
+		}
+		missed = b.history[len(b.history)-int(b.sequence-after):]
+	}
+
+	stream := &brokerStream{
+		broker: b,
+		events: make(chan lib.ChangeEvent, len(missed)+changeStreamBuffer),
+	}
+	for _, event := range missed {
+		stream.events <- event
+	}
+	b.subscribers[stream] = struct{}{}
+	return stream, nil
+}
+
+// Next waits for the next event of the stream
+func (s *brokerStream) Next(ctx context.Context) (lib.ChangeEvent, error) {
+	select {
+	case event, open := <-s.events:
+		if !open {
+			return lib.ChangeEvent{}, s.err
+		}
+		return event, nil
+	case <-ctx.Done():
+		return lib.ChangeEvent{}, ctx.Err()
+	}
+}
+
+func (s *brokerStream) Close() error {
+	s.broker.lock.Lock()
+	defer s.broker.lock.Unlock()
+
+	s.broker.unsubscribe(s, lib.ChangeStreamLagging)
+	return nil
+}
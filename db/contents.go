@@ -0,0 +1,151 @@
+package db
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"dockerrestapi/lib"
+	"encoding/hex"
+	"hash"
+	"io"
+	"strings"
+)
+
+var (
+	ContentsFileThreshold int64 = 1 << 20   // contents larger than this are stored in a file rather than inline in the book
+	ContentsChunkSize           = 255 << 10 // chunk size of file stored contents, matches the GridFS default
+	PageSize                    = 2000      // most bytes of contents per page, pages breaking earlier at a line end or space
+)
+
+// inlineContents reads contents stored inline in the book.
+type inlineContents struct {
+	*strings.Reader
+}
+
+func newInlineContents(contents string) *inlineContents {
+	return &inlineContents{Reader: strings.NewReader(contents)}
+}
+
+func (c *inlineContents) Close() error {
+	return nil
+}
+
+func (c *inlineContents) Skip(n int64) (int64, error) {
+	if remaining := int64(c.Len()); n > remaining {
+		n = remaining
+	}
+	_, err := c.Seek(n, io.SeekCurrent)
+	return n, err
+}
+
+// chunkedContents reads contents stored as a list of fixed size chunks.
+type chunkedContents struct {
+	chunks [][]byte
+	size   int64
+	offset int64
+}
+
+func (c *chunkedContents) Read(p []byte) (int, error) {
+	if c.offset >= c.size {
+		return 0, io.EOF
+	}
+	chunk := c.chunks[c.offset/int64(ContentsChunkSize)]
+	n := copy(p, chunk[c.offset%int64(ContentsChunkSize):])
+	c.offset += int64(n)
+	return n, nil
+}
+
+func (c *chunkedContents) Close() error {
+	return nil
+}
+
+func (c *chunkedContents) Size() int64 {
+	return c.size
+}
+
+func (c *chunkedContents) Skip(n int64) (int64, error) {
+	if c.offset+n > c.size {
+		n = c.size - c.offset
+	}
+	c.offset += n
+	return n, nil
+}
+
+// readChunks reads all of a reader into chunks of ContentsChunkSize, returning the chunks and total length
+func readChunks(contents io.Reader) ([][]byte, int64, error) {
+	var chunks [][]byte
+	var length int64
+	for {
+		chunk := make([]byte, ContentsChunkSize)
+		n, err := io.ReadFull(contents, chunk)
+		if n > 0 {
+			chunks = append(chunks, chunk[:n])
+			length += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return chunks, length, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+}
+
+// peekInline reads up to ContentsFileThreshold bytes of contents. When the contents fit they are returned as a string,
+// otherwise a reader replaying the full contents is returned to be stored in a file.
+func peekInline(contents io.Reader) (string, io.Reader, error) {
+	head, err := io.ReadAll(io.LimitReader(contents, ContentsFileThreshold+1))
+	if err != nil {
+		return "", nil, err
+	}
+	if int64(len(head)) <= ContentsFileThreshold {
+		return string(head), nil, nil
+	}
+	return "", io.MultiReader(bytes.NewReader(head), contents), nil
+}
+
+// splitContents returns contents as an inline string when under the threshold, otherwise as chunks, along with the total length
+func splitContents(contents io.Reader) (string, [][]byte, int64, error) {
+	inline, large, err := peekInline(contents)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	if large == nil {
+		return inline, nil, int64(len(inline)), nil
+	}
+	chunks, length, err := readChunks(large)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	return "", chunks, length, nil
+}
+
+// contentsAnalyser parses the structure, counts the statistics and terms and hashes the contents of a book, fed the contents as they are stored
+type contentsAnalyser struct {
+	structure *lib.StructureParser
+	stats     *lib.StatsCounter
+	hash      hash.Hash
+}
+
+// newContentsAnalyser returns an analyser of the contents of a book
+func newContentsAnalyser(book *lib.Book) (*contentsAnalyser, error) {
+	parser, err := lib.NewStructureParser(book.ChapterPattern, PageSize)
+	if err != nil {
+		return nil, err
+	}
+	return &contentsAnalyser{structure: parser, stats: lib.NewStatsCounter(), hash: sha256.New()}, nil
+}
+
+// Write analyses the next bytes of the contents
+func (a *contentsAnalyser) Write(data []byte) (int, error) {
+	_, _ = a.structure.Write(data)
+	_, _ = a.hash.Write(data)
+	return a.stats.Write(data)
+}
+
+// apply ends the analysis and sets the structure, statistics, terms and hash of the contents on the book
+func (a *contentsAnalyser) apply(book *lib.Book) {
+	book.Structure = a.structure.Structure()
+	book.Stats = a.stats.Stats()
+	book.Terms = a.stats.Terms(lib.TermVectorSize)
+	book.ContentsHash = hex.EncodeToString(a.hash.Sum(nil))
+}
@@ -1,15 +1,167 @@
 package db
 
 import (
+	"context"
 	"dockerrestapi/lib"
+	"io"
+	"time"
 )
 
 // RestDbInterface built for book library.
+// Every handler is bound to a single library, Library returns a handler bound to another library of the same deployment.
+// Books are stored under the canonical name of their author when written under the name or an alias of a known author,
+// and books looked up by name and author are found by any of these names.
 type RestDbInterface interface {
 	Disconnect()
-	GetAllBooks() ([]lib.BookIdentifier, error)
+	GetAllBooks(filter lib.BookFilter) ([]lib.BookIdentifier, error)
+	EachBook(filter lib.BookFilter, visit func(lib.BookIdentifier) error) error // visits the books matching the filter as they are read, until visit fails
 	CreateNewBook(book *lib.Book) error
 	GetOneBook(bookIdentifier *lib.BookIdentifier) (*lib.Book, error)
 	UpdateExistingBook(book *lib.Book) error
-	DeleteBook(bookIdentifier *lib.BookIdentifier) error
+	DeleteBook(bookIdentifier *lib.BookIdentifier) error // moves the book to the trash
+
+	// GetAllBooksWithFields and GetOneBookWithFields only load the id and the given fields, by bson tag, of books
+	GetAllBooksWithFields(filter lib.BookFilter, fields []string) ([]lib.Book, error)
+	GetOneBookWithFields(bookIdentifier *lib.BookIdentifier, fields []string) (*lib.Book, error)
+	GetFacets(filter lib.BookFilter, limit int) (*lib.Facets, error) // counts the books matching the filter, up to limit values per facet
+
+	GetDeletedBooks() ([]lib.BookIdentifier, error)
+	RestoreBook(bookIdentifier *lib.BookIdentifier) error
+	PurgeBook(bookIdentifier *lib.BookIdentifier) ([]lib.Attachment, error) // permanently removes a book from the trash, returning its removed attachments
+
+	GetBookContents(bookIdentifier *lib.BookIdentifier) (ContentsReader, error)
+	StoreBookContents(bookIdentifier *lib.BookIdentifier, contents io.Reader) error
+
+	// Revisions keep the contents of a book each time they are written with changes, until the book is purged
+	GetAllRevisions(bookID string) ([]lib.Revision, error) // oldest first
+	GetRevisionContents(bookID string, number int) (ContentsReader, error)
+	PurgeRevisions(before time.Time) (int, error) // removes the revisions created before the cutoff but the latest of each book
+
+	GetAllAttachments(bookID string) ([]lib.Attachment, error)
+	GetOneAttachment(bookID, attachmentID string) (*lib.Attachment, error)
+	CreateNewAttachment(attachment *lib.Attachment) error
+	DeleteAttachment(bookID, attachmentID string) error
+	IsBlobReferenced(hash string) (bool, error)
+
+	GetAllAuthors() ([]lib.Author, error)
+	GetOneAuthor(id string) (*lib.Author, error)
+	FindAuthor(name string) (*lib.Author, error)                 // by canonical name or alias
+	CreateNewAuthor(author *lib.Author) error                    // also links the books written under its names
+	UpdateExistingAuthor(author *lib.Author) error               // also links the books written under its new names
+	DeleteAuthor(id string) error                                // fails while books are linked to it
+	MergeAuthors(sourceID, targetID string) (*lib.Author, error) // re-points the books of source to target, which takes its names as aliases
+
+	// Reviews belong to a book, the rating of the book is updated along with them
+	GetAllReviews(bookID string, query lib.ReviewQuery) (*lib.ReviewPage, error)
+	GetOneReview(bookID, reviewID string) (*lib.Review, error)
+	CreateNewReview(review *lib.Review) error                               // fails when the user already reviewed the book
+	UpdateExistingReview(review *lib.Review) error                          // changes the rating, title and text of a review
+	ModerateReview(bookID, reviewID, status string) (*lib.Review, error)    // sets the moderation status of a review
+	VoteReviewHelpful(bookID, reviewID, userID string) (*lib.Review, error) // counts a user once however often they vote
+	DeleteReview(bookID, reviewID string) error
+
+	// Shelves hold books of the library in order, books in the trash are hidden from shelves and leave them when purged
+	GetAllShelves(filter lib.ShelfFilter) ([]lib.Shelf, error)
+	GetOneShelf(id string) (*lib.Shelf, error)
+	CreateNewShelf(shelf *lib.Shelf) error
+	UpdateExistingShelf(shelf *lib.Shelf) error // changes the name, description and visibility of a shelf
+	DeleteShelf(id string) error
+	AddShelfBooks(id string, bookIDs []string, position int) (*lib.Shelf, error) // fails unless every book is in the library
+	RemoveShelfBooks(id string, bookIDs []string) (*lib.Shelf, error)
+	ReorderShelfBooks(id string, bookIDs []string) (*lib.Shelf, error)
+
+	// Reading progress and bookmarks belong to a user reading a book, bookmarks are re-anchored when the contents of their book change.
+	// Changes carry the time they were made at, a change older than the stored state is ignored and the stored state returned in place.
+	GetReadingProgress(bookID, userID string) (*lib.ReadingProgress, error)
+	SaveReadingProgress(progress *lib.ReadingProgress) error                         // locates the progress in the contents
+	GetAllBookmarks(bookID, userID string, since *time.Time) ([]lib.Bookmark, error) // only the ones changed after since when given, deleted ones included
+	GetOneBookmark(bookID, bookmarkID string) (*lib.Bookmark, error)
+	CreateNewBookmark(bookmark *lib.Bookmark) error                                       // anchors the bookmark in the contents, once per client id
+	UpdateExistingBookmark(bookmark *lib.Bookmark) error                                  // changes the range and note of a bookmark
+	DeleteBookmark(bookID, bookmarkID string, deletedAt time.Time) (*lib.Bookmark, error) // leaves a tombstone
+
+	WatchBooks(resumeToken string) (ChangeStream, error) // streams changes to the books of the library after the token, or from now when empty
+
+	Library(name string) (RestDbInterface, error)
+	GetAllLibraries() ([]lib.Library, error)
+	CreateLibrary(name string) error
+	DropLibrary(name string) error
+}
+
+// ContentsReader streams the contents of a book without holding them in memory.
+type ContentsReader interface {
+	io.ReadCloser
+	Size() int64
+	Skip(n int64) (int64, error)
+}
+
+// ChangeStream is an ordered stream of change events, Next blocks until an event or the context is done.
+type ChangeStream interface {
+	Next(ctx context.Context) (lib.ChangeEvent, error)
+	Close() error
+}
+
+// BlobStoreInterface stores content addressed binary blobs, such as book attachments.
+// Blobs are grouped by namespace, the library they belong to, and keyed by the hex SHA-256 of their content.
+type BlobStoreInterface interface {
+	Disconnect()
+	HasBlob(namespace, hash string) (bool, error)
+	PutBlob(namespace, hash string, blob io.Reader) error
+	GetBlob(namespace, hash string) (ContentsReader, error)
+	DeleteBlob(namespace, hash string) error
+	DropNamespace(namespace string) error
+}
+
+// WebhookStoreInterface stores webhook subscriptions and their persistent delivery queue.
+// Webhooks and deliveries belong to a library, deliveries are addressed through their webhook.
+type WebhookStoreInterface interface {
+	Disconnect()
+	GetAllWebhooks(library string) ([]lib.Webhook, error)
+	GetOneWebhook(library, id string) (*lib.Webhook, error)
+	CreateNewWebhook(webhook *lib.Webhook) error
+	DeleteWebhook(library, id string) error // also deletes its deliveries
+	DropNamespace(library string) error
+
+	CreateNewDelivery(delivery *lib.Delivery) error
+	GetDeliveries(library, webhookID, status string) ([]lib.Delivery, error) // of every webhook of the library when webhookID is empty, of any status when status is empty
+	GetOneDelivery(library, webhookID, id string) (*lib.Delivery, error)
+	// ClaimDueDeliveries leases pending deliveries of every library due by now, oldest first, by moving their next attempt to
+	// now plus the lease, so that no other dispatcher claims them until the lease is over or their attempt is recorded
+	ClaimDueDeliveries(now time.Time, lease time.Duration, limit int) ([]lib.Delivery, error)
+	UpdateDelivery(delivery *lib.Delivery) error
+}
+
+// LendingStoreInterface stores the patrons of libraries, the copies they own of their books, the loans of these copies and the holds on them.
+// A book has lib.DefaultCopies until its count is set, and checkouts never lend more copies than the book has, even when concurrent.
+// Holds of a book are queued first come first served: whenever a copy is free, returned or added, it is set aside for the oldest
+// waiting hold until now plus the pickup window, and only the patron of that hold may check it out.
+type LendingStoreInterface interface {
+	Disconnect()
+	GetAllPatrons(library string) ([]lib.Patron, error)
+	GetOnePatron(library, id string) (*lib.Patron, error)
+	CreateNewPatron(patron *lib.Patron) error
+	DeletePatron(library, id string) error // fails while the patron has books on loan or on hold
+	DropNamespace(library string) error
+
+	GetCopies(library, bookID string) (*lib.BookCopies, error)
+	SetCopies(library, bookID string, copies int, now time.Time, pickupWindow time.Duration) (*lib.BookCopies, error) // fails below the copies on loan or set aside
+
+	CheckoutBook(loan *lib.Loan) error                                  // fulfils the ready hold of the patron, otherwise fails unless a copy is available
+	GetLoans(library string, filter lib.LoanFilter) ([]lib.Loan, error) // oldest checkout first
+	GetOneLoan(library, id string) (*lib.Loan, error)
+	ReturnLoan(library, id string, now time.Time, pickupWindow time.Duration) (*lib.Loan, error)
+	RenewLoan(library, id string, now, dueAt time.Time, maxRenewals int) (*lib.Loan, error) // fails once overdue or renewed maxRenewals times
+
+	PlaceHold(hold *lib.Hold, pickupWindow time.Duration) error         // fails while a copy is available or the patron already holds the book
+	GetHolds(library string, filter lib.HoldFilter) ([]lib.Hold, error) // in queue order
+	GetOneHold(library, id string) (*lib.Hold, error)
+	CancelHold(library, id string, now time.Time, pickupWindow time.Duration) (*lib.Hold, error)
+	ExpireHolds(now time.Time, pickupWindow time.Duration) ([]lib.Hold, error) // expires the ready holds of every library past their pickup deadline
+}
+
+// QuotaStoreInterface counts the requests of clients per UTC day, so that daily quotas hold across restarts and replicas of the api.
+// Clients are opaque keys, usages of past days are forgotten once over.
+type QuotaStoreInterface interface {
+	Disconnect()
+	IncrementQuota(client, day string, expiresAt time.Time) (int64, error) // counts a request of the client on the day, returning its requests that day
 }
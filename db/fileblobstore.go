@@ -0,0 +1,152 @@
+package db
+
+import (
+	"dockerrestapi/lib"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// blobHashRegex matches a hex SHA-256, so hashes are safe to use as file names
+var blobHashRegex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// blobNamespaceRegex matches the library names safe to use as directory names, including those created with a '_'
+// before it was left out of library names
+var blobNamespaceRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+type FileBlobStore struct {
+	root string
+}
+
+// CreateFileBlobStore returns a blob store keeping blobs as files under root, laid out as root/namespace/ab/abcdef...
+func CreateFileBlobStore(root string) (BlobStoreInterface, error) {
+	err := os.MkdirAll(root, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Connected to FileBlobStore at", root)
+	return &FileBlobStore{root: root}, nil
+}
+
+func (f *FileBlobStore) Disconnect() {
+}
+
+func (f *FileBlobStore) HasBlob(namespace, hash string) (bool, error) {
+	path, err := f.blobPath(namespace, hash)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// PutBlob writes the blob to a temporary file first, so a failed upload never leaves a partial blob behind
+func (f *FileBlobStore) PutBlob(namespace, hash string, blob io.Reader) error {
+	path, err := f.blobPath(namespace, hash)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(path), hash+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	_, err = io.Copy(temp, blob)
+	if err != nil {
+		temp.Close()
+		return err
+	}
+	err = temp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
+
+func (f *FileBlobStore) GetBlob(namespace, hash string) (ContentsReader, error) {
+	path, err := f.blobPath(namespace, hash)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, lib.NoMatchingBlob
+		}
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &fileContents{File: file, size: info.Size()}, nil
+}
+
+func (f *FileBlobStore) DeleteBlob(namespace, hash string) error {
+	path, err := f.blobPath(namespace, hash)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return lib.NoMatchingBlob
+	}
+	return err
+}
+
+func (f *FileBlobStore) DropNamespace(namespace string) error {
+	if !blobNamespaceRegex.MatchString(namespace) {
+		return lib.IncorrectLibraryName
+	}
+	return os.RemoveAll(filepath.Join(f.root, namespace))
+}
+
+// blobPath returns the file path of a blob, validating the namespace and hash cannot escape the root
+func (f *FileBlobStore) blobPath(namespace, hash string) (string, error) {
+	if !blobNamespaceRegex.MatchString(namespace) {
+		return "", lib.IncorrectLibraryName
+	}
+	if !blobHashRegex.MatchString(hash) {
+		return "", lib.NoMatchingBlob
+	}
+	return filepath.Join(f.root, namespace, hash[:2], hash), nil
+}
+
+// fileContents reads a blob from an open file.
+type fileContents struct {
+	*os.File
+	size int64
+}
+
+func (c *fileContents) Size() int64 {
+	return c.size
+}
+
+func (c *fileContents) Skip(n int64) (int64, error) {
+	offset, err := c.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if remaining := c.size - offset; n > remaining {
+		n = remaining
+	}
+	_, err = c.Seek(n, io.SeekCurrent)
+	return n, err
+}
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"context"
+	"dockerrestapi/lib"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"io"
+	"log"
+	"regexp"
+)
+
+const (
+	attachmentsBucketName = "attachments"
+)
+
+type GridFSBlobStore struct {
+	client *mongo.Client
+	bucket *gridfs.Bucket
+}
+
+// CreateGridFSBlobStore returns a blob store keeping blobs in a GridFS bucket of the given database, each file named namespace/hash.
+func CreateGridFSBlobStore(dsn, databaseName string) (BlobStoreInterface, error) {
+	client, err := connectMongo(dsn)
+	if err != nil {
+		return nil, err
+	}
+	bucket, err := gridfs.NewBucket(client.Database(databaseName), options.GridFSBucket().SetName(attachmentsBucketName))
+	if err != nil {
+		return nil, err
+	}
+	return &GridFSBlobStore{
+		client: client,
+		bucket: bucket,
+	}, nil
+}
+
+func (g *GridFSBlobStore) Disconnect() {
+	err := disconnectMongo(g.client)
+	if err != nil {
+		log.Println("disconnect error:", err.Error())
+	}
+}
+
+func (g *GridFSBlobStore) HasBlob(namespace, hash string) (bool, error) {
+	count, err := g.bucket.GetFilesCollection().CountDocuments(context.Background(), bson.M{"filename": blobFileName(namespace, hash)})
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (g *GridFSBlobStore) PutBlob(namespace, hash string, blob io.Reader) error {
+	_, err := g.bucket.UploadFromStream(blobFileName(namespace, hash), blob)
+	return err
+}
+
+func (g *GridFSBlobStore) GetBlob(namespace, hash string) (ContentsReader, error) {
+	stream, err := g.bucket.OpenDownloadStreamByName(blobFileName(namespace, hash))
+	if err != nil {
+		if errors.Is(err, gridfs.ErrFileNotFound) {
+			return nil, lib.NoMatchingBlob
+		}
+		return nil, err
+	}
+	return &gridFSContents{DownloadStream: stream}, nil
+}
+
+func (g *GridFSBlobStore) DeleteBlob(namespace, hash string) error {
+	return g.deleteMatching(bson.M{"filename": blobFileName(namespace, hash)})
+}
+
+func (g *GridFSBlobStore) DropNamespace(namespace string) error {
+	return g.deleteMatching(bson.M{"filename": bson.M{"$regex": "^" + regexp.QuoteMeta(namespace+"/")}})
+}
+
+// deleteMatching deletes every file, and its chunks, matching a files collection filter
+func (g *GridFSBlobStore) deleteMatching(filter bson.M) error {
+	cursor, err := g.bucket.Find(filter)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(context.Background())
+
+	var files []gridfs.File
+	err = cursor.All(context.Background(), &files)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		err = g.bucket.Delete(file.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blobFileName returns the GridFS file name of a blob
+func blobFileName(namespace, hash string) string {
+	return namespace + "/" + hash
+}
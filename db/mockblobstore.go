@@ -0,0 +1,95 @@
+package db
+
+import (
+	"bytes"
+	"dockerrestapi/lib"
+	"io"
+	"log"
+	"sync"
+)
+
+type MockBlobStore struct {
+	lock  sync.RWMutex
+	blobs map[string]map[string][]byte // blobs keyed by namespace then hash
+}
+
+func CreateMockBlobStore() (BlobStoreInterface, error) {
+	log.Println("Connected to MockBlobStore!")
+	return &MockBlobStore{
+		blobs: map[string]map[string][]byte{},
+	}, nil
+}
+
+func (m *MockBlobStore) Disconnect() {
+}
+
+func (m *MockBlobStore) HasBlob(namespace, hash string) (bool, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	_, exists := m.blobs[namespace][hash]
+	return exists, nil
+}
+
+func (m *MockBlobStore) PutBlob(namespace, hash string, blob io.Reader) error {
+	contents, err := io.ReadAll(blob)
+	if err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.blobs[namespace] == nil {
+		m.blobs[namespace] = map[string][]byte{}
+	}
+	m.blobs[namespace][hash] = contents
+	return nil
+}
+
+func (m *MockBlobStore) GetBlob(namespace, hash string) (ContentsReader, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	contents, exists := m.blobs[namespace][hash]
+	if !exists {
+		return nil, lib.NoMatchingBlob
+	}
+	return &bytesContents{Reader: bytes.NewReader(contents)}, nil
+}
+
+func (m *MockBlobStore) DeleteBlob(namespace, hash string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, exists := m.blobs[namespace][hash]; !exists {
+		return lib.NoMatchingBlob
+	}
+	delete(m.blobs[namespace], hash)
+	return nil
+}
+
+func (m *MockBlobStore) DropNamespace(namespace string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.blobs, namespace)
+	return nil
+}
+
+// bytesContents reads a blob held in memory.
+type bytesContents struct {
+	*bytes.Reader
+}
+
+func (c *bytesContents) Close() error {
+	return nil
+}
+
+func (c *bytesContents) Skip(n int64) (int64, error) {
+	if remaining := int64(c.Len()); n > remaining {
+		n = remaining
+	}
+	_, err := c.Seek(n, io.SeekCurrent)
+	return n, err
+}
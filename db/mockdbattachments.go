@@ -0,0 +1,70 @@
+package db
+
+import (
+	"dockerrestapi/lib"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (m *MockDB) GetAllAttachments(bookID string) ([]lib.Attachment, error) {
+	m.shared.lock.RLock()
+	defer m.shared.lock.RUnlock()
+
+	if _, exists := m.findBook(lib.BookIdentifier{ID: bookID}); !exists {
+		return nil, lib.NoMatchingBook
+	}
+	results := []lib.Attachment{}
+	for _, attachment := range m.attachments {
+		if attachment.BookID == bookID {
+			results = append(results, attachment)
+		}
+	}
+	return results, nil
+}
+
+func (m *MockDB) GetOneAttachment(bookID, attachmentID string) (*lib.Attachment, error) {
+	m.shared.lock.RLock()
+	defer m.shared.lock.RUnlock()
+
+	attachment, exists := m.attachments[attachmentID]
+	if !exists || attachment.BookID != bookID {
+		return nil, lib.NoMatchingAttachment
+	}
+	return &attachment, nil
+}
+
+func (m *MockDB) CreateNewAttachment(attachment *lib.Attachment) error {
+	m.shared.lock.Lock()
+	defer m.shared.lock.Unlock()
+
+	if _, exists := m.findBook(lib.BookIdentifier{ID: attachment.BookID}); !exists {
+		return lib.NoMatchingBook
+	}
+	attachment.ID = primitive.NewObjectID().Hex()
+	attachment.CreatedAt = m.shared.clock.Now()
+	m.attachments[attachment.ID] = *attachment
+	return nil
+}
+
+func (m *MockDB) DeleteAttachment(bookID, attachmentID string) error {
+	m.shared.lock.Lock()
+	defer m.shared.lock.Unlock()
+
+	attachment, exists := m.attachments[attachmentID]
+	if !exists || attachment.BookID != bookID {
+		return lib.NoMatchingAttachment
+	}
+	delete(m.attachments, attachmentID)
+	return nil
+}
+
+func (m *MockDB) IsBlobReferenced(hash string) (bool, error) {
+	m.shared.lock.RLock()
+	defer m.shared.lock.RUnlock()
+
+	for _, attachment := range m.attachments {
+		if attachment.SHA256 == hash || attachment.Thumbnail == hash {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,212 @@
+package db
+
+import (
+	"dockerrestapi/lib"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+)
+
+func (m *MockDB) GetAllAuthors() ([]lib.Author, error) {
+	m.shared.lock.RLock()
+	defer m.shared.lock.RUnlock()
+
+	results := []lib.Author{}
+	for _, author := range m.authors {
+		results = append(results, author)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+	return results, nil
+}
+
+func (m *MockDB) GetOneAuthor(id string) (*lib.Author, error) {
+	m.shared.lock.RLock()
+	defer m.shared.lock.RUnlock()
+
+	author, exists := m.authors[id]
+	if !exists {
+		return nil, lib.NoMatchingAuthor
+	}
+	return &author, nil
+}
+
+func (m *MockDB) FindAuthor(name string) (*lib.Author, error) {
+	m.shared.lock.RLock()
+	defer m.shared.lock.RUnlock()
+
+	author, exists := m.findAuthor(name)
+	if !exists {
+		return nil, lib.NoMatchingAuthor
+	}
+	return &author, nil
+}
+
+// CreateNewAuthor stores a new author and links the books written under its names
+func (m *MockDB) CreateNewAuthor(author *lib.Author) error {
+	err := author.Normalise()
+	if err != nil {
+		return err
+	}
+
+	m.shared.lock.Lock()
+	defer m.shared.lock.Unlock()
+
+	if m.isAuthorNameTaken(author, "") {
+		return lib.AuthorAlreadyExists
+	}
+	author.ID = primitive.NewObjectID().Hex()
+	author.CreatedAt = m.shared.clock.Now()
+	author.UpdatedAt = author.CreatedAt
+	err = m.linkBooks(*author)
+	if err != nil {
+		return err
+	}
+	m.authors[author.ID] = *author
+	return nil
+}
+
+// UpdateExistingAuthor updates an author given its id, renaming its books and linking the books written under its new names
+func (m *MockDB) UpdateExistingAuthor(author *lib.Author) error {
+	err := author.Normalise()
+	if err != nil {
+		return err
+	}
+
+	m.shared.lock.Lock()
+	defer m.shared.lock.Unlock()
+
+	existing, exists := m.authors[author.ID]
+	if !exists {
+		return lib.NoMatchingAuthor
+	}
+	if m.isAuthorNameTaken(author, author.ID) {
+		return lib.AuthorAlreadyExists
+	}
+	author.CreatedAt = existing.CreatedAt
+	author.UpdatedAt = m.shared.clock.Now()
+	err = m.linkBooks(*author)
+	if err != nil {
+		return err
+	}
+	m.authors[author.ID] = *author
+	return nil
+}
+
+// DeleteAuthor deletes an author no book is linked to, books in the trash included
+func (m *MockDB) DeleteAuthor(id string) error {
+	m.shared.lock.Lock()
+	defer m.shared.lock.Unlock()
+
+	if _, exists := m.authors[id]; !exists {
+		return lib.NoMatchingAuthor
+	}
+	for _, book := range m.db {
+		if book.AuthorID == id {
+			return lib.AuthorHasBooks
+		}
+	}
+	delete(m.authors, id)
+	return nil
+}
+
+// MergeAuthors deletes the source author, adding its names to the aliases of the target and re-pointing its books to the target
+func (m *MockDB) MergeAuthors(sourceID, targetID string) (*lib.Author, error) {
+	if sourceID == targetID {
+		return nil, lib.CannotMergeAuthor
+	}
+
+	m.shared.lock.Lock()
+	defer m.shared.lock.Unlock()
+
+	source, exists := m.authors[sourceID]
+	if !exists {
+		return nil, lib.NoMatchingAuthor
+	}
+	target, exists := m.authors[targetID]
+	if !exists {
+		return nil, lib.NoMatchingAuthor
+	}
+	target.Merge(&source)
+	target.UpdatedAt = m.shared.clock.Now()
+	err := m.linkBooks(target, sourceID)
+	if err != nil {
+		return nil, err
+	}
+	delete(m.authors, sourceID)
+	m.authors[targetID] = target
+	return &target, nil
+}
+
+// linkAuthor stores a book under the canonical name of its author when known, linking it by id. Caller must hold the lock.
+func (m *MockDB) linkAuthor(book *lib.Book) {
+	book.AuthorID = ""
+	if author, exists := m.findAuthor(book.Author); exists {
+		book.Author = author.Name
+		book.AuthorID = author.ID
+	}
+}
+
+// canonicalAuthor returns the canonical name of a known author, otherwise the name itself. Caller must hold the lock.
+func (m *MockDB) canonicalAuthor(name string) string {
+	if author, exists := m.findAuthor(name); exists {
+		return author.Name
+	}
+	return name
+}
+
+// findAuthor finds an author by canonical name or alias. Caller must hold the lock.
+func (m *MockDB) findAuthor(name string) (lib.Author, bool) {
+	for _, author := range m.authors {
+		if author.HasName(name) {
+			return author, true
+		}
+	}
+	return lib.Author{}, false
+}
+
+// isAuthorNameTaken checks whether another author than the one of the given id has one of the names of the author. Caller must hold the lock.
+func (m *MockDB) isAuthorNameTaken(author *lib.Author, id string) bool {
+	for _, name := range author.Names() {
+		if other, exists := m.findAuthor(name); exists && other.ID != id {
+			return true
+		}
+	}
+	return false
+}
+
+// linkBooks points every book linked to the author or one of the given previous authors, or written under one of its names, to the author.
+// Nothing changes when two books not in the trash would then share a name. Caller must hold the lock.
+func (m *MockDB) linkBooks(author lib.Author, previousAuthorIDs ...string) error {
+	linked := map[string]bool{author.ID: true}
+	for _, id := range previousAuthorIDs {
+		linked[id] = true
+	}
+
+	var books []lib.Book
+	names := map[string]bool{}
+	for _, book := range m.db {
+		if !linked[book.AuthorID] && !author.HasName(book.Author) {
+			continue
+		}
+		if book.DeletedAt == nil {
+			if names[lib.FoldName(book.Name)] {
+				return lib.BookAlreadyExists
+			}
+			names[lib.FoldName(book.Name)] = true
+		}
+		books = append(books, book)
+	}
+
+	for _, book := range books {
+		if book.Author == author.Name && book.AuthorID == author.ID {
+			continue
+		}
+		book.Author = author.Name
+		book.AuthorID = author.ID
+		book.UpdatedAt = m.shared.clock.Now()
+		m.db[book.ID] = book
+		if book.DeletedAt == nil {
+			m.publishChange(lib.ChangeUpdated, book)
+		}
+	}
+	return nil
+}
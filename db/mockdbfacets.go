@@ -0,0 +1,17 @@
+package db
+
+import (
+	"dockerrestapi/lib"
+)
+
+// facetFields are the fields the facets of books are counted from
+var facetFields = []string{lib.JsonBsonTagAuthor, lib.JsonBsonTagTags, lib.JsonBsonTagLanguage, lib.JsonBsonTagUpdatedAt}
+
+// GetFacets counts the books matching the filter per author, tag, language and update year, up to limit values per facet
+func (m *MockDB) GetFacets(filter lib.BookFilter, limit int) (*lib.Facets, error) {
+	books, err := m.GetAllBooksWithFields(filter, facetFields)
+	if err != nil {
+		return nil, err
+	}
+	return lib.CountFacets(books, limit), nil
+}
@@ -2,98 +2,376 @@ package db
 
 import (
 	"dockerrestapi/lib"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
 	"log"
+	"strings"
+	"sync"
 	"time"
 )
 
 type MockDB struct {
-	db map[lib.BookIdentifier]lib.Book
+	name   string
+	shared *mockShared
+	db     map[string]lib.Book // books keyed by id
+	files  map[string][][]byte // chunked contents keyed by file id
+
+	revisions   map[string][]lib.Revision      // revisions of the contents keyed by book id, oldest first
+	attachments map[string]lib.Attachment      // attachments keyed by id
+	authors     map[string]lib.Author          // authors keyed by id
+	reviews     map[string]lib.Review          // reviews keyed by id
+	shelves     map[string]lib.Shelf           // shelves keyed by id
+	progress    map[string]lib.ReadingProgress // reading progress keyed by book and user
+	bookmarks   map[string]lib.Bookmark        // bookmarks keyed by id
+	changes     *changeBroker
+}
+
+// mockShared is the state shared by every library of one mock deployment.
+type mockShared struct {
+	lock      sync.RWMutex
+	clock     lib.Clock
+	libraries map[string]*MockDB
+	created   map[string]time.Time
 }
 
 func CreateMockDBHandler() (RestDbInterface, error) {
+	return CreateMockDBHandlerWithClock(lib.SystemClock{})
+}
+
+// CreateMockDBHandlerWithClock returns a mock db taking every timestamp from the given clock
+func CreateMockDBHandlerWithClock(clock lib.Clock) (RestDbInterface, error) {
 	log.Println("Connected to MockDB!")
-	return &MockDB{
-		db: map[lib.BookIdentifier]lib.Book{},
-	}, nil
+	shared := &mockShared{
+		clock:     clock,
+		libraries: map[string]*MockDB{},
+		created:   map[string]time.Time{},
+	}
+	defaultLibrary := shared.newLibrary(lib.DefaultLibraryName)
+	return defaultLibrary, nil
+}
+
+func (s *mockShared) newLibrary(name string) *MockDB {
+	library := &MockDB{
+		name:   name,
+		shared: s,
+		db:     map[string]lib.Book{},
+		files:  map[string][][]byte{},
+
+		revisions:   map[string][]lib.Revision{},
+		attachments: map[string]lib.Attachment{},
+		authors:     map[string]lib.Author{},
+		reviews:     map[string]lib.Review{},
+		shelves:     map[string]lib.Shelf{},
+		progress:    map[string]lib.ReadingProgress{},
+		bookmarks:   map[string]lib.Bookmark{},
+		changes:     newChangeBroker(),
+	}
+	s.libraries[name] = library
+	s.created[name] = s.clock.Now()
+	return library
 }
 
 func (m *MockDB) Disconnect() {
 }
 
-func (m *MockDB) GetAllBooks() ([]lib.BookIdentifier, error) {
+func (m *MockDB) GetAllBooks(filter lib.BookFilter) ([]lib.BookIdentifier, error) {
+	m.shared.lock.RLock()
+	defer m.shared.lock.RUnlock()
 
 	var results []lib.BookIdentifier
-	for identifier, _ := range m.db {
-		results = append(results, identifier)
+	for _, book := range m.db {
+		if book.DeletedAt == nil && filter.Matches(&book) {
+			results = append(results, book.Identifier())
+		}
 	}
 	return results, nil
 }
 
-func (m *MockDB) GetOneBook(bookIdentifier *lib.BookIdentifier) (*lib.Book, error) {
-	for dbIdentifier, foundBook := range m.db {
-		if dbIdentifier.Name == bookIdentifier.Name && dbIdentifier.Author == bookIdentifier.Author {
-			return &foundBook, nil
+// EachBook visits the books matching the filter, outside of the lock so that visit may be slow or use the db
+func (m *MockDB) EachBook(filter lib.BookFilter, visit func(lib.BookIdentifier) error) error {
+	books, err := m.GetAllBooks(filter)
+	if err != nil {
+		return err
+	}
+	for _, book := range books {
+		err = visit(book)
+		if err != nil {
+			return err
 		}
 	}
-	return nil, lib.NoMatchingBook
+	return nil
 }
 
-func (m *MockDB) CreateNewBook(book *lib.Book) error {
-	inDb, err := m.isBookInDb(lib.BookIdentifier{
-		Name:   book.Name,
-		Author: book.Author,
-	})
-	if err != nil {
-		return err
+func (m *MockDB) GetAllBooksWithFields(filter lib.BookFilter, fields []string) ([]lib.Book, error) {
+	m.shared.lock.RLock()
+	defer m.shared.lock.RUnlock()
+
+	var results []lib.Book
+	for _, book := range m.db {
+		if book.DeletedAt == nil && filter.Matches(&book) {
+			results = append(results, book.Project(fields))
+		}
 	}
-	if inDb {
+	return results, nil
+}
+
+func (m *MockDB) GetOneBookWithFields(bookIdentifier *lib.BookIdentifier, fields []string) (*lib.Book, error) {
+	m.shared.lock.RLock()
+	defer m.shared.lock.RUnlock()
+
+	foundBook, exists := m.findBook(*bookIdentifier)
+	if !exists {
+		return nil, lib.NoMatchingBook
+	}
+	projected := foundBook.Project(fields)
+	return &projected, nil
+}
+
+func (m *MockDB) GetOneBook(bookIdentifier *lib.BookIdentifier) (*lib.Book, error) {
+	m.shared.lock.RLock()
+	defer m.shared.lock.RUnlock()
+
+	foundBook, exists := m.findBook(*bookIdentifier)
+	if !exists {
+		return nil, lib.NoMatchingBook
+	}
+	return &foundBook, nil
+}
+
+func (m *MockDB) CreateNewBook(book *lib.Book) error {
+	m.shared.lock.Lock()
+	defer m.shared.lock.Unlock()
+
+	m.linkAuthor(book)
+	if _, inDb := m.findBook(lib.BookIdentifier{Name: book.Name, Author: book.Author}); inDb {
 		return lib.BookAlreadyExists
 	}
 
-	book.UpdatedDate = time.Now().Format(lib.DbTimeFormat)
-	m.db[lib.BookIdentifier{
-		Name:   book.Name,
-		Author: book.Author,
-	}] = *book
+	book.ID = primitive.NewObjectID().Hex()
+	book.Rating = nil
+	book.CreatedAt = m.shared.clock.Now()
+	book.UpdatedAt = book.CreatedAt
+	err := m.storeContents(book, strings.NewReader(book.Contents))
+	if err != nil {
+		return err
+	}
+	m.recordRevision(book)
+	m.db[book.ID] = *book
+	m.publishChange(lib.ChangeCreated, *book)
 
 	return nil
 }
 
 func (m *MockDB) UpdateExistingBook(book *lib.Book) error {
-	inDb, err := m.isBookInDb(lib.BookIdentifier{
-		Name:   book.Name,
-		Author: book.Author,
-	})
+	m.shared.lock.Lock()
+	defer m.shared.lock.Unlock()
+
+	m.linkAuthor(book)
+	existing, inDb := m.findBook(book.Identifier())
+	if !inDb {
+		return lib.NoMatchingBook
+	}
+	if sameName, clash := m.findBook(lib.BookIdentifier{Name: book.Name, Author: book.Author}); clash && sameName.ID != existing.ID {
+		return lib.BookAlreadyExists
+	}
+
+	book.ID = existing.ID
+	book.Rating = existing.Rating
+	book.DeletedAt = existing.DeletedAt
+	book.CreatedAt = existing.CreatedAt
+	book.UpdatedAt = m.shared.clock.Now()
+	err := m.storeContents(book, strings.NewReader(book.Contents))
 	if err != nil {
 		return err
 	}
+	m.recordRevision(book)
+	m.db[book.ID] = *book
+	m.reanchorBookmarks(*book)
+	m.publishChange(lib.ChangeUpdated, *book)
+
+	return nil
+}
+
+// DeleteBook moves a book to the trash, it keeps its contents, attachments and shelves until purged
+func (m *MockDB) DeleteBook(bookIdentifier *lib.BookIdentifier) error {
+	m.shared.lock.Lock()
+	defer m.shared.lock.Unlock()
+
+	existing, inDb := m.findBook(*bookIdentifier)
 	if !inDb {
 		return lib.NoMatchingBook
 	}
 
-	m.db[lib.BookIdentifier{
-		Name:   book.Name,
-		Author: book.Author,
-	}] = *book
+	deletedAt := m.shared.clock.Now()
+	existing.DeletedAt = &deletedAt
+	m.db[existing.ID] = existing
+	m.publishChange(lib.ChangeDeleted, existing)
 
 	return nil
 }
 
-func (m *MockDB) DeleteBook(bookIdentifier *lib.BookIdentifier) error {
-	inDb, err := m.isBookInDb(*bookIdentifier)
+// GetBookContents streams the contents of a book, whether inline or chunked
+func (m *MockDB) GetBookContents(bookIdentifier *lib.BookIdentifier) (ContentsReader, error) {
+	m.shared.lock.RLock()
+	defer m.shared.lock.RUnlock()
+
+	book, exists := m.findBook(*bookIdentifier)
+	if !exists {
+		return nil, lib.NoMatchingBook
+	}
+	if book.ContentsFile == "" {
+		return newInlineContents(book.Contents), nil
+	}
+	return &chunkedContents{
+		chunks: m.files[book.ContentsFile],
+		size:   book.ContentsLength,
+	}, nil
+}
+
+// StoreBookContents replaces the contents of a book from a stream
+func (m *MockDB) StoreBookContents(bookIdentifier *lib.BookIdentifier, contents io.Reader) error {
+	inline, chunks, length, err := splitContents(contents) // read before locking, uploads can be slow
 	if err != nil {
-		return lib.NoMatchingBook
+		return err
 	}
-	if !inDb {
+
+	m.shared.lock.Lock()
+	defer m.shared.lock.Unlock()
+
+	book, exists := m.findBook(*bookIdentifier)
+	if !exists {
 		return lib.NoMatchingBook
 	}
 
-	delete(m.db, *bookIdentifier)
+	analyser, err := newContentsAnalyser(&book)
+	if err != nil {
+		return err
+	}
+	m.setContents(&book, inline, chunks, length)
+	_, _ = io.WriteString(analyser, m.bookText(book))
+	analyser.apply(&book)
+	book.UpdatedAt = m.shared.clock.Now()
+	m.recordRevision(&book)
+	m.db[book.ID] = book
+	m.reanchorBookmarks(book)
+	m.publishChange(lib.ChangeUpdated, book)
+	return nil
+}
+
+// WatchBooks streams the changes of this library from its in-memory broker
+func (m *MockDB) WatchBooks(resumeToken string) (ChangeStream, error) {
+	return m.changes.subscribe(resumeToken)
+}
+
+// Library returns the mock library of the given name
+func (m *MockDB) Library(name string) (RestDbInterface, error) {
+	m.shared.lock.RLock()
+	defer m.shared.lock.RUnlock()
+
+	library, exists := m.shared.libraries[name]
+	if !exists {
+		return nil, lib.NoMatchingLibrary
+	}
+	return library, nil
+}
+
+func (m *MockDB) GetAllLibraries() ([]lib.Library, error) {
+	m.shared.lock.RLock()
+	defer m.shared.lock.RUnlock()
+
+	var results []lib.Library
+	for name := range m.shared.libraries {
+		results = append(results, lib.Library{
+			Name:      name,
+			CreatedAt: m.shared.created[name],
+		})
+	}
+	return results, nil
+}
+
+func (m *MockDB) CreateLibrary(name string) error {
+	if !lib.IsValidLibraryName(name) {
+		return lib.IncorrectLibraryName
+	}
+
+	m.shared.lock.Lock()
+	defer m.shared.lock.Unlock()
+
+	if _, exists := m.shared.libraries[name]; exists {
+		return lib.LibraryAlreadyExists
+	}
+	m.shared.newLibrary(name)
+	return nil
+}
+
+func (m *MockDB) DropLibrary(name string) error {
+	if name == lib.DefaultLibraryName {
+		return lib.CannotDropDefaultLibrary
+	}
+
+	m.shared.lock.Lock()
+	defer m.shared.lock.Unlock()
+
+	library, exists := m.shared.libraries[name]
+	if !exists {
+		return lib.NoMatchingLibrary
+	}
+	delete(m.shared.libraries, name)
+	delete(m.shared.created, name)
+	library.changes.closeAll() // as Mongo invalidates the streams of a dropped collection
+	return nil
+}
+
+// publishChange publishes a change to a book to the streams of this library. Caller must hold the lock, publishing never blocks.
+func (m *MockDB) publishChange(changeType string, book lib.Book) {
+	m.changes.publish(lib.ChangeEvent{
+		Type:   changeType,
+		BookID: book.ID,
+		Name:   book.Name,
+		Author: book.Author,
+		Time:   m.shared.clock.Now(),
+	})
+}
 
+// storeContents stores contents inline in the book when under the threshold, otherwise in a chunked file. Caller must hold the lock.
+func (m *MockDB) storeContents(book *lib.Book, contents io.Reader) error {
+	analyser, err := newContentsAnalyser(book)
+	if err != nil {
+		return err
+	}
+	inline, chunks, length, err := splitContents(io.TeeReader(contents, analyser))
+	if err != nil {
+		return err
+	}
+	m.setContents(book, inline, chunks, length)
+	analyser.apply(book)
 	return nil
 }
 
-func (m *MockDB) isBookInDb(bookIdentifier lib.BookIdentifier) (bool, error) {
-	_, exists := m.db[bookIdentifier]
-	return exists, nil
+// setContents sets already split contents on the book. Caller must hold the lock.
+func (m *MockDB) setContents(book *lib.Book, inline string, chunks [][]byte, length int64) {
+	book.Contents = inline
+	book.ContentsFile = ""
+	book.ContentsLength = length
+	if chunks == nil {
+		return
+	}
+	book.ContentsFile = primitive.NewObjectID().Hex()
+	m.files[book.ContentsFile] = chunks
+}
+
+// findBook finds a book not in the trash by id, or by name and author ignoring case and accents when no id is given.
+// Caller must hold the lock.
+func (m *MockDB) findBook(bookIdentifier lib.BookIdentifier) (lib.Book, bool) {
+	if bookIdentifier.ID != "" {
+		book, exists := m.db[bookIdentifier.ID]
+		return book, exists && book.DeletedAt == nil
+	}
+	bookIdentifier.Author = m.canonicalAuthor(bookIdentifier.Author)
+	for _, book := range m.db {
+		if book.DeletedAt == nil && lib.SameName(book.Name, bookIdentifier.Name) && lib.SameName(book.Author, bookIdentifier.Author) {
+			return book, true
+		}
+	}
+	return lib.Book{}, false
 }
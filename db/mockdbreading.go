@@ -0,0 +1,212 @@
+package db
+
+import (
+	"dockerrestapi/lib"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+	"strings"
+	"time"
+)
+
+func (m *MockDB) GetReadingProgress(bookID, userID string) (*lib.ReadingProgress, error) {
+	m.shared.lock.RLock()
+	defer m.shared.lock.RUnlock()
+
+	if _, exists := m.findBook(lib.BookIdentifier{ID: bookID}); !exists {
+		return nil, lib.NoMatchingBook
+	}
+	progress, exists := m.progress[progressKey(bookID, userID)]
+	if !exists {
+		return nil, lib.NoMatchingProgress
+	}
+	return &progress, nil
+}
+
+// SaveReadingProgress locates the progress of a user in the contents and stores it, unless a more recent progress is stored
+func (m *MockDB) SaveReadingProgress(progress *lib.ReadingProgress) error {
+	if progress.UserID == "" {
+		return lib.IncompleteReading
+	}
+
+	m.shared.lock.Lock()
+	defer m.shared.lock.Unlock()
+
+	book, exists := m.findBook(lib.BookIdentifier{ID: progress.BookID})
+	if !exists {
+		return lib.NoMatchingBook
+	}
+	key := progressKey(progress.BookID, progress.UserID)
+	if stored, exists := m.progress[key]; exists && !stored.UpdatedAt.Before(progress.UpdatedAt) {
+		*progress = stored
+		return nil
+	}
+	err := progress.Locate(m.bookText(book))
+	if err != nil {
+		return err
+	}
+	m.progress[key] = *progress
+	return nil
+}
+
+func (m *MockDB) GetAllBookmarks(bookID, userID string, since *time.Time) ([]lib.Bookmark, error) {
+	m.shared.lock.RLock()
+	defer m.shared.lock.RUnlock()
+
+	if _, exists := m.findBook(lib.BookIdentifier{ID: bookID}); !exists {
+		return nil, lib.NoMatchingBook
+	}
+	results := []lib.Bookmark{}
+	for _, bookmark := range m.bookmarks {
+		if bookmark.BookID != bookID || bookmark.UserID != userID {
+			continue
+		}
+		if (since == nil && bookmark.DeletedAt == nil) || (since != nil && bookmark.UpdatedAt.After(*since)) {
+			results = append(results, bookmark)
+		}
+	}
+	sortBookmarks(results)
+	return results, nil
+}
+
+func (m *MockDB) GetOneBookmark(bookID, bookmarkID string) (*lib.Bookmark, error) {
+	m.shared.lock.RLock()
+	defer m.shared.lock.RUnlock()
+
+	return m.findBookmark(bookID, bookmarkID)
+}
+
+// CreateNewBookmark anchors a bookmark in the contents and stores it, returning the bookmark of the same client id when already created
+func (m *MockDB) CreateNewBookmark(bookmark *lib.Bookmark) error {
+	err := bookmark.Normalise()
+	if err != nil {
+		return err
+	}
+
+	m.shared.lock.Lock()
+	defer m.shared.lock.Unlock()
+
+	book, exists := m.findBook(lib.BookIdentifier{ID: bookmark.BookID})
+	if !exists {
+		return lib.NoMatchingBook
+	}
+	if bookmark.ClientID != "" {
+		for _, other := range m.bookmarks {
+			if other.BookID == bookmark.BookID && other.UserID == bookmark.UserID && other.ClientID == bookmark.ClientID {
+				*bookmark = other
+				return nil
+			}
+		}
+	}
+	bookmark.Anchor(m.bookText(book))
+	bookmark.ID = primitive.NewObjectID().Hex()
+	bookmark.CreatedAt = m.shared.clock.Now()
+	if bookmark.UpdatedAt.IsZero() {
+		bookmark.UpdatedAt = bookmark.CreatedAt
+	}
+	bookmark.DeletedAt = nil
+	m.bookmarks[bookmark.ID] = *bookmark
+	return nil
+}
+
+// UpdateExistingBookmark changes the range and note of a bookmark, unless changed more recently, deleted bookmarks coming back
+func (m *MockDB) UpdateExistingBookmark(bookmark *lib.Bookmark) error {
+	m.shared.lock.Lock()
+	defer m.shared.lock.Unlock()
+
+	existing, err := m.findBookmark(bookmark.BookID, bookmark.ID)
+	if err != nil {
+		return err
+	}
+	if !existing.UpdatedAt.Before(bookmark.UpdatedAt) {
+		*bookmark = *existing
+		return nil
+	}
+	updated := *existing
+	updated.Start = bookmark.Start
+	updated.End = bookmark.End
+	updated.Note = bookmark.Note
+	err = updated.Normalise()
+	if err != nil {
+		return err
+	}
+	updated.Anchor(m.bookText(m.db[updated.BookID]))
+	updated.UpdatedAt = bookmark.UpdatedAt
+	updated.DeletedAt = nil
+	m.bookmarks[updated.ID] = updated
+	*bookmark = updated
+	return nil
+}
+
+// DeleteBookmark replaces a bookmark with a tombstone, unless changed more recently
+func (m *MockDB) DeleteBookmark(bookID, bookmarkID string, deletedAt time.Time) (*lib.Bookmark, error) {
+	m.shared.lock.Lock()
+	defer m.shared.lock.Unlock()
+
+	bookmark, err := m.findBookmark(bookID, bookmarkID)
+	if err != nil {
+		return nil, err
+	}
+	if bookmark.DeletedAt != nil || !bookmark.UpdatedAt.Before(deletedAt) {
+		return bookmark, nil
+	}
+	bookmark.DeletedAt = &deletedAt
+	bookmark.UpdatedAt = deletedAt
+	m.bookmarks[bookmark.ID] = *bookmark
+	return bookmark, nil
+}
+
+// findBookmark finds a bookmark of a book not in the trash. Caller must hold the lock.
+func (m *MockDB) findBookmark(bookID, bookmarkID string) (*lib.Bookmark, error) {
+	if _, exists := m.findBook(lib.BookIdentifier{ID: bookID}); !exists {
+		return nil, lib.NoMatchingBook
+	}
+	bookmark, exists := m.bookmarks[bookmarkID]
+	if !exists || bookmark.BookID != bookID {
+		return nil, lib.NoMatchingBookmark
+	}
+	return &bookmark, nil
+}
+
+// reanchorBookmarks finds the place of the bookmarks of a book in its new contents. Caller must hold the lock.
+func (m *MockDB) reanchorBookmarks(book lib.Book) {
+	text := ""
+	now := m.shared.clock.Now()
+	for id, bookmark := range m.bookmarks {
+		if bookmark.BookID != book.ID || bookmark.DeletedAt != nil {
+			continue
+		}
+		if text == "" {
+			text = m.bookText(book)
+		}
+		bookmark.Reanchor(text)
+		bookmark.UpdatedAt = now
+		m.bookmarks[id] = bookmark
+	}
+}
+
+// bookText returns the whole contents of a book, whether inline or chunked. Caller must hold the lock.
+func (m *MockDB) bookText(book lib.Book) string {
+	if book.ContentsFile == "" {
+		return book.Contents
+	}
+	var text strings.Builder
+	for _, chunk := range m.files[book.ContentsFile] {
+		text.Write(chunk)
+	}
+	return text.String()
+}
+
+// progressKey returns the key of the reading progress of a user on a book
+func progressKey(bookID, userID string) string {
+	return bookID + "/" + userID
+}
+
+// sortBookmarks sorts bookmarks in reading order
+func sortBookmarks(bookmarks []lib.Bookmark) {
+	sort.Slice(bookmarks, func(i, j int) bool {
+		if bookmarks[i].Start != bookmarks[j].Start {
+			return bookmarks[i].Start < bookmarks[j].Start
+		}
+		return bookmarks[i].ID < bookmarks[j].ID
+	})
+}
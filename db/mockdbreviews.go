@@ -0,0 +1,180 @@
+package db
+
+import (
+	"dockerrestapi/lib"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+)
+
+func (m *MockDB) GetAllReviews(bookID string, query lib.ReviewQuery) (*lib.ReviewPage, error) {
+	m.shared.lock.RLock()
+	defer m.shared.lock.RUnlock()
+
+	if _, exists := m.findBook(lib.BookIdentifier{ID: bookID}); !exists {
+		return nil, lib.NoMatchingBook
+	}
+	var selected []lib.Review
+	for _, review := range m.reviews {
+		if review.BookID != bookID {
+			continue
+		}
+		if (query.Status == "" && review.IsRated()) || review.Status == query.Status {
+			selected = append(selected, review)
+		}
+	}
+	sortReviews(selected, query.Sort)
+
+	page := &lib.ReviewPage{Reviews: []lib.Review{}, Total: len(selected), Offset: query.Offset, Limit: query.Limit}
+	if query.Offset < len(selected) {
+		page.Reviews = append(page.Reviews, selected[query.Offset:min(query.Offset+query.Limit, len(selected))]...)
+	}
+	return page, nil
+}
+
+func (m *MockDB) GetOneReview(bookID, reviewID string) (*lib.Review, error) {
+	m.shared.lock.RLock()
+	defer m.shared.lock.RUnlock()
+
+	return m.findReview(bookID, reviewID)
+}
+
+// CreateNewReview stores the review of a user on a book and counts its stars in the rating of the book
+func (m *MockDB) CreateNewReview(review *lib.Review) error {
+	err := review.Normalise()
+	if err != nil {
+		return err
+	}
+
+	m.shared.lock.Lock()
+	defer m.shared.lock.Unlock()
+
+	if _, exists := m.findBook(lib.BookIdentifier{ID: review.BookID}); !exists {
+		return lib.NoMatchingBook
+	}
+	for _, other := range m.reviews {
+		if other.BookID == review.BookID && other.UserID == review.UserID {
+			return lib.ReviewAlreadyExists
+		}
+	}
+	review.ID = primitive.NewObjectID().Hex()
+	review.Status = lib.ReviewPending
+	review.Helpful = 0
+	review.Voters = nil
+	review.CreatedAt = m.shared.clock.Now()
+	review.UpdatedAt = review.CreatedAt
+	m.reviews[review.ID] = *review
+	m.changeRating(review.BookID, lib.RatingChange(nil, review))
+	return nil
+}
+
+// UpdateExistingReview changes the rating, title and text of a review, which goes back to pending moderation
+func (m *MockDB) UpdateExistingReview(review *lib.Review) error {
+	m.shared.lock.Lock()
+	defer m.shared.lock.Unlock()
+
+	existing, err := m.findReview(review.BookID, review.ID)
+	if err != nil {
+		return err
+	}
+	updated := *existing
+	updated.Stars = review.Stars
+	updated.Title = review.Title
+	updated.Text = review.Text
+	err = updated.Normalise()
+	if err != nil {
+		return err
+	}
+	updated.Status = lib.ReviewPending
+	updated.UpdatedAt = m.shared.clock.Now()
+	m.reviews[updated.ID] = updated
+	m.changeRating(updated.BookID, lib.RatingChange(existing, &updated))
+	*review = updated
+	return nil
+}
+
+// ModerateReview sets the moderation status of a review, rejected reviews leaving the rating of the book
+func (m *MockDB) ModerateReview(bookID, reviewID, status string) (*lib.Review, error) {
+	if !lib.IsValidReviewStatus(status) {
+		return nil, lib.IncorrectReviewStatus
+	}
+
+	m.shared.lock.Lock()
+	defer m.shared.lock.Unlock()
+
+	existing, err := m.findReview(bookID, reviewID)
+	if err != nil {
+		return nil, err
+	}
+	moderated := *existing
+	moderated.Status = status
+	m.reviews[moderated.ID] = moderated
+	m.changeRating(bookID, lib.RatingChange(existing, &moderated))
+	return &moderated, nil
+}
+
+// VoteReviewHelpful counts a user finding a review helpful, once however often they vote
+func (m *MockDB) VoteReviewHelpful(bookID, reviewID, userID string) (*lib.Review, error) {
+	m.shared.lock.Lock()
+	defer m.shared.lock.Unlock()
+
+	review, err := m.findReview(bookID, reviewID)
+	if err != nil {
+		return nil, err
+	}
+	if !review.HasVoted(userID) {
+		review.Voters = append(append([]string{}, review.Voters...), userID)
+		review.Helpful = len(review.Voters)
+		m.reviews[review.ID] = *review
+	}
+	return review, nil
+}
+
+// DeleteReview deletes a review and takes its stars out of the rating of the book
+func (m *MockDB) DeleteReview(bookID, reviewID string) error {
+	m.shared.lock.Lock()
+	defer m.shared.lock.Unlock()
+
+	existing, err := m.findReview(bookID, reviewID)
+	if err != nil {
+		return err
+	}
+	delete(m.reviews, reviewID)
+	m.changeRating(bookID, lib.RatingChange(existing, nil))
+	return nil
+}
+
+// findReview finds a review of a book not in the trash. Caller must hold the lock.
+func (m *MockDB) findReview(bookID, reviewID string) (*lib.Review, error) {
+	if _, exists := m.findBook(lib.BookIdentifier{ID: bookID}); !exists {
+		return nil, lib.NoMatchingBook
+	}
+	review, exists := m.reviews[reviewID]
+	if !exists || review.BookID != bookID {
+		return nil, lib.NoMatchingReview
+	}
+	return &review, nil
+}
+
+// changeRating changes the number of reviews per stars in the rating of a book. Caller must hold the lock.
+func (m *MockDB) changeRating(bookID string, change map[int]int) {
+	book, exists := m.db[bookID]
+	if !exists || len(change) == 0 {
+		return
+	}
+	book.Rating = book.Rating.Changed(change)
+	m.db[bookID] = book
+	m.publishChange(lib.ChangeUpdated, book)
+}
+
+// sortReviews sorts reviews most recent first, or most helpful first then most recent
+func sortReviews(reviews []lib.Review, order string) {
+	sort.Slice(reviews, func(i, j int) bool {
+		if order == lib.ReviewSortHelpful && reviews[i].Helpful != reviews[j].Helpful {
+			return reviews[i].Helpful > reviews[j].Helpful
+		}
+		if !reviews[i].CreatedAt.Equal(reviews[j].CreatedAt) {
+			return reviews[i].CreatedAt.After(reviews[j].CreatedAt)
+		}
+		return reviews[i].ID > reviews[j].ID
+	})
+}
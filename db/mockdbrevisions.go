@@ -0,0 +1,100 @@
+package db
+
+import (
+	"dockerrestapi/lib"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// GetAllRevisions lists the revisions of the contents of a book, oldest first
+func (m *MockDB) GetAllRevisions(bookID string) ([]lib.Revision, error) {
+	m.shared.lock.RLock()
+	defer m.shared.lock.RUnlock()
+
+	if _, exists := m.findBook(lib.BookIdentifier{ID: bookID}); !exists {
+		return nil, lib.NoMatchingBook
+	}
+	return append([]lib.Revision{}, m.revisions[bookID]...), nil
+}
+
+// GetRevisionContents streams the contents of a revision of a book, whether inline or chunked
+func (m *MockDB) GetRevisionContents(bookID string, number int) (ContentsReader, error) {
+	m.shared.lock.RLock()
+	defer m.shared.lock.RUnlock()
+
+	if _, exists := m.findBook(lib.BookIdentifier{ID: bookID}); !exists {
+		return nil, lib.NoMatchingBook
+	}
+	for _, revision := range m.revisions[bookID] {
+		if revision.Number != number {
+			continue
+		}
+		if revision.ContentsFile == "" {
+			return newInlineContents(revision.Contents), nil
+		}
+		return &chunkedContents{
+			chunks: m.files[revision.ContentsFile],
+			size:   revision.ContentsLength,
+		}, nil
+	}
+	return nil, lib.NoMatchingRevision
+}
+
+// PurgeRevisions removes the revisions created before the cutoff but the latest of each book, with their contents
+func (m *MockDB) PurgeRevisions(before time.Time) (int, error) {
+	m.shared.lock.Lock()
+	defer m.shared.lock.Unlock()
+
+	purged := 0
+	for bookID, revisions := range m.revisions {
+		latest := revisions[len(revisions)-1]
+		var kept []lib.Revision
+		for _, revision := range revisions {
+			if revision.Number == latest.Number || !revision.CreatedAt.Before(before) {
+				kept = append(kept, revision)
+				continue
+			}
+			if revision.ContentsFile != latest.ContentsFile {
+				delete(m.files, revision.ContentsFile)
+			}
+			purged++
+		}
+		m.revisions[bookID] = kept
+	}
+	return purged, nil
+}
+
+// recordRevision keeps the contents just stored on a book as its next revision, unless they are the contents of its
+// latest revision, which the book then shares in place of a copy. Caller must hold the lock.
+func (m *MockDB) recordRevision(book *lib.Book) {
+	revisions := m.revisions[book.ID]
+	number := 1
+	if last := len(revisions) - 1; last >= 0 {
+		if revisions[last].ContentsHash == book.ContentsHash {
+			if book.ContentsFile != revisions[last].ContentsFile {
+				delete(m.files, book.ContentsFile)
+				book.ContentsFile = revisions[last].ContentsFile
+			}
+			return
+		}
+		number = revisions[last].Number + 1
+	}
+	m.revisions[book.ID] = append(revisions, lib.Revision{
+		ID:             primitive.NewObjectID().Hex(),
+		BookID:         book.ID,
+		Number:         number,
+		CreatedAt:      book.UpdatedAt,
+		ContentsHash:   book.ContentsHash,
+		ContentsLength: book.ContentsLength,
+		Contents:       book.Contents,
+		ContentsFile:   book.ContentsFile,
+	})
+}
+
+// deleteRevisions removes the revisions of a book with their contents. Caller must hold the lock.
+func (m *MockDB) deleteRevisions(bookID string) {
+	for _, revision := range m.revisions[bookID] {
+		delete(m.files, revision.ContentsFile)
+	}
+	delete(m.revisions, bookID)
+}
@@ -0,0 +1,185 @@
+package db
+
+import (
+	"dockerrestapi/lib"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+)
+
+func (m *MockDB) GetAllShelves(filter lib.ShelfFilter) ([]lib.Shelf, error) {
+	m.shared.lock.RLock()
+	defer m.shared.lock.RUnlock()
+
+	results := []lib.Shelf{}
+	for _, shelf := range m.shelves {
+		if filter.Matches(shelf) {
+			results = append(results, m.withoutTrashedBooks(shelf))
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].ID < results[j].ID })
+	return results, nil
+}
+
+func (m *MockDB) GetOneShelf(id string) (*lib.Shelf, error) {
+	m.shared.lock.RLock()
+	defer m.shared.lock.RUnlock()
+
+	shelf, exists := m.shelves[id]
+	if !exists {
+		return nil, lib.NoMatchingShelf
+	}
+	shelf = m.withoutTrashedBooks(shelf)
+	return &shelf, nil
+}
+
+// CreateNewShelf stores a new shelf, its books must be in the library
+func (m *MockDB) CreateNewShelf(shelf *lib.Shelf) error {
+	err := shelf.Normalise()
+	if err != nil {
+		return err
+	}
+
+	m.shared.lock.Lock()
+	defer m.shared.lock.Unlock()
+
+	books := shelf.BookIDs
+	shelf.BookIDs = []string{}
+	shelf.AddBooks(books, -1)
+	err = m.checkBooksExist(shelf.BookIDs)
+	if err != nil {
+		return err
+	}
+	shelf.ID = primitive.NewObjectID().Hex()
+	shelf.Version = 0
+	shelf.CreatedAt = m.shared.clock.Now()
+	shelf.UpdatedAt = shelf.CreatedAt
+	m.shelves[shelf.ID] = *shelf
+	return nil
+}
+
+// UpdateExistingShelf changes the name, description and visibility of a shelf, leaving its owner and books
+func (m *MockDB) UpdateExistingShelf(shelf *lib.Shelf) error {
+	m.shared.lock.Lock()
+	defer m.shared.lock.Unlock()
+
+	existing, exists := m.shelves[shelf.ID]
+	if !exists {
+		return lib.NoMatchingShelf
+	}
+	shelf.OwnerID = existing.OwnerID
+	shelf.BookIDs = existing.BookIDs
+	err := shelf.Normalise()
+	if err != nil {
+		return err
+	}
+	shelf.Version = existing.Version
+	shelf.CreatedAt = existing.CreatedAt
+	shelf.UpdatedAt = m.shared.clock.Now()
+	m.shelves[shelf.ID] = *shelf
+	*shelf = m.withoutTrashedBooks(*shelf)
+	return nil
+}
+
+func (m *MockDB) DeleteShelf(id string) error {
+	m.shared.lock.Lock()
+	defer m.shared.lock.Unlock()
+
+	if _, exists := m.shelves[id]; !exists {
+		return lib.NoMatchingShelf
+	}
+	delete(m.shelves, id)
+	return nil
+}
+
+// AddShelfBooks inserts books at a position of a shelf, every book must be in the library
+func (m *MockDB) AddShelfBooks(id string, bookIDs []string, position int) (*lib.Shelf, error) {
+	m.shared.lock.Lock()
+	defer m.shared.lock.Unlock()
+
+	err := m.checkBooksExist(bookIDs)
+	if err != nil {
+		return nil, err
+	}
+	return m.changeShelfBooks(id, func(shelf *lib.Shelf) error {
+		shelf.AddBooks(bookIDs, position)
+		return nil
+	})
+}
+
+// RemoveShelfBooks takes books off a shelf
+func (m *MockDB) RemoveShelfBooks(id string, bookIDs []string) (*lib.Shelf, error) {
+	m.shared.lock.Lock()
+	defer m.shared.lock.Unlock()
+
+	return m.changeShelfBooks(id, func(shelf *lib.Shelf) error {
+		shelf.RemoveBooks(bookIDs)
+		return nil
+	})
+}
+
+// ReorderShelfBooks puts the books of a shelf in the given order, books in the trash going last
+func (m *MockDB) ReorderShelfBooks(id string, bookIDs []string) (*lib.Shelf, error) {
+	m.shared.lock.Lock()
+	defer m.shared.lock.Unlock()
+
+	return m.changeShelfBooks(id, func(shelf *lib.Shelf) error {
+		return shelf.Reorder(append(append([]string{}, bookIDs...), m.trashedBooks(shelf.BookIDs)...))
+	})
+}
+
+// changeShelfBooks applies a change to the books of a shelf. Caller must hold the lock.
+func (m *MockDB) changeShelfBooks(id string, change func(shelf *lib.Shelf) error) (*lib.Shelf, error) {
+	shelf, exists := m.shelves[id]
+	if !exists {
+		return nil, lib.NoMatchingShelf
+	}
+	err := change(&shelf)
+	if err != nil {
+		return nil, err
+	}
+	shelf.Version++
+	shelf.UpdatedAt = m.shared.clock.Now()
+	m.shelves[id] = shelf
+	shelf = m.withoutTrashedBooks(shelf)
+	return &shelf, nil
+}
+
+// checkBooksExist checks every book is in the library and not in the trash. Caller must hold the lock.
+func (m *MockDB) checkBooksExist(bookIDs []string) error {
+	for _, bookID := range bookIDs {
+		if _, exists := m.findBook(lib.BookIdentifier{ID: bookID}); !exists {
+			return lib.NoMatchingBook
+		}
+	}
+	return nil
+}
+
+// withoutTrashedBooks returns a shelf without its books in the trash, which stay on the shelf until purged. Caller must hold the lock.
+func (m *MockDB) withoutTrashedBooks(shelf lib.Shelf) lib.Shelf {
+	if trashed := m.trashedBooks(shelf.BookIDs); len(trashed) > 0 {
+		shelf.RemoveBooks(trashed)
+	}
+	return shelf
+}
+
+// trashedBooks returns the books in the trash among the given ones. Caller must hold the lock.
+func (m *MockDB) trashedBooks(bookIDs []string) []string {
+	var trashed []string
+	for _, bookID := range bookIDs {
+		if book, exists := m.db[bookID]; exists && book.DeletedAt != nil {
+			trashed = append(trashed, bookID)
+		}
+	}
+	return trashed
+}
+
+// unshelveBook takes a purged book off every shelf. Caller must hold the lock.
+func (m *MockDB) unshelveBook(bookID string) {
+	for id, shelf := range m.shelves {
+		if shelf.HasBook(bookID) {
+			shelf.RemoveBooks([]string{bookID})
+			shelf.Version++
+			m.shelves[id] = shelf
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"dockerrestapi/lib"
+)
+
+// GetDeletedBooks lists the books in the trash, with the time they were deleted
+func (m *MockDB) GetDeletedBooks() ([]lib.BookIdentifier, error) {
+	m.shared.lock.RLock()
+	defer m.shared.lock.RUnlock()
+
+	var results []lib.BookIdentifier
+	for _, book := range m.db {
+		if book.DeletedAt != nil {
+			results = append(results, book.Identifier())
+		}
+	}
+	return results, nil
+}
+
+// RestoreBook moves a book out of the trash, unless a book of the same name and author was created since
+func (m *MockDB) RestoreBook(bookIdentifier *lib.BookIdentifier) error {
+	m.shared.lock.Lock()
+	defer m.shared.lock.Unlock()
+
+	deleted, exists := m.findDeletedBook(*bookIdentifier)
+	if !exists {
+		return lib.NoMatchingDeletedBook
+	}
+	if _, clash := m.findBook(lib.BookIdentifier{Name: deleted.Name, Author: deleted.Author}); clash {
+		return lib.BookAlreadyExists
+	}
+	deleted.DeletedAt = nil
+	m.db[deleted.ID] = deleted
+	m.publishChange(lib.ChangeRestored, deleted)
+	return nil
+}
+
+// PurgeBook permanently removes a book in the trash with its contents, revisions, attachments, reviews and reading state,
+// taking it off every shelf and returning the removed attachments
+func (m *MockDB) PurgeBook(bookIdentifier *lib.BookIdentifier) ([]lib.Attachment, error) {
+	m.shared.lock.Lock()
+	defer m.shared.lock.Unlock()
+
+	deleted, exists := m.findDeletedBook(*bookIdentifier)
+	if !exists {
+		return nil, lib.NoMatchingDeletedBook
+	}
+	var removed []lib.Attachment
+	for id, attachment := range m.attachments {
+		if attachment.BookID == deleted.ID {
+			removed = append(removed, attachment)
+			delete(m.attachments, id)
+		}
+	}
+	for id, review := range m.reviews {
+		if review.BookID == deleted.ID {
+			delete(m.reviews, id)
+		}
+	}
+	for key, progress := range m.progress {
+		if progress.BookID == deleted.ID {
+			delete(m.progress, key)
+		}
+	}
+	for id, bookmark := range m.bookmarks {
+		if bookmark.BookID == deleted.ID {
+			delete(m.bookmarks, id)
+		}
+	}
+	m.unshelveBook(deleted.ID)
+	delete(m.db, deleted.ID)
+	delete(m.files, deleted.ContentsFile)
+	m.deleteRevisions(deleted.ID)
+	m.publishChange(lib.ChangePurged, deleted)
+	return removed, nil
+}
+
+// findDeletedBook finds a book in the trash by id, or by name and author ignoring case and accents when no id is given,
+// the most recently deleted one when several match. Caller must hold the lock.
+func (m *MockDB) findDeletedBook(bookIdentifier lib.BookIdentifier) (lib.Book, bool) {
+	if bookIdentifier.ID != "" {
+		book, exists := m.db[bookIdentifier.ID]
+		return book, exists && book.DeletedAt != nil
+	}
+	bookIdentifier.Author = m.canonicalAuthor(bookIdentifier.Author)
+	var found lib.Book
+	for _, book := range m.db {
+		if book.DeletedAt == nil || !lib.SameName(book.Name, bookIdentifier.Name) || !lib.SameName(book.Author, bookIdentifier.Author) {
+			continue
+		}
+		if found.DeletedAt == nil || book.DeletedAt.After(*found.DeletedAt) {
+			found = book
+		}
+	}
+	return found, found.DeletedAt != nil
+}
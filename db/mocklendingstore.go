@@ -0,0 +1,401 @@
+package db
+
+import (
+	"dockerrestapi/lib"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+type MockLendingStore struct {
+	lock    sync.RWMutex
+	patrons map[string]lib.Patron     // patrons keyed by id
+	loans   map[string]lib.Loan       // loans keyed by id
+	copies  map[string]lib.BookCopies // copies keyed by library and book id, see copiesKey
+	holds   map[string]lib.Hold       // holds keyed by id
+}
+
+func CreateMockLendingStore() (LendingStoreInterface, error) {
+	log.Println("Connected to MockLendingStore!")
+	return &MockLendingStore{
+		patrons: map[string]lib.Patron{},
+		loans:   map[string]lib.Loan{},
+		copies:  map[string]lib.BookCopies{},
+		holds:   map[string]lib.Hold{},
+	}, nil
+}
+
+func (m *MockLendingStore) Disconnect() {
+}
+
+func (m *MockLendingStore) GetAllPatrons(library string) ([]lib.Patron, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	results := []lib.Patron{}
+	for _, patron := range m.patrons {
+		if patron.Library == library {
+			results = append(results, patron)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].ID < results[j].ID })
+	return results, nil
+}
+
+func (m *MockLendingStore) GetOnePatron(library, id string) (*lib.Patron, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	patron, exists := m.patrons[id]
+	if !exists || patron.Library != library {
+		return nil, lib.NoMatchingPatron
+	}
+	return &patron, nil
+}
+
+func (m *MockLendingStore) CreateNewPatron(patron *lib.Patron) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	patron.ID = primitive.NewObjectID().Hex()
+	m.patrons[patron.ID] = *patron
+	return nil
+}
+
+func (m *MockLendingStore) DeletePatron(library, id string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	patron, exists := m.patrons[id]
+	if !exists || patron.Library != library {
+		return lib.NoMatchingPatron
+	}
+	for _, loan := range m.loans {
+		if loan.PatronID == id && loan.IsActive() {
+			return lib.PatronHasActiveLoans
+		}
+	}
+	for _, hold := range m.holds {
+		if hold.PatronID == id && hold.IsActive() {
+			return lib.PatronHasActiveLoans
+		}
+	}
+	delete(m.patrons, id)
+	return nil
+}
+
+func (m *MockLendingStore) DropNamespace(library string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for id, patron := range m.patrons {
+		if patron.Library == library {
+			delete(m.patrons, id)
+		}
+	}
+	for id, loan := range m.loans {
+		if loan.Library == library {
+			delete(m.loans, id)
+		}
+	}
+	for key, copies := range m.copies {
+		if copies.Library == library {
+			delete(m.copies, key)
+		}
+	}
+	for id, hold := range m.holds {
+		if hold.Library == library {
+			delete(m.holds, id)
+		}
+	}
+	return nil
+}
+
+func (m *MockLendingStore) GetCopies(library, bookID string) (*lib.BookCopies, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	copies := m.bookCopies(library, bookID)
+	copies.SetAvailable()
+	return &copies, nil
+}
+
+func (m *MockLendingStore) SetCopies(library, bookID string, count int, now time.Time, pickupWindow time.Duration) (*lib.BookCopies, error) {
+	if count < 0 {
+		return nil, lib.IncorrectCopies
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	copies := m.bookCopies(library, bookID)
+	if copies.OnLoan+copies.OnHold > count {
+		return nil, lib.CopiesOnLoan
+	}
+	copies.Copies = count
+	m.copies[copiesKey(library, bookID)] = copies
+	copies = m.assignHolds(library, bookID, now, pickupWindow)
+	copies.SetAvailable()
+	return &copies, nil
+}
+
+func (m *MockLendingStore) CheckoutBook(loan *lib.Loan) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	copies := m.bookCopies(loan.Library, loan.BookID)
+	if hold, exists := m.readyHold(loan.Library, loan.BookID, loan.PatronID); exists {
+		hold.Close(lib.HoldFulfilled, loan.CheckedOutAt)
+		m.holds[hold.ID] = hold
+		copies.OnHold--
+	} else {
+		copies.SetAvailable()
+		if copies.Available == 0 {
+			return lib.NoCopyAvailable
+		}
+	}
+	copies.OnLoan++
+	m.copies[copiesKey(loan.Library, loan.BookID)] = copies
+
+	loan.ID = primitive.NewObjectID().Hex()
+	m.loans[loan.ID] = *loan
+	return nil
+}
+
+func (m *MockLendingStore) GetLoans(library string, filter lib.LoanFilter) ([]lib.Loan, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	results := []lib.Loan{}
+	for _, loan := range m.loans {
+		if loan.Library == library && filter.Matches(loan) {
+			results = append(results, loan)
+		}
+	}
+	sortLoans(results)
+	return results, nil
+}
+
+func (m *MockLendingStore) GetOneLoan(library, id string) (*lib.Loan, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	loan, exists := m.loans[id]
+	if !exists || loan.Library != library {
+		return nil, lib.NoMatchingLoan
+	}
+	return &loan, nil
+}
+
+func (m *MockLendingStore) ReturnLoan(library, id string, now time.Time, pickupWindow time.Duration) (*lib.Loan, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	loan, exists := m.loans[id]
+	if !exists || loan.Library != library {
+		return nil, lib.NoMatchingLoan
+	}
+	if !loan.IsActive() {
+		return nil, lib.LoanAlreadyReturned
+	}
+	loan.ReturnedAt = &now
+	m.loans[id] = loan
+
+	copies := m.bookCopies(library, loan.BookID)
+	copies.OnLoan--
+	m.copies[copiesKey(library, loan.BookID)] = copies
+	m.assignHolds(library, loan.BookID, now, pickupWindow)
+	return &loan, nil
+}
+
+func (m *MockLendingStore) RenewLoan(library, id string, now, dueAt time.Time, maxRenewals int) (*lib.Loan, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	loan, exists := m.loans[id]
+	if !exists || loan.Library != library {
+		return nil, lib.NoMatchingLoan
+	}
+	switch {
+	case !loan.IsActive():
+		return nil, lib.LoanAlreadyReturned
+	case loan.IsOverdue(now):
+		return nil, lib.LoanOverdue
+	case loan.Renewals >= maxRenewals:
+		return nil, lib.RenewalLimitReached
+	}
+	loan.Renewals++
+	loan.DueAt = dueAt
+	m.loans[id] = loan
+	return &loan, nil
+}
+
+func (m *MockLendingStore) PlaceHold(hold *lib.Hold, pickupWindow time.Duration) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, other := range m.holds {
+		if other.Library == hold.Library && other.BookID == hold.BookID && other.PatronID == hold.PatronID && other.IsActive() {
+			return lib.HoldAlreadyPlaced
+		}
+	}
+	copies := m.bookCopies(hold.Library, hold.BookID)
+	copies.SetAvailable()
+	if copies.Available > 0 {
+		return lib.CopyAvailable
+	}
+	copies.Waiting++
+	copies.HoldSequence++
+	m.copies[copiesKey(hold.Library, hold.BookID)] = copies
+
+	hold.ID = primitive.NewObjectID().Hex()
+	hold.Status = lib.HoldWaiting
+	hold.Sequence = copies.HoldSequence
+	m.holds[hold.ID] = *hold
+	return nil
+}
+
+func (m *MockLendingStore) GetHolds(library string, filter lib.HoldFilter) ([]lib.Hold, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	results := []lib.Hold{}
+	for _, hold := range m.holds {
+		if hold.Library == library && filter.Matches(hold) {
+			results = append(results, hold)
+		}
+	}
+	sortHolds(results)
+	return results, nil
+}
+
+func (m *MockLendingStore) GetOneHold(library, id string) (*lib.Hold, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	hold, exists := m.holds[id]
+	if !exists || hold.Library != library {
+		return nil, lib.NoMatchingHold
+	}
+	return &hold, nil
+}
+
+func (m *MockLendingStore) CancelHold(library, id string, now time.Time, pickupWindow time.Duration) (*lib.Hold, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	hold, exists := m.holds[id]
+	if !exists || hold.Library != library {
+		return nil, lib.NoMatchingHold
+	}
+	if !hold.IsActive() {
+		return nil, lib.HoldNotActive
+	}
+	m.closeHold(hold, lib.HoldCancelled, now, pickupWindow)
+	hold = m.holds[id]
+	return &hold, nil
+}
+
+func (m *MockLendingStore) ExpireHolds(now time.Time, pickupWindow time.Duration) ([]lib.Hold, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var due []lib.Hold
+	for _, hold := range m.holds {
+		if hold.Status == lib.HoldReady && hold.ExpiresAt.Before(now) {
+			due = append(due, hold)
+		}
+	}
+	sortHolds(due) // copies set aside longest go first to the next in queue
+	expired := []lib.Hold{}
+	for _, hold := range due {
+		m.closeHold(hold, lib.HoldExpired, now, pickupWindow)
+		expired = append(expired, m.holds[hold.ID])
+	}
+	return expired, nil
+}
+
+// closeHold ends an active hold, the copy set aside for a ready hold going to the next in queue. Caller must hold the lock.
+func (m *MockLendingStore) closeHold(hold lib.Hold, status string, now time.Time, pickupWindow time.Duration) {
+	copies := m.bookCopies(hold.Library, hold.BookID)
+	if hold.Status == lib.HoldReady {
+		copies.OnHold--
+	} else {
+		copies.Waiting--
+	}
+	m.copies[copiesKey(hold.Library, hold.BookID)] = copies
+	hold.Close(status, now)
+	m.holds[hold.ID] = hold
+	m.assignHolds(hold.Library, hold.BookID, now, pickupWindow)
+}
+
+// assignHolds sets free copies of a book aside for its oldest waiting holds, returning the updated copies. Caller must hold the lock.
+func (m *MockLendingStore) assignHolds(library, bookID string, now time.Time, pickupWindow time.Duration) lib.BookCopies {
+	copies := m.bookCopies(library, bookID)
+	var waiting []lib.Hold
+	for _, hold := range m.holds {
+		if hold.Library == library && hold.BookID == bookID && hold.Status == lib.HoldWaiting {
+			waiting = append(waiting, hold)
+		}
+	}
+	sort.Slice(waiting, func(i, j int) bool { return waiting[i].Sequence < waiting[j].Sequence })
+	for _, hold := range waiting {
+		if copies.Free() <= 0 {
+			break
+		}
+		hold.SetReady(now, pickupWindow)
+		m.holds[hold.ID] = hold
+		copies.OnHold++
+		copies.Waiting--
+	}
+	m.copies[copiesKey(library, bookID)] = copies
+	return copies
+}
+
+// readyHold finds the ready hold of a patron on a book. Caller must hold the lock.
+func (m *MockLendingStore) readyHold(library, bookID, patronID string) (lib.Hold, bool) {
+	for _, hold := range m.holds {
+		if hold.Library == library && hold.BookID == bookID && hold.PatronID == patronID && hold.Status == lib.HoldReady {
+			return hold, true
+		}
+	}
+	return lib.Hold{}, false
+}
+
+// bookCopies returns the copies of a book, the default count when never set. Caller must hold the lock.
+func (m *MockLendingStore) bookCopies(library, bookID string) lib.BookCopies {
+	if copies, exists := m.copies[copiesKey(library, bookID)]; exists {
+		return copies
+	}
+	return lib.BookCopies{Library: library, BookID: bookID, Copies: lib.DefaultCopies}
+}
+
+// copiesKey keys the copies of a book, ids being unique only within a library
+func copiesKey(library, bookID string) string {
+	return library + "/" + bookID
+}
+
+// sortHolds sorts holds in queue order, oldest placed first and by sequence within a book
+func sortHolds(holds []lib.Hold) {
+	sort.Slice(holds, func(i, j int) bool {
+		if !holds[i].PlacedAt.Equal(holds[j].PlacedAt) {
+			return holds[i].PlacedAt.Before(holds[j].PlacedAt)
+		}
+		if holds[i].Sequence != holds[j].Sequence {
+			return holds[i].Sequence < holds[j].Sequence
+		}
+		return holds[i].ID < holds[j].ID
+	})
+}
+
+// sortLoans sorts loans oldest checkout first, by id for the same checkout time
+func sortLoans(loans []lib.Loan) {
+	sort.Slice(loans, func(i, j int) bool {
+		if !loans[i].CheckedOutAt.Equal(loans[j].CheckedOutAt) {
+			return loans[i].CheckedOutAt.Before(loans[j].CheckedOutAt)
+		}
+		return loans[i].ID < loans[j].ID
+	})
+}
@@ -0,0 +1,45 @@
+package db
+
+import (
+	"dockerrestapi/lib"
+	"log"
+	"sync"
+	"time"
+)
+
+type MockQuotaStore struct {
+	lock   sync.Mutex
+	usages map[string]lib.QuotaUsage // keyed by day then client
+}
+
+func CreateMockQuotaStore() (QuotaStoreInterface, error) {
+	log.Println("Connected to MockQuotaStore!")
+	return &MockQuotaStore{usages: map[string]lib.QuotaUsage{}}, nil
+}
+
+func (m *MockQuotaStore) Disconnect() {
+}
+
+func (m *MockQuotaStore) IncrementQuota(client, day string, expiresAt time.Time) (int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	key := day + " " + client
+	usage, exists := m.usages[key]
+	if !exists {
+		m.forgetOtherDays(day)
+		usage = lib.QuotaUsage{Client: client, Day: day, ExpiresAt: expiresAt}
+	}
+	usage.Requests++
+	m.usages[key] = usage
+	return usage.Requests, nil
+}
+
+// forgetOtherDays drops the usages of days other than the given one. Caller must hold the lock.
+func (m *MockQuotaStore) forgetOtherDays(day string) {
+	for key, usage := range m.usages {
+		if usage.Day != day {
+			delete(m.usages, key)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package db
+
+import (
+	"dockerrestapi/lib"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+type MockWebhookStore struct {
+	lock       sync.RWMutex
+	webhooks   map[string]lib.Webhook  // webhooks keyed by id
+	deliveries map[string]lib.Delivery // deliveries keyed by id
+}
+
+func CreateMockWebhookStore() (WebhookStoreInterface, error) {
+	log.Println("Connected to MockWebhookStore!")
+	return &MockWebhookStore{
+		webhooks:   map[string]lib.Webhook{},
+		deliveries: map[string]lib.Delivery{},
+	}, nil
+}
+
+func (m *MockWebhookStore) Disconnect() {
+}
+
+func (m *MockWebhookStore) GetAllWebhooks(library string) ([]lib.Webhook, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	results := []lib.Webhook{}
+	for _, webhook := range m.webhooks {
+		if webhook.Library == library {
+			results = append(results, webhook)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].ID < results[j].ID })
+	return results, nil
+}
+
+func (m *MockWebhookStore) GetOneWebhook(library, id string) (*lib.Webhook, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	webhook, exists := m.webhooks[id]
+	if !exists || webhook.Library != library {
+		return nil, lib.NoMatchingWebhook
+	}
+	return &webhook, nil
+}
+
+func (m *MockWebhookStore) CreateNewWebhook(webhook *lib.Webhook) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	webhook.ID = primitive.NewObjectID().Hex()
+	m.webhooks[webhook.ID] = *webhook
+	return nil
+}
+
+func (m *MockWebhookStore) DeleteWebhook(library, id string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	webhook, exists := m.webhooks[id]
+	if !exists || webhook.Library != library {
+		return lib.NoMatchingWebhook
+	}
+	delete(m.webhooks, id)
+	for deliveryID, delivery := range m.deliveries {
+		if delivery.WebhookID == id {
+			delete(m.deliveries, deliveryID)
+		}
+	}
+	return nil
+}
+
+func (m *MockWebhookStore) DropNamespace(library string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for id, webhook := range m.webhooks {
+		if webhook.Library == library {
+			delete(m.webhooks, id)
+		}
+	}
+	for id, delivery := range m.deliveries {
+		if delivery.Library == library {
+			delete(m.deliveries, id)
+		}
+	}
+	return nil
+}
+
+func (m *MockWebhookStore) CreateNewDelivery(delivery *lib.Delivery) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, exists := m.webhooks[delivery.WebhookID]; !exists {
+		return lib.NoMatchingWebhook
+	}
+	delivery.ID = primitive.NewObjectID().Hex()
+	m.deliveries[delivery.ID] = cloneDelivery(*delivery)
+	return nil
+}
+
+func (m *MockWebhookStore) GetDeliveries(library, webhookID, status string) ([]lib.Delivery, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	results := []lib.Delivery{}
+	for _, delivery := range m.deliveries {
+		if delivery.Library == library && (webhookID == "" || delivery.WebhookID == webhookID) && (status == "" || delivery.Status == status) {
+			results = append(results, cloneDelivery(delivery))
+		}
+	}
+	sortDeliveries(results)
+	return results, nil
+}
+
+func (m *MockWebhookStore) GetOneDelivery(library, webhookID, id string) (*lib.Delivery, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	delivery, exists := m.deliveries[id]
+	if !exists || delivery.Library != library || delivery.WebhookID != webhookID {
+		return nil, lib.NoMatchingDelivery
+	}
+	delivery = cloneDelivery(delivery)
+	return &delivery, nil
+}
+
+func (m *MockWebhookStore) ClaimDueDeliveries(now time.Time, lease time.Duration, limit int) ([]lib.Delivery, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var results []lib.Delivery
+	for _, delivery := range m.deliveries {
+		if delivery.Status == lib.DeliveryPending && !delivery.NextAttemptAt.After(now) {
+			results = append(results, cloneDelivery(delivery))
+		}
+	}
+	sortDeliveries(results)
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	for i := range results {
+		results[i].NextAttemptAt = now.Add(lease)
+		m.deliveries[results[i].ID] = cloneDelivery(results[i])
+	}
+	return results, nil
+}
+
+func (m *MockWebhookStore) UpdateDelivery(delivery *lib.Delivery) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, exists := m.deliveries[delivery.ID]; !exists {
+		return lib.NoMatchingDelivery // its webhook was deleted meanwhile
+	}
+	m.deliveries[delivery.ID] = cloneDelivery(*delivery)
+	return nil
+}
+
+// cloneDelivery copies a delivery so its attempt log and finish time are not shared with the caller
+func cloneDelivery(delivery lib.Delivery) lib.Delivery {
+	delivery.Attempts = append([]lib.DeliveryAttempt{}, delivery.Attempts...)
+	if delivery.FinishedAt != nil {
+		finishedAt := *delivery.FinishedAt
+		delivery.FinishedAt = &finishedAt
+	}
+	return delivery
+}
+
+// sortDeliveries orders deliveries oldest first, ids breaking ties as they are increasing
+func sortDeliveries(deliveries []lib.Delivery) {
+	sort.Slice(deliveries, func(i, j int) bool {
+		if !deliveries[i].CreatedAt.Equal(deliveries[j].CreatedAt) {
+			return deliveries[i].CreatedAt.Before(deliveries[j].CreatedAt)
+		}
+		return deliveries[i].ID < deliveries[j].ID
+	})
+}
@@ -0,0 +1,106 @@
+package db
+
+import (
+	"context"
+	"dockerrestapi/lib"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// GetAllAttachments retrieves the attachments of a book
+func (m *MongoDB) GetAllAttachments(bookID string) ([]lib.Attachment, error) {
+	inDb, err := m.isBookInDb(lib.BookIdentifier{ID: bookID})
+	if err != nil {
+		return nil, err
+	}
+	if !inDb {
+		return nil, lib.NoMatchingBook
+	}
+
+	cursor, err := m.attachmentsCollection().Find(context.Background(), bson.M{lib.JsonBsonTagBookID: bookID})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	results := []lib.Attachment{}
+	err = cursor.All(context.Background(), &results)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// GetOneAttachment retrieves a single attachment of a book
+func (m *MongoDB) GetOneAttachment(bookID, attachmentID string) (*lib.Attachment, error) {
+	id, err := primitive.ObjectIDFromHex(attachmentID)
+	if err != nil {
+		return nil, lib.NoMatchingAttachment
+	}
+	attachment := &lib.Attachment{}
+	err = m.attachmentsCollection().FindOne(context.Background(), bson.M{lib.JsonBsonTagID: id, lib.JsonBsonTagBookID: bookID}).Decode(attachment)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, lib.NoMatchingAttachment
+		}
+		return nil, err
+	}
+	return attachment, nil
+}
+
+// CreateNewAttachment stores the metadata of a new attachment, its blob must already be stored
+func (m *MongoDB) CreateNewAttachment(attachment *lib.Attachment) error {
+	inDb, err := m.isBookInDb(lib.BookIdentifier{ID: attachment.BookID})
+	if err != nil {
+		return err
+	}
+	if !inDb {
+		return lib.NoMatchingBook
+	}
+	attachment.ID = ""
+	attachment.CreatedAt = m.clock.Now()
+
+	result, err := m.attachmentsCollection().InsertOne(context.Background(), attachment)
+	if err != nil {
+		return err
+	}
+	if insertedID, ok := result.InsertedID.(primitive.ObjectID); ok {
+		attachment.ID = insertedID.Hex()
+	}
+	return nil
+}
+
+// DeleteAttachment deletes the metadata of an attachment, the caller decides whether its blob is still referenced
+func (m *MongoDB) DeleteAttachment(bookID, attachmentID string) error {
+	id, err := primitive.ObjectIDFromHex(attachmentID)
+	if err != nil {
+		return lib.NoMatchingAttachment
+	}
+	result, err := m.attachmentsCollection().DeleteOne(context.Background(), bson.M{lib.JsonBsonTagID: id, lib.JsonBsonTagBookID: bookID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return lib.NoMatchingAttachment
+	}
+	return nil
+}
+
+// IsBlobReferenced checks to see if any attachment of this library uses a blob, as content or thumbnail
+func (m *MongoDB) IsBlobReferenced(hash string) (bool, error) {
+	count, err := m.attachmentsCollection().CountDocuments(context.Background(), bson.M{"$or": bson.A{
+		bson.M{lib.JsonBsonTagSHA256: hash},
+		bson.M{lib.JsonBsonTagThumbnail: hash},
+	}})
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// attachmentsCollection returns the collection holding attachment metadata of this library
+func (m *MongoDB) attachmentsCollection() *mongo.Collection {
+	return m.database.Collection(m.collection.Name() + "_attachments")
+}
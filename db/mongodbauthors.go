@@ -0,0 +1,356 @@
+package db
+
+import (
+	"context"
+	"dockerrestapi/lib"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+)
+
+// GetAllAuthors retrieves every author of the library in name order
+func (m *MongoDB) GetAllAuthors() ([]lib.Author, error) {
+	cursor, err := m.authorsCollection().Find(context.Background(), bson.M{}, options.Find().SetSort(bson.M{lib.JsonBsonTagName: 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	results := []lib.Author{}
+	err = cursor.All(context.Background(), &results)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// GetOneAuthor retrieves a single author given its id
+func (m *MongoDB) GetOneAuthor(id string) (*lib.Author, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, lib.NoMatchingAuthor
+	}
+	return m.findAuthor(bson.M{lib.JsonBsonTagID: objectID})
+}
+
+// FindAuthor retrieves a single author given its canonical name or an alias
+func (m *MongoDB) FindAuthor(name string) (*lib.Author, error) {
+	return m.findAuthor(bson.M{lib.JsonBsonTagAuthorKeys: lib.AuthorKey(name)})
+}
+
+// CreateNewAuthor stores a new author and links the books written under its names
+func (m *MongoDB) CreateNewAuthor(author *lib.Author) error {
+	err := author.Normalise()
+	if err != nil {
+		return err
+	}
+	taken, err := m.isAuthorNameTaken(author, "")
+	if err != nil {
+		return err
+	}
+	if taken {
+		return lib.AuthorAlreadyExists
+	}
+
+	author.ID = ""
+	author.CreatedAt = m.clock.Now()
+	author.UpdatedAt = author.CreatedAt
+	err = m.checkBooksLinkable(*author)
+	if err != nil {
+		return err
+	}
+	result, err := m.authorsCollection().InsertOne(context.Background(), author)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return lib.AuthorAlreadyExists
+		}
+		return err
+	}
+	insertedID, _ := result.InsertedID.(primitive.ObjectID)
+	author.ID = insertedID.Hex()
+	err = m.linkBooks(*author)
+	if err != nil {
+		// the books are pointed back to their authors, the author goes with them
+		_, deleteErr := m.authorsCollection().DeleteOne(context.Background(), bson.M{lib.JsonBsonTagID: insertedID})
+		if deleteErr != nil {
+			log.Println("cant delete unlinked author", author.Name, deleteErr.Error())
+		}
+		return err
+	}
+	return nil
+}
+
+// UpdateExistingAuthor updates an author given its id, renaming its books and linking the books written under its new names
+func (m *MongoDB) UpdateExistingAuthor(author *lib.Author) error {
+	err := author.Normalise()
+	if err != nil {
+		return err
+	}
+	existing, err := m.GetOneAuthor(author.ID)
+	if err != nil {
+		return err
+	}
+	taken, err := m.isAuthorNameTaken(author, author.ID)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return lib.AuthorAlreadyExists
+	}
+
+	author.CreatedAt = existing.CreatedAt
+	author.UpdatedAt = m.clock.Now()
+	err = m.checkBooksLinkable(*author)
+	if err != nil {
+		return err
+	}
+	err = m.replaceAuthor(author)
+	if err != nil {
+		return err
+	}
+	err = m.linkBooks(*author)
+	if err != nil {
+		// the books are pointed back to their authors, the author gets its names back
+		restoreErr := m.replaceAuthor(existing)
+		if restoreErr != nil {
+			log.Println("cant restore author", existing.Name, restoreErr.Error())
+		}
+		return err
+	}
+	return nil
+}
+
+// DeleteAuthor deletes an author no book is linked to, books in the trash included
+func (m *MongoDB) DeleteAuthor(id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return lib.NoMatchingAuthor
+	}
+	count, err := m.collection.CountDocuments(context.Background(), bson.M{lib.JsonBsonTagAuthorID: id})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return lib.AuthorHasBooks
+	}
+	result, err := m.authorsCollection().DeleteOne(context.Background(), bson.M{lib.JsonBsonTagID: objectID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return lib.NoMatchingAuthor
+	}
+	return nil
+}
+
+// MergeAuthors deletes the source author, adding its names to the aliases of the target and re-pointing its books to the target.
+// The books are re-pointed and the aliases added before the source is deleted, so that a failure partway loses no name:
+// merging again completes a merge failing before the delete, updating the target one failing after it.
+func (m *MongoDB) MergeAuthors(sourceID, targetID string) (*lib.Author, error) {
+	if sourceID == targetID {
+		return nil, lib.CannotMergeAuthor
+	}
+	source, err := m.GetOneAuthor(sourceID)
+	if err != nil {
+		return nil, err
+	}
+	target, err := m.GetOneAuthor(targetID)
+	if err != nil {
+		return nil, err
+	}
+	target.Merge(source)
+	target.UpdatedAt = m.clock.Now()
+	err = m.checkBooksLinkable(*target, sourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.linkBooks(*target, sourceID)
+	if err != nil {
+		return nil, err
+	}
+	// the keys of the source are unique, the target only takes them once the source is deleted
+	targetObjectID, _ := primitive.ObjectIDFromHex(targetID) // valid once found
+	_, err = m.authorsCollection().UpdateOne(context.Background(), bson.M{lib.JsonBsonTagID: targetObjectID},
+		bson.M{"$set": bson.M{lib.JsonBsonTagAliases: target.Aliases, lib.JsonBsonTagUpdatedAt: target.UpdatedAt}})
+	if err != nil {
+		return nil, err
+	}
+	sourceObjectID, _ := primitive.ObjectIDFromHex(sourceID) // valid once found
+	_, err = m.authorsCollection().DeleteOne(context.Background(), bson.M{lib.JsonBsonTagID: sourceObjectID})
+	if err != nil {
+		return nil, err
+	}
+	err = m.replaceAuthor(target)
+	if err != nil {
+		return nil, err
+	}
+	return target, nil
+}
+
+// linkAuthor stores a book under the canonical name of its author when known, linking it by id
+func (m *MongoDB) linkAuthor(book *lib.Book) error {
+	book.AuthorID = ""
+	author, err := m.FindAuthor(book.Author)
+	if err != nil {
+		if errors.Is(err, lib.NoMatchingAuthor) {
+			return nil
+		}
+		return err
+	}
+	book.Author = author.Name
+	book.AuthorID = author.ID
+	return nil
+}
+
+// canonicalAuthor returns the canonical name of a known author, otherwise the name itself
+func (m *MongoDB) canonicalAuthor(name string) (string, error) {
+	author, err := m.FindAuthor(name)
+	if err != nil {
+		if errors.Is(err, lib.NoMatchingAuthor) {
+			return name, nil
+		}
+		return "", err
+	}
+	return author.Name, nil
+}
+
+// findAuthor retrieves the author matching the filter
+func (m *MongoDB) findAuthor(filter bson.M) (*lib.Author, error) {
+	author := &lib.Author{}
+	err := m.authorsCollection().FindOne(context.Background(), filter).Decode(author)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, lib.NoMatchingAuthor
+		}
+		return nil, err
+	}
+	return author, nil
+}
+
+// isAuthorNameTaken checks whether another author than the one of the given id has one of the names of the author
+func (m *MongoDB) isAuthorNameTaken(author *lib.Author, id string) (bool, error) {
+	filter := bson.M{lib.JsonBsonTagAuthorKeys: bson.M{"$in": author.Keys}}
+	if objectID, err := primitive.ObjectIDFromHex(id); err == nil {
+		filter[lib.JsonBsonTagID] = bson.M{"$ne": objectID}
+	}
+	count, err := m.authorsCollection().CountDocuments(context.Background(), filter)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// replaceAuthor replaces the stored author of the same id
+func (m *MongoDB) replaceAuthor(author *lib.Author) error {
+	id, err := primitive.ObjectIDFromHex(author.ID)
+	if err != nil {
+		return lib.NoMatchingAuthor
+	}
+	replacement := *author
+	replacement.ID = "" // _id is immutable, kept by the replacement
+	_, err = m.authorsCollection().ReplaceOne(context.Background(), bson.M{lib.JsonBsonTagID: id}, replacement)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return lib.AuthorAlreadyExists
+		}
+		return err
+	}
+	return nil
+}
+
+// checkBooksLinkable fails when two books not in the trash would share a name once linked to the author, see linkBooks
+func (m *MongoDB) checkBooksLinkable(author lib.Author, previousAuthorIDs ...string) error {
+	_, err := m.booksToLink(author, previousAuthorIDs)
+	return err
+}
+
+// linkBooks points every book linked to the author or one of the given previous authors, or written under one of its names, to the author.
+// When a book written meanwhile makes two books share a name, the books already changed are pointed back to their previous author.
+func (m *MongoDB) linkBooks(author lib.Author, previousAuthorIDs ...string) error {
+	books, err := m.booksToLink(author, previousAuthorIDs)
+	if err != nil || len(books) == 0 {
+		return err
+	}
+	ids := make([]primitive.ObjectID, len(books))
+	for i, book := range books {
+		ids[i], _ = primitive.ObjectIDFromHex(book.ID) // checked by booksToLink
+	}
+	_, err = m.collection.UpdateMany(context.Background(),
+		bson.M{lib.JsonBsonTagID: bson.M{"$in": ids}},
+		bson.M{"$set": bson.M{lib.JsonBsonTagAuthor: author.Name, lib.JsonBsonTagAuthorID: author.ID, lib.JsonBsonTagUpdatedAt: m.clock.Now()}},
+	)
+	if err != nil {
+		m.unlinkBooks(author, books)
+		if mongo.IsDuplicateKeyError(err) {
+			return lib.BookAlreadyExists
+		}
+		return err
+	}
+	return nil
+}
+
+// unlinkBooks points the books linkBooks may have changed back to the author they had, logging failures
+func (m *MongoDB) unlinkBooks(author lib.Author, books []lib.Book) {
+	models := make([]mongo.WriteModel, 0, len(books))
+	for _, book := range books {
+		id, _ := primitive.ObjectIDFromHex(book.ID) // checked by booksToLink
+		update := bson.M{"$set": bson.M{lib.JsonBsonTagAuthor: book.Author, lib.JsonBsonTagAuthorID: book.AuthorID}}
+		if book.AuthorID == "" {
+			update = bson.M{"$set": bson.M{lib.JsonBsonTagAuthor: book.Author}, "$unset": bson.M{lib.JsonBsonTagAuthorID: ""}}
+		}
+		models = append(models, mongo.NewUpdateOneModel().SetFilter(bson.M{lib.JsonBsonTagID: id, lib.JsonBsonTagAuthorID: author.ID}).SetUpdate(update))
+	}
+	_, err := m.collection.BulkWrite(context.Background(), models, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		log.Println("cant unlink books of author", author.Name, err.Error())
+	}
+}
+
+// booksToLink returns the books linkBooks changes, with their current author, failing when two books not in the trash would
+// then share a name as the unique index compares them
+func (m *MongoDB) booksToLink(author lib.Author, previousAuthorIDs []string) ([]lib.Book, error) {
+	filter := bson.M{"$or": bson.A{
+		bson.M{lib.JsonBsonTagAuthorID: bson.M{"$in": append(previousAuthorIDs, author.ID)}},
+		bson.M{lib.JsonBsonTagAuthor: bson.M{"$in": author.Names()}},
+	}}
+	projection := bson.M{lib.JsonBsonTagName: 1, lib.JsonBsonTagAuthor: 1, lib.JsonBsonTagAuthorID: 1, lib.JsonBsonTagDeletedAt: 1}
+	cursor, err := m.collection.Find(context.Background(), filter, options.Find().SetProjection(projection).SetCollation(nameCollation))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+	var books []lib.Book
+	err = cursor.All(context.Background(), &books)
+	if err != nil {
+		return nil, err
+	}
+
+	var changed []lib.Book
+	names := map[string]bool{}
+	for _, book := range books {
+		if book.DeletedAt == nil {
+			if names[lib.FoldName(book.Name)] {
+				return nil, lib.BookAlreadyExists
+			}
+			names[lib.FoldName(book.Name)] = true
+		}
+		if book.Author == author.Name && book.AuthorID == author.ID {
+			continue
+		}
+		_, err := primitive.ObjectIDFromHex(book.ID)
+		if err != nil {
+			return nil, err
+		}
+		changed = append(changed, book)
+	}
+	return changed, nil
+}
+
+// authorsCollection returns the collection holding the authors of this library
+func (m *MongoDB) authorsCollection() *mongo.Collection {
+	return m.database.Collection(m.collection.Name() + "_authors")
+}
@@ -0,0 +1,127 @@
+package db
+
+import (
+	"context"
+	"dockerrestapi/lib"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const changeStreamHistoryLost = 286 // server error code of a resume token no longer in the oplog
+
+// mongoChange is the part of a change stream document needed to describe a book change
+type mongoChange struct {
+	OperationType string `bson:"operationType"`
+	DocumentKey   struct {
+		ID primitive.ObjectID `bson:"_id"`
+	} `bson:"documentKey"`
+	FullDocument      *lib.Book `bson:"fullDocument"`
+	UpdateDescription struct {
+		UpdatedFields bson.M   `bson:"updatedFields"`
+		RemovedFields []string `bson:"removedFields"`
+	} `bson:"updateDescription"`
+	ClusterTime primitive.Timestamp `bson:"clusterTime"`
+}
+
+// mongoChangeStream turns a Mongo change stream of a library collection into book change events
+type mongoChangeStream struct {
+	stream *mongo.ChangeStream
+}
+
+// WatchBooks streams the changes of this library from a Mongo change stream, which needs the server to run as a replica set.
+// Resume tokens are the _data of Mongo resume tokens.
+func (m *MongoDB) WatchBooks(resumeToken string) (ChangeStream, error) {
+	pipeline := mongo.Pipeline{
+		bson.D{{Key: "$match", Value: bson.M{
+			"operationType": bson.M{"$in": bson.A{"insert", "update", "replace", "delete"}},
+		}}},
+		// events only need the name and author of the looked up book and whether it was trashed or restored, not its contents
+		bson.D{{Key: "$project", Value: bson.M{
+			"operationType":                         1,
+			"documentKey":                           1,
+			"clusterTime":                           1,
+			"fullDocument." + lib.JsonBsonTagID:     1,
+			"fullDocument." + lib.JsonBsonTagName:   1,
+			"fullDocument." + lib.JsonBsonTagAuthor: 1,
+			"updateDescription.updatedFields." + lib.JsonBsonTagDeletedAt: 1,
+			"updateDescription.removedFields":                             1,
+		}}},
+	}
+	watchOptions := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if resumeToken != "" {
+		watchOptions.SetResumeAfter(bson.M{"_data": resumeToken})
+	}
+
+	stream, err := m.collection.Watch(context.Background(), pipeline, watchOptions)
+	if err != nil {
+		var serverError mongo.ServerError
+		if resumeToken != "" && errors.As(err, &serverError) {
+			if serverError.HasErrorCode(changeStreamHistoryLost) {
+				return nil, lib.ResumeTokenExpired
+			}
+			return nil, lib.IncorrectResumeToken
+		}
+		return nil, err
+	}
+	return &mongoChangeStream{stream: stream}, nil
+}
+
+// Next waits for the next change of the collection
+func (s *mongoChangeStream) Next(ctx context.Context) (lib.ChangeEvent, error) {
+	if !s.stream.Next(ctx) {
+		if err := s.stream.Err(); err != nil {
+			return lib.ChangeEvent{}, err
+		}
+		if err := ctx.Err(); err != nil {
+			return lib.ChangeEvent{}, err
+		}
+		return lib.ChangeEvent{}, lib.ChangeStreamClosed // the server ended the stream
+	}
+
+	var change mongoChange
+	err := s.stream.Decode(&change)
+	if err != nil {
+		return lib.ChangeEvent{}, err
+	}
+	if change.OperationType == "invalidate" {
+		return lib.ChangeEvent{}, lib.ChangeStreamClosed // the collection was dropped
+	}
+	event := lib.ChangeEvent{
+		Token:  s.stream.ResumeToken().Lookup("_data").StringValue(),
+		Type:   change.changeType(),
+		BookID: change.DocumentKey.ID.Hex(),
+		Time:   time.Unix(int64(change.ClusterTime.T), 0).UTC(),
+	}
+	if change.FullDocument != nil {
+		event.Name = change.FullDocument.Name
+		event.Author = change.FullDocument.Author
+	}
+	return event, nil
+}
+
+func (s *mongoChangeStream) Close() error {
+	return s.stream.Close(context.Background())
+}
+
+// changeType maps a Mongo operation to a book change, trashing and restoring are updates of deletedAt
+func (c *mongoChange) changeType() string {
+	switch c.OperationType {
+	case "insert":
+		return lib.ChangeCreated
+	case "delete":
+		return lib.ChangePurged
+	}
+	if _, trashed := c.UpdateDescription.UpdatedFields[lib.JsonBsonTagDeletedAt]; trashed {
+		return lib.ChangeDeleted
+	}
+	for _, field := range c.UpdateDescription.RemovedFields {
+		if field == lib.JsonBsonTagDeletedAt {
+			return lib.ChangeRestored
+		}
+	}
+	return lib.ChangeUpdated
+}
@@ -0,0 +1,79 @@
+package db
+
+import (
+	"context"
+	"dockerrestapi/lib"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// mongoFacets is the result of the facets pipeline, the count of books being a one element list unless none match
+type mongoFacets struct {
+	Books []struct {
+		Count int64 `bson:"count"`
+	} `bson:"books"`
+	Authors      []lib.FacetCount `bson:"authors"`
+	Tags         []lib.FacetCount `bson:"tags"`
+	Languages    []lib.FacetCount `bson:"languages"`
+	UpdatedYears []lib.FacetCount `bson:"updatedYears"`
+}
+
+// GetFacets counts the books matching the filter per author, tag, language and update year, up to limit values per facet,
+// in a single aggregation counting as lib.CountFacets does
+func (m *MongoDB) GetFacets(filter lib.BookFilter, limit int) (*lib.Facets, error) {
+	pipeline := bson.A{
+		bson.M{"$match": bookFilterQuery(filter)},
+		bson.M{"$facet": bson.M{
+			"books":   bson.A{bson.M{"$count": "count"}},
+			"authors": facetStages(nil, "$"+lib.JsonBsonTagAuthor, limit),
+			"tags": facetStages(bson.A{bson.M{"$unwind": "$" + lib.JsonBsonTagTags}},
+				"$"+lib.JsonBsonTagTags, limit),
+			"languages": facetStages(bson.A{bson.M{"$match": bson.M{lib.JsonBsonTagLanguage: bson.M{"$nin": bson.A{nil, ""}}}}},
+				"$"+lib.JsonBsonTagLanguage, limit),
+			"updatedYears": facetStages(bson.A{bson.M{"$match": bson.M{lib.JsonBsonTagUpdatedAt: bson.M{"$type": "date"}}}},
+				bson.M{"$toString": bson.M{"$year": "$" + lib.JsonBsonTagUpdatedAt}}, limit),
+		}},
+	}
+	cursor, err := m.collection.Aggregate(context.Background(), pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var results []mongoFacets
+	err = cursor.All(context.Background(), &results)
+	if err != nil {
+		return nil, err
+	}
+	var result mongoFacets
+	if len(results) > 0 {
+		result = results[0]
+	}
+	facets := &lib.Facets{
+		Authors:      nonNilFacet(result.Authors),
+		Tags:         nonNilFacet(result.Tags),
+		Languages:    nonNilFacet(result.Languages),
+		UpdatedYears: nonNilFacet(result.UpdatedYears),
+	}
+	if len(result.Books) > 0 {
+		facets.Books = result.Books[0].Count
+	}
+	return facets, nil
+}
+
+// nonNilFacet returns the counts of a facet, empty rather than nil so that facets without values render as []
+func nonNilFacet(counts []lib.FacetCount) []lib.FacetCount {
+	if counts == nil {
+		return []lib.FacetCount{}
+	}
+	return counts
+}
+
+// facetStages returns the stages of a facet counting the books per value of the key after the given stages, most books first
+// then values in byte order, keeping up to limit values
+func facetStages(stages bson.A, key any, limit int) bson.A {
+	return append(stages,
+		bson.M{"$group": bson.M{"_id": key, "count": bson.M{"$sum": 1}}},
+		bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+		bson.M{"$limit": limit},
+	)
+}
@@ -5,24 +5,82 @@ import (
 	"dockerrestapi/lib"
 	"errors"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"io"
 	"log"
-	"time"
+	"regexp"
+	"strings"
+	"sync"
 )
 
-var (
-	dataBaseName   = "WanShiTong"
-	collectionName = "library"
+const (
+	DefaultDatabaseName     = "WanShiTong"
+	DefaultCollectionName   = "library"
+	librariesCollectionName = "libraries" // registry of every non default library
 )
 
 type MongoDB struct {
-	client     *mongo.Client
-	collection *mongo.Collection
+	clock          lib.Clock
+	client         *mongo.Client
+	database       *mongo.Database
+	libraries      *mongo.Collection
+	collection     *mongo.Collection
+	collectionName string // collection of the default library, other libraries are prefixed with it
 }
 
-// CreateMongoDBHandler returns a db interface to a mongo handler given access dsn.
-func CreateMongoDBHandler(dsn string) (RestDbInterface, error) {
+// CreateMongoDBHandler returns a db interface to a mongo handler given access dsn, database name, and the collection name of the default library.
+func CreateMongoDBHandler(dsn, databaseName, collectionName string) (RestDbInterface, error) {
+	return CreateMongoDBHandlerWithClock(dsn, databaseName, collectionName, lib.SystemClock{})
+}
+
+// CreateMongoDBHandlerWithClock returns a mongo handler taking every timestamp from the given clock
+func CreateMongoDBHandlerWithClock(dsn, databaseName, collectionName string, clock lib.Clock) (RestDbInterface, error) {
+	client, err := connectMongo(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	database := client.Database(databaseName)
+	handler := &MongoDB{
+		clock:          clock,
+		client:         client,
+		database:       database,
+		libraries:      database.Collection(librariesCollectionName),
+		collection:     database.Collection(collectionName),
+		collectionName: collectionName,
+	}
+	err = handler.ensureLibraryIndexes()
+	if err != nil {
+		return nil, err
+	}
+	return handler, nil
+}
+
+// mongoClients are the clients connected by dsn, shared by the handler and every store of a deployment until each disconnected
+var mongoClients = struct {
+	lock    sync.Mutex
+	clients map[string]*sharedMongoClient
+}{clients: map[string]*sharedMongoClient{}}
+
+// sharedMongoClient is a client along with how many handlers and stores use it
+type sharedMongoClient struct {
+	client *mongo.Client
+	users  int
+}
+
+// connectMongo connects to and pings mongo given access dsn, or returns the client already connected to it.
+// Clients are released with disconnectMongo.
+func connectMongo(dsn string) (*mongo.Client, error) {
+	mongoClients.lock.Lock()
+	defer mongoClients.lock.Unlock()
+	if shared, exists := mongoClients.clients[dsn]; exists {
+		shared.users++
+		return shared.client, nil
+	}
+
 	clientOptions := options.Client().ApplyURI(dsn)
 
 	log.Println("connecting to mongo")
@@ -44,27 +102,43 @@ func CreateMongoDBHandler(dsn string) (RestDbInterface, error) {
 	}
 
 	log.Println("Connected to mongoDB")
-	return &MongoDB{
-		client:     client,
-		collection: client.Database(dataBaseName).Collection(collectionName),
-	}, nil
+	mongoClients.clients[dsn] = &sharedMongoClient{client: client, users: 1}
+	return client, nil
+}
+
+// disconnectMongo releases a client of connectMongo, disconnecting it once released by every user
+func disconnectMongo(client *mongo.Client) error {
+	mongoClients.lock.Lock()
+	defer mongoClients.lock.Unlock()
+	for dsn, shared := range mongoClients.clients {
+		if shared.client != client {
+			continue
+		}
+		shared.users--
+		if shared.users > 0 {
+			return nil
+		}
+		delete(mongoClients.clients, dsn)
+		break
+	}
+	return client.Disconnect(context.Background())
 }
 
 func (m *MongoDB) Disconnect() {
-	err := m.client.Disconnect(context.Background())
+	err := disconnectMongo(m.client)
 	if err != nil {
 		log.Println("disconnect error:", err.Error())
 	}
 }
 
-// GetAllBooks , retrieves all books in mongo, returns only identifiers
-func (m *MongoDB) GetAllBooks() ([]lib.BookIdentifier, error) {
+// GetAllBooks , retrieves all books in mongo matching the filter, returns only identifiers
+func (m *MongoDB) GetAllBooks(filter lib.BookFilter) ([]lib.BookIdentifier, error) {
 	// Specify the fields to include (1) or exclude (0)
 	projection := bson.M{lib.JsonBsonTagName: 1, lib.JsonBsonTagAuthor: 1}
 
-	cursor, err := m.collection.Find(context.Background(), bson.M{}, options.Find().SetProjection(projection))
+	cursor, err := m.collection.Find(context.Background(), bookFilterQuery(filter), options.Find().SetProjection(projection))
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	defer cursor.Close(context.Background())
 
@@ -78,11 +152,71 @@ func (m *MongoDB) GetAllBooks() ([]lib.BookIdentifier, error) {
 	return result, nil
 }
 
+// EachBook visits the identifier of every book in mongo matching the filter as the cursor reads it, stopping at the first
+// error of visit
+func (m *MongoDB) EachBook(filter lib.BookFilter, visit func(lib.BookIdentifier) error) error {
+	projection := bson.M{lib.JsonBsonTagName: 1, lib.JsonBsonTagAuthor: 1}
+	cursor, err := m.collection.Find(context.Background(), bookFilterQuery(filter), options.Find().SetProjection(projection))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(context.Background())
+
+	for cursor.Next(context.Background()) {
+		var book lib.BookIdentifier
+		err = cursor.Decode(&book)
+		if err != nil {
+			return err
+		}
+		err = visit(book)
+		if err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// GetAllBooksWithFields retrieves all books in mongo matching the filter, projected to the given fields
+func (m *MongoDB) GetAllBooksWithFields(filter lib.BookFilter, fields []string) ([]lib.Book, error) {
+	cursor, err := m.collection.Find(context.Background(), bookFilterQuery(filter), options.Find().SetProjection(fieldsProjection(fields)))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var result []lib.Book
+	err = cursor.All(context.Background(), &result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetOneBookWithFields retrieves single book given a book Identifier, projected to the given fields
+func (m *MongoDB) GetOneBookWithFields(bookIdentifier *lib.BookIdentifier, fields []string) (*lib.Book, error) {
+	match, err := m.identifierFilter(*bookIdentifier)
+	if err != nil {
+		return nil, err
+	}
+	receivedBook := &lib.Book{}
+	err = m.collection.FindOne(context.Background(), match, options.FindOne().SetProjection(fieldsProjection(fields)).SetCollation(nameCollation)).Decode(receivedBook)
+	if err != nil {
+		if errors.Is(mongo.ErrNoDocuments, err) {
+			return nil, lib.NoMatchingBook
+		}
+		return nil, err
+	}
+	return receivedBook, nil
+}
+
 // GetOneBook retrieves single book given a book Identifier
 func (m *MongoDB) GetOneBook(bookIdentifier *lib.BookIdentifier) (*lib.Book, error) {
-	match := bson.M{lib.JsonBsonTagName: bookIdentifier.Name, lib.JsonBsonTagAuthor: bookIdentifier.Author}
+	match, err := m.identifierFilter(*bookIdentifier)
+	if err != nil {
+		return nil, err
+	}
 	receivedBook := &lib.Book{}
-	err := m.collection.FindOne(context.Background(), match).Decode(receivedBook)
+	err = m.collection.FindOne(context.Background(), match, options.FindOne().SetCollation(nameCollation)).Decode(receivedBook)
 	if err != nil {
 		if errors.Is(mongo.ErrNoDocuments, err) {
 			return nil, lib.NoMatchingBook
@@ -95,6 +229,10 @@ func (m *MongoDB) GetOneBook(bookIdentifier *lib.BookIdentifier) (*lib.Book, err
 
 // CreateNewBook stores a new book in db
 func (m *MongoDB) CreateNewBook(book *lib.Book) error {
+	err := m.linkAuthor(book)
+	if err != nil {
+		return err
+	}
 	inDb, err := m.isBookInDb(lib.BookIdentifier{
 		Name:   book.Name,
 		Author: book.Author,
@@ -105,76 +243,527 @@ func (m *MongoDB) CreateNewBook(book *lib.Book) error {
 	if inDb {
 		return lib.BookAlreadyExists
 	}
-	book.UpdatedDate = time.Now().Format(lib.DbTimeFormat)
+	book.ID = ""
+	book.Rating = nil
+	book.CreatedAt = m.clock.Now()
+	book.UpdatedAt = book.CreatedAt
+	err = m.storeContents(book, strings.NewReader(book.Contents))
+	if err != nil {
+		return err
+	}
 
-	_, err = m.collection.InsertOne(context.Background(), book)
+	result, err := m.collection.InsertOne(context.Background(), book)
 	if err != nil {
+		m.deleteContentsFile(book.ContentsFile)
+		if mongo.IsDuplicateKeyError(err) {
+			return lib.BookAlreadyExists // created concurrently
+		}
 		log.Println("cant insert document", err.Error())
 		return err
 	}
-	return nil
+	if insertedID, ok := result.InsertedID.(primitive.ObjectID); ok {
+		book.ID = insertedID.Hex()
+	}
+	_, err = m.recordRevision(book, 1)
+	return err
 }
 
-// UpdateExistingBook updates existing  book in db
+// UpdateExistingBook updates existing  book in db, matched by id when given otherwise by name and author
 func (m *MongoDB) UpdateExistingBook(book *lib.Book) error {
-
-	inDb, err := m.isBookInDb(lib.BookIdentifier{
+	err := m.linkAuthor(book)
+	if err != nil {
+		return err
+	}
+	existing, err := m.GetOneBook(&lib.BookIdentifier{
+		ID:     book.ID,
 		Name:   book.Name,
 		Author: book.Author,
 	})
 	if err != nil {
 		return err
 	}
-	if !inDb {
-		return lib.NoMatchingBook
+	if existing.Name != book.Name || existing.Author != book.Author {
+		// renaming a book to another case or accents of its own name is no clash
+		clash, err := m.GetOneBookWithFields(&lib.BookIdentifier{Name: book.Name, Author: book.Author}, nil)
+		if err == nil && clash.ID != existing.ID {
+			return lib.BookAlreadyExists
+		}
+		if err != nil && !errors.Is(err, lib.NoMatchingBook) {
+			return err
+		}
 	}
-	book.UpdatedDate = time.Now().Format(lib.DbTimeFormat)
+	latest, err := m.latestRevision(existing)
+	if err != nil {
+		return err
+	}
+	book.DeletedAt = existing.DeletedAt
+	book.CreatedAt = existing.CreatedAt
+	book.UpdatedAt = m.clock.Now()
+	match, err := m.identifierFilter(existing.Identifier())
+	if err != nil {
+		return err
+	}
+	err = m.storeContents(book, strings.NewReader(book.Contents))
+	if err != nil {
+		return err
+	}
+	changed := m.shareRevisionContents(book, latest)
 
+	changes, err := bookUpdate(book)
+	if err != nil {
+		if changed {
+			m.deleteContentsFile(book.ContentsFile)
+		}
+		return err
+	}
 	_, err = m.collection.UpdateOne(
 		context.Background(),
-		bson.M{lib.JsonBsonTagName: book.Name, lib.JsonBsonTagAuthor: book.Author},
-		bson.M{"$set": book},
+		match,
+		changes,
 	)
 	if err != nil {
+		if changed {
+			m.deleteContentsFile(book.ContentsFile) // the new contents are referenced by no book nor revision
+		}
 		if errors.Is(mongo.ErrNoDocuments, err) {
 			return lib.NoMatchingBook
 		}
+		if mongo.IsDuplicateKeyError(err) {
+			return lib.BookAlreadyExists // renamed concurrently to the same name
+		}
 		return err
 	}
-	return nil
+	book.ID = existing.ID
+	book.Rating = existing.Rating
+	if changed {
+		_, err = m.recordRevision(book, latest.Number+1)
+		if err != nil {
+			return err
+		}
+	}
+	return m.reanchorBookmarks(book.ID)
 }
 
-// DeleteBook deletes existing book given Identifier
+// clearableBookFields are the fields of a book left out of a $set when empty, cleared by an update leaving them out
+var clearableBookFields = []string{lib.JsonBsonTagAuthorID, lib.JsonBsonTagISBN10, lib.JsonBsonTagISBN13, lib.JsonBsonTagContributors,
+	lib.JsonBsonTagPublisher, lib.JsonBsonTagPublicationDate, lib.JsonBsonTagLanguage, lib.JsonBsonTagPageCount, lib.JsonBsonTagSeries,
+	lib.JsonBsonTagVolume, lib.JsonBsonTagTags, lib.JsonBsonTagChapterPattern}
+
+// bookUpdate returns the update replacing a stored book with a book, as MockDB replaces it: the fields of the book are
+// set and the metadata it leaves out cleared, but for the id, the rating kept by reviews and the trash state
+func bookUpdate(book *lib.Book) (bson.M, error) {
+	document, err := bson.Marshal(book)
+	if err != nil {
+		return nil, err
+	}
+	set := bson.M{}
+	err = bson.Unmarshal(document, &set)
+	if err != nil {
+		return nil, err
+	}
+	delete(set, lib.JsonBsonTagID)
+	delete(set, lib.JsonBsonTagRating)
+	delete(set, lib.JsonBsonTagDeletedAt)
+	unset := bson.M{}
+	for _, field := range clearableBookFields {
+		if _, found := set[field]; !found {
+			unset[field] = ""
+		}
+	}
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	return update, nil
+}
+
+// DeleteBook moves existing book given Identifier to the trash, it keeps its contents, attachments and shelves until purged
 func (m *MongoDB) DeleteBook(bookIdentifier *lib.BookIdentifier) error {
-	inDb, err := m.isBookInDb(lib.BookIdentifier{
-		Name:   bookIdentifier.Name,
-		Author: bookIdentifier.Author,
+	match, err := m.identifierFilter(*bookIdentifier)
+	if err != nil {
+		return err
+	}
+	trashed := &lib.Book{}
+	err = m.collection.FindOneAndUpdate(context.Background(), match, bson.M{"$set": bson.M{lib.JsonBsonTagDeletedAt: m.clock.Now()}},
+		options.FindOneAndUpdate().SetProjection(fieldsProjection(nil)).SetCollation(nameCollation)).Decode(trashed)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return lib.NoMatchingBook
+		}
+		return err
+	}
+	log.Println("trashed", trashed.ID)
+	return nil
+}
+
+// GetBookContents streams the contents of a book, from GridFS when too large to be stored inline
+func (m *MongoDB) GetBookContents(bookIdentifier *lib.BookIdentifier) (ContentsReader, error) {
+	book, err := m.GetOneBook(bookIdentifier)
+	if err != nil {
+		return nil, err
+	}
+	return m.openContents(book.Contents, book.ContentsFile)
+}
+
+// StoreBookContents replaces the contents of a book from a stream, large contents are streamed into GridFS
+func (m *MongoDB) StoreBookContents(bookIdentifier *lib.BookIdentifier, contents io.Reader) error {
+	existing, err := m.GetOneBook(bookIdentifier)
+	if err != nil {
+		return err
+	}
+
+	latest, err := m.latestRevision(existing)
+	if err != nil {
+		return err
+	}
+	match, err := m.identifierFilter(existing.Identifier())
+	if err != nil {
+		return err
+	}
+	book := &lib.Book{ID: existing.ID, Name: existing.Name, ChapterPattern: existing.ChapterPattern, UpdatedAt: m.clock.Now()}
+	err = m.storeContents(book, contents)
+	if err != nil {
+		return err
+	}
+	changed := m.shareRevisionContents(book, latest)
+
+	_, err = m.collection.UpdateOne(context.Background(), match, bson.M{"$set": bson.M{
+		lib.JsonBsonTagContents:       book.Contents,
+		lib.JsonBsonTagContentsFile:   book.ContentsFile,
+		lib.JsonBsonTagContentsLength: book.ContentsLength,
+		lib.JsonBsonTagStructure:      book.Structure,
+		lib.JsonBsonTagStats:          book.Stats,
+		lib.JsonBsonTagTerms:          book.Terms,
+		lib.JsonBsonTagContentsHash:   book.ContentsHash,
+		lib.JsonBsonTagUpdatedAt:      book.UpdatedAt,
+	}})
+	if err != nil {
+		if changed {
+			m.deleteContentsFile(book.ContentsFile)
+		}
+		return err
+	}
+	if changed {
+		_, err = m.recordRevision(book, latest.Number+1)
+		if err != nil {
+			return err
+		}
+	}
+	return m.reanchorBookmarks(existing.ID)
+}
+
+// Library returns a handler to the library of the given name, each library is stored in its own collection
+func (m *MongoDB) Library(name string) (RestDbInterface, error) {
+	if name == lib.DefaultLibraryName {
+		return m.withCollection(m.collectionName), nil
+	}
+	inDb, err := m.isLibraryInDb(name)
+	if err != nil {
+		return nil, err
+	}
+	if !inDb {
+		return nil, lib.NoMatchingLibrary
+	}
+	return m.withCollection(m.libraryCollectionName(name)), nil
+}
+
+// GetAllLibraries lists the default library and every created library
+func (m *MongoDB) GetAllLibraries() ([]lib.Library, error) {
+	cursor, err := m.libraries.Find(context.Background(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var result []lib.Library
+	err = cursor.All(context.Background(), &result)
+	if err != nil {
+		return nil, err
+	}
+	return append([]lib.Library{{Name: lib.DefaultLibraryName}}, result...), nil
+}
+
+// CreateLibrary registers a new library
+func (m *MongoDB) CreateLibrary(name string) error {
+	if !lib.IsValidLibraryName(name) {
+		return lib.IncorrectLibraryName
+	}
+	if name == lib.DefaultLibraryName {
+		return lib.LibraryAlreadyExists
+	}
+	inDb, err := m.isLibraryInDb(name)
+	if err != nil {
+		return err
+	}
+	if inDb {
+		return lib.LibraryAlreadyExists
+	}
+
+	err = m.withCollection(m.libraryCollectionName(name)).ensureIndexes()
+	if err != nil {
+		return err
+	}
+	_, err = m.libraries.InsertOne(context.Background(), lib.Library{
+		Name:      name,
+		CreatedAt: m.clock.Now(),
 	})
+	return err
+}
+
+// DropLibrary removes a library from the registry and drops its collection
+func (m *MongoDB) DropLibrary(name string) error {
+	if name == lib.DefaultLibraryName {
+		return lib.CannotDropDefaultLibrary
+	}
+	inDb, err := m.isLibraryInDb(name)
 	if err != nil {
 		return err
 	}
 	if !inDb {
-		return lib.NoMatchingBook
+		return lib.NoMatchingLibrary
 	}
 
-	match := bson.M{lib.JsonBsonTagName: bookIdentifier.Name, lib.JsonBsonTagAuthor: bookIdentifier.Author}
-	result, err := m.collection.DeleteOne(context.Background(), match)
+	library := m.withCollection(m.libraryCollectionName(name))
+	err = library.collection.Drop(context.Background())
+	if err != nil {
+		return err
+	}
+	bucket, err := library.contentsBucket()
 	if err != nil {
-		if errors.Is(mongo.ErrNoDocuments, err) {
-			return lib.NoMatchingBook
-		}
 		return err
 	}
-	log.Println("deleted no.", result.DeletedCount)
+	err = bucket.Drop()
+	if err != nil {
+		return err
+	}
+	err = library.attachmentsCollection().Drop(context.Background())
+	if err != nil {
+		return err
+	}
+	err = library.authorsCollection().Drop(context.Background())
+	if err != nil {
+		return err
+	}
+	err = library.reviewsCollection().Drop(context.Background())
+	if err != nil {
+		return err
+	}
+	err = library.shelvesCollection().Drop(context.Background())
+	if err != nil {
+		return err
+	}
+	err = library.progressCollection().Drop(context.Background())
+	if err != nil {
+		return err
+	}
+	err = library.bookmarksCollection().Drop(context.Background())
+	if err != nil {
+		return err
+	}
+	err = library.revisionsCollection().Drop(context.Background())
+	if err != nil {
+		return err
+	}
+	_, err = m.libraries.DeleteOne(context.Background(), bson.M{lib.JsonBsonTagName: name})
+	return err
+}
 
+// storeContents sets contents on the book, inline when under the threshold, otherwise uploaded to GridFS
+func (m *MongoDB) storeContents(book *lib.Book, contents io.Reader) error {
+	analyser, err := newContentsAnalyser(book)
+	if err != nil {
+		return err
+	}
+	inline, large, err := peekInline(io.TeeReader(contents, analyser))
+	if err != nil {
+		return err
+	}
+	book.Contents = inline
+	book.ContentsFile = ""
+	book.ContentsLength = int64(len(inline))
+	if large == nil {
+		analyser.apply(book)
+		return nil
+	}
+
+	bucket, err := m.contentsBucket()
+	if err != nil {
+		return err
+	}
+	upload, err := bucket.OpenUploadStream(book.Name, options.GridFSUpload().SetChunkSizeBytes(int32(ContentsChunkSize)))
+	if err != nil {
+		return err
+	}
+	length, err := io.Copy(upload, large)
+	if err != nil {
+		_ = upload.Abort()
+		return err
+	}
+	err = upload.Close()
+	if err != nil {
+		return err
+	}
+	fileID, ok := upload.FileID.(primitive.ObjectID)
+	if !ok {
+		return errors.New("unexpected GridFS file id")
+	}
+	book.ContentsFile = fileID.Hex()
+	book.ContentsLength = length
+	analyser.apply(book)
 	return nil
 }
 
+// openContents streams contents stored inline, or in the given GridFS file when one is given
+func (m *MongoDB) openContents(inline, contentsFile string) (ContentsReader, error) {
+	if contentsFile == "" {
+		return newInlineContents(inline), nil
+	}
+
+	fileID, err := primitive.ObjectIDFromHex(contentsFile)
+	if err != nil {
+		return nil, err
+	}
+	bucket, err := m.contentsBucket()
+	if err != nil {
+		return nil, err
+	}
+	stream, err := bucket.OpenDownloadStream(fileID)
+	if err != nil {
+		return nil, err
+	}
+	return &gridFSContents{DownloadStream: stream}, nil
+}
+
+// deleteContentsFile removes a no longer referenced contents file from GridFS, failures are only logged
+func (m *MongoDB) deleteContentsFile(contentsFile string) {
+	if contentsFile == "" {
+		return
+	}
+	fileID, err := primitive.ObjectIDFromHex(contentsFile)
+	if err != nil {
+		log.Println("bad contents file id", contentsFile)
+		return
+	}
+	bucket, err := m.contentsBucket()
+	if err != nil {
+		log.Println("cant open contents bucket", err.Error())
+		return
+	}
+	err = bucket.Delete(fileID)
+	if err != nil {
+		log.Println("cant delete contents file", err.Error())
+	}
+}
+
+// contentsBucket returns the GridFS bucket holding large contents of this library, named after its collection
+func (m *MongoDB) contentsBucket() (*gridfs.Bucket, error) {
+	return gridfs.NewBucket(m.database, options.GridFSBucket().SetName(m.collection.Name()))
+}
+
+// gridFSContents streams contents from a GridFS file.
+type gridFSContents struct {
+	*gridfs.DownloadStream
+}
+
+func (c *gridFSContents) Size() int64 {
+	return c.GetFile().Length
+}
+
+// withCollection returns a copy of the handler bound to another collection of the same database
+func (m *MongoDB) withCollection(collectionName string) *MongoDB {
+	library := *m
+	library.collection = m.database.Collection(collectionName)
+	return &library
+}
+
+// ensureLibraryIndexes creates the indexes of the default library and of every created library, when opening the handler
+func (m *MongoDB) ensureLibraryIndexes() error {
+	err := m.ensureIndexes()
+	if err != nil {
+		return err
+	}
+	libraries, err := m.GetAllLibraries()
+	if err != nil {
+		return err
+	}
+	for _, library := range libraries[1:] {
+		err = m.withCollection(m.libraryCollectionName(library.Name)).ensureIndexes()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureIndexes creates the indexes of the collections of a library unless they exist. Active books are unique by
+// name and author under nameCollation, books in the trash being told apart by when they were deleted.
+func (m *MongoDB) ensureIndexes() error {
+	indexes := []struct {
+		collection *mongo.Collection
+		model      mongo.IndexModel
+	}{
+		{m.collection, mongo.IndexModel{
+			Keys:    bson.D{{Key: lib.JsonBsonTagName, Value: 1}, {Key: lib.JsonBsonTagAuthor, Value: 1}, {Key: lib.JsonBsonTagDeletedAt, Value: 1}},
+			Options: options.Index().SetCollation(nameCollation).SetUnique(true),
+		}},
+		{m.authorsCollection(), mongo.IndexModel{
+			Keys:    bson.M{lib.JsonBsonTagAuthorKeys: 1},
+			Options: options.Index().SetUnique(true),
+		}},
+		{m.reviewsCollection(), mongo.IndexModel{
+			Keys:    bson.D{{Key: lib.JsonBsonTagBookID, Value: 1}, {Key: lib.JsonBsonTagUserID, Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}},
+		{m.shelvesCollection(), mongo.IndexModel{Keys: bson.M{lib.JsonBsonTagBookIDs: 1}}},
+		{m.progressCollection(), mongo.IndexModel{
+			Keys:    bson.D{{Key: lib.JsonBsonTagBookID, Value: 1}, {Key: lib.JsonBsonTagUserID, Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}},
+		{m.bookmarksCollection(), mongo.IndexModel{
+			Keys: bson.D{{Key: lib.JsonBsonTagBookID, Value: 1}, {Key: lib.JsonBsonTagUserID, Value: 1}, {Key: lib.JsonBsonTagClientID, Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{lib.JsonBsonTagClientID: bson.M{"$exists": true}}),
+		}},
+		{m.revisionsCollection(), mongo.IndexModel{
+			Keys:    bson.D{{Key: lib.JsonBsonTagBookID, Value: 1}, {Key: lib.JsonBsonTagNumber, Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}},
+	}
+	for _, index := range indexes {
+		_, err := index.collection.Indexes().CreateOne(context.Background(), index.model)
+		if err != nil {
+			log.Println("cant create index on", index.collection.Name(), err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+// libraryCollectionName returns the collection name used by a non default library
+func (m *MongoDB) libraryCollectionName(name string) string {
+	return m.collectionName + "_" + name
+}
+
+// isLibraryInDb checks to see if library is registered
+func (m *MongoDB) isLibraryInDb(name string) (bool, error) {
+	count, err := m.libraries.CountDocuments(context.Background(), bson.M{lib.JsonBsonTagName: name})
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
 // isBookInDb checks to see if book Is in DB
 func (m *MongoDB) isBookInDb(book lib.BookIdentifier) (bool, error) {
 	projection := bson.M{lib.JsonBsonTagName: 1, lib.JsonBsonTagAuthor: 1}
-	match := bson.M{lib.JsonBsonTagName: book.Name, lib.JsonBsonTagAuthor: book.Author}
-	cursor := m.collection.FindOne(context.Background(), match, options.FindOne().SetProjection(projection))
+	match, err := m.identifierFilter(book)
+	if err != nil {
+		if errors.Is(err, lib.NoMatchingBook) {
+			return false, nil
+		}
+		return false, err
+	}
+	cursor := m.collection.FindOne(context.Background(), match, options.FindOne().SetProjection(projection).SetCollation(nameCollation))
 	if cursor.Err() != nil {
 		if errors.Is(cursor.Err(), mongo.ErrNoDocuments) {
 			return false, nil
@@ -184,3 +773,75 @@ func (m *MongoDB) isBookInDb(book lib.BookIdentifier) (bool, error) {
 
 	return true, nil
 }
+
+// bookFilterQuery returns a query matching every set field of the filter
+func bookFilterQuery(filter lib.BookFilter) bson.M {
+	query := bson.M{lib.JsonBsonTagDeletedAt: notInTrash}
+	if filter.ISBN != "" {
+		query[lib.JsonBsonTagISBN13] = filter.ISBN
+	}
+	if filter.Author != "" {
+		query["$or"] = bson.A{
+			bson.M{lib.JsonBsonTagAuthor: filter.Author},
+			bson.M{lib.JsonBsonTagContributors + "." + lib.JsonBsonTagName: filter.Author},
+		}
+	}
+	if filter.AuthorID != "" {
+		query[lib.JsonBsonTagAuthorID] = filter.AuthorID
+	}
+	if filter.Publisher != "" {
+		query[lib.JsonBsonTagPublisher] = filter.Publisher
+	}
+	if filter.Language != "" {
+		query[lib.JsonBsonTagLanguage] = filter.Language
+	}
+	if filter.Series != "" {
+		query[lib.JsonBsonTagSeries] = filter.Series
+	}
+	if filter.Year != "" {
+		query[lib.JsonBsonTagPublicationDate] = bson.M{"$regex": "^" + regexp.QuoteMeta(filter.Year)}
+	}
+	if len(filter.Tags) > 0 {
+		query[lib.JsonBsonTagTags] = bson.M{"$all": filter.Tags}
+	}
+	updated := bson.M{}
+	if !filter.UpdatedAfter.IsZero() {
+		updated["$gt"] = filter.UpdatedAfter
+	}
+	if !filter.UpdatedBefore.IsZero() {
+		updated["$lt"] = filter.UpdatedBefore
+	}
+	if len(updated) > 0 {
+		query[lib.JsonBsonTagUpdatedAt] = updated
+	}
+	return query
+}
+
+// fieldsProjection returns a projection of the given fields, the id is always included
+func fieldsProjection(fields []string) bson.M {
+	projection := bson.M{lib.JsonBsonTagID: 1}
+	for _, field := range fields {
+		projection[field] = 1
+	}
+	return projection
+}
+
+// nameCollation matches the names and authors of books ignoring case and accents, as lib.SameName does
+var nameCollation = &options.Collation{Locale: "en", Strength: 1}
+
+// identifierFilter returns a filter matching a book not in the trash by the id of the identifier when given, otherwise by its name and author,
+// an author known by an alias being matched by its canonical name. Queries by name and author use nameCollation.
+func (m *MongoDB) identifierFilter(bookIdentifier lib.BookIdentifier) (bson.M, error) {
+	if bookIdentifier.ID == "" {
+		author, err := m.canonicalAuthor(bookIdentifier.Author)
+		if err != nil {
+			return nil, err
+		}
+		return bson.M{lib.JsonBsonTagName: bookIdentifier.Name, lib.JsonBsonTagAuthor: author, lib.JsonBsonTagDeletedAt: notInTrash}, nil
+	}
+	id, err := primitive.ObjectIDFromHex(bookIdentifier.ID)
+	if err != nil {
+		return nil, lib.NoMatchingBook // not an id this db could have given out
+	}
+	return bson.M{lib.JsonBsonTagID: id, lib.JsonBsonTagDeletedAt: notInTrash}, nil
+}
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"context"
+	"dockerrestapi/lib"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"log"
+	"time"
+)
+
+const (
+	legacyTimeFormat      = time.UnixDate // format of the string timestamps written by earlier versions
+	legacyBookUpdatedDate = "updateDate"
+	legacyCreatedDate     = "createDate"
+)
+
+// MigrateMongoTimestamps converts the UnixDate string timestamps written by earlier versions into native dates,
+// in the library registry and in the book and attachment collections of every library. It returns the number of migrated documents
+// and is safe to run more than once, as only documents still holding a string timestamp are touched.
+func MigrateMongoTimestamps(dsn, databaseName, collectionName string) (int64, error) {
+	client, err := connectMongo(dsn)
+	if err != nil {
+		return 0, err
+	}
+	defer disconnectMongo(client)
+
+	handler := &MongoDB{
+		clock:          lib.SystemClock{},
+		client:         client,
+		database:       client.Database(databaseName),
+		collection:     client.Database(databaseName).Collection(collectionName),
+		collectionName: collectionName,
+	}
+	handler.libraries = handler.database.Collection(librariesCollectionName)
+
+	// the registry is migrated first, GetAllLibraries decodes it into native dates
+	migrated, err := migrateStringTimestamp(handler.libraries, legacyCreatedDate, lib.JsonBsonTagCreatedAt)
+	if err != nil {
+		return migrated, err
+	}
+
+	libraries, err := handler.GetAllLibraries()
+	if err != nil {
+		return migrated, err
+	}
+	for _, library := range libraries {
+		collection := handler.collection
+		if library.Name != lib.DefaultLibraryName {
+			collection = handler.database.Collection(handler.libraryCollectionName(library.Name))
+		}
+		books, err := migrateStringTimestamp(collection, legacyBookUpdatedDate, lib.JsonBsonTagUpdatedAt, lib.JsonBsonTagCreatedAt)
+		migrated += books
+		if err != nil {
+			return migrated, err
+		}
+		attachments, err := migrateStringTimestamp(handler.withCollection(collection.Name()).attachmentsCollection(), legacyCreatedDate, lib.JsonBsonTagCreatedAt)
+		migrated += attachments
+		if err != nil {
+			return migrated, err
+		}
+		log.Println("migrated timestamps of library", library.Name)
+	}
+	return migrated, nil
+}
+
+// migrateStringTimestamp replaces a legacy string field with a native date in each of the new fields,
+// new fields that are already set are kept. The earliest known time is the best guess for a creation date.
+func migrateStringTimestamp(collection *mongo.Collection, legacyField string, newFields ...string) (int64, error) {
+	cursor, err := collection.Find(context.Background(), bson.M{legacyField: bson.M{"$type": "string"}})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(context.Background())
+
+	var migrated int64
+	for cursor.Next(context.Background()) {
+		var document bson.M
+		err = cursor.Decode(&document)
+		if err != nil {
+			return migrated, err
+		}
+		legacyValue, _ := document[legacyField].(string)
+		timestamp, err := time.Parse(legacyTimeFormat, legacyValue)
+		if err != nil {
+			log.Println("cant parse", legacyField, legacyValue, "of", document[lib.JsonBsonTagID], "leaving it as is")
+			continue
+		}
+
+		set := bson.M{}
+		for _, field := range newFields {
+			if _, exists := document[field]; !exists {
+				set[field] = timestamp.UTC()
+			}
+		}
+		update := bson.M{"$unset": bson.M{legacyField: ""}}
+		if len(set) > 0 {
+			update["$set"] = set
+		}
+		_, err = collection.UpdateOne(context.Background(), bson.M{lib.JsonBsonTagID: document[lib.JsonBsonTagID]}, update)
+		if err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+	return migrated, cursor.Err()
+}
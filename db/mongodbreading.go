@@ -0,0 +1,304 @@
+package db
+
+import (
+	"context"
+	"dockerrestapi/lib"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"io"
+	"log"
+	"time"
+)
+
+// GetReadingProgress retrieves how far a user read a book
+func (m *MongoDB) GetReadingProgress(bookID, userID string) (*lib.ReadingProgress, error) {
+	inDb, err := m.isBookInDb(lib.BookIdentifier{ID: bookID})
+	if err != nil {
+		return nil, err
+	}
+	if !inDb {
+		return nil, lib.NoMatchingBook
+	}
+	progress := &lib.ReadingProgress{}
+	err = m.progressCollection().FindOne(context.Background(), bson.M{lib.JsonBsonTagBookID: bookID, lib.JsonBsonTagUserID: userID}).Decode(progress)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, lib.NoMatchingProgress
+		}
+		return nil, err
+	}
+	return progress, nil
+}
+
+// SaveReadingProgress locates the progress of a user in the contents and stores it, unless a more recent progress is stored.
+// The progress is upserted only over an older one, the unique index on the book and user refusing the insert otherwise.
+func (m *MongoDB) SaveReadingProgress(progress *lib.ReadingProgress) error {
+	if progress.UserID == "" {
+		return lib.IncompleteReading
+	}
+	text, err := m.bookText(progress.BookID)
+	if err != nil {
+		return err
+	}
+	err = progress.Locate(text)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.progressCollection().UpdateOne(context.Background(),
+		bson.M{lib.JsonBsonTagBookID: progress.BookID, lib.JsonBsonTagUserID: progress.UserID, lib.JsonBsonTagUpdatedAt: bson.M{"$lt": progress.UpdatedAt}},
+		bson.M{"$set": progress},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		stored, err := m.GetReadingProgress(progress.BookID, progress.UserID)
+		if err != nil {
+			return err
+		}
+		*progress = *stored
+		return nil
+	}
+	return err
+}
+
+// GetAllBookmarks retrieves the bookmarks of a user on a book in reading order, the ones changed after since
+// including deleted ones when given
+func (m *MongoDB) GetAllBookmarks(bookID, userID string, since *time.Time) ([]lib.Bookmark, error) {
+	inDb, err := m.isBookInDb(lib.BookIdentifier{ID: bookID})
+	if err != nil {
+		return nil, err
+	}
+	if !inDb {
+		return nil, lib.NoMatchingBook
+	}
+	query := bson.M{lib.JsonBsonTagBookID: bookID, lib.JsonBsonTagUserID: userID}
+	if since == nil {
+		query[lib.JsonBsonTagDeletedAt] = bson.M{"$exists": false}
+	} else {
+		query[lib.JsonBsonTagUpdatedAt] = bson.M{"$gt": *since}
+	}
+	cursor, err := m.bookmarksCollection().Find(context.Background(), query,
+		options.Find().SetSort(bson.D{{Key: lib.JsonBsonTagStart, Value: 1}, {Key: lib.JsonBsonTagID, Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	results := []lib.Bookmark{}
+	err = cursor.All(context.Background(), &results)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// GetOneBookmark retrieves a single bookmark of a book given its id
+func (m *MongoDB) GetOneBookmark(bookID, bookmarkID string) (*lib.Bookmark, error) {
+	inDb, err := m.isBookInDb(lib.BookIdentifier{ID: bookID})
+	if err != nil {
+		return nil, err
+	}
+	if !inDb {
+		return nil, lib.NoMatchingBook
+	}
+	objectID, err := primitive.ObjectIDFromHex(bookmarkID)
+	if err != nil {
+		return nil, lib.NoMatchingBookmark
+	}
+	bookmark := &lib.Bookmark{}
+	err = m.bookmarksCollection().FindOne(context.Background(), bson.M{lib.JsonBsonTagID: objectID, lib.JsonBsonTagBookID: bookID}).Decode(bookmark)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, lib.NoMatchingBookmark
+		}
+		return nil, err
+	}
+	return bookmark, nil
+}
+
+// CreateNewBookmark anchors a bookmark in the contents and stores it, a unique index on the book, user and client id
+// returning the bookmark already created by a client sending it again
+func (m *MongoDB) CreateNewBookmark(bookmark *lib.Bookmark) error {
+	err := bookmark.Normalise()
+	if err != nil {
+		return err
+	}
+	text, err := m.bookText(bookmark.BookID)
+	if err != nil {
+		return err
+	}
+
+	bookmark.Anchor(text)
+	bookmark.ID = ""
+	bookmark.CreatedAt = m.clock.Now()
+	if bookmark.UpdatedAt.IsZero() {
+		bookmark.UpdatedAt = bookmark.CreatedAt
+	}
+	bookmark.DeletedAt = nil
+	result, err := m.bookmarksCollection().InsertOne(context.Background(), bookmark)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return m.bookmarksCollection().FindOne(context.Background(), bson.M{
+				lib.JsonBsonTagBookID:   bookmark.BookID,
+				lib.JsonBsonTagUserID:   bookmark.UserID,
+				lib.JsonBsonTagClientID: bookmark.ClientID,
+			}).Decode(bookmark)
+		}
+		return err
+	}
+	if insertedID, ok := result.InsertedID.(primitive.ObjectID); ok {
+		bookmark.ID = insertedID.Hex()
+	}
+	return nil
+}
+
+// UpdateExistingBookmark changes the range and note of a bookmark, unless changed more recently, deleted bookmarks coming back
+func (m *MongoDB) UpdateExistingBookmark(bookmark *lib.Bookmark) error {
+	existing, err := m.GetOneBookmark(bookmark.BookID, bookmark.ID)
+	if err != nil {
+		return err
+	}
+	if !existing.UpdatedAt.Before(bookmark.UpdatedAt) {
+		*bookmark = *existing
+		return nil
+	}
+	updated := *existing
+	updated.Start = bookmark.Start
+	updated.End = bookmark.End
+	updated.Note = bookmark.Note
+	err = updated.Normalise()
+	if err != nil {
+		return err
+	}
+	text, err := m.bookText(updated.BookID)
+	if err != nil {
+		return err
+	}
+	updated.Anchor(text)
+	updated.UpdatedAt = bookmark.UpdatedAt
+	updated.DeletedAt = nil
+
+	changed, err := m.setBookmark(updated, bson.M{"$lt": updated.UpdatedAt})
+	if err != nil {
+		return err
+	}
+	if !changed {
+		stored, err := m.GetOneBookmark(bookmark.BookID, bookmark.ID)
+		if err != nil {
+			return err
+		}
+		updated = *stored
+	}
+	*bookmark = updated
+	return nil
+}
+
+// DeleteBookmark replaces a bookmark with a tombstone, unless changed more recently
+func (m *MongoDB) DeleteBookmark(bookID, bookmarkID string, deletedAt time.Time) (*lib.Bookmark, error) {
+	bookmark, err := m.GetOneBookmark(bookID, bookmarkID)
+	if err != nil {
+		return nil, err
+	}
+	objectID, err := primitive.ObjectIDFromHex(bookmark.ID)
+	if err != nil {
+		return nil, lib.NoMatchingBookmark
+	}
+	_, err = m.bookmarksCollection().UpdateOne(context.Background(),
+		bson.M{lib.JsonBsonTagID: objectID, lib.JsonBsonTagDeletedAt: bson.M{"$exists": false}, lib.JsonBsonTagUpdatedAt: bson.M{"$lt": deletedAt}},
+		bson.M{"$set": bson.M{lib.JsonBsonTagDeletedAt: deletedAt, lib.JsonBsonTagUpdatedAt: deletedAt}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return m.GetOneBookmark(bookID, bookmarkID)
+}
+
+// reanchorBookmarks finds the place of the bookmarks of a book in its new contents,
+// bookmarks changed meanwhile being left to the change
+func (m *MongoDB) reanchorBookmarks(bookID string) error {
+	cursor, err := m.bookmarksCollection().Find(context.Background(),
+		bson.M{lib.JsonBsonTagBookID: bookID, lib.JsonBsonTagDeletedAt: bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(context.Background())
+	var bookmarks []lib.Bookmark
+	err = cursor.All(context.Background(), &bookmarks)
+	if err != nil || len(bookmarks) == 0 {
+		return err
+	}
+
+	text, err := m.bookText(bookID)
+	if err != nil {
+		return err
+	}
+	now := m.clock.Now()
+	for _, bookmark := range bookmarks {
+		read := bookmark.UpdatedAt
+		if !bookmark.Reanchor(text) {
+			log.Println("orphaned bookmark", bookmark.ID)
+		}
+		bookmark.UpdatedAt = now
+		_, err = m.setBookmark(bookmark, read)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setBookmark writes the range, note and anchor of a bookmark when its stored update time matches,
+// returning false when it does not
+func (m *MongoDB) setBookmark(bookmark lib.Bookmark, updatedAt any) (bool, error) {
+	objectID, err := primitive.ObjectIDFromHex(bookmark.ID)
+	if err != nil {
+		return false, lib.NoMatchingBookmark
+	}
+	result, err := m.bookmarksCollection().UpdateOne(context.Background(),
+		bson.M{lib.JsonBsonTagID: objectID, lib.JsonBsonTagUpdatedAt: updatedAt},
+		bson.M{
+			"$set": bson.M{
+				lib.JsonBsonTagStart:     bookmark.Start,
+				"end":                    bookmark.End,
+				"note":                   bookmark.Note,
+				"quote":                  bookmark.Quote,
+				"prefix":                 bookmark.Prefix,
+				"suffix":                 bookmark.Suffix,
+				"orphaned":               bookmark.Orphaned,
+				lib.JsonBsonTagUpdatedAt: bookmark.UpdatedAt,
+			},
+			"$unset": bson.M{lib.JsonBsonTagDeletedAt: ""},
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
+// bookText reads the whole contents of a book not in the trash
+func (m *MongoDB) bookText(bookID string) (string, error) {
+	contents, err := m.GetBookContents(&lib.BookIdentifier{ID: bookID})
+	if err != nil {
+		return "", err
+	}
+	defer contents.Close()
+	text, err := io.ReadAll(contents)
+	if err != nil {
+		return "", err
+	}
+	return string(text), nil
+}
+
+// progressCollection returns the collection holding the reading progress of the users of this library
+func (m *MongoDB) progressCollection() *mongo.Collection {
+	return m.database.Collection(m.collection.Name() + "_progress")
+}
+
+// bookmarksCollection returns the collection holding the bookmarks of the users of this library
+func (m *MongoDB) bookmarksCollection() *mongo.Collection {
+	return m.database.Collection(m.collection.Name() + "_bookmarks")
+}
@@ -0,0 +1,264 @@
+package db
+
+import (
+	"context"
+	"dockerrestapi/lib"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetAllReviews retrieves a page of the reviews of a book, most recent or most helpful first
+func (m *MongoDB) GetAllReviews(bookID string, query lib.ReviewQuery) (*lib.ReviewPage, error) {
+	inDb, err := m.isBookInDb(lib.BookIdentifier{ID: bookID})
+	if err != nil {
+		return nil, err
+	}
+	if !inDb {
+		return nil, lib.NoMatchingBook
+	}
+
+	filter := bson.M{lib.JsonBsonTagBookID: bookID, lib.JsonBsonTagStatus: bson.M{"$ne": lib.ReviewRejected}}
+	if query.Status != "" {
+		filter[lib.JsonBsonTagStatus] = query.Status
+	}
+	total, err := m.reviewsCollection().CountDocuments(context.Background(), filter)
+	if err != nil {
+		return nil, err
+	}
+	order := bson.D{{Key: lib.JsonBsonTagCreatedAt, Value: -1}, {Key: lib.JsonBsonTagID, Value: -1}}
+	if query.Sort == lib.ReviewSortHelpful {
+		order = append(bson.D{{Key: lib.JsonBsonTagHelpful, Value: -1}}, order...)
+	}
+	findOptions := options.Find().SetSort(order).SetSkip(int64(query.Offset)).SetLimit(int64(query.Limit))
+	cursor, err := m.reviewsCollection().Find(context.Background(), filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	page := &lib.ReviewPage{Reviews: []lib.Review{}, Total: int(total), Offset: query.Offset, Limit: query.Limit}
+	err = cursor.All(context.Background(), &page.Reviews)
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// GetOneReview retrieves a single review of a book
+func (m *MongoDB) GetOneReview(bookID, reviewID string) (*lib.Review, error) {
+	match, err := m.reviewFilter(bookID, reviewID)
+	if err != nil {
+		return nil, err
+	}
+	review := &lib.Review{}
+	err = m.reviewsCollection().FindOne(context.Background(), match).Decode(review)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, lib.NoMatchingReview
+		}
+		return nil, err
+	}
+	return review, nil
+}
+
+// CreateNewReview stores the review of a user on a book and counts its stars in the rating of the book,
+// a unique index on the book and user keeping concurrent reviews of a user apart
+func (m *MongoDB) CreateNewReview(review *lib.Review) error {
+	err := review.Normalise()
+	if err != nil {
+		return err
+	}
+	inDb, err := m.isBookInDb(lib.BookIdentifier{ID: review.BookID})
+	if err != nil {
+		return err
+	}
+	if !inDb {
+		return lib.NoMatchingBook
+	}
+
+	review.ID = ""
+	review.Status = lib.ReviewPending
+	review.Helpful = 0
+	review.Voters = nil
+	review.CreatedAt = m.clock.Now()
+	review.UpdatedAt = review.CreatedAt
+	result, err := m.reviewsCollection().InsertOne(context.Background(), review)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return lib.ReviewAlreadyExists
+		}
+		return err
+	}
+	if insertedID, ok := result.InsertedID.(primitive.ObjectID); ok {
+		review.ID = insertedID.Hex()
+	}
+	return m.changeRating(review.BookID, lib.RatingChange(nil, review))
+}
+
+// UpdateExistingReview changes the rating, title and text of a review, which goes back to pending moderation
+func (m *MongoDB) UpdateExistingReview(review *lib.Review) error {
+	existing, err := m.GetOneReview(review.BookID, review.ID)
+	if err != nil {
+		return err
+	}
+	updated := *existing
+	updated.Stars = review.Stars
+	updated.Title = review.Title
+	updated.Text = review.Text
+	err = updated.Normalise()
+	if err != nil {
+		return err
+	}
+
+	updated.Status = lib.ReviewPending
+	updated.UpdatedAt = m.clock.Now()
+	before, err := m.updateReview(review.BookID, review.ID, bson.M{"$set": bson.M{
+		lib.JsonBsonTagStars:     updated.Stars,
+		"title":                  updated.Title,
+		"text":                   updated.Text,
+		lib.JsonBsonTagStatus:    updated.Status,
+		lib.JsonBsonTagUpdatedAt: updated.UpdatedAt,
+	}})
+	if err != nil {
+		return err
+	}
+	*review = *before
+	review.Stars = updated.Stars
+	review.Title = updated.Title
+	review.Text = updated.Text
+	review.Status = updated.Status
+	review.UpdatedAt = updated.UpdatedAt
+	return m.changeRating(review.BookID, lib.RatingChange(before, review))
+}
+
+// ModerateReview sets the moderation status of a review, rejected reviews leaving the rating of the book
+func (m *MongoDB) ModerateReview(bookID, reviewID, status string) (*lib.Review, error) {
+	if !lib.IsValidReviewStatus(status) {
+		return nil, lib.IncorrectReviewStatus
+	}
+	before, err := m.updateReview(bookID, reviewID, bson.M{"$set": bson.M{lib.JsonBsonTagStatus: status}})
+	if err != nil {
+		return nil, err
+	}
+	moderated := *before
+	moderated.Status = status
+	return &moderated, m.changeRating(bookID, lib.RatingChange(before, &moderated))
+}
+
+// VoteReviewHelpful counts a user finding a review helpful, once however often they vote
+func (m *MongoDB) VoteReviewHelpful(bookID, reviewID, userID string) (*lib.Review, error) {
+	match, err := m.reviewFilter(bookID, reviewID)
+	if err != nil {
+		return nil, err
+	}
+	voters := "$" + lib.JsonBsonTagVoters
+	vote := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{lib.JsonBsonTagVoters: bson.M{"$setUnion": bson.A{bson.M{"$ifNull": bson.A{voters, bson.A{}}}, bson.A{userID}}}}}},
+		{{Key: "$set", Value: bson.M{lib.JsonBsonTagHelpful: bson.M{"$size": voters}}}},
+	}
+	review := &lib.Review{}
+	err = m.reviewsCollection().FindOneAndUpdate(context.Background(), match, vote,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(review)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, lib.NoMatchingReview
+		}
+		return nil, err
+	}
+	return review, nil
+}
+
+// DeleteReview deletes a review and takes its stars out of the rating of the book
+func (m *MongoDB) DeleteReview(bookID, reviewID string) error {
+	match, err := m.reviewFilter(bookID, reviewID)
+	if err != nil {
+		return err
+	}
+	deleted := &lib.Review{}
+	err = m.reviewsCollection().FindOneAndDelete(context.Background(), match).Decode(deleted)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return lib.NoMatchingReview
+		}
+		return err
+	}
+	return m.changeRating(bookID, lib.RatingChange(deleted, nil))
+}
+
+// updateReview applies an update to a review of a book, returning the review as it was before
+func (m *MongoDB) updateReview(bookID, reviewID string, update bson.M) (*lib.Review, error) {
+	match, err := m.reviewFilter(bookID, reviewID)
+	if err != nil {
+		return nil, err
+	}
+	before := &lib.Review{}
+	err = m.reviewsCollection().FindOneAndUpdate(context.Background(), match, update).Decode(before)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, lib.NoMatchingReview
+		}
+		return nil, err
+	}
+	return before, nil
+}
+
+// reviewFilter returns a filter matching a review of a book not in the trash
+func (m *MongoDB) reviewFilter(bookID, reviewID string) (bson.M, error) {
+	inDb, err := m.isBookInDb(lib.BookIdentifier{ID: bookID})
+	if err != nil {
+		return nil, err
+	}
+	if !inDb {
+		return nil, lib.NoMatchingBook
+	}
+	id, err := primitive.ObjectIDFromHex(reviewID)
+	if err != nil {
+		return nil, lib.NoMatchingReview
+	}
+	return bson.M{lib.JsonBsonTagID: id, lib.JsonBsonTagBookID: bookID}, nil
+}
+
+// changeRating changes the number of reviews per stars in the rating of a book.
+// The change is applied by a single pipeline update so concurrent review changes never overwrite each other.
+func (m *MongoDB) changeRating(bookID string, change map[int]int) error {
+	if len(change) == 0 {
+		return nil
+	}
+	id, err := primitive.ObjectIDFromHex(bookID)
+	if err != nil {
+		return lib.NoMatchingBook
+	}
+	increment := func(field string, delta int) bson.M {
+		return bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$" + field, 0}}, delta}}
+	}
+	rating := lib.JsonBsonTagRating + "."
+	count, sum := 0, 0
+	counts := bson.M{}
+	for stars := lib.MinStars; stars <= lib.MaxStars; stars++ {
+		count += change[stars]
+		sum += stars * change[stars]
+		field := rating + lib.JsonBsonTagHistogram + "." + lib.StarsKey(stars)
+		counts[field] = increment(field, change[stars])
+	}
+	counts[rating+lib.JsonBsonTagCount] = increment(rating+lib.JsonBsonTagCount, count)
+	counts[rating+lib.JsonBsonTagSum] = increment(rating+lib.JsonBsonTagSum, sum)
+	average := bson.M{rating + lib.JsonBsonTagAverage: bson.M{"$cond": bson.A{
+		bson.M{"$gt": bson.A{"$" + rating + lib.JsonBsonTagCount, 0}},
+		bson.M{"$divide": bson.A{"$" + rating + lib.JsonBsonTagSum, "$" + rating + lib.JsonBsonTagCount}},
+		0,
+	}}}
+
+	_, err = m.collection.UpdateOne(context.Background(), bson.M{lib.JsonBsonTagID: id}, mongo.Pipeline{
+		{{Key: "$set", Value: counts}},
+		{{Key: "$set", Value: average}},
+	})
+	return err
+}
+
+// reviewsCollection returns the collection holding the reviews of this library
+func (m *MongoDB) reviewsCollection() *mongo.Collection {
+	return m.database.Collection(m.collection.Name() + "_reviews")
+}
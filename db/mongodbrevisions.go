@@ -0,0 +1,198 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"dockerrestapi/lib"
+	"encoding/hex"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"io"
+	"time"
+)
+
+// GetAllRevisions lists the revisions of the contents of a book, oldest first
+func (m *MongoDB) GetAllRevisions(bookID string) ([]lib.Revision, error) {
+	book, err := m.GetOneBookWithFields(&lib.BookIdentifier{ID: bookID}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	findOptions := options.Find().SetSort(bson.M{lib.JsonBsonTagNumber: 1}).SetProjection(bson.M{lib.JsonBsonTagContents: 0})
+	cursor, err := m.revisionsCollection().Find(context.Background(), bson.M{lib.JsonBsonTagBookID: book.ID}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+	revisions := []lib.Revision{}
+	err = cursor.All(context.Background(), &revisions)
+	if err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// GetRevisionContents streams the contents of a revision of a book, from GridFS when too large to be stored inline
+func (m *MongoDB) GetRevisionContents(bookID string, number int) (ContentsReader, error) {
+	book, err := m.GetOneBookWithFields(&lib.BookIdentifier{ID: bookID}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	revision := &lib.Revision{}
+	err = m.revisionsCollection().FindOne(context.Background(), bson.M{lib.JsonBsonTagBookID: book.ID, lib.JsonBsonTagNumber: number}).Decode(revision)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, lib.NoMatchingRevision
+		}
+		return nil, err
+	}
+	return m.openContents(revision.Contents, revision.ContentsFile)
+}
+
+// PurgeRevisions removes the revisions created before the cutoff but the latest of each book, with the contents only they hold
+func (m *MongoDB) PurgeRevisions(before time.Time) (int, error) {
+	findOptions := options.Find().SetSort(bson.D{{Key: lib.JsonBsonTagBookID, Value: 1}, {Key: lib.JsonBsonTagNumber, Value: 1}}).
+		SetProjection(bson.M{lib.JsonBsonTagBookID: 1, lib.JsonBsonTagNumber: 1, lib.JsonBsonTagContentsFile: 1})
+	cursor, err := m.revisionsCollection().Find(context.Background(), bson.M{lib.JsonBsonTagCreatedAt: bson.M{"$lt": before}}, findOptions)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(context.Background())
+	var revisions []lib.Revision
+	err = cursor.All(context.Background(), &revisions)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	latest := &lib.Revision{}
+	for _, revision := range revisions {
+		if revision.BookID != latest.BookID {
+			latest = &lib.Revision{}
+			findOptions := options.FindOne().SetSort(bson.M{lib.JsonBsonTagNumber: -1}).SetProjection(bson.M{lib.JsonBsonTagContents: 0})
+			err = m.revisionsCollection().FindOne(context.Background(), bson.M{lib.JsonBsonTagBookID: revision.BookID}, findOptions).Decode(latest)
+			if err != nil {
+				return purged, err
+			}
+		}
+		if revision.Number >= latest.Number {
+			continue
+		}
+		id, err := primitive.ObjectIDFromHex(revision.ID)
+		if err != nil {
+			return purged, err
+		}
+		_, err = m.revisionsCollection().DeleteOne(context.Background(), bson.M{lib.JsonBsonTagID: id})
+		if err != nil {
+			return purged, err
+		}
+		if revision.ContentsFile != latest.ContentsFile {
+			m.deleteContentsFile(revision.ContentsFile)
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// latestRevision returns the latest revision of the contents of a stored book, when writing them. Contents stored before
+// revisions were kept are first recorded as the first revision, which then owns them.
+func (m *MongoDB) latestRevision(book *lib.Book) (*lib.Revision, error) {
+	latest := &lib.Revision{}
+	findOptions := options.FindOne().SetSort(bson.M{lib.JsonBsonTagNumber: -1}).SetProjection(bson.M{lib.JsonBsonTagContents: 0})
+	err := m.revisionsCollection().FindOne(context.Background(), bson.M{lib.JsonBsonTagBookID: book.ID}, findOptions).Decode(latest)
+	if err == nil {
+		return latest, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	if book.ContentsHash == "" {
+		contents, err := m.openContents(book.Contents, book.ContentsFile)
+		if err != nil {
+			return nil, err
+		}
+		defer contents.Close()
+		hash := sha256.New()
+		_, err = io.Copy(hash, contents)
+		if err != nil {
+			return nil, err
+		}
+		book.ContentsHash = hex.EncodeToString(hash.Sum(nil))
+	}
+	return m.recordRevision(book, 1)
+}
+
+// recordRevision keeps the contents stored on a book as its revision of the given number, or of the next free number
+// when revisions are written concurrently, a unique index on the book and number keeping them apart
+func (m *MongoDB) recordRevision(book *lib.Book, number int) (*lib.Revision, error) {
+	revision := &lib.Revision{
+		BookID:         book.ID,
+		Number:         number,
+		CreatedAt:      book.UpdatedAt,
+		ContentsHash:   book.ContentsHash,
+		ContentsLength: book.ContentsLength,
+		Contents:       book.Contents,
+		ContentsFile:   book.ContentsFile,
+	}
+	for {
+		result, err := m.revisionsCollection().InsertOne(context.Background(), revision)
+		if mongo.IsDuplicateKeyError(err) {
+			revision.Number++
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if insertedID, ok := result.InsertedID.(primitive.ObjectID); ok {
+			revision.ID = insertedID.Hex()
+		}
+		return revision, nil
+	}
+}
+
+// shareRevisionContents points a book whose contents were just stored unchanged at the contents of its latest revision,
+// dropping the copy. It returns whether the contents changed and need a revision of their own.
+func (m *MongoDB) shareRevisionContents(book *lib.Book, latest *lib.Revision) bool {
+	if book.ContentsHash != latest.ContentsHash {
+		return true
+	}
+	if book.ContentsFile != latest.ContentsFile {
+		m.deleteContentsFile(book.ContentsFile)
+		book.ContentsFile = latest.ContentsFile
+	}
+	return false
+}
+
+// deleteRevisions removes the revisions of a book with their contents, and the contents of the book when no revision owns them
+func (m *MongoDB) deleteRevisions(book *lib.Book) error {
+	cursor, err := m.revisionsCollection().Find(context.Background(), bson.M{lib.JsonBsonTagBookID: book.ID},
+		options.Find().SetProjection(bson.M{lib.JsonBsonTagContentsFile: 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(context.Background())
+	var revisions []lib.Revision
+	err = cursor.All(context.Background(), &revisions)
+	if err != nil {
+		return err
+	}
+	files := map[string]bool{book.ContentsFile: true}
+	for _, revision := range revisions {
+		files[revision.ContentsFile] = true
+	}
+	for file := range files {
+		m.deleteContentsFile(file)
+	}
+	_, err = m.revisionsCollection().DeleteMany(context.Background(), bson.M{lib.JsonBsonTagBookID: book.ID})
+	return err
+}
+
+// revisionsCollection returns the collection holding the revisions of the contents of the books of this library
+func (m *MongoDB) revisionsCollection() *mongo.Collection {
+	return m.database.Collection(m.collection.Name() + "_revisions")
+}
@@ -0,0 +1,303 @@
+package db
+
+import (
+	"context"
+	"dockerrestapi/lib"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetAllShelves retrieves the shelves of the library matching the filter, in creation order
+func (m *MongoDB) GetAllShelves(filter lib.ShelfFilter) ([]lib.Shelf, error) {
+	query := bson.M{"$or": bson.A{
+		bson.M{lib.JsonBsonTagVisibility: lib.ShelfPublic},
+		bson.M{lib.JsonBsonTagOwnerID: filter.VisibleTo, lib.JsonBsonTagVisibility: lib.ShelfPrivate},
+	}}
+	if filter.OwnerID != "" {
+		query[lib.JsonBsonTagOwnerID] = filter.OwnerID
+	}
+	cursor, err := m.shelvesCollection().Find(context.Background(), query, options.Find().SetSort(bson.M{lib.JsonBsonTagID: 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	results := []lib.Shelf{}
+	err = cursor.All(context.Background(), &results)
+	if err != nil {
+		return nil, err
+	}
+	shelves := make([]*lib.Shelf, len(results))
+	for i := range results {
+		shelves[i] = &results[i]
+	}
+	err = m.hideTrashedBooks(shelves...)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// GetOneShelf retrieves a single shelf given its id, without its books in the trash
+func (m *MongoDB) GetOneShelf(id string) (*lib.Shelf, error) {
+	shelf, err := m.getShelf(id)
+	if err != nil {
+		return nil, err
+	}
+	err = m.hideTrashedBooks(shelf)
+	if err != nil {
+		return nil, err
+	}
+	return shelf, nil
+}
+
+// getShelf retrieves a single shelf given its id with every one of its books, as stored
+func (m *MongoDB) getShelf(id string) (*lib.Shelf, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, lib.NoMatchingShelf
+	}
+	shelf := &lib.Shelf{}
+	err = m.shelvesCollection().FindOne(context.Background(), bson.M{lib.JsonBsonTagID: objectID}).Decode(shelf)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, lib.NoMatchingShelf
+		}
+		return nil, err
+	}
+	return shelf, nil
+}
+
+// CreateNewShelf stores a new shelf, its books must be in the library
+func (m *MongoDB) CreateNewShelf(shelf *lib.Shelf) error {
+	err := shelf.Normalise()
+	if err != nil {
+		return err
+	}
+	books := shelf.BookIDs
+	shelf.BookIDs = []string{}
+	shelf.AddBooks(books, -1)
+	err = m.checkBooksExist(shelf.BookIDs)
+	if err != nil {
+		return err
+	}
+
+	shelf.ID = ""
+	shelf.Version = 0
+	shelf.CreatedAt = m.clock.Now()
+	shelf.UpdatedAt = shelf.CreatedAt
+	result, err := m.shelvesCollection().InsertOne(context.Background(), shelf)
+	if err != nil {
+		return err
+	}
+	if insertedID, ok := result.InsertedID.(primitive.ObjectID); ok {
+		shelf.ID = insertedID.Hex()
+	}
+	return nil
+}
+
+// UpdateExistingShelf changes the name, description and visibility of a shelf, leaving its owner and books
+func (m *MongoDB) UpdateExistingShelf(shelf *lib.Shelf) error {
+	existing, err := m.GetOneShelf(shelf.ID)
+	if err != nil {
+		return err
+	}
+	shelf.OwnerID = existing.OwnerID
+	shelf.BookIDs = existing.BookIDs
+	err = shelf.Normalise()
+	if err != nil {
+		return err
+	}
+	shelf.Version = existing.Version
+	shelf.CreatedAt = existing.CreatedAt
+	shelf.UpdatedAt = m.clock.Now()
+
+	objectID, err := primitive.ObjectIDFromHex(shelf.ID)
+	if err != nil {
+		return lib.NoMatchingShelf
+	}
+	result, err := m.shelvesCollection().UpdateOne(context.Background(), bson.M{lib.JsonBsonTagID: objectID}, bson.M{"$set": bson.M{
+		lib.JsonBsonTagName:       shelf.Name,
+		"description":             shelf.Description,
+		lib.JsonBsonTagVisibility: shelf.Visibility,
+		lib.JsonBsonTagUpdatedAt:  shelf.UpdatedAt,
+	}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return lib.NoMatchingShelf
+	}
+	return nil
+}
+
+// DeleteShelf deletes a shelf, leaving its books in the library
+func (m *MongoDB) DeleteShelf(id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return lib.NoMatchingShelf
+	}
+	result, err := m.shelvesCollection().DeleteOne(context.Background(), bson.M{lib.JsonBsonTagID: objectID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return lib.NoMatchingShelf
+	}
+	return nil
+}
+
+// AddShelfBooks inserts books at a position of a shelf, every book must be in the library
+func (m *MongoDB) AddShelfBooks(id string, bookIDs []string, position int) (*lib.Shelf, error) {
+	err := m.checkBooksExist(bookIDs)
+	if err != nil {
+		return nil, err
+	}
+	return m.changeShelfBooks(id, func(shelf *lib.Shelf) error {
+		shelf.AddBooks(bookIDs, position)
+		return nil
+	})
+}
+
+// RemoveShelfBooks takes books off a shelf
+func (m *MongoDB) RemoveShelfBooks(id string, bookIDs []string) (*lib.Shelf, error) {
+	return m.changeShelfBooks(id, func(shelf *lib.Shelf) error {
+		shelf.RemoveBooks(bookIDs)
+		return nil
+	})
+}
+
+// ReorderShelfBooks puts the books of a shelf in the given order, books in the trash going last
+func (m *MongoDB) ReorderShelfBooks(id string, bookIDs []string) (*lib.Shelf, error) {
+	return m.changeShelfBooks(id, func(shelf *lib.Shelf) error {
+		trashed, err := m.trashedBooks(shelf.BookIDs)
+		if err != nil {
+			return err
+		}
+		return shelf.Reorder(append(append([]string{}, bookIDs...), trashed...))
+	})
+}
+
+// changeShelfBooks applies a change to the books of a shelf. The shelf is only written when its version is still
+// the one read, the change being applied again to the shelf as changed meanwhile otherwise.
+func (m *MongoDB) changeShelfBooks(id string, change func(shelf *lib.Shelf) error) (*lib.Shelf, error) {
+	for {
+		shelf, err := m.getShelf(id)
+		if err != nil {
+			return nil, err
+		}
+		err = change(shelf)
+		if err != nil {
+			return nil, err
+		}
+		objectID, err := primitive.ObjectIDFromHex(shelf.ID)
+		if err != nil {
+			return nil, err
+		}
+		read := shelf.Version
+		shelf.Version++
+		shelf.UpdatedAt = m.clock.Now()
+		result, err := m.shelvesCollection().UpdateOne(context.Background(),
+			bson.M{lib.JsonBsonTagID: objectID, lib.JsonBsonTagVersion: read},
+			bson.M{"$set": bson.M{
+				lib.JsonBsonTagBookIDs:   shelf.BookIDs,
+				lib.JsonBsonTagVersion:   shelf.Version,
+				lib.JsonBsonTagUpdatedAt: shelf.UpdatedAt,
+			}})
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 1 {
+			return shelf, m.hideTrashedBooks(shelf)
+		}
+	}
+}
+
+// checkBooksExist checks every book is in the library and not in the trash
+func (m *MongoDB) checkBooksExist(bookIDs []string) error {
+	ids := bson.A{}
+	seen := map[string]bool{}
+	for _, bookID := range bookIDs {
+		id, err := primitive.ObjectIDFromHex(bookID)
+		if err != nil {
+			return lib.NoMatchingBook
+		}
+		if !seen[bookID] {
+			seen[bookID] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	count, err := m.collection.CountDocuments(context.Background(), bson.M{lib.JsonBsonTagID: bson.M{"$in": ids}, lib.JsonBsonTagDeletedAt: notInTrash})
+	if err != nil {
+		return err
+	}
+	if int(count) != len(ids) {
+		return lib.NoMatchingBook
+	}
+	return nil
+}
+
+// hideTrashedBooks takes the books in the trash off shelves as read, they stay on the shelves until purged
+func (m *MongoDB) hideTrashedBooks(shelves ...*lib.Shelf) error {
+	var bookIDs []string
+	for _, shelf := range shelves {
+		bookIDs = append(bookIDs, shelf.BookIDs...)
+	}
+	trashed, err := m.trashedBooks(bookIDs)
+	if err != nil || len(trashed) == 0 {
+		return err
+	}
+	for _, shelf := range shelves {
+		shelf.RemoveBooks(trashed)
+	}
+	return nil
+}
+
+// trashedBooks returns the books in the trash among the given ones
+func (m *MongoDB) trashedBooks(bookIDs []string) ([]string, error) {
+	ids := bson.A{}
+	for _, bookID := range bookIDs {
+		if id, err := primitive.ObjectIDFromHex(bookID); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	cursor, err := m.collection.Find(context.Background(), bson.M{lib.JsonBsonTagID: bson.M{"$in": ids}, lib.JsonBsonTagDeletedAt: inTrash},
+		options.Find().SetProjection(bson.M{lib.JsonBsonTagID: 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+	var books []lib.Book
+	err = cursor.All(context.Background(), &books)
+	if err != nil {
+		return nil, err
+	}
+	trashed := make([]string, len(books))
+	for i, book := range books {
+		trashed[i] = book.ID
+	}
+	return trashed, nil
+}
+
+// unshelveBook takes a purged book off every shelf
+func (m *MongoDB) unshelveBook(bookID string) error {
+	_, err := m.shelvesCollection().UpdateMany(context.Background(),
+		bson.M{lib.JsonBsonTagBookIDs: bookID},
+		bson.M{"$pull": bson.M{lib.JsonBsonTagBookIDs: bookID}, "$inc": bson.M{lib.JsonBsonTagVersion: 1}},
+	)
+	return err
+}
+
+// shelvesCollection returns the collection holding the shelves of this library
+func (m *MongoDB) shelvesCollection() *mongo.Collection {
+	return m.database.Collection(m.collection.Name() + "_shelves")
+}
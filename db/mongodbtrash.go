@@ -0,0 +1,148 @@
+package db
+
+import (
+	"context"
+	"dockerrestapi/lib"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	notInTrash = bson.M{"$exists": false} // deletedAt condition of every active book query
+	inTrash    = bson.M{"$exists": true}
+)
+
+// GetDeletedBooks lists the books in the trash, with the time they were deleted
+func (m *MongoDB) GetDeletedBooks() ([]lib.BookIdentifier, error) {
+	projection := bson.M{lib.JsonBsonTagName: 1, lib.JsonBsonTagAuthor: 1, lib.JsonBsonTagDeletedAt: 1}
+	cursor, err := m.collection.Find(context.Background(), bson.M{lib.JsonBsonTagDeletedAt: inTrash}, options.Find().SetProjection(projection))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var result []lib.BookIdentifier
+	err = cursor.All(context.Background(), &result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// RestoreBook moves a book out of the trash, unless a book of the same name and author was created since
+func (m *MongoDB) RestoreBook(bookIdentifier *lib.BookIdentifier) error {
+	deleted, err := m.getDeletedBook(bookIdentifier)
+	if err != nil {
+		return err
+	}
+	clash, err := m.isBookInDb(lib.BookIdentifier{Name: deleted.Name, Author: deleted.Author})
+	if err != nil {
+		return err
+	}
+	if clash {
+		return lib.BookAlreadyExists
+	}
+
+	match, err := m.trashFilter(deleted.Identifier())
+	if err != nil {
+		return err
+	}
+	result, err := m.collection.UpdateOne(context.Background(), match, bson.M{"$unset": bson.M{lib.JsonBsonTagDeletedAt: ""}})
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return lib.BookAlreadyExists // created concurrently
+		}
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return lib.NoMatchingDeletedBook // purged meanwhile
+	}
+	return nil
+}
+
+// PurgeBook permanently removes a book in the trash with its contents, revisions, attachment metadata, reviews and reading state,
+// taking it off every shelf and returning the removed attachments
+func (m *MongoDB) PurgeBook(bookIdentifier *lib.BookIdentifier) ([]lib.Attachment, error) {
+	deleted, err := m.getDeletedBook(bookIdentifier)
+	if err != nil {
+		return nil, err
+	}
+
+	match, err := m.trashFilter(deleted.Identifier())
+	if err != nil {
+		return nil, err
+	}
+	result, err := m.collection.DeleteOne(context.Background(), match)
+	if err != nil {
+		return nil, err
+	}
+	if result.DeletedCount == 0 {
+		return nil, lib.NoMatchingDeletedBook // restored or purged meanwhile
+	}
+	err = m.deleteRevisions(deleted)
+	if err != nil {
+		return nil, err
+	}
+	err = m.unshelveBook(deleted.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	attachments := m.attachmentsCollection()
+	cursor, err := attachments.Find(context.Background(), bson.M{lib.JsonBsonTagBookID: deleted.ID})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+	var removed []lib.Attachment
+	err = cursor.All(context.Background(), &removed)
+	if err != nil {
+		return nil, err
+	}
+	_, err = attachments.DeleteMany(context.Background(), bson.M{lib.JsonBsonTagBookID: deleted.ID})
+	if err != nil {
+		return nil, err
+	}
+	_, err = m.reviewsCollection().DeleteMany(context.Background(), bson.M{lib.JsonBsonTagBookID: deleted.ID})
+	if err != nil {
+		return nil, err
+	}
+	_, err = m.progressCollection().DeleteMany(context.Background(), bson.M{lib.JsonBsonTagBookID: deleted.ID})
+	if err != nil {
+		return nil, err
+	}
+	_, err = m.bookmarksCollection().DeleteMany(context.Background(), bson.M{lib.JsonBsonTagBookID: deleted.ID})
+	if err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// getDeletedBook retrieves a book in the trash, the most recently deleted one when matched by a name and author deleted several times
+func (m *MongoDB) getDeletedBook(bookIdentifier *lib.BookIdentifier) (*lib.Book, error) {
+	match, err := m.trashFilter(*bookIdentifier)
+	if err != nil {
+		return nil, lib.NoMatchingDeletedBook
+	}
+	deleted := &lib.Book{}
+	err = m.collection.FindOne(context.Background(), match, options.FindOne().SetSort(bson.M{lib.JsonBsonTagDeletedAt: -1}).SetCollation(nameCollation)).Decode(deleted)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, lib.NoMatchingDeletedBook
+		}
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// trashFilter returns a filter matching a book in the trash by the id of the identifier when given, otherwise by its name and author
+func (m *MongoDB) trashFilter(bookIdentifier lib.BookIdentifier) (bson.M, error) {
+	match, err := m.identifierFilter(bookIdentifier)
+	if err != nil {
+		return nil, err
+	}
+	match[lib.JsonBsonTagDeletedAt] = inTrash
+	return match, nil
+}
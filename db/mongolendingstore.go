@@ -0,0 +1,589 @@
+package db
+
+import (
+	"context"
+	"dockerrestapi/lib"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"time"
+)
+
+const (
+	patronsCollectionName = "patrons"
+	loansCollectionName   = "loans"
+	copiesCollectionName  = "book_copies"
+	holdsCollectionName   = "holds"
+)
+
+type MongoLendingStore struct {
+	client  *mongo.Client
+	patrons *mongo.Collection
+	loans   *mongo.Collection
+	copies  *mongo.Collection
+	holds   *mongo.Collection
+}
+
+// CreateMongoLendingStore returns a lending store keeping patrons, loans and copy counts of every library in collections of the given database.
+// Copy counts are single documents per book, updated conditionally so that concurrent checkouts never lend more copies than there are,
+// and a copy is only ever set aside for a hold once a waiting hold has been counted out of them.
+func CreateMongoLendingStore(dsn, databaseName string) (LendingStoreInterface, error) {
+	client, err := connectMongo(dsn)
+	if err != nil {
+		return nil, err
+	}
+	database := client.Database(databaseName)
+	store := &MongoLendingStore{
+		client:  client,
+		patrons: database.Collection(patronsCollectionName),
+		loans:   database.Collection(loansCollectionName),
+		copies:  database.Collection(copiesCollectionName),
+		holds:   database.Collection(holdsCollectionName),
+	}
+	_, err = store.copies.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: lib.JsonBsonTagLibrary, Value: 1}, {Key: lib.JsonBsonTagBookID, Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = store.loans.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: lib.JsonBsonTagLibrary, Value: 1}, {Key: lib.JsonBsonTagBookID, Value: 1}}},
+		{Keys: bson.D{{Key: lib.JsonBsonTagLibrary, Value: 1}, {Key: lib.JsonBsonTagPatronID, Value: 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = store.holds.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: lib.JsonBsonTagLibrary, Value: 1}, {Key: lib.JsonBsonTagBookID, Value: 1}, {Key: lib.JsonBsonTagStatus, Value: 1}, {Key: lib.JsonBsonTagSequence, Value: 1}}},
+		{Keys: bson.D{{Key: lib.JsonBsonTagStatus, Value: 1}, {Key: lib.JsonBsonTagExpiresAt, Value: 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *MongoLendingStore) Disconnect() {
+	err := disconnectMongo(s.client)
+	if err != nil {
+		log.Println("disconnect error:", err.Error())
+	}
+}
+
+func (s *MongoLendingStore) GetAllPatrons(library string) ([]lib.Patron, error) {
+	cursor, err := s.patrons.Find(context.Background(), bson.M{lib.JsonBsonTagLibrary: library}, options.Find().SetSort(bson.M{lib.JsonBsonTagID: 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	results := []lib.Patron{}
+	err = cursor.All(context.Background(), &results)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (s *MongoLendingStore) GetOnePatron(library, id string) (*lib.Patron, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, lib.NoMatchingPatron
+	}
+	patron := &lib.Patron{}
+	err = s.patrons.FindOne(context.Background(), bson.M{lib.JsonBsonTagID: objectID, lib.JsonBsonTagLibrary: library}).Decode(patron)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, lib.NoMatchingPatron
+		}
+		return nil, err
+	}
+	return patron, nil
+}
+
+func (s *MongoLendingStore) CreateNewPatron(patron *lib.Patron) error {
+	patron.ID = ""
+	result, err := s.patrons.InsertOne(context.Background(), patron)
+	if err != nil {
+		return err
+	}
+	if insertedID, ok := result.InsertedID.(primitive.ObjectID); ok {
+		patron.ID = insertedID.Hex()
+	}
+	return nil
+}
+
+func (s *MongoLendingStore) DeletePatron(library, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return lib.NoMatchingPatron
+	}
+	active, err := s.loans.CountDocuments(context.Background(), bson.M{
+		lib.JsonBsonTagLibrary:    library,
+		lib.JsonBsonTagPatronID:   id,
+		lib.JsonBsonTagReturnedAt: bson.M{"$exists": false},
+	})
+	if err != nil {
+		return err
+	}
+	if active > 0 {
+		return lib.PatronHasActiveLoans
+	}
+	active, err = s.holds.CountDocuments(context.Background(), bson.M{
+		lib.JsonBsonTagLibrary:  library,
+		lib.JsonBsonTagPatronID: id,
+		lib.JsonBsonTagStatus:   bson.M{"$in": activeHoldStatuses},
+	})
+	if err != nil {
+		return err
+	}
+	if active > 0 {
+		return lib.PatronHasActiveLoans
+	}
+	result, err := s.patrons.DeleteOne(context.Background(), bson.M{lib.JsonBsonTagID: objectID, lib.JsonBsonTagLibrary: library})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return lib.NoMatchingPatron
+	}
+	return nil
+}
+
+func (s *MongoLendingStore) DropNamespace(library string) error {
+	for _, collection := range []*mongo.Collection{s.patrons, s.loans, s.copies, s.holds} {
+		_, err := collection.DeleteMany(context.Background(), bson.M{lib.JsonBsonTagLibrary: library})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MongoLendingStore) GetCopies(library, bookID string) (*lib.BookCopies, error) {
+	copies := &lib.BookCopies{}
+	err := s.copies.FindOne(context.Background(), copiesFilter(library, bookID)).Decode(copies)
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, err
+		}
+		copies = &lib.BookCopies{Library: library, BookID: bookID, Copies: lib.DefaultCopies}
+	}
+	copies.SetAvailable()
+	return copies, nil
+}
+
+func (s *MongoLendingStore) SetCopies(library, bookID string, count int, now time.Time, pickupWindow time.Duration) (*lib.BookCopies, error) {
+	if count < 0 {
+		return nil, lib.IncorrectCopies
+	}
+	err := s.ensureCopies(library, bookID)
+	if err != nil {
+		return nil, err
+	}
+	filter := copiesFilter(library, bookID)
+	filter["$expr"] = bson.M{"$lte": bson.A{copiesTaken, count}}
+	result, err := s.copies.UpdateOne(context.Background(), filter, bson.M{"$set": bson.M{lib.JsonBsonTagCopies: count}})
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, lib.CopiesOnLoan
+	}
+	err = s.assignHolds(library, bookID, now, pickupWindow)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetCopies(library, bookID)
+}
+
+func (s *MongoLendingStore) CheckoutBook(loan *lib.Loan) error {
+	err := s.ensureCopies(loan.Library, loan.BookID)
+	if err != nil {
+		return err
+	}
+	fulfilled, err := s.fulfilHold(loan)
+	if err != nil {
+		return err
+	}
+	if !fulfilled {
+		// take a copy only while one is free and no hold waits for it, the count and the check being a single atomic update
+		filter := copiesFilter(loan.Library, loan.BookID)
+		filter[lib.JsonBsonTagWaiting] = bson.M{"$not": bson.M{"$gt": 0}}
+		filter["$expr"] = bson.M{"$lt": bson.A{copiesTaken, "$" + lib.JsonBsonTagCopies}}
+		result, err := s.copies.UpdateOne(context.Background(), filter, bson.M{"$inc": bson.M{lib.JsonBsonTagOnLoan: 1}})
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return lib.NoCopyAvailable
+		}
+	}
+
+	loan.ID = ""
+	inserted, err := s.loans.InsertOne(context.Background(), loan)
+	if err != nil {
+		s.updateCopies(loan.Library, loan.BookID, bson.M{lib.JsonBsonTagOnLoan: -1})
+		return err
+	}
+	if insertedID, ok := inserted.InsertedID.(primitive.ObjectID); ok {
+		loan.ID = insertedID.Hex()
+	}
+	return nil
+}
+
+func (s *MongoLendingStore) GetLoans(library string, filter lib.LoanFilter) ([]lib.Loan, error) {
+	query := bson.M{lib.JsonBsonTagLibrary: library}
+	if filter.BookID != "" {
+		query[lib.JsonBsonTagBookID] = filter.BookID
+	}
+	if filter.PatronID != "" {
+		query[lib.JsonBsonTagPatronID] = filter.PatronID
+	}
+	if filter.Active || !filter.DueBefore.IsZero() {
+		query[lib.JsonBsonTagReturnedAt] = bson.M{"$exists": false}
+	}
+	if !filter.DueBefore.IsZero() {
+		query[lib.JsonBsonTagDueAt] = bson.M{"$lt": filter.DueBefore}
+	}
+	cursor, err := s.loans.Find(context.Background(), query,
+		options.Find().SetSort(bson.D{{Key: lib.JsonBsonTagCheckedOutAt, Value: 1}, {Key: lib.JsonBsonTagID, Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	results := []lib.Loan{}
+	err = cursor.All(context.Background(), &results)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (s *MongoLendingStore) GetOneLoan(library, id string) (*lib.Loan, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, lib.NoMatchingLoan
+	}
+	loan := &lib.Loan{}
+	err = s.loans.FindOne(context.Background(), bson.M{lib.JsonBsonTagID: objectID, lib.JsonBsonTagLibrary: library}).Decode(loan)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, lib.NoMatchingLoan
+		}
+		return nil, err
+	}
+	return loan, nil
+}
+
+func (s *MongoLendingStore) ReturnLoan(library, id string, now time.Time, pickupWindow time.Duration) (*lib.Loan, error) {
+	loan, err := s.updateActiveLoan(library, id, bson.M{}, bson.M{"$set": bson.M{lib.JsonBsonTagReturnedAt: now}})
+	if err != nil {
+		return nil, err
+	}
+	s.updateCopies(library, loan.BookID, bson.M{lib.JsonBsonTagOnLoan: -1})
+	err = s.assignHolds(library, loan.BookID, now, pickupWindow)
+	if err != nil {
+		return nil, err
+	}
+	return loan, nil
+}
+
+func (s *MongoLendingStore) RenewLoan(library, id string, now, dueAt time.Time, maxRenewals int) (*lib.Loan, error) {
+	conditions := bson.M{
+		lib.JsonBsonTagDueAt:    bson.M{"$gte": now},
+		lib.JsonBsonTagRenewals: bson.M{"$lt": maxRenewals},
+	}
+	update := bson.M{
+		"$set": bson.M{lib.JsonBsonTagDueAt: dueAt},
+		"$inc": bson.M{lib.JsonBsonTagRenewals: 1},
+	}
+	loan, err := s.updateActiveLoan(library, id, conditions, update)
+	if err != nil {
+		if !errors.Is(err, lib.NoMatchingLoan) {
+			return nil, err
+		}
+		// tell why the loan was not renewed
+		loan, findErr := s.GetOneLoan(library, id)
+		switch {
+		case findErr != nil:
+			return nil, findErr
+		case !loan.IsActive():
+			return nil, lib.LoanAlreadyReturned
+		case loan.IsOverdue(now):
+			return nil, lib.LoanOverdue
+		default:
+			return nil, lib.RenewalLimitReached
+		}
+	}
+	return loan, nil
+}
+
+// updateActiveLoan updates a loan not returned yet matching the extra conditions, returning the updated loan.
+// Fails with lib.LoanAlreadyReturned when the loan was returned, lib.NoMatchingLoan when missing or not matching the conditions.
+func (s *MongoLendingStore) updateActiveLoan(library, id string, conditions bson.M, update bson.M) (*lib.Loan, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, lib.NoMatchingLoan
+	}
+	filter := bson.M{lib.JsonBsonTagID: objectID, lib.JsonBsonTagLibrary: library, lib.JsonBsonTagReturnedAt: bson.M{"$exists": false}}
+	for key, condition := range conditions {
+		filter[key] = condition
+	}
+	loan := &lib.Loan{}
+	err = s.loans.FindOneAndUpdate(context.Background(), filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(loan)
+	if err == nil {
+		return loan, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	if len(conditions) == 0 {
+		if _, findErr := s.GetOneLoan(library, id); findErr == nil {
+			return nil, lib.LoanAlreadyReturned
+		}
+	}
+	return nil, lib.NoMatchingLoan
+}
+
+// ensureCopies creates the copies document of a book with the default count when missing
+func (s *MongoLendingStore) ensureCopies(library, bookID string) error {
+	_, err := s.copies.UpdateOne(context.Background(), copiesFilter(library, bookID),
+		bson.M{"$setOnInsert": bson.M{
+			lib.JsonBsonTagCopies:       lib.DefaultCopies,
+			lib.JsonBsonTagOnLoan:       0,
+			lib.JsonBsonTagOnHold:       0,
+			lib.JsonBsonTagWaiting:      0,
+			lib.JsonBsonTagHoldSequence: 0,
+		}},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil // inserted concurrently
+	}
+	return err
+}
+
+// updateCopies increments the counts of the copies of a book, logging failures as the counts were already acted upon
+func (s *MongoLendingStore) updateCopies(library, bookID string, increments bson.M) {
+	_, err := s.copies.UpdateOne(context.Background(), copiesFilter(library, bookID), bson.M{"$inc": increments})
+	if err != nil {
+		log.Println("cant update copies of book", bookID, err.Error())
+	}
+}
+
+// copiesFilter matches the copies document of a book
+func copiesFilter(library, bookID string) bson.M {
+	return bson.M{lib.JsonBsonTagLibrary: library, lib.JsonBsonTagBookID: bookID}
+}
+
+func (s *MongoLendingStore) PlaceHold(hold *lib.Hold, pickupWindow time.Duration) error {
+	held, err := s.holds.CountDocuments(context.Background(), bson.M{
+		lib.JsonBsonTagLibrary:  hold.Library,
+		lib.JsonBsonTagBookID:   hold.BookID,
+		lib.JsonBsonTagPatronID: hold.PatronID,
+		lib.JsonBsonTagStatus:   bson.M{"$in": activeHoldStatuses},
+	})
+	if err != nil {
+		return err
+	}
+	if held > 0 {
+		return lib.HoldAlreadyPlaced
+	}
+	err = s.ensureCopies(hold.Library, hold.BookID)
+	if err != nil {
+		return err
+	}
+
+	// count the hold in only while no copy can be checked out, taking the next place in the queue
+	filter := copiesFilter(hold.Library, hold.BookID)
+	filter["$or"] = bson.A{
+		bson.M{lib.JsonBsonTagWaiting: bson.M{"$gt": 0}},
+		bson.M{"$expr": bson.M{"$gte": bson.A{copiesTaken, "$" + lib.JsonBsonTagCopies}}},
+	}
+	copies := &lib.BookCopies{}
+	err = s.copies.FindOneAndUpdate(context.Background(), filter,
+		bson.M{"$inc": bson.M{lib.JsonBsonTagWaiting: 1, lib.JsonBsonTagHoldSequence: 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(copies)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return lib.CopyAvailable
+		}
+		return err
+	}
+
+	hold.ID = ""
+	hold.Status = lib.HoldWaiting
+	hold.Sequence = copies.HoldSequence
+	inserted, err := s.holds.InsertOne(context.Background(), hold)
+	if err != nil {
+		s.updateCopies(hold.Library, hold.BookID, bson.M{lib.JsonBsonTagWaiting: -1})
+		return err
+	}
+	if insertedID, ok := inserted.InsertedID.(primitive.ObjectID); ok {
+		hold.ID = insertedID.Hex()
+	}
+	// a copy returned while the hold was being inserted could not be set aside for it
+	return s.assignHolds(hold.Library, hold.BookID, hold.PlacedAt, pickupWindow)
+}
+
+func (s *MongoLendingStore) GetHolds(library string, filter lib.HoldFilter) ([]lib.Hold, error) {
+	query := bson.M{lib.JsonBsonTagLibrary: library}
+	if filter.BookID != "" {
+		query[lib.JsonBsonTagBookID] = filter.BookID
+	}
+	if filter.PatronID != "" {
+		query[lib.JsonBsonTagPatronID] = filter.PatronID
+	}
+	if filter.Active {
+		query[lib.JsonBsonTagStatus] = bson.M{"$in": activeHoldStatuses}
+	}
+	cursor, err := s.holds.Find(context.Background(), query, options.Find().SetSort(bson.D{
+		{Key: lib.JsonBsonTagPlacedAt, Value: 1}, {Key: lib.JsonBsonTagSequence, Value: 1}, {Key: lib.JsonBsonTagID, Value: 1},
+	}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	results := []lib.Hold{}
+	err = cursor.All(context.Background(), &results)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (s *MongoLendingStore) GetOneHold(library, id string) (*lib.Hold, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, lib.NoMatchingHold
+	}
+	hold := &lib.Hold{}
+	err = s.holds.FindOne(context.Background(), bson.M{lib.JsonBsonTagID: objectID, lib.JsonBsonTagLibrary: library}).Decode(hold)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, lib.NoMatchingHold
+		}
+		return nil, err
+	}
+	return hold, nil
+}
+
+func (s *MongoLendingStore) CancelHold(library, id string, now time.Time, pickupWindow time.Duration) (*lib.Hold, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, lib.NoMatchingHold
+	}
+	filter := bson.M{lib.JsonBsonTagID: objectID, lib.JsonBsonTagLibrary: library, lib.JsonBsonTagStatus: bson.M{"$in": activeHoldStatuses}}
+	hold, err := s.closeHold(filter, lib.HoldCancelled, now, pickupWindow)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if _, err = s.GetOneHold(library, id); err != nil {
+			return nil, err
+		}
+		return nil, lib.HoldNotActive
+	}
+	return hold, err
+}
+
+func (s *MongoLendingStore) ExpireHolds(now time.Time, pickupWindow time.Duration) ([]lib.Hold, error) {
+	filter := bson.M{lib.JsonBsonTagStatus: lib.HoldReady, lib.JsonBsonTagExpiresAt: bson.M{"$lt": now}}
+	expired := []lib.Hold{}
+	for {
+		hold, err := s.closeHold(filter, lib.HoldExpired, now, pickupWindow)
+		if err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return expired, nil
+			}
+			return expired, err
+		}
+		expired = append(expired, *hold)
+	}
+}
+
+// activeHoldStatuses are the statuses of holds waiting or ready
+var activeHoldStatuses = bson.A{lib.HoldWaiting, lib.HoldReady}
+
+// copiesTaken adds up the copies of a book on loan and set aside for holds, as a $expr operand
+var copiesTaken = bson.M{"$add": bson.A{
+	bson.M{"$ifNull": bson.A{"$" + lib.JsonBsonTagOnLoan, 0}},
+	bson.M{"$ifNull": bson.A{"$" + lib.JsonBsonTagOnHold, 0}},
+}}
+
+// fulfilHold closes the ready hold of the borrowing patron, lending the copy set aside for it, reporting whether there was one
+func (s *MongoLendingStore) fulfilHold(loan *lib.Loan) (bool, error) {
+	err := s.holds.FindOneAndUpdate(context.Background(), bson.M{
+		lib.JsonBsonTagLibrary:  loan.Library,
+		lib.JsonBsonTagBookID:   loan.BookID,
+		lib.JsonBsonTagPatronID: loan.PatronID,
+		lib.JsonBsonTagStatus:   lib.HoldReady,
+	}, bson.M{"$set": bson.M{lib.JsonBsonTagStatus: lib.HoldFulfilled, lib.JsonBsonTagClosedAt: loan.CheckedOutAt}}).Err()
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return false, nil
+		}
+		return false, err
+	}
+	_, err = s.copies.UpdateOne(context.Background(), copiesFilter(loan.Library, loan.BookID),
+		bson.M{"$inc": bson.M{lib.JsonBsonTagOnHold: -1, lib.JsonBsonTagOnLoan: 1}})
+	return true, err
+}
+
+// closeHold closes the active hold matching the filter with the given status, the copy set aside for a ready hold going to the next in queue.
+// Fails with mongo.ErrNoDocuments when no hold matches.
+func (s *MongoLendingStore) closeHold(filter bson.M, status string, now time.Time, pickupWindow time.Duration) (*lib.Hold, error) {
+	hold := &lib.Hold{}
+	err := s.holds.FindOneAndUpdate(context.Background(), filter,
+		bson.M{"$set": bson.M{lib.JsonBsonTagStatus: status, lib.JsonBsonTagClosedAt: now}},
+		options.FindOneAndUpdate().SetSort(bson.M{lib.JsonBsonTagExpiresAt: 1}),
+	).Decode(hold)
+	if err != nil {
+		return nil, err
+	}
+	if hold.Status == lib.HoldReady {
+		s.updateCopies(hold.Library, hold.BookID, bson.M{lib.JsonBsonTagOnHold: -1})
+	} else {
+		s.updateCopies(hold.Library, hold.BookID, bson.M{lib.JsonBsonTagWaiting: -1})
+	}
+	hold.Close(status, now)
+	return hold, s.assignHolds(hold.Library, hold.BookID, now, pickupWindow)
+}
+
+// assignHolds sets free copies of a book aside for its oldest waiting holds.
+// Each copy is first counted out of the free copies and the waiting holds at once, then given to the oldest hold still waiting.
+func (s *MongoLendingStore) assignHolds(library, bookID string, now time.Time, pickupWindow time.Duration) error {
+	for {
+		filter := copiesFilter(library, bookID)
+		filter[lib.JsonBsonTagWaiting] = bson.M{"$gt": 0}
+		filter["$expr"] = bson.M{"$lt": bson.A{copiesTaken, "$" + lib.JsonBsonTagCopies}}
+		result, err := s.copies.UpdateOne(context.Background(), filter, bson.M{"$inc": bson.M{lib.JsonBsonTagOnHold: 1, lib.JsonBsonTagWaiting: -1}})
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return nil
+		}
+
+		ready := lib.Hold{}
+		ready.SetReady(now, pickupWindow)
+		err = s.holds.FindOneAndUpdate(context.Background(),
+			bson.M{lib.JsonBsonTagLibrary: library, lib.JsonBsonTagBookID: bookID, lib.JsonBsonTagStatus: lib.HoldWaiting},
+			bson.M{"$set": bson.M{lib.JsonBsonTagStatus: ready.Status, lib.JsonBsonTagReadyAt: ready.ReadyAt, lib.JsonBsonTagExpiresAt: ready.ExpiresAt}},
+			options.FindOneAndUpdate().SetSort(bson.M{lib.JsonBsonTagSequence: 1}),
+		).Err()
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			// the hold counted out was cancelled or is not inserted yet, whoever did so assigns the copy again
+			s.updateCopies(library, bookID, bson.M{lib.JsonBsonTagOnHold: -1, lib.JsonBsonTagWaiting: 1})
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"dockerrestapi/lib"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"time"
+)
+
+const (
+	quotasCollectionName = "quotas"
+)
+
+type MongoQuotaStore struct {
+	client *mongo.Client
+	quotas *mongo.Collection
+}
+
+// CreateMongoQuotaStore returns a quota store counting the daily requests of clients in a collection of the given database,
+// usages being removed by a TTL index once their day is over.
+func CreateMongoQuotaStore(dsn, databaseName string) (QuotaStoreInterface, error) {
+	client, err := connectMongo(dsn)
+	if err != nil {
+		return nil, err
+	}
+	store := &MongoQuotaStore{
+		client: client,
+		quotas: client.Database(databaseName).Collection(quotasCollectionName),
+	}
+	_, err = store.quotas.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: lib.JsonBsonTagClient, Value: 1}, {Key: lib.JsonBsonTagDay, Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.M{lib.JsonBsonTagExpiresAt: 1},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *MongoQuotaStore) Disconnect() {
+	err := disconnectMongo(s.client)
+	if err != nil {
+		log.Println("disconnect error:", err.Error())
+	}
+}
+
+// IncrementQuota counts a request in a single upsert, concurrent first requests of a day retrying once the usage exists
+func (s *MongoQuotaStore) IncrementQuota(client, day string, expiresAt time.Time) (int64, error) {
+	usage := &lib.QuotaUsage{}
+	err := s.quotas.FindOneAndUpdate(context.Background(),
+		bson.M{lib.JsonBsonTagClient: client, lib.JsonBsonTagDay: day},
+		bson.M{"$inc": bson.M{lib.JsonBsonTagRequests: 1}, "$setOnInsert": bson.M{lib.JsonBsonTagExpiresAt: expiresAt}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(usage)
+	if mongo.IsDuplicateKeyError(err) {
+		return s.IncrementQuota(client, day, expiresAt)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return usage.Requests, nil
+}
@@ -0,0 +1,233 @@
+package db
+
+import (
+	"context"
+	"dockerrestapi/lib"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"time"
+)
+
+const (
+	webhooksCollectionName   = "webhooks"
+	deliveriesCollectionName = "webhook_deliveries"
+	finishedDeliveryLifetime = 30 * 24 * time.Hour // how long delivered and dead deliveries are kept
+)
+
+type MongoWebhookStore struct {
+	client     *mongo.Client
+	webhooks   *mongo.Collection
+	deliveries *mongo.Collection
+}
+
+// CreateMongoWebhookStore returns a webhook store keeping webhooks of every library and their delivery queue in collections of the given database,
+// delivered and dead deliveries being removed by a TTL index once finished for finishedDeliveryLifetime.
+func CreateMongoWebhookStore(dsn, databaseName string) (WebhookStoreInterface, error) {
+	client, err := connectMongo(dsn)
+	if err != nil {
+		return nil, err
+	}
+	database := client.Database(databaseName)
+	store := &MongoWebhookStore{
+		client:     client,
+		webhooks:   database.Collection(webhooksCollectionName),
+		deliveries: database.Collection(deliveriesCollectionName),
+	}
+	_, err = store.deliveries.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: lib.JsonBsonTagStatus, Value: 1}, {Key: lib.JsonBsonTagNextAttemptAt, Value: 1}}},
+		{
+			Keys:    bson.M{lib.JsonBsonTagFinishedAt: 1},
+			Options: options.Index().SetExpireAfterSeconds(int32(finishedDeliveryLifetime.Seconds())),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *MongoWebhookStore) Disconnect() {
+	err := disconnectMongo(s.client)
+	if err != nil {
+		log.Println("disconnect error:", err.Error())
+	}
+}
+
+func (s *MongoWebhookStore) GetAllWebhooks(library string) ([]lib.Webhook, error) {
+	cursor, err := s.webhooks.Find(context.Background(), bson.M{lib.JsonBsonTagLibrary: library})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	results := []lib.Webhook{}
+	err = cursor.All(context.Background(), &results)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (s *MongoWebhookStore) GetOneWebhook(library, id string) (*lib.Webhook, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, lib.NoMatchingWebhook
+	}
+	webhook := &lib.Webhook{}
+	err = s.webhooks.FindOne(context.Background(), bson.M{lib.JsonBsonTagID: objectID, lib.JsonBsonTagLibrary: library}).Decode(webhook)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, lib.NoMatchingWebhook
+		}
+		return nil, err
+	}
+	return webhook, nil
+}
+
+func (s *MongoWebhookStore) CreateNewWebhook(webhook *lib.Webhook) error {
+	webhook.ID = ""
+	result, err := s.webhooks.InsertOne(context.Background(), webhook)
+	if err != nil {
+		return err
+	}
+	if insertedID, ok := result.InsertedID.(primitive.ObjectID); ok {
+		webhook.ID = insertedID.Hex()
+	}
+	return nil
+}
+
+func (s *MongoWebhookStore) DeleteWebhook(library, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return lib.NoMatchingWebhook
+	}
+	result, err := s.webhooks.DeleteOne(context.Background(), bson.M{lib.JsonBsonTagID: objectID, lib.JsonBsonTagLibrary: library})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return lib.NoMatchingWebhook
+	}
+	_, err = s.deliveries.DeleteMany(context.Background(), bson.M{lib.JsonBsonTagWebhookID: id})
+	return err
+}
+
+func (s *MongoWebhookStore) DropNamespace(library string) error {
+	_, err := s.webhooks.DeleteMany(context.Background(), bson.M{lib.JsonBsonTagLibrary: library})
+	if err != nil {
+		return err
+	}
+	_, err = s.deliveries.DeleteMany(context.Background(), bson.M{lib.JsonBsonTagLibrary: library})
+	return err
+}
+
+func (s *MongoWebhookStore) CreateNewDelivery(delivery *lib.Delivery) error {
+	delivery.ID = ""
+	result, err := s.deliveries.InsertOne(context.Background(), delivery)
+	if err != nil {
+		return err
+	}
+	if insertedID, ok := result.InsertedID.(primitive.ObjectID); ok {
+		delivery.ID = insertedID.Hex()
+	}
+	return nil
+}
+
+func (s *MongoWebhookStore) GetDeliveries(library, webhookID, status string) ([]lib.Delivery, error) {
+	query := bson.M{lib.JsonBsonTagLibrary: library}
+	if webhookID != "" {
+		query[lib.JsonBsonTagWebhookID] = webhookID
+	}
+	if status != "" {
+		query[lib.JsonBsonTagStatus] = status
+	}
+	return s.findDeliveries(query, options.Find().SetSort(bson.D{{Key: lib.JsonBsonTagCreatedAt, Value: 1}, {Key: lib.JsonBsonTagID, Value: 1}}))
+}
+
+func (s *MongoWebhookStore) GetOneDelivery(library, webhookID, id string) (*lib.Delivery, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, lib.NoMatchingDelivery
+	}
+	delivery := &lib.Delivery{}
+	err = s.deliveries.FindOne(context.Background(), bson.M{
+		lib.JsonBsonTagID:        objectID,
+		lib.JsonBsonTagLibrary:   library,
+		lib.JsonBsonTagWebhookID: webhookID,
+	}).Decode(delivery)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, lib.NoMatchingDelivery
+		}
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// ClaimDueDeliveries leases due deliveries one at a time, each claim being a single atomic update so that dispatchers
+// of several replicas never claim the same delivery
+func (s *MongoWebhookStore) ClaimDueDeliveries(now time.Time, lease time.Duration, limit int) ([]lib.Delivery, error) {
+	query := bson.M{
+		lib.JsonBsonTagStatus:        lib.DeliveryPending,
+		lib.JsonBsonTagNextAttemptAt: bson.M{"$lte": now},
+	}
+	claim := bson.M{"$set": bson.M{lib.JsonBsonTagNextAttemptAt: now.Add(lease)}}
+	claimOptions := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: lib.JsonBsonTagCreatedAt, Value: 1}, {Key: lib.JsonBsonTagID, Value: 1}}).
+		SetReturnDocument(options.After)
+
+	results := []lib.Delivery{}
+	for len(results) < limit {
+		var delivery lib.Delivery
+		err := s.deliveries.FindOneAndUpdate(context.Background(), query, claim, claimOptions).Decode(&delivery)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			break
+		}
+		if err != nil {
+			return results, err
+		}
+		results = append(results, delivery)
+	}
+	return results, nil
+}
+
+func (s *MongoWebhookStore) UpdateDelivery(delivery *lib.Delivery) error {
+	objectID, err := primitive.ObjectIDFromHex(delivery.ID)
+	if err != nil {
+		return lib.NoMatchingDelivery
+	}
+	update := *delivery
+	update.ID = "" // _id is immutable, omitted from the update
+	changes := bson.M{"$set": update}
+	if delivery.FinishedAt == nil { // retried deliveries are no longer removed
+		changes["$unset"] = bson.M{lib.JsonBsonTagFinishedAt: ""}
+	}
+	result, err := s.deliveries.UpdateOne(context.Background(), bson.M{lib.JsonBsonTagID: objectID}, changes)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return lib.NoMatchingDelivery // its webhook was deleted meanwhile
+	}
+	return nil
+}
+
+// findDeliveries decodes every delivery matching the query
+func (s *MongoWebhookStore) findDeliveries(query bson.M, findOptions *options.FindOptions) ([]lib.Delivery, error) {
+	cursor, err := s.deliveries.Find(context.Background(), query, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	results := []lib.Delivery{}
+	err = cursor.All(context.Background(), &results)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -0,0 +1,408 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"dockerrestapi/db"
+	"dockerrestapi/lib"
+	"encoding/hex"
+	"errors"
+	"github.com/gorilla/mux"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	attachmentsPath          = libraryBookPath + "/attachments"
+	attachmentPath           = attachmentsPath + "/{" + paramAttachment + "}"
+	attachmentThumbnailPath  = attachmentPath + "/thumbnail"
+	paramAttachment          = "attachment"
+	attachmentFormField      = "file"
+	defaultMaxAttachmentSize = 32 << 20
+	multipartOverhead        = 1 << 20 // allowance for multipart headers and other form fields on top of the attachment size
+	thumbnailSize            = 256
+)
+
+// allowedAttachmentTypes are the sniffed content types accepted as attachments
+var allowedAttachmentTypes = map[string]bool{
+	"image/jpeg":           true,
+	"image/png":            true,
+	"image/gif":            true,
+	"application/pdf":      true,
+	"application/epub+zip": true,
+}
+
+// getAttachments lists the attachments of a book given the id in the path.
+// eg : api/libraries/{library}/books/{id}/attachments
+func (r *RestService) getAttachments(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Attachments request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+
+	attachments, err := library.GetAllAttachments(mux.Vars(request)[paramID])
+	if err != nil {
+		r.restAttachmentError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, attachments)
+}
+
+// createAttachment stores the file of a multipart upload, in the "file" form field, as an attachment of the book given the id in the path.
+// The content type is sniffed rather than trusted, identical files are stored once, and images get a thumbnail.
+// eg : api/libraries/{library}/books/{id}/attachments
+func (r *RestService) createAttachment(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received create Attachment request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+	bookID := mux.Vars(request)[paramID]
+	_, err = library.GetOneBook(&lib.BookIdentifier{ID: bookID})
+	if err != nil {
+		r.restAttachmentError(writer, err)
+		return
+	}
+
+	request.Body = http.MaxBytesReader(writer, request.Body, r.maxAttachmentSize+multipartOverhead)
+	part, err := r.attachmentPart(request)
+	if err != nil {
+		r.restAttachmentError(writer, err)
+		return
+	}
+
+	attachment, unlock, err := r.storeAttachmentBlobs(r.libraryNameFromRequest(request), part)
+	if err != nil {
+		r.restAttachmentError(writer, err)
+		return
+	}
+	defer unlock() // the blobs cannot be released until the attachment referencing them is recorded
+	attachment.BookID = bookID
+
+	err = library.CreateNewAttachment(attachment)
+	if err != nil {
+		r.restAttachmentError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, attachment)
+}
+
+// getAttachment downloads an attachment given the book id and attachment id in the path.
+// eg : api/libraries/{library}/books/{id}/attachments/{attachment}
+func (r *RestService) getAttachment(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Attachment request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+	params := mux.Vars(request)
+
+	attachment, err := library.GetOneAttachment(params[paramID], params[paramAttachment])
+	if err != nil {
+		r.restAttachmentError(writer, err)
+		return
+	}
+	writer.Header().Set("Content-Disposition", "attachment; filename=\""+strings.ReplaceAll(attachment.FileName, "\"", "")+"\"")
+	r.blobResponse(writer, request, r.libraryNameFromRequest(request), attachment.SHA256, attachment.ContentType)
+}
+
+// getAttachmentThumbnail downloads the PNG thumbnail of an image attachment given the book id and attachment id in the path.
+// eg : api/libraries/{library}/books/{id}/attachments/{attachment}/thumbnail
+func (r *RestService) getAttachmentThumbnail(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Attachment Thumbnail request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+	params := mux.Vars(request)
+
+	attachment, err := library.GetOneAttachment(params[paramID], params[paramAttachment])
+	if err != nil {
+		r.restAttachmentError(writer, err)
+		return
+	}
+	if attachment.Thumbnail == "" {
+		r.restResponse(writer, http.StatusNotFound, "attachment has no thumbnail")
+		return
+	}
+	r.blobResponse(writer, request, r.libraryNameFromRequest(request), attachment.Thumbnail, "image/png")
+}
+
+// deleteAttachment deletes an attachment given the book id and attachment id in the path, its blobs are removed once no attachment uses them.
+// eg : api/libraries/{library}/books/{id}/attachments/{attachment}
+func (r *RestService) deleteAttachment(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received delete Attachment request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+	params := mux.Vars(request)
+
+	attachment, err := library.GetOneAttachment(params[paramID], params[paramAttachment])
+	if err != nil {
+		r.restAttachmentError(writer, err)
+		return
+	}
+	err = library.DeleteAttachment(attachment.BookID, attachment.ID)
+	if err != nil {
+		r.restAttachmentError(writer, err)
+		return
+	}
+	r.releaseBlobs(library, r.libraryNameFromRequest(request), attachment.SHA256, attachment.Thumbnail)
+	r.restResponse(writer, http.StatusOK, nil)
+}
+
+// releaseAttachmentBlobs deletes the blobs of the attachments of a purged book no longer referenced elsewhere,
+// failures are only logged as the book is already gone
+func (r *RestService) releaseAttachmentBlobs(library db.RestDbInterface, libraryName string, attachments []lib.Attachment) {
+	if r.blobs == nil {
+		return
+	}
+	for _, attachment := range attachments {
+		r.releaseBlobs(library, libraryName, attachment.SHA256, attachment.Thumbnail)
+	}
+}
+
+// releaseBlobs deletes blobs no longer referenced by any attachment of the library
+func (r *RestService) releaseBlobs(library db.RestDbInterface, libraryName string, hashes ...string) {
+	for _, hash := range hashes {
+		if hash != "" {
+			r.releaseBlob(library, libraryName, hash)
+		}
+	}
+}
+
+// releaseBlob deletes a blob no longer referenced by any attachment of the library, holding its lock so that an upload
+// cannot reuse it between the check and the deletion
+func (r *RestService) releaseBlob(library db.RestDbInterface, libraryName, hash string) {
+	unlock := r.blobLocks.acquire(libraryName, hash)
+	defer unlock()
+	referenced, err := library.IsBlobReferenced(hash)
+	if err != nil {
+		stdError("cant check blob references " + err.Error())
+		return
+	}
+	if referenced {
+		return
+	}
+	err = r.blobs.DeleteBlob(libraryName, hash)
+	if err != nil && !errors.Is(err, lib.NoMatchingBlob) {
+		stdError("cant delete blob " + err.Error())
+	}
+}
+
+// attachmentPart returns the "file" part of a multipart upload
+func (r *RestService) attachmentPart(request *http.Request) (*multipart.Part, error) {
+	reader, err := request.MultipartReader()
+	if err != nil {
+		return nil, lib.IncorrectParameters
+	}
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			if err == io.EOF {
+				return nil, lib.IncorrectParameters
+			}
+			return nil, err
+		}
+		if part.FormName() == attachmentFormField && part.FileName() != "" {
+			return part, nil
+		}
+	}
+}
+
+// storeAttachmentBlobs spools an uploaded file to disk while hashing it, sniffs and checks its type,
+// and stores it, and a thumbnail for images, in the blob store unless already present. The blobs are returned locked,
+// to be unlocked once the attachment is recorded.
+func (r *RestService) storeAttachmentBlobs(namespace string, part *multipart.Part) (*lib.Attachment, func(), error) {
+	spool, err := os.CreateTemp("", "attachment-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(spool, hash), io.LimitReader(part, r.maxAttachmentSize+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if size > r.maxAttachmentSize {
+		return nil, nil, lib.AttachmentTooLarge
+	}
+
+	head := make([]byte, 512)
+	n, err := spool.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+	contentType := sniffAttachmentType(head[:n])
+	if !allowedAttachmentTypes[contentType] {
+		return nil, nil, lib.UnsupportedAttachmentType
+	}
+
+	attachment := &lib.Attachment{
+		FileName:    filepath.Base(part.FileName()),
+		ContentType: contentType,
+		Size:        size,
+		SHA256:      hex.EncodeToString(hash.Sum(nil)),
+	}
+	var thumbnail []byte
+	if strings.HasPrefix(contentType, "image/") {
+		thumbnail, err = createThumbnail(io.NewSectionReader(spool, 0, size), thumbnailSize)
+		if err != nil {
+			stdInfo("no thumbnail for " + attachment.FileName + " " + err.Error())
+		} else {
+			thumbnailHash := sha256.Sum256(thumbnail)
+			attachment.Thumbnail = hex.EncodeToString(thumbnailHash[:])
+		}
+	}
+
+	unlock := r.blobLocks.acquire(namespace, attachment.SHA256, attachment.Thumbnail)
+	err = r.putBlobOnce(namespace, attachment.SHA256, io.NewSectionReader(spool, 0, size))
+	if err == nil && attachment.Thumbnail != "" {
+		err = r.putBlobOnce(namespace, attachment.Thumbnail, bytes.NewReader(thumbnail))
+	}
+	if err != nil {
+		unlock()
+		return nil, nil, err
+	}
+	return attachment, unlock, nil
+}
+
+// putBlobOnce stores a blob unless a blob with the same hash is already stored
+func (r *RestService) putBlobOnce(namespace, hash string, blob io.Reader) error {
+	exists, err := r.blobs.HasBlob(namespace, hash)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	return r.blobs.PutBlob(namespace, hash, blob)
+}
+
+// blobLocks are the locks of the blobs being stored or released, an upload holding them until its attachment is
+// recorded so that a blob found unreferenced is not deleted while an attachment reusing it is being created
+type blobLocks struct {
+	lock  sync.Mutex
+	locks map[string]*blobLock // by namespace and hash
+}
+
+// blobLock locks a blob, kept while a request holds or waits for it
+type blobLock struct {
+	sync.Mutex
+	users int
+}
+
+// newBlobLocks returns locks with no blob locked
+func newBlobLocks() *blobLocks {
+	return &blobLocks{locks: map[string]*blobLock{}}
+}
+
+// acquire locks the blobs of a namespace with the given hashes, empty ones ignored, returning the function unlocking
+// them. Blobs are locked in order so that requests sharing several blobs cannot deadlock.
+func (l *blobLocks) acquire(namespace string, hashes ...string) func() {
+	keys := make([]string, 0, len(hashes))
+	for _, hash := range hashes {
+		if hash != "" {
+			keys = append(keys, namespace+"/"+hash)
+		}
+	}
+	sort.Strings(keys)
+	for i := len(keys) - 1; i > 0; i-- {
+		if keys[i] == keys[i-1] {
+			keys = append(keys[:i], keys[i+1:]...) // a thumbnail can be the image itself
+		}
+	}
+
+	locks := make([]*blobLock, len(keys))
+	for i, key := range keys {
+		l.lock.Lock()
+		lock, found := l.locks[key]
+		if !found {
+			lock = &blobLock{}
+			l.locks[key] = lock
+		}
+		lock.users++
+		l.lock.Unlock()
+		lock.Lock()
+		locks[i] = lock
+	}
+	return func() {
+		for i, lock := range locks {
+			lock.Unlock()
+			l.lock.Lock()
+			if lock.users--; lock.users == 0 {
+				delete(l.locks, keys[i])
+			}
+			l.lock.Unlock()
+		}
+	}
+}
+
+// blobResponse streams a blob with its content type, using the hash as the ETag
+func (r *RestService) blobResponse(writer http.ResponseWriter, request *http.Request, namespace, hash, contentType string) {
+	etag := "\"" + hash + "\""
+	if request.Header.Get("If-None-Match") == etag {
+		writer.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	blob, err := r.blobs.GetBlob(namespace, hash)
+	if err != nil {
+		r.restAttachmentError(writer, err)
+		return
+	}
+	defer blob.Close()
+
+	writer.Header().Set("Content-Type", contentType)
+	writer.Header().Set("Content-Length", strconv.FormatInt(blob.Size(), 10))
+	writer.Header().Set("ETag", etag)
+	writer.WriteHeader(http.StatusOK)
+	_, err = io.Copy(writer, blob)
+	if err != nil {
+		stdError("cant stream blob " + err.Error())
+	}
+}
+
+// restAttachmentError responds to a failed attachment operation
+func (r *RestService) restAttachmentError(writer http.ResponseWriter, err error) {
+	var maxBytesError *http.MaxBytesError
+	switch {
+	case errors.Is(err, lib.NoMatchingBook), errors.Is(err, lib.NoMatchingAttachment), errors.Is(err, lib.NoMatchingBlob):
+		r.restResponse(writer, http.StatusNotFound, err.Error())
+	case errors.Is(err, lib.AttachmentTooLarge), errors.As(err, &maxBytesError):
+		r.restResponse(writer, http.StatusRequestEntityTooLarge, lib.AttachmentTooLarge.Error())
+	case errors.Is(err, lib.UnsupportedAttachmentType):
+		r.restResponse(writer, http.StatusUnsupportedMediaType, err.Error())
+	case errors.Is(err, lib.IncorrectParameters):
+		r.restResponse(writer, http.StatusBadRequest, err.Error())
+	default:
+		stdError(err.Error())
+		r.restResponse(writer, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// sniffAttachmentType detects the content type from the first bytes of a file, recognising EPUB which sniffs as a plain zip
+func sniffAttachmentType(head []byte) string {
+	// an EPUB zip must start with an uncompressed "mimetype" entry holding its media type
+	if bytes.HasPrefix(head, []byte("PK\x03\x04")) && bytes.Contains(head, []byte("mimetypeapplication/epub+zip")) {
+		return "application/epub+zip"
+	}
+	contentType, _, _ := strings.Cut(http.DetectContentType(head), ";")
+	return contentType
+}
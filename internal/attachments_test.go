@@ -0,0 +1,247 @@
+package internal
+
+import (
+	"bytes"
+	"dockerrestapi/db"
+	"dockerrestapi/lib"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAttachmentsMockStore(t *testing.T) {
+	blobs, err := db.CreateMockBlobStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testAttachments(t, blobs)
+}
+
+func TestAttachmentsFileStore(t *testing.T) {
+	blobs, err := db.CreateFileBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testAttachments(t, blobs)
+}
+
+func testAttachments(t *testing.T, blobs db.BlobStoreInterface) {
+	mockConn, err := db.CreateMockDBHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	attachmentApi, err := CreateRestApiService(mockConn, "8081", WithBlobStore(blobs), WithMaxAttachmentSize(64<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	book := lib.Book{Name: "covered", Author: "philip", Contents: "A covered read"}
+	err = mockConn.CreateNewBook(&book)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := map[string]string{paramLibrary: lib.DefaultLibraryName, paramID: book.ID}
+
+	// upload the same cover twice, stored once
+	cover := testPNG(t, 600, 300)
+	first := uploadAttachment(t, attachmentApi, params, "cover.png", cover, http.StatusOK)
+	second := uploadAttachment(t, attachmentApi, params, "copy.png", cover, http.StatusOK)
+	if first.ContentType != "image/png" || first.Size != int64(len(cover)) || first.Thumbnail == "" {
+		t.Error("unexpected attachment", first)
+	}
+	if first.ID == second.ID || first.SHA256 != second.SHA256 {
+		t.Error("expecting two attachments sharing one blob got", first, second)
+	}
+
+	response, err := testResponse(http.MethodGet, attachmentsPath, attachmentApi.getAttachments, nil, http.StatusOK, params)
+	if err != nil {
+		t.Error(err)
+	}
+	var attachments []lib.Attachment
+	err = json.Unmarshal([]byte(response), &attachments)
+	if err != nil || len(attachments) != 2 {
+		t.Error("expecting 2 attachments got", response)
+	}
+
+	// download the attachment and its thumbnail
+	firstParams := map[string]string{paramLibrary: lib.DefaultLibraryName, paramID: book.ID, paramAttachment: first.ID}
+	recorder := attachmentRequest(attachmentApi.getAttachment, firstParams)
+	if recorder.Code != http.StatusOK || !bytes.Equal(recorder.Body.Bytes(), cover) {
+		t.Error("expecting the uploaded cover got", recorder.Code)
+	}
+	recorder = attachmentRequest(attachmentApi.getAttachmentThumbnail, firstParams)
+	thumbnail, err := png.Decode(recorder.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if thumbnail.Bounds().Dx() != thumbnailSize || thumbnail.Bounds().Dy() != thumbnailSize/2 {
+		t.Error("expecting a 256x128 thumbnail got", thumbnail.Bounds())
+	}
+
+	// reject unsupported and oversized files
+	uploadAttachment(t, attachmentApi, params, "notes.txt", []byte("just some text"), http.StatusUnsupportedMediaType)
+	uploadAttachment(t, attachmentApi, params, "cover.webp", []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), http.StatusUnsupportedMediaType)
+	uploadAttachment(t, attachmentApi, params, "huge.png", append(testPNG(t, 1, 1), make([]byte, 64<<10)...), http.StatusRequestEntityTooLarge)
+
+	// epub is recognised although it sniffs as a zip
+	epub := append([]byte("PK\x03\x04"), make([]byte, 26)...)
+	epub = append(epub, []byte("mimetypeapplication/epub+zip")...)
+	uploaded := uploadAttachment(t, attachmentApi, params, "book.epub", epub, http.StatusOK)
+	if uploaded.ContentType != "application/epub+zip" {
+		t.Error("expecting application/epub+zip got", uploaded.ContentType)
+	}
+
+	// the shared blob stays until its last attachment is deleted
+	_, err = testResponse(http.MethodDelete, attachmentPath, attachmentApi.deleteAttachment, nil, http.StatusOK, firstParams)
+	if err != nil {
+		t.Error(err)
+	}
+	exists, err := blobs.HasBlob(lib.DefaultLibraryName, first.SHA256)
+	if err != nil || !exists {
+		t.Error("blob should still be referenced")
+	}
+	_, err = testResponse(http.MethodDelete, deleteBookPath, attachmentApi.deleteBook, nil, http.StatusOK, map[string]string{paramName: book.Name, paramAuthor: book.Author})
+	if err != nil {
+		t.Error(err)
+	}
+	exists, err = blobs.HasBlob(lib.DefaultLibraryName, first.SHA256)
+	if err != nil || !exists {
+		t.Error("blob should be kept while the book is in the trash")
+	}
+	_, err = testResponse(http.MethodDelete, trashBookPath, attachmentApi.purgeBook, nil, http.StatusOK, params)
+	if err != nil {
+		t.Error(err)
+	}
+	exists, err = blobs.HasBlob(lib.DefaultLibraryName, first.SHA256)
+	if err != nil || exists {
+		t.Error("blob should be deleted with the purged book")
+	}
+}
+
+func TestAttachmentBlobReuse(t *testing.T) {
+	mockBlobs, err := db.CreateMockBlobStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs := &reusedBlobStore{BlobStoreInterface: mockBlobs}
+	mockConn, err := db.CreateMockDBHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	attachmentApi, err := CreateRestApiService(mockConn, "8081", WithBlobStore(blobs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	book := lib.Book{Name: "covered", Author: "philip", Contents: "A covered read"}
+	err = mockConn.CreateNewBook(&book)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := map[string]string{paramLibrary: lib.DefaultLibraryName, paramID: book.ID}
+	cover := testPNG(t, 64, 64)
+	first := uploadAttachment(t, attachmentApi, params, "cover.png", cover, http.StatusOK)
+
+	// the last copy of the cover is deleted while an upload reuses its blob, the deletion waiting for the upload
+	deleted := make(chan int)
+	blobs.beforeReuse = func() {
+		go func() {
+			deleteParams := map[string]string{paramLibrary: lib.DefaultLibraryName, paramID: book.ID, paramAttachment: first.ID}
+			recorder := httptest.NewRecorder()
+			attachmentApi.deleteAttachment(recorder, mux.SetURLVars(httptest.NewRequest(http.MethodDelete, attachmentPath, nil), deleteParams))
+			deleted <- recorder.Code
+		}()
+		time.Sleep(50 * time.Millisecond)
+	}
+	second := uploadAttachment(t, attachmentApi, params, "copy.png", cover, http.StatusOK)
+	if code := <-deleted; code != http.StatusOK {
+		t.Error("expecting the first copy deleted got", code)
+	}
+	for _, hash := range []string{second.SHA256, second.Thumbnail} {
+		if exists, err := mockBlobs.HasBlob(lib.DefaultLibraryName, hash); err != nil || !exists {
+			t.Error("expecting the blobs of the second copy kept", hash, err)
+		}
+	}
+}
+
+// reusedBlobStore calls beforeReuse, once, when an upload finds its blob already stored
+type reusedBlobStore struct {
+	db.BlobStoreInterface
+	beforeReuse func()
+	once        sync.Once
+}
+
+// HasBlob checks whether a blob is stored, calling beforeReuse the first time it is
+func (s *reusedBlobStore) HasBlob(namespace, hash string) (bool, error) {
+	exists, err := s.BlobStoreInterface.HasBlob(namespace, hash)
+	if exists && s.beforeReuse != nil {
+		s.once.Do(s.beforeReuse)
+	}
+	return exists, err
+}
+
+// uploadAttachment uploads a file as a multipart form, returning the created attachment
+func uploadAttachment(t *testing.T, service *RestService, params map[string]string, fileName string, contents []byte, expectedStatus int) lib.Attachment {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile(attachmentFormField, fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = part.Write(contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = form.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPut, attachmentsPath, &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req = mux.SetURLVars(req, params)
+	recorder := httptest.NewRecorder()
+	service.createAttachment(recorder, req)
+	if recorder.Code != expectedStatus {
+		t.Error(fileName, "expecting", expectedStatus, "got", recorder.Code, recorder.Body.String())
+	}
+
+	attachment := lib.Attachment{}
+	if expectedStatus == http.StatusOK {
+		err = json.Unmarshal(recorder.Body.Bytes(), &attachment)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	return attachment
+}
+
+// attachmentRequest calls an attachment download handler, returning the recorded response
+func attachmentRequest(funcCall func(writer http.ResponseWriter, request *http.Request), params map[string]string) *httptest.ResponseRecorder {
+	req := mux.SetURLVars(httptest.NewRequest(http.MethodGet, attachmentPath, nil), params)
+	recorder := httptest.NewRecorder()
+	funcCall(recorder, req)
+	return recorder
+}
+
+// testPNG encodes a gradient image of the given size
+func testPNG(t *testing.T, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	var encoded bytes.Buffer
+	err := png.Encode(&encoded, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded.Bytes()
+}
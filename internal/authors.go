@@ -0,0 +1,231 @@
+package internal
+
+import (
+	"dockerrestapi/db"
+	"dockerrestapi/lib"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+const (
+	authorsPath     = libraryPath + "/authors"
+	authorPath      = authorsPath + "/{" + paramAuthorID + "}"
+	authorBooksPath = authorPath + "/books"
+	authorMergePath = authorPath + "/merge/{" + paramMergeInto + "}"
+	paramAuthorID   = "authorId"
+	paramMergeInto  = "into"
+)
+
+// getAuthors lists the authors of a library in name order.
+// eg : api/libraries/{library}/authors
+func (r *RestService) getAuthors(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Authors request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+
+	authors, err := library.GetAllAuthors()
+	if err != nil {
+		r.restAuthorError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, authors)
+}
+
+// createAuthor creates a new author, the books already written under its name or aliases are linked to it.
+// eg : api/libraries/{library}/authors
+func (r *RestService) createAuthor(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received create Author request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+
+	author := &lib.Author{}
+	err = json.NewDecoder(request.Body).Decode(author)
+	if err != nil {
+		r.restResponse(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	err = library.CreateNewAuthor(author)
+	if err != nil {
+		r.restAuthorError(writer, err)
+		return
+	}
+
+	libraryName := r.libraryNameFromRequest(request)
+	r.notifyLinkedBooks(library, libraryName, author.ID, nil)
+	writer.Header().Set("Location", LibrariesPath+"/"+libraryName+"/authors/"+author.ID)
+	r.restResponse(writer, http.StatusOK, author)
+}
+
+// getAuthor retrieves an author given the id in the path.
+// eg : api/libraries/{library}/authors/{authorId}
+func (r *RestService) getAuthor(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Author request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+
+	author, err := library.GetOneAuthor(mux.Vars(request)[paramAuthorID])
+	if err != nil {
+		r.restAuthorError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, author)
+}
+
+// updateAuthor replaces the name, aliases and biography of an author given the id in the path.
+// Its books follow a change of name, and the books written under new aliases are linked to it.
+// eg : api/libraries/{library}/authors/{authorId}
+func (r *RestService) updateAuthor(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received update Author request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+
+	author := &lib.Author{}
+	err = json.NewDecoder(request.Body).Decode(author)
+	if err != nil {
+		r.restResponse(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	author.ID = mux.Vars(request)[paramAuthorID]
+	existing, err := library.GetOneAuthor(author.ID)
+	if err != nil {
+		r.restAuthorError(writer, err)
+		return
+	}
+	var unchanged map[string]bool
+	if existing.Name == author.Name {
+		unchanged = r.linkedBookIDs(library, author.ID)
+	}
+
+	err = library.UpdateExistingAuthor(author)
+	if err != nil {
+		r.restAuthorError(writer, err)
+		return
+	}
+	r.notifyLinkedBooks(library, r.libraryNameFromRequest(request), author.ID, unchanged)
+	r.restResponse(writer, http.StatusOK, author)
+}
+
+// deleteAuthor deletes an author given the id in the path, which fails while books are linked to it, books in the trash included.
+// eg : api/libraries/{library}/authors/{authorId}
+func (r *RestService) deleteAuthor(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received delete Author request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+
+	err = library.DeleteAuthor(mux.Vars(request)[paramAuthorID])
+	if err != nil {
+		r.restAuthorError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, nil)
+}
+
+// getAuthorBooks lists the books linked to an author given the id in the path.
+// eg : api/libraries/{library}/authors/{authorId}/books
+func (r *RestService) getAuthorBooks(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Author Books request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+
+	authorID := mux.Vars(request)[paramAuthorID]
+	if _, err = library.GetOneAuthor(authorID); err != nil {
+		r.restAuthorError(writer, err)
+		return
+	}
+	books, err := library.GetAllBooks(lib.BookFilter{AuthorID: authorID})
+	if err != nil {
+		stdError(err.Error())
+		r.restResponse(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	r.restResponse(writer, http.StatusOK, books)
+}
+
+// mergeAuthor merges the author of the id in the path into another author, which takes its names as aliases and all its books.
+// eg : api/libraries/{library}/authors/{authorId}/merge/{into}
+func (r *RestService) mergeAuthor(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received merge Author request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+
+	params := mux.Vars(request)
+	unchanged := r.linkedBookIDs(library, params[paramMergeInto])
+	merged, err := library.MergeAuthors(params[paramAuthorID], params[paramMergeInto])
+	if err != nil {
+		r.restAuthorError(writer, err)
+		return
+	}
+	r.notifyLinkedBooks(library, r.libraryNameFromRequest(request), merged.ID, unchanged)
+	r.restResponse(writer, http.StatusOK, merged)
+}
+
+// linkedBookIDs returns the ids of the books linked to an author, when webhooks need to be told which books it gains
+func (r *RestService) linkedBookIDs(library db.RestDbInterface, authorID string) map[string]bool {
+	if r.webhooks == nil {
+		return nil
+	}
+	books, err := library.GetAllBooks(lib.BookFilter{AuthorID: authorID})
+	if err != nil {
+		stdError("cant list books of author " + authorID + " " + err.Error())
+		return nil
+	}
+	ids := map[string]bool{}
+	for _, book := range books {
+		ids[book.ID] = true
+	}
+	return ids
+}
+
+// notifyLinkedBooks notifies webhooks of the update of every book linked to an author, except the unchanged ones
+func (r *RestService) notifyLinkedBooks(library db.RestDbInterface, libraryName, authorID string, unchanged map[string]bool) {
+	if r.webhooks == nil {
+		return
+	}
+	books, err := library.GetAllBooks(lib.BookFilter{AuthorID: authorID})
+	if err != nil {
+		stdError("cant list books of author " + authorID + " " + err.Error())
+		return
+	}
+	for _, book := range books {
+		if !unchanged[book.ID] {
+			r.notifyWebhooks(libraryName, lib.ChangeUpdated, book)
+		}
+	}
+}
+
+// restAuthorError responds to a failed author operation with the status matching the error
+func (r *RestService) restAuthorError(writer http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, lib.NoMatchingAuthor):
+		r.restResponse(writer, http.StatusNotFound, err.Error())
+	case errors.Is(err, lib.IncompleteAuthor), errors.Is(err, lib.AuthorAlreadyExists), errors.Is(err, lib.AuthorHasBooks),
+		errors.Is(err, lib.CannotMergeAuthor), errors.Is(err, lib.BookAlreadyExists):
+		r.restResponse(writer, http.StatusBadRequest, err.Error())
+	default:
+		stdError(err.Error())
+		r.restResponse(writer, http.StatusInternalServerError, err.Error())
+	}
+}
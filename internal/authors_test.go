@@ -0,0 +1,294 @@
+package internal
+
+import (
+	"dockerrestapi/db"
+	"dockerrestapi/lib"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// TestBookAuthorChanges checks on every backend that a book changed to an unknown author is no longer linked to the
+// previous one, and that merging authors keeps every name
+func TestBookAuthorChanges(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, handler db.RestDbInterface, clock *lib.ManualClock) {
+		tolkien := &lib.Author{Name: "J. R. R. Tolkien"}
+		err := handler.CreateNewAuthor(tolkien)
+		if err != nil {
+			t.Fatal(err)
+		}
+		book := &lib.Book{Name: "the hobbit", Author: "J. R. R. Tolkien", Contents: "In a hole in the ground."}
+		err = handler.CreateNewBook(book)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if book.AuthorID != tolkien.ID {
+			t.Fatal("expecting the book linked to its author got", book.AuthorID)
+		}
+
+		book.Author = "Bilbo Baggins"
+		err = handler.UpdateExistingBook(book)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored, err := handler.GetOneBook(&lib.BookIdentifier{ID: book.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.AuthorID != "" {
+			t.Error("expecting the book no longer linked to its previous author got", stored.AuthorID)
+		}
+		if err = handler.DeleteAuthor(tolkien.ID); err != nil {
+			t.Error("expecting an author without books deleted got", err)
+		}
+
+		// the books and names of a merged author go to the target
+		bilbo := &lib.Author{Name: "Bilbo Baggins", Aliases: []string{"Mr. Baggins"}}
+		frodo := &lib.Author{Name: "Frodo Baggins"}
+		for _, author := range []*lib.Author{bilbo, frodo} {
+			if err = handler.CreateNewAuthor(author); err != nil {
+				t.Fatal(err)
+			}
+		}
+		merged, err := handler.MergeAuthors(bilbo.ID, frodo.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(merged.Aliases, []string{"Bilbo Baggins", "Mr. Baggins"}) {
+			t.Error("expecting the names of the source as aliases got", merged.Aliases)
+		}
+		if found, err := handler.FindAuthor("mr. baggins"); err != nil || found.ID != frodo.ID {
+			t.Error("expecting the target found by the aliases of the source got", found, err)
+		}
+		if stored, err = handler.GetOneBook(&lib.BookIdentifier{ID: book.ID}); err != nil || stored.AuthorID != frodo.ID || stored.Author != "Frodo Baggins" {
+			t.Error("expecting the books of the source re-pointed got", stored, err)
+		}
+		if _, err = handler.GetOneAuthor(bilbo.ID); err != lib.NoMatchingAuthor {
+			t.Error("expecting the source deleted got", err)
+		}
+
+		// names are matched ignoring case and accents, as books are told apart
+		for _, book := range []*lib.Book{
+			{Name: "Wuthering Heights", Author: "Emily Brontë", Contents: "A house on the moors."},
+			{Name: "wuthering heights", Author: "Ellis Bell", Contents: "The same house."},
+		} {
+			if err = handler.CreateNewBook(book); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = handler.CreateNewAuthor(&lib.Author{Name: "Emily Bronte", Aliases: []string{"Ellis Bell"}}); err != lib.BookAlreadyExists {
+			t.Error("expecting books sharing a name refused got", err)
+		}
+		if _, err = handler.FindAuthor("ellis bell"); err != lib.NoMatchingAuthor {
+			t.Error("expecting no author left behind got", err)
+		}
+		bronte := &lib.Author{Name: "Emily Bronte"}
+		if err = handler.CreateNewAuthor(bronte); err != nil {
+			t.Fatal(err)
+		}
+		if found, err := handler.FindAuthor("EMILY BRONTË"); err != nil || found.ID != bronte.ID {
+			t.Error("expecting the author found ignoring accents got", found, err)
+		}
+	})
+}
+
+func TestAuthors(t *testing.T) {
+	authorsApi, err := createMockApi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	library := map[string]string{paramLibrary: lib.DefaultLibraryName}
+	for _, book := range []lib.Book{
+		{Name: "philosopher's stone", Author: "JKR", Contents: "A boy lived."},
+		{Name: "chamber of secrets", Author: "J.K. Rowling", Contents: "A boy once nearly died."},
+		{Name: "the cuckoo's calling", Author: "Robert Galbraith", Contents: "A model fell."},
+	} {
+		err = authorsApi.db.CreateNewBook(&book)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// books written under any name of a new author are linked to it
+	rowling := createTestAuthor(t, authorsApi, lib.Author{Name: " J.K. Rowling ", Aliases: []string{"JKR", "jkr", "j.k. rowling"}, Biography: "Wrote a boy."}, http.StatusOK)
+	if rowling.ID == "" || rowling.Name != "J.K. Rowling" || len(rowling.Aliases) != 1 || rowling.Aliases[0] != "JKR" {
+		t.Error("expecting a normalised author got", rowling)
+	}
+	authorParams := map[string]string{paramLibrary: lib.DefaultLibraryName, paramAuthorID: rowling.ID}
+	if books := getAuthorBooks(t, authorsApi, authorParams); len(books) != 2 {
+		t.Error("expecting two books of JKR got", books)
+	}
+
+	// books are created and found by any name of their author
+	response, err := testResponse(http.MethodGet, getBookPath, authorsApi.getBook, nil, http.StatusOK, map[string]string{paramName: "philosopher's stone", paramAuthor: "jkr"})
+	if err != nil {
+		t.Error(err)
+	}
+	book := lib.Book{}
+	err = json.Unmarshal([]byte(response), &book)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.Author != "J.K. Rowling" || book.AuthorID != rowling.ID {
+		t.Error("expecting the book under the canonical name got", book)
+	}
+	created := lib.Book{Name: "prisoner of azkaban", Author: "JKR", Contents: "A godfather escaped."}
+	marshalCreated, err := json.Marshal(created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = testResponse(http.MethodPut, libraryBooksPath, authorsApi.createBook, marshalCreated, http.StatusOK, library)
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = testResponse(http.MethodPut, libraryBooksPath, authorsApi.createBook, marshalCreated, http.StatusBadRequest, library)
+	if err != nil {
+		t.Error(err)
+	}
+	if books := getAuthorBooks(t, authorsApi, authorParams); len(books) != 3 {
+		t.Error("expecting three books of JKR got", books)
+	}
+
+	// names are unique across authors
+	createTestAuthor(t, authorsApi, lib.Author{Name: "Robert Galbraith", Aliases: []string{"jkr"}}, http.StatusBadRequest)
+	createTestAuthor(t, authorsApi, lib.Author{Aliases: []string{"Galbraith"}}, http.StatusBadRequest)
+	galbraith := createTestAuthor(t, authorsApi, lib.Author{Name: "Robert Galbraith"}, http.StatusOK)
+	galbraithParams := map[string]string{paramLibrary: lib.DefaultLibraryName, paramAuthorID: galbraith.ID}
+
+	// authors with books cannot be deleted, they are merged instead
+	_, err = testResponse(http.MethodDelete, authorPath, authorsApi.deleteAuthor, nil, http.StatusBadRequest, galbraithParams)
+	if err != nil {
+		t.Error(err)
+	}
+	mergeParams := map[string]string{paramLibrary: lib.DefaultLibraryName, paramAuthorID: galbraith.ID, paramMergeInto: galbraith.ID}
+	_, err = testResponse(http.MethodPut, authorMergePath, authorsApi.mergeAuthor, nil, http.StatusBadRequest, mergeParams)
+	if err != nil {
+		t.Error(err)
+	}
+	mergeParams[paramMergeInto] = rowling.ID
+	response, err = testResponse(http.MethodPut, authorMergePath, authorsApi.mergeAuthor, nil, http.StatusOK, mergeParams)
+	if err != nil {
+		t.Error(err)
+	}
+	merged := lib.Author{}
+	err = json.Unmarshal([]byte(response), &merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.ID != rowling.ID || len(merged.Aliases) != 2 || merged.Aliases[1] != "Robert Galbraith" {
+		t.Error("expecting Galbraith to be an alias of Rowling got", merged)
+	}
+	if books := getAuthorBooks(t, authorsApi, authorParams); len(books) != 4 {
+		t.Error("expecting four books of JKR got", books)
+	}
+	_, err = testResponse(http.MethodGet, authorPath, authorsApi.getAuthor, nil, http.StatusNotFound, galbraithParams)
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = authorsApi.db.GetOneBook(&lib.BookIdentifier{Name: "the cuckoo's calling", Author: "Robert Galbraith"})
+	if err != nil {
+		t.Error("expecting the book to be found by its former author", err)
+	}
+
+	// a renamed author renames its books
+	renamed := merged
+	renamed.Name = "Joanne Rowling"
+	renamed.Aliases = append(renamed.Aliases, "J.K. Rowling")
+	marshalRenamed, err := json.Marshal(renamed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = testResponse(http.MethodPut, authorPath, authorsApi.updateAuthor, marshalRenamed, http.StatusOK, authorParams)
+	if err != nil {
+		t.Error(err)
+	}
+	stored, err := authorsApi.db.GetOneBook(&lib.BookIdentifier{Name: "chamber of secrets", Author: "JKR"})
+	if err != nil || stored.Author != "Joanne Rowling" {
+		t.Error("expecting the book to follow its author got", stored, err)
+	}
+
+	// merges making two books share a name and author are refused
+	anonymous := createTestAuthor(t, authorsApi, lib.Author{Name: "Anonymous"}, http.StatusOK)
+	err = authorsApi.db.CreateNewBook(&lib.Book{Name: "chamber of secrets", Author: "Anonymous", Contents: "A copy."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = testResponse(http.MethodPut, authorMergePath, authorsApi.mergeAuthor, nil, http.StatusBadRequest,
+		map[string]string{paramLibrary: lib.DefaultLibraryName, paramAuthorID: anonymous.ID, paramMergeInto: rowling.ID})
+	if err != nil {
+		t.Error(err)
+	}
+	anonymousParams := map[string]string{paramLibrary: lib.DefaultLibraryName, paramAuthorID: anonymous.ID}
+	if books := getAuthorBooks(t, authorsApi, anonymousParams); len(books) != 1 {
+		t.Error("expecting the refused merge to change nothing got", books)
+	}
+
+	// authors without books can be deleted
+	err = authorsApi.db.DeleteBook(&lib.BookIdentifier{Name: "chamber of secrets", Author: "anonymous"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = testResponse(http.MethodDelete, authorPath, authorsApi.deleteAuthor, nil, http.StatusBadRequest, anonymousParams)
+	if err != nil {
+		t.Error("expecting books in the trash to keep their author", err)
+	}
+	_, err = testResponse(http.MethodDelete, trashPath, authorsApi.emptyTrash, nil, http.StatusOK, library)
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = testResponse(http.MethodDelete, authorPath, authorsApi.deleteAuthor, nil, http.StatusOK, anonymousParams)
+	if err != nil {
+		t.Error(err)
+	}
+
+	response, err = testResponse(http.MethodGet, authorsPath, authorsApi.getAuthors, nil, http.StatusOK, library)
+	if err != nil {
+		t.Error(err)
+	}
+	var authors []lib.Author
+	err = json.Unmarshal([]byte(response), &authors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(authors) != 1 || authors[0].Name != "Joanne Rowling" {
+		t.Error("expecting only Joanne Rowling got", authors)
+	}
+}
+
+// createTestAuthor creates an author, returning the created author
+func createTestAuthor(t *testing.T, service *RestService, author lib.Author, expectedStatus int) lib.Author {
+	t.Helper()
+	marshalAuthor, err := json.Marshal(author)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := testResponse(http.MethodPut, authorsPath, service.createAuthor, marshalAuthor, expectedStatus, map[string]string{paramLibrary: lib.DefaultLibraryName})
+	if err != nil {
+		t.Error(err)
+		return lib.Author{}
+	}
+	created := lib.Author{}
+	if expectedStatus == http.StatusOK {
+		err = json.Unmarshal([]byte(response), &created)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return created
+}
+
+// getAuthorBooks lists the books of an author
+func getAuthorBooks(t *testing.T, service *RestService, params map[string]string) []lib.BookIdentifier {
+	t.Helper()
+	response, err := testResponse(http.MethodGet, authorBooksPath, service.getAuthorBooks, nil, http.StatusOK, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var books []lib.BookIdentifier
+	err = json.Unmarshal([]byte(response), &books)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return books
+}
@@ -0,0 +1,183 @@
+package internal
+
+import (
+	"context"
+	"dockerrestapi/db"
+	"dockerrestapi/lib"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"time"
+)
+
+const (
+	libraryChangesPath   = libraryPath + "/changes"
+	libraryChangesWsPath = libraryChangesPath + "/ws"
+	paramSince           = "since"
+)
+
+var (
+	changesKeepAlive = 15 * time.Second // idle streams send a comment or ping this often to keep proxies from closing them
+	changesUpgrader  = websocket.Upgrader{}
+)
+
+// streamChanges streams the book changes of a library as Server-Sent Events, each event id being its resume token.
+// A reconnecting client resumes with the Last-Event-ID header or the since query parameter.
+// eg : api/libraries/{library}/changes?since={token}
+func (r *RestService) streamChanges(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received stream Changes request")
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		r.restResponse(writer, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+	resumeToken := request.Header.Get("Last-Event-ID")
+	if resumeToken == "" {
+		resumeToken = request.URL.Query().Get(paramSince)
+	}
+	stream, ok := r.watchBooks(writer, request, resumeToken)
+	if !ok {
+		return
+	}
+	defer stream.Close()
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	err := r.forwardChanges(request.Context(), stream, func(event *lib.ChangeEvent) error {
+		if event == nil {
+			_, err := fmt.Fprint(writer, ": keepalive\n\n")
+			flusher.Flush()
+			return err
+		}
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(writer, "id: %s\nevent: %s\ndata: %s\n\n", event.Token, event.Type, data)
+		flusher.Flush()
+		return err
+	})
+	if err != nil && !errors.Is(err, context.Canceled) {
+		stdError("change stream ended " + err.Error())
+	}
+}
+
+// streamChangesWebSocket streams the book changes of a library over a WebSocket as JSON messages carrying their resume token.
+// A reconnecting client resumes with the since query parameter.
+// eg : api/libraries/{library}/changes/ws?since={token}
+func (r *RestService) streamChangesWebSocket(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received stream Changes WebSocket request")
+	stream, ok := r.watchBooks(writer, request, request.URL.Query().Get(paramSince))
+	if !ok {
+		return
+	}
+	defer stream.Close()
+
+	conn, err := changesUpgrader.Upgrade(writer, request, nil)
+	if err != nil {
+		stdError("cant upgrade to websocket " + err.Error()) // the upgrader already responded
+		return
+	}
+	defer conn.Close()
+
+	// the client only sends control frames, reading them notices when it goes away
+	ctx, cancel := context.WithCancel(request.Context())
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	err = r.forwardChanges(ctx, stream, func(event *lib.ChangeEvent) error {
+		if event == nil {
+			return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(changesKeepAlive))
+		}
+		return conn.WriteJSON(event)
+	})
+	if err != nil && !errors.Is(err, context.Canceled) {
+		stdError("change stream ended " + err.Error())
+		_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, err.Error()), time.Now().Add(time.Second))
+	}
+}
+
+// watchBooks opens the change stream of the library of a request, responding with the failure when it cant
+func (r *RestService) watchBooks(writer http.ResponseWriter, request *http.Request, resumeToken string) (db.ChangeStream, bool) {
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return nil, false
+	}
+	stream, err := library.WatchBooks(resumeToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, lib.IncorrectResumeToken):
+			r.restResponse(writer, http.StatusBadRequest, err.Error())
+		case errors.Is(err, lib.ResumeTokenExpired):
+			r.restResponse(writer, http.StatusGone, err.Error())
+		default:
+			stdError(err.Error())
+			r.restResponse(writer, http.StatusInternalServerError, err.Error())
+		}
+		return nil, false
+	}
+	return stream, true
+}
+
+// forwardChanges sends every event of the stream until the context is done or sending fails,
+// calling send with nil when the stream has been idle for the keep alive period. It returns once the stream is no longer
+// read, streams not being safe for concurrent use, so that the caller may close it.
+func (r *RestService) forwardChanges(ctx context.Context, stream db.ChangeStream, send func(event *lib.ChangeEvent) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	events := make(chan lib.ChangeEvent)
+	failed := make(chan error, 1)
+	go func() {
+		defer close(done)
+		for {
+			event, err := stream.Next(ctx)
+			if err != nil {
+				failed <- err
+				return
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	keepAlive := time.NewTicker(changesKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event := <-events:
+			if err := send(&event); err != nil {
+				return err
+			}
+			keepAlive.Reset(changesKeepAlive)
+		case <-keepAlive.C:
+			if err := send(nil); err != nil {
+				return err
+			}
+		case err := <-failed:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"dockerrestapi/lib"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestChangesServerSentEvents(t *testing.T) {
+	changesApi, err := createMockApi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(changesApi.router)
+	defer server.Close()
+	changesUrl := server.URL + LibrariesPath + "/" + lib.DefaultLibraryName + "/changes"
+
+	// events published while streaming are received in order
+	response, events := openEventStream(t, changesUrl, "")
+	book := lib.Book{Name: "watched", Author: "philip", Contents: "A watched read"}
+	err = changesApi.db.CreateNewBook(&book)
+	if err != nil {
+		t.Fatal(err)
+	}
+	book.Contents = "A rewatched read"
+	err = changesApi.db.UpdateExistingBook(&book)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := readEvent(t, events)
+	updated := readEvent(t, events)
+	response.Body.Close()
+	if created.Type != lib.ChangeCreated || created.BookID != book.ID || created.Name != book.Name || updated.Type != lib.ChangeUpdated {
+		t.Error("expecting created then updated events got", created, updated)
+	}
+
+	// a reconnecting client resumes after its last event, missing nothing
+	err = changesApi.db.DeleteBook(&lib.BookIdentifier{ID: book.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, events = openEventStream(t, changesUrl, created.Token)
+	defer response.Body.Close()
+	if resumed := readEvent(t, events); resumed.Token != updated.Token {
+		t.Error("expecting the updated event again got", resumed)
+	}
+	if deleted := readEvent(t, events); deleted.Type != lib.ChangeDeleted || deleted.BookID != book.ID {
+		t.Error("expecting the deleted event got", deleted)
+	}
+
+	// bad and unknown tokens are refused
+	for token, status := range map[string]int{"not-a-token": http.StatusBadRequest, "99": http.StatusBadRequest} {
+		refused, err := http.Get(changesUrl + "?since=" + token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		refused.Body.Close()
+		if refused.StatusCode != status {
+			t.Error(token, "expecting", status, "got", refused.StatusCode)
+		}
+	}
+}
+
+func TestChangesWebSocket(t *testing.T) {
+	changesApi, err := createMockApi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(changesApi.router)
+	defer server.Close()
+	wsUrl := "ws" + strings.TrimPrefix(server.URL, "http") + LibrariesPath + "/" + lib.DefaultLibraryName + "/changes/ws"
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsUrl, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	book := lib.Book{Name: "socketed", Author: "philip", Contents: "A socketed read"}
+	err = changesApi.db.CreateNewBook(&book)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = changesApi.db.DeleteBook(&lib.BookIdentifier{ID: book.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var created, deleted lib.ChangeEvent
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err = conn.ReadJSON(&created); err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.ReadJSON(&deleted); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if created.Type != lib.ChangeCreated || deleted.Type != lib.ChangeDeleted || deleted.BookID != book.ID {
+		t.Error("expecting created then deleted events got", created, deleted)
+	}
+
+	// resume after the created event
+	conn, _, err = websocket.DefaultDialer.Dial(wsUrl+"?since="+created.Token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var resumed lib.ChangeEvent
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err = conn.ReadJSON(&resumed); err != nil {
+		t.Fatal(err)
+	}
+	if resumed.Token != deleted.Token {
+		t.Error("expecting the deleted event again got", resumed)
+	}
+}
+
+func TestChangesDroppedLibrary(t *testing.T) {
+	changesApi, err := createMockApi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = changesApi.db.CreateLibrary("dropped")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(changesApi.router)
+	defer server.Close()
+	wsUrl := "ws" + strings.TrimPrefix(server.URL, "http") + LibrariesPath + "/dropped/changes/ws"
+
+	// streams of a dropped library end rather than staying open forever
+	conn, _, err := websocket.DefaultDialer.Dial(wsUrl, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	err = changesApi.db.DropLibrary("dropped")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var event lib.ChangeEvent
+	err = conn.ReadJSON(&event)
+	var closed *websocket.CloseError
+	if !errors.As(err, &closed) || closed.Text != lib.ChangeStreamClosed.Error() {
+		t.Error("expecting the stream closed got", err)
+	}
+}
+
+func TestForwardChangesStopsReading(t *testing.T) {
+	changesApi, err := createMockApi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// streams are closed once forwarding returns, which must no longer be reading them
+	stream := &slowChangeStream{started: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stream.started
+		cancel()
+	}()
+	err = changesApi.forwardChanges(ctx, stream, func(event *lib.ChangeEvent) error { return nil })
+	if !errors.Is(err, context.Canceled) {
+		t.Error("expecting the forwarding cancelled got", err)
+	}
+	if stream.reading.Load() {
+		t.Error("expecting the stream no longer read once forwarding returned")
+	}
+}
+
+// slowChangeStream is a change stream taking a while to notice its context is done
+type slowChangeStream struct {
+	reading atomic.Bool
+	started chan struct{} // closed once first read
+}
+
+func (s *slowChangeStream) Next(ctx context.Context) (lib.ChangeEvent, error) {
+	s.reading.Store(true)
+	defer s.reading.Store(false)
+	select {
+	case <-s.started:
+	default:
+		close(s.started)
+	}
+	<-ctx.Done()
+	time.Sleep(20 * time.Millisecond)
+	return lib.ChangeEvent{}, ctx.Err()
+}
+
+func (s *slowChangeStream) Close() error {
+	return nil
+}
+
+// openEventStream connects to a Server-Sent Events stream, returning the response and a channel of its decoded events
+func openEventStream(t *testing.T, url, lastEventID string) (*http.Response, chan lib.ChangeEvent) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatal("expecting an event stream got", response.StatusCode, response.Header.Get("Content-Type"))
+	}
+
+	events := make(chan lib.ChangeEvent, 16)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(response.Body)
+		for scanner.Scan() {
+			data, isData := strings.CutPrefix(scanner.Text(), "data: ")
+			if !isData {
+				continue
+			}
+			var event lib.ChangeEvent
+			if json.Unmarshal([]byte(data), &event) == nil {
+				events <- event
+			}
+		}
+	}()
+	return response, events
+}
+
+// readEvent waits for the next event of a stream
+func readEvent(t *testing.T, events chan lib.ChangeEvent) lib.ChangeEvent {
+	select {
+	case event, open := <-events:
+		if !open {
+			t.Fatal("event stream closed")
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+	}
+	return lib.ChangeEvent{}
+}
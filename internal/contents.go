@@ -0,0 +1,166 @@
+package internal
+
+import (
+	"dockerrestapi/db"
+	"dockerrestapi/lib"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	libraryBookContentsPath = libraryBookPath + "/contents"
+)
+
+// getBookContents streams the contents of a book given the id in the path, a single byte range may be requested with the Range header.
+// The Accept header chooses between the stored Markdown, the default, and the contents rendered into HTML or plain text.
+// eg : api/libraries/{library}/books/{id}/contents
+func (r *RestService) getBookContents(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Book Contents request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+
+	bookIdentifier, err := r.createBookIdentifierFromParams(mux.Vars(request))
+	if err != nil {
+		r.restResponse(writer, http.StatusBadRequest, lib.IncorrectParameters)
+		return
+	}
+
+	writer.Header().Set("Vary", "Accept")
+	mediaType, err := negotiateContentsType(request.Header.Get("Accept"))
+	if err != nil {
+		r.restResponse(writer, http.StatusNotAcceptable, err.Error())
+		return
+	}
+
+	var contents db.ContentsReader
+	if mediaType == mediaTypeMarkdown {
+		contents, err = library.GetBookContents(bookIdentifier)
+	} else {
+		contents, err = r.renderedContents(library, r.libraryNameFromRequest(request), bookIdentifier, mediaType)
+	}
+	if err != nil {
+		if errors.Is(err, lib.NoMatchingBook) {
+			r.restResponse(writer, http.StatusNotFound, err.Error())
+			return
+		}
+		stdError(err.Error())
+		r.restResponse(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer contents.Close()
+
+	size := contents.Size()
+	start, length, partial, err := parseRange(request.Header.Get("Range"), size)
+	if err != nil {
+		writer.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		r.restResponse(writer, http.StatusRequestedRangeNotSatisfiable, err.Error())
+		return
+	}
+	if start > 0 {
+		_, err = contents.Skip(start)
+		if err != nil {
+			stdError(err.Error())
+			r.restResponse(writer, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	writer.Header().Set("Content-Type", mediaType+"; charset=utf-8")
+	writer.Header().Set("Accept-Ranges", "bytes")
+	writer.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	status := http.StatusOK
+	if partial {
+		writer.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, size))
+		status = http.StatusPartialContent
+	}
+	writer.WriteHeader(status)
+
+	_, err = io.CopyN(writer, contents, length)
+	if err != nil {
+		stdError("cant stream contents " + err.Error())
+	}
+}
+
+// storeBookContents replaces the contents of a book given the id in the path with the raw request body, streamed to storage.
+// eg : api/libraries/{library}/books/{id}/contents
+func (r *RestService) storeBookContents(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received store Book Contents request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+
+	bookIdentifier, err := r.createBookIdentifierFromParams(mux.Vars(request))
+	if err != nil {
+		r.restResponse(writer, http.StatusBadRequest, lib.IncorrectParameters)
+		return
+	}
+
+	err = library.StoreBookContents(bookIdentifier, request.Body)
+	if err != nil {
+		if errors.Is(err, lib.NoMatchingBook) {
+			r.restResponse(writer, http.StatusNotFound, err.Error())
+			return
+		}
+		stdError(err.Error())
+		r.restResponse(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	r.notifyWebhooks(r.libraryNameFromRequest(request), lib.ChangeUpdated, *bookIdentifier)
+	r.restResponse(writer, http.StatusOK, nil)
+}
+
+// parseRange parses a single "bytes=" Range header against the contents size, returning the start and length to serve.
+// Missing, malformed or multiple ranges are ignored and the full contents are served, as allowed by RFC 7233.
+func parseRange(header string, size int64) (start, length int64, partial bool, err error) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, size, false, nil
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, size, false, nil
+	}
+
+	if first == "" { // suffix range, the last n bytes
+		suffix, parseErr := strconv.ParseInt(last, 10, 64)
+		if parseErr != nil || suffix < 0 {
+			return 0, size, false, nil
+		}
+		if suffix == 0 || size == 0 {
+			return 0, 0, false, lib.UnsatisfiableRange
+		}
+		if suffix > size {
+			suffix = size
+		}
+		return size - suffix, suffix, true, nil
+	}
+
+	start, parseErr := strconv.ParseInt(first, 10, 64)
+	if parseErr != nil || start < 0 {
+		return 0, size, false, nil
+	}
+	end := size - 1
+	if last != "" {
+		requestedEnd, parseErr := strconv.ParseInt(last, 10, 64)
+		if parseErr != nil || requestedEnd < start {
+			return 0, size, false, nil
+		}
+		if requestedEnd < end {
+			end = requestedEnd
+		}
+	}
+	if start >= size {
+		return 0, 0, false, lib.UnsatisfiableRange
+	}
+	return start, end - start + 1, true, nil
+}
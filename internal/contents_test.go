@@ -0,0 +1,139 @@
+package internal
+
+import (
+	"bytes"
+	"dockerrestapi/db"
+	"dockerrestapi/lib"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBookContents(t *testing.T) {
+	// shrink the threshold and chunks so the test contents are stored chunked
+	previousThreshold, previousChunkSize := db.ContentsFileThreshold, db.ContentsChunkSize
+	db.ContentsFileThreshold, db.ContentsChunkSize = 16, 8
+	defer func() {
+		db.ContentsFileThreshold, db.ContentsChunkSize = previousThreshold, previousChunkSize
+	}()
+
+	contentsApi, err := createMockApi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	largeBook := lib.Book{
+		Name:     "large",
+		Author:   "philip",
+		Contents: "0123456789abcdefghijklmnopqrstuvwxyz",
+	}
+	marshalLargeBook, err := json.Marshal(largeBook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = testResponse(http.MethodPut, createBookPath, contentsApi.createBook, marshalLargeBook, http.StatusOK, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := contentsApi.db.GetOneBook(&lib.BookIdentifier{Name: largeBook.Name, Author: largeBook.Author})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Contents != "" || stored.ContentsLength != int64(len(largeBook.Contents)) {
+		t.Error("expecting contents out of the book document got", stored.Contents, stored.ContentsLength)
+	}
+	params := map[string]string{paramLibrary: lib.DefaultLibraryName, paramID: stored.ID}
+
+	// full read
+	recorder := contentsRequest(contentsApi.getBookContents, http.MethodGet, "", nil, params)
+	if recorder.Code != http.StatusOK || recorder.Body.String() != largeBook.Contents {
+		t.Error("expecting", largeBook.Contents, "got", recorder.Code, recorder.Body.String())
+	}
+	if recorder.Header().Get("Content-Length") != "36" {
+		t.Error("expecting content length 36 got", recorder.Header().Get("Content-Length"))
+	}
+
+	// ranges across chunk boundaries
+	ranges := map[string]string{
+		"bytes=5-17": "56789abcdefgh",
+		"bytes=30-":  "uvwxyz",
+		"bytes=-4":   "wxyz",
+		"bytes=0-0":  "0",
+	}
+	for rangeHeader, expected := range ranges {
+		recorder = contentsRequest(contentsApi.getBookContents, http.MethodGet, rangeHeader, nil, params)
+		if recorder.Code != http.StatusPartialContent || recorder.Body.String() != expected {
+			t.Error(rangeHeader, "expecting", expected, "got", recorder.Code, recorder.Body.String())
+		}
+	}
+	recorder = contentsRequest(contentsApi.getBookContents, http.MethodGet, "bytes=5-9", nil, params)
+	if recorder.Header().Get("Content-Range") != "bytes 5-9/36" {
+		t.Error("expecting content range bytes 5-9/36 got", recorder.Header().Get("Content-Range"))
+	}
+	recorder = contentsRequest(contentsApi.getBookContents, http.MethodGet, "bytes=36-", nil, params)
+	if recorder.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Error("expecting", http.StatusRequestedRangeNotSatisfiable, "got", recorder.Code)
+	}
+
+	// upload small contents, now stored inline
+	recorder = contentsRequest(contentsApi.storeBookContents, http.MethodPut, "", []byte("short"), params)
+	if recorder.Code != http.StatusOK {
+		t.Error("expecting", http.StatusOK, "got", recorder.Code)
+	}
+	stored, err = contentsApi.db.GetOneBook(&lib.BookIdentifier{ID: stored.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Contents != "short" || stored.ContentsFile != "" {
+		t.Error("expecting inline contents got", stored.Contents, stored.ContentsFile)
+	}
+
+	// upload large contents again
+	large := strings.Repeat("long contents ", 10)
+	contentsRequest(contentsApi.storeBookContents, http.MethodPut, "", []byte(large), params)
+	recorder = contentsRequest(contentsApi.getBookContents, http.MethodGet, "", nil, params)
+	if recorder.Body.String() != large {
+		t.Error("expecting", large, "got", recorder.Body.String())
+	}
+
+	// unknown book
+	recorder = contentsRequest(contentsApi.getBookContents, http.MethodGet, "", nil, map[string]string{paramID: "unknown"})
+	if recorder.Code != http.StatusNotFound {
+		t.Error("expecting", http.StatusNotFound, "got", recorder.Code)
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	start, length, partial, err := parseRange("bytes=0-1,4-5", 10)
+	if err != nil || partial || start != 0 || length != 10 {
+		t.Error("multiple ranges should serve everything")
+	}
+	_, _, partial, err = parseRange("bytes=5-2", 10)
+	if err != nil || partial {
+		t.Error("malformed ranges should be ignored")
+	}
+	start, length, partial, err = parseRange("bytes=8-100", 10)
+	if err != nil || !partial || start != 8 || length != 2 {
+		t.Error("expecting 8 and 2 got", start, length)
+	}
+	_, _, _, err = parseRange("bytes=-0", 10)
+	if err == nil {
+		t.Error("should of failed here")
+	}
+}
+
+// contentsRequest calls a contents handler with an optional range header, returning the recorded response
+func contentsRequest(funcCall func(writer http.ResponseWriter, request *http.Request), httpMethod, rangeHeader string,
+	input []byte, params map[string]string) *httptest.ResponseRecorder {
+
+	req := httptest.NewRequest(httpMethod, libraryBookContentsPath, bytes.NewBuffer(input))
+	if rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
+	req = mux.SetURLVars(req, params)
+	recorder := httptest.NewRecorder()
+	funcCall(recorder, req)
+	return recorder
+}
@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"bytes"
+	"dockerrestapi/lib"
+	"errors"
+	"github.com/gorilla/mux"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+const (
+	libraryBooksEPUBPath = libraryBooksPath + "/epub"
+	libraryBookEPUBPath  = libraryBookPath + "/epub"
+)
+
+// importEPUB creates a book from an EPUB uploaded as the raw request body, with the title, creators, language and
+// chapters of the EPUB, responding with the identifier of the book created. Uploads are limited as attachments are.
+// eg : api/libraries/{library}/books/epub
+func (r *RestService) importEPUB(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received import EPUB request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+
+	request.Body = http.MaxBytesReader(writer, request.Body, r.maxAttachmentSize)
+	data, err := io.ReadAll(request.Body)
+	if err != nil {
+		r.restEPUBError(writer, err)
+		return
+	}
+	book, err := lib.ImportEPUB(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		r.restEPUBError(writer, err)
+		return
+	}
+	err = r.validateStoreBookRequest(book)
+	if err != nil {
+		r.restEPUBError(writer, err)
+		return
+	}
+
+	err = library.CreateNewBook(book)
+	if err != nil {
+		r.restEPUBError(writer, err)
+		return
+	}
+	r.notifyWebhooks(r.libraryNameFromRequest(request), lib.ChangeCreated, book.Identifier())
+	writer.Header().Set("Location", libraryBookLocation(r.libraryNameFromRequest(request), book.ID))
+	r.restResponse(writer, http.StatusOK, book.Identifier())
+}
+
+// exportEPUB downloads a book given the id in the path as an EPUB 3, a document for each of its top level chapters.
+// eg : api/libraries/{library}/books/{id}/epub
+func (r *RestService) exportEPUB(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received export EPUB request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+
+	bookIdentifier := &lib.BookIdentifier{ID: mux.Vars(request)[paramID]}
+	book, err := library.GetOneBook(bookIdentifier)
+	if err != nil {
+		r.restEPUBError(writer, err)
+		return
+	}
+	contents, err := library.GetBookContents(bookIdentifier)
+	if err != nil {
+		r.restEPUBError(writer, err)
+		return
+	}
+	defer contents.Close()
+	text, err := io.ReadAll(contents)
+	if err != nil {
+		r.restEPUBError(writer, err)
+		return
+	}
+	var epub bytes.Buffer
+	err = lib.ExportEPUB(book, string(text), &epub)
+	if err != nil {
+		r.restEPUBError(writer, err)
+		return
+	}
+
+	writer.Header().Set("Content-Type", lib.MediaTypeEPUB)
+	writer.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": book.Name + ".epub"}))
+	writer.Header().Set("Content-Length", strconv.Itoa(epub.Len()))
+	writer.WriteHeader(http.StatusOK)
+	_, err = epub.WriteTo(writer)
+	if err != nil {
+		stdError("cant stream epub " + err.Error())
+	}
+}
+
+// restEPUBError responds with the status matching an EPUB import or export error
+func (r *RestService) restEPUBError(writer http.ResponseWriter, err error) {
+	var maxBytesError *http.MaxBytesError
+	switch {
+	case errors.Is(err, lib.NoMatchingBook):
+		r.restResponse(writer, http.StatusNotFound, err.Error())
+	case errors.As(err, &maxBytesError):
+		r.restResponse(writer, http.StatusRequestEntityTooLarge, lib.EPUBTooLarge.Error())
+	case errors.Is(err, lib.IncorrectEPUB), errors.Is(err, lib.IncompleteBook), errors.Is(err, lib.BookAlreadyExists):
+		r.restResponse(writer, http.StatusBadRequest, err.Error())
+	default:
+		stdError(err.Error())
+		r.restResponse(writer, http.StatusInternalServerError, err.Error())
+	}
+}
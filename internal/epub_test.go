@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"bytes"
+	"dockerrestapi/lib"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEPUB(t *testing.T) {
+	epubApi, err := createMockApi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := "# An unexpected party\n\nIn a hole in the ground there lived a *hobbit*.\n\n# Roast mutton\n\nUp jumped Bilbo."
+	var upload bytes.Buffer
+	err = lib.ExportEPUB(&lib.Book{Name: "the hobbit", Author: "Tolkien", Language: "en"}, contents, &upload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the import route is matched before the book route
+	importPath := libraryBookLocation(lib.DefaultLibraryName, "epub")
+	response, err := testResponse(http.MethodPut, importPath, epubApi.router.ServeHTTP, upload.Bytes(), http.StatusOK, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identifier := lib.BookIdentifier{}
+	err = json.Unmarshal([]byte(response), &identifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	book, err := epubApi.db.GetOneBook(&identifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.Name != "the hobbit" || book.Author != "Tolkien" || book.Language != "en" || book.Contents != contents {
+		t.Error("expecting the book of the epub got", book)
+	}
+	_, err = testResponse(http.MethodPut, importPath, epubApi.router.ServeHTTP, upload.Bytes(), http.StatusBadRequest, nil)
+	if err != nil {
+		t.Error("expecting an existing book refused", err)
+	}
+	_, err = testResponse(http.MethodPut, importPath, epubApi.router.ServeHTTP, []byte("not an epub"), http.StatusBadRequest, nil)
+	if err != nil {
+		t.Error("expecting an incorrect epub refused", err)
+	}
+
+	// the export is an epub of the book
+	params := map[string]string{paramLibrary: lib.DefaultLibraryName, paramID: book.ID}
+	recorder := epubRequest(epubApi, params)
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != lib.MediaTypeEPUB ||
+		recorder.Header().Get("Content-Disposition") != `attachment; filename="the hobbit.epub"` {
+		t.Fatal("expecting an epub download got", recorder.Code, recorder.Header())
+	}
+	exported, err := lib.ImportEPUB(bytes.NewReader(recorder.Body.Bytes()), int64(recorder.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exported.Name != book.Name || exported.Author != book.Author || exported.Contents != contents {
+		t.Error("expecting the exported book got", exported)
+	}
+	params[paramID] = "missing"
+	if recorder = epubRequest(epubApi, params); recorder.Code != http.StatusNotFound {
+		t.Error("expecting", http.StatusNotFound, "got", recorder.Code)
+	}
+
+	// uploads are limited as attachments are
+	limitedApi, err := createMockApi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	WithMaxAttachmentSize(16)(limitedApi)
+	_, err = testResponse(http.MethodPut, importPath, limitedApi.router.ServeHTTP, upload.Bytes(), http.StatusRequestEntityTooLarge, nil)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// epubRequest exports a book as an EPUB, returning the recorded response
+func epubRequest(service *RestService, params map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, libraryBookEPUBPath, nil)
+	req = mux.SetURLVars(req, params)
+	recorder := httptest.NewRecorder()
+	service.exportEPUB(recorder, req)
+	return recorder
+}
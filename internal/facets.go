@@ -0,0 +1,50 @@
+package internal
+
+import (
+	"dockerrestapi/lib"
+	"net/http"
+	"strconv"
+)
+
+const (
+	libraryFacetsPath = libraryPath + "/facets"
+
+	defaultFacetLimit = 20
+	maxFacetLimit     = 100
+)
+
+// getLibraryFacets counts the books of a library per author, tag, language and update year, for the catalogue to build
+// its filters. The books can be narrowed with the query parameters of the book list, each facet keeping the limit values
+// with the most books.
+// eg : api/libraries/{library}/facets?tag=fantasy&limit=10
+func (r *RestService) getLibraryFacets(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Library Facets request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+
+	query := request.URL.Query()
+	filter, err := r.createBookFilterFromQuery(query)
+	if err != nil {
+		r.restResponse(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	limit := defaultFacetLimit
+	if value := query.Get(paramLimit); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxFacetLimit {
+			r.restResponse(writer, http.StatusBadRequest, lib.IncorrectParameters.Error())
+			return
+		}
+	}
+
+	facets, err := library.GetFacets(filter, limit)
+	if err != nil {
+		stdError(err.Error())
+		r.restResponse(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	r.restResponse(writer, http.StatusOK, facets)
+}
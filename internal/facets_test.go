@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"dockerrestapi/db"
+	"dockerrestapi/lib"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestFacets checks that every backend counts the same facets, against Mongo only when TEST_MONGO_DSN is set
+func TestFacets(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		clock := lib.NewManualClock(time.Date(2022, time.June, 1, 0, 0, 0, 0, time.UTC))
+		handler, err := db.CreateMockDBHandlerWithClock(clock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testFacets(t, handler, clock)
+	})
+	t.Run("mongo", func(t *testing.T) {
+		dsn := os.Getenv("TEST_MONGO_DSN")
+		if dsn == "" {
+			t.Skip("TEST_MONGO_DSN not set")
+		}
+		clock := lib.NewManualClock(time.Date(2022, time.June, 1, 0, 0, 0, 0, time.UTC))
+		handler, err := db.CreateMongoDBHandlerWithClock(dsn, "facetsTest", "books_"+strconv.FormatInt(time.Now().UnixNano(), 36), clock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer handler.Disconnect()
+		testFacets(t, handler, clock)
+	})
+}
+
+// testFacets stores books over three years in a new library of a backend and checks their facets, dropping the library after
+func testFacets(t *testing.T, handler db.RestDbInterface, clock *lib.ManualClock) {
+	err := handler.CreateLibrary("catalogue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.DropLibrary("catalogue")
+	library, err := handler.Library("catalogue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	books := []lib.Book{
+		{Name: "the hobbit", Author: "Tolkien", Tags: []string{"classic", "fantasy"}, Language: "en"},
+		{Name: "the silmarillion", Author: "Tolkien", Tags: []string{"fantasy"}, Language: "en"},
+		{Name: "a wizard of earthsea", Author: "Le Guin", Tags: []string{"fantasy"}, Language: "en"},
+		{Name: "madame bovary", Author: "Flaubert", Tags: []string{"classic"}, Language: "fr"},
+		{Name: "bread", Author: "Baker"},
+	}
+	for i, book := range books {
+		if i == 1 || i == 3 {
+			clock.Advance(365 * 24 * time.Hour)
+		}
+		book.Contents = "Once upon a time."
+		err = library.CreateNewBook(&book)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	service, err := CreateRestApiService(handler, "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	facets := facetsTestResponse(t, service, "", http.StatusOK)
+	expected := &lib.Facets{
+		Books:        5,
+		Authors:      []lib.FacetCount{facetCount("Tolkien", 2), facetCount("Baker", 1), facetCount("Flaubert", 1), facetCount("Le Guin", 1)},
+		Tags:         []lib.FacetCount{facetCount("fantasy", 3), facetCount("classic", 2)},
+		Languages:    []lib.FacetCount{facetCount("en", 3), facetCount("fr", 1)},
+		UpdatedYears: []lib.FacetCount{facetCount("2023", 2), facetCount("2024", 2), facetCount("2022", 1)},
+	}
+	if !reflect.DeepEqual(facets, expected) {
+		t.Error("expecting", expected, "got", facets)
+	}
+
+	// facets are counted over the filters of the book list
+	facets = facetsTestResponse(t, service, "?tag=fantasy&limit=1", http.StatusOK)
+	expected = &lib.Facets{
+		Books:        3,
+		Authors:      []lib.FacetCount{facetCount("Tolkien", 2)},
+		Tags:         []lib.FacetCount{facetCount("fantasy", 3)},
+		Languages:    []lib.FacetCount{facetCount("en", 3)},
+		UpdatedYears: []lib.FacetCount{facetCount("2023", 2)},
+	}
+	if !reflect.DeepEqual(facets, expected) {
+		t.Error("expecting", expected, "got", facets)
+	}
+	facets = facetsTestResponse(t, service, "?language=de", http.StatusOK)
+	expected = &lib.Facets{Authors: []lib.FacetCount{}, Tags: []lib.FacetCount{}, Languages: []lib.FacetCount{}, UpdatedYears: []lib.FacetCount{}}
+	if !reflect.DeepEqual(facets, expected) {
+		t.Error("expecting empty facets got", facets)
+	}
+	facetsTestResponse(t, service, "?limit=0", http.StatusBadRequest)
+}
+
+// facetsTestResponse gets the facets of the catalogue library with a query
+func facetsTestResponse(t *testing.T, service *RestService, query string, status int) *lib.Facets {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	service.router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/libraries/catalogue/facets"+query, nil))
+	if recorder.Code != status {
+		t.Fatal("expecting", status, "got", recorder.Code, recorder.Body.String())
+	}
+	facets := &lib.Facets{}
+	if status == http.StatusOK {
+		err := json.Unmarshal(recorder.Body.Bytes(), facets)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return facets
+}
+
+// facetCount returns a value of a facet with its count
+func facetCount(value string, count int64) lib.FacetCount {
+	return lib.FacetCount{Value: value, Count: count}
+}
@@ -0,0 +1,772 @@
+package internal
+
+import (
+	"dockerrestapi/db"
+	"dockerrestapi/lib"
+	"encoding/json"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	graphqlPath = "/graphql"
+)
+
+var (
+	graphqlMaxDepth       = 8    // deepest field nesting of a query
+	graphqlMaxComplexity  = 1000 // every selected field costs one, multiplied by graphqlListMultiplier for each list it is nested in
+	graphqlListMultiplier = 10   // also the number of items of a paged list when no limit is given, the limit multiplying otherwise
+	graphqlContentsCost   = 50   // selecting contents costs more than other fields, as they may be read from a file for every book
+)
+
+// graphqlRequest is a GraphQL request, posted as json or given as query parameters
+type graphqlRequest struct {
+	Query         string         `json:"query"`
+	Variables     map[string]any `json:"variables"`
+	OperationName string         `json:"operationName"`
+}
+
+// graphqlAuthor groups the books of an author, known authors have an id and books written under any of their names
+type graphqlAuthor struct {
+	ID      string     `json:"id,omitempty"`
+	Name    string     `json:"name"`
+	Aliases []string   `json:"aliases,omitempty"`
+	Books   []lib.Book `json:"books"`
+}
+
+// queryGraphql executes a GraphQL query or mutation against the libraries.
+// Queries may be posted as json or, mutations excepted, given with the query, variables and operationName parameters.
+// eg : graphql?query={books(library:"default"){id name author}}
+func (r *RestService) queryGraphql(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received GraphQL request")
+	graphqlQuery := graphqlRequest{}
+	if request.Method == http.MethodGet {
+		query := request.URL.Query()
+		graphqlQuery.Query = query.Get("query")
+		graphqlQuery.OperationName = query.Get("operationName")
+		if variables := query.Get("variables"); variables != "" {
+			err := json.Unmarshal([]byte(variables), &graphqlQuery.Variables)
+			if err != nil {
+				r.restResponse(writer, http.StatusBadRequest, graphqlErrorResult(err))
+				return
+			}
+		}
+	} else {
+		err := json.NewDecoder(request.Body).Decode(&graphqlQuery)
+		if err != nil {
+			r.restResponse(writer, http.StatusBadRequest, graphqlErrorResult(err))
+			return
+		}
+	}
+
+	operation, err := r.checkGraphqlLimits(graphqlQuery)
+	if err != nil {
+		r.restResponse(writer, http.StatusBadRequest, graphqlErrorResult(err))
+		return
+	}
+	if operation == ast.OperationTypeMutation && request.Method == http.MethodGet {
+		r.restResponse(writer, http.StatusMethodNotAllowed, graphqlErrorResult(lib.IncorrectParameters))
+		return
+	}
+
+	result := graphql.Do(graphql.Params{
+		Schema:         r.graphql,
+		RequestString:  graphqlQuery.Query,
+		VariableValues: graphqlQuery.Variables,
+		OperationName:  graphqlQuery.OperationName,
+		Context:        request.Context(),
+	})
+	status := http.StatusOK
+	if result.Data == nil && result.HasErrors() { // the query did not validate
+		status = http.StatusBadRequest
+	}
+	r.restResponse(writer, status, result)
+}
+
+// graphqlErrorResult returns a GraphQL result holding only the error
+func graphqlErrorResult(err error) *graphql.Result {
+	return &graphql.Result{Errors: []gqlerrors.FormattedError{gqlerrors.FormatError(err)}}
+}
+
+// checkGraphqlLimits parses a query and rejects it when the executed operation is nested deeper than graphqlMaxDepth
+// or more complex than graphqlMaxComplexity, returning the type of the operation.
+// Introspection fields are not counted, and fragments spreading themselves are rejected.
+func (r *RestService) checkGraphqlLimits(graphqlQuery graphqlRequest) (string, error) {
+	document, err := parser.Parse(parser.ParseParams{Source: graphqlQuery.Query})
+	if err != nil {
+		return "", err
+	}
+
+	fragments := map[string]*ast.FragmentDefinition{}
+	var operations []*ast.OperationDefinition
+	for _, definition := range document.Definitions {
+		switch definition := definition.(type) {
+		case *ast.FragmentDefinition:
+			fragments[definition.Name.Value] = definition
+		case *ast.OperationDefinition:
+			if graphqlQuery.OperationName == "" || (definition.Name != nil && definition.Name.Value == graphqlQuery.OperationName) {
+				operations = append(operations, definition)
+			}
+		}
+	}
+	if hasGraphqlFragmentCycle(fragments) { // crashes the validation of graphql
+		return "", lib.FragmentCycle
+	}
+	if len(operations) != 1 { // left to graphql to report
+		return "", nil
+	}
+
+	operation := operations[0]
+	root := r.graphql.QueryType()
+	if operation.Operation == ast.OperationTypeMutation {
+		root = r.graphql.MutationType()
+	}
+	limits := graphqlLimits{fragments: fragments, visiting: map[string]bool{}, schema: r.graphql, variables: graphqlQuery.Variables}
+	_, err = limits.complexity(operation.SelectionSet, root, 1)
+	return operation.Operation, err
+}
+
+// hasGraphqlFragmentCycle checks whether any fragment spreads itself, directly or through other fragments
+func hasGraphqlFragmentCycle(fragments map[string]*ast.FragmentDefinition) bool {
+	done := map[string]bool{}
+	visiting := map[string]bool{}
+	var spreadsItself func(selectionSet *ast.SelectionSet) bool
+	spreadsItself = func(selectionSet *ast.SelectionSet) bool {
+		if selectionSet == nil {
+			return false
+		}
+		for _, selection := range selectionSet.Selections {
+			switch selection := selection.(type) {
+			case *ast.Field:
+				if spreadsItself(selection.SelectionSet) {
+					return true
+				}
+			case *ast.InlineFragment:
+				if spreadsItself(selection.SelectionSet) {
+					return true
+				}
+			case *ast.FragmentSpread:
+				name := selection.Name.Value
+				fragment, exists := fragments[name]
+				if !exists || done[name] {
+					continue
+				}
+				if visiting[name] {
+					return true
+				}
+				visiting[name] = true
+				if spreadsItself(fragment.SelectionSet) {
+					return true
+				}
+				delete(visiting, name)
+				done[name] = true
+			}
+		}
+		return false
+	}
+	for _, fragment := range fragments {
+		if spreadsItself(fragment.SelectionSet) {
+			return true
+		}
+	}
+	return false
+}
+
+// graphqlLimits walks the selections of a query to measure its depth and complexity
+type graphqlLimits struct {
+	fragments map[string]*ast.FragmentDefinition
+	visiting  map[string]bool // fragments being walked, to stop at cycles
+	schema    graphql.Schema
+	variables map[string]any
+}
+
+// complexity returns the complexity of a selection set on the given type, failing once the depth or complexity limits are passed
+func (l *graphqlLimits) complexity(selections *ast.SelectionSet, parent *graphql.Object, depth int) (int, error) {
+	if selections == nil || parent == nil {
+		return 0, nil
+	}
+	if depth > graphqlMaxDepth {
+		return 0, lib.QueryTooDeep
+	}
+
+	total := 0
+	for _, selection := range selections.Selections {
+		var cost int
+		var err error
+		switch selection := selection.(type) {
+		case *ast.Field:
+			cost, err = l.fieldComplexity(selection, parent, depth)
+		case *ast.InlineFragment:
+			fragmentType := parent
+			if selection.TypeCondition != nil {
+				fragmentType, _ = l.schema.Type(selection.TypeCondition.Name.Value).(*graphql.Object)
+			}
+			cost, err = l.complexity(selection.SelectionSet, fragmentType, depth)
+		case *ast.FragmentSpread:
+			fragment, exists := l.fragments[selection.Name.Value]
+			if !exists || l.visiting[selection.Name.Value] {
+				continue
+			}
+			l.visiting[selection.Name.Value] = true
+			fragmentType, _ := l.schema.Type(fragment.TypeCondition.Name.Value).(*graphql.Object)
+			cost, err = l.complexity(fragment.SelectionSet, fragmentType, depth)
+			delete(l.visiting, selection.Name.Value)
+		}
+		if err != nil {
+			return 0, err
+		}
+		total += cost
+		if total > graphqlMaxComplexity {
+			return 0, lib.QueryTooComplex
+		}
+	}
+	return total, nil
+}
+
+// fieldComplexity returns the complexity of a field, one, or graphqlContentsCost for contents, plus the complexity of its selections
+// multiplied for lists
+func (l *graphqlLimits) fieldComplexity(field *ast.Field, parent *graphql.Object, depth int) (int, error) {
+	definition, exists := parent.Fields()[field.Name.Value]
+	if !exists { // introspection or unknown fields, unknown ones are left to graphql to report
+		return 0, nil
+	}
+	if parent.Name() == "Book" && field.Name.Value == lib.JsonBsonTagContents {
+		return graphqlContentsCost, nil
+	}
+
+	multiplier := 1
+	fieldType := definition.Type
+	if nonNull, isNonNull := fieldType.(*graphql.NonNull); isNonNull {
+		fieldType = nonNull.OfType
+	}
+	if list, isList := fieldType.(*graphql.List); isList {
+		multiplier = l.listLength(field, definition)
+		fieldType = list.OfType
+		if nonNull, isNonNull := fieldType.(*graphql.NonNull); isNonNull {
+			fieldType = nonNull.OfType
+		}
+	}
+	object, _ := fieldType.(*graphql.Object)
+	selected, err := l.complexity(field.SelectionSet, object, depth+1)
+	if err != nil {
+		return 0, err
+	}
+	return 1 + multiplier*selected, nil
+}
+
+// listLength returns the number of items a list field is counted for, its limit argument for paged lists
+func (l *graphqlLimits) listLength(field *ast.Field, definition *graphql.FieldDefinition) int {
+	paged := false
+	for _, argument := range definition.Args {
+		paged = paged || argument.Name() == paramLimit
+	}
+	if !paged {
+		return graphqlListMultiplier
+	}
+	for _, argument := range field.Arguments {
+		if argument.Name.Value != paramLimit {
+			continue
+		}
+		var limit int
+		switch value := argument.Value.(type) {
+		case *ast.IntValue:
+			limit, _ = strconv.Atoi(value.Value)
+		case *ast.Variable:
+			switch variable := l.variables[value.Name.Value].(type) {
+			case float64:
+				limit = int(variable)
+			case int:
+				limit = variable
+			}
+		}
+		if limit > 0 { // others are rejected when resolving
+			return limit
+		}
+	}
+	return graphqlListMultiplier
+}
+
+// newGraphqlSchema returns the GraphQL schema of the libraries.
+func (r *RestService) newGraphqlSchema() (graphql.Schema, error) {
+	contributorType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Contributor",
+		Fields: graphql.Fields{
+			"name": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"role": &graphql.Field{Type: graphql.String},
+		},
+	})
+	bookType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Book",
+		Fields: graphql.Fields{
+			"id":              &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"name":            &graphql.Field{Type: graphql.String},
+			"author":          &graphql.Field{Type: graphql.String},
+			"authorId":        &graphql.Field{Type: graphql.ID},
+			"contents":        &graphql.Field{Type: graphql.String},
+			"contentsLength":  &graphql.Field{Type: graphql.Int},
+			"createdAt":       &graphql.Field{Type: graphql.DateTime},
+			"updatedAt":       &graphql.Field{Type: graphql.DateTime},
+			"isbn10":          &graphql.Field{Type: graphql.String},
+			"isbn13":          &graphql.Field{Type: graphql.String},
+			"contributors":    &graphql.Field{Type: graphql.NewList(contributorType)},
+			"publisher":       &graphql.Field{Type: graphql.String},
+			"publicationDate": &graphql.Field{Type: graphql.String},
+			"language":        &graphql.Field{Type: graphql.String},
+			"pageCount":       &graphql.Field{Type: graphql.Int},
+			"series":          &graphql.Field{Type: graphql.String},
+			"volume":          &graphql.Field{Type: graphql.Int},
+			"tags":            &graphql.Field{Type: graphql.NewList(graphql.String)},
+		},
+	})
+	authorType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Author",
+		Fields: graphql.Fields{
+			"id":      &graphql.Field{Type: graphql.ID},
+			"name":    &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"aliases": &graphql.Field{Type: graphql.NewList(graphql.String)},
+			"books":   &graphql.Field{Type: graphql.NewList(bookType)},
+		},
+	})
+	contributorInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "ContributorInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"name": &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+			"role": &graphql.InputObjectFieldConfig{Type: graphql.String},
+		},
+	})
+	bookInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "BookInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"name":            &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+			"author":          &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+			"contents":        &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+			"isbn10":          &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"isbn13":          &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"contributors":    &graphql.InputObjectFieldConfig{Type: graphql.NewList(contributorInput)},
+			"publisher":       &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"publicationDate": &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"language":        &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"pageCount":       &graphql.InputObjectFieldConfig{Type: graphql.Int},
+			"series":          &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"volume":          &graphql.InputObjectFieldConfig{Type: graphql.Int},
+			"tags":            &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.String)},
+		},
+	})
+
+	libraryArgument := &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: lib.DefaultLibraryName}
+	pageArguments := func(arguments graphql.FieldConfigArgument) graphql.FieldConfigArgument {
+		arguments[paramLimit] = &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: graphqlListMultiplier}
+		arguments[paramOffset] = &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0}
+		return arguments
+	}
+	identifierArguments := graphql.FieldConfigArgument{
+		"library": libraryArgument,
+		"id":      &graphql.ArgumentConfig{Type: graphql.ID},
+		"name":    &graphql.ArgumentConfig{Type: graphql.String},
+		"author":  &graphql.ArgumentConfig{Type: graphql.String},
+	}
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"books": &graphql.Field{
+				Type:    graphql.NewList(bookType),
+				Args:    pageArguments(graphql.FieldConfigArgument{"library": libraryArgument}),
+				Resolve: r.resolveGraphqlBooks,
+			},
+			"search": &graphql.Field{
+				Type: graphql.NewList(bookType),
+				Args: pageArguments(graphql.FieldConfigArgument{
+					"library":       libraryArgument,
+					"isbn":          &graphql.ArgumentConfig{Type: graphql.String},
+					"author":        &graphql.ArgumentConfig{Type: graphql.String},
+					"publisher":     &graphql.ArgumentConfig{Type: graphql.String},
+					"language":      &graphql.ArgumentConfig{Type: graphql.String},
+					"series":        &graphql.ArgumentConfig{Type: graphql.String},
+					"year":          &graphql.ArgumentConfig{Type: graphql.String},
+					"tags":          &graphql.ArgumentConfig{Type: graphql.NewList(graphql.String)},
+					"updatedAfter":  &graphql.ArgumentConfig{Type: graphql.DateTime},
+					"updatedBefore": &graphql.ArgumentConfig{Type: graphql.DateTime},
+				}),
+				Resolve: r.resolveGraphqlBooks,
+			},
+			"book": &graphql.Field{
+				Type:    bookType,
+				Args:    identifierArguments,
+				Resolve: r.resolveGraphqlBook,
+			},
+			"authors": &graphql.Field{
+				Type:    graphql.NewList(authorType),
+				Args:    pageArguments(graphql.FieldConfigArgument{"library": libraryArgument}),
+				Resolve: r.resolveGraphqlAuthors,
+			},
+		},
+	})
+
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createBook": &graphql.Field{
+				Type: bookType,
+				Args: graphql.FieldConfigArgument{
+					"library": libraryArgument,
+					"book":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(bookInput)},
+				},
+				Resolve: r.resolveGraphqlCreateBook,
+			},
+			"updateBook": &graphql.Field{
+				Type: bookType,
+				Args: graphql.FieldConfigArgument{
+					"library": libraryArgument,
+					"id":      &graphql.ArgumentConfig{Type: graphql.ID},
+					"book":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(bookInput)},
+				},
+				Resolve: r.resolveGraphqlUpdateBook,
+			},
+			"deleteBook": &graphql.Field{
+				Type:    graphql.Boolean,
+				Args:    identifierArguments,
+				Resolve: r.resolveGraphqlDeleteBook,
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query, Mutation: mutation})
+}
+
+// resolveGraphqlBooks lists a page of the books of a library in creation order, filtered by the search arguments,
+// loading only the selected fields
+func (r *RestService) resolveGraphqlBooks(params graphql.ResolveParams) (any, error) {
+	library, err := r.graphqlLibrary(params)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := r.graphqlBookFilter(params.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := graphqlSelectedFields(params.Info.FieldASTs, params.Info.Fragments)
+	books, err := library.GetAllBooksWithFields(filter, fields)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(books, func(i, j int) bool { return books[i].ID < books[j].ID })
+	books, err = graphqlPage(books, params.Args)
+	if err != nil {
+		return nil, err
+	}
+	for i := range books {
+		err = loadGraphqlContents(library, &books[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return books, nil
+}
+
+// resolveGraphqlBook gets a single book given its id, or name and author, loading only the selected fields
+func (r *RestService) resolveGraphqlBook(params graphql.ResolveParams) (any, error) {
+	library, err := r.graphqlLibrary(params)
+	if err != nil {
+		return nil, err
+	}
+	bookIdentifier, err := r.graphqlBookIdentifier(params.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := graphqlSelectedFields(params.Info.FieldASTs, params.Info.Fragments)
+	book, err := library.GetOneBookWithFields(bookIdentifier, fields)
+	if err != nil {
+		return nil, err
+	}
+	err = loadGraphqlContents(library, book)
+	if err != nil {
+		return nil, err
+	}
+	return book, nil
+}
+
+// resolveGraphqlAuthors lists a page of the authors of a library in name order with their books, loading only the selected book fields.
+// Books of known authors are listed under the author they are linked to, whichever of its names they were written under,
+// others under the author name they were written under.
+func (r *RestService) resolveGraphqlAuthors(params graphql.ResolveParams) (any, error) {
+	library, err := r.graphqlLibrary(params)
+	if err != nil {
+		return nil, err
+	}
+
+	var bookFields []*ast.Field
+	for _, field := range graphqlSelections(params.Info.FieldASTs, params.Info.Fragments) {
+		if field.Name.Value == "books" {
+			bookFields = append(bookFields, field)
+		}
+	}
+	fields := append(graphqlSelectedFields(bookFields, params.Info.Fragments), lib.JsonBsonTagAuthor, lib.JsonBsonTagAuthorID)
+	books, err := library.GetAllBooksWithFields(lib.BookFilter{}, fields)
+	if err != nil {
+		return nil, err
+	}
+	knownAuthors, err := library.GetAllAuthors()
+	if err != nil {
+		return nil, err
+	}
+
+	known := map[string]*graphqlAuthor{}   // by id
+	unknown := map[string]*graphqlAuthor{} // by name
+	for _, author := range knownAuthors {
+		known[author.ID] = &graphqlAuthor{ID: author.ID, Name: author.Name, Aliases: author.Aliases}
+	}
+	sort.Slice(books, func(i, j int) bool { return books[i].ID < books[j].ID })
+	for _, book := range books {
+		author, exists := known[book.AuthorID]
+		if !exists {
+			author, exists = unknown[book.Author]
+			if !exists {
+				author = &graphqlAuthor{Name: book.Author}
+				unknown[book.Author] = author
+			}
+		}
+		author.Books = append(author.Books, book)
+	}
+	results := make([]graphqlAuthor, 0, len(known)+len(unknown))
+	for _, authors := range []map[string]*graphqlAuthor{known, unknown} {
+		for _, author := range authors {
+			results = append(results, *author)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Name != results[j].Name {
+			return results[i].Name < results[j].Name
+		}
+		return results[i].ID < results[j].ID
+	})
+	results, err = graphqlPage(results, params.Args)
+	if err != nil {
+		return nil, err
+	}
+	for _, author := range results {
+		for i := range author.Books {
+			err = loadGraphqlContents(library, &author.Books[i])
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return results, nil
+}
+
+// resolveGraphqlCreateBook stores a new book and notifies webhooks
+func (r *RestService) resolveGraphqlCreateBook(params graphql.ResolveParams) (any, error) {
+	library, err := r.graphqlLibrary(params)
+	if err != nil {
+		return nil, err
+	}
+	book, err := r.graphqlBookInput(params.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	err = library.CreateNewBook(book)
+	if err != nil {
+		return nil, err
+	}
+	r.notifyWebhooks(graphqlLibraryName(params.Args), lib.ChangeCreated, book.Identifier())
+	return book, nil
+}
+
+// resolveGraphqlUpdateBook updates an existing book, matched by id when given, otherwise by name and author, and notifies webhooks
+func (r *RestService) resolveGraphqlUpdateBook(params graphql.ResolveParams) (any, error) {
+	library, err := r.graphqlLibrary(params)
+	if err != nil {
+		return nil, err
+	}
+	book, err := r.graphqlBookInput(params.Args)
+	if err != nil {
+		return nil, err
+	}
+	book.ID, _ = params.Args["id"].(string)
+
+	err = library.UpdateExistingBook(book)
+	if err != nil {
+		return nil, err
+	}
+	r.notifyWebhooks(graphqlLibraryName(params.Args), lib.ChangeUpdated, book.Identifier())
+	return book, nil
+}
+
+// resolveGraphqlDeleteBook moves a book to the trash given its id, or name and author
+func (r *RestService) resolveGraphqlDeleteBook(params graphql.ResolveParams) (any, error) {
+	library, err := r.graphqlLibrary(params)
+	if err != nil {
+		return nil, err
+	}
+	bookIdentifier, err := r.graphqlBookIdentifier(params.Args)
+	if err != nil {
+		return nil, err
+	}
+	err = r.trashBook(library, graphqlLibraryName(params.Args), bookIdentifier)
+	if err != nil {
+		return nil, err
+	}
+	return true, nil
+}
+
+// graphqlLibrary returns the library named by the library argument
+func (r *RestService) graphqlLibrary(params graphql.ResolveParams) (db.RestDbInterface, error) {
+	return r.library(graphqlLibraryName(params.Args))
+}
+
+// graphqlLibraryName returns the library argument, the default library when null
+func graphqlLibraryName(args map[string]any) string {
+	name, _ := args["library"].(string)
+	if name == "" {
+		return lib.DefaultLibraryName
+	}
+	return name
+}
+
+// graphqlBookFilter returns a book filter from the search arguments
+func (r *RestService) graphqlBookFilter(args map[string]any) (lib.BookFilter, error) {
+	filter := lib.BookFilter{}
+	filter.ISBN, _ = args["isbn"].(string)
+	filter.Author, _ = args["author"].(string)
+	filter.Publisher, _ = args["publisher"].(string)
+	filter.Language, _ = args["language"].(string)
+	filter.Series, _ = args["series"].(string)
+	filter.Year, _ = args["year"].(string)
+	tags, _ := args["tags"].([]any)
+	for _, tag := range tags {
+		if tag, isString := tag.(string); isString {
+			filter.Tags = append(filter.Tags, tag)
+		}
+	}
+	if after, isTime := args["updatedAfter"].(time.Time); isTime {
+		filter.UpdatedAfter = after
+	}
+	if before, isTime := args["updatedBefore"].(time.Time); isTime {
+		filter.UpdatedBefore = before
+	}
+	err := r.normaliseBookFilter(&filter)
+	return filter, err
+}
+
+// graphqlPage returns the items selected by the limit and offset arguments
+func graphqlPage[T any](items []T, args map[string]any) ([]T, error) {
+	limit, _ := args[paramLimit].(int)
+	offset, _ := args[paramOffset].(int)
+	if limit < 1 || offset < 0 {
+		return nil, lib.IncorrectParameters
+	}
+	if offset >= len(items) {
+		return items[:0], nil
+	}
+	return items[offset:min(offset+limit, len(items))], nil
+}
+
+// graphqlBookIdentifier returns the book identifier of the id, or name and author, arguments
+func (r *RestService) graphqlBookIdentifier(args map[string]any) (*lib.BookIdentifier, error) {
+	params := map[string]string{}
+	for _, param := range []string{paramID, paramName, paramAuthor} {
+		if value, isString := args[param].(string); isString {
+			params[param] = value
+		}
+	}
+	bookIdentifier, err := r.createBookIdentifierFromParams(params)
+	if err != nil {
+		return nil, lib.IncorrectParameters
+	}
+	return bookIdentifier, nil
+}
+
+// graphqlBookInput returns the validated book of the book argument
+func (r *RestService) graphqlBookInput(args map[string]any) (*lib.Book, error) {
+	input, err := json.Marshal(args["book"])
+	if err != nil {
+		return nil, err
+	}
+	book := &lib.Book{}
+	err = json.Unmarshal(input, book)
+	if err != nil {
+		return nil, err
+	}
+	err = r.validateStoreBookRequest(book)
+	if err != nil {
+		return nil, err
+	}
+	return book, nil
+}
+
+// graphqlSelectedFields returns the stored book fields, by bson tag, selected by the given book fields.
+// The file of large contents is also loaded when the contents are selected.
+func graphqlSelectedFields(fields []*ast.Field, fragments map[string]ast.Definition) []string {
+	var selected []string
+	seen := map[string]bool{}
+	for _, field := range graphqlSelections(fields, fragments) {
+		name := field.Name.Value
+		if name == "id" || seen[name] || !lib.IsBookField(name) {
+			continue
+		}
+		seen[name] = true
+		selected = append(selected, name)
+		if name == lib.JsonBsonTagContents {
+			selected = append(selected, lib.JsonBsonTagContentsFile)
+		}
+	}
+	return selected
+}
+
+// graphqlSelections returns the fields selected under the given fields, expanding fragments
+func graphqlSelections(fields []*ast.Field, fragments map[string]ast.Definition) []*ast.Field {
+	var selections []*ast.Field
+	var collect func(selectionSet *ast.SelectionSet, visiting map[string]bool)
+	collect = func(selectionSet *ast.SelectionSet, visiting map[string]bool) {
+		if selectionSet == nil {
+			return
+		}
+		for _, selection := range selectionSet.Selections {
+			switch selection := selection.(type) {
+			case *ast.Field:
+				selections = append(selections, selection)
+			case *ast.InlineFragment:
+				collect(selection.SelectionSet, visiting)
+			case *ast.FragmentSpread:
+				fragment, isFragment := fragments[selection.Name.Value].(*ast.FragmentDefinition)
+				if isFragment && !visiting[selection.Name.Value] {
+					visiting[selection.Name.Value] = true
+					collect(fragment.SelectionSet, visiting)
+				}
+			}
+		}
+	}
+	for _, field := range fields {
+		collect(field.SelectionSet, map[string]bool{})
+	}
+	return selections
+}
+
+// loadGraphqlContents reads contents stored in a file into a book projected with its contents
+func loadGraphqlContents(library db.RestDbInterface, book *lib.Book) error {
+	if book.ContentsFile == "" || book.Contents != "" {
+		return nil
+	}
+	contents, err := library.GetBookContents(&lib.BookIdentifier{ID: book.ID})
+	if err != nil {
+		return err
+	}
+	defer contents.Close()
+	data, err := io.ReadAll(contents)
+	if err != nil {
+		return err
+	}
+	book.Contents = string(data)
+	return nil
+}
@@ -0,0 +1,244 @@
+package internal
+
+import (
+	"dockerrestapi/db"
+	"dockerrestapi/lib"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+// projectionRecorder records the fields books are loaded with
+type projectionRecorder struct {
+	db.RestDbInterface
+	fields [][]string
+}
+
+func (p *projectionRecorder) GetAllBooksWithFields(filter lib.BookFilter, fields []string) ([]lib.Book, error) {
+	p.fields = append(p.fields, fields)
+	return p.RestDbInterface.GetAllBooksWithFields(filter, fields)
+}
+
+func (p *projectionRecorder) GetOneBookWithFields(bookIdentifier *lib.BookIdentifier, fields []string) (*lib.Book, error) {
+	p.fields = append(p.fields, fields)
+	return p.RestDbInterface.GetOneBookWithFields(bookIdentifier, fields)
+}
+
+// lastFields returns the fields of the last load
+func (p *projectionRecorder) lastFields() []string {
+	if len(p.fields) == 0 {
+		return nil
+	}
+	return p.fields[len(p.fields)-1]
+}
+
+func TestGraphql(t *testing.T) {
+	mockConn, err := db.CreateMockDBHandlerWithClock(testClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := &projectionRecorder{RestDbInterface: mockConn}
+	graphqlApi, err := CreateRestApiService(recorder, "8081")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// mutations create and update books
+	var created struct {
+		CreateBook lib.Book `json:"createBook"`
+	}
+	postGraphql(t, graphqlApi, `mutation { createBook(book: {name: "book1", author: "philip", contents: "A bad read", tags: ["Fantasy"]}) { id name tags } }`,
+		nil, http.StatusOK, &created)
+	if created.CreateBook.ID == "" || created.CreateBook.Name != "book1" || !reflect.DeepEqual(created.CreateBook.Tags, []string{"fantasy"}) {
+		t.Error("expecting the created book got", created.CreateBook)
+	}
+	postGraphql(t, graphqlApi, `mutation ($book: BookInput!) { createBook(book: $book) { id } }`,
+		map[string]any{"book": map[string]any{"name": "book2", "author": "Gino", "contents": "A wild read", "series": "Wild", "volume": 1}}, http.StatusOK, nil)
+	postGraphql(t, graphqlApi, `mutation { updateBook(id: "`+created.CreateBook.ID+`", book: {name: "book1", author: "philip", contents: "A good read"}) { id } }`,
+		nil, http.StatusOK, nil)
+
+	// only the selected fields are loaded
+	var listed struct {
+		Books []lib.Book `json:"books"`
+	}
+	postGraphql(t, graphqlApi, `{ books { name author } }`, nil, http.StatusOK, &listed)
+	if len(listed.Books) != 2 || listed.Books[0].Contents != "" {
+		t.Error("expecting two books without contents got", listed.Books)
+	}
+	if !reflect.DeepEqual(recorder.lastFields(), []string{lib.JsonBsonTagName, lib.JsonBsonTagAuthor}) {
+		t.Error("expecting name and author to be loaded got", recorder.lastFields())
+	}
+
+	// fragments are followed, and contents bring their file along
+	var got struct {
+		Book lib.Book `json:"book"`
+	}
+	postGraphql(t, graphqlApi, `query ($id: ID) { book(id: $id) { ...details } } fragment details on Book { name contents }`,
+		map[string]any{"id": created.CreateBook.ID}, http.StatusOK, &got)
+	if got.Book.Contents != "A good read" || got.Book.Author != "" {
+		t.Error("expecting the updated contents only got", got.Book)
+	}
+	if !reflect.DeepEqual(recorder.lastFields(), []string{lib.JsonBsonTagName, lib.JsonBsonTagContents, lib.JsonBsonTagContentsFile}) {
+		t.Error("expecting name and contents to be loaded got", recorder.lastFields())
+	}
+
+	var searched struct {
+		Search []lib.Book `json:"search"`
+	}
+	postGraphql(t, graphqlApi, `{ search(series: "Wild") { name volume } }`, nil, http.StatusOK, &searched)
+	if len(searched.Search) != 1 || searched.Search[0].Name != "book2" || searched.Search[0].Volume != 1 {
+		t.Error("expecting book2 got", searched.Search)
+	}
+
+	var authors struct {
+		Authors []graphqlAuthor `json:"authors"`
+	}
+	postGraphql(t, graphqlApi, `{ authors { name books { name } } }`, nil, http.StatusOK, &authors)
+	if len(authors.Authors) != 2 || authors.Authors[0].Name != "Gino" || len(authors.Authors[1].Books) != 1 || authors.Authors[1].Books[0].Name != "book1" {
+		t.Error("expecting Gino then philip got", authors.Authors)
+	}
+	if !reflect.DeepEqual(recorder.lastFields(), []string{lib.JsonBsonTagName, lib.JsonBsonTagAuthor, lib.JsonBsonTagAuthorID}) {
+		t.Error("expecting name and author to be loaded got", recorder.lastFields())
+	}
+	// books of known authors are listed under the author whichever name they were written under
+	author := &lib.Author{Name: "Philip K. Dick", Aliases: []string{"philip", "PKD"}}
+	err = mockConn.CreateNewAuthor(author)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postGraphql(t, graphqlApi, `mutation { createBook(book: {name: "book4", author: "pkd", contents: "A short read"}) { id } }`, nil, http.StatusOK, nil)
+	postGraphql(t, graphqlApi, `{ authors { id name books { name } } }`, nil, http.StatusOK, &authors)
+	if len(authors.Authors) != 2 || authors.Authors[0].ID != "" || authors.Authors[1].ID != author.ID || len(authors.Authors[1].Books) != 2 {
+		t.Error("expecting Gino then Philip K. Dick with both books got", authors.Authors)
+	}
+
+	// lists are paged
+	postGraphql(t, graphqlApi, `{ books(limit: 1, offset: 1) { name } }`, nil, http.StatusOK, &listed)
+	if len(listed.Books) != 1 || listed.Books[0].Name != "book2" {
+		t.Error("expecting book2 got", listed.Books)
+	}
+	postGraphql(t, graphqlApi, `query ($offset: Int) { authors(offset: $offset) { name } }`, map[string]any{"offset": 2}, http.StatusOK, &authors)
+	if len(authors.Authors) != 0 {
+		t.Error("expecting no authors got", authors.Authors)
+	}
+
+	// errors are reported in the result
+	var result struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	postGraphql(t, graphqlApi, `{ book(name: "missing", author: "nobody") { name } }`, nil, http.StatusOK, &result)
+	if len(result.Errors) != 1 || result.Errors[0].Message != lib.NoMatchingBook.Error() {
+		t.Error("expecting no matching book got", result.Errors)
+	}
+	postGraphql(t, graphqlApi, `{ books { unknown } }`, nil, http.StatusBadRequest, nil)
+	postGraphql(t, graphqlApi, `mutation { createBook(book: {name: "book3", author: "Sheldon", contents: "", isbn13: "123"}) { id } }`, nil, http.StatusOK, &result)
+	if len(result.Errors) != 1 {
+		t.Error("expecting an incomplete book got", result.Errors)
+	}
+
+	// deleted books are moved to the trash
+	var deleted struct {
+		DeleteBook bool `json:"deleteBook"`
+	}
+	postGraphql(t, graphqlApi, `mutation { deleteBook(name: "book2", author: "Gino") }`, nil, http.StatusOK, &deleted)
+	if !deleted.DeleteBook {
+		t.Error("expecting book2 to be deleted")
+	}
+	postGraphql(t, graphqlApi, `{ books { name } }`, nil, http.StatusOK, &listed)
+	if len(listed.Books) != 2 || listed.Books[0].Name != "book1" || listed.Books[1].Name != "book4" {
+		t.Error("expecting book1 and book4 got", listed.Books)
+	}
+
+	// queries may be sent as parameters, mutations may not
+	response, err := testResponse(http.MethodGet, graphqlPath+"?query="+url.QueryEscape(`{ books { name } }`), graphqlApi.queryGraphql, nil, http.StatusOK, nil)
+	if err != nil || response == "" {
+		t.Error(err)
+	}
+	_, err = testResponse(http.MethodGet, graphqlPath+"?query="+url.QueryEscape(`mutation { deleteBook(name: "book1", author: "philip") }`), graphqlApi.queryGraphql, nil, http.StatusMethodNotAllowed, nil)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGraphqlLimits(t *testing.T) {
+	graphqlApi, err := createMockApi()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nested lists multiply the complexity
+	postGraphql(t, graphqlApi, `{ authors { name books { name contributors { name role } } } }`, nil, http.StatusBadRequest, nil)
+	postGraphql(t, graphqlApi, `{ authors { name books { name author } } }`, nil, http.StatusOK, nil)
+	// paged lists are multiplied by their limit, and contents cost more than other fields
+	postGraphql(t, graphqlApi, `{ books(limit: 1000) { name } }`, nil, http.StatusBadRequest, nil)
+	postGraphql(t, graphqlApi, `query ($limit: Int) { books(limit: $limit) { name } }`, map[string]any{"limit": 1000}, http.StatusBadRequest, nil)
+	postGraphql(t, graphqlApi, `{ books(limit: 50) { name } }`, nil, http.StatusOK, nil)
+	postGraphql(t, graphqlApi, `{ books { name contents } }`, nil, http.StatusOK, nil)
+	postGraphql(t, graphqlApi, `{ books(limit: 20) { name contents } }`, nil, http.StatusBadRequest, nil)
+	postGraphql(t, graphqlApi, `{ authors { name books { contents } } }`, nil, http.StatusBadRequest, nil)
+	var result struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	postGraphql(t, graphqlApi, `{ books(limit: 0) { name } }`, nil, http.StatusOK, &result)
+	if len(result.Errors) != 1 || result.Errors[0].Message != lib.IncorrectParameters.Error() {
+		t.Error("expecting incorrect parameters got", result.Errors)
+	}
+	postGraphql(t, graphqlApi, `{ a: books { ...all } b: books { ...all } c: books { ...all } } fragment all on Book { id name author contents contentsLength createdAt updatedAt isbn10 isbn13 publisher publicationDate language pageCount series volume tags contributors { name role } }`,
+		nil, http.StatusBadRequest, nil)
+
+	maxDepth := graphqlMaxDepth
+	graphqlMaxDepth = 2
+	defer func() { graphqlMaxDepth = maxDepth }()
+	postGraphql(t, graphqlApi, `{ books { name } }`, nil, http.StatusOK, nil)
+	postGraphql(t, graphqlApi, `{ books { contributors { name } } }`, nil, http.StatusBadRequest, &result)
+	if len(result.Errors) != 1 || result.Errors[0].Message != lib.QueryTooDeep.Error() {
+		t.Error("expecting a too deep query got", result.Errors)
+	}
+	// fragments spreading themselves are rejected
+	postGraphql(t, graphqlApi, `{ books { ...loop } } fragment loop on Book { name ...again } fragment again on Book { ...loop }`, nil, http.StatusBadRequest, &result)
+	if len(result.Errors) != 1 || result.Errors[0].Message != lib.FragmentCycle.Error() {
+		t.Error("expecting a fragment cycle got", result.Errors)
+	}
+}
+
+// postGraphql posts a query, unmarshalling the data and errors of the result into data when given
+func postGraphql(t *testing.T, service *RestService, query string, variables map[string]any, expectedStatus int, data any) {
+	t.Helper()
+	body, err := json.Marshal(graphqlRequest{Query: query, Variables: variables})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := testResponse(http.MethodPost, graphqlPath, service.queryGraphql, body, expectedStatus, nil)
+	if err != nil {
+		t.Error(query, err)
+		return
+	}
+	if data == nil {
+		return
+	}
+	// errors are at the top of the result and fields in its data
+	reflect.ValueOf(data).Elem().SetZero()
+	err = json.Unmarshal([]byte(response), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := struct {
+		Data json.RawMessage `json:"data"`
+	}{}
+	err = json.Unmarshal([]byte(response), &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Data) > 0 {
+		err = json.Unmarshal(result.Data, data)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package internal
+
+import (
+	"context"
+	"dockerrestapi/lib"
+	"dockerrestapi/librarypb"
+	"errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"net"
+)
+
+// grpcServer serves the gRPC api against the storage, clock and webhooks of the rest api
+type grpcServer struct {
+	librarypb.UnimplementedLibraryServer
+	rest *RestService
+}
+
+// WithGrpcPort also serves the gRPC api on the given port
+func WithGrpcPort(port string) ServiceOption {
+	return func(service *RestService) {
+		service.grpcPort = port
+	}
+}
+
+// startGrpc starts serving the gRPC api on its port
+func (r *RestService) startGrpc() error {
+	listener, err := net.Listen("tcp", ":"+r.grpcPort)
+	if err != nil {
+		return err
+	}
+	go func() {
+		err := r.grpc.Serve(listener)
+		if err != nil {
+			stdError("grpc stopped " + err.Error())
+		}
+	}()
+	stdInfo("grpc started on port " + r.grpcPort)
+	return nil
+}
+
+// newGrpcServer returns a gRPC server of the library service
+func (r *RestService) newGrpcServer() *grpc.Server {
+	server := grpc.NewServer()
+	librarypb.RegisterLibraryServer(server, &grpcServer{rest: r})
+	return server
+}
+
+func (g *grpcServer) ListBooks(request *librarypb.ListBooksRequest, stream librarypb.Library_ListBooksServer) error {
+	return g.streamBooks(request.GetLibrary(), lib.BookFilter{}, stream)
+}
+
+func (g *grpcServer) SearchBooks(request *librarypb.SearchBooksRequest, stream librarypb.Library_SearchBooksServer) error {
+	filter := lib.BookFilter{
+		ISBN:      request.GetIsbn(),
+		Author:    request.GetAuthor(),
+		Publisher: request.GetPublisher(),
+		Language:  request.GetLanguage(),
+		Series:    request.GetSeries(),
+		Year:      request.GetYear(),
+		Tags:      request.GetTags(),
+	}
+	if request.UpdatedAfter != nil {
+		filter.UpdatedAfter = request.GetUpdatedAfter().AsTime()
+	}
+	if request.UpdatedBefore != nil {
+		filter.UpdatedBefore = request.GetUpdatedBefore().AsTime()
+	}
+	err := g.rest.normaliseBookFilter(&filter)
+	if err != nil {
+		return grpcError(err)
+	}
+	return g.streamBooks(request.GetLibrary(), filter, stream)
+}
+
+func (g *grpcServer) GetBook(_ context.Context, request *librarypb.GetBookRequest) (*librarypb.Book, error) {
+	library, err := g.rest.library(grpcLibraryName(request.GetLibrary()))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	bookIdentifier, err := grpcBookIdentifier(request.GetBook())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	book, err := library.GetOneBook(bookIdentifier)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return bookToProto(book), nil
+}
+
+func (g *grpcServer) CreateBook(_ context.Context, request *librarypb.CreateBookRequest) (*librarypb.Book, error) {
+	libraryName := grpcLibraryName(request.GetLibrary())
+	library, err := g.rest.library(libraryName)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	book := bookFromProto(request.GetBook())
+	err = g.rest.validateStoreBookRequest(book)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	err = library.CreateNewBook(book)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	g.rest.notifyWebhooks(libraryName, lib.ChangeCreated, book.Identifier())
+	return bookToProto(book), nil
+}
+
+func (g *grpcServer) UpdateBook(_ context.Context, request *librarypb.UpdateBookRequest) (*librarypb.Book, error) {
+	libraryName := grpcLibraryName(request.GetLibrary())
+	library, err := g.rest.library(libraryName)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	book := bookFromProto(request.GetBook())
+	err = g.rest.validateStoreBookRequest(book)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	err = library.UpdateExistingBook(book)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	g.rest.notifyWebhooks(libraryName, lib.ChangeUpdated, book.Identifier())
+	return bookToProto(book), nil
+}
+
+func (g *grpcServer) DeleteBook(_ context.Context, request *librarypb.DeleteBookRequest) (*librarypb.DeleteBookResponse, error) {
+	libraryName := grpcLibraryName(request.GetLibrary())
+	library, err := g.rest.library(libraryName)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	bookIdentifier, err := grpcBookIdentifier(request.GetBook())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	err = g.rest.trashBook(library, libraryName, bookIdentifier)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &librarypb.DeleteBookResponse{}, nil
+}
+
+// streamBooks sends the identifier of every book of a library matching the filter, one message each as storage reads them
+func (g *grpcServer) streamBooks(libraryName string, filter lib.BookFilter, stream grpc.ServerStream) error {
+	library, err := g.rest.library(grpcLibraryName(libraryName))
+	if err != nil {
+		return grpcError(err)
+	}
+	var sendErr error
+	err = library.EachBook(filter, func(book lib.BookIdentifier) error {
+		sendErr = stream.SendMsg(&librarypb.BookIdentifier{Id: book.ID, Name: book.Name, Author: book.Author})
+		return sendErr
+	})
+	if err != nil && err != sendErr {
+		return grpcError(err)
+	}
+	return err
+}
+
+// grpcLibraryName returns the library named in a request, the default library when empty
+func grpcLibraryName(name string) string {
+	if name == "" {
+		return lib.DefaultLibraryName
+	}
+	return name
+}
+
+// grpcBookIdentifier returns the identifier of a request, which needs an id or both a name and an author
+func grpcBookIdentifier(book *librarypb.BookIdentifier) (*lib.BookIdentifier, error) {
+	if book.GetId() == "" && (book.GetName() == "" || book.GetAuthor() == "") {
+		return nil, lib.IncorrectParameters
+	}
+	return &lib.BookIdentifier{ID: book.GetId(), Name: book.GetName(), Author: book.GetAuthor()}, nil
+}
+
+// grpcError maps lib errors to gRPC status codes
+func grpcError(err error) error {
+	code := codes.Internal
+	switch {
+	case errors.Is(err, lib.NoMatchingBook), errors.Is(err, lib.NoMatchingLibrary):
+		code = codes.NotFound
+	case errors.Is(err, lib.BookAlreadyExists):
+		code = codes.AlreadyExists
+	case errors.Is(err, lib.BookHasActiveLoans):
+		code = codes.FailedPrecondition
+	case errors.Is(err, lib.IncorrectParameters), errors.Is(err, lib.IncompleteBook), errors.Is(err, lib.IncorrectTimestamp),
+		errors.Is(err, lib.IncorrectISBN), errors.Is(err, lib.MismatchedISBN), errors.Is(err, lib.IncorrectLanguage),
+		errors.Is(err, lib.IncorrectPublicationDate), errors.Is(err, lib.IncorrectContributor), errors.Is(err, lib.IncorrectPageCount),
+		errors.Is(err, lib.VolumeWithoutSeries), errors.Is(err, lib.IncorrectLibraryName), errors.Is(err, lib.IncorrectChapterPattern):
+		code = codes.InvalidArgument
+	default:
+		stdError(err.Error())
+	}
+	return status.Error(code, err.Error())
+}
+
+// bookToProto converts a book to its protobuf message
+func bookToProto(book *lib.Book) *librarypb.Book {
+	message := &librarypb.Book{
+		Id:              book.ID,
+		Name:            book.Name,
+		Author:          book.Author,
+		Contents:        book.Contents,
+		ContentsLength:  book.ContentsLength,
+		CreatedAt:       timestamppb.New(book.CreatedAt),
+		UpdatedAt:       timestamppb.New(book.UpdatedAt),
+		Isbn10:          book.ISBN10,
+		Isbn13:          book.ISBN13,
+		Publisher:       book.Publisher,
+		PublicationDate: book.PublicationDate,
+		Language:        book.Language,
+		PageCount:       int32(book.PageCount),
+		Series:          book.Series,
+		Volume:          int32(book.Volume),
+		Tags:            book.Tags,
+		ChapterPattern:  book.ChapterPattern,
+		AuthorId:        book.AuthorID,
+	}
+	for _, contributor := range book.Contributors {
+		message.Contributors = append(message.Contributors, &librarypb.Contributor{Name: contributor.Name, Role: contributor.Role})
+	}
+	if book.Rating != nil {
+		message.Rating = &librarypb.RatingSummary{Count: int32(book.Rating.Count), Average: book.Rating.Average, Histogram: map[string]int32{}}
+		for stars, count := range book.Rating.Histogram {
+			message.Rating.Histogram[stars] = int32(count)
+		}
+	}
+	if book.Stats != nil {
+		message.Stats = &librarypb.BookStats{Words: book.Stats.Words, Characters: book.Stats.Characters,
+			ReadingMinutes: book.Stats.ReadingMinutes, Vocabulary: book.Stats.Vocabulary}
+		for _, term := range book.Stats.TopTerms {
+			message.Stats.TopTerms = append(message.Stats.TopTerms, &librarypb.TermCount{Term: term.Term, Count: term.Count})
+		}
+	}
+	return message
+}
+
+// bookFromProto converts a protobuf message to a book to store, timestamps, author id, rating and stats are set by storage
+func bookFromProto(message *librarypb.Book) *lib.Book {
+	book := &lib.Book{
+		ID:              message.GetId(),
+		Name:            message.GetName(),
+		Author:          message.GetAuthor(),
+		Contents:        message.GetContents(),
+		ISBN10:          message.GetIsbn10(),
+		ISBN13:          message.GetIsbn13(),
+		Publisher:       message.GetPublisher(),
+		PublicationDate: message.GetPublicationDate(),
+		Language:        message.GetLanguage(),
+		PageCount:       int(message.GetPageCount()),
+		Series:          message.GetSeries(),
+		Volume:          int(message.GetVolume()),
+		Tags:            message.GetTags(),
+		ChapterPattern:  message.GetChapterPattern(),
+	}
+	for _, contributor := range message.GetContributors() {
+		book.Contributors = append(book.Contributors, lib.Contributor{Name: contributor.GetName(), Role: contributor.GetRole()})
+	}
+	return book
+}
@@ -0,0 +1,140 @@
+package internal
+
+import (
+	"context"
+	"dockerrestapi/librarypb"
+	"errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestGrpc(t *testing.T) {
+	grpcApi, err := createMockApi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := startTestGrpc(t, grpcApi)
+	ctx := context.Background()
+
+	// create, refusing duplicates and incomplete books
+	created, err := client.CreateBook(ctx, &librarypb.CreateBookRequest{Book: &librarypb.Book{
+		Name: "remote", Author: "philip", Contents: "A remote read", Isbn10: "0-306-40615-2", Tags: []string{"Remote", "rpc"},
+		ChapterPattern: "^Part \\d+$", Stats: &librarypb.BookStats{Words: 100},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.GetId() == "" || created.GetIsbn13() != "9780306406157" || created.GetCreatedAt().AsTime() != testClock.Now() {
+		t.Error("expecting the created book normalised got", created)
+	}
+	if created.GetChapterPattern() != "^Part \\d+$" || created.GetStats().GetWords() != 3 || created.GetRating() != nil {
+		t.Error("expecting the chapter pattern kept and the stats counted got", created)
+	}
+	_, err = client.CreateBook(ctx, &librarypb.CreateBookRequest{Book: &librarypb.Book{Name: "remote", Author: "philip", Contents: "Again"}})
+	expectGrpcCode(t, err, codes.AlreadyExists)
+	_, err = client.CreateBook(ctx, &librarypb.CreateBookRequest{Book: &librarypb.Book{Name: "remote"}})
+	expectGrpcCode(t, err, codes.InvalidArgument)
+	_, err = client.CreateBook(ctx, &librarypb.CreateBookRequest{Library: "unknown", Book: &librarypb.Book{Name: "a", Author: "b", Contents: "c"}})
+	expectGrpcCode(t, err, codes.NotFound)
+	_, err = client.CreateBook(ctx, &librarypb.CreateBookRequest{Book: &librarypb.Book{Name: "a", Author: "b", Contents: "c", Language: "not a tag!"}})
+	expectGrpcCode(t, err, codes.InvalidArgument)
+	other, err := client.CreateBook(ctx, &librarypb.CreateBookRequest{Book: &librarypb.Book{Name: "local", Author: "philip", Contents: "A local read"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// get by id or by name and author
+	book, err := client.GetBook(ctx, &librarypb.GetBookRequest{Book: &librarypb.BookIdentifier{Id: created.GetId()}})
+	if err != nil || book.GetContents() != "A remote read" {
+		t.Error("expecting the book by id got", book, err)
+	}
+	book, err = client.GetBook(ctx, &librarypb.GetBookRequest{Book: &librarypb.BookIdentifier{Name: "remote", Author: "philip"}})
+	if err != nil || book.GetId() != created.GetId() {
+		t.Error("expecting the book by name and author got", book, err)
+	}
+	_, err = client.GetBook(ctx, &librarypb.GetBookRequest{Book: &librarypb.BookIdentifier{Name: "remote"}})
+	expectGrpcCode(t, err, codes.InvalidArgument)
+
+	// list and search stream their results
+	if books, err := receiveBooks(client.ListBooks(ctx, &librarypb.ListBooksRequest{})); err != nil || len(books) != 2 {
+		t.Error("expecting 2 books listed got", books, err)
+	}
+	found, err := receiveBooks(client.SearchBooks(ctx, &librarypb.SearchBooksRequest{Isbn: "9780306406157", Tags: []string{"REMOTE"}}))
+	if err != nil || len(found) != 1 || found[0].GetId() != created.GetId() {
+		t.Error("expecting the remote book found got", found, err)
+	}
+	_, err = receiveBooks(client.SearchBooks(ctx, &librarypb.SearchBooksRequest{Isbn: "123"}))
+	expectGrpcCode(t, err, codes.InvalidArgument)
+
+	// update and delete
+	updated, err := client.UpdateBook(ctx, &librarypb.UpdateBookRequest{Book: &librarypb.Book{Id: created.GetId(), Name: "remote", Author: "philip", Contents: "A better read"}})
+	if err != nil || updated.GetContents() != "A better read" {
+		t.Error("expecting the updated book got", updated, err)
+	}
+	_, err = client.UpdateBook(ctx, &librarypb.UpdateBookRequest{Book: &librarypb.Book{Id: created.GetId(), Name: "local", Author: "philip", Contents: "Clash"}})
+	expectGrpcCode(t, err, codes.AlreadyExists)
+	_, err = client.DeleteBook(ctx, &librarypb.DeleteBookRequest{Book: &librarypb.BookIdentifier{Id: other.GetId()}})
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = client.DeleteBook(ctx, &librarypb.DeleteBookRequest{Book: &librarypb.BookIdentifier{Id: other.GetId()}})
+	expectGrpcCode(t, err, codes.NotFound)
+	if trash, _ := grpcApi.db.GetDeletedBooks(); len(trash) != 1 || trash[0].ID != other.GetId() {
+		t.Error("expecting the deleted book in the trash got", trash)
+	}
+}
+
+// startTestGrpc serves the gRPC api of a service in memory, returning a client to it
+func startTestGrpc(t *testing.T, service *RestService) librarypb.LibraryClient {
+	listener := bufconn.Listen(1 << 20)
+	server := service.newGrpcServer()
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return librarypb.NewLibraryClient(conn)
+}
+
+// bookStream is a server stream of book identifiers
+type bookStream interface {
+	Recv() (*librarypb.BookIdentifier, error)
+}
+
+// receiveBooks receives every book of a stream until it ends or fails
+func receiveBooks[S bookStream](stream S, err error) ([]*librarypb.BookIdentifier, error) {
+	if err != nil {
+		return nil, err
+	}
+	var books []*librarypb.BookIdentifier
+	for {
+		book, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return books, nil
+		}
+		if err != nil {
+			return books, err
+		}
+		books = append(books, book)
+	}
+}
+
+func expectGrpcCode(t *testing.T, err error, code codes.Code) {
+	t.Helper()
+	if status.Code(err) != code {
+		t.Error("expecting", code, "got", err)
+	}
+}
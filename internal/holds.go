@@ -0,0 +1,220 @@
+package internal
+
+import (
+	"dockerrestapi/lib"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	bookHoldsPath   = libraryBookPath + "/holds"
+	holdsPath       = libraryPath + "/holds"
+	holdPath        = holdsPath + "/{" + paramHold + "}"
+	patronHoldsPath = patronPath + "/holds"
+	paramHold       = "hold"
+
+	defaultPickupWindow       = 3 * 24 * time.Hour
+	defaultHoldExpiryInterval = time.Minute
+)
+
+// holdRequest is the body of a hold, naming the patron waiting for a copy
+type holdRequest struct {
+	PatronID string `json:"patronId"`
+}
+
+// WithPickupWindow sets how long a copy set aside for a hold waits for its patron before going to the next in queue
+func WithPickupWindow(window time.Duration) ServiceOption {
+	return func(service *RestService) {
+		service.pickupWindow = window
+	}
+}
+
+// getBookHolds lists the queue of a book, the ready holds first then the waiting ones with their position.
+// eg : api/libraries/{library}/books/{id}/holds
+func (r *RestService) getBookHolds(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Book Holds request")
+	bookID, err := r.lendingBookID(request)
+	if err != nil {
+		r.restLendingError(writer, err)
+		return
+	}
+	holds, err := r.lending.GetHolds(r.libraryNameFromRequest(request), lib.HoldFilter{BookID: bookID, Active: true})
+	if err != nil {
+		r.restLendingError(writer, err)
+		return
+	}
+	queue := make([]lib.Hold, 0, len(holds))
+	for _, hold := range holds {
+		if hold.Status == lib.HoldReady {
+			queue = append(queue, hold)
+		}
+	}
+	position := 0
+	for _, hold := range holds {
+		if hold.Status == lib.HoldWaiting {
+			position++
+			hold.Position = position
+			queue = append(queue, hold)
+		}
+	}
+	r.restResponse(writer, http.StatusOK, queue)
+}
+
+// placeHold queues a patron for a book whose copies are all out, the patron being told the position in the queue.
+// eg : api/libraries/{library}/books/{id}/holds with {"patronId": "..."}
+func (r *RestService) placeHold(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received place Hold request")
+	bookID, err := r.lendingBookID(request)
+	if err != nil {
+		r.restLendingError(writer, err)
+		return
+	}
+	body := holdRequest{}
+	err = json.NewDecoder(request.Body).Decode(&body)
+	if err != nil {
+		r.restResponse(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	libraryName := r.libraryNameFromRequest(request)
+	if _, err = r.lending.GetOnePatron(libraryName, body.PatronID); err != nil {
+		r.restLendingError(writer, err)
+		return
+	}
+
+	hold := &lib.Hold{
+		Library:  libraryName,
+		BookID:   bookID,
+		PatronID: body.PatronID,
+		PlacedAt: r.clock.Now(),
+	}
+	unlock := r.lendingLocks.acquire(libraryName, bookID)
+	defer unlock()
+	err = r.lending.PlaceHold(hold, r.pickupWindow)
+	if err != nil {
+		r.restLendingError(writer, err)
+		return
+	}
+	if _, err = r.lendingBookID(request); err != nil {
+		// the book was deleted meanwhile, the hold leaves the queue
+		if _, cancelErr := r.lending.CancelHold(libraryName, hold.ID, r.clock.Now(), r.pickupWindow); cancelErr != nil {
+			stdError("cant cancel hold " + hold.ID + " of deleted book " + cancelErr.Error())
+		}
+		r.restLendingError(writer, err)
+		return
+	}
+	r.respondHold(writer, hold.Library, hold.ID, LibrariesPath+"/"+libraryName+"/holds/"+hold.ID)
+}
+
+// getHold retrieves a hold of a library with its position in the queue while waiting.
+// eg : api/libraries/{library}/holds/{hold}
+func (r *RestService) getHold(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Hold request")
+	r.respondHold(writer, r.libraryNameFromRequest(request), mux.Vars(request)[paramHold], "")
+}
+
+// cancelHold takes a hold out of the queue, the copy set aside for a ready hold going to the next in queue.
+// eg : api/libraries/{library}/holds/{hold}
+func (r *RestService) cancelHold(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received cancel Hold request")
+	hold, err := r.lending.CancelHold(r.libraryNameFromRequest(request), mux.Vars(request)[paramHold], r.clock.Now(), r.pickupWindow)
+	if err != nil {
+		r.restLendingError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, hold)
+}
+
+// getPatronHolds lists the holds of a patron, oldest first, only the ones waiting or ready with active=true.
+// eg : api/libraries/{library}/patrons/{patron}/holds?active=true
+func (r *RestService) getPatronHolds(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Patron Holds request")
+	libraryName := r.libraryNameFromRequest(request)
+	patron, err := r.lending.GetOnePatron(libraryName, mux.Vars(request)[paramPatron])
+	if err != nil {
+		r.restLendingError(writer, err)
+		return
+	}
+	filter := lib.HoldFilter{PatronID: patron.ID, Active: request.URL.Query().Get(paramActive) == "true"}
+	holds, err := r.lending.GetHolds(libraryName, filter)
+	if err != nil {
+		r.restLendingError(writer, err)
+		return
+	}
+	for i := range holds {
+		err = r.setHoldPosition(libraryName, &holds[i])
+		if err != nil {
+			r.restLendingError(writer, err)
+			return
+		}
+	}
+	r.restResponse(writer, http.StatusOK, holds)
+}
+
+// respondHold responds with a hold and its position in the queue, setting the location header when given
+func (r *RestService) respondHold(writer http.ResponseWriter, libraryName, id, location string) {
+	hold, err := r.lending.GetOneHold(libraryName, id)
+	if err != nil {
+		r.restLendingError(writer, err)
+		return
+	}
+	err = r.setHoldPosition(libraryName, hold)
+	if err != nil {
+		r.restLendingError(writer, err)
+		return
+	}
+	if location != "" {
+		writer.Header().Set("Location", location)
+	}
+	r.restResponse(writer, http.StatusOK, hold)
+}
+
+// setHoldPosition sets the position of a waiting hold in the queue of its book
+func (r *RestService) setHoldPosition(libraryName string, hold *lib.Hold) error {
+	if hold.Status != lib.HoldWaiting {
+		return nil
+	}
+	queue, err := r.lending.GetHolds(libraryName, lib.HoldFilter{BookID: hold.BookID, Active: true})
+	if err != nil {
+		return err
+	}
+	for _, other := range queue {
+		if other.Status != lib.HoldWaiting {
+			continue
+		}
+		hold.Position++
+		if other.ID == hold.ID {
+			return nil
+		}
+	}
+	hold.Position = 0 // no longer waiting
+	return nil
+}
+
+// expireHoldsPeriodically expires the ready holds not picked up in time until the service stops
+func (r *RestService) expireHoldsPeriodically() {
+	ticker := time.NewTicker(r.holdExpiryInterval)
+	defer ticker.Stop()
+	for {
+		r.expireHolds()
+		select {
+		case <-ticker.C:
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// expireHolds expires the ready holds of every library past their pickup deadline, returning how many expired
+func (r *RestService) expireHolds() int {
+	expired, err := r.lending.ExpireHolds(r.clock.Now(), r.pickupWindow)
+	if err != nil {
+		stdError("cant expire holds " + err.Error())
+	}
+	if len(expired) > 0 {
+		stdInfo("expired " + strconv.Itoa(len(expired)) + " holds")
+	}
+	return len(expired)
+}
@@ -0,0 +1,237 @@
+package internal
+
+import (
+	"dockerrestapi/db"
+	"dockerrestapi/lib"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHolds(t *testing.T) {
+	clock := lib.NewManualClock(time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC))
+	holdsApi := createLendingApi(t, clock, WithPickupWindow(48*time.Hour))
+	for _, book := range []lib.Book{
+		{Name: "the hobbit", Author: "Tolkien", Contents: "In a hole in the ground."},
+		{Name: "the silmarillion", Author: "Tolkien", Contents: "There was Eru."},
+	} {
+		err := holdsApi.db.CreateNewBook(&book)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	hobbit, err := holdsApi.db.GetOneBook(&lib.BookIdentifier{Name: "the hobbit", Author: "Tolkien"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	silmarillion, err := holdsApi.db.GetOneBook(&lib.BookIdentifier{Name: "the silmarillion", Author: "Tolkien"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	book := map[string]string{paramLibrary: lib.DefaultLibraryName, paramID: hobbit.ID}
+	alice := createTestPatron(t, holdsApi, "Alice")
+	bob := createTestPatron(t, holdsApi, "Bob")
+	carol := createTestPatron(t, holdsApi, "Carol")
+	dave := createTestPatron(t, holdsApi, "Dave")
+
+	// holds are only placed once every copy is out
+	placeTestHold(t, holdsApi, map[string]string{paramLibrary: lib.DefaultLibraryName, paramID: silmarillion.ID}, bob.ID, http.StatusBadRequest)
+	aliceLoan := checkoutTestBook(t, holdsApi, book, alice.ID, http.StatusOK)
+
+	// holds queue first come first served, once per patron
+	bobHold := placeTestHold(t, holdsApi, book, bob.ID, http.StatusOK)
+	clock.Advance(time.Minute)
+	carolHold := placeTestHold(t, holdsApi, book, carol.ID, http.StatusOK)
+	clock.Advance(time.Minute)
+	daveHold := placeTestHold(t, holdsApi, book, dave.ID, http.StatusOK)
+	if bobHold.Status != lib.HoldWaiting || bobHold.Position != 1 || carolHold.Position != 2 || daveHold.Position != 3 {
+		t.Error("expecting three waiting holds in order got", bobHold, carolHold, daveHold)
+	}
+	placeTestHold(t, holdsApi, book, bob.ID, http.StatusBadRequest)
+	placeTestHold(t, holdsApi, book, "000000000000000000000000", http.StatusNotFound)
+
+	// cancelled holds leave the queue
+	carolParams := map[string]string{paramLibrary: lib.DefaultLibraryName, paramHold: carolHold.ID}
+	cancelled := decodeTestResponse[lib.Hold](t, http.MethodDelete, holdPath, holdsApi.cancelHold, nil, http.StatusOK, carolParams)
+	if cancelled.Status != lib.HoldCancelled || cancelled.ClosedAt == nil {
+		t.Error("expecting a cancelled hold got", cancelled)
+	}
+	_, err = testResponse(http.MethodDelete, holdPath, holdsApi.cancelHold, nil, http.StatusBadRequest, carolParams)
+	if err != nil {
+		t.Error(err)
+	}
+	daveParams := map[string]string{paramLibrary: lib.DefaultLibraryName, paramHold: daveHold.ID}
+	if hold := decodeTestResponse[lib.Hold](t, http.MethodGet, holdPath, holdsApi.getHold, nil, http.StatusOK, daveParams); hold.Position != 2 {
+		t.Error("expecting Dave to move up the queue got", hold)
+	}
+
+	// a returned copy is set aside for the next in queue, whom only can check it out
+	decodeTestResponse[lib.Loan](t, http.MethodPut, loanReturnPath, holdsApi.returnLoan, nil, http.StatusOK, map[string]string{paramLibrary: lib.DefaultLibraryName, paramLoan: aliceLoan.ID})
+	queue := decodeTestResponse[[]lib.Hold](t, http.MethodGet, bookHoldsPath, holdsApi.getBookHolds, nil, http.StatusOK, book)
+	if len(queue) != 2 || queue[0].ID != bobHold.ID || queue[0].Status != lib.HoldReady || queue[1].ID != daveHold.ID || queue[1].Position != 1 {
+		t.Fatal("expecting Bob to be ready and Dave next got", queue)
+	}
+	if !queue[0].ExpiresAt.Equal(clock.Now().Add(48 * time.Hour)) {
+		t.Error("expecting the copy to wait two days got", queue[0].ExpiresAt)
+	}
+	if copies := getTestCopies(t, holdsApi, book); copies.OnHold != 1 || copies.Available != 0 {
+		t.Error("expecting the copy to be set aside got", copies)
+	}
+	checkoutTestBook(t, holdsApi, book, alice.ID, http.StatusBadRequest)
+	checkoutTestBook(t, holdsApi, book, dave.ID, http.StatusBadRequest)
+
+	// copies not picked up in time go to the next in queue
+	clock.Advance(47 * time.Hour)
+	if expired := holdsApi.expireHolds(); expired != 0 {
+		t.Error("expecting no hold to expire yet got", expired)
+	}
+	clock.Advance(2 * time.Hour)
+	if expired := holdsApi.expireHolds(); expired != 1 {
+		t.Error("expecting the hold of Bob to expire got", expired)
+	}
+	bobHolds := decodeTestResponse[[]lib.Hold](t, http.MethodGet, patronHoldsPath, holdsApi.getPatronHolds, nil, http.StatusOK, map[string]string{paramLibrary: lib.DefaultLibraryName, paramPatron: bob.ID})
+	if len(bobHolds) != 1 || bobHolds[0].Status != lib.HoldExpired {
+		t.Error("expecting the expired hold of Bob got", bobHolds)
+	}
+	if hold := decodeTestResponse[lib.Hold](t, http.MethodGet, holdPath, holdsApi.getHold, nil, http.StatusOK, daveParams); hold.Status != lib.HoldReady {
+		t.Error("expecting the copy to be set aside for Dave got", hold)
+	}
+
+	// books on hold cannot be deleted, nor patrons holding them
+	_, err = testResponse(http.MethodDelete, libraryBookPath, holdsApi.deleteBook, nil, http.StatusBadRequest, book)
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = testResponse(http.MethodDelete, patronPath, holdsApi.deletePatron, nil, http.StatusBadRequest, map[string]string{paramLibrary: lib.DefaultLibraryName, paramPatron: dave.ID})
+	if err != nil {
+		t.Error(err)
+	}
+
+	// checking out the copy set aside fulfils the hold
+	daveLoan := checkoutTestBook(t, holdsApi, book, dave.ID, http.StatusOK)
+	if hold := decodeTestResponse[lib.Hold](t, http.MethodGet, holdPath, holdsApi.getHold, nil, http.StatusOK, daveParams); hold.Status != lib.HoldFulfilled {
+		t.Error("expecting the hold of Dave to be fulfilled got", hold)
+	}
+	if copies := getTestCopies(t, holdsApi, book); copies.OnHold != 0 || copies.OnLoan != 1 || copies.Waiting != 0 {
+		t.Error("expecting the copy on loan got", copies)
+	}
+
+	// added copies go to the holds waiting for one
+	carolHold = placeTestHold(t, holdsApi, book, carol.ID, http.StatusOK)
+	_, err = testResponse(http.MethodPut, bookCopiesPath, holdsApi.setBookCopies, []byte(`{"copies":2}`), http.StatusOK, book)
+	if err != nil {
+		t.Error(err)
+	}
+	if hold := decodeTestResponse[lib.Hold](t, http.MethodGet, holdPath, holdsApi.getHold, nil, http.StatusOK, map[string]string{paramLibrary: lib.DefaultLibraryName, paramHold: carolHold.ID}); hold.Status != lib.HoldReady {
+		t.Error("expecting the added copy to be set aside for Carol got", hold)
+	}
+	decodeTestResponse[lib.Loan](t, http.MethodPut, loanReturnPath, holdsApi.returnLoan, nil, http.StatusOK, map[string]string{paramLibrary: lib.DefaultLibraryName, paramLoan: daveLoan.ID})
+	if copies := getTestCopies(t, holdsApi, book); copies.Available != 1 {
+		t.Error("expecting the returned copy back on the shelf got", copies)
+	}
+}
+
+func TestConcurrentReturnsAndHolds(t *testing.T) {
+	lending, err := db.CreateMockLendingStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const copies = 5
+	now := time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC)
+	_, err = lending.SetCopies(lib.DefaultLibraryName, "book", copies, now, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loans []lib.Loan
+	for i := 0; i < copies; i++ {
+		loan := lib.Loan{Library: lib.DefaultLibraryName, BookID: "book", PatronID: "borrower" + strconv.Itoa(i), CheckedOutAt: now}
+		err = lending.CheckoutBook(&loan)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loans = append(loans, loan)
+	}
+
+	// patrons queue while copies are returned, taking a copy left free instead, and some change their mind
+	var wait sync.WaitGroup
+	for _, loan := range loans {
+		wait.Add(1)
+		go func(loan lib.Loan) {
+			defer wait.Done()
+			_, err := lending.ReturnLoan(lib.DefaultLibraryName, loan.ID, now, time.Hour)
+			if err != nil {
+				t.Error(err)
+			}
+		}(loan)
+	}
+	for i := 0; i < 30; i++ {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			patron := "patron" + strconv.Itoa(i)
+			hold := &lib.Hold{Library: lib.DefaultLibraryName, BookID: "book", PatronID: patron, PlacedAt: now}
+			err := lending.PlaceHold(hold, time.Hour)
+			if errors.Is(err, lib.CopyAvailable) {
+				err = lending.CheckoutBook(&lib.Loan{Library: lib.DefaultLibraryName, BookID: "book", PatronID: patron, CheckedOutAt: now})
+				if err != nil && !errors.Is(err, lib.NoCopyAvailable) {
+					t.Error(err)
+				}
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if i%3 == 0 {
+				_, err = lending.CancelHold(lib.DefaultLibraryName, hold.ID, now, time.Hour)
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	wait.Wait()
+
+	// every copy is accounted for, and copies only ever went to the oldest holds
+	counts, err := lending.GetCopies(lib.DefaultLibraryName, "book")
+	if err != nil {
+		t.Fatal(err)
+	}
+	active, err := lending.GetLoans(lib.DefaultLibraryName, lib.LoanFilter{BookID: "book", Active: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	holds, err := lending.GetHolds(lib.DefaultLibraryName, lib.HoldFilter{BookID: "book", Active: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ready, waiting := 0, 0
+	var lastReady, firstWaiting int64 = 0, 1 << 62
+	for _, hold := range holds {
+		if hold.Status == lib.HoldReady {
+			ready++
+			lastReady = max(lastReady, hold.Sequence)
+		} else {
+			waiting++
+			firstWaiting = min(firstWaiting, hold.Sequence)
+		}
+	}
+	if counts.OnLoan != len(active) || counts.OnHold != ready || counts.Waiting != waiting || counts.OnLoan+counts.OnHold > copies {
+		t.Error("expecting the counts to match the loans and holds got", counts, len(active), ready, waiting)
+	}
+	if waiting > 0 && counts.Free() > 0 {
+		t.Error("expecting no copy left free while holds wait got", counts)
+	}
+	if lastReady > firstWaiting {
+		t.Error("expecting copies to go to the oldest holds got", holds)
+	}
+}
+
+// placeTestHold places a hold for a patron, returning the hold when placed
+func placeTestHold(t *testing.T, service *RestService, params map[string]string, patronID string, expectedStatus int) lib.Hold {
+	t.Helper()
+	return decodeTestResponse[lib.Hold](t, http.MethodPut, bookHoldsPath, service.placeHold, holdRequest{PatronID: patronID}, expectedStatus, params)
+}
@@ -0,0 +1,364 @@
+package internal
+
+import (
+	"dockerrestapi/db"
+	"dockerrestapi/lib"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"net/http"
+	"time"
+)
+
+const (
+	patronsPath     = libraryPath + "/patrons"
+	patronPath      = patronsPath + "/{" + paramPatron + "}"
+	patronLoansPath = patronPath + "/loans"
+	bookCopiesPath  = libraryBookPath + "/copies"
+	bookLoansPath   = libraryBookPath + "/loans"
+	loansPath       = libraryPath + "/loans"
+	loanPath        = loansPath + "/{" + paramLoan + "}"
+	loanReturnPath  = loanPath + "/return"
+	loanRenewPath   = loanPath + "/renew"
+	paramPatron     = "patron"
+	paramLoan       = "loan"
+	paramActive     = "active"
+	paramOverdue    = "overdue"
+
+	defaultLoanPeriod  = 14 * 24 * time.Hour
+	defaultMaxRenewals = 2
+)
+
+// checkoutRequest is the body of a checkout, naming the patron borrowing a copy
+type checkoutRequest struct {
+	PatronID string `json:"patronId"`
+}
+
+// copiesRequest is the body setting the number of copies of a book
+type copiesRequest struct {
+	Copies *int `json:"copies"`
+}
+
+// WithLendingStore enables lending, patrons, copy counts and loans being kept in the given store
+func WithLendingStore(lending db.LendingStoreInterface) ServiceOption {
+	return func(service *RestService) {
+		service.lending = lending
+	}
+}
+
+// WithLoanPolicy sets how long a copy is lent for, by checkout and by renewal, and how many times a loan may be renewed
+func WithLoanPolicy(period time.Duration, maxRenewals int) ServiceOption {
+	return func(service *RestService) {
+		service.loanPeriod = period
+		service.maxRenewals = maxRenewals
+	}
+}
+
+// getPatrons lists the patrons of a library.
+// eg : api/libraries/{library}/patrons
+func (r *RestService) getPatrons(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Patrons request")
+	patrons, err := r.lending.GetAllPatrons(r.libraryNameFromRequest(request))
+	if err != nil {
+		r.restLendingError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, patrons)
+}
+
+// createPatron registers a new patron of a library, who needs a name and may have an email.
+// eg : api/libraries/{library}/patrons
+func (r *RestService) createPatron(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received create Patron request")
+	if _, err := r.libraryFromRequest(request); err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+
+	patron := &lib.Patron{}
+	err := json.NewDecoder(request.Body).Decode(patron)
+	if err != nil {
+		r.restResponse(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	err = patron.Normalise()
+	if err != nil {
+		r.restResponse(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	patron.Library = r.libraryNameFromRequest(request)
+	patron.CreatedAt = r.clock.Now()
+
+	err = r.lending.CreateNewPatron(patron)
+	if err != nil {
+		r.restLendingError(writer, err)
+		return
+	}
+	writer.Header().Set("Location", LibrariesPath+"/"+patron.Library+"/patrons/"+patron.ID)
+	r.restResponse(writer, http.StatusOK, patron)
+}
+
+// getPatron retrieves a patron of a library.
+// eg : api/libraries/{library}/patrons/{patron}
+func (r *RestService) getPatron(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Patron request")
+	patron, err := r.lending.GetOnePatron(r.libraryNameFromRequest(request), mux.Vars(request)[paramPatron])
+	if err != nil {
+		r.restLendingError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, patron)
+}
+
+// deletePatron deletes a patron of a library, which fails while the patron has books on loan. Its loan history is kept.
+// eg : api/libraries/{library}/patrons/{patron}
+func (r *RestService) deletePatron(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received delete Patron request")
+	err := r.lending.DeletePatron(r.libraryNameFromRequest(request), mux.Vars(request)[paramPatron])
+	if err != nil {
+		r.restLendingError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, nil)
+}
+
+// getPatronLoans lists the loans of a patron, oldest first, only the ones not returned yet with active=true.
+// eg : api/libraries/{library}/patrons/{patron}/loans?active=true
+func (r *RestService) getPatronLoans(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Patron Loans request")
+	libraryName := r.libraryNameFromRequest(request)
+	patron, err := r.lending.GetOnePatron(libraryName, mux.Vars(request)[paramPatron])
+	if err != nil {
+		r.restLendingError(writer, err)
+		return
+	}
+	filter := r.createLoanFilterFromQuery(request)
+	filter.PatronID = patron.ID
+	r.respondLoans(writer, libraryName, filter)
+}
+
+// getBookCopies retrieves the number of copies of a book, how many are on loan and how many are available.
+// eg : api/libraries/{library}/books/{id}/copies
+func (r *RestService) getBookCopies(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Book Copies request")
+	bookID, err := r.lendingBookID(request)
+	if err != nil {
+		r.restLendingError(writer, err)
+		return
+	}
+	copies, err := r.lending.GetCopies(r.libraryNameFromRequest(request), bookID)
+	if err != nil {
+		r.restLendingError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, copies)
+}
+
+// setBookCopies sets the number of copies the library owns of a book, which cannot be less than the copies on loan or set aside for holds.
+// Added copies are set aside for the holds waiting for one.
+// eg : api/libraries/{library}/books/{id}/copies with {"copies": 3}
+func (r *RestService) setBookCopies(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received set Book Copies request")
+	bookID, err := r.lendingBookID(request)
+	if err != nil {
+		r.restLendingError(writer, err)
+		return
+	}
+	body := copiesRequest{}
+	err = json.NewDecoder(request.Body).Decode(&body)
+	if err != nil {
+		r.restResponse(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	if body.Copies == nil {
+		r.restResponse(writer, http.StatusBadRequest, lib.IncorrectCopies.Error())
+		return
+	}
+	copies, err := r.lending.SetCopies(r.libraryNameFromRequest(request), bookID, *body.Copies, r.clock.Now(), r.pickupWindow)
+	if err != nil {
+		r.restLendingError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, copies)
+}
+
+// getBookLoans lists the loans of a book, oldest first, only the ones not returned yet with active=true.
+// eg : api/libraries/{library}/books/{id}/loans?active=true
+func (r *RestService) getBookLoans(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Book Loans request")
+	bookID, err := r.lendingBookID(request)
+	if err != nil {
+		r.restLendingError(writer, err)
+		return
+	}
+	filter := r.createLoanFilterFromQuery(request)
+	filter.BookID = bookID
+	r.respondLoans(writer, r.libraryNameFromRequest(request), filter)
+}
+
+// checkoutBook lends a copy of a book to a patron until the end of the loan period, the copy set aside for the ready hold of the patron
+// or otherwise a copy available to anyone.
+// eg : api/libraries/{library}/books/{id}/loans with {"patronId": "..."}
+func (r *RestService) checkoutBook(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received checkout Book request")
+	bookID, err := r.lendingBookID(request)
+	if err != nil {
+		r.restLendingError(writer, err)
+		return
+	}
+	body := checkoutRequest{}
+	err = json.NewDecoder(request.Body).Decode(&body)
+	if err != nil {
+		r.restResponse(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	libraryName := r.libraryNameFromRequest(request)
+	if _, err = r.lending.GetOnePatron(libraryName, body.PatronID); err != nil {
+		r.restLendingError(writer, err)
+		return
+	}
+
+	now := r.clock.Now()
+	loan := &lib.Loan{
+		Library:      libraryName,
+		BookID:       bookID,
+		PatronID:     body.PatronID,
+		CheckedOutAt: now,
+		DueAt:        now.Add(r.loanPeriod),
+	}
+	unlock := r.lendingLocks.acquire(libraryName, bookID)
+	defer unlock()
+	err = r.lending.CheckoutBook(loan)
+	if err != nil {
+		r.restLendingError(writer, err)
+		return
+	}
+	if _, err = r.lendingBookID(request); err != nil {
+		// the book was deleted meanwhile, the copy goes back on the shelf
+		if _, returnErr := r.lending.ReturnLoan(libraryName, loan.ID, now, r.pickupWindow); returnErr != nil {
+			stdError("cant return loan " + loan.ID + " of deleted book " + returnErr.Error())
+		}
+		r.restLendingError(writer, err)
+		return
+	}
+	writer.Header().Set("Location", LibrariesPath+"/"+libraryName+"/loans/"+loan.ID)
+	r.restResponse(writer, http.StatusOK, loan)
+}
+
+// getLoans lists the loans of a library, oldest first, only the ones not returned yet with active=true
+// and only the ones past their due date with overdue=true.
+// eg : api/libraries/{library}/loans?overdue=true
+func (r *RestService) getLoans(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Loans request")
+	if _, err := r.libraryFromRequest(request); err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+	r.respondLoans(writer, r.libraryNameFromRequest(request), r.createLoanFilterFromQuery(request))
+}
+
+// getLoan retrieves a loan of a library.
+// eg : api/libraries/{library}/loans/{loan}
+func (r *RestService) getLoan(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Loan request")
+	loan, err := r.lending.GetOneLoan(r.libraryNameFromRequest(request), mux.Vars(request)[paramLoan])
+	if err != nil {
+		r.restLendingError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, loan)
+}
+
+// returnLoan puts the loaned copy back on the shelf, or sets it aside for the next hold on the book, the loan staying in the history.
+// eg : api/libraries/{library}/loans/{loan}/return
+func (r *RestService) returnLoan(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received return Loan request")
+	loan, err := r.lending.ReturnLoan(r.libraryNameFromRequest(request), mux.Vars(request)[paramLoan], r.clock.Now(), r.pickupWindow)
+	if err != nil {
+		r.restLendingError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, loan)
+}
+
+// renewLoan extends a loan not yet overdue by a loan period from now, at most the maximum number of renewals.
+// eg : api/libraries/{library}/loans/{loan}/renew
+func (r *RestService) renewLoan(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received renew Loan request")
+	now := r.clock.Now()
+	loan, err := r.lending.RenewLoan(r.libraryNameFromRequest(request), mux.Vars(request)[paramLoan], now, now.Add(r.loanPeriod), r.maxRenewals)
+	if err != nil {
+		r.restLendingError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, loan)
+}
+
+// respondLoans responds with the loans of a library matching the filter
+func (r *RestService) respondLoans(writer http.ResponseWriter, libraryName string, filter lib.LoanFilter) {
+	loans, err := r.lending.GetLoans(libraryName, filter)
+	if err != nil {
+		r.restLendingError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, loans)
+}
+
+// createLoanFilterFromQuery returns a loan filter from the list query parameters active and overdue
+func (r *RestService) createLoanFilterFromQuery(request *http.Request) lib.LoanFilter {
+	query := request.URL.Query()
+	filter := lib.LoanFilter{Active: query.Get(paramActive) == "true"}
+	if query.Get(paramOverdue) == "true" {
+		filter.DueBefore = r.clock.Now()
+	}
+	return filter
+}
+
+// lendingBookID returns the id of the book in the path, failing when the library or a book of this id not in the trash is missing
+func (r *RestService) lendingBookID(request *http.Request) (string, error) {
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		return "", err
+	}
+	book, err := library.GetOneBookWithFields(&lib.BookIdentifier{ID: mux.Vars(request)[paramID]}, nil)
+	if err != nil {
+		return "", err
+	}
+	return book.ID, nil
+}
+
+// checkNoActiveLoans fails with lib.BookHasActiveLoans when copies of the book are on loan or on hold, lending disabled meaning none are
+func (r *RestService) checkNoActiveLoans(libraryName, bookID string) error {
+	if r.lending == nil || bookID == "" {
+		return nil
+	}
+	loans, err := r.lending.GetLoans(libraryName, lib.LoanFilter{BookID: bookID, Active: true})
+	if err != nil {
+		return err
+	}
+	holds, err := r.lending.GetHolds(libraryName, lib.HoldFilter{BookID: bookID, Active: true})
+	if err != nil {
+		return err
+	}
+	if len(loans) > 0 || len(holds) > 0 {
+		return lib.BookHasActiveLoans
+	}
+	return nil
+}
+
+// restLendingError responds to a failed lending operation with the status matching the error
+func (r *RestService) restLendingError(writer http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, lib.NoMatchingLibrary):
+		r.restLibraryError(writer, err)
+	case errors.Is(err, lib.NoMatchingPatron), errors.Is(err, lib.NoMatchingLoan), errors.Is(err, lib.NoMatchingHold), errors.Is(err, lib.NoMatchingBook):
+		r.restResponse(writer, http.StatusNotFound, err.Error())
+	case errors.Is(err, lib.NoCopyAvailable), errors.Is(err, lib.LoanAlreadyReturned), errors.Is(err, lib.LoanOverdue),
+		errors.Is(err, lib.RenewalLimitReached), errors.Is(err, lib.PatronHasActiveLoans), errors.Is(err, lib.IncorrectCopies),
+		errors.Is(err, lib.CopiesOnLoan), errors.Is(err, lib.HoldAlreadyPlaced), errors.Is(err, lib.CopyAvailable), errors.Is(err, lib.HoldNotActive):
+		r.restResponse(writer, http.StatusBadRequest, err.Error())
+	default:
+		stdError(err.Error())
+		r.restResponse(writer, http.StatusInternalServerError, err.Error())
+	}
+}
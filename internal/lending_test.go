@@ -0,0 +1,308 @@
+package internal
+
+import (
+	"dockerrestapi/db"
+	"dockerrestapi/lib"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLending(t *testing.T) {
+	clock := lib.NewManualClock(time.Date(2024, time.May, 1, 0, 0, 0, 0, time.UTC))
+	lendingApi := createLendingApi(t, clock, WithLoanPolicy(7*24*time.Hour, 1))
+	library := map[string]string{paramLibrary: lib.DefaultLibraryName}
+	err := lendingApi.db.CreateNewBook(&lib.Book{Name: "the hobbit", Author: "Tolkien", Contents: "In a hole in the ground."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	books, err := lendingApi.db.GetAllBooks(lib.BookFilter{})
+	if err != nil || len(books) != 1 {
+		t.Fatal("expecting the hobbit got", books, err)
+	}
+	book := map[string]string{paramLibrary: lib.DefaultLibraryName, paramID: books[0].ID}
+
+	// patrons need a name and a valid email
+	for _, bad := range []string{`{"email":"bilbo@shire.me"}`, `{"name":"Bilbo","email":"not an email"}`} {
+		_, err = testResponse(http.MethodPut, patronsPath, lendingApi.createPatron, []byte(bad), http.StatusBadRequest, library)
+		if err != nil {
+			t.Error(bad, err)
+		}
+	}
+	alice := createTestPatron(t, lendingApi, "Alice")
+	bob := createTestPatron(t, lendingApi, "Bob")
+	carol := createTestPatron(t, lendingApi, "Carol")
+
+	// a book has a single copy until told otherwise
+	if copies := getTestCopies(t, lendingApi, book); copies.Copies != lib.DefaultCopies || copies.Available != lib.DefaultCopies {
+		t.Error("expecting a single available copy got", copies)
+	}
+	for _, bad := range []string{`{}`, `{"copies":-1}`} {
+		_, err = testResponse(http.MethodPut, bookCopiesPath, lendingApi.setBookCopies, []byte(bad), http.StatusBadRequest, book)
+		if err != nil {
+			t.Error(bad, err)
+		}
+	}
+	_, err = testResponse(http.MethodPut, bookCopiesPath, lendingApi.setBookCopies, []byte(`{"copies":2}`), http.StatusOK, book)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// copies are lent while available, for the loan period
+	aliceLoan := checkoutTestBook(t, lendingApi, book, alice.ID, http.StatusOK)
+	if !aliceLoan.DueAt.Equal(clock.Now().Add(7*24*time.Hour)) || aliceLoan.ReturnedAt != nil {
+		t.Error("expecting a loan due in a week got", aliceLoan)
+	}
+	bobLoan := checkoutTestBook(t, lendingApi, book, bob.ID, http.StatusOK)
+	checkoutTestBook(t, lendingApi, book, carol.ID, http.StatusBadRequest)
+	checkoutTestBook(t, lendingApi, book, "000000000000000000000000", http.StatusNotFound)
+	checkoutTestBook(t, lendingApi, map[string]string{paramLibrary: lib.DefaultLibraryName, paramID: "000000000000000000000000"}, alice.ID, http.StatusNotFound)
+	if copies := getTestCopies(t, lendingApi, book); copies.OnLoan != 2 || copies.Available != 0 {
+		t.Error("expecting both copies on loan got", copies)
+	}
+	_, err = testResponse(http.MethodPut, bookCopiesPath, lendingApi.setBookCopies, []byte(`{"copies":1}`), http.StatusBadRequest, book)
+	if err != nil {
+		t.Error("expecting copies on loan to be kept", err)
+	}
+
+	// neither books on loan nor their borrowers can be deleted
+	_, err = testResponse(http.MethodDelete, libraryBookPath, lendingApi.deleteBook, nil, http.StatusBadRequest, book)
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = testResponse(http.MethodDelete, deleteBookPath, lendingApi.deleteBook, nil, http.StatusBadRequest, map[string]string{paramName: "the hobbit", paramAuthor: "Tolkien"})
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = testResponse(http.MethodDelete, patronPath, lendingApi.deletePatron, nil, http.StatusBadRequest, map[string]string{paramLibrary: lib.DefaultLibraryName, paramPatron: alice.ID})
+	if err != nil {
+		t.Error(err)
+	}
+
+	// loans are renewed from now, as many times as allowed
+	clock.Advance(24 * time.Hour)
+	aliceParams := map[string]string{paramLibrary: lib.DefaultLibraryName, paramLoan: aliceLoan.ID}
+	renewed := decodeTestResponse[lib.Loan](t, http.MethodPut, loanRenewPath, lendingApi.renewLoan, nil, http.StatusOK, aliceParams)
+	if renewed.Renewals != 1 || !renewed.DueAt.Equal(clock.Now().Add(7*24*time.Hour)) {
+		t.Error("expecting a loan renewed for a week got", renewed)
+	}
+	_, err = testResponse(http.MethodPut, loanRenewPath, lendingApi.renewLoan, nil, http.StatusBadRequest, aliceParams)
+	if err != nil {
+		t.Error("expecting the renewal limit to be reached", err)
+	}
+
+	// overdue loans are listed and cannot be renewed
+	clock.Advance(6*24*time.Hour + time.Hour)
+	overdue := decodeTestResponse[[]lib.Loan](t, http.MethodGet, loansPath+"?overdue=true", lendingApi.getLoans, nil, http.StatusOK, library)
+	if len(overdue) != 1 || overdue[0].ID != bobLoan.ID {
+		t.Error("expecting the loan of Bob to be overdue got", overdue)
+	}
+	bobParams := map[string]string{paramLibrary: lib.DefaultLibraryName, paramLoan: bobLoan.ID}
+	_, err = testResponse(http.MethodPut, loanRenewPath, lendingApi.renewLoan, nil, http.StatusBadRequest, bobParams)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// returned copies are available again, the loans staying in the history
+	returned := decodeTestResponse[lib.Loan](t, http.MethodPut, loanReturnPath, lendingApi.returnLoan, nil, http.StatusOK, bobParams)
+	if returned.ReturnedAt == nil || !returned.ReturnedAt.Equal(clock.Now()) {
+		t.Error("expecting a returned loan got", returned)
+	}
+	_, err = testResponse(http.MethodPut, loanReturnPath, lendingApi.returnLoan, nil, http.StatusBadRequest, bobParams)
+	if err != nil {
+		t.Error(err)
+	}
+	if copies := getTestCopies(t, lendingApi, book); copies.OnLoan != 1 || copies.Available != 1 {
+		t.Error("expecting a copy back on the shelf got", copies)
+	}
+	if loans := decodeTestResponse[[]lib.Loan](t, http.MethodGet, bookLoansPath, lendingApi.getBookLoans, nil, http.StatusOK, book); len(loans) != 2 || loans[0].ID != aliceLoan.ID {
+		t.Error("expecting both loans in the history got", loans)
+	}
+	if loans := decodeTestResponse[[]lib.Loan](t, http.MethodGet, bookLoansPath+"?active=true", lendingApi.getBookLoans, nil, http.StatusOK, book); len(loans) != 1 || loans[0].ID != aliceLoan.ID {
+		t.Error("expecting the loan of Alice to be active got", loans)
+	}
+	bobPatron := map[string]string{paramLibrary: lib.DefaultLibraryName, paramPatron: bob.ID}
+	if loans := decodeTestResponse[[]lib.Loan](t, http.MethodGet, patronLoansPath, lendingApi.getPatronLoans, nil, http.StatusOK, bobPatron); len(loans) != 1 || loans[0].ID != bobLoan.ID {
+		t.Error("expecting the history of Bob got", loans)
+	}
+	_, err = testResponse(http.MethodDelete, patronPath, lendingApi.deletePatron, nil, http.StatusOK, bobPatron)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// once every copy is back the book can be deleted
+	decodeTestResponse[lib.Loan](t, http.MethodPut, loanReturnPath, lendingApi.returnLoan, nil, http.StatusOK, aliceParams)
+	_, err = testResponse(http.MethodDelete, libraryBookPath, lendingApi.deleteBook, nil, http.StatusOK, book)
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = testResponse(http.MethodGet, bookCopiesPath, lendingApi.getBookCopies, nil, http.StatusNotFound, book)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestConcurrentCheckouts(t *testing.T) {
+	lending, err := db.CreateMockLendingStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = lending.SetCopies(lib.DefaultLibraryName, "book", 3, time.Now(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wait sync.WaitGroup
+	var lock sync.Mutex
+	lent := 0
+	for i := 0; i < 20; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			err := lending.CheckoutBook(&lib.Loan{Library: lib.DefaultLibraryName, BookID: "book", PatronID: "patron"})
+			if err == nil {
+				lock.Lock()
+				lent++
+				lock.Unlock()
+			}
+		}()
+	}
+	wait.Wait()
+	if lent != 3 {
+		t.Error("expecting exactly three copies lent got", lent)
+	}
+}
+
+func TestCheckoutWhileDeleting(t *testing.T) {
+	clock := lib.NewManualClock(time.Date(2024, time.May, 1, 0, 0, 0, 0, time.UTC))
+	mockConn, err := db.CreateMockDBHandlerWithClock(clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockLending, err := db.CreateMockLendingStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lending := &hookedLendingStore{LendingStoreInterface: mockLending, checkedOut: make(chan struct{}, 1)}
+	lendingApi, err := CreateRestApiService(mockConn, "8081", WithLendingStore(lending), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = lendingApi.db.CreateNewBook(&lib.Book{Name: "the hobbit", Author: "Tolkien", Contents: "In a hole in the ground."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	books, err := lendingApi.db.GetAllBooks(lib.BookFilter{})
+	if err != nil || len(books) != 1 {
+		t.Fatal("expecting the hobbit got", books, err)
+	}
+	book := map[string]string{paramLibrary: lib.DefaultLibraryName, paramID: books[0].ID}
+	alice := createTestPatron(t, lendingApi, "Alice")
+	body, err := json.Marshal(checkoutRequest{PatronID: alice.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a checkout arriving once the delete checked the loans waits for the delete, then finds the book gone
+	checkout := make(chan error, 1)
+	lending.checked = func() {
+		go func() {
+			_, err := testResponse(http.MethodPut, bookLoansPath, lendingApi.checkoutBook, body, http.StatusNotFound, book)
+			checkout <- err
+		}()
+		select {
+		case <-lending.checkedOut:
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	_, err = testResponse(http.MethodDelete, libraryBookPath, lendingApi.deleteBook, nil, http.StatusOK, book)
+	if err != nil {
+		t.Error(err)
+	}
+	if err = <-checkout; err != nil {
+		t.Error(err)
+	}
+	if loans := decodeTestResponse[[]lib.Loan](t, http.MethodGet, loansPath+"?active=true", lendingApi.getLoans, nil, http.StatusOK,
+		map[string]string{paramLibrary: lib.DefaultLibraryName}); len(loans) != 0 {
+		t.Error("expecting no active loan of the trashed book got", loans)
+	}
+}
+
+// hookedLendingStore is a lending store calling checked once the holds of a book are first read, and telling checkedOut
+// about every checkout
+type hookedLendingStore struct {
+	db.LendingStoreInterface
+	checked    func()
+	checkedOut chan struct{}
+}
+
+// GetHolds reads the holds then calls checked, once
+func (s *hookedLendingStore) GetHolds(library string, filter lib.HoldFilter) ([]lib.Hold, error) {
+	holds, err := s.LendingStoreInterface.GetHolds(library, filter)
+	if checked := s.checked; checked != nil {
+		s.checked = nil
+		checked()
+	}
+	return holds, err
+}
+
+// CheckoutBook lends a copy then tells checkedOut, without waiting
+func (s *hookedLendingStore) CheckoutBook(loan *lib.Loan) error {
+	err := s.LendingStoreInterface.CheckoutBook(loan)
+	select {
+	case s.checkedOut <- struct{}{}:
+	default:
+	}
+	return err
+}
+
+// createLendingApi creates a rest api lending books with the given clock
+func createLendingApi(t *testing.T, clock lib.Clock, options ...ServiceOption) *RestService {
+	t.Helper()
+	mockConn, err := db.CreateMockDBHandlerWithClock(clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lending, err := db.CreateMockLendingStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := CreateRestApiService(mockConn, "8081", append([]ServiceOption{WithLendingStore(lending), WithClock(clock)}, options...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service
+}
+
+// createTestPatron registers a patron of the default library
+func createTestPatron(t *testing.T, service *RestService, name string) lib.Patron {
+	t.Helper()
+	marshalPatron, err := json.Marshal(lib.Patron{Name: name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := testResponse(http.MethodPut, patronsPath, service.createPatron, marshalPatron, http.StatusOK, map[string]string{paramLibrary: lib.DefaultLibraryName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	patron := lib.Patron{}
+	err = json.Unmarshal([]byte(response), &patron)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return patron
+}
+
+// getTestCopies retrieves the copies of a book
+func getTestCopies(t *testing.T, service *RestService, params map[string]string) lib.BookCopies {
+	t.Helper()
+	return decodeTestResponse[lib.BookCopies](t, http.MethodGet, bookCopiesPath, service.getBookCopies, nil, http.StatusOK, params)
+}
+
+// checkoutTestBook lends a copy of a book to a patron, returning the loan when lent
+func checkoutTestBook(t *testing.T, service *RestService, params map[string]string, patronID string, expectedStatus int) lib.Loan {
+	t.Helper()
+	return decodeTestResponse[lib.Loan](t, http.MethodPut, bookLoansPath, service.checkoutBook, checkoutRequest{PatronID: patronID}, expectedStatus, params)
+}
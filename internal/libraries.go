@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"dockerrestapi/db"
+	"dockerrestapi/lib"
+	"errors"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+const (
+	LibrariesPath    = "/api/libraries"
+	libraryPath      = LibrariesPath + "/{" + paramLibrary + "}"
+	libraryBooksPath = libraryPath + "/books"
+	libraryBookPath  = libraryBooksPath + "/{" + paramID + "}"
+	paramLibrary     = "library"
+)
+
+// getLibraries lists every library of the deployment
+func (r *RestService) getLibraries(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Libraries request")
+	libraries, err := r.db.GetAllLibraries()
+	if err != nil {
+		stdError(err.Error())
+		r.restResponse(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	r.restResponse(writer, http.StatusOK, libraries)
+}
+
+// createLibrary creates a new empty library given the name in the path.
+// eg : api/libraries/{library}
+func (r *RestService) createLibrary(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received create Library request")
+	err := r.db.CreateLibrary(mux.Vars(request)[paramLibrary])
+	if err != nil {
+		if errors.Is(err, lib.IncorrectLibraryName) || errors.Is(err, lib.LibraryAlreadyExists) {
+			r.restResponse(writer, http.StatusBadRequest, err.Error())
+			return
+		}
+		stdError(err.Error())
+		r.restResponse(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	r.restResponse(writer, http.StatusOK, nil)
+}
+
+// dropLibrary drops a library and every book stored in it given the name in the path.
+// eg : api/libraries/{library}
+func (r *RestService) dropLibrary(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received drop Library request")
+	name := mux.Vars(request)[paramLibrary]
+	err := r.db.DropLibrary(name)
+	if err != nil {
+		if errors.Is(err, lib.CannotDropDefaultLibrary) {
+			r.restResponse(writer, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, lib.NoMatchingLibrary) {
+			r.restResponse(writer, http.StatusNotFound, err.Error())
+			return
+		}
+		stdError(err.Error())
+		r.restResponse(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	r.similarity.drop(name)
+	if r.blobs != nil {
+		err = r.blobs.DropNamespace(name)
+		if err != nil {
+			stdError("cant drop attachments of library " + name + " " + err.Error())
+		}
+	}
+	if r.webhooks != nil {
+		err = r.webhooks.DropNamespace(name)
+		if err != nil {
+			stdError("cant drop webhooks of library " + name + " " + err.Error())
+		}
+	}
+	if r.lending != nil {
+		err = r.lending.DropNamespace(name)
+		if err != nil {
+			stdError("cant drop lending of library " + name + " " + err.Error())
+		}
+	}
+	r.restResponse(writer, http.StatusOK, nil)
+}
+
+// libraryFromRequest returns the db of the library named in the path, or the default library for the original /api/library routes
+func (r *RestService) libraryFromRequest(request *http.Request) (db.RestDbInterface, error) {
+	return r.library(r.libraryNameFromRequest(request))
+}
+
+// library returns the db of the named library
+func (r *RestService) library(name string) (db.RestDbInterface, error) {
+	if name == lib.DefaultLibraryName {
+		return r.db, nil
+	}
+	return r.db.Library(name)
+}
+
+// libraryNameFromRequest returns the library named in the path, defaulting to the default library
+func (r *RestService) libraryNameFromRequest(request *http.Request) string {
+	if name, exists := mux.Vars(request)[paramLibrary]; exists {
+		return name
+	}
+	return lib.DefaultLibraryName
+}
+
+// restLibraryError responds to a failure resolving the library of a request
+func (r *RestService) restLibraryError(writer http.ResponseWriter, err error) {
+	if errors.Is(err, lib.NoMatchingLibrary) {
+		r.restResponse(writer, http.StatusNotFound, err.Error())
+		return
+	}
+	stdError(err.Error())
+	r.restResponse(writer, http.StatusInternalServerError, err.Error())
+}
+
+// libraryBookLocation returns the path of a book within a library
+func libraryBookLocation(library, id string) string {
+	return LibrariesPath + "/" + library + "/books/" + id
+}
@@ -0,0 +1,178 @@
+package internal
+
+import (
+	"dockerrestapi/db"
+	"dockerrestapi/lib"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestLibraries(t *testing.T) {
+	libraryApi, err := createMockApi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenant := map[string]string{paramLibrary: "tenant1"}
+
+	// create library, fail on creating it again or with a bad name
+	_, err = testResponse(http.MethodPut, libraryPath, libraryApi.createLibrary, nil, http.StatusOK, tenant)
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = testResponse(http.MethodPut, libraryPath, libraryApi.createLibrary, nil, http.StatusBadRequest, tenant)
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = testResponse(http.MethodPut, libraryPath, libraryApi.createLibrary, nil, http.StatusBadRequest, map[string]string{paramLibrary: "bad name!"})
+	if err != nil {
+		t.Error(err)
+	}
+
+	response, err := testResponse(http.MethodGet, LibrariesPath, libraryApi.getLibraries, nil, http.StatusOK, nil)
+	if err != nil {
+		t.Error(err)
+	}
+	var libraries []lib.Library
+	err = json.Unmarshal([]byte(response), &libraries)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(libraries) != 2 {
+		t.Error("expecting", 2, "libraries got", len(libraries))
+	}
+
+	// unknown libraries are not found
+	_, err = testResponse(http.MethodGet, libraryBooksPath, libraryApi.getBooks, nil, http.StatusNotFound, map[string]string{paramLibrary: "unknown"})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// TestReservedLibraryNames checks that no library can share the collections kept next to another library
+func TestReservedLibraryNames(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, handler db.RestDbInterface, clock *lib.ManualClock) {
+		author := &lib.Author{Name: "Ursula K. Le Guin"}
+		err := handler.CreateNewAuthor(author)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// authors would be the collection of the default authors, a_reviews that of the reviews of library a
+		for _, name := range []string{"authors", "Reviews", "a_reviews"} {
+			if err = handler.CreateLibrary(name); !errors.Is(err, lib.IncorrectLibraryName) {
+				t.Error("expecting", lib.IncorrectLibraryName, "creating", name, "got", err)
+			}
+		}
+		if err = handler.DropLibrary("authors"); !errors.Is(err, lib.NoMatchingLibrary) {
+			t.Error("expecting", lib.NoMatchingLibrary, "got", err)
+		}
+		authors, err := handler.GetAllAuthors()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(authors) != 1 || authors[0].ID != author.ID {
+			t.Error("expecting the default authors kept got", authors)
+		}
+	})
+}
+
+func TestLibraryBooks(t *testing.T) {
+	libraryApi, err := createMockApi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenant := map[string]string{paramLibrary: "tenant1"}
+	_, err = testResponse(http.MethodPut, libraryPath, libraryApi.createLibrary, nil, http.StatusOK, tenant)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same book can be stored in two libraries
+	marshalDefaultBook2, err := json.Marshal(defaultBook2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = testResponse(http.MethodPut, libraryBooksPath, libraryApi.createBook, marshalDefaultBook2, http.StatusOK, tenant)
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = testResponse(http.MethodPut, createBookPath, libraryApi.createBook, marshalDefaultBook2, http.StatusOK, nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// list and get the tenant copy by id
+	response, err := testResponse(http.MethodGet, libraryBooksPath, libraryApi.getBooks, nil, http.StatusOK, tenant)
+	if err != nil {
+		t.Error(err)
+	}
+	var identifiers []lib.BookIdentifier
+	err = json.Unmarshal([]byte(response), &identifiers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(identifiers) != 1 || identifiers[0].ID == "" {
+		t.Fatal("expecting one book with an id got", identifiers)
+	}
+	bookParams := map[string]string{paramLibrary: "tenant1", paramID: identifiers[0].ID}
+
+	response, err = testResponse(http.MethodGet, libraryBookPath, libraryApi.getBook, nil, http.StatusOK, bookParams)
+	if err != nil {
+		t.Error(err)
+	}
+	book := lib.Book{}
+	err = json.Unmarshal([]byte(response), &book)
+	if err != nil {
+		t.Error(err)
+	}
+	if !isEqualBook(book, defaultBook2) {
+		t.Error("expecting", defaultBook2, "got", book)
+	}
+
+	// update by id
+	updated := defaultBook2
+	updated.Contents = "A tame read"
+	marshalUpdated, err := json.Marshal(updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = testResponse(http.MethodPut, libraryBookPath, libraryApi.updateBook, marshalUpdated, http.StatusOK, bookParams)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// the default library copy is untouched
+	defaultCopy, err := libraryApi.db.GetOneBook(&lib.BookIdentifier{Name: defaultBook2.Name, Author: defaultBook2.Author})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if defaultCopy.Contents != defaultBook2.Contents {
+		t.Error("expecting", defaultBook2.Contents, "got", defaultCopy.Contents)
+	}
+
+	// delete by id, then again fails
+	_, err = testResponse(http.MethodDelete, libraryBookPath, libraryApi.deleteBook, nil, http.StatusOK, bookParams)
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = testResponse(http.MethodDelete, libraryBookPath, libraryApi.deleteBook, nil, http.StatusNotFound, bookParams)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// drop the library, the default library cannot be dropped
+	_, err = testResponse(http.MethodDelete, libraryPath, libraryApi.dropLibrary, nil, http.StatusOK, tenant)
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = testResponse(http.MethodGet, libraryBooksPath, libraryApi.getBooks, nil, http.StatusNotFound, tenant)
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = testResponse(http.MethodDelete, libraryPath, libraryApi.dropLibrary, nil, http.StatusBadRequest, map[string]string{paramLibrary: lib.DefaultLibraryName})
+	if err != nil {
+		t.Error(err)
+	}
+}
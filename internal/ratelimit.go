@@ -0,0 +1,172 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"dockerrestapi/db"
+	"dockerrestapi/lib"
+	"encoding/hex"
+	"github.com/gorilla/mux"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	apiKeyHeader       = "X-Api-Key"
+	forwardedForHeader = "X-Forwarded-For"
+)
+
+// listRoutes are the routes whose GET reads a whole library, limited as lib.RouteClassList
+var listRoutes = map[string]bool{
+	getBooksPath:                true,
+	libraryBooksPath:            true,
+	libraryBooksSuggestionsPath: true,
+	libraryBookSimilarPath:      true,
+	libraryFacetsPath:           true,
+	libraryStatsPath:            true,
+}
+
+// WithRateLimits limits the requests of each client per route class, classes without a limit being left unlimited
+func WithRateLimits(limits map[string]lib.RateLimit) ServiceOption {
+	return func(service *RestService) {
+		service.limiter = lib.NewRateLimiter(limits)
+	}
+}
+
+// WithDailyQuota limits each client to quota requests a UTC day, counted in the given store
+func WithDailyQuota(quotas db.QuotaStoreInterface, quota int64) ServiceOption {
+	return func(service *RestService) {
+		service.quotas = quotas
+		service.dailyQuota = quota
+	}
+}
+
+// WithApiKeys limits the clients sending one of the keys in the X-Api-Key header by key rather than by address,
+// other keys being ignored so that made up keys cannot get around the limits
+func WithApiKeys(keys []string) ServiceOption {
+	return func(service *RestService) {
+		service.apiKeys = map[string]bool{}
+		for _, key := range keys {
+			if key != "" {
+				service.apiKeys[key] = true
+			}
+		}
+	}
+}
+
+// WithTrustedProxy takes the address of clients from the last X-Forwarded-For entry, as added by a reverse proxy in front
+// of the api. Only set it behind such a proxy, as clients could otherwise choose their address.
+func WithTrustedProxy() ServiceOption {
+	return func(service *RestService) {
+		service.trustProxy = true
+	}
+}
+
+// limitRequests rate limits the requests of each client per route class, then counts them against its daily quota,
+// answering 429 with Retry-After once either is exhausted. The RateLimit-* headers tell the client about the closest limit.
+func (r *RestService) limitRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		now := r.clock.Now()
+		client := r.rateLimitClient(request)
+		decision := lib.RateDecision{Allowed: true}
+		if r.limiter != nil {
+			decision = r.limiter.Allow(routeClass(request), client, now)
+		}
+		if !decision.Allowed {
+			r.restTooManyRequests(writer, decision, lib.RateLimitExceeded)
+			return
+		}
+		if r.quotas != nil {
+			quota, err := r.countQuota(client, now)
+			if err != nil {
+				stdError("cant count quota " + err.Error()) // requests are let through while quotas cannot be counted
+			} else if !quota.Allowed {
+				r.restTooManyRequests(writer, quota, lib.QuotaExceeded)
+				return
+			} else if decision.Limit == 0 || quota.Remaining < decision.Remaining {
+				decision = quota
+			}
+		}
+		setRateLimitHeaders(writer.Header(), decision)
+		next.ServeHTTP(writer, request)
+	})
+}
+
+// countQuota counts a request of a client against its daily quota
+func (r *RestService) countQuota(client string, now time.Time) (lib.RateDecision, error) {
+	reset := lib.QuotaReset(now)
+	requests, err := r.quotas.IncrementQuota(client, lib.QuotaDay(now), reset)
+	if err != nil {
+		return lib.RateDecision{}, err
+	}
+	decision := lib.RateDecision{
+		Allowed:   requests <= r.dailyQuota,
+		Limit:     int(r.dailyQuota),
+		Remaining: int(max(r.dailyQuota-requests, 0)),
+		Reset:     reset.Sub(now),
+	}
+	if !decision.Allowed {
+		decision.RetryAfter = decision.Reset
+	}
+	return decision, nil
+}
+
+// rateLimitClient returns the key a request is limited by, the hash of its api key when known otherwise its address
+func (r *RestService) rateLimitClient(request *http.Request) string {
+	if key := request.Header.Get(apiKeyHeader); r.apiKeys[key] {
+		hash := sha256.Sum256([]byte(key))
+		return "key:" + hex.EncodeToString(hash[:])
+	}
+	address := request.RemoteAddr
+	if forwarded := request.Header.Get(forwardedForHeader); r.trustProxy && forwarded != "" {
+		address = strings.TrimSpace(forwarded[strings.LastIndex(forwarded, ",")+1:])
+	}
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	return "ip:" + address
+}
+
+// routeClass returns the class of the route of a request: GraphQL queries and reads of a whole library are lists,
+// other reads are reads and everything else writes
+func routeClass(request *http.Request) string {
+	template := ""
+	if route := mux.CurrentRoute(request); route != nil {
+		template, _ = route.GetPathTemplate()
+	}
+	switch {
+	case template == graphqlPath:
+		return lib.RouteClassList
+	case request.Method != http.MethodGet && request.Method != http.MethodHead:
+		return lib.RouteClassWrite
+	case listRoutes[template]:
+		return lib.RouteClassList
+	default:
+		return lib.RouteClassRead
+	}
+}
+
+// restTooManyRequests responds 429 to a request over a limit, telling the client when to retry
+func (r *RestService) restTooManyRequests(writer http.ResponseWriter, decision lib.RateDecision, err error) {
+	setRateLimitHeaders(writer.Header(), decision)
+	writer.Header().Set("Retry-After", strconv.FormatInt(max(ceilSeconds(decision.RetryAfter), 1), 10))
+	r.restResponse(writer, http.StatusTooManyRequests, err.Error())
+}
+
+// setRateLimitHeaders sets the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers of a limited request
+func setRateLimitHeaders(header http.Header, decision lib.RateDecision) {
+	if decision.Limit == 0 {
+		return
+	}
+	header.Set("RateLimit-Limit", strconv.Itoa(decision.Limit))
+	header.Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+	header.Set("RateLimit-Reset", strconv.FormatInt(ceilSeconds(decision.Reset), 10))
+}
+
+// ceilSeconds returns a duration in whole seconds, rounded up
+func ceilSeconds(duration time.Duration) int64 {
+	return int64(math.Ceil(duration.Seconds()))
+}
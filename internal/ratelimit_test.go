@@ -0,0 +1,124 @@
+package internal
+
+import (
+	"dockerrestapi/db"
+	"dockerrestapi/lib"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	clock := lib.NewManualClock(time.Date(2024, time.January, 2, 12, 0, 0, 0, time.UTC))
+	service := createRateLimitApi(t, clock, WithRateLimits(map[string]lib.RateLimit{
+		lib.RouteClassList: {Rate: 0.5, Burst: 2},
+		lib.RouteClassRead: {Rate: 10, Burst: 20},
+	}), WithApiKeys([]string{"secret"}))
+
+	for remaining := 1; remaining >= 0; remaining-- {
+		response := rateLimitRequest(service, http.MethodGet, "/api/library/getlist", "10.0.0.1:1234", nil)
+		if response.Code != http.StatusOK || response.Header().Get("RateLimit-Limit") != "2" || response.Header().Get("RateLimit-Remaining") != strconv.Itoa(remaining) {
+			t.Fatal("expecting the burst allowed got", response.Code, response.Header())
+		}
+	}
+	response := rateLimitRequest(service, http.MethodGet, "/api/library/getlist", "10.0.0.1:5678", nil)
+	if response.Code != http.StatusTooManyRequests || response.Header().Get("Retry-After") != "2" || response.Header().Get("RateLimit-Reset") != "4" {
+		t.Error("expecting 429 retrying in two seconds got", response.Code, response.Header())
+	}
+
+	// reads, other addresses and configured api keys are limited apart, unknown keys by address
+	if response = rateLimitRequest(service, http.MethodGet, "/api/libraries", "10.0.0.1:1234", nil); response.Code != http.StatusOK || response.Header().Get("RateLimit-Limit") != "20" {
+		t.Error("expecting reads limited apart got", response.Code, response.Header())
+	}
+	if response = rateLimitRequest(service, http.MethodGet, "/api/library/getlist", "10.0.0.2:1234", nil); response.Code != http.StatusOK {
+		t.Error("expecting addresses limited apart got", response.Code)
+	}
+	if response = rateLimitRequest(service, http.MethodGet, "/api/library/getlist", "10.0.0.1:1234", map[string]string{apiKeyHeader: "secret"}); response.Code != http.StatusOK {
+		t.Error("expecting api keys limited apart got", response.Code)
+	}
+	if response = rateLimitRequest(service, http.MethodGet, "/api/library/getlist", "10.0.0.1:1234", map[string]string{apiKeyHeader: "made up"}); response.Code != http.StatusTooManyRequests {
+		t.Error("expecting unknown api keys limited by address got", response.Code)
+	}
+	// writes are not limited
+	if response = rateLimitRequest(service, http.MethodDelete, "/api/libraries/unknown", "10.0.0.1:1234", nil); response.Header().Get("RateLimit-Limit") != "" {
+		t.Error("expecting writes not limited got", response.Header())
+	}
+
+	clock.Advance(2 * time.Second)
+	if response = rateLimitRequest(service, http.MethodGet, "/api/library/getlist", "10.0.0.1:1234", nil); response.Code != http.StatusOK {
+		t.Error("expecting a request allowed again got", response.Code)
+	}
+}
+
+func TestRateLimitTrustedProxy(t *testing.T) {
+	clock := lib.NewManualClock(time.Date(2024, time.January, 2, 12, 0, 0, 0, time.UTC))
+	service := createRateLimitApi(t, clock, WithRateLimits(map[string]lib.RateLimit{lib.RouteClassList: {Rate: 1, Burst: 1}}), WithTrustedProxy())
+
+	forwarded := map[string]string{forwardedForHeader: "1.2.3.4, 10.0.0.1"}
+	if response := rateLimitRequest(service, http.MethodGet, "/api/library/getlist", "192.168.0.1:80", forwarded); response.Code != http.StatusOK {
+		t.Fatal("expecting the first request allowed got", response.Code)
+	}
+	if response := rateLimitRequest(service, http.MethodGet, "/api/library/getlist", "192.168.0.1:80", nil); response.Code != http.StatusOK {
+		t.Error("expecting the proxy limited apart from the forwarded client got", response.Code)
+	}
+	forwarded = map[string]string{forwardedForHeader: "5.6.7.8, 10.0.0.1"}
+	if response := rateLimitRequest(service, http.MethodGet, "/api/library/getlist", "192.168.0.1:80", forwarded); response.Code != http.StatusTooManyRequests {
+		t.Error("expecting the last forwarded address limited got", response.Code)
+	}
+}
+
+func TestDailyQuota(t *testing.T) {
+	clock := lib.NewManualClock(time.Date(2024, time.January, 2, 23, 0, 0, 0, time.UTC))
+	quotas, err := db.CreateMockQuotaStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := createRateLimitApi(t, clock, WithDailyQuota(quotas, 2))
+
+	for remaining := 1; remaining >= 0; remaining-- {
+		response := rateLimitRequest(service, http.MethodGet, "/api/libraries", "10.0.0.1:1234", nil)
+		if response.Code != http.StatusOK || response.Header().Get("RateLimit-Remaining") != strconv.Itoa(remaining) || response.Header().Get("RateLimit-Reset") != "3600" {
+			t.Fatal("expecting the quota counted got", response.Code, response.Header())
+		}
+	}
+	response := rateLimitRequest(service, http.MethodGet, "/api/libraries", "10.0.0.1:1234", nil)
+	if response.Code != http.StatusTooManyRequests || response.Header().Get("Retry-After") != "3600" {
+		t.Error("expecting 429 until midnight got", response.Code, response.Header())
+	}
+	if response = rateLimitRequest(service, http.MethodGet, "/api/libraries", "10.0.0.2:1234", nil); response.Code != http.StatusOK {
+		t.Error("expecting quotas per client got", response.Code)
+	}
+
+	clock.Advance(time.Hour)
+	if response = rateLimitRequest(service, http.MethodGet, "/api/libraries", "10.0.0.1:1234", nil); response.Code != http.StatusOK || response.Header().Get("RateLimit-Remaining") != "1" {
+		t.Error("expecting the quota reset at midnight got", response.Code, response.Header())
+	}
+}
+
+// createRateLimitApi creates a rest api on a mock db with rate limiting options
+func createRateLimitApi(t *testing.T, clock lib.Clock, options ...ServiceOption) *RestService {
+	t.Helper()
+	mockConn, err := db.CreateMockDBHandlerWithClock(clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := CreateRestApiService(mockConn, "8081", append([]ServiceOption{WithClock(clock)}, options...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service
+}
+
+// rateLimitRequest sends a request from an address with optional headers through the router
+func rateLimitRequest(service *RestService, method, url, remoteAddr string, headers map[string]string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, url, nil)
+	request.RemoteAddr = remoteAddr
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	recorder := httptest.NewRecorder()
+	service.router.ServeHTTP(recorder, request)
+	return recorder
+}
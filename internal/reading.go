@@ -0,0 +1,368 @@
+package internal
+
+import (
+	"dockerrestapi/db"
+	"dockerrestapi/lib"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"net/http"
+	"time"
+)
+
+const (
+	readingProgressPath = libraryBookPath + "/progress"
+	readingPath         = libraryBookPath + "/reading"
+	bookmarksPath       = libraryBookPath + "/bookmarks"
+	bookmarkPath        = bookmarksPath + "/{" + paramBookmark + "}"
+	paramBookmark       = "bookmark"
+)
+
+// getReadingProgress retrieves how far the user read the book given the id in the path.
+// eg : api/libraries/{library}/books/{id}/progress
+func (r *RestService) getReadingProgress(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Reading Progress request")
+	library, bookID, userID, err := r.readerFromRequest(request)
+	if err != nil {
+		r.restReadingError(writer, err)
+		return
+	}
+
+	progress, err := library.GetReadingProgress(bookID, userID)
+	if err != nil {
+		r.restReadingError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, progress)
+}
+
+// saveReadingProgress stores how far the user read the book, as a character offset or a paragraph index.
+// A progress older than the stored one, as sent by a device coming back online, leaves the stored one.
+// eg : api/libraries/{library}/books/{id}/progress with {"offset": 1200} or {"paragraph": 12, "updatedAt": "..."}
+func (r *RestService) saveReadingProgress(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received save Reading Progress request")
+	library, bookID, userID, err := r.readerFromRequest(request)
+	if err != nil {
+		r.restReadingError(writer, err)
+		return
+	}
+	progress := &lib.ReadingProgress{}
+	err = json.NewDecoder(request.Body).Decode(progress)
+	if err != nil {
+		r.restResponse(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	progress.BookID = bookID
+	progress.UserID = userID
+	progress.UpdatedAt = r.changedAt(progress.UpdatedAt)
+
+	err = library.SaveReadingProgress(progress)
+	if err != nil {
+		r.restReadingError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, progress)
+}
+
+// getBookmarks lists the bookmarks of the user on the book in reading order, with since= the ones changed
+// after a time, deleted ones included.
+// eg : api/libraries/{library}/books/{id}/bookmarks?since=2024-01-01T00:00:00Z
+func (r *RestService) getBookmarks(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Bookmarks request")
+	library, bookID, userID, err := r.readerFromRequest(request)
+	if err != nil {
+		r.restReadingError(writer, err)
+		return
+	}
+	since, err := sinceFromQuery(request)
+	if err != nil {
+		r.restReadingError(writer, err)
+		return
+	}
+
+	bookmarks, err := library.GetAllBookmarks(bookID, userID, since)
+	if err != nil {
+		r.restReadingError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, bookmarks)
+}
+
+// createBookmark stores a bookmark of the user highlighting the characters from start to end, a bookmark being
+// created once per client id.
+// eg : api/libraries/{library}/books/{id}/bookmarks with {"start": 120, "end": 180, "note": "...", "clientId": "..."}
+func (r *RestService) createBookmark(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received create Bookmark request")
+	library, bookID, userID, err := r.readerFromRequest(request)
+	if err != nil {
+		r.restReadingError(writer, err)
+		return
+	}
+	bookmark := &lib.Bookmark{}
+	err = json.NewDecoder(request.Body).Decode(bookmark)
+	if err != nil {
+		r.restResponse(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	bookmark.BookID = bookID
+	bookmark.UserID = userID
+	bookmark.UpdatedAt = r.changedAt(bookmark.UpdatedAt)
+
+	err = library.CreateNewBookmark(bookmark)
+	if err != nil {
+		r.restReadingError(writer, err)
+		return
+	}
+	writer.Header().Set("Location", libraryBookLocation(r.libraryNameFromRequest(request), bookID)+"/bookmarks/"+bookmark.ID)
+	r.restResponse(writer, http.StatusOK, bookmark)
+}
+
+// getBookmark retrieves a bookmark of the user given the book id and bookmark id in the path.
+// eg : api/libraries/{library}/books/{id}/bookmarks/{bookmark}
+func (r *RestService) getBookmark(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Bookmark request")
+	_, bookmark, err := r.bookmarkFromRequest(request)
+	if err != nil {
+		r.restReadingError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, bookmark)
+}
+
+// updateBookmark changes the range and note of a bookmark of the user.
+// eg : api/libraries/{library}/books/{id}/bookmarks/{bookmark} with {"start": 120, "end": 200, "note": "..."}
+func (r *RestService) updateBookmark(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received update Bookmark request")
+	library, existing, err := r.bookmarkFromRequest(request)
+	if err != nil {
+		r.restReadingError(writer, err)
+		return
+	}
+	bookmark := &lib.Bookmark{}
+	err = json.NewDecoder(request.Body).Decode(bookmark)
+	if err != nil {
+		r.restResponse(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	bookmark.ID = existing.ID
+	bookmark.BookID = existing.BookID
+	bookmark.UserID = existing.UserID
+	bookmark.UpdatedAt = r.changedAt(bookmark.UpdatedAt)
+
+	err = library.UpdateExistingBookmark(bookmark)
+	if err != nil {
+		r.restReadingError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, bookmark)
+}
+
+// deleteBookmark deletes a bookmark of the user, leaving a tombstone for the other devices of the user to sync.
+// eg : api/libraries/{library}/books/{id}/bookmarks/{bookmark}
+func (r *RestService) deleteBookmark(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received delete Bookmark request")
+	library, bookmark, err := r.bookmarkFromRequest(request)
+	if err != nil {
+		r.restReadingError(writer, err)
+		return
+	}
+
+	_, err = library.DeleteBookmark(bookmark.BookID, bookmark.ID, r.clock.Now())
+	if err != nil {
+		r.restReadingError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, "bookmark deleted")
+}
+
+// getReadingState retrieves the reading progress and bookmarks of the user on the book, with since= only the
+// bookmarks changed after a time.
+// eg : api/libraries/{library}/books/{id}/reading?since=2024-01-01T00:00:00Z
+func (r *RestService) getReadingState(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Reading State request")
+	library, bookID, userID, err := r.readerFromRequest(request)
+	if err != nil {
+		r.restReadingError(writer, err)
+		return
+	}
+	since, err := sinceFromQuery(request)
+	if err != nil {
+		r.restReadingError(writer, err)
+		return
+	}
+	state := &lib.ReadingState{SyncedAt: r.clock.Now()}
+
+	err = r.readReadingState(library, bookID, userID, since, state)
+	if err != nil {
+		r.restReadingError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, state)
+}
+
+// syncReadingState applies the progress and bookmark changes a device made since it last synced, then responds
+// with the state changed by other devices meanwhile. Bookmarks without id are created, ones with a deletedAt
+// deleted and the others updated, the most recent change winning when devices change the same bookmark.
+// eg : api/libraries/{library}/books/{id}/reading with {"since": "...", "progress": {...}, "bookmarks": [{...}]}
+func (r *RestService) syncReadingState(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received sync Reading State request")
+	library, bookID, userID, err := r.readerFromRequest(request)
+	if err != nil {
+		r.restReadingError(writer, err)
+		return
+	}
+	changes := &lib.ReadingState{}
+	err = json.NewDecoder(request.Body).Decode(changes)
+	if err != nil {
+		r.restResponse(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	state := &lib.ReadingState{SyncedAt: r.clock.Now()}
+
+	if changes.Progress != nil {
+		changes.Progress.BookID = bookID
+		changes.Progress.UserID = userID
+		changes.Progress.UpdatedAt = r.changedAt(changes.Progress.UpdatedAt)
+		err = library.SaveReadingProgress(changes.Progress)
+		if err != nil {
+			r.restReadingError(writer, err)
+			return
+		}
+	}
+	var touched []lib.Bookmark
+	for _, bookmark := range changes.Bookmarks {
+		bookmark.BookID = bookID
+		bookmark.UserID = userID
+		bookmark.UpdatedAt = r.changedAt(bookmark.UpdatedAt)
+		switch {
+		case bookmark.ID == "":
+			err = library.CreateNewBookmark(&bookmark)
+		case bookmark.DeletedAt != nil:
+			var deleted *lib.Bookmark
+			deleted, err = r.ownBookmark(library, bookID, bookmark.ID, userID)
+			if err == nil {
+				deleted, err = library.DeleteBookmark(bookID, deleted.ID, r.changedAt(*bookmark.DeletedAt))
+			}
+			if err == nil {
+				bookmark = *deleted
+			}
+		default:
+			_, err = r.ownBookmark(library, bookID, bookmark.ID, userID)
+			if err == nil {
+				err = library.UpdateExistingBookmark(&bookmark)
+			}
+		}
+		if errors.Is(err, lib.NoMatchingBookmark) {
+			continue // purged with its book or never synced, nothing to merge with
+		}
+		if err != nil {
+			r.restReadingError(writer, err)
+			return
+		}
+		touched = append(touched, bookmark)
+	}
+
+	err = r.readReadingState(library, bookID, userID, changes.Since, state)
+	if err != nil {
+		r.restReadingError(writer, err)
+		return
+	}
+	listed := map[string]bool{}
+	for _, bookmark := range state.Bookmarks {
+		listed[bookmark.ID] = true
+	}
+	for _, bookmark := range touched {
+		if !listed[bookmark.ID] {
+			listed[bookmark.ID] = true
+			state.Bookmarks = append(state.Bookmarks, bookmark)
+		}
+	}
+	r.restResponse(writer, http.StatusOK, state)
+}
+
+// readReadingState reads the progress of the user into the state, when started, and the bookmarks changed after since
+func (r *RestService) readReadingState(library db.RestDbInterface, bookID, userID string, since *time.Time, state *lib.ReadingState) error {
+	progress, err := library.GetReadingProgress(bookID, userID)
+	if err != nil && !errors.Is(err, lib.NoMatchingProgress) {
+		return err
+	}
+	state.Progress = progress
+	state.Bookmarks, err = library.GetAllBookmarks(bookID, userID, since)
+	return err
+}
+
+// readerFromRequest returns the library, the book id in the path and the user reading it
+func (r *RestService) readerFromRequest(request *http.Request) (db.RestDbInterface, string, string, error) {
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		return nil, "", "", err
+	}
+	userID := request.Header.Get(userIDHeader)
+	if userID == "" {
+		return nil, "", "", lib.IncompleteReading
+	}
+	return library, mux.Vars(request)[paramID], userID, nil
+}
+
+// bookmarkFromRequest returns the library and the bookmark given in the path, bookmarks of other users are not found
+func (r *RestService) bookmarkFromRequest(request *http.Request) (db.RestDbInterface, *lib.Bookmark, error) {
+	library, bookID, userID, err := r.readerFromRequest(request)
+	if err != nil {
+		return nil, nil, err
+	}
+	bookmark, err := r.ownBookmark(library, bookID, mux.Vars(request)[paramBookmark], userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return library, bookmark, nil
+}
+
+// ownBookmark retrieves a bookmark of a book when owned by the user
+func (r *RestService) ownBookmark(library db.RestDbInterface, bookID, bookmarkID, userID string) (*lib.Bookmark, error) {
+	bookmark, err := library.GetOneBookmark(bookID, bookmarkID)
+	if err != nil {
+		return nil, err
+	}
+	if bookmark.UserID != userID {
+		return nil, lib.NoMatchingBookmark
+	}
+	return bookmark, nil
+}
+
+// changedAt returns when a device says it made a change, now when it does not say or says a time to come
+func (r *RestService) changedAt(at time.Time) time.Time {
+	now := r.clock.Now()
+	if at.IsZero() || at.After(now) {
+		return now
+	}
+	return at
+}
+
+// sinceFromQuery parses the since= time of a request, nil when not given
+func sinceFromQuery(request *http.Request) (*time.Time, error) {
+	value := request.URL.Query().Get(paramSince)
+	if value == "" {
+		return nil, nil
+	}
+	since, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return nil, lib.IncorrectParameters
+	}
+	return &since, nil
+}
+
+// restReadingError responds with the status matching a reading progress or bookmark error
+func (r *RestService) restReadingError(writer http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, lib.NoMatchingLibrary):
+		r.restLibraryError(writer, err)
+	case errors.Is(err, lib.NoMatchingBook), errors.Is(err, lib.NoMatchingProgress), errors.Is(err, lib.NoMatchingBookmark):
+		r.restResponse(writer, http.StatusNotFound, err.Error())
+	case errors.Is(err, lib.IncompleteReading), errors.Is(err, lib.IncorrectPosition),
+		errors.Is(err, lib.IncorrectBookmarkRange), errors.Is(err, lib.IncorrectParameters):
+		r.restResponse(writer, http.StatusBadRequest, err.Error())
+	default:
+		stdError(err.Error())
+		r.restResponse(writer, http.StatusInternalServerError, err.Error())
+	}
+}
@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"dockerrestapi/lib"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestReading(t *testing.T) {
+	clock := lib.NewManualClock(time.Date(2024, time.March, 1, 9, 0, 0, 0, time.UTC))
+	readingApi := createReviewsApi(t, clock)
+	book := lib.Book{Name: "the hobbit", Author: "Tolkien", Contents: "In a hole in the ground there lived a hobbit.\n\nNot a nasty, dirty, wet hole."}
+	err := readingApi.db.CreateNewBook(&book)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := map[string]string{paramLibrary: lib.DefaultLibraryName, paramID: book.ID}
+
+	// progress belongs to a user and is located by offset or paragraph
+	decodeTestResponse[lib.ReadingProgress](t, http.MethodPut, readingProgressPath, asUser("", readingApi.saveReadingProgress), lib.ReadingProgress{Offset: 3}, http.StatusBadRequest, params)
+	decodeTestResponse[lib.ReadingProgress](t, http.MethodGet, readingProgressPath, asUser("bilbo", readingApi.getReadingProgress), nil, http.StatusNotFound, params)
+	progress := decodeTestResponse[lib.ReadingProgress](t, http.MethodPut, readingProgressPath, asUser("bilbo", readingApi.saveReadingProgress), lib.ReadingProgress{Paragraph: 1}, http.StatusOK, params)
+	if progress.Offset != 47 || progress.Paragraph != 1 || progress.UserID != "bilbo" {
+		t.Error("expecting the start of the second paragraph got", progress)
+	}
+
+	// a device coming back online with an older progress leaves the stored one
+	clock.Advance(time.Minute)
+	stale := lib.ReadingProgress{Offset: 5, UpdatedAt: clock.Now().Add(-time.Hour)}
+	progress = decodeTestResponse[lib.ReadingProgress](t, http.MethodPut, readingProgressPath, asUser("bilbo", readingApi.saveReadingProgress), stale, http.StatusOK, params)
+	if progress.Offset != 47 {
+		t.Error("expecting the more recent progress kept got", progress)
+	}
+
+	// bookmarks are created once per client id and only seen by their user
+	request := lib.Bookmark{Start: 53, End: 58, Note: "rude", ClientID: "phone-1"}
+	nasty := decodeTestResponse[lib.Bookmark](t, http.MethodPut, bookmarksPath, asUser("bilbo", readingApi.createBookmark), request, http.StatusOK, params)
+	if nasty.Quote != "nasty" || nasty.ID == "" {
+		t.Error("expecting nasty highlighted got", nasty)
+	}
+	again := decodeTestResponse[lib.Bookmark](t, http.MethodPut, bookmarksPath, asUser("bilbo", readingApi.createBookmark), request, http.StatusOK, params)
+	if again.ID != nasty.ID {
+		t.Error("expecting the same bookmark got", again)
+	}
+	decodeTestResponse[lib.Bookmark](t, http.MethodPut, bookmarksPath, asUser("bilbo", readingApi.createBookmark), lib.Bookmark{Start: 10, End: 5}, http.StatusBadRequest, params)
+	bookmarkParams := map[string]string{paramLibrary: lib.DefaultLibraryName, paramID: book.ID, paramBookmark: nasty.ID}
+	decodeTestResponse[lib.Bookmark](t, http.MethodGet, bookmarkPath, asUser("frodo", readingApi.getBookmark), nil, http.StatusNotFound, bookmarkParams)
+	if bookmarks := getTestBookmarks(t, readingApi, params, "frodo"); len(bookmarks) != 0 {
+		t.Error("expecting no bookmark of frodo got", bookmarks)
+	}
+
+	// bookmarks follow their text when the contents change
+	clock.Advance(time.Minute)
+	editedAt := clock.Now()
+	book.Contents = "Chapter 1\n\n" + book.Contents
+	err = readingApi.db.UpdateExistingBook(&book)
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved := decodeTestResponse[lib.Bookmark](t, http.MethodGet, bookmarkPath, asUser("bilbo", readingApi.getBookmark), nil, http.StatusOK, bookmarkParams)
+	if moved.Start != 64 || moved.End != 69 || moved.Quote != "nasty" || moved.Orphaned {
+		t.Error("expecting the bookmark moved by the chapter title got", moved)
+	}
+
+	// a device syncs its offline changes and gets the ones of other devices
+	clock.Advance(time.Minute)
+	deletedAt := clock.Now().Add(-30 * time.Second)
+	changes := lib.ReadingState{
+		Since:    &editedAt,
+		Progress: &lib.ReadingProgress{Offset: 70},
+		Bookmarks: []lib.Bookmark{
+			{ID: nasty.ID, DeletedAt: &deletedAt, UpdatedAt: deletedAt},
+			{Start: 11, End: 13, ClientID: "tablet-1"},
+			{ID: "doesnt exist", Note: "lost"},
+		},
+	}
+	state := decodeTestResponse[lib.ReadingState](t, http.MethodPut, readingPath, asUser("bilbo", readingApi.syncReadingState), changes, http.StatusOK, params)
+	if state.Progress == nil || state.Progress.Offset != 70 || !state.SyncedAt.Equal(clock.Now()) {
+		t.Error("expecting the synced progress got", state)
+	}
+	if len(state.Bookmarks) != 2 || state.Bookmarks[0].Quote != "In" || state.Bookmarks[1].DeletedAt == nil {
+		t.Error("expecting the deleted and created bookmarks got", state.Bookmarks)
+	}
+	if bookmarks := getTestBookmarks(t, readingApi, params, "bilbo"); len(bookmarks) != 1 || bookmarks[0].ClientID != "tablet-1" {
+		t.Error("expecting the created bookmark only got", bookmarks)
+	}
+
+	// a later sync only gets what changed since
+	clock.Advance(time.Minute)
+	state = decodeTestResponse[lib.ReadingState](t, http.MethodGet, readingPath+"?since="+state.SyncedAt.Format(time.RFC3339Nano), asUser("bilbo", readingApi.getReadingState), nil, http.StatusOK, params)
+	if len(state.Bookmarks) != 0 || state.Progress == nil {
+		t.Error("expecting no bookmark change got", state)
+	}
+	decodeTestResponse[[]lib.Bookmark](t, http.MethodGet, bookmarksPath+"?since=yesterday", asUser("bilbo", readingApi.getBookmarks), nil, http.StatusBadRequest, params)
+}
+
+// getTestBookmarks lists the live bookmarks of a user on a book
+func getTestBookmarks(t *testing.T, service *RestService, params map[string]string, userID string) []lib.Bookmark {
+	t.Helper()
+	return decodeTestResponse[[]lib.Bookmark](t, http.MethodGet, bookmarksPath, asUser(userID, service.getBookmarks), nil, http.StatusOK, params)
+}
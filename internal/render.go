@@ -0,0 +1,257 @@
+package internal
+
+import (
+	"bytes"
+	"container/list"
+	"dockerrestapi/db"
+	"dockerrestapi/lib"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	mediaTypeMarkdown = "text/markdown"
+	mediaTypeHTML     = "text/html"
+	mediaTypePlain    = "text/plain"
+
+	defaultRenderCacheSize = 64 << 20
+)
+
+// contentsMediaTypes are the media types contents can be served as, in order of preference. Markdown is the stored
+// contents, the others are rendered from it.
+var contentsMediaTypes = []string{mediaTypeMarkdown, mediaTypeHTML, mediaTypePlain}
+
+// WithRenderCacheSize limits the bytes of contents rendered into HTML or plain text kept in memory, 0 disables the cache
+func WithRenderCacheSize(size int64) ServiceOption {
+	return func(service *RestService) {
+		service.renders = newRenderCache(size)
+	}
+}
+
+// negotiateContentsType returns the media type contents are served as given an Accept header, text/markdown when the
+// header is missing
+func negotiateContentsType(accept string) (string, error) {
+	return negotiateMediaType(accept, contentsMediaTypes)
+}
+
+// negotiateMediaType returns the offered media type of highest quality in an Accept header, named types winning over
+// wildcards so that browsers get HTML, the first offer when the header is missing
+func negotiateMediaType(accept string, offers []string) (string, error) {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0], nil
+	}
+	best, bestQuality, bestSpecificity := "", 0.0, -1
+	for _, offer := range offers {
+		quality, specificity := acceptQuality(accept, offer)
+		if quality > bestQuality || quality == bestQuality && quality > 0 && specificity > bestSpecificity {
+			best, bestQuality, bestSpecificity = offer, quality, specificity
+		}
+	}
+	if best == "" {
+		return "", lib.UnacceptableMediaType
+	}
+	return best, nil
+}
+
+// acceptQuality returns the quality an Accept header gives a media type, from its most specific matching range, with
+// the specificity of the range: 2 for the type, 1 for a type/* and 0 for */*
+func acceptQuality(accept, mediaType string) (float64, int) {
+	mainType, _, _ := strings.Cut(mediaType, "/")
+	quality, specificity := 0.0, -1
+	for _, accepted := range strings.Split(accept, ",") {
+		acceptedType, params, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		rangeSpecificity := -1
+		switch acceptedType {
+		case mediaType:
+			rangeSpecificity = 2
+		case mainType + "/*":
+			rangeSpecificity = 1
+		case "*/*", "*":
+			rangeSpecificity = 0
+		}
+		if rangeSpecificity <= specificity {
+			continue
+		}
+		rangeQuality := 1.0
+		if q, found := params["q"]; found {
+			rangeQuality, err = strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+		}
+		quality, specificity = rangeQuality, rangeSpecificity
+	}
+	return quality, specificity
+}
+
+// render renders Markdown contents into a media type
+func render(contents string, mediaType string) []byte {
+	if mediaType == mediaTypeHTML {
+		return []byte(lib.RenderHTML(contents))
+	}
+	return []byte(lib.RenderPlainText(contents))
+}
+
+// renderedContents returns the contents of a book rendered into a media type, from the cache when rendered from the
+// same version of the book
+func (r *RestService) renderedContents(library db.RestDbInterface, libraryName string, bookIdentifier *lib.BookIdentifier, mediaType string) (db.ContentsReader, error) {
+	// the version is read before the contents, so contents updated in between are rendered again on the next request
+	book, err := library.GetOneBookWithFields(bookIdentifier, []string{lib.JsonBsonTagUpdatedAt})
+	if err != nil {
+		return nil, err
+	}
+	key := renderKey{library: libraryName, bookID: book.ID, mediaType: mediaType}
+	version := strconv.FormatInt(book.UpdatedAt.UnixNano(), 10)
+	rendered, err := r.renders.getOrRender(key, version, func() ([]byte, error) {
+		contents, err := library.GetBookContents(&lib.BookIdentifier{ID: book.ID})
+		if err != nil {
+			return nil, err
+		}
+		defer contents.Close()
+		markdown, err := io.ReadAll(contents)
+		if err != nil {
+			return nil, err
+		}
+		return render(string(markdown), mediaType), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &renderedContents{bytes.NewReader(rendered)}, nil
+}
+
+// renderedContents reads rendered contents held in memory
+type renderedContents struct {
+	*bytes.Reader
+}
+
+func (c *renderedContents) Close() error {
+	return nil
+}
+
+func (c *renderedContents) Skip(n int64) (int64, error) {
+	if remaining := int64(c.Len()); n > remaining {
+		n = remaining
+	}
+	_, err := c.Seek(n, io.SeekCurrent)
+	return n, err
+}
+
+// renderKey identifies the contents of a book rendered into a media type
+type renderKey struct {
+	library   string
+	bookID    string
+	mediaType string
+}
+
+// renderEntry is rendered contents with the version of the book they were rendered from
+type renderEntry struct {
+	key      renderKey
+	version  string
+	rendered []byte
+}
+
+// renderFlight is a rendering in progress, waited for by the requests missing the cache meanwhile
+type renderFlight struct {
+	done     chan struct{} // closed once rendered
+	rendered []byte
+	err      error
+}
+
+// renderFlightKey identifies the rendering of a version of contents
+type renderFlightKey struct {
+	renderKey
+	version string
+}
+
+// renderCache keeps rendered contents, evicting the least recently used past a total size in bytes. Entries are
+// tagged with the update time of the book, an update invalidating them on every replica without notifying it.
+type renderCache struct {
+	lock    sync.Mutex
+	maxSize int64
+	size    int64
+	entries map[renderKey]*list.Element
+	recent  *list.List // of renderEntry, most recently used first
+	flights map[renderFlightKey]*renderFlight
+}
+
+// newRenderCache returns a cache keeping up to maxSize bytes of rendered contents
+func newRenderCache(maxSize int64) *renderCache {
+	return &renderCache{maxSize: maxSize, entries: map[renderKey]*list.Element{}, recent: list.New(),
+		flights: map[renderFlightKey]*renderFlight{}}
+}
+
+// getOrRender returns the rendered contents of a version from the cache, otherwise renders and keeps them. Requests
+// missing the cache while the same version is rendered wait for that rendering rather than render it again.
+func (c *renderCache) getOrRender(key renderKey, version string, render func() ([]byte, error)) ([]byte, error) {
+	if rendered, found := c.get(key, version); found {
+		return rendered, nil
+	}
+	flightKey := renderFlightKey{renderKey: key, version: version}
+	c.lock.Lock()
+	if flight, found := c.flights[flightKey]; found {
+		c.lock.Unlock()
+		<-flight.done
+		return flight.rendered, flight.err
+	}
+	flight := &renderFlight{done: make(chan struct{})}
+	c.flights[flightKey] = flight
+	c.lock.Unlock()
+
+	flight.rendered, flight.err = render()
+	if flight.err == nil {
+		c.put(key, version, flight.rendered)
+	}
+	c.lock.Lock()
+	delete(c.flights, flightKey)
+	c.lock.Unlock()
+	close(flight.done)
+	return flight.rendered, flight.err
+}
+
+// get returns the rendered contents for a key when rendered from the given version
+func (c *renderCache) get(key renderKey, version string) ([]byte, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	element, found := c.entries[key]
+	if !found {
+		return nil, false
+	}
+	entry := element.Value.(*renderEntry)
+	if entry.version != version {
+		c.remove(element)
+		return nil, false
+	}
+	c.recent.MoveToFront(element)
+	return entry.rendered, true
+}
+
+// put keeps the rendered contents of a version, replacing those of other versions
+func (c *renderCache) put(key renderKey, version string, rendered []byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if element, found := c.entries[key]; found {
+		c.remove(element)
+	}
+	if int64(len(rendered)) > c.maxSize {
+		return
+	}
+	c.entries[key] = c.recent.PushFront(&renderEntry{key: key, version: version, rendered: rendered})
+	c.size += int64(len(rendered))
+	for c.size > c.maxSize {
+		c.remove(c.recent.Back())
+	}
+}
+
+// remove removes an entry. Caller must hold the lock.
+func (c *renderCache) remove(element *list.Element) {
+	entry := c.recent.Remove(element).(*renderEntry)
+	delete(c.entries, entry.key)
+	c.size -= int64(len(entry.rendered))
+}
@@ -0,0 +1,160 @@
+package internal
+
+import (
+	"dockerrestapi/lib"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNegotiateContentsType(t *testing.T) {
+	tests := map[string]string{
+		"":                               mediaTypeMarkdown,
+		"*/*":                            mediaTypeMarkdown,
+		"text/html":                      mediaTypeHTML,
+		"text/html;q=0.5, text/plain":    mediaTypePlain,
+		"application/json, text/*;q=0.8": mediaTypeMarkdown,
+		"text/*, text/markdown;q=0":      mediaTypeHTML,
+		"text/html, application/xhtml+xml, */*;q=0.8": mediaTypeHTML,
+		"text/plain, */*": mediaTypePlain,
+	}
+	for accept, expected := range tests {
+		if mediaType, err := negotiateContentsType(accept); err != nil || mediaType != expected {
+			t.Errorf("expecting %q negotiated as %s got %s %v", accept, expected, mediaType, err)
+		}
+	}
+	if _, err := negotiateContentsType("application/json, */*;q=0"); err != lib.UnacceptableMediaType {
+		t.Error("expecting no acceptable type got", err)
+	}
+}
+
+func TestRenderedContents(t *testing.T) {
+	clock := lib.NewManualClock(time.Date(2024, time.July, 1, 0, 0, 0, 0, time.UTC))
+	renderApi := createReviewsApi(t, clock)
+	marshalBook, err := json.Marshal(lib.Book{Name: "the hobbit", Author: "Tolkien", Contents: "# Riddles\n\n*In the dark* <script>alert(1)</script>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = testResponse(http.MethodPut, createBookPath, renderApi.createBook, marshalBook, http.StatusOK, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	book, err := renderApi.db.GetOneBook(&lib.BookIdentifier{Name: "the hobbit", Author: "Tolkien"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := map[string]string{paramLibrary: lib.DefaultLibraryName, paramID: book.ID}
+
+	// the stored Markdown by default, rendered HTML and plain text on request
+	recorder := renderRequest(renderApi, "", "", params)
+	if recorder.Header().Get("Content-Type") != "text/markdown; charset=utf-8" || recorder.Body.String() != book.Contents {
+		t.Error("expecting the stored markdown got", recorder.Header().Get("Content-Type"), recorder.Body.String())
+	}
+	expectedHTML := "\n<h1>Riddles</h1>\n<p><em>In the dark</em> &lt;script&gt;alert(1)&lt;/script&gt;</p>\n"
+	recorder = renderRequest(renderApi, "text/html", "", params)
+	if recorder.Header().Get("Content-Type") != "text/html; charset=utf-8" || recorder.Header().Get("Vary") != "Accept" ||
+		recorder.Body.String() != expectedHTML {
+		t.Error("expecting sanitised html got", recorder.Header(), recorder.Body.String())
+	}
+	recorder = renderRequest(renderApi, "text/plain", "bytes=9-", params)
+	if recorder.Code != http.StatusPartialContent || recorder.Body.String() != "In the dark <script>alert(1)</script>" {
+		t.Error("expecting a range of the plain text got", recorder.Code, recorder.Body.String())
+	}
+	recorder = renderRequest(renderApi, "application/pdf", "", params)
+	if recorder.Code != http.StatusNotAcceptable {
+		t.Error("expecting", http.StatusNotAcceptable, "got", recorder.Code)
+	}
+
+	// the render is cached until the book is updated
+	key := renderKey{library: lib.DefaultLibraryName, bookID: book.ID, mediaType: mediaTypeHTML}
+	if _, found := renderApi.renders.entries[key]; !found {
+		t.Fatal("expecting the html render cached")
+	}
+	clock.Advance(time.Second)
+	_, err = testResponse(http.MethodPut, libraryBookContentsPath, renderApi.storeBookContents, []byte("**Gollum**"), http.StatusOK, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder = renderRequest(renderApi, "text/html", "", params)
+	if recorder.Body.String() != "\n<p><strong>Gollum</strong></p>\n" {
+		t.Error("expecting the updated contents rendered got", recorder.Body.String())
+	}
+	recorder = renderRequest(renderApi, "text/html", "", map[string]string{paramLibrary: lib.DefaultLibraryName, paramID: "missing"})
+	if recorder.Code != http.StatusNotFound {
+		t.Error("expecting", http.StatusNotFound, "got", recorder.Code)
+	}
+}
+
+func TestRenderCacheEviction(t *testing.T) {
+	cache := newRenderCache(10)
+	first, second := renderKey{bookID: "1"}, renderKey{bookID: "2"}
+	cache.put(first, "v1", []byte("12345"))
+	cache.put(second, "v1", []byte("12345"))
+	if _, found := cache.get(first, "v1"); !found {
+		t.Fatal("expecting the first render kept")
+	}
+	cache.put(renderKey{bookID: "3"}, "v1", []byte("123"))
+	if _, found := cache.get(second, "v1"); found {
+		t.Error("expecting the least recently used render evicted")
+	}
+	if _, found := cache.get(first, "v2"); found || cache.size != 3 {
+		t.Error("expecting a render of another version dropped got size", cache.size)
+	}
+	cache.put(first, "v1", []byte("12345678901"))
+	if _, found := cache.get(first, "v1"); found {
+		t.Error("expecting a render larger than the cache not kept")
+	}
+}
+
+// renderRequest gets the contents of a book with optional Accept and Range headers
+func renderRequest(service *RestService, accept, rangeHeader string, params map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, libraryBookContentsPath, nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
+	req = mux.SetURLVars(req, params)
+	recorder := httptest.NewRecorder()
+	service.getBookContents(recorder, req)
+	return recorder
+}
+
+func TestRenderCacheRendersOnce(t *testing.T) {
+	cache := newRenderCache(defaultRenderCacheSize)
+	key := renderKey{bookID: "1", mediaType: mediaTypeHTML}
+	release := make(chan struct{})
+	var renders atomic.Int32
+	render := func() ([]byte, error) {
+		renders.Add(1)
+		<-release
+		return []byte("<p>rendered</p>"), nil
+	}
+
+	// requests missing the cache while the first renders wait for it, later ones find the render cached
+	var done sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			rendered, err := cache.getOrRender(key, "v1", render)
+			if err != nil || string(rendered) != "<p>rendered</p>" {
+				t.Error("expecting the render got", string(rendered), err)
+			}
+		}()
+	}
+	close(release)
+	done.Wait()
+	if renders.Load() != 1 {
+		t.Error("expecting contents rendered once got", renders.Load())
+	}
+	if _, found := cache.get(key, "v1"); !found || len(cache.flights) != 0 {
+		t.Error("expecting the render cached and no rendering left in progress")
+	}
+}
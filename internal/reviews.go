@@ -0,0 +1,242 @@
+package internal
+
+import (
+	"dockerrestapi/lib"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strconv"
+)
+
+const (
+	reviewsPath          = libraryBookPath + "/reviews"
+	reviewPath           = reviewsPath + "/{" + paramReview + "}"
+	reviewModerationPath = reviewPath + "/moderation"
+	reviewHelpfulPath    = reviewPath + "/helpful"
+	paramReview          = "review"
+	paramSort            = "sort"
+	paramOffset          = "offset"
+	paramLimit           = "limit"
+
+	defaultReviewLimit = 20
+	maxReviewLimit     = 100
+)
+
+// moderationRequest is the body of a moderation, setting the status of a review
+type moderationRequest struct {
+	Status string `json:"status"`
+}
+
+// helpfulVoteRequest is the body of a helpful vote, naming the user who found the review helpful
+type helpfulVoteRequest struct {
+	UserID string `json:"userId"`
+}
+
+// getReviews lists a page of the reviews of a book, most recent first or most helpful first with sort=helpful.
+// Rejected reviews are only listed when asked for by status.
+// eg : api/libraries/{library}/books/{id}/reviews?sort=helpful&offset=20&limit=10&status=pending
+func (r *RestService) getReviews(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Reviews request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+	query, err := createReviewQueryFromQuery(request)
+	if err != nil {
+		r.restReviewError(writer, err)
+		return
+	}
+
+	page, err := library.GetAllReviews(mux.Vars(request)[paramID], query)
+	if err != nil {
+		r.restReviewError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, page)
+}
+
+// createReview stores the review of a user on the book given the id in the path, a user reviews a book once.
+// eg : api/libraries/{library}/books/{id}/reviews with {"userId": "...", "rating": 4, "title": "...", "text": "..."}
+func (r *RestService) createReview(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received create Review request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+	review := &lib.Review{}
+	err = json.NewDecoder(request.Body).Decode(review)
+	if err != nil {
+		r.restResponse(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	review.BookID = mux.Vars(request)[paramID]
+
+	err = library.CreateNewReview(review)
+	if err != nil {
+		r.restReviewError(writer, err)
+		return
+	}
+	writer.Header().Set("Location", libraryBookLocation(r.libraryNameFromRequest(request), review.BookID)+"/reviews/"+review.ID)
+	r.restResponse(writer, http.StatusOK, review)
+}
+
+// getReview retrieves a review given the book id and review id in the path.
+// eg : api/libraries/{library}/books/{id}/reviews/{review}
+func (r *RestService) getReview(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Review request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+	params := mux.Vars(request)
+
+	review, err := library.GetOneReview(params[paramID], params[paramReview])
+	if err != nil {
+		r.restReviewError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, review)
+}
+
+// updateReview changes the rating, title and text of a review, which goes back to pending moderation.
+// eg : api/libraries/{library}/books/{id}/reviews/{review} with {"rating": 5, "title": "...", "text": "..."}
+func (r *RestService) updateReview(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received update Review request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+	review := &lib.Review{}
+	err = json.NewDecoder(request.Body).Decode(review)
+	if err != nil {
+		r.restResponse(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	params := mux.Vars(request)
+	review.BookID = params[paramID]
+	review.ID = params[paramReview]
+
+	err = library.UpdateExistingReview(review)
+	if err != nil {
+		r.restReviewError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, review)
+}
+
+// deleteReview deletes a review, taking its stars out of the rating of the book.
+// eg : api/libraries/{library}/books/{id}/reviews/{review}
+func (r *RestService) deleteReview(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received delete Review request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+	params := mux.Vars(request)
+
+	err = library.DeleteReview(params[paramID], params[paramReview])
+	if err != nil {
+		r.restReviewError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, "review deleted")
+}
+
+// moderateReview sets the moderation status of a review, rejected reviews are hidden and leave the rating of the book.
+// eg : api/libraries/{library}/books/{id}/reviews/{review}/moderation with {"status": "approved"}
+func (r *RestService) moderateReview(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received moderate Review request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+	body := moderationRequest{}
+	err = json.NewDecoder(request.Body).Decode(&body)
+	if err != nil {
+		r.restResponse(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	params := mux.Vars(request)
+
+	review, err := library.ModerateReview(params[paramID], params[paramReview], body.Status)
+	if err != nil {
+		r.restReviewError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, review)
+}
+
+// voteReviewHelpful counts a user finding a review helpful, voting again changes nothing.
+// eg : api/libraries/{library}/books/{id}/reviews/{review}/helpful with {"userId": "..."}
+func (r *RestService) voteReviewHelpful(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received vote Review Helpful request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+	body := helpfulVoteRequest{}
+	err = json.NewDecoder(request.Body).Decode(&body)
+	if err != nil || body.UserID == "" {
+		r.restResponse(writer, http.StatusBadRequest, lib.IncorrectParameters.Error())
+		return
+	}
+	params := mux.Vars(request)
+
+	review, err := library.VoteReviewHelpful(params[paramID], params[paramReview], body.UserID)
+	if err != nil {
+		r.restReviewError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, review)
+}
+
+// createReviewQueryFromQuery reads the sort, paging and status of a review listing from the url query
+func createReviewQueryFromQuery(request *http.Request) (lib.ReviewQuery, error) {
+	values := request.URL.Query()
+	query := lib.ReviewQuery{Status: values.Get(paramStatus), Sort: values.Get(paramSort), Limit: defaultReviewLimit}
+	if query.Sort == "" {
+		query.Sort = lib.ReviewSortRecent
+	}
+	if query.Sort != lib.ReviewSortRecent && query.Sort != lib.ReviewSortHelpful {
+		return query, lib.IncorrectParameters
+	}
+	if query.Status != "" && !lib.IsValidReviewStatus(query.Status) {
+		return query, lib.IncorrectReviewStatus
+	}
+	var err error
+	if offset := values.Get(paramOffset); offset != "" {
+		query.Offset, err = strconv.Atoi(offset)
+		if err != nil || query.Offset < 0 {
+			return query, lib.IncorrectParameters
+		}
+	}
+	if limit := values.Get(paramLimit); limit != "" {
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil || query.Limit < 1 || query.Limit > maxReviewLimit {
+			return query, lib.IncorrectParameters
+		}
+	}
+	return query, nil
+}
+
+// restReviewError responds with the status matching a review error
+func (r *RestService) restReviewError(writer http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, lib.NoMatchingBook), errors.Is(err, lib.NoMatchingReview):
+		r.restResponse(writer, http.StatusNotFound, err.Error())
+	case errors.Is(err, lib.IncompleteReview), errors.Is(err, lib.ReviewAlreadyExists),
+		errors.Is(err, lib.IncorrectReviewStatus), errors.Is(err, lib.IncorrectParameters):
+		r.restResponse(writer, http.StatusBadRequest, err.Error())
+	default:
+		stdError(err.Error())
+		r.restResponse(writer, http.StatusInternalServerError, err.Error())
+	}
+}
@@ -0,0 +1,265 @@
+package internal
+
+import (
+	"dockerrestapi/db"
+	"dockerrestapi/lib"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReviews(t *testing.T) {
+	clock := lib.NewManualClock(time.Date(2024, time.March, 1, 9, 0, 0, 0, time.UTC))
+	reviewsApi := createReviewsApi(t, clock)
+	book := lib.Book{Name: "the hobbit", Author: "Tolkien", Contents: "In a hole in the ground there lived a hobbit."}
+	err := reviewsApi.db.CreateNewBook(&book)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bookParams := map[string]string{paramLibrary: lib.DefaultLibraryName, paramID: book.ID}
+
+	// a user reviews a book once, with 1 to 5 stars
+	createTestReview(t, reviewsApi, bookParams, lib.Review{UserID: "bilbo", Stars: 6}, http.StatusBadRequest)
+	createTestReview(t, reviewsApi, bookParams, lib.Review{Stars: 3}, http.StatusBadRequest)
+	bilbo := createTestReview(t, reviewsApi, bookParams, lib.Review{UserID: " bilbo ", Stars: 4, Title: "There and back"}, http.StatusOK)
+	if bilbo.ID == "" || bilbo.UserID != "bilbo" || bilbo.Status != lib.ReviewPending || !bilbo.CreatedAt.Equal(clock.Now()) {
+		t.Error("expecting a pending review got", bilbo)
+	}
+	createTestReview(t, reviewsApi, bookParams, lib.Review{UserID: "bilbo", Stars: 1}, http.StatusBadRequest)
+	clock.Advance(time.Minute)
+	frodo := createTestReview(t, reviewsApi, bookParams, lib.Review{UserID: "frodo", Stars: 2}, http.StatusOK)
+	clock.Advance(time.Minute)
+	sam := createTestReview(t, reviewsApi, bookParams, lib.Review{UserID: "sam", Stars: 5}, http.StatusOK)
+	checkTestRating(t, reviewsApi, book.ID, 3, map[string]int{"2": 1, "4": 1, "5": 1})
+	_, err = testResponse(http.MethodPut, reviewsPath, reviewsApi.createReview, []byte(`{"userId":"gollum","rating":3}`), http.StatusNotFound,
+		map[string]string{paramLibrary: lib.DefaultLibraryName, paramID: "doesnt exist"})
+	if err != nil {
+		t.Error(err)
+	}
+
+	// the rating is kept when the book itself is updated
+	book.Contents = "In a hole in the ground there lived a hobbit, not a nasty, dirty, wet hole."
+	err = reviewsApi.db.UpdateExistingBook(&book)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestRating(t, reviewsApi, book.ID, 3, map[string]int{"2": 1, "4": 1, "5": 1})
+
+	// editing a review moves its stars and sends it back to moderation
+	frodoParams := reviewTestParams(book.ID, frodo.ID)
+	marshalEdit, err := json.Marshal(lib.Review{Stars: 3, Text: "Uncle Bilbo's favourite."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := testResponse(http.MethodPut, reviewPath, reviewsApi.updateReview, marshalEdit, http.StatusOK, frodoParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edited := lib.Review{}
+	err = json.Unmarshal([]byte(response), &edited)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edited.Stars != 3 || edited.UserID != "frodo" || edited.Text != "Uncle Bilbo's favourite." {
+		t.Error("expecting an edited review got", edited)
+	}
+	checkTestRating(t, reviewsApi, book.ID, 3, map[string]int{"3": 1, "4": 1, "5": 1})
+
+	// rejected reviews are hidden and leave the rating, until approved again
+	samParams := reviewTestParams(book.ID, sam.ID)
+	moderateTestReview(t, reviewsApi, samParams, "hidden", http.StatusBadRequest)
+	moderateTestReview(t, reviewsApi, samParams, lib.ReviewRejected, http.StatusOK)
+	checkTestRating(t, reviewsApi, book.ID, 2, map[string]int{"3": 1, "4": 1})
+	if page := getTestReviews(t, reviewsApi, reviewsPath, bookParams); page.Total != 2 {
+		t.Error("expecting rejected reviews hidden got", page)
+	}
+	if page := getTestReviews(t, reviewsApi, reviewsPath+"?status=rejected", bookParams); page.Total != 1 || page.Reviews[0].ID != sam.ID {
+		t.Error("expecting the rejected review got", page)
+	}
+	moderateTestReview(t, reviewsApi, samParams, lib.ReviewApproved, http.StatusOK)
+	moderateTestReview(t, reviewsApi, samParams, lib.ReviewApproved, http.StatusOK)
+	checkTestRating(t, reviewsApi, book.ID, 3, map[string]int{"3": 1, "4": 1, "5": 1})
+
+	// a user finds a review helpful once, helpful reviews come first when asked
+	for _, userID := range []string{"gandalf", "thorin", "gandalf"} {
+		marshalVote, err := json.Marshal(helpfulVoteRequest{UserID: userID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = testResponse(http.MethodPut, reviewHelpfulPath, reviewsApi.voteReviewHelpful, marshalVote, http.StatusOK, reviewTestParams(book.ID, bilbo.ID))
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	_, err = testResponse(http.MethodPut, reviewHelpfulPath, reviewsApi.voteReviewHelpful, []byte(`{}`), http.StatusBadRequest, samParams)
+	if err != nil {
+		t.Error(err)
+	}
+	recent := getTestReviews(t, reviewsApi, reviewsPath, bookParams)
+	if len(recent.Reviews) != 3 || recent.Reviews[0].ID != sam.ID || recent.Reviews[2].ID != bilbo.ID {
+		t.Error("expecting the most recent review first got", recent)
+	}
+	helpful := getTestReviews(t, reviewsApi, reviewsPath+"?sort=helpful", bookParams)
+	if len(helpful.Reviews) != 3 || helpful.Reviews[0].ID != bilbo.ID || helpful.Reviews[0].Helpful != 2 || helpful.Reviews[1].ID != sam.ID {
+		t.Error("expecting the most helpful review first got", helpful)
+	}
+
+	// reviews are paged
+	page := getTestReviews(t, reviewsApi, reviewsPath+"?offset=1&limit=1", bookParams)
+	if page.Total != 3 || page.Offset != 1 || page.Limit != 1 || len(page.Reviews) != 1 || page.Reviews[0].ID != frodo.ID {
+		t.Error("expecting the second review got", page)
+	}
+	if page = getTestReviews(t, reviewsApi, reviewsPath+"?offset=5", bookParams); page.Total != 3 || len(page.Reviews) != 0 {
+		t.Error("expecting an empty page got", page)
+	}
+	for _, query := range []string{"?limit=0", "?limit=101", "?offset=-1", "?sort=stars", "?status=hidden"} {
+		_, err = testResponse(http.MethodGet, reviewsPath+query, reviewsApi.getReviews, nil, http.StatusBadRequest, bookParams)
+		if err != nil {
+			t.Error(query, err)
+		}
+	}
+
+	// deleting a review takes its stars out of the rating
+	_, err = testResponse(http.MethodDelete, reviewPath, reviewsApi.deleteReview, nil, http.StatusOK, frodoParams)
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = testResponse(http.MethodGet, reviewPath, reviewsApi.getReview, nil, http.StatusNotFound, frodoParams)
+	if err != nil {
+		t.Error(err)
+	}
+	checkTestRating(t, reviewsApi, book.ID, 2, map[string]int{"4": 1, "5": 1})
+
+	// reviews of a book in the trash are out of reach, and go with it when purged
+	err = reviewsApi.db.DeleteBook(&lib.BookIdentifier{ID: book.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = testResponse(http.MethodGet, reviewsPath, reviewsApi.getReviews, nil, http.StatusNotFound, bookParams)
+	if err != nil {
+		t.Error(err)
+	}
+	err = reviewsApi.db.RestoreBook(&lib.BookIdentifier{ID: book.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page = getTestReviews(t, reviewsApi, reviewsPath, bookParams); page.Total != 2 {
+		t.Error("expecting the reviews back with the book got", page)
+	}
+}
+
+func TestConcurrentReviews(t *testing.T) {
+	reviewsApi := createReviewsApi(t, lib.NewManualClock(time.Date(2024, time.March, 1, 9, 0, 0, 0, time.UTC)))
+	book := lib.Book{Name: "the silmarillion", Author: "Tolkien", Contents: "There was Eru, the One."}
+	err := reviewsApi.db.CreateNewBook(&book)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bookParams := map[string]string{paramLibrary: lib.DefaultLibraryName, paramID: book.ID}
+
+	// every user reviewing twice at once gets a single review counted
+	var group sync.WaitGroup
+	for user := 0; user < 20; user++ {
+		for attempt := 0; attempt < 2; attempt++ {
+			group.Add(1)
+			go func(user int) {
+				defer group.Done()
+				body, _ := json.Marshal(lib.Review{UserID: "user" + strconv.Itoa(user), Stars: user%5 + 1})
+				_, _ = testResponse(http.MethodPut, reviewsPath, reviewsApi.createReview, body, http.StatusOK, bookParams)
+			}(user)
+		}
+	}
+	group.Wait()
+	checkTestRating(t, reviewsApi, book.ID, 20, map[string]int{"1": 4, "2": 4, "3": 4, "4": 4, "5": 4})
+}
+
+// createReviewsApi creates a rest api taking every timestamp from the given clock
+func createReviewsApi(t *testing.T, clock lib.Clock) *RestService {
+	t.Helper()
+	mockConn, err := db.CreateMockDBHandlerWithClock(clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := CreateRestApiService(mockConn, "8081", WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service
+}
+
+// reviewTestParams returns the path parameters of a review of the default library
+func reviewTestParams(bookID, reviewID string) map[string]string {
+	return map[string]string{paramLibrary: lib.DefaultLibraryName, paramID: bookID, paramReview: reviewID}
+}
+
+// createTestReview reviews a book, returning the review when created
+func createTestReview(t *testing.T, service *RestService, params map[string]string, review lib.Review, expectedStatus int) lib.Review {
+	t.Helper()
+	body, err := json.Marshal(review)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := testResponse(http.MethodPut, reviewsPath, service.createReview, body, expectedStatus, params)
+	if err != nil {
+		t.Error(err)
+		return lib.Review{}
+	}
+	created := lib.Review{}
+	if expectedStatus == http.StatusOK {
+		err = json.Unmarshal([]byte(response), &created)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return created
+}
+
+// moderateTestReview sets the moderation status of a review
+func moderateTestReview(t *testing.T, service *RestService, params map[string]string, status string, expectedStatus int) {
+	t.Helper()
+	body, err := json.Marshal(moderationRequest{Status: status})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = testResponse(http.MethodPut, reviewModerationPath, service.moderateReview, body, expectedStatus, params)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// getTestReviews lists a page of the reviews of a book
+func getTestReviews(t *testing.T, service *RestService, url string, params map[string]string) lib.ReviewPage {
+	t.Helper()
+	return decodeTestResponse[lib.ReviewPage](t, http.MethodGet, url, service.getReviews, nil, http.StatusOK, params)
+}
+
+// checkTestRating checks the review count and histogram of the rating of a book, and that its average matches them
+func checkTestRating(t *testing.T, service *RestService, bookID string, count int, histogram map[string]int) {
+	t.Helper()
+	response, err := testResponse(http.MethodGet, libraryBookPath, service.getBook, nil, http.StatusOK, map[string]string{paramLibrary: lib.DefaultLibraryName, paramID: bookID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	book := lib.Book{}
+	err = json.Unmarshal([]byte(response), &book)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.Rating == nil || book.Rating.Count != count {
+		t.Fatal("expecting", count, "ratings got", book.Rating)
+	}
+	sum := 0
+	for stars := lib.MinStars; stars <= lib.MaxStars; stars++ {
+		key := lib.StarsKey(stars)
+		if book.Rating.Histogram[key] != histogram[key] {
+			t.Error("expecting", histogram[key], "ratings of", key, "stars got", book.Rating.Histogram)
+		}
+		sum += stars * histogram[key]
+	}
+	if average := float64(sum) / float64(count); book.Rating.Average != average {
+		t.Error("expecting an average of", average, "got", book.Rating.Average)
+	}
+}
@@ -0,0 +1,273 @@
+package internal
+
+import (
+	"dockerrestapi/db"
+	"dockerrestapi/lib"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	libraryBookRevisionsPath = libraryBookPath + "/revisions"
+	libraryBookRevisionPath  = libraryBookRevisionsPath + "/{" + paramRevision + "}"
+	libraryBookDiffPath      = libraryBookPath + "/diff"
+	paramRevision            = "revision"
+	paramFrom                = "from"
+	paramTo                  = "to"
+	paramGranularity         = "granularity"
+	paramContext             = "context"
+
+	mediaTypeJSON = "application/json"
+	mediaTypeDiff = "text/x-diff"
+)
+
+// diffMediaTypes are the media types diffs can be served as, in order of preference
+var diffMediaTypes = []string{mediaTypeJSON, mediaTypeDiff}
+
+// WithRevisionRetention purges the revisions of books, but the latest of each, once written longer than retention ago,
+// 0 keeps them until the book is purged
+func WithRevisionRetention(retention time.Duration) ServiceOption {
+	return func(service *RestService) {
+		service.revisionRetention = retention
+	}
+}
+
+// getBookRevisions lists the revisions of the contents of a book given the id in the path, oldest first.
+// eg : api/libraries/{library}/books/{id}/revisions
+func (r *RestService) getBookRevisions(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Book Revisions request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+
+	revisions, err := library.GetAllRevisions(mux.Vars(request)[paramID])
+	if err != nil {
+		r.restRevisionError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, revisions)
+}
+
+// getBookRevision streams the contents of a revision of a book given the id and the revision number in the path.
+// eg : api/libraries/{library}/books/{id}/revisions/{revision}
+func (r *RestService) getBookRevision(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Book Revision request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+	params := mux.Vars(request)
+	number, err := strconv.Atoi(params[paramRevision])
+	if err != nil {
+		r.restRevisionError(writer, lib.IncorrectParameters)
+		return
+	}
+
+	contents, err := library.GetRevisionContents(params[paramID], number)
+	if err != nil {
+		r.restRevisionError(writer, err)
+		return
+	}
+	defer contents.Close()
+	writer.Header().Set("Content-Type", mediaTypeMarkdown+"; charset=utf-8")
+	writer.Header().Set("Content-Length", strconv.FormatInt(contents.Size(), 10))
+	writer.WriteHeader(http.StatusOK)
+	_, err = io.Copy(writer, contents)
+	if err != nil {
+		stdError("cant stream revision " + err.Error())
+	}
+}
+
+// getBookDiff compares two revisions of the contents of a book given the id in the path, by default the latest with the
+// one before, revision 0 standing for no contents. Changes are found line by line, or word by word within the changed
+// lines, and served as JSON unless the Accept header prefers a unified diff.
+// eg : api/libraries/{library}/books/{id}/diff?from=1&to=3&granularity=word&context=3
+func (r *RestService) getBookDiff(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Book Diff request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+
+	writer.Header().Set("Vary", "Accept")
+	mediaType, err := negotiateMediaType(request.Header.Get("Accept"), diffMediaTypes)
+	if err != nil {
+		r.restResponse(writer, http.StatusNotAcceptable, err.Error())
+		return
+	}
+	bookID := mux.Vars(request)[paramID]
+	revisions, err := library.GetAllRevisions(bookID)
+	if err != nil {
+		r.restRevisionError(writer, err)
+		return
+	}
+	query := request.URL.Query()
+	latest := 0
+	if len(revisions) > 0 {
+		latest = revisions[len(revisions)-1].Number
+	}
+	to, err := revisionNumberFromQuery(query.Get(paramTo), latest)
+	if err != nil {
+		r.restRevisionError(writer, err)
+		return
+	}
+	from, err := revisionNumberFromQuery(query.Get(paramFrom), previousRevisionNumber(revisions, to))
+	if err != nil {
+		r.restRevisionError(writer, err)
+		return
+	}
+	granularity := query.Get(paramGranularity)
+	if granularity == "" {
+		granularity = lib.DiffGranularityLine
+	}
+	context := lib.DefaultDiffContext
+	if value := query.Get(paramContext); value != "" {
+		context, err = strconv.Atoi(value)
+		if err != nil {
+			r.restRevisionError(writer, lib.IncorrectDiffContext)
+			return
+		}
+	}
+
+	fromText, err := revisionText(library, bookID, from)
+	if err != nil {
+		r.restRevisionError(writer, err)
+		return
+	}
+	toText, err := revisionText(library, bookID, to)
+	if err != nil {
+		r.restRevisionError(writer, err)
+		return
+	}
+	diff, err := lib.DiffTexts(fromText, toText, granularity, context)
+	if err != nil {
+		r.restRevisionError(writer, err)
+		return
+	}
+	diff.From, diff.To = from, to
+	if mediaType == mediaTypeJSON {
+		r.restResponse(writer, http.StatusOK, diff)
+		return
+	}
+
+	writer.Header().Set("Content-Type", mediaTypeDiff+"; charset=utf-8")
+	writer.WriteHeader(http.StatusOK)
+	err = diff.WriteUnified(writer, revisionDiffName("a", from), revisionDiffName("b", to))
+	if err != nil {
+		stdError("cant write diff " + err.Error())
+	}
+}
+
+// revisionNumberFromQuery parses a revision number of the url query, the default when missing
+func revisionNumberFromQuery(value string, defaultNumber int) (int, error) {
+	if value == "" {
+		return defaultNumber, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 0 {
+		return 0, lib.IncorrectParameters
+	}
+	return number, nil
+}
+
+// previousRevisionNumber returns the number of the revision kept before the given one, 0 when there is none
+func previousRevisionNumber(revisions []lib.Revision, number int) int {
+	previous := 0
+	for _, revision := range revisions {
+		if revision.Number < number {
+			previous = revision.Number
+		}
+	}
+	return previous
+}
+
+// purgeRevisionsPeriodically purges the revisions past the revision retention of every library until the service stops
+func (r *RestService) purgeRevisionsPeriodically() {
+	ticker := time.NewTicker(r.trashPurgeInterval)
+	defer ticker.Stop()
+	for {
+		r.purgeExpiredRevisions()
+		select {
+		case <-ticker.C:
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// purgeExpiredRevisions purges the revisions written longer than the revision retention ago, but the latest of each book,
+// from every library, returning how many were purged
+func (r *RestService) purgeExpiredRevisions() int {
+	libraries, err := r.db.GetAllLibraries()
+	if err != nil {
+		stdError("cant list libraries to purge revisions " + err.Error())
+		return 0
+	}
+	cutoff := r.clock.Now().Add(-r.revisionRetention)
+	purged := 0
+	for _, libraryInfo := range libraries {
+		library, err := r.db.Library(libraryInfo.Name)
+		if err != nil {
+			stdError("cant open library " + libraryInfo.Name + " to purge revisions " + err.Error())
+			continue
+		}
+		count, err := library.PurgeRevisions(cutoff)
+		purged += count
+		if err != nil {
+			stdError("cant purge revisions of library " + libraryInfo.Name + " " + err.Error())
+		}
+	}
+	if purged > 0 {
+		stdInfo("purged expired revisions")
+	}
+	return purged
+}
+
+// revisionText reads the whole contents of a revision of a book, revision 0 having none
+func revisionText(library db.RestDbInterface, bookID string, number int) (string, error) {
+	if number == 0 {
+		return "", nil
+	}
+	contents, err := library.GetRevisionContents(bookID, number)
+	if err != nil {
+		return "", err
+	}
+	defer contents.Close()
+	text, err := io.ReadAll(contents)
+	if err != nil {
+		return "", err
+	}
+	return string(text), nil
+}
+
+// revisionDiffName names a revision in a unified diff, /dev/null for no contents as for a created file
+func revisionDiffName(side string, number int) string {
+	if number == 0 {
+		return "/dev/null"
+	}
+	return fmt.Sprintf("%s/revisions/%d", side, number)
+}
+
+// restRevisionError responds with the status matching a revision or diff error
+func (r *RestService) restRevisionError(writer http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, lib.NoMatchingBook), errors.Is(err, lib.NoMatchingRevision):
+		r.restResponse(writer, http.StatusNotFound, err.Error())
+	case errors.Is(err, lib.IncorrectParameters), errors.Is(err, lib.IncorrectDiffGranularity), errors.Is(err, lib.IncorrectDiffContext):
+		r.restResponse(writer, http.StatusBadRequest, err.Error())
+	case errors.Is(err, lib.DiffTooLarge):
+		r.restResponse(writer, http.StatusUnprocessableEntity, err.Error())
+	default:
+		stdError(err.Error())
+		r.restResponse(writer, http.StatusInternalServerError, err.Error())
+	}
+}
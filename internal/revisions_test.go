@@ -0,0 +1,210 @@
+package internal
+
+import (
+	"dockerrestapi/db"
+	"dockerrestapi/lib"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRevisions(t *testing.T) {
+	previousThreshold, previousChunkSize := db.ContentsFileThreshold, db.ContentsChunkSize
+	db.ContentsFileThreshold, db.ContentsChunkSize = 64, 16
+	defer func() {
+		db.ContentsFileThreshold, db.ContentsChunkSize = previousThreshold, previousChunkSize
+	}()
+
+	revisionsApi, err := createMockApi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := "# The Hobbit\n\nIn a hole in the ground there lived a hobbit.\n"
+	second := "# The Hobbit\n\nIn a hole in the ground there lived a hobbit.\nNot a nasty, dirty, wet hole, filled with the ends of worms.\n"
+	third := "# The Hobbit\n\nIn a hole in the earth there lived a hobbit.\nNot a nasty, dirty, wet hole, filled with the ends of worms.\n"
+	book := lib.Book{Name: "the hobbit", Author: "Tolkien", Contents: first}
+	marshalBook, err := json.Marshal(book)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = testResponse(http.MethodPut, createBookPath, revisionsApi.createBook, marshalBook, http.StatusOK, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := revisionsApi.db.GetOneBook(&lib.BookIdentifier{Name: "the hobbit", Author: "Tolkien"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := map[string]string{paramLibrary: lib.DefaultLibraryName, paramID: stored.ID}
+
+	// every write of changed contents is a revision, large contents included, writes of the same contents are not
+	stored.Contents = second
+	for _, tags := range [][]string{nil, {"fantasy"}} {
+		stored.Tags = tags
+		marshalBook, err = json.Marshal(stored)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = testResponse(http.MethodPut, libraryBookPath, revisionsApi.updateBook, marshalBook, http.StatusOK, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = testResponse(http.MethodPut, libraryBookContentsPath, revisionsApi.storeBookContents, []byte(third), http.StatusOK, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := testResponse(http.MethodGet, libraryBookRevisionsPath, revisionsApi.getBookRevisions, nil, http.StatusOK, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var revisions []lib.Revision
+	err = json.Unmarshal([]byte(response), &revisions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 3 || revisions[0].Number != 1 || revisions[2].Number != 3 || revisions[1].ContentsLength != int64(len(second)) {
+		t.Fatal("expecting three revisions got", revisions)
+	}
+	for number, expected := range map[string]string{"1": first, "2": second, "3": third} {
+		revisionParams := map[string]string{paramLibrary: lib.DefaultLibraryName, paramID: stored.ID, paramRevision: number}
+		response, err = testResponse(http.MethodGet, libraryBookRevisionPath, revisionsApi.getBookRevision, nil, http.StatusOK, revisionParams)
+		if err != nil || response != expected {
+			t.Error("expecting the contents of revision", number, "got", response, err)
+		}
+	}
+	// the large contents of the book are those of its latest revision
+	if contents, _ := revisionsApi.db.GetBookContents(&lib.BookIdentifier{ID: stored.ID}); contents.Size() != int64(len(third)) {
+		t.Error("expecting the contents of the latest revision got", contents.Size())
+	}
+
+	// diffs are JSON by default, between the latest revision and the one before
+	diffPath := libraryBookLocation(lib.DefaultLibraryName, stored.ID) + "/diff"
+	recorder := diffRequest(revisionsApi, diffPath, "")
+	diff := lib.Diff{}
+	err = json.Unmarshal(recorder.Body.Bytes(), &diff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff.From != 2 || diff.To != 3 || diff.Insertions != 1 || diff.Deletions != 1 || len(diff.Hunks) != 1 ||
+		diff.Hunks[0].Segments[2] != (lib.DiffSegment{Op: lib.DiffDelete, Text: "In a hole in the ground there lived a hobbit.\n"}) {
+		t.Error("expecting the diff of the latest revision got", diff)
+	}
+	recorder = diffRequest(revisionsApi, diffPath+"?from=1&granularity=word&context=0", mediaTypeDiff)
+	expected := "--- a/revisions/1\n+++ b/revisions/3\n@@ -3 +3,2 @@\n" +
+		"In a hole in the [-ground-]{+earth+} there lived a hobbit.\n{+Not a nasty, dirty, wet hole, filled with the ends of worms.\n+}\n"
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != mediaTypeDiff+"; charset=utf-8" || recorder.Body.String() != expected {
+		t.Errorf("expecting the unified word diff\n%s\ngot %d\n%s", expected, recorder.Code, recorder.Body.String())
+	}
+	recorder = diffRequest(revisionsApi, diffPath+"?from=0&to=1", mediaTypeDiff)
+	if !strings.HasPrefix(recorder.Body.String(), "--- /dev/null\n+++ b/revisions/1\n@@ -0,0 +1,3 @@\n+# The Hobbit\n") {
+		t.Error("expecting the first revision added got", recorder.Body.String())
+	}
+
+	for query, status := range map[string]int{"?to=4": http.StatusNotFound, "?from=-1": http.StatusBadRequest,
+		"?granularity=char": http.StatusBadRequest, "?context=many": http.StatusBadRequest} {
+		if recorder = diffRequest(revisionsApi, diffPath+query, ""); recorder.Code != status {
+			t.Error("expecting", status, "for", query, "got", recorder.Code)
+		}
+	}
+	if recorder = diffRequest(revisionsApi, diffPath, "image/png"); recorder.Code != http.StatusNotAcceptable {
+		t.Error("expecting", http.StatusNotAcceptable, "got", recorder.Code)
+	}
+
+	// revisions go with the book once purged
+	err = revisionsApi.db.DeleteBook(&lib.BookIdentifier{ID: stored.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorder = diffRequest(revisionsApi, diffPath, ""); recorder.Code != http.StatusNotFound {
+		t.Error("expecting", http.StatusNotFound, "got", recorder.Code)
+	}
+	_, err = revisionsApi.db.PurgeBook(&lib.BookIdentifier{ID: stored.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestRevisionRetention checks on every backend that expired revisions are purged but the latest of each book, the others
+// keeping their numbers
+func TestRevisionRetention(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, handler db.RestDbInterface, clock *lib.ManualClock) {
+		previousThreshold := db.ContentsFileThreshold
+		db.ContentsFileThreshold = 8 // every revision but the first in a file
+		defer func() { db.ContentsFileThreshold = previousThreshold }()
+
+		revisionsApi, err := CreateRestApiService(handler, "8081", WithClock(clock), WithRevisionRetention(2*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		book := &lib.Book{Name: "the hobbit", Author: "Tolkien", Contents: "first"}
+		err = handler.CreateNewBook(book)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, contents := range []string{"the second", "the third"} {
+			clock.Advance(time.Hour)
+			book.Contents = contents
+			err = handler.UpdateExistingBook(book)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// revisions are kept for the retention
+		clock.Advance(time.Hour)
+		if purged := revisionsApi.purgeExpiredRevisions(); purged != 1 {
+			t.Error("expecting the first revision purged got", purged)
+		}
+		revisions, err := handler.GetAllRevisions(book.ID)
+		if err != nil || len(revisions) != 2 || revisions[0].Number != 2 || revisions[1].Number != 3 {
+			t.Fatal("expecting revisions 2 and 3 left got", revisions, err)
+		}
+		params := map[string]string{paramLibrary: lib.DefaultLibraryName, paramID: book.ID}
+		diffPath := libraryBookLocation(lib.DefaultLibraryName, book.ID) + "/diff"
+		recorder := diffRequest(revisionsApi, diffPath, "")
+		diff := lib.Diff{}
+		if err = json.Unmarshal(recorder.Body.Bytes(), &diff); err != nil || diff.From != 2 || diff.To != 3 {
+			t.Error("expecting the diff of the last two revisions left got", recorder.Body.String())
+		}
+		if recorder = diffRequest(revisionsApi, diffPath+"?from=1", ""); recorder.Code != http.StatusNotFound {
+			t.Error("expecting a purged revision not found got", recorder.Code)
+		}
+
+		// the latest revision is kept however old
+		clock.Advance(24 * time.Hour)
+		if purged := revisionsApi.purgeExpiredRevisions(); purged != 1 {
+			t.Error("expecting the second revision purged got", purged)
+		}
+		response, err := testResponse(http.MethodGet, libraryBookRevisionPath, revisionsApi.getBookRevision, nil, http.StatusOK,
+			map[string]string{paramLibrary: lib.DefaultLibraryName, paramID: book.ID, paramRevision: "3"})
+		if err != nil || response != "the third" {
+			t.Error("expecting the latest revision kept got", response, err)
+		}
+		if recorder = diffRequest(revisionsApi, diffPath, ""); recorder.Code != http.StatusOK {
+			t.Error("expecting the latest revision diffed with no contents got", recorder.Code)
+		}
+		_, err = testResponse(http.MethodGet, libraryBookRevisionsPath, revisionsApi.getBookRevisions, nil, http.StatusOK, params)
+		if err != nil {
+			t.Error(err)
+		}
+		response, err = testResponse(http.MethodGet, libraryBookContentsPath, revisionsApi.getBookContents, nil, http.StatusOK, params)
+		if err != nil || response != "the third" {
+			t.Error("expecting the contents of the book kept got", response, err)
+		}
+	})
+}
+
+// diffRequest gets a diff through the router with an Accept header, returning the recorded response
+func diffRequest(service *RestService, url, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	recorder := httptest.NewRecorder()
+	service.router.ServeHTTP(recorder, req)
+	return recorder
+}
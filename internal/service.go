@@ -6,9 +6,14 @@ import (
 	"encoding/json"
 	"errors"
 	"github.com/gorilla/mux"
+	"github.com/graphql-go/graphql"
+	"golang.org/x/text/language"
+	"google.golang.org/grpc"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"time"
 )
 
 const (
@@ -20,12 +25,80 @@ const (
 	deleteBookPath = BasePath + "/delete/{" + paramName + "}/{" + paramAuthor + "}"
 	paramAuthor    = "author"
 	paramName      = "name"
+	paramID        = "id"
 )
 
 type RestService struct {
 	db     db.RestDbInterface
 	router *mux.Router
 	port   string
+
+	blobs             db.BlobStoreInterface // attachments are disabled when nil
+	blobLocks         *blobLocks
+	maxAttachmentSize int64
+
+	grpcPort string // the gRPC api is disabled when empty
+	grpc     *grpc.Server
+
+	graphql graphql.Schema
+
+	renders    *renderCache       // contents rendered into HTML and plain text
+	similarity *similarityIndexes // TF-IDF vectors of the books of each library
+
+	webhooks              db.WebhookStoreInterface // webhooks are disabled when nil
+	webhookClient         *http.Client
+	webhookWake           chan struct{}
+	webhookPrivateTargets bool // webhooks may post to private, loopback and link-local addresses
+
+	lending            db.LendingStoreInterface // lending is disabled when nil
+	loanPeriod         time.Duration
+	maxRenewals        int
+	pickupWindow       time.Duration // how long a copy set aside for a hold waits for its patron
+	holdExpiryInterval time.Duration
+	lendingLocks       *blobLocks // locks of the books being lent or deleted, by library and book id
+
+	limiter    *lib.RateLimiter       // requests are not rate limited when nil
+	quotas     db.QuotaStoreInterface // daily quotas are disabled when nil
+	dailyQuota int64                  // requests a client may make a UTC day
+	apiKeys    map[string]bool        // keys clients are limited by rather than by address
+	trustProxy bool                   // client addresses are taken from X-Forwarded-For
+
+	clock              lib.Clock
+	trashRetention     time.Duration // books are purged from the trash this long after deletion, never when 0
+	trashPurgeInterval time.Duration
+	revisionRetention  time.Duration // revisions but the latest are purged this long after being written, never when 0
+	stop               chan struct{}
+}
+
+// ServiceOption configures an optional part of the rest api
+type ServiceOption func(service *RestService)
+
+// WithBlobStore enables book attachments, stored in the given blob store
+func WithBlobStore(blobs db.BlobStoreInterface) ServiceOption {
+	return func(service *RestService) {
+		service.blobs = blobs
+	}
+}
+
+// WithMaxAttachmentSize limits the size in bytes of a single uploaded attachment
+func WithMaxAttachmentSize(size int64) ServiceOption {
+	return func(service *RestService) {
+		service.maxAttachmentSize = size
+	}
+}
+
+// WithTrashRetention purges deleted books from the trash once they have been there longer than retention, 0 keeps them until purged by hand
+func WithTrashRetention(retention time.Duration) ServiceOption {
+	return func(service *RestService) {
+		service.trashRetention = retention
+	}
+}
+
+// WithClock sets the clock deciding when deleted books are due for purging
+func WithClock(clock lib.Clock) ServiceOption {
+	return func(service *RestService) {
+		service.clock = clock
+	}
 }
 
 // Start starts rest api
@@ -37,24 +110,87 @@ func (r *RestService) Start() {
 		}
 	}()
 	log.Printf("rest started on port %s\n", r.port)
+	if r.grpc != nil {
+		err := r.startGrpc()
+		if err != nil {
+			panic(err)
+		}
+	}
+	go r.rebuildSimilarityIndexes()
+	if r.trashRetention > 0 {
+		go r.purgeTrashPeriodically()
+	}
+	if r.revisionRetention > 0 {
+		go r.purgeRevisionsPeriodically()
+	}
+	if r.webhooks != nil {
+		go r.deliverWebhooksPeriodically()
+	}
+	if r.lending != nil {
+		go r.expireHoldsPeriodically()
+	}
 }
 
 // Stop stops rest api
 func (r *RestService) Stop() {
+	close(r.stop)
+	if r.grpc != nil {
+		r.grpc.GracefulStop()
+	}
 	r.db.Disconnect()
+	if r.blobs != nil {
+		r.blobs.Disconnect()
+	}
+	if r.webhooks != nil {
+		r.webhooks.Disconnect()
+	}
+	if r.lending != nil {
+		r.lending.Disconnect()
+	}
+	if r.quotas != nil {
+		r.quotas.Disconnect()
+	}
 	stdInfo("stopped restapi")
 }
 
-// CreateRestApiService creates a restapi given a db interface
-func CreateRestApiService(db db.RestDbInterface, port string) (*RestService, error) {
+// CreateRestApiService creates a restapi given a db interface and optional features
+func CreateRestApiService(db db.RestDbInterface, port string, options ...ServiceOption) (*RestService, error) {
 
 	stdInfo("creating rest api")
 	router := mux.NewRouter()
 	restAPi := &RestService{
-		db:     db,
-		router: router,
-		port:   port,
+		db:                db,
+		router:            router,
+		port:              port,
+		maxAttachmentSize: defaultMaxAttachmentSize,
+		blobLocks:         newBlobLocks(),
+		lendingLocks:      newBlobLocks(),
+		renders:           newRenderCache(defaultRenderCacheSize),
+		similarity:        newSimilarityIndexes(),
+
+		clock:              lib.SystemClock{},
+		trashPurgeInterval: defaultTrashPurgeInterval,
+		stop:               make(chan struct{}),
+
+		webhookClient: newWebhookClient(false),
+		webhookWake:   make(chan struct{}, 1),
+
+		loanPeriod:         defaultLoanPeriod,
+		maxRenewals:        defaultMaxRenewals,
+		pickupWindow:       defaultPickupWindow,
+		holdExpiryInterval: defaultHoldExpiryInterval,
 	}
+	for _, option := range options {
+		option(restAPi)
+	}
+	if restAPi.grpcPort != "" {
+		restAPi.grpc = restAPi.newGrpcServer()
+	}
+	schema, err := restAPi.newGraphqlSchema()
+	if err != nil {
+		return nil, err
+	}
+	restAPi.graphql = schema
 
 	// Define endpoints
 	router.HandleFunc(getBooksPath, restAPi.getBooks).Methods(http.MethodGet)
@@ -62,13 +198,128 @@ func CreateRestApiService(db db.RestDbInterface, port string) (*RestService, err
 	router.HandleFunc(getBookPath, restAPi.getBook).Methods(http.MethodGet)
 	router.HandleFunc(updateBookPath, restAPi.updateBook).Methods(http.MethodPut)
 	router.HandleFunc(deleteBookPath, restAPi.deleteBook).Methods(http.MethodDelete)
+
+	// Define multi library endpoints
+	router.HandleFunc(LibrariesPath, restAPi.getLibraries).Methods(http.MethodGet)
+	router.HandleFunc(libraryPath, restAPi.createLibrary).Methods(http.MethodPut)
+	router.HandleFunc(libraryPath, restAPi.dropLibrary).Methods(http.MethodDelete)
+	router.HandleFunc(libraryStatsPath, restAPi.getLibraryStats).Methods(http.MethodGet)
+	router.HandleFunc(libraryFacetsPath, restAPi.getLibraryFacets).Methods(http.MethodGet)
+	router.HandleFunc(libraryBooksPath, restAPi.getBooks).Methods(http.MethodGet)
+	router.HandleFunc(libraryBooksPath, restAPi.createBook).Methods(http.MethodPut)
+	router.HandleFunc(libraryBooksEPUBPath, restAPi.importEPUB).Methods(http.MethodPut) // before libraryBookPath, which would match it
+	router.HandleFunc(libraryBooksSuggestionsPath, restAPi.getBookSuggestions).Methods(http.MethodGet)
+	router.HandleFunc(libraryBookPath, restAPi.getBook).Methods(http.MethodGet)
+	router.HandleFunc(libraryBookPath, restAPi.updateBook).Methods(http.MethodPut)
+	router.HandleFunc(libraryBookPath, restAPi.deleteBook).Methods(http.MethodDelete)
+	router.HandleFunc(libraryBookContentsPath, restAPi.getBookContents).Methods(http.MethodGet)
+	router.HandleFunc(libraryBookContentsPath, restAPi.storeBookContents).Methods(http.MethodPut)
+	router.HandleFunc(libraryBookChaptersPath, restAPi.getBookChapters).Methods(http.MethodGet)
+	router.HandleFunc(libraryBookPagePath, restAPi.getBookPage).Methods(http.MethodGet)
+	router.HandleFunc(libraryBookEPUBPath, restAPi.exportEPUB).Methods(http.MethodGet)
+	router.HandleFunc(libraryBookRevisionsPath, restAPi.getBookRevisions).Methods(http.MethodGet)
+	router.HandleFunc(libraryBookRevisionPath, restAPi.getBookRevision).Methods(http.MethodGet)
+	router.HandleFunc(libraryBookDiffPath, restAPi.getBookDiff).Methods(http.MethodGet)
+	router.HandleFunc(libraryBookSimilarPath, restAPi.getSimilarBooks).Methods(http.MethodGet)
+	router.HandleFunc(libraryChangesPath, restAPi.streamChanges).Methods(http.MethodGet)
+	router.HandleFunc(libraryChangesWsPath, restAPi.streamChangesWebSocket).Methods(http.MethodGet)
+	router.HandleFunc(trashPath, restAPi.getTrash).Methods(http.MethodGet)
+	router.HandleFunc(trashPath, restAPi.emptyTrash).Methods(http.MethodDelete)
+	router.HandleFunc(trashBookPath, restAPi.purgeBook).Methods(http.MethodDelete)
+	router.HandleFunc(trashRestorePath, restAPi.restoreBook).Methods(http.MethodPut)
+	router.HandleFunc(authorsPath, restAPi.getAuthors).Methods(http.MethodGet)
+	router.HandleFunc(authorsPath, restAPi.createAuthor).Methods(http.MethodPut)
+	router.HandleFunc(authorPath, restAPi.getAuthor).Methods(http.MethodGet)
+	router.HandleFunc(authorPath, restAPi.updateAuthor).Methods(http.MethodPut)
+	router.HandleFunc(authorPath, restAPi.deleteAuthor).Methods(http.MethodDelete)
+	router.HandleFunc(authorBooksPath, restAPi.getAuthorBooks).Methods(http.MethodGet)
+	router.HandleFunc(authorMergePath, restAPi.mergeAuthor).Methods(http.MethodPut)
+	router.HandleFunc(reviewsPath, restAPi.getReviews).Methods(http.MethodGet)
+	router.HandleFunc(reviewsPath, restAPi.createReview).Methods(http.MethodPut)
+	router.HandleFunc(reviewPath, restAPi.getReview).Methods(http.MethodGet)
+	router.HandleFunc(reviewPath, restAPi.updateReview).Methods(http.MethodPut)
+	router.HandleFunc(reviewPath, restAPi.deleteReview).Methods(http.MethodDelete)
+	router.HandleFunc(reviewModerationPath, restAPi.moderateReview).Methods(http.MethodPut)
+	router.HandleFunc(reviewHelpfulPath, restAPi.voteReviewHelpful).Methods(http.MethodPut)
+	router.HandleFunc(shelvesPath, restAPi.getShelves).Methods(http.MethodGet)
+	router.HandleFunc(shelvesPath, restAPi.createShelf).Methods(http.MethodPut)
+	router.HandleFunc(shelfPath, restAPi.getShelf).Methods(http.MethodGet)
+	router.HandleFunc(shelfPath, restAPi.updateShelf).Methods(http.MethodPut)
+	router.HandleFunc(shelfPath, restAPi.deleteShelf).Methods(http.MethodDelete)
+	router.HandleFunc(shelfBooksPath, restAPi.getShelfBooks).Methods(http.MethodGet)
+	router.HandleFunc(shelfBooksPath, restAPi.addShelfBooks).Methods(http.MethodPut)
+	router.HandleFunc(shelfBooksPath, restAPi.removeShelfBooks).Methods(http.MethodDelete)
+	router.HandleFunc(shelfOrderPath, restAPi.reorderShelfBooks).Methods(http.MethodPut)
+	router.HandleFunc(readingProgressPath, restAPi.getReadingProgress).Methods(http.MethodGet)
+	router.HandleFunc(readingProgressPath, restAPi.saveReadingProgress).Methods(http.MethodPut)
+	router.HandleFunc(bookmarksPath, restAPi.getBookmarks).Methods(http.MethodGet)
+	router.HandleFunc(bookmarksPath, restAPi.createBookmark).Methods(http.MethodPut)
+	router.HandleFunc(bookmarkPath, restAPi.getBookmark).Methods(http.MethodGet)
+	router.HandleFunc(bookmarkPath, restAPi.updateBookmark).Methods(http.MethodPut)
+	router.HandleFunc(bookmarkPath, restAPi.deleteBookmark).Methods(http.MethodDelete)
+	router.HandleFunc(readingPath, restAPi.getReadingState).Methods(http.MethodGet)
+	router.HandleFunc(readingPath, restAPi.syncReadingState).Methods(http.MethodPut)
+	router.HandleFunc(graphqlPath, restAPi.queryGraphql).Methods(http.MethodGet, http.MethodPost)
+
+	if restAPi.blobs != nil {
+		router.HandleFunc(attachmentsPath, restAPi.getAttachments).Methods(http.MethodGet)
+		router.HandleFunc(attachmentsPath, restAPi.createAttachment).Methods(http.MethodPut)
+		router.HandleFunc(attachmentPath, restAPi.getAttachment).Methods(http.MethodGet)
+		router.HandleFunc(attachmentPath, restAPi.deleteAttachment).Methods(http.MethodDelete)
+		router.HandleFunc(attachmentThumbnailPath, restAPi.getAttachmentThumbnail).Methods(http.MethodGet)
+	}
+	if restAPi.webhooks != nil {
+		router.HandleFunc(webhooksPath, restAPi.getWebhooks).Methods(http.MethodGet)
+		router.HandleFunc(webhooksPath, restAPi.createWebhook).Methods(http.MethodPut)
+		router.HandleFunc(deadLettersPath, restAPi.getDeadLetters).Methods(http.MethodGet) // before webhookPath, which would match it
+		router.HandleFunc(webhookPath, restAPi.getWebhook).Methods(http.MethodGet)
+		router.HandleFunc(webhookPath, restAPi.deleteWebhook).Methods(http.MethodDelete)
+		router.HandleFunc(deliveriesPath, restAPi.getDeliveries).Methods(http.MethodGet)
+		router.HandleFunc(deliveryRetryPath, restAPi.retryDelivery).Methods(http.MethodPut)
+	}
+	if restAPi.lending != nil {
+		router.HandleFunc(patronsPath, restAPi.getPatrons).Methods(http.MethodGet)
+		router.HandleFunc(patronsPath, restAPi.createPatron).Methods(http.MethodPut)
+		router.HandleFunc(patronPath, restAPi.getPatron).Methods(http.MethodGet)
+		router.HandleFunc(patronPath, restAPi.deletePatron).Methods(http.MethodDelete)
+		router.HandleFunc(patronLoansPath, restAPi.getPatronLoans).Methods(http.MethodGet)
+		router.HandleFunc(bookCopiesPath, restAPi.getBookCopies).Methods(http.MethodGet)
+		router.HandleFunc(bookCopiesPath, restAPi.setBookCopies).Methods(http.MethodPut)
+		router.HandleFunc(bookLoansPath, restAPi.getBookLoans).Methods(http.MethodGet)
+		router.HandleFunc(bookLoansPath, restAPi.checkoutBook).Methods(http.MethodPut)
+		router.HandleFunc(bookHoldsPath, restAPi.getBookHolds).Methods(http.MethodGet)
+		router.HandleFunc(bookHoldsPath, restAPi.placeHold).Methods(http.MethodPut)
+		router.HandleFunc(holdPath, restAPi.getHold).Methods(http.MethodGet)
+		router.HandleFunc(holdPath, restAPi.cancelHold).Methods(http.MethodDelete)
+		router.HandleFunc(patronHoldsPath, restAPi.getPatronHolds).Methods(http.MethodGet)
+		router.HandleFunc(loansPath, restAPi.getLoans).Methods(http.MethodGet)
+		router.HandleFunc(loanPath, restAPi.getLoan).Methods(http.MethodGet)
+		router.HandleFunc(loanReturnPath, restAPi.returnLoan).Methods(http.MethodPut)
+		router.HandleFunc(loanRenewPath, restAPi.renewLoan).Methods(http.MethodPut)
+	}
+	if restAPi.limiter != nil || restAPi.quotas != nil {
+		router.Use(restAPi.limitRequests)
+	}
 	return restAPi, nil
 }
 
-// getBooks Retrieves a full list (name and author) of every book stored in db
+// getBooks Retrieves a full list (name and author) of every book stored in db, optionally filtered by query parameters.
+// eg : api/library/getlist?author=JKR&tag=fantasy&tag=school&language=en&year=1998
 func (r *RestService) getBooks(writer http.ResponseWriter, request *http.Request) {
 	stdInfo("received get Books request")
-	books, err := r.db.GetAllBooks()
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+
+	filter, err := r.createBookFilterFromQuery(request.URL.Query())
+	if err != nil {
+		r.restResponse(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	books, err := library.GetAllBooks(filter)
 	if err != nil {
 		stdError(err.Error())
 		r.restResponse(writer, http.StatusInternalServerError, err.Error())
@@ -77,11 +328,17 @@ func (r *RestService) getBooks(writer http.ResponseWriter, request *http.Request
 	r.restResponse(writer, http.StatusOK, books)
 }
 
-// getBook Retrieves a single book from the db given the name and author in the path.
-// eg : api/library/get/{name}/{author}
+// getBook Retrieves a single book from the db given the name and author, or the id, in the path.
+// Names and authors are matched ignoring case and accents, a missing book is answered with the books it may have meant.
+// eg : api/library/get/{name}/{author} or api/libraries/{library}/books/{id}
 func (r *RestService) getBook(writer http.ResponseWriter, request *http.Request) {
 	stdInfo("received get Book request")
 	params := mux.Vars(request)
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
 
 	bookIdentifier, err := r.createBookIdentifierFromParams(params)
 	if err != nil {
@@ -89,10 +346,10 @@ func (r *RestService) getBook(writer http.ResponseWriter, request *http.Request)
 		return
 	}
 
-	returnedBook, err := r.db.GetOneBook(bookIdentifier)
+	returnedBook, err := library.GetOneBook(bookIdentifier)
 	if err != nil {
 		if errors.Is(err, lib.NoMatchingBook) {
-			r.restResponse(writer, http.StatusBadRequest, err.Error())
+			r.restBookNotFound(writer, library, bookIdentifier)
 			return
 		}
 		stdError(err.Error())
@@ -105,6 +362,11 @@ func (r *RestService) getBook(writer http.ResponseWriter, request *http.Request)
 // createBook Creates stores a new book into the db
 func (r *RestService) createBook(writer http.ResponseWriter, request *http.Request) {
 	stdInfo("received create Books request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
 
 	book, err := r.unmarshalAndValidateStoreBookRequest(request.Body)
 	if err != nil {
@@ -112,7 +374,7 @@ func (r *RestService) createBook(writer http.ResponseWriter, request *http.Reque
 		return
 	}
 
-	err = r.db.CreateNewBook(book)
+	err = library.CreateNewBook(book)
 	if err != nil {
 		if errors.Is(lib.BookAlreadyExists, err) {
 			r.restResponse(writer, http.StatusBadRequest, err.Error())
@@ -123,22 +385,31 @@ func (r *RestService) createBook(writer http.ResponseWriter, request *http.Reque
 		return
 	}
 
+	r.notifyWebhooks(r.libraryNameFromRequest(request), lib.ChangeCreated, book.Identifier())
+	writer.Header().Set("Location", libraryBookLocation(r.libraryNameFromRequest(request), book.ID))
 	r.restResponse(writer, http.StatusOK, nil)
 }
 
-// updateBook Updates an existing book in the db
+// updateBook Updates an existing book in the db, matched by the id in the path when given, otherwise by name and author
 func (r *RestService) updateBook(writer http.ResponseWriter, request *http.Request) {
 	stdInfo("received update Book request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+
 	book, err := r.unmarshalAndValidateStoreBookRequest(request.Body)
 	if err != nil {
 		stdError(err.Error())
 		r.restResponse(writer, http.StatusBadRequest, err.Error())
 		return
 	}
+	book.ID = mux.Vars(request)[paramID]
 
-	err = r.db.UpdateExistingBook(book)
+	err = library.UpdateExistingBook(book)
 	if err != nil {
-		if errors.Is(err, lib.NoMatchingBook) {
+		if errors.Is(err, lib.NoMatchingBook) || errors.Is(err, lib.BookAlreadyExists) {
 			r.restResponse(writer, http.StatusBadRequest, err.Error())
 			return
 		}
@@ -146,37 +417,73 @@ func (r *RestService) updateBook(writer http.ResponseWriter, request *http.Reque
 		r.restResponse(writer, http.StatusInternalServerError, err.Error())
 		return
 	}
+	r.notifyWebhooks(r.libraryNameFromRequest(request), lib.ChangeUpdated, book.Identifier())
 	r.restResponse(writer, http.StatusOK, nil)
 }
 
-// deleteBook deletes an existing book in the db given the name and author in the path.
-// eg : api/library/get/{name}/{author}
+// deleteBook moves an existing book in the db to the trash given the name and author, or the id, in the path.
+// eg : api/library/delete/{name}/{author} or api/libraries/{library}/books/{id}
 func (r *RestService) deleteBook(writer http.ResponseWriter, request *http.Request) {
 	stdInfo("received delete Book request")
 	params := mux.Vars(request)
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+
 	bookIdentifier, err := r.createBookIdentifierFromParams(params)
 	if err != nil {
 		r.restResponse(writer, http.StatusBadRequest, lib.IncorrectParameters)
 		return
 	}
 
-	err = r.db.DeleteBook(bookIdentifier)
+	err = r.trashBook(library, r.libraryNameFromRequest(request), bookIdentifier)
 	if err != nil {
 		if errors.Is(err, lib.NoMatchingBook) {
 			r.restResponse(writer, http.StatusNotFound, err.Error())
 			return
 		}
+		if errors.Is(err, lib.BookHasActiveLoans) {
+			r.restResponse(writer, http.StatusBadRequest, err.Error())
+			return
+		}
 		r.restResponse(writer, http.StatusInternalServerError, err.Error())
 		return
 	}
 	r.restResponse(writer, http.StatusOK, nil)
 }
 
+// trashBook moves a book to the trash and notifies webhooks, which fails while copies of the book are on loan
+func (r *RestService) trashBook(library db.RestDbInterface, libraryName string, bookIdentifier *lib.BookIdentifier) error {
+	if bookIdentifier.ID == "" && (r.webhooks != nil || r.lending != nil) {
+		// webhooks are told the id of the deleted book, and loans are kept by book id
+		if book, err := library.GetOneBookWithFields(bookIdentifier, nil); err == nil {
+			bookIdentifier.ID = book.ID
+		}
+	}
+	if r.lending != nil {
+		// copies cannot be lent or held between the check and the delete
+		unlock := r.lendingLocks.acquire(libraryName, bookIdentifier.ID)
+		defer unlock()
+	}
+	err := r.checkNoActiveLoans(libraryName, bookIdentifier.ID)
+	if err != nil {
+		return err
+	}
+	err = library.DeleteBook(bookIdentifier)
+	if err != nil {
+		return err
+	}
+	r.notifyWebhooks(libraryName, lib.ChangeDeleted, *bookIdentifier)
+	return nil
+}
+
 // restResponse reponds to a rest call given the Writer status and data to write.
 // Assumes "Content-Type", "application/json"
 func (r *RestService) restResponse(writer http.ResponseWriter, status int, data any) {
-	writer.WriteHeader(status)
 	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
 
 	if data == nil {
 		_, err := writer.Write(nil)
@@ -208,16 +515,83 @@ func (r *RestService) unmarshalAndValidateStoreBookRequest(body io.ReadCloser) (
 	if err != nil {
 		return nil, err
 	}
-	if book.Name == "" || book.Author == "" || book.Contents == "" {
-		return nil, errors.New("not enough information to store book")
+	err = r.validateStoreBookRequest(book)
+	if err != nil {
+		return nil, err
 	}
 	return book, nil
 }
 
-// createBookIdentifierFromParams returns a bookIdentifier object, given a map of parameters that must contain "name" and "author" keys with non-empty values.
+// validateStoreBookRequest validates a book to store has no empty fields and normalises its metadata
+func (r *RestService) validateStoreBookRequest(book *lib.Book) error {
+	if book.Name == "" || book.Author == "" || book.Contents == "" {
+		return lib.IncompleteBook
+	}
+	return book.NormaliseMetadata()
+}
+
+// createBookFilterFromQuery returns a book filter from the list query parameters isbn, author, publisher, language, series, year,
+// any number of tag, and updatedAfter and updatedBefore as RFC 3339 timestamps.
+func (r *RestService) createBookFilterFromQuery(query url.Values) (lib.BookFilter, error) {
+	filter := lib.BookFilter{
+		ISBN:      query.Get("isbn"),
+		Author:    query.Get(paramAuthor),
+		Publisher: query.Get("publisher"),
+		Language:  query.Get("language"),
+		Series:    query.Get("series"),
+		Year:      query.Get("year"),
+		Tags:      query["tag"],
+	}
+	err := r.normaliseBookFilter(&filter)
+	if err != nil {
+		return filter, err
+	}
+	if after := query.Get("updatedAfter"); after != "" {
+		filter.UpdatedAfter, err = time.Parse(time.RFC3339Nano, after)
+		if err != nil {
+			return filter, lib.IncorrectTimestamp
+		}
+	}
+	if before := query.Get("updatedBefore"); before != "" {
+		filter.UpdatedBefore, err = time.Parse(time.RFC3339Nano, before)
+		if err != nil {
+			return filter, lib.IncorrectTimestamp
+		}
+	}
+	return filter, nil
+}
+
+// normaliseBookFilter brings the isbn, language and tags of a filter to the form books are stored in
+func (r *RestService) normaliseBookFilter(filter *lib.BookFilter) error {
+	if filter.ISBN != "" {
+		_, isbn13, err := lib.NormaliseISBN(filter.ISBN)
+		if err != nil {
+			return err
+		}
+		filter.ISBN = isbn13
+	}
+	if filter.Language != "" {
+		parsed, err := language.Parse(filter.Language)
+		if err != nil {
+			return lib.IncorrectLanguage
+		}
+		filter.Language = parsed.String()
+	}
+	filter.Tags = lib.NormaliseTags(filter.Tags)
+	return nil
+}
+
+// createBookIdentifierFromParams returns a bookIdentifier object, given a map of parameters that must contain either a non-empty "id" key
+// or "name" and "author" keys with non-empty values.
 func (r *RestService) createBookIdentifierFromParams(params map[string]string) (*lib.BookIdentifier, error) {
 	var name, author string
 	var exists bool
+	if id, exists := params[paramID]; exists {
+		if id == "" {
+			return nil, errors.New("empty argument")
+		}
+		return &lib.BookIdentifier{ID: id}, nil
+	}
 	if name, exists = params[paramName]; !exists {
 		return nil, errors.New("not enough arguments")
 	}
@@ -10,13 +10,18 @@ import (
 	"github.com/gorilla/mux"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
 
 var api *RestService
 
+var testClock = lib.NewManualClock(time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC))
+
 var (
 	defaultBook1 = lib.Book{
 		Name:     "book1",
@@ -73,7 +78,8 @@ func TestCreateBook(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	defaultBook1.UpdatedDate = time.Now().Format(lib.DbTimeFormat)
+	defaultBook1.CreatedAt = testClock.Now()
+	defaultBook1.UpdatedAt = testClock.Now()
 	if response != "" {
 		t.Error("expecting", "", "got", response)
 	}
@@ -96,7 +102,8 @@ func TestCreateBook(t *testing.T) {
 	if err != nil {
 		t.Error()
 	}
-	defaultBook2.UpdatedDate = time.Now().Format(lib.DbTimeFormat)
+	defaultBook2.CreatedAt = testClock.Now()
+	defaultBook2.UpdatedAt = testClock.Now()
 	if response != "" {
 		t.Error("expecting", "", "got", response)
 	}
@@ -109,7 +116,8 @@ func TestCreateBook(t *testing.T) {
 	if err != nil {
 		t.Error()
 	}
-	defaultBook3.UpdatedDate = time.Now().Format(lib.DbTimeFormat)
+	defaultBook3.CreatedAt = testClock.Now()
+	defaultBook3.UpdatedAt = testClock.Now()
 	if response != "" {
 		t.Error("expecting", "", "got", response)
 	}
@@ -118,6 +126,7 @@ func TestCreateBook(t *testing.T) {
 func TestUpdateBook(t *testing.T) {
 
 	////update book1 with book1 updated
+	testClock.Advance(time.Minute)
 	marshalDefaultBook1, err := json.Marshal(defaultBook1Updated)
 	if err != nil {
 		return
@@ -126,7 +135,8 @@ func TestUpdateBook(t *testing.T) {
 	if err != nil {
 		t.Error()
 	}
-	defaultBook1.UpdatedDate = time.Now().Format(lib.DbTimeFormat)
+	defaultBook1Updated.CreatedAt = defaultBook1.CreatedAt
+	defaultBook1Updated.UpdatedAt = testClock.Now()
 	if response != "" {
 		t.Error("expecting", "", "got", response)
 	}
@@ -150,6 +160,40 @@ func TestUpdateBook(t *testing.T) {
 	}
 }
 
+// TestClearBookMetadata checks that every backend clears the metadata an update leaves out, keeping the rating and trash state
+func TestClearBookMetadata(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, handler db.RestDbInterface, clock *lib.ManualClock) {
+		book := &lib.Book{Name: "the hobbit", Author: "Tolkien", Contents: "In a hole in the ground.", ISBN10: "0261102214",
+			ISBN13: "9780261102217", Contributors: []lib.Contributor{{Name: "Alan Lee", Role: "illustrator"}}, Publisher: "Allen & Unwin",
+			PublicationDate: "1937", Language: "en", PageCount: 310, Series: "Middle-earth", Volume: 1, Tags: []string{"fantasy"},
+			ChapterPattern: "^Chapter"}
+		err := handler.CreateNewBook(book)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		clock.Advance(time.Second)
+		update := &lib.Book{ID: book.ID, Name: "the hobbit", Author: "Tolkien", Contents: "In a hole in the ground.", DeletedAt: &book.CreatedAt}
+		err = handler.UpdateExistingBook(update)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored, err := handler.GetOneBook(&lib.BookIdentifier{ID: book.ID})
+		if err != nil {
+			t.Fatal("expecting the book kept out of the trash got", err)
+		}
+		cleared := lib.Book{ID: stored.ID, Name: stored.Name, Author: stored.Author, Contents: stored.Contents, CreatedAt: stored.CreatedAt,
+			UpdatedAt: stored.UpdatedAt, ContentsLength: stored.ContentsLength, ContentsFile: stored.ContentsFile, ContentsHash: stored.ContentsHash,
+			Structure: stored.Structure, Stats: stored.Stats, Terms: stored.Terms}
+		if !reflect.DeepEqual(*stored, cleared) {
+			t.Errorf("expecting the metadata cleared got %+v", *stored)
+		}
+		if !stored.CreatedAt.Equal(book.CreatedAt) || stored.Stats == nil {
+			t.Error("expecting the creation time and statistics kept got", stored.CreatedAt, stored.Stats)
+		}
+	})
+}
+
 func TestGetBook(t *testing.T) {
 
 	//Test Book 1, should be updated
@@ -184,12 +228,14 @@ func TestGetBook(t *testing.T) {
 
 	//fail on getting books that dont exist
 	paramMap = map[string]string{paramName: "doesnt exits", paramAuthor: "philip"}
-	response, err = testResponse(http.MethodPut, updateBookPath, api.getBook, nil, http.StatusBadRequest, paramMap)
+	response, err = testResponse(http.MethodPut, updateBookPath, api.getBook, nil, http.StatusNotFound, paramMap)
 	if err != nil {
 		t.Error()
 	}
-	if response != "\""+lib.NoMatchingBook.Error()+"\"" {
-		t.Error("expecting", "\""+lib.NoMatchingBook.Error()+"\"", "got", response)
+	var notFound bookNotFound
+	err = json.Unmarshal([]byte(response), &notFound)
+	if err != nil || notFound.Error != lib.NoMatchingBook.Error() {
+		t.Error("expecting", lib.NoMatchingBook.Error(), "got", response)
 	}
 }
 
@@ -249,8 +295,184 @@ func TestGetBooks(t *testing.T) {
 	}
 }
 
+func TestBookMetadataFilters(t *testing.T) {
+	metadataApi, err := createMockApi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	books := []lib.Book{
+		{Name: "chamber", Author: "JKR", Contents: "A snake read", ISBN13: "978-0-306-40615-7", Language: "en-gb",
+			PublicationDate: "1998-07-02", Series: "Harry Potter", Volume: 2, Tags: []string{"Fantasy", "school"}},
+		{Name: "translated", Author: "someone", Contents: "A foreign read", Language: "fr", PublicationDate: "1998",
+			Contributors: []lib.Contributor{{Name: "JKR", Role: "translator"}}, Tags: []string{"fantasy"}},
+		{Name: "unrelated", Author: "philip", Contents: "A plain read"},
+	}
+	for _, book := range books {
+		marshalBook, err := json.Marshal(book)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = testResponse(http.MethodPut, createBookPath, metadataApi.createBook, marshalBook, http.StatusOK, nil)
+		if err != nil {
+			t.Error(book.Name, err)
+		}
+	}
+
+	// normalised on the way in
+	stored, err := metadataApi.db.GetOneBook(&lib.BookIdentifier{Name: "chamber", Author: "JKR"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.ISBN10 != "0306406152" || stored.Language != "en-GB" || len(stored.Tags) != 2 || stored.Tags[0] != "fantasy" {
+		t.Error("expecting normalised metadata got", stored)
+	}
+
+	// invalid metadata is refused
+	marshalBad, err := json.Marshal(lib.Book{Name: "bad", Author: "philip", Contents: "A bad read", ISBN10: "0-306-40615-3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := testResponse(http.MethodPut, createBookPath, metadataApi.createBook, marshalBad, http.StatusBadRequest, nil)
+	if err != nil {
+		t.Error(err)
+	}
+	if response != "\""+lib.IncorrectISBN.Error()+"\"" {
+		t.Error("expecting", lib.IncorrectISBN.Error(), "got", response)
+	}
+
+	filters := map[string][]string{
+		"?tag=fantasy":                   {"chamber", "translated"},
+		"?tag=fantasy&tag=School":        {"chamber"},
+		"?author=JKR":                    {"chamber", "translated"},
+		"?isbn=0306406152":               {"chamber"},
+		"?language=EN-gb":                {"chamber"},
+		"?year=1998&series=Harry+Potter": {"chamber"},
+		"?publisher=nobody":              {},
+	}
+	for query, expected := range filters {
+		response, err = testResponse(http.MethodGet, getBooksPath+query, metadataApi.getBooks, nil, http.StatusOK, nil)
+		if err != nil {
+			t.Error(query, err)
+			continue
+		}
+		var identifiers []lib.BookIdentifier
+		err = json.Unmarshal([]byte(response), &identifiers)
+		if err != nil {
+			t.Error(query, err)
+			continue
+		}
+		if len(identifiers) != len(expected) {
+			t.Error(query, "expecting", expected, "got", identifiers)
+			continue
+		}
+		for _, name := range expected {
+			found := false
+			for _, identifier := range identifiers {
+				found = found || identifier.Name == name
+			}
+			if !found {
+				t.Error(query, "didnt find", name)
+			}
+		}
+	}
+
+	_, err = testResponse(http.MethodGet, getBooksPath+"?isbn=123", metadataApi.getBooks, nil, http.StatusBadRequest, nil)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBookTimestamps(t *testing.T) {
+	clock := lib.NewManualClock(time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC))
+	mockConn, err := db.CreateMockDBHandlerWithClock(clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timestampApi, err := CreateRestApiService(mockConn, "8081")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, book := range []lib.Book{defaultBook2, defaultBook3} {
+		book.ID = ""
+		marshalBook, err := json.Marshal(book)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = testResponse(http.MethodPut, createBookPath, timestampApi.createBook, marshalBook, http.StatusOK, nil)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	clock.Advance(90 * time.Minute)
+	updated := defaultBook3
+	updated.Contents = "A less bizarre read"
+	marshalUpdated, err := json.Marshal(updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = testResponse(http.MethodPut, updateBookPath, timestampApi.updateBook, marshalUpdated, http.StatusOK, nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// rendered as RFC 3339, created kept on update
+	paramMap := map[string]string{paramName: defaultBook3.Name, paramAuthor: defaultBook3.Author}
+	response, err := testResponse(http.MethodGet, getBookPath, timestampApi.getBook, nil, http.StatusOK, paramMap)
+	if err != nil {
+		t.Error(err)
+	}
+	if !strings.Contains(response, `"createdAt": "2024-03-01T12:00:00Z"`) || !strings.Contains(response, `"updatedAt": "2024-03-01T13:30:00Z"`) {
+		t.Error("expecting RFC 3339 timestamps got", response)
+	}
+
+	// range query on the update time
+	response, err = testResponse(http.MethodGet, getBooksPath+"?updatedAfter=2024-03-01T13:00:00Z", timestampApi.getBooks, nil, http.StatusOK, nil)
+	if err != nil {
+		t.Error(err)
+	}
+	var identifiers []lib.BookIdentifier
+	err = json.Unmarshal([]byte(response), &identifiers)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(identifiers) != 1 || identifiers[0].Name != defaultBook3.Name {
+		t.Error("expecting only", defaultBook3.Name, "got", identifiers)
+	}
+	_, err = testResponse(http.MethodGet, getBooksPath+"?updatedBefore=yesterday", timestampApi.getBooks, nil, http.StatusBadRequest, nil)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// forEachBackend runs a test against the in-memory backend, and against Mongo when TEST_MONGO_DSN is set, each on a new
+// database with a manual clock starting on 2022-06-01
+func forEachBackend(t *testing.T, test func(t *testing.T, handler db.RestDbInterface, clock *lib.ManualClock)) {
+	t.Run("memory", func(t *testing.T) {
+		clock := lib.NewManualClock(time.Date(2022, time.June, 1, 0, 0, 0, 0, time.UTC))
+		handler, err := db.CreateMockDBHandlerWithClock(clock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		test(t, handler, clock)
+	})
+	t.Run("mongo", func(t *testing.T) {
+		dsn := os.Getenv("TEST_MONGO_DSN")
+		if dsn == "" {
+			t.Skip("TEST_MONGO_DSN not set")
+		}
+		clock := lib.NewManualClock(time.Date(2022, time.June, 1, 0, 0, 0, 0, time.UTC))
+		handler, err := db.CreateMongoDBHandlerWithClock(dsn, "test_"+strconv.FormatInt(time.Now().UnixNano(), 36), "books", clock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer handler.Disconnect()
+		test(t, handler, clock)
+	})
+}
+
 func createMockApi() (*RestService, error) {
-	mockConn, err := db.CreateMockDBHandler()
+	mockConn, err := db.CreateMockDBHandlerWithClock(testClock)
 	if err != nil {
 		return nil, err
 	}
@@ -372,6 +594,32 @@ func testResponse(httpMethod, url string, funcCall func(writer http.ResponseWrit
 	return responseWriter.Body.String(), nil
 }
 
+// decodeTestResponse calls a handler with the body marshalled when given, returning the response decoded when successful.
+// Responses of other statuses and plain messages are left undecoded.
+func decodeTestResponse[T any](t *testing.T, method, url string, handler http.HandlerFunc, body any, expectedStatus int, params map[string]string) T {
+	t.Helper()
+	var input []byte
+	if body != nil {
+		var err error
+		input, err = json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	response, err := testResponse(method, url, handler, input, expectedStatus, params)
+	if err != nil {
+		t.Fatal(method, url, err)
+	}
+	var output T
+	if expectedStatus == http.StatusOK && !strings.HasPrefix(response, "\"") {
+		err = json.Unmarshal([]byte(response), &output)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return output
+}
+
 func sameBookFromHttpRequest(t *testing.T, response string, matchedBook lib.Book) bool {
 	bookRetrieved := &lib.Book{}
 	err := json.Unmarshal([]byte(response), bookRetrieved)
@@ -383,7 +631,8 @@ func sameBookFromHttpRequest(t *testing.T, response string, matchedBook lib.Book
 	if bookRetrieved.Name != matchedBook.Name ||
 		bookRetrieved.Author != matchedBook.Author ||
 		bookRetrieved.Contents != matchedBook.Contents ||
-		bookRetrieved.UpdatedDate != matchedBook.UpdatedDate {
+		!bookRetrieved.CreatedAt.Equal(matchedBook.CreatedAt) ||
+		!bookRetrieved.UpdatedAt.Equal(matchedBook.UpdatedAt) {
 
 		expected, _ := fmt.Printf("%v", matchedBook)
 		retrieved, _ := fmt.Printf("%v", bookRetrieved)
@@ -0,0 +1,242 @@
+package internal
+
+import (
+	"dockerrestapi/db"
+	"dockerrestapi/lib"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+const (
+	shelvesPath    = libraryPath + "/shelves"
+	shelfPath      = shelvesPath + "/{" + paramShelf + "}"
+	shelfBooksPath = shelfPath + "/books"
+	shelfOrderPath = shelfPath + "/order"
+	paramShelf     = "shelf"
+	paramOwner     = "owner"
+
+	userIDHeader = "X-User-Id" // the user making the request, as authenticated upstream
+)
+
+// shelfBooksRequest is the body of a change to the books of a shelf, position only applying to added books
+type shelfBooksRequest struct {
+	BookIDs  []string `json:"bookIds"`
+	Position *int     `json:"position,omitempty"`
+}
+
+// getShelves lists the public shelves and the private ones of the user, only the ones of an owner with owner=.
+// eg : api/libraries/{library}/shelves?owner={userId}
+func (r *RestService) getShelves(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Shelves request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+	filter := lib.ShelfFilter{OwnerID: request.URL.Query().Get(paramOwner), VisibleTo: request.Header.Get(userIDHeader)}
+
+	shelves, err := library.GetAllShelves(filter)
+	if err != nil {
+		r.restShelfError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, shelves)
+}
+
+// createShelf stores a new shelf owned by the user, private unless told otherwise.
+// eg : api/libraries/{library}/shelves with {"name": "to read", "visibility": "public", "bookIds": ["..."]}
+func (r *RestService) createShelf(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received create Shelf request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+	shelf := &lib.Shelf{}
+	err = json.NewDecoder(request.Body).Decode(shelf)
+	if err != nil {
+		r.restResponse(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	shelf.OwnerID = request.Header.Get(userIDHeader)
+
+	err = library.CreateNewShelf(shelf)
+	if err != nil {
+		r.restShelfError(writer, err)
+		return
+	}
+	writer.Header().Set("Location", LibrariesPath+"/"+r.libraryNameFromRequest(request)+"/shelves/"+shelf.ID)
+	r.restResponse(writer, http.StatusOK, shelf)
+}
+
+// getShelf retrieves a shelf given its id in the path, private shelves only for their owner.
+// eg : api/libraries/{library}/shelves/{shelf}
+func (r *RestService) getShelf(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Shelf request")
+	_, shelf, err := r.shelfFromRequest(request, false)
+	if err != nil {
+		r.restShelfError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, shelf)
+}
+
+// updateShelf changes the name, description and visibility of a shelf of the user.
+// eg : api/libraries/{library}/shelves/{shelf} with {"name": "favourites", "visibility": "private"}
+func (r *RestService) updateShelf(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received update Shelf request")
+	library, existing, err := r.shelfFromRequest(request, true)
+	if err != nil {
+		r.restShelfError(writer, err)
+		return
+	}
+	shelf := &lib.Shelf{}
+	err = json.NewDecoder(request.Body).Decode(shelf)
+	if err != nil {
+		r.restResponse(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	shelf.ID = existing.ID
+
+	err = library.UpdateExistingShelf(shelf)
+	if err != nil {
+		r.restShelfError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, shelf)
+}
+
+// deleteShelf deletes a shelf of the user, its books stay in the library.
+// eg : api/libraries/{library}/shelves/{shelf}
+func (r *RestService) deleteShelf(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received delete Shelf request")
+	library, shelf, err := r.shelfFromRequest(request, true)
+	if err != nil {
+		r.restShelfError(writer, err)
+		return
+	}
+	err = library.DeleteShelf(shelf.ID)
+	if err != nil {
+		r.restShelfError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, "shelf deleted")
+}
+
+// getShelfBooks lists the books of a shelf in shelf order, with their name and author.
+// eg : api/libraries/{library}/shelves/{shelf}/books
+func (r *RestService) getShelfBooks(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Shelf Books request")
+	library, shelf, err := r.shelfFromRequest(request, false)
+	if err != nil {
+		r.restShelfError(writer, err)
+		return
+	}
+	books := make([]lib.BookIdentifier, 0, len(shelf.BookIDs))
+	for _, bookID := range shelf.BookIDs {
+		book, err := library.GetOneBookWithFields(&lib.BookIdentifier{ID: bookID}, []string{lib.JsonBsonTagName, lib.JsonBsonTagAuthor})
+		if errors.Is(err, lib.NoMatchingBook) {
+			continue // deleted meanwhile
+		}
+		if err != nil {
+			r.restShelfError(writer, err)
+			return
+		}
+		books = append(books, book.Identifier())
+	}
+	r.restResponse(writer, http.StatusOK, books)
+}
+
+// addShelfBooks adds books to a shelf of the user at a position, at the end when none is given.
+// eg : api/libraries/{library}/shelves/{shelf}/books with {"bookIds": ["...", "..."], "position": 0}
+func (r *RestService) addShelfBooks(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received add Shelf Books request")
+	r.changeShelfBooks(writer, request, func(library db.RestDbInterface, shelfID string, body shelfBooksRequest) (*lib.Shelf, error) {
+		position := -1
+		if body.Position != nil {
+			position = *body.Position
+		}
+		return library.AddShelfBooks(shelfID, body.BookIDs, position)
+	})
+}
+
+// removeShelfBooks takes books off a shelf of the user.
+// eg : api/libraries/{library}/shelves/{shelf}/books with {"bookIds": ["...", "..."]}
+func (r *RestService) removeShelfBooks(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received remove Shelf Books request")
+	r.changeShelfBooks(writer, request, func(library db.RestDbInterface, shelfID string, body shelfBooksRequest) (*lib.Shelf, error) {
+		return library.RemoveShelfBooks(shelfID, body.BookIDs)
+	})
+}
+
+// reorderShelfBooks puts the books of a shelf of the user in the given order, which lists every book of the shelf.
+// eg : api/libraries/{library}/shelves/{shelf}/order with {"bookIds": ["...", "..."]}
+func (r *RestService) reorderShelfBooks(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received reorder Shelf Books request")
+	r.changeShelfBooks(writer, request, func(library db.RestDbInterface, shelfID string, body shelfBooksRequest) (*lib.Shelf, error) {
+		return library.ReorderShelfBooks(shelfID, body.BookIDs)
+	})
+}
+
+// changeShelfBooks applies a change of the body to the books of a shelf of the user, responding with the changed shelf
+func (r *RestService) changeShelfBooks(writer http.ResponseWriter, request *http.Request,
+	change func(library db.RestDbInterface, shelfID string, body shelfBooksRequest) (*lib.Shelf, error)) {
+	library, shelf, err := r.shelfFromRequest(request, true)
+	if err != nil {
+		r.restShelfError(writer, err)
+		return
+	}
+	body := shelfBooksRequest{}
+	err = json.NewDecoder(request.Body).Decode(&body)
+	if err != nil {
+		r.restResponse(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	shelf, err = change(library, shelf.ID, body)
+	if err != nil {
+		r.restShelfError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, shelf)
+}
+
+// shelfFromRequest returns the library and the shelf given in the path, when visible to the user, and owned by the user when asked.
+// Shelves the user cannot see are not found.
+func (r *RestService) shelfFromRequest(request *http.Request, owned bool) (db.RestDbInterface, *lib.Shelf, error) {
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		return nil, nil, err
+	}
+	shelf, err := library.GetOneShelf(mux.Vars(request)[paramShelf])
+	if err != nil {
+		return nil, nil, err
+	}
+	userID := request.Header.Get(userIDHeader)
+	if !shelf.IsVisibleTo(userID) {
+		return nil, nil, lib.NoMatchingShelf
+	}
+	if owned && shelf.OwnerID != userID {
+		return nil, nil, lib.ShelfNotOwned
+	}
+	return library, shelf, nil
+}
+
+// restShelfError responds with the status matching a shelf error
+func (r *RestService) restShelfError(writer http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, lib.NoMatchingLibrary):
+		r.restLibraryError(writer, err)
+	case errors.Is(err, lib.NoMatchingShelf), errors.Is(err, lib.NoMatchingBook):
+		r.restResponse(writer, http.StatusNotFound, err.Error())
+	case errors.Is(err, lib.ShelfNotOwned):
+		r.restResponse(writer, http.StatusForbidden, err.Error())
+	case errors.Is(err, lib.IncompleteShelf), errors.Is(err, lib.IncorrectVisibility), errors.Is(err, lib.IncorrectShelfOrder):
+		r.restResponse(writer, http.StatusBadRequest, err.Error())
+	default:
+		stdError(err.Error())
+		r.restResponse(writer, http.StatusInternalServerError, err.Error())
+	}
+}
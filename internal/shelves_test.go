@@ -0,0 +1,147 @@
+package internal
+
+import (
+	"dockerrestapi/lib"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestShelves(t *testing.T) {
+	shelvesApi, err := createMockApi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	library := map[string]string{paramLibrary: lib.DefaultLibraryName}
+	var bookIDs []string
+	for _, name := range []string{"the hobbit", "the fellowship of the ring", "the two towers", "the return of the king"} {
+		book := lib.Book{Name: name, Author: "Tolkien", Contents: "Middle-earth."}
+		err = shelvesApi.db.CreateNewBook(&book)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bookIDs = append(bookIDs, book.ID)
+	}
+	hobbit, fellowship, towers, king := bookIDs[0], bookIDs[1], bookIDs[2], bookIDs[3]
+
+	// shelves belong to the user creating them and are private by default
+	decodeTestResponse[lib.Shelf](t, http.MethodPut, shelvesPath, asUser("", shelvesApi.createShelf), lib.Shelf{Name: "to read"}, http.StatusBadRequest, library)
+	decodeTestResponse[lib.Shelf](t, http.MethodPut, shelvesPath, asUser("bilbo", shelvesApi.createShelf), lib.Shelf{Name: "to read", Visibility: "friends"}, http.StatusBadRequest, library)
+	decodeTestResponse[lib.Shelf](t, http.MethodPut, shelvesPath, asUser("bilbo", shelvesApi.createShelf), lib.Shelf{Name: "to read", BookIDs: []string{"doesnt exist"}}, http.StatusNotFound, library)
+	toRead := createTestShelf(t, shelvesApi, lib.Shelf{Name: " to read ", OwnerID: "frodo", BookIDs: []string{hobbit, fellowship, hobbit}}, "bilbo")
+	if toRead.OwnerID != "bilbo" || toRead.Name != "to read" || toRead.Visibility != lib.ShelfPrivate || !reflect.DeepEqual(toRead.BookIDs, []string{hobbit, fellowship}) {
+		t.Error("expecting a private shelf of bilbo got", toRead)
+	}
+	favourites := createTestShelf(t, shelvesApi, lib.Shelf{Name: "favourites", Visibility: lib.ShelfPublic, BookIDs: []string{king}}, "frodo")
+
+	// private shelves are only seen by their owner, public shelves only changed by theirs
+	toReadParams := map[string]string{paramLibrary: lib.DefaultLibraryName, paramShelf: toRead.ID}
+	favouritesParams := map[string]string{paramLibrary: lib.DefaultLibraryName, paramShelf: favourites.ID}
+	if shelves := getTestShelves(t, shelvesApi, shelvesPath, "bilbo"); len(shelves) != 2 {
+		t.Error("expecting both shelves for bilbo got", shelves)
+	}
+	if shelves := getTestShelves(t, shelvesApi, shelvesPath, ""); len(shelves) != 1 || shelves[0].ID != favourites.ID {
+		t.Error("expecting the public shelf only got", shelves)
+	}
+	if shelves := getTestShelves(t, shelvesApi, shelvesPath+"?owner=bilbo", "frodo"); len(shelves) != 0 {
+		t.Error("expecting no public shelf of bilbo got", shelves)
+	}
+	decodeTestResponse[lib.Shelf](t, http.MethodGet, shelfPath, asUser("frodo", shelvesApi.getShelf), nil, http.StatusNotFound, toReadParams)
+	decodeTestResponse[lib.Shelf](t, http.MethodGet, shelfPath, asUser("", shelvesApi.getShelf), nil, http.StatusOK, favouritesParams)
+	decodeTestResponse[lib.Shelf](t, http.MethodPut, shelfBooksPath, asUser("bilbo", shelvesApi.addShelfBooks), shelfBooksRequest{BookIDs: []string{hobbit}}, http.StatusForbidden, favouritesParams)
+	decodeTestResponse[lib.Shelf](t, http.MethodDelete, shelfPath, asUser("bilbo", shelvesApi.deleteShelf), nil, http.StatusForbidden, favouritesParams)
+
+	// books are added in bulk at a position, books already on the shelf keep their place
+	position := 1
+	added := decodeTestResponse[lib.Shelf](t, http.MethodPut, shelfBooksPath, asUser("bilbo", shelvesApi.addShelfBooks),
+		shelfBooksRequest{BookIDs: []string{towers, hobbit, king}, Position: &position}, http.StatusOK, toReadParams)
+	if !reflect.DeepEqual(added.BookIDs, []string{hobbit, towers, king, fellowship}) {
+		t.Error("expecting books inserted at 1 got", added.BookIDs)
+	}
+	decodeTestResponse[lib.Shelf](t, http.MethodPut, shelfBooksPath, asUser("bilbo", shelvesApi.addShelfBooks), shelfBooksRequest{BookIDs: []string{"doesnt exist"}}, http.StatusNotFound, toReadParams)
+
+	// reordering lists every book of the shelf once
+	for _, order := range [][]string{{hobbit, towers, king}, {hobbit, towers, king, king}, {hobbit, towers, king, "doesnt exist"}} {
+		decodeTestResponse[lib.Shelf](t, http.MethodPut, shelfOrderPath, asUser("bilbo", shelvesApi.reorderShelfBooks), shelfBooksRequest{BookIDs: order}, http.StatusBadRequest, toReadParams)
+	}
+	reordered := decodeTestResponse[lib.Shelf](t, http.MethodPut, shelfOrderPath, asUser("bilbo", shelvesApi.reorderShelfBooks),
+		shelfBooksRequest{BookIDs: []string{hobbit, fellowship, towers, king}}, http.StatusOK, toReadParams)
+	if !reflect.DeepEqual(reordered.BookIDs, []string{hobbit, fellowship, towers, king}) {
+		t.Error("expecting the books in story order got", reordered.BookIDs)
+	}
+
+	// books are removed in bulk, and leave every shelf when deleted
+	removed := decodeTestResponse[lib.Shelf](t, http.MethodDelete, shelfBooksPath, asUser("bilbo", shelvesApi.removeShelfBooks),
+		shelfBooksRequest{BookIDs: []string{hobbit, "not on the shelf"}}, http.StatusOK, toReadParams)
+	if !reflect.DeepEqual(removed.BookIDs, []string{fellowship, towers, king}) {
+		t.Error("expecting the hobbit removed got", removed.BookIDs)
+	}
+	_, err = testResponse(http.MethodDelete, libraryBookPath, shelvesApi.deleteBook, nil, http.StatusOK, map[string]string{paramLibrary: lib.DefaultLibraryName, paramID: king})
+	if err != nil {
+		t.Fatal(err)
+	}
+	remaining := decodeTestResponse[lib.Shelf](t, http.MethodGet, shelfPath, asUser("bilbo", shelvesApi.getShelf), nil, http.StatusOK, toReadParams)
+	if !reflect.DeepEqual(remaining.BookIDs, []string{fellowship, towers}) {
+		t.Error("expecting the deleted book off the shelf got", remaining.BookIDs)
+	}
+	if emptied := decodeTestResponse[lib.Shelf](t, http.MethodGet, shelfPath, asUser("", shelvesApi.getShelf), nil, http.StatusOK, favouritesParams); len(emptied.BookIDs) != 0 {
+		t.Error("expecting the deleted book off every shelf got", emptied.BookIDs)
+	}
+	books := getTestShelfBooks(t, shelvesApi, toReadParams, "bilbo")
+	if len(books) != 2 || books[0].Name != "the fellowship of the ring" || books[1].Name != "the two towers" {
+		t.Error("expecting the books of the shelf in order got", books)
+	}
+
+	// books in the trash are back on their shelves once restored, after the books reordered meanwhile
+	decodeTestResponse[lib.Shelf](t, http.MethodPut, shelfOrderPath, asUser("bilbo", shelvesApi.reorderShelfBooks),
+		shelfBooksRequest{BookIDs: []string{towers, fellowship}}, http.StatusOK, toReadParams)
+	_, err = testResponse(http.MethodPut, trashRestorePath, shelvesApi.restoreBook, nil, http.StatusOK, map[string]string{paramLibrary: lib.DefaultLibraryName, paramID: king})
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := decodeTestResponse[lib.Shelf](t, http.MethodGet, shelfPath, asUser("bilbo", shelvesApi.getShelf), nil, http.StatusOK, toReadParams)
+	if !reflect.DeepEqual(restored.BookIDs, []string{towers, fellowship, king}) {
+		t.Error("expecting the restored book back on the shelf got", restored.BookIDs)
+	}
+	if favourite := decodeTestResponse[lib.Shelf](t, http.MethodGet, shelfPath, asUser("", shelvesApi.getShelf), nil, http.StatusOK, favouritesParams); !reflect.DeepEqual(favourite.BookIDs, []string{king}) {
+		t.Error("expecting the restored book back on every shelf got", favourite.BookIDs)
+	}
+
+	// the owner renames and publishes a shelf, keeping its books
+	renamed := decodeTestResponse[lib.Shelf](t, http.MethodPut, shelfPath, asUser("bilbo", shelvesApi.updateShelf),
+		lib.Shelf{Name: "reading next", Visibility: lib.ShelfPublic, OwnerID: "frodo"}, http.StatusOK, toReadParams)
+	if renamed.Name != "reading next" || renamed.OwnerID != "bilbo" || len(renamed.BookIDs) != 3 {
+		t.Error("expecting a renamed shelf of bilbo got", renamed)
+	}
+	decodeTestResponse[lib.Shelf](t, http.MethodGet, shelfPath, asUser("frodo", shelvesApi.getShelf), nil, http.StatusOK, toReadParams)
+	decodeTestResponse[lib.Shelf](t, http.MethodDelete, shelfPath, asUser("bilbo", shelvesApi.deleteShelf), nil, http.StatusOK, toReadParams)
+	decodeTestResponse[lib.Shelf](t, http.MethodGet, shelfPath, asUser("bilbo", shelvesApi.getShelf), nil, http.StatusNotFound, toReadParams)
+}
+
+// createTestShelf creates a shelf of a user in the default library
+func createTestShelf(t *testing.T, service *RestService, shelf lib.Shelf, userID string) lib.Shelf {
+	t.Helper()
+	return decodeTestResponse[lib.Shelf](t, http.MethodPut, shelvesPath, asUser(userID, service.createShelf), shelf, http.StatusOK, map[string]string{paramLibrary: lib.DefaultLibraryName})
+}
+
+// getTestShelves lists the shelves of the default library seen by a user
+func getTestShelves(t *testing.T, service *RestService, url, userID string) []lib.Shelf {
+	t.Helper()
+	return decodeTestResponse[[]lib.Shelf](t, http.MethodGet, url, asUser(userID, service.getShelves), nil, http.StatusOK, map[string]string{paramLibrary: lib.DefaultLibraryName})
+}
+
+// getTestShelfBooks lists the books of a shelf seen by a user
+func getTestShelfBooks(t *testing.T, service *RestService, params map[string]string, userID string) []lib.BookIdentifier {
+	t.Helper()
+	return decodeTestResponse[[]lib.BookIdentifier](t, http.MethodGet, shelfBooksPath, asUser(userID, service.getShelfBooks), nil, http.StatusOK, params)
+}
+
+// asUser calls a handler as a user, given by the user id header when not empty
+func asUser(userID string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if userID != "" {
+			request.Header.Set(userIDHeader, userID)
+		}
+		handler(writer, request)
+	}
+}
@@ -0,0 +1,172 @@
+package internal
+
+import (
+	"dockerrestapi/db"
+	"dockerrestapi/lib"
+	"errors"
+	"github.com/gorilla/mux"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+const (
+	libraryBookSimilarPath = libraryBookPath + "/similar"
+
+	defaultSimilarLimit = 10
+	maxSimilarLimit     = 100
+)
+
+// similarityIndexes holds the similarity index of each library, kept in step with storage by the hashes of the contents
+// of books so that books written by any api or replica are indexed
+type similarityIndexes struct {
+	lock      sync.Mutex
+	libraries map[string]*lib.SimilarityIndex
+}
+
+func newSimilarityIndexes() *similarityIndexes {
+	return &similarityIndexes{libraries: map[string]*lib.SimilarityIndex{}}
+}
+
+// index returns the similarity index of a library, empty until synced
+func (s *similarityIndexes) index(libraryName string) *lib.SimilarityIndex {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	index, found := s.libraries[libraryName]
+	if !found {
+		index = lib.NewSimilarityIndex()
+		s.libraries[libraryName] = index
+	}
+	return index
+}
+
+// drop forgets the similarity index of a dropped library
+func (s *similarityIndexes) drop(libraryName string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.libraries, libraryName)
+}
+
+// getSimilarBooks lists the books of a library most similar to a book given the id in the path, by the TF-IDF vectors of
+// their contents, most similar first.
+// eg : api/libraries/{library}/books/{id}/similar?limit=10
+func (r *RestService) getSimilarBooks(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Similar Books request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+	limit := defaultSimilarLimit
+	if value := request.URL.Query().Get(paramLimit); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxSimilarLimit {
+			r.restResponse(writer, http.StatusBadRequest, lib.IncorrectParameters.Error())
+			return
+		}
+	}
+
+	index, err := r.syncSimilarityIndex(library, r.libraryNameFromRequest(request))
+	if err != nil {
+		stdError(err.Error())
+		r.restResponse(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	similar, err := index.Similar(mux.Vars(request)[paramID], limit)
+	if err != nil {
+		if errors.Is(err, lib.NoMatchingBook) {
+			r.restResponse(writer, http.StatusNotFound, err.Error())
+			return
+		}
+		stdError(err.Error())
+		r.restResponse(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	r.restResponse(writer, http.StatusOK, similar)
+}
+
+// syncSimilarityIndex brings the similarity index of a library in step with storage, indexing the books whose contents
+// changed since they were indexed and removing the deleted ones
+func (r *RestService) syncSimilarityIndex(library db.RestDbInterface, libraryName string) (*lib.SimilarityIndex, error) {
+	index := r.similarity.index(libraryName)
+	books, err := library.GetAllBooksWithFields(lib.BookFilter{}, []string{lib.JsonBsonTagName, lib.JsonBsonTagAuthor,
+		lib.JsonBsonTagContentsHash, lib.JsonBsonTagUpdatedAt})
+	if err != nil {
+		return nil, err
+	}
+	stored := map[string]bool{}
+	for _, book := range books {
+		stored[book.ID] = true
+		version := book.ContentsHash
+		if version == "" { // stored before contents were hashed
+			version = book.UpdatedAt.String()
+		}
+		if indexed, found := index.Version(book.ID); found && indexed == version {
+			index.Rename(book.Identifier())
+			continue
+		}
+		terms, err := r.bookTerms(library, book.ID)
+		if errors.Is(err, lib.NoMatchingBook) { // deleted meanwhile
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		index.Put(book.Identifier(), version, terms)
+	}
+	for _, id := range index.BookIDs() {
+		if !stored[id] {
+			index.Remove(id)
+		}
+	}
+	return index, nil
+}
+
+// bookTerms returns the terms stored with a book, counting them from the contents of books stored before terms were kept
+func (r *RestService) bookTerms(library db.RestDbInterface, bookID string) ([]lib.TermCount, error) {
+	book, err := library.GetOneBookWithFields(&lib.BookIdentifier{ID: bookID}, []string{lib.JsonBsonTagTerms})
+	if err != nil {
+		return nil, err
+	}
+	if book.Terms != nil {
+		return book.Terms, nil
+	}
+
+	contents, err := library.GetBookContents(&lib.BookIdentifier{ID: bookID})
+	if err != nil {
+		return nil, err
+	}
+	defer contents.Close()
+	counter := lib.NewStatsCounter()
+	_, err = io.Copy(counter, contents)
+	if err != nil {
+		return nil, err
+	}
+	return counter.Terms(lib.TermVectorSize), nil
+}
+
+// rebuildSimilarityIndexes builds the similarity index of every library from storage, failures are only logged as
+// indexes are synced again when queried
+func (r *RestService) rebuildSimilarityIndexes() {
+	libraries, err := r.db.GetAllLibraries()
+	if err != nil {
+		stdError("cant list libraries to index " + err.Error())
+		return
+	}
+	for _, info := range libraries {
+		library, err := r.db.Library(info.Name)
+		if err != nil {
+			stdError("cant open library " + info.Name + " to index " + err.Error())
+			continue
+		}
+		index, err := r.syncSimilarityIndex(library, info.Name)
+		if err != nil {
+			stdError("cant index library " + info.Name + " " + err.Error())
+			continue
+		}
+		stdInfo("indexed " + strconv.Itoa(len(index.BookIDs())) + " books of library " + info.Name + " for similarity")
+	}
+}
@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"dockerrestapi/lib"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestSimilarBooks(t *testing.T) {
+	similarApi, err := createMockApi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	books := []lib.Book{
+		{Name: "the hobbit", Author: "Tolkien", Contents: "The hobbit journeyed with the dwarves to the mountain where the dragon slept on gold."},
+		{Name: "the fellowship of the ring", Author: "Tolkien", Contents: "The hobbit carried the ring with the dwarves and elves through the mountain mines."},
+		{Name: "a wizard of earthsea", Author: "Le Guin", Contents: "The young wizard sailed from island to island, chased by a shadow, and spoke with a dragon."},
+		{Name: "bread", Author: "Baker", Contents: "Knead the flour with butter and water, then bake the bread in a hot oven."},
+	}
+	ids := map[string]string{}
+	for _, book := range books {
+		marshalBook, err := json.Marshal(book)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = testResponse(http.MethodPut, createBookPath, similarApi.createBook, marshalBook, http.StatusOK, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored, err := similarApi.db.GetOneBook(&lib.BookIdentifier{Name: book.Name, Author: book.Author})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[book.Name] = stored.ID
+	}
+
+	similar := similarTestResponse(t, similarApi, ids["the hobbit"], "", http.StatusOK)
+	if len(similar) != 2 || similar[0].Name != "the fellowship of the ring" || similar[1].Name != "a wizard of earthsea" {
+		t.Fatal("expecting books ranked by similarity got", similar)
+	}
+
+	// writes are indexed when queried, deleted books are left out
+	_, err = testResponse(http.MethodPut, libraryBookContentsPath, similarApi.storeBookContents,
+		[]byte("The hobbit baked bread with flour and butter in the oven of the mountain."), http.StatusOK,
+		map[string]string{paramLibrary: lib.DefaultLibraryName, paramID: ids["the hobbit"]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = similarApi.db.DeleteBook(&lib.BookIdentifier{ID: ids["the fellowship of the ring"]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	similar = similarTestResponse(t, similarApi, ids["the hobbit"], "?limit=1", http.StatusOK)
+	if len(similar) != 1 || similar[0].Name != "bread" {
+		t.Error("expecting the similar book of the new contents got", similar)
+	}
+	similarTestResponse(t, similarApi, ids["the fellowship of the ring"], "", http.StatusNotFound)
+	similarTestResponse(t, similarApi, ids["the hobbit"], "?limit=0", http.StatusBadRequest)
+
+	// the index is rebuilt from storage when the service starts
+	restarted, err := CreateRestApiService(similarApi.db, "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted.rebuildSimilarityIndexes()
+	if indexed := restarted.similarity.index(lib.DefaultLibraryName).BookIDs(); len(indexed) != 3 {
+		t.Error("expecting the books of the library indexed got", indexed)
+	}
+}
+
+// similarTestResponse gets the books similar to a book of the default library with a query
+func similarTestResponse(t *testing.T, service *RestService, bookID, query string, status int) []lib.SimilarBook {
+	t.Helper()
+	return decodeTestResponse[[]lib.SimilarBook](t, http.MethodGet, libraryBookSimilarPath+query, service.getSimilarBooks, nil, status,
+		map[string]string{paramLibrary: lib.DefaultLibraryName, paramID: bookID})
+}
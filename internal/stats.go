@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"dockerrestapi/db"
+	"dockerrestapi/lib"
+	"errors"
+	"io"
+	"net/http"
+)
+
+const (
+	libraryStatsPath = libraryPath + "/stats"
+)
+
+// getLibraryStats sums the word counts, characters and reading times of the books of a library, in total and for each
+// primary author. The books can be narrowed with the query parameters of the book list.
+// eg : api/libraries/{library}/stats?author=Tolkien
+func (r *RestService) getLibraryStats(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Library Stats request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+
+	filter, err := r.createBookFilterFromQuery(request.URL.Query())
+	if err != nil {
+		r.restResponse(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	books, err := library.GetAllBooksWithFields(filter, []string{lib.JsonBsonTagAuthor, lib.JsonBsonTagStats})
+	if err != nil {
+		stdError(err.Error())
+		r.restResponse(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for i := range books {
+		if books[i].Stats != nil {
+			continue
+		}
+		books[i].Stats, err = r.countStats(library, books[i].ID)
+		if err != nil && !errors.Is(err, lib.NoMatchingBook) { // deleted meanwhile
+			stdError(err.Error())
+			r.restResponse(writer, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	r.restResponse(writer, http.StatusOK, lib.AggregateStats(books))
+}
+
+// countStats counts the statistics of the contents of a book stored before statistics were
+func (r *RestService) countStats(library db.RestDbInterface, bookID string) (*lib.BookStats, error) {
+	contents, err := library.GetBookContents(&lib.BookIdentifier{ID: bookID})
+	if err != nil {
+		return nil, err
+	}
+	defer contents.Close()
+	counter := lib.NewStatsCounter()
+	_, err = io.Copy(counter, contents)
+	if err != nil {
+		return nil, err
+	}
+	return counter.Stats(), nil
+}
@@ -0,0 +1,85 @@
+package internal
+
+import (
+	"dockerrestapi/lib"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	statsApi, err := createMockApi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	books := []lib.Book{
+		{Name: "the hobbit", Author: "Tolkien", Contents: "In a hole in the ground there lived a hobbit."},
+		{Name: "the silmarillion", Author: "Tolkien", Contents: strings.Repeat("Morgoth ", 300)},
+		{Name: "emma", Author: "Austen", Contents: "Emma Woodhouse, handsome, clever, and rich."},
+	}
+	for _, book := range books {
+		marshalBook, err := json.Marshal(book)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = testResponse(http.MethodPut, createBookPath, statsApi.createBook, marshalBook, http.StatusOK, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the statistics are stored with the book and shown with it, whatever the client sent
+	hobbit, err := statsApi.db.GetOneBook(&lib.BookIdentifier{Name: "the hobbit", Author: "Tolkien"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := map[string]string{paramLibrary: lib.DefaultLibraryName, paramID: hobbit.ID}
+	hobbit.Stats = &lib.BookStats{Words: 1}
+	marshalBook, err := json.Marshal(hobbit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = testResponse(http.MethodPut, libraryBookPath, statsApi.updateBook, marshalBook, http.StatusOK, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := testResponse(http.MethodGet, libraryBookPath, statsApi.getBook, nil, http.StatusOK, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	book := lib.Book{}
+	err = json.Unmarshal([]byte(response), &book)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.Stats == nil || book.Stats.Words != 10 || book.Stats.Vocabulary != 8 || book.Stats.ReadingMinutes != 1 || book.Stats.TopTerms[0].Term != "ground" {
+		t.Fatal("expecting the statistics of the contents got", book.Stats)
+	}
+	_, err = testResponse(http.MethodPut, libraryBookContentsPath, statsApi.storeBookContents, []byte("Bilbo Baggins"), http.StatusOK, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := statsApi.db.GetOneBook(&lib.BookIdentifier{ID: hobbit.ID}); stored.Stats.Words != 2 {
+		t.Error("expecting the statistics of the stored contents got", stored.Stats)
+	}
+
+	// the library totals, for each author, narrowed by the book filters
+	stats := lib.LibraryStats{}
+	statsTestResponse(t, statsApi, "", &stats)
+	if stats.Books != 3 || stats.Words != 308 || len(stats.Authors) != 2 || stats.Authors[1].Author != "Tolkien" ||
+		stats.Authors[1].Words != 302 || stats.Authors[1].ReadingMinutes != 3 {
+		t.Error("expecting the totals of the library got", stats)
+	}
+	statsTestResponse(t, statsApi, "?author=Austen", &stats)
+	if stats.Books != 1 || stats.Words != 6 || len(stats.Authors) != 1 {
+		t.Error("expecting the totals of the author got", stats)
+	}
+}
+
+// statsTestResponse gets the statistics of the default library with a query
+func statsTestResponse(t *testing.T, service *RestService, query string, output *lib.LibraryStats) {
+	t.Helper()
+	*output = decodeTestResponse[lib.LibraryStats](t, http.MethodGet, libraryStatsPath+query, service.getLibraryStats, nil, http.StatusOK,
+		map[string]string{paramLibrary: lib.DefaultLibraryName})
+}
@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"dockerrestapi/db"
+	"dockerrestapi/lib"
+	"errors"
+	"github.com/gorilla/mux"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	libraryBookChaptersPath = libraryBookPath + "/chapters"
+	libraryBookPagePath     = libraryBookPath + "/pages/{" + paramPage + "}"
+	paramPage               = "page"
+)
+
+// getBookChapters lists the chapters of a book given the id in the path, with its number of pages.
+// eg : api/libraries/{library}/books/{id}/chapters
+func (r *RestService) getBookChapters(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Book Chapters request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+
+	structure, err := r.bookStructure(library, mux.Vars(request)[paramID])
+	if err != nil {
+		r.restStructureError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, structure.TableOfContents())
+}
+
+// getBookPage retrieves a page of the contents of a book given the id and the page number in the path, counted from 1.
+// eg : api/libraries/{library}/books/{id}/pages/{page}
+func (r *RestService) getBookPage(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Book Page request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+	params := mux.Vars(request)
+	number, err := strconv.Atoi(params[paramPage])
+	if err != nil {
+		r.restStructureError(writer, lib.IncorrectParameters)
+		return
+	}
+
+	structure, err := r.bookStructure(library, params[paramID])
+	if err != nil {
+		r.restStructureError(writer, err)
+		return
+	}
+	start, end, err := structure.Page(number)
+	if err != nil {
+		r.restStructureError(writer, err)
+		return
+	}
+	contents, err := library.GetBookContents(&lib.BookIdentifier{ID: params[paramID]})
+	if err != nil {
+		r.restStructureError(writer, err)
+		return
+	}
+	defer contents.Close()
+	_, err = contents.Skip(start)
+	if err != nil {
+		r.restStructureError(writer, err)
+		return
+	}
+	var text strings.Builder
+	_, err = io.CopyN(&text, contents, end-start)
+	if err != nil && !errors.Is(err, io.EOF) { // contents shortened meanwhile
+		r.restStructureError(writer, err)
+		return
+	}
+
+	r.restResponse(writer, http.StatusOK, lib.BookPage{
+		Number:    number,
+		PageCount: structure.PageCount(),
+		Start:     start,
+		End:       end,
+		Chapter:   structure.ChapterAt(start),
+		Text:      text.String(),
+	})
+}
+
+// bookStructure returns the structure stored with a book, parsing it from the contents of books stored before
+// structures were
+func (r *RestService) bookStructure(library db.RestDbInterface, bookID string) (*lib.BookStructure, error) {
+	book, err := library.GetOneBookWithFields(&lib.BookIdentifier{ID: bookID}, []string{lib.JsonBsonTagStructure, lib.JsonBsonTagChapterPattern})
+	if err != nil {
+		return nil, err
+	}
+	if book.Structure != nil {
+		return book.Structure, nil
+	}
+
+	contents, err := library.GetBookContents(&lib.BookIdentifier{ID: bookID})
+	if err != nil {
+		return nil, err
+	}
+	defer contents.Close()
+	parser, err := lib.NewStructureParser(book.ChapterPattern, db.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(parser, contents)
+	if err != nil {
+		return nil, err
+	}
+	return parser.Structure(), nil
+}
+
+// restStructureError responds with the status matching a chapter or page error
+func (r *RestService) restStructureError(writer http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, lib.NoMatchingBook), errors.Is(err, lib.NoMatchingPage):
+		r.restResponse(writer, http.StatusNotFound, err.Error())
+	case errors.Is(err, lib.IncorrectParameters), errors.Is(err, lib.IncorrectChapterPattern):
+		r.restResponse(writer, http.StatusBadRequest, err.Error())
+	default:
+		stdError(err.Error())
+		r.restResponse(writer, http.StatusInternalServerError, err.Error())
+	}
+}
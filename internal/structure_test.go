@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"dockerrestapi/db"
+	"dockerrestapi/lib"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestBookStructure(t *testing.T) {
+	// shrink the pages, threshold and chunks so the test contents span pages and are stored chunked
+	previousThreshold, previousChunkSize, previousPageSize := db.ContentsFileThreshold, db.ContentsChunkSize, db.PageSize
+	db.ContentsFileThreshold, db.ContentsChunkSize, db.PageSize = 64, 16, 40
+	defer func() {
+		db.ContentsFileThreshold, db.ContentsChunkSize, db.PageSize = previousThreshold, previousChunkSize, previousPageSize
+	}()
+
+	structureApi, err := createMockApi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := "# An unexpected party\n\nIn a hole in the ground there lived a hobbit.\n\n# Roast mutton\n\nUp jumped Bilbo."
+	marshalBook, err := json.Marshal(lib.Book{Name: "the hobbit", Author: "Tolkien", Contents: contents})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = testResponse(http.MethodPut, createBookPath, structureApi.createBook, marshalBook, http.StatusOK, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	book, err := structureApi.db.GetOneBook(&lib.BookIdentifier{Name: "the hobbit", Author: "Tolkien"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := map[string]string{paramLibrary: lib.DefaultLibraryName, paramID: book.ID}
+
+	// the table of contents is stored with the book
+	toc := decodeTestResponse[lib.TableOfContents](t, http.MethodGet, libraryBookChaptersPath, structureApi.getBookChapters, nil, http.StatusOK, params)
+	if len(toc.Chapters) != 2 || toc.Chapters[0].Title != "An unexpected party" || toc.Chapters[1].Title != "Roast mutton" || toc.PageCount != 4 {
+		t.Fatal("expecting two chapters over four pages got", toc)
+	}
+
+	// pages are read from the chunked contents
+	var text strings.Builder
+	for number := 1; number <= toc.PageCount; number++ {
+		params[paramPage] = strconv.Itoa(number)
+		page := decodeTestResponse[lib.BookPage](t, http.MethodGet, libraryBookPagePath, structureApi.getBookPage, nil, http.StatusOK, params)
+		if page.Number != number || len(page.Text) > db.PageSize {
+			t.Error("expecting page", number, "got", page)
+		}
+		if number == toc.Chapters[1].FirstPage && (page.Chapter != "Roast mutton" || !strings.HasPrefix(page.Text, "# Roast mutton")) {
+			t.Error("expecting the second chapter to start a page got", page)
+		}
+		text.WriteString(page.Text)
+	}
+	if text.String() != contents {
+		t.Error("expecting the pages to hold the contents got", text.String())
+	}
+	params[paramPage] = "5"
+	decodeTestResponse[lib.BookPage](t, http.MethodGet, libraryBookPagePath, structureApi.getBookPage, nil, http.StatusNotFound, params)
+	params[paramPage] = "first"
+	decodeTestResponse[lib.BookPage](t, http.MethodGet, libraryBookPagePath, structureApi.getBookPage, nil, http.StatusBadRequest, params)
+
+	// storing new contents parses them again
+	_, err = testResponse(http.MethodPut, libraryBookContentsPath, structureApi.storeBookContents, []byte("# The end\n\nThey lived happily."), http.StatusOK, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	toc = decodeTestResponse[lib.TableOfContents](t, http.MethodGet, libraryBookChaptersPath, structureApi.getBookChapters, nil, http.StatusOK, params)
+	if len(toc.Chapters) != 1 || toc.Chapters[0].Title != "The end" || toc.PageCount != 1 {
+		t.Error("expecting the chapter of the new contents got", toc)
+	}
+
+	// a chapter pattern replaces Markdown headings
+	marshalBook, err = json.Marshal(lib.Book{Name: "the hobbit", Author: "Tolkien", Contents: "CHAPTER I\nhobbits\nCHAPTER II\nmutton", ChapterPattern: "^CHAPTER"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = testResponse(http.MethodPut, libraryBookPath, structureApi.updateBook, marshalBook, http.StatusOK, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	toc = decodeTestResponse[lib.TableOfContents](t, http.MethodGet, libraryBookChaptersPath, structureApi.getBookChapters, nil, http.StatusOK, params)
+	if len(toc.Chapters) != 2 || toc.Chapters[1].Title != "CHAPTER" {
+		t.Error("expecting the chapters matching the pattern got", toc)
+	}
+	marshalBook, err = json.Marshal(lib.Book{Name: "the hobbit", Author: "Tolkien", Contents: "text", ChapterPattern: "CHAPTER ("})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = testResponse(http.MethodPut, libraryBookPath, structureApi.updateBook, marshalBook, http.StatusBadRequest, params)
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"dockerrestapi/db"
+	"dockerrestapi/lib"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+const (
+	libraryBooksSuggestionsPath = libraryBooksPath + "/suggestions"
+
+	maxSuggestionLimit = 50
+)
+
+// bookNotFound is the response to a get of a missing book, with the books the request may have meant
+type bookNotFound struct {
+	Error       string               `json:"error"`
+	Suggestions []lib.BookSuggestion `json:"suggestions"`
+}
+
+// getBookSuggestions lists the books of a library whose name and author are most alike the given ones, most alike first,
+// forgiving typos, missing words, case and accents.
+// eg : api/libraries/{library}/books/suggestions?name=harry poter&author=rowling&limit=5
+func (r *RestService) getBookSuggestions(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Book Suggestions request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+	query := request.URL.Query()
+	wanted := lib.BookIdentifier{Name: query.Get(paramName), Author: query.Get(paramAuthor)}
+	if wanted.Name == "" && wanted.Author == "" {
+		r.restResponse(writer, http.StatusBadRequest, lib.IncorrectParameters.Error())
+		return
+	}
+	limit := lib.DefaultSuggestionLimit
+	if value := query.Get(paramLimit); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxSuggestionLimit {
+			r.restResponse(writer, http.StatusBadRequest, lib.IncorrectParameters.Error())
+			return
+		}
+	}
+
+	suggestions, err := r.suggestBooks(library, wanted, limit)
+	if err != nil {
+		stdError(err.Error())
+		r.restResponse(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	r.restResponse(writer, http.StatusOK, suggestions)
+}
+
+// suggestBooks returns the books of a library most alike the wanted name and author, an author known by an alias
+// being compared by its canonical name
+func (r *RestService) suggestBooks(library db.RestDbInterface, wanted lib.BookIdentifier, limit int) ([]lib.BookSuggestion, error) {
+	if wanted.Author != "" {
+		author, err := library.FindAuthor(wanted.Author)
+		if err != nil && !errors.Is(err, lib.NoMatchingAuthor) {
+			return nil, err
+		}
+		if err == nil {
+			wanted.Author = author.Name
+		}
+	}
+	books, err := library.GetAllBooks(lib.BookFilter{})
+	if err != nil {
+		return nil, err
+	}
+	return lib.SuggestBooks(wanted, books, limit), nil
+}
+
+// restBookNotFound responds to a get of a missing book, suggesting the books a lookup by name and author may have meant
+func (r *RestService) restBookNotFound(writer http.ResponseWriter, library db.RestDbInterface, bookIdentifier *lib.BookIdentifier) {
+	response := bookNotFound{Error: lib.NoMatchingBook.Error(), Suggestions: []lib.BookSuggestion{}}
+	if bookIdentifier.ID == "" {
+		suggestions, err := r.suggestBooks(library, *bookIdentifier, lib.DefaultSuggestionLimit)
+		if err != nil {
+			stdError("cant suggest books " + err.Error())
+		} else {
+			response.Suggestions = suggestions
+		}
+	}
+	r.restResponse(writer, http.StatusNotFound, response)
+}
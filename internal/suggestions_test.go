@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"dockerrestapi/lib"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFuzzyBookLookup(t *testing.T) {
+	fuzzyApi, err := createMockApi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fuzzyApi.db.CreateNewAuthor(&lib.Author{Name: "J.K. Rowling", Aliases: []string{"JKR"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	books := []lib.Book{
+		{Name: "Harry Potter and the Philosopher's Stone", Author: "J.K. Rowling", Contents: "Mr. and Mrs. Dursley, of number four, Privet Drive"},
+		{Name: "Harry Potter and the Chamber of Secrets", Author: "J.K. Rowling", Contents: "Not for the first time, an argument had broken out"},
+		{Name: "Wuthering Heights", Author: "Emily Brontë", Contents: "1801. I have just returned from a visit to my landlord"},
+	}
+	for _, book := range books {
+		marshalBook, err := json.Marshal(book)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = testResponse(http.MethodPut, createBookPath, fuzzyApi.createBook, marshalBook, http.StatusOK, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// names and authors are matched ignoring case and accents
+	response, err := testResponse(http.MethodGet, getBookPath, fuzzyApi.getBook, nil, http.StatusOK,
+		map[string]string{paramName: "WUTHERING heights", paramAuthor: "emily bronte"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var book lib.Book
+	err = json.Unmarshal([]byte(response), &book)
+	if err != nil || book.Name != "Wuthering Heights" {
+		t.Error("expecting wuthering heights got", response)
+	}
+	marshalBook, _ := json.Marshal(lib.Book{Name: "wuthering heights", Author: "EMILY BRONTË", Contents: "a copy"})
+	_, err = testResponse(http.MethodPut, createBookPath, fuzzyApi.createBook, marshalBook, http.StatusBadRequest, nil)
+	if err != nil {
+		t.Error("expecting the same book in another case refused", err)
+	}
+
+	// missing books are answered with the books they may have meant
+	response, err = testResponse(http.MethodGet, getBookPath, fuzzyApi.getBook, nil, http.StatusNotFound,
+		map[string]string{paramName: "Harry Potter 2", paramAuthor: "jkr"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var notFound bookNotFound
+	err = json.Unmarshal([]byte(response), &notFound)
+	if err != nil || len(notFound.Suggestions) != 2 || notFound.Suggestions[0].Author != "J.K. Rowling" {
+		t.Error("expecting both harry potter books suggested got", response)
+	}
+
+	// suggestions are served before books by id
+	recorder := httptest.NewRecorder()
+	fuzzyApi.router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet,
+		"/api/libraries/default/books/suggestions?name=wutherin&limit=1", nil))
+	var suggestions []lib.BookSuggestion
+	err = json.Unmarshal(recorder.Body.Bytes(), &suggestions)
+	if recorder.Code != http.StatusOK || err != nil || len(suggestions) != 1 || suggestions[0].Name != "Wuthering Heights" {
+		t.Error("expecting wuthering heights suggested got", recorder.Code, recorder.Body.String())
+	}
+	recorder = httptest.NewRecorder()
+	fuzzyApi.router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/libraries/default/books/suggestions", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Error("expecting", http.StatusBadRequest, "without name nor author got", recorder.Code)
+	}
+}
@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/gif" // register decoders for thumbnails
+	_ "image/jpeg"
+	"image/png"
+	"io"
+)
+
+const (
+	maxThumbnailSourcePixels = 50_000_000 // refuse to decode larger images, guarding against decompression bombs
+)
+
+// createThumbnail decodes an image and returns a PNG scaled down to fit within maxSide, preserving the aspect ratio.
+// Pixels are box filtered, averaging every source pixel that falls in a thumbnail pixel.
+func createThumbnail(source io.ReadSeeker, maxSide int) ([]byte, error) {
+	config, _, err := image.DecodeConfig(source)
+	if err != nil {
+		return nil, err
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxThumbnailSourcePixels {
+		return nil, errors.New("image dimensions not supported for thumbnail")
+	}
+	_, err = source.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(source)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	thumbWidth, thumbHeight := width, height
+	if width > maxSide || height > maxSide {
+		if width >= height {
+			thumbWidth, thumbHeight = maxSide, max(1, height*maxSide/width)
+		} else {
+			thumbWidth, thumbHeight = max(1, width*maxSide/height), maxSide
+		}
+	}
+
+	thumbnail := image.NewRGBA64(image.Rect(0, 0, thumbWidth, thumbHeight))
+	for y := 0; y < thumbHeight; y++ {
+		y0 := bounds.Min.Y + y*height/thumbHeight
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*height/thumbHeight)
+		for x := 0; x < thumbWidth; x++ {
+			x0 := bounds.Min.X + x*width/thumbWidth
+			x1 := max(x0+1, bounds.Min.X+(x+1)*width/thumbWidth)
+
+			var red, green, blue, alpha, count uint64
+			for sourceY := y0; sourceY < y1; sourceY++ {
+				for sourceX := x0; sourceX < x1; sourceX++ {
+					r, g, b, a := img.At(sourceX, sourceY).RGBA()
+					red, green, blue, alpha = red+uint64(r), green+uint64(g), blue+uint64(b), alpha+uint64(a)
+					count++
+				}
+			}
+			thumbnail.SetRGBA64(x, y, color.RGBA64{
+				R: uint16(red / count),
+				G: uint16(green / count),
+				B: uint16(blue / count),
+				A: uint16(alpha / count),
+			})
+		}
+	}
+
+	var encoded bytes.Buffer
+	err = png.Encode(&encoded, thumbnail)
+	if err != nil {
+		return nil, err
+	}
+	return encoded.Bytes(), nil
+}
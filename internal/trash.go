@@ -0,0 +1,194 @@
+package internal
+
+import (
+	"dockerrestapi/db"
+	"dockerrestapi/lib"
+	"errors"
+	"github.com/gorilla/mux"
+	"net/http"
+	"time"
+)
+
+const (
+	trashPath                 = libraryPath + "/trash"
+	trashBookPath             = trashPath + "/{" + paramID + "}"
+	trashRestorePath          = trashBookPath + "/restore"
+	defaultTrashPurgeInterval = time.Hour
+)
+
+// getTrash lists the deleted books of a library, with the time they were deleted.
+// eg : api/libraries/{library}/trash
+func (r *RestService) getTrash(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Trash request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+
+	books, err := library.GetDeletedBooks()
+	if err != nil {
+		stdError(err.Error())
+		r.restResponse(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	r.restResponse(writer, http.StatusOK, books)
+}
+
+// restoreBook moves a deleted book out of the trash given its id in the path.
+// eg : api/libraries/{library}/trash/{id}/restore
+func (r *RestService) restoreBook(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received restore Book request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+
+	bookIdentifier, err := r.createBookIdentifierFromParams(mux.Vars(request))
+	if err != nil {
+		r.restResponse(writer, http.StatusBadRequest, lib.IncorrectParameters)
+		return
+	}
+
+	err = library.RestoreBook(bookIdentifier)
+	if err != nil {
+		r.restTrashError(writer, err)
+		return
+	}
+	r.notifyWebhooks(r.libraryNameFromRequest(request), lib.ChangeRestored, *bookIdentifier)
+	writer.Header().Set("Location", libraryBookLocation(r.libraryNameFromRequest(request), bookIdentifier.ID))
+	r.restResponse(writer, http.StatusOK, nil)
+}
+
+// purgeBook permanently deletes a book in the trash with its contents and attachments given its id in the path.
+// eg : api/libraries/{library}/trash/{id}
+func (r *RestService) purgeBook(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received purge Book request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+
+	bookIdentifier, err := r.createBookIdentifierFromParams(mux.Vars(request))
+	if err != nil {
+		r.restResponse(writer, http.StatusBadRequest, lib.IncorrectParameters)
+		return
+	}
+
+	err = r.purge(library, r.libraryNameFromRequest(request), bookIdentifier)
+	if err != nil {
+		r.restTrashError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, nil)
+}
+
+// emptyTrash permanently deletes every book in the trash of a library.
+// eg : api/libraries/{library}/trash
+func (r *RestService) emptyTrash(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received empty Trash request")
+	library, err := r.libraryFromRequest(request)
+	if err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+
+	_, err = r.purgeDeletedBefore(library, r.libraryNameFromRequest(request), time.Time{})
+	if err != nil {
+		stdError(err.Error())
+		r.restResponse(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	r.restResponse(writer, http.StatusOK, nil)
+}
+
+// purgeTrashPeriodically purges books past the trash retention of every library until the service stops
+func (r *RestService) purgeTrashPeriodically() {
+	ticker := time.NewTicker(r.trashPurgeInterval)
+	defer ticker.Stop()
+	for {
+		r.purgeExpiredTrash()
+		select {
+		case <-ticker.C:
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// purgeExpiredTrash purges the books deleted longer than the trash retention ago from every library, returning how many were purged
+func (r *RestService) purgeExpiredTrash() int {
+	libraries, err := r.db.GetAllLibraries()
+	if err != nil {
+		stdError("cant list libraries to purge " + err.Error())
+		return 0
+	}
+	cutoff := r.clock.Now().Add(-r.trashRetention)
+	purged := 0
+	for _, libraryInfo := range libraries {
+		library, err := r.db.Library(libraryInfo.Name)
+		if err != nil {
+			stdError("cant open library " + libraryInfo.Name + " to purge " + err.Error())
+			continue
+		}
+		count, err := r.purgeDeletedBefore(library, libraryInfo.Name, cutoff)
+		purged += count
+		if err != nil {
+			stdError("cant purge trash of library " + libraryInfo.Name + " " + err.Error())
+		}
+	}
+	if purged > 0 {
+		stdInfo("purged expired books from the trash")
+	}
+	return purged
+}
+
+// purgeDeletedBefore purges the books of a library deleted before the cutoff, every book in the trash for a zero cutoff
+func (r *RestService) purgeDeletedBefore(library db.RestDbInterface, libraryName string, cutoff time.Time) (int, error) {
+	books, err := library.GetDeletedBooks()
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, book := range books {
+		if !cutoff.IsZero() && book.DeletedAt != nil && !book.DeletedAt.Before(cutoff) {
+			continue
+		}
+		err = r.purge(library, libraryName, &lib.BookIdentifier{ID: book.ID})
+		if err != nil {
+			if errors.Is(err, lib.NoMatchingDeletedBook) {
+				continue // restored or purged meanwhile
+			}
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// purge permanently deletes a book in the trash, releasing the blobs of its attachments
+func (r *RestService) purge(library db.RestDbInterface, libraryName string, bookIdentifier *lib.BookIdentifier) error {
+	attachments, err := library.PurgeBook(bookIdentifier)
+	if err != nil {
+		return err
+	}
+	r.releaseAttachmentBlobs(library, libraryName, attachments)
+	r.notifyWebhooks(libraryName, lib.ChangePurged, *bookIdentifier)
+	return nil
+}
+
+// restTrashError responds to a failed trash operation
+func (r *RestService) restTrashError(writer http.ResponseWriter, err error) {
+	if errors.Is(err, lib.NoMatchingDeletedBook) {
+		r.restResponse(writer, http.StatusNotFound, err.Error())
+		return
+	}
+	if errors.Is(err, lib.BookAlreadyExists) {
+		r.restResponse(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	stdError(err.Error())
+	r.restResponse(writer, http.StatusInternalServerError, err.Error())
+}
@@ -0,0 +1,179 @@
+package internal
+
+import (
+	"dockerrestapi/db"
+	"dockerrestapi/lib"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTrash(t *testing.T) {
+	trashApi, err := createMockApi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	book := lib.Book{Name: "mistyped", Author: "philp", Contents: "A lost read"}
+	err = trashApi.db.CreateNewBook(&book)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := map[string]string{paramLibrary: lib.DefaultLibraryName, paramID: book.ID}
+	library := map[string]string{paramLibrary: lib.DefaultLibraryName}
+
+	// deleted books are gone from get and list, but listed in the trash
+	_, err = testResponse(http.MethodDelete, deleteBookPath, trashApi.deleteBook, nil, http.StatusOK, map[string]string{paramName: book.Name, paramAuthor: book.Author})
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = testResponse(http.MethodGet, libraryBookPath, trashApi.getBook, nil, http.StatusNotFound, params)
+	if err != nil {
+		t.Error(err)
+	}
+	response, err := testResponse(http.MethodGet, libraryBooksPath, trashApi.getBooks, nil, http.StatusOK, library)
+	if err != nil {
+		t.Error(err)
+	}
+	if response != "null" {
+		t.Error("expecting no books got", response)
+	}
+	_, err = testResponse(http.MethodDelete, libraryBookPath, trashApi.deleteBook, nil, http.StatusNotFound, params)
+	if err != nil {
+		t.Error(err)
+	}
+	trash := getTrash(t, trashApi, library)
+	if len(trash) != 1 || trash[0].ID != book.ID || trash[0].DeletedAt == nil || !trash[0].DeletedAt.Equal(testClock.Now()) {
+		t.Error("expecting the deleted book in the trash got", trash)
+	}
+
+	// restore fails while a book of the same name and author exists
+	clash := lib.Book{Name: book.Name, Author: book.Author, Contents: "A rewrite"}
+	err = trashApi.db.CreateNewBook(&clash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = testResponse(http.MethodPut, trashRestorePath, trashApi.restoreBook, nil, http.StatusBadRequest, params)
+	if err != nil {
+		t.Error(err)
+	}
+	err = trashApi.db.DeleteBook(&lib.BookIdentifier{ID: clash.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = testResponse(http.MethodPut, trashRestorePath, trashApi.restoreBook, nil, http.StatusOK, params)
+	if err != nil {
+		t.Error(err)
+	}
+	restored, err := trashApi.db.GetOneBook(&lib.BookIdentifier{Name: book.Name, Author: book.Author})
+	if err != nil || restored.ID != book.ID || restored.Contents != book.Contents || restored.DeletedAt != nil {
+		t.Error("expecting the restored book got", restored, err)
+	}
+	_, err = testResponse(http.MethodPut, trashRestorePath, trashApi.restoreBook, nil, http.StatusNotFound, params)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// purge removes a book from the trash for good
+	clashParams := map[string]string{paramLibrary: lib.DefaultLibraryName, paramID: clash.ID}
+	_, err = testResponse(http.MethodDelete, trashBookPath, trashApi.purgeBook, nil, http.StatusOK, clashParams)
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = testResponse(http.MethodDelete, trashBookPath, trashApi.purgeBook, nil, http.StatusNotFound, clashParams)
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = testResponse(http.MethodDelete, trashBookPath, trashApi.purgeBook, nil, http.StatusNotFound, params)
+	if err != nil {
+		t.Error("books not in the trash cannot be purged", err)
+	}
+
+	// empty the trash
+	err = trashApi.db.DeleteBook(&lib.BookIdentifier{ID: book.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = testResponse(http.MethodDelete, trashPath, trashApi.emptyTrash, nil, http.StatusOK, library)
+	if err != nil {
+		t.Error(err)
+	}
+	if trash = getTrash(t, trashApi, library); len(trash) != 0 {
+		t.Error("expecting an empty trash got", trash)
+	}
+}
+
+func TestPurgeExpiredTrash(t *testing.T) {
+	clock := lib.NewManualClock(time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC))
+	mockConn, err := db.CreateMockDBHandlerWithClock(clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trashApi, err := CreateRestApiService(mockConn, "8081", WithClock(clock), WithTrashRetention(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mockConn.CreateLibrary("tenant1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenant, err := mockConn.Library("tenant1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := lib.Book{Name: "old", Author: "philip", Contents: "Deleted long ago"}
+	recent := lib.Book{Name: "recent", Author: "philip", Contents: "Deleted just now"}
+	kept := lib.Book{Name: "kept", Author: "philip", Contents: "Never deleted"}
+	for _, book := range []*lib.Book{&old, &kept} {
+		err = tenant.CreateNewBook(book)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = mockConn.CreateNewBook(&recent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tenant.DeleteBook(&lib.BookIdentifier{ID: old.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(20 * time.Hour)
+	err = mockConn.DeleteBook(&lib.BookIdentifier{ID: recent.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if purged := trashApi.purgeExpiredTrash(); purged != 0 {
+		t.Error("expecting nothing purged before the retention got", purged)
+	}
+	clock.Advance(5 * time.Hour)
+	if purged := trashApi.purgeExpiredTrash(); purged != 1 {
+		t.Error("expecting 1 book purged got", purged)
+	}
+	if trash, _ := tenant.GetDeletedBooks(); len(trash) != 0 {
+		t.Error("expecting the old book purged got", trash)
+	}
+	if trash, _ := mockConn.GetDeletedBooks(); len(trash) != 1 || trash[0].ID != recent.ID {
+		t.Error("expecting the recent book kept in the trash got", trash)
+	}
+	if _, err = tenant.GetOneBook(&lib.BookIdentifier{ID: kept.ID}); err != nil {
+		t.Error("expecting active books untouched got", err)
+	}
+}
+
+// getTrash lists the trash of a library
+func getTrash(t *testing.T, service *RestService, params map[string]string) []lib.BookIdentifier {
+	response, err := testResponse(http.MethodGet, trashPath, service.getTrash, nil, http.StatusOK, params)
+	if err != nil {
+		t.Error(err)
+	}
+	var trash []lib.BookIdentifier
+	err = json.Unmarshal([]byte(response), &trash)
+	if err != nil {
+		t.Error(err)
+	}
+	return trash
+}
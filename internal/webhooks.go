@@ -0,0 +1,463 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"dockerrestapi/db"
+	"dockerrestapi/lib"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	webhooksPath      = libraryPath + "/webhooks"
+	deadLettersPath   = webhooksPath + "/deadletters"
+	webhookPath       = webhooksPath + "/{" + paramWebhook + "}"
+	deliveriesPath    = webhookPath + "/deliveries"
+	deliveryRetryPath = deliveriesPath + "/{" + paramDelivery + "}/retry"
+	paramWebhook      = "webhook"
+	paramDelivery     = "delivery"
+	paramStatus       = "status"
+
+	webhookSignatureHeader = "X-Webhook-Signature"
+	webhookTimestampHeader = "X-Webhook-Timestamp"
+	webhookEventHeader     = "X-Webhook-Event"
+	webhookDeliveryHeader  = "X-Webhook-Delivery"
+	webhookBatchSize       = 100
+	webhookConcurrency     = 10 // deliveries of a batch posted at once
+)
+
+var (
+	webhookMaxAttempts  = 6 // a delivery is dead once this many attempts failed
+	webhookBaseBackoff  = 30 * time.Second
+	webhookMaxBackoff   = time.Hour
+	webhookPollInterval = 5 * time.Second
+	webhookTimeout      = 10 * time.Second
+	webhookLease        = 5 * time.Minute // longer than a batch takes, at worst batch size over concurrency timeouts
+)
+
+// WithWebhookStore enables webhooks, subscriptions and their delivery queue being kept in the given store
+func WithWebhookStore(webhooks db.WebhookStoreInterface) ServiceOption {
+	return func(service *RestService) {
+		service.webhooks = webhooks
+	}
+}
+
+// WithPrivateWebhookTargets lets webhooks post to private, loopback and link-local addresses, for receivers on a trusted network
+func WithPrivateWebhookTargets() ServiceOption {
+	return func(service *RestService) {
+		service.webhookPrivateTargets = true
+		service.webhookClient = newWebhookClient(true)
+	}
+}
+
+// getWebhooks lists the webhooks of a library, without their secrets.
+// eg : api/libraries/{library}/webhooks
+func (r *RestService) getWebhooks(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Webhooks request")
+	webhooks, err := r.webhooks.GetAllWebhooks(r.libraryNameFromRequest(request))
+	if err != nil {
+		r.restWebhookError(writer, err)
+		return
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	r.restResponse(writer, http.StatusOK, webhooks)
+}
+
+// createWebhook subscribes an url to the book changes of a library, generating its secret when none is given.
+// The secret is only ever returned in this response.
+// eg : api/libraries/{library}/webhooks
+func (r *RestService) createWebhook(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received create Webhook request")
+	if _, err := r.libraryFromRequest(request); err != nil {
+		r.restLibraryError(writer, err)
+		return
+	}
+
+	webhook := &lib.Webhook{}
+	err := json.NewDecoder(request.Body).Decode(webhook)
+	if err != nil {
+		r.restResponse(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	err = webhook.Validate()
+	if err == nil {
+		err = r.checkWebhookTarget(request.Context(), webhook.URL)
+	}
+	if err != nil {
+		r.restResponse(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	if webhook.Secret == "" {
+		webhook.Secret, err = newWebhookSecret()
+		if err != nil {
+			stdError(err.Error())
+			r.restResponse(writer, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	webhook.Library = r.libraryNameFromRequest(request)
+	webhook.CreatedAt = r.clock.Now()
+
+	err = r.webhooks.CreateNewWebhook(webhook)
+	if err != nil {
+		r.restWebhookError(writer, err)
+		return
+	}
+	writer.Header().Set("Location", LibrariesPath+"/"+webhook.Library+"/webhooks/"+webhook.ID)
+	r.restResponse(writer, http.StatusOK, webhook)
+}
+
+// getWebhook retrieves a webhook of a library, without its secret.
+// eg : api/libraries/{library}/webhooks/{webhook}
+func (r *RestService) getWebhook(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Webhook request")
+	webhook, err := r.webhooks.GetOneWebhook(r.libraryNameFromRequest(request), mux.Vars(request)[paramWebhook])
+	if err != nil {
+		r.restWebhookError(writer, err)
+		return
+	}
+	webhook.Secret = ""
+	r.restResponse(writer, http.StatusOK, webhook)
+}
+
+// deleteWebhook unsubscribes a webhook, dropping its queued deliveries and logs.
+// eg : api/libraries/{library}/webhooks/{webhook}
+func (r *RestService) deleteWebhook(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received delete Webhook request")
+	err := r.webhooks.DeleteWebhook(r.libraryNameFromRequest(request), mux.Vars(request)[paramWebhook])
+	if err != nil {
+		r.restWebhookError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, nil)
+}
+
+// getDeliveries lists the deliveries of a webhook with the log of their attempts, optionally only those of the status query parameter.
+// eg : api/libraries/{library}/webhooks/{webhook}/deliveries?status=pending
+func (r *RestService) getDeliveries(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Deliveries request")
+	libraryName := r.libraryNameFromRequest(request)
+	webhookID := mux.Vars(request)[paramWebhook]
+	if _, err := r.webhooks.GetOneWebhook(libraryName, webhookID); err != nil {
+		r.restWebhookError(writer, err)
+		return
+	}
+	deliveries, err := r.webhooks.GetDeliveries(libraryName, webhookID, request.URL.Query().Get(paramStatus))
+	if err != nil {
+		r.restWebhookError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, deliveries)
+}
+
+// getDeadLetters lists the deliveries of every webhook of a library that were given up on.
+// eg : api/libraries/{library}/webhooks/deadletters
+func (r *RestService) getDeadLetters(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received get Dead Letters request")
+	deliveries, err := r.webhooks.GetDeliveries(r.libraryNameFromRequest(request), "", lib.DeliveryDead)
+	if err != nil {
+		r.restWebhookError(writer, err)
+		return
+	}
+	r.restResponse(writer, http.StatusOK, deliveries)
+}
+
+// retryDelivery queues a dead delivery again for a fresh round of attempts, keeping the log of earlier ones.
+// eg : api/libraries/{library}/webhooks/{webhook}/deliveries/{delivery}/retry
+func (r *RestService) retryDelivery(writer http.ResponseWriter, request *http.Request) {
+	stdInfo("received retry Delivery request")
+	params := mux.Vars(request)
+	delivery, err := r.webhooks.GetOneDelivery(r.libraryNameFromRequest(request), params[paramWebhook], params[paramDelivery])
+	if err != nil {
+		r.restWebhookError(writer, err)
+		return
+	}
+	if delivery.Status != lib.DeliveryDead {
+		r.restResponse(writer, http.StatusBadRequest, lib.DeliveryNotDead.Error())
+		return
+	}
+	delivery.Status = lib.DeliveryPending
+	delivery.Failures = 0
+	delivery.NextAttemptAt = r.clock.Now()
+	delivery.FinishedAt = nil
+	err = r.webhooks.UpdateDelivery(delivery)
+	if err != nil {
+		r.restWebhookError(writer, err)
+		return
+	}
+	r.wakeWebhookDispatcher()
+	r.restResponse(writer, http.StatusOK, delivery)
+}
+
+// notifyWebhooks queues a delivery of a book change to every webhook of the library subscribed to it,
+// failures are only logged as the change itself succeeded
+func (r *RestService) notifyWebhooks(libraryName, event string, book lib.BookIdentifier) {
+	if r.webhooks == nil {
+		return
+	}
+	webhooks, err := r.webhooks.GetAllWebhooks(libraryName)
+	if err != nil {
+		stdError("cant list webhooks to notify " + err.Error())
+		return
+	}
+	now := r.clock.Now()
+	book.DeletedAt = nil
+	queued := false
+	for _, webhook := range webhooks {
+		if !webhook.Subscribes(event) {
+			continue
+		}
+		err = r.webhooks.CreateNewDelivery(&lib.Delivery{
+			WebhookID: webhook.ID,
+			Library:   libraryName,
+			Payload: lib.WebhookPayload{
+				Event:   event,
+				Library: libraryName,
+				Book:    book,
+				Time:    now,
+			},
+			Status:        lib.DeliveryPending,
+			Attempts:      []lib.DeliveryAttempt{},
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+		if err != nil {
+			stdError("cant queue webhook delivery " + err.Error())
+			continue
+		}
+		queued = true
+	}
+	if queued {
+		r.wakeWebhookDispatcher()
+	}
+}
+
+// deliverWebhooksPeriodically delivers due deliveries whenever woken or polled until the service stops
+func (r *RestService) deliverWebhooksPeriodically() {
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+	for {
+		r.deliverDueWebhooks()
+		select {
+		case <-ticker.C:
+		case <-r.webhookWake:
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// wakeWebhookDispatcher asks the dispatcher to look for due deliveries without waiting for its next poll
+func (r *RestService) wakeWebhookDispatcher() {
+	select {
+	case r.webhookWake <- struct{}{}:
+	default: // already woken
+	}
+}
+
+// deliverDueWebhooks claims a batch of due deliveries and attempts them once, webhookConcurrency at a time, returning how
+// many were attempted. A delivery whose attempt is never recorded, as the service stopped, is attempted again once its
+// lease is over.
+func (r *RestService) deliverDueWebhooks() int {
+	deliveries, err := r.webhooks.ClaimDueDeliveries(r.clock.Now(), webhookLease, webhookBatchSize)
+	if err != nil {
+		stdError("cant claim due webhook deliveries " + err.Error())
+		if len(deliveries) == 0 {
+			return 0
+		}
+	}
+	slots := make(chan struct{}, webhookConcurrency)
+	var wait sync.WaitGroup
+	for i := range deliveries {
+		slots <- struct{}{}
+		wait.Add(1)
+		go func(delivery *lib.Delivery) {
+			defer wait.Done()
+			r.attemptDelivery(delivery)
+			<-slots
+		}(&deliveries[i])
+	}
+	wait.Wait()
+	if len(deliveries) == webhookBatchSize {
+		r.wakeWebhookDispatcher() // there may be more
+	}
+	return len(deliveries)
+}
+
+// attemptDelivery posts a delivery to its webhook, logging the attempt and scheduling a retry with exponential backoff
+// when it fails, until it is dead after the last attempt
+func (r *RestService) attemptDelivery(delivery *lib.Delivery) {
+	webhook, err := r.webhooks.GetOneWebhook(delivery.Library, delivery.WebhookID)
+	if err != nil {
+		if !errors.Is(err, lib.NoMatchingWebhook) { // deleted webhooks take their deliveries with them
+			stdError("cant load webhook to deliver " + err.Error())
+		}
+		return
+	}
+
+	attempt := lib.DeliveryAttempt{Time: r.clock.Now()}
+	attempt.StatusCode, err = r.postWebhook(webhook, delivery, attempt.Time)
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+	delivery.Attempts = append(delivery.Attempts, attempt)
+
+	if err == nil {
+		delivery.Status = lib.DeliveryDelivered
+		delivery.FinishedAt = &attempt.Time
+	} else {
+		delivery.Failures++
+		if delivery.Failures >= webhookMaxAttempts {
+			delivery.Status = lib.DeliveryDead
+			delivery.FinishedAt = &attempt.Time
+			stdError("webhook delivery " + delivery.ID + " is dead " + attempt.Error)
+		} else {
+			delivery.NextAttemptAt = attempt.Time.Add(webhookBackoff(delivery.Failures))
+		}
+	}
+	err = r.webhooks.UpdateDelivery(delivery)
+	if err != nil && !errors.Is(err, lib.NoMatchingDelivery) {
+		stdError("cant update webhook delivery " + err.Error())
+	}
+}
+
+// postWebhook posts the payload of a delivery signed along with the time of the attempt, any response but a 2xx is a failure,
+// redirects included
+func (r *RestService) postWebhook(webhook *lib.Webhook, delivery *lib.Delivery, now time.Time) (int, error) {
+	body, err := json.Marshal(delivery.Payload)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, delivery.Payload.Event)
+	req.Header.Set(webhookDeliveryHeader, delivery.ID)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, signWebhookPayload(webhook.Secret, timestamp, body))
+
+	response, err := r.webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10)) // drained so the connection can be reused
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, errors.New("webhook responded " + response.Status)
+	}
+	return response.StatusCode, nil
+}
+
+// restWebhookError responds to a failed webhook operation
+func (r *RestService) restWebhookError(writer http.ResponseWriter, err error) {
+	if errors.Is(err, lib.NoMatchingWebhook) || errors.Is(err, lib.NoMatchingDelivery) {
+		r.restResponse(writer, http.StatusNotFound, err.Error())
+		return
+	}
+	stdError(err.Error())
+	r.restResponse(writer, http.StatusInternalServerError, err.Error())
+}
+
+// newWebhookClient returns the client posting deliveries, which does not follow redirects and, unless private targets
+// are allowed, refuses to connect to private, loopback and link-local addresses whatever the host of the webhook resolves to
+// when posting. Proxies are not used, as the address connected to would be theirs.
+func newWebhookClient(privateTargets bool) *http.Client {
+	dialer := &net.Dialer{Timeout: webhookTimeout}
+	if !privateTargets {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isPrivateAddress(ip) {
+				return lib.PrivateWebhook
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:       webhookTimeout,
+		Transport:     transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
+
+// checkWebhookTarget refuses webhook urls whose host is, or resolves to, a private, loopback or link-local address
+// unless private targets are allowed. The addresses are checked again when posting, as they may resolve otherwise by then.
+func (r *RestService) checkWebhookTarget(ctx context.Context, webhookURL string) error {
+	if r.webhookPrivateTargets {
+		return nil
+	}
+	parsed, err := url.Parse(webhookURL)
+	if err != nil {
+		return lib.IncorrectWebhook
+	}
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	addresses, err := net.DefaultResolver.LookupIPAddr(ctx, parsed.Hostname())
+	if err != nil {
+		return lib.IncorrectWebhook
+	}
+	for _, address := range addresses {
+		if isPrivateAddress(address.IP) {
+			return lib.PrivateWebhook
+		}
+	}
+	return nil
+}
+
+// isPrivateAddress checks whether an address is private, loopback, link-local, unspecified or multicast
+func isPrivateAddress(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast()
+}
+
+// signWebhookPayload returns the signature header of a payload, the hex HMAC-SHA256 of the timestamp header, a dot and
+// the body keyed by the webhook secret, so that receivers can refuse replays of old payloads
+func signWebhookPayload(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff returns the wait before the next attempt after the given number of failed ones, doubling from the base up to the max
+func webhookBackoff(failed int) time.Duration {
+	backoff := webhookBaseBackoff
+	for i := 1; i < failed && backoff < webhookMaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, webhookMaxBackoff)
+}
+
+// newWebhookSecret returns a random 32 byte hex secret
+func newWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
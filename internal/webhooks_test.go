@@ -0,0 +1,361 @@
+package internal
+
+import (
+	"dockerrestapi/db"
+	"dockerrestapi/lib"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver records the payloads posted to it whose signature checks out, failing with its status while it is not 200
+type webhookReceiver struct {
+	lock       sync.Mutex
+	secret     string
+	status     int
+	payloads   []lib.WebhookPayload
+	timestamps []string
+	forged     int
+}
+
+func (w *webhookReceiver) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	body, _ := io.ReadAll(request.Body)
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if request.Header.Get(webhookSignatureHeader) != signWebhookPayload(w.secret, request.Header.Get(webhookTimestampHeader), body) {
+		w.forged++
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if w.status != http.StatusOK {
+		writer.WriteHeader(w.status)
+		return
+	}
+	var payload lib.WebhookPayload
+	_ = json.Unmarshal(body, &payload)
+	w.payloads = append(w.payloads, payload)
+	w.timestamps = append(w.timestamps, request.Header.Get(webhookTimestampHeader))
+}
+
+func (w *webhookReceiver) received() []lib.WebhookPayload {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return append([]lib.WebhookPayload{}, w.payloads...)
+}
+
+func (w *webhookReceiver) setStatus(status int) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.status = status
+}
+
+func TestWebhooks(t *testing.T) {
+	clock := lib.NewManualClock(time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC))
+	mockConn, err := db.CreateMockDBHandlerWithClock(clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhookStore, err := db.CreateMockWebhookStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhookApi, err := CreateRestApiService(mockConn, "8081", WithWebhookStore(webhookStore), WithClock(clock), WithPrivateWebhookTargets())
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver := &webhookReceiver{secret: "s3cret", status: http.StatusOK}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	library := map[string]string{paramLibrary: lib.DefaultLibraryName}
+
+	// subscribe to created and deleted books, refusing bad urls and events
+	for _, bad := range []string{`{"url":"ftp://example.com"}`, `{"url":"/relative"}`, `{"url":"` + server.URL + `","events":["read"]}`} {
+		_, err = testResponse(http.MethodPut, webhooksPath, webhookApi.createWebhook, []byte(bad), http.StatusBadRequest, library)
+		if err != nil {
+			t.Error(bad, err)
+		}
+	}
+	response, err := testResponse(http.MethodPut, webhooksPath, webhookApi.createWebhook,
+		[]byte(`{"url":"`+server.URL+`","secret":"s3cret","events":["created","deleted"]}`), http.StatusOK, library)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var webhook lib.Webhook
+	err = json.Unmarshal([]byte(response), &webhook)
+	if err != nil || webhook.ID == "" || webhook.Secret != "s3cret" {
+		t.Fatal("expecting the created webhook got", response)
+	}
+	webhookParams := map[string]string{paramLibrary: lib.DefaultLibraryName, paramWebhook: webhook.ID}
+	response, err = testResponse(http.MethodGet, webhookPath, webhookApi.getWebhook, nil, http.StatusOK, webhookParams)
+	if err != nil {
+		t.Error(err)
+	}
+	var retrieved lib.Webhook
+	if err = json.Unmarshal([]byte(response), &retrieved); err != nil || retrieved.ID != webhook.ID || retrieved.Secret != "" {
+		t.Error("secrets are only returned on creation got", response)
+	}
+
+	// a created book is posted signed, an update is not subscribed to
+	book, err := json.Marshal(lib.Book{Name: "hooked", Author: "philip", Contents: "A hooked read"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = testResponse(http.MethodPut, createBookPath, webhookApi.createBook, book, http.StatusOK, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = testResponse(http.MethodPut, updateBookPath, webhookApi.updateBook, book, http.StatusOK, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempted := webhookApi.deliverDueWebhooks(); attempted != 1 {
+		t.Error("expecting 1 delivery attempted got", attempted)
+	}
+	payloads := receiver.received()
+	if len(payloads) != 1 || payloads[0].Event != lib.ChangeCreated || payloads[0].Book.Name != "hooked" || payloads[0].Book.ID == "" {
+		t.Fatal("expecting the created book posted got", payloads)
+	}
+	if timestamp := receiver.timestamps[0]; timestamp != strconv.FormatInt(clock.Now().Unix(), 10) {
+		t.Error("expecting the attempt time signed got", timestamp)
+	}
+	bookID := payloads[0].Book.ID
+
+	// a failing receiver is retried with backoff until the delivery is dead
+	receiver.setStatus(http.StatusServiceUnavailable)
+	_, err = testResponse(http.MethodDelete, deleteBookPath, webhookApi.deleteBook, nil, http.StatusOK, map[string]string{paramName: "hooked", paramAuthor: "philip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhookApi.deliverDueWebhooks()
+	if attempted := webhookApi.deliverDueWebhooks(); attempted != 0 {
+		t.Error("expecting the retry to wait for its backoff got", attempted, "attempts")
+	}
+	clock.Advance(webhookBaseBackoff)
+	if attempted := webhookApi.deliverDueWebhooks(); attempted != 1 {
+		t.Error("expecting the retry after its backoff got", attempted, "attempts")
+	}
+	for i := 2; i < webhookMaxAttempts; i++ {
+		clock.Advance(webhookMaxBackoff)
+		webhookApi.deliverDueWebhooks()
+	}
+	response, err = testResponse(http.MethodGet, deadLettersPath, webhookApi.getDeadLetters, nil, http.StatusOK, library)
+	if err != nil {
+		t.Error(err)
+	}
+	var dead []lib.Delivery
+	err = json.Unmarshal([]byte(response), &dead)
+	if err != nil || len(dead) != 1 || len(dead[0].Attempts) != webhookMaxAttempts || dead[0].Attempts[0].StatusCode != http.StatusServiceUnavailable {
+		t.Fatal("expecting one dead delivery with every attempt logged got", response)
+	}
+	if dead[0].Payload.Event != lib.ChangeDeleted || dead[0].Payload.Book.ID != bookID {
+		t.Error("expecting the deleted book to be dead got", dead[0].Payload)
+	}
+
+	// retrying a dead delivery by hand
+	deliveryParams := map[string]string{paramLibrary: lib.DefaultLibraryName, paramWebhook: webhook.ID, paramDelivery: dead[0].ID}
+	receiver.setStatus(http.StatusOK)
+	_, err = testResponse(http.MethodPut, deliveryRetryPath, webhookApi.retryDelivery, nil, http.StatusOK, deliveryParams)
+	if err != nil {
+		t.Error(err)
+	}
+	webhookApi.deliverDueWebhooks()
+	_, err = testResponse(http.MethodPut, deliveryRetryPath, webhookApi.retryDelivery, nil, http.StatusBadRequest, deliveryParams)
+	if err != nil {
+		t.Error("delivered deliveries cannot be retried", err)
+	}
+	if payloads = receiver.received(); len(payloads) != 2 || payloads[1].Event != lib.ChangeDeleted {
+		t.Error("expecting the deleted book posted after the retry got", payloads)
+	}
+
+	// the delivery log of the webhook
+	response, err = testResponse(http.MethodGet, deliveriesPath, webhookApi.getDeliveries, nil, http.StatusOK, webhookParams)
+	if err != nil {
+		t.Error(err)
+	}
+	var deliveries []lib.Delivery
+	err = json.Unmarshal([]byte(response), &deliveries)
+	if err != nil || len(deliveries) != 2 || deliveries[0].Status != lib.DeliveryDelivered || deliveries[1].Status != lib.DeliveryDelivered ||
+		len(deliveries[1].Attempts) != webhookMaxAttempts+1 {
+		t.Error("expecting both deliveries delivered got", response)
+	}
+	for _, delivery := range deliveries {
+		if delivery.FinishedAt == nil || !delivery.FinishedAt.Equal(delivery.Attempts[len(delivery.Attempts)-1].Time) {
+			t.Error("expecting finished deliveries to be expired from their last attempt got", delivery.FinishedAt)
+		}
+	}
+	if receiver.forged != 0 {
+		t.Error("expecting every payload correctly signed got", receiver.forged, "forged")
+	}
+
+	// unsubscribe
+	_, err = testResponse(http.MethodDelete, webhookPath, webhookApi.deleteWebhook, nil, http.StatusOK, webhookParams)
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = testResponse(http.MethodGet, deliveriesPath, webhookApi.getDeliveries, nil, http.StatusNotFound, webhookParams)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWebhookTargets(t *testing.T) {
+	webhookStore, err := db.CreateMockWebhookStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockConn, err := db.CreateMockDBHandlerWithClock(testClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhookApi, err := CreateRestApiService(mockConn, "8081", WithWebhookStore(webhookStore))
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver := &webhookReceiver{secret: "s3cret", status: http.StatusOK}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	// private, loopback and link-local targets are refused when subscribing
+	library := map[string]string{paramLibrary: lib.DefaultLibraryName}
+	for _, target := range []string{server.URL, "http://localhost:8081/hook", "http://10.0.0.1/hook", "http://[::1]/hook", "http://169.254.169.254/latest/meta-data"} {
+		response, err := testResponse(http.MethodPut, webhooksPath, webhookApi.createWebhook, []byte(`{"url":"`+target+`"}`), http.StatusBadRequest, library)
+		if err != nil || response != `"`+lib.PrivateWebhook.Error()+`"` {
+			t.Error("expecting", target, "refused got", response, err)
+		}
+	}
+
+	// and when posting, whatever the host resolved to when subscribing
+	err = webhookStore.CreateNewWebhook(&lib.Webhook{Library: lib.DefaultLibraryName, URL: server.URL, Secret: "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhookApi.notifyWebhooks(lib.DefaultLibraryName, lib.ChangeCreated, lib.BookIdentifier{ID: "1"})
+	webhookApi.deliverDueWebhooks()
+	deliveries, err := webhookStore.GetDeliveries(lib.DefaultLibraryName, "", lib.DeliveryPending)
+	if err != nil || len(deliveries) != 1 || !strings.Contains(deliveries[0].Attempts[0].Error, lib.PrivateWebhook.Error()) {
+		t.Error("expecting the post refused got", deliveries, err)
+	}
+	if received := receiver.received(); len(received) != 0 {
+		t.Error("expecting nothing posted got", received)
+	}
+
+	// redirects are failures, not followed
+	redirect := httptest.NewServer(http.RedirectHandler(server.URL, http.StatusFound))
+	defer redirect.Close()
+	client := newWebhookClient(true)
+	response, err := client.Post(redirect.URL, "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusFound || len(receiver.received()) != 0 {
+		t.Error("expecting the redirect returned got", response.Status)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	expected := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute}
+	for i, backoff := range expected {
+		if got := webhookBackoff(i + 1); got != backoff {
+			t.Error("expecting", backoff, "after", i+1, "failures got", got)
+		}
+	}
+	if got := webhookBackoff(50); got != webhookMaxBackoff {
+		t.Error("expecting the backoff capped at", webhookMaxBackoff, "got", got)
+	}
+}
+
+func TestWebhookDeliveryClaims(t *testing.T) {
+	clock := lib.NewManualClock(time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC))
+	mockConn, err := db.CreateMockDBHandlerWithClock(clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhookStore, err := db.CreateMockWebhookStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhookApi, err := CreateRestApiService(mockConn, "8081", WithWebhookStore(webhookStore), WithClock(clock), WithPrivateWebhookTargets())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a receiver holding every post until released
+	var lock sync.Mutex
+	inFlight, mostInFlight, posted := 0, 0, map[string]int{}
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		lock.Lock()
+		inFlight++
+		mostInFlight = max(mostInFlight, inFlight)
+		posted[request.Header.Get(webhookDeliveryHeader)]++
+		lock.Unlock()
+		<-release
+		lock.Lock()
+		inFlight--
+		lock.Unlock()
+	}))
+	defer server.Close()
+	webhook := &lib.Webhook{Library: lib.DefaultLibraryName, URL: server.URL, Secret: "s3cret"}
+	err = webhookStore.CreateNewWebhook(webhook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue := func(count int) {
+		for i := 0; i < count; i++ {
+			webhookApi.notifyWebhooks(lib.DefaultLibraryName, lib.ChangeCreated, lib.BookIdentifier{ID: strconv.Itoa(i)})
+		}
+	}
+
+	// deliveries are posted webhookConcurrency at a time, claimed deliveries are left to their dispatcher
+	queue(2 * webhookConcurrency)
+	attempted := make(chan int)
+	go func() { attempted <- webhookApi.deliverDueWebhooks() }()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		lock.Lock()
+		busy := inFlight
+		lock.Unlock()
+		if busy == webhookConcurrency {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expecting", webhookConcurrency, "posts at once got", busy)
+		}
+	}
+	if others := webhookApi.deliverDueWebhooks(); others != 0 {
+		t.Error("expecting claimed deliveries left alone got", others, "attempts")
+	}
+	close(release)
+	if count := <-attempted; count != 2*webhookConcurrency {
+		t.Error("expecting every delivery attempted got", count)
+	}
+	if mostInFlight != webhookConcurrency || len(posted) != 2*webhookConcurrency {
+		t.Error("expecting", 2*webhookConcurrency, "deliveries posted", webhookConcurrency, "at a time got", len(posted), mostInFlight)
+	}
+	for id, count := range posted {
+		if count != 1 {
+			t.Error("expecting delivery", id, "posted once got", count)
+		}
+	}
+
+	// a delivery claimed by a dispatcher that stopped is attempted again once its lease is over
+	queue(1)
+	claimed, err := webhookStore.ClaimDueDeliveries(clock.Now(), webhookLease, webhookBatchSize)
+	if err != nil || len(claimed) != 1 {
+		t.Fatal("expecting the delivery claimed got", claimed, err)
+	}
+	if count := webhookApi.deliverDueWebhooks(); count != 0 {
+		t.Error("expecting the leased delivery left alone got", count, "attempts")
+	}
+	clock.Advance(webhookLease)
+	if count := webhookApi.deliverDueWebhooks(); count != 1 {
+		t.Error("expecting the delivery attempted after its lease got", count, "attempts")
+	}
+}
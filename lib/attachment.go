@@ -0,0 +1,32 @@
+package lib
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	JsonBsonTagBookID    = "bookId"
+	JsonBsonTagSHA256    = "sha256"
+	JsonBsonTagThumbnail = "thumbnail"
+)
+
+// Attachment is a binary file, such as cover art or an EPUB, attached to a book.
+// The file itself is stored content addressed by its SHA-256, so identical uploads share one stored blob.
+type Attachment struct {
+	ID          string    `bson:"_id,omitempty" json:"id,omitempty"`
+	BookID      string    `bson:"bookId" json:"bookId"`
+	FileName    string    `bson:"fileName" json:"fileName"`
+	ContentType string    `bson:"contentType" json:"contentType"`
+	Size        int64     `bson:"size" json:"size"`
+	SHA256      string    `bson:"sha256" json:"sha256"`
+	Thumbnail   string    `bson:"thumbnail,omitempty" json:"thumbnail,omitempty"` // SHA-256 of the generated PNG thumbnail, images only
+	CreatedAt   time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+var ( // Errors
+	NoMatchingAttachment      = errors.New("no matching attachment for book")
+	NoMatchingBlob            = errors.New("no matching blob in store")
+	AttachmentTooLarge        = errors.New("attachment is too large")
+	UnsupportedAttachmentType = errors.New("unsupported attachment type, expecting an image, pdf or epub")
+)
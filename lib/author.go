@@ -0,0 +1,76 @@
+package lib
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	JsonBsonTagAuthorID   = "authorId"
+	JsonBsonTagAuthorKeys = "keys"
+	JsonBsonTagAliases    = "aliases"
+)
+
+// Author is a person books are written by, known by a canonical name and any number of aliases, eg "J.K. Rowling" and "JKR".
+// Books written under any of its names are stored under the canonical name and linked to the author by id.
+type Author struct {
+	ID        string    `bson:"_id,omitempty" json:"id,omitempty"`
+	Name      string    `bson:"name" json:"name"`
+	Aliases   []string  `bson:"aliases,omitempty" json:"aliases,omitempty"`
+	Biography string    `bson:"biography,omitempty" json:"biography,omitempty"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+
+	Keys []string `bson:"keys" json:"-"` // keys of the name and aliases, unique across the authors of a library
+}
+
+var ( // Errors
+	NoMatchingAuthor    = errors.New("no matching author in library")
+	AuthorAlreadyExists = errors.New("another author already has this name or alias")
+	IncompleteAuthor    = errors.New("authors need a name")
+	AuthorHasBooks      = errors.New("author still has books, merge it into another author instead")
+	CannotMergeAuthor   = errors.New("an author cannot be merged into itself")
+)
+
+// AuthorKey returns the key an author name is matched by, ignoring case, accents and surrounding spaces as the authors of books are
+func AuthorKey(name string) string {
+	return FoldName(strings.TrimSpace(name))
+}
+
+// Normalise validates an author and normalises it in place: names are trimmed, aliases repeating the name or
+// another alias are dropped, and the keys are set.
+func (a *Author) Normalise() error {
+	a.Name = strings.TrimSpace(a.Name)
+	if a.Name == "" {
+		return IncompleteAuthor
+	}
+	a.Keys = []string{AuthorKey(a.Name)}
+	aliases := a.Aliases
+	a.Aliases = nil
+	for _, alias := range aliases {
+		alias = strings.TrimSpace(alias)
+		if alias == "" || a.HasName(alias) {
+			continue
+		}
+		a.Aliases = append(a.Aliases, alias)
+		a.Keys = append(a.Keys, AuthorKey(alias))
+	}
+	return nil
+}
+
+// HasName checks whether the name is the canonical name or an alias of the author
+func (a *Author) HasName(name string) bool {
+	return containsString(a.Keys, AuthorKey(name))
+}
+
+// Names returns the canonical name followed by the aliases of the author
+func (a *Author) Names() []string {
+	return append([]string{a.Name}, a.Aliases...)
+}
+
+// Merge adds the names of another author as aliases of this one
+func (a *Author) Merge(other *Author) {
+	a.Aliases = append(a.Aliases, other.Names()...)
+	_ = a.Normalise() // the name is already valid
+}
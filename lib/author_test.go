@@ -0,0 +1,41 @@
+package lib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAuthorNormalise(t *testing.T) {
+	author := &Author{Name: " J.K. Rowling ", Aliases: []string{"JKR", " ", "jkr", "j.k. rowling", " Robert Galbraith"}}
+	err := author.Normalise()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if author.Name != "J.K. Rowling" || !reflect.DeepEqual(author.Aliases, []string{"JKR", "Robert Galbraith"}) {
+		t.Error("expecting trimmed distinct names got", author.Name, author.Aliases)
+	}
+	if !reflect.DeepEqual(author.Keys, []string{"j.k. rowling", "jkr", "robert galbraith"}) {
+		t.Error("expecting lower case keys got", author.Keys)
+	}
+	if !author.HasName(" ROBERT galbraith") || author.HasName("Rowling") {
+		t.Error("expecting names to match ignoring case, accents and spaces only")
+	}
+	accented := &Author{Name: "Emily Brontë", Aliases: []string{"emily bronte", "Ellis Bell"}}
+	err = accented.Normalise()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(accented.Aliases, []string{"Ellis Bell"}) || !accented.HasName("EMILY BRONTE") {
+		t.Error("expecting names to match ignoring accents got", accented.Aliases)
+	}
+
+	other := &Author{Name: "Galbraith", Aliases: []string{"jkr"}}
+	author.Merge(other)
+	if !reflect.DeepEqual(author.Aliases, []string{"JKR", "Robert Galbraith", "Galbraith"}) {
+		t.Error("expecting the merged names as aliases got", author.Aliases)
+	}
+
+	if err = (&Author{Aliases: []string{"JKR"}}).Normalise(); err != IncompleteAuthor {
+		t.Error("expecting an incomplete author got", err)
+	}
+}
@@ -0,0 +1,31 @@
+package lib
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	ChangeCreated  = "created"
+	ChangeUpdated  = "updated"
+	ChangeDeleted  = "deleted" // moved to the trash
+	ChangeRestored = "restored"
+	ChangePurged   = "purged"
+)
+
+// ChangeEvent describes a change to a book of a library. Token is opaque, a client reconnecting with it receives every later event.
+type ChangeEvent struct {
+	Token  string    `json:"token"`
+	Type   string    `json:"type"`
+	BookID string    `json:"id"`
+	Name   string    `json:"name,omitempty"` // empty when the book was purged before the event was read
+	Author string    `json:"author,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+var ( // Errors
+	IncorrectResumeToken = errors.New("resume token is not valid")
+	ResumeTokenExpired   = errors.New("resume token is too old, changes since were lost")
+	ChangeStreamLagging  = errors.New("change stream fell too far behind and was closed, resume from the last token")
+	ChangeStreamClosed   = errors.New("change stream was closed, the library was dropped")
+)
@@ -0,0 +1,41 @@
+package lib
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time, injected wherever timestamps are taken so tests can control it.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the wall clock, in UTC and truncated to the millisecond precision of stored dates.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+// ManualClock only moves when told to, for deterministic tests.
+type ManualClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward
+func (c *ManualClock) Advance(duration time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(duration)
+}
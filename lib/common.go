@@ -6,27 +6,77 @@ import (
 )
 
 const (
-	JsonBsonTagName        = "name"
-	JsonBsonTagAuthor      = "author"
-	JsonBsonTagContents    = "contents"
-	JsonBsonTagUpdatedTime = "updateDate"
-	DbTimeFormat           = time.UnixDate
+	JsonBsonTagID             = "_id"
+	JsonBsonTagName           = "name"
+	JsonBsonTagAuthor         = "author"
+	JsonBsonTagContents       = "contents"
+	JsonBsonTagCreatedAt      = "createdAt"
+	JsonBsonTagUpdatedAt      = "updatedAt"
+	JsonBsonTagDeletedAt      = "deletedAt"
+	JsonBsonTagContentsFile   = "contentsFile"
+	JsonBsonTagContentsLength = "contentsLength"
 )
 
 type BookIdentifier struct {
+	ID     string `bson:"_id,omitempty" json:"id,omitempty"`
 	Name   string `bson:"name" json:"name,omitempty" `
 	Author string `bson:"author" json:"author,omitempty"`
+
+	DeletedAt *time.Time `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"` // only set when listing the trash
 }
 
 type Book struct {
-	Name        string `bson:"name" json:"name,omitempty" `
-	Author      string `bson:"author" json:"author,omitempty"`
-	Contents    string `bson:"contents" json:"contents,omitempty"`
-	UpdatedDate string `bson:"updateDate" json:"updateDate,omitempty"`
+	ID        string     `bson:"_id,omitempty" json:"id,omitempty"`
+	Name      string     `bson:"name" json:"name,omitempty" `
+	Author    string     `bson:"author" json:"author,omitempty"`
+	AuthorID  string     `bson:"authorId,omitempty" json:"authorId,omitempty"` // set when the author is a known author, Author is then its canonical name
+	Contents  string     `bson:"contents" json:"contents,omitempty"`
+	CreatedAt time.Time  `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time  `bson:"updatedAt" json:"updatedAt"`
+	DeletedAt *time.Time `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"` // set while the book is in the trash
+
+	ContentsLength int64  `bson:"contentsLength" json:"contentsLength,omitempty"`
+	ContentsFile   string `bson:"contentsFile" json:"-"`           // id of the file holding contents too large to store inline, Contents is then empty
+	ContentsHash   string `bson:"contentsHash,omitempty" json:"-"` // hex SHA-256 of the contents, set whenever they are written
+
+	ISBN10          string        `bson:"isbn10,omitempty" json:"isbn10,omitempty"`
+	ISBN13          string        `bson:"isbn13,omitempty" json:"isbn13,omitempty"`
+	Contributors    []Contributor `bson:"contributors,omitempty" json:"contributors,omitempty"` // authors, editors, translators... beyond the primary author
+	Publisher       string        `bson:"publisher,omitempty" json:"publisher,omitempty"`
+	PublicationDate string        `bson:"publicationDate,omitempty" json:"publicationDate,omitempty"` // YYYY, YYYY-MM or YYYY-MM-DD
+	Language        string        `bson:"language,omitempty" json:"language,omitempty"`               // BCP 47 tag
+	PageCount       int           `bson:"pageCount,omitempty" json:"pageCount,omitempty"`
+	Series          string        `bson:"series,omitempty" json:"series,omitempty"`
+	Volume          int           `bson:"volume,omitempty" json:"volume,omitempty"`
+	Tags            []string      `bson:"tags,omitempty" json:"tags,omitempty"`
+
+	Rating *RatingSummary `bson:"rating,omitempty" json:"rating,omitempty"` // kept up to date by reviews, never written with the book
+
+	ChapterPattern string         `bson:"chapterPattern,omitempty" json:"chapterPattern,omitempty"` // regular expression of chapter lines, Markdown headings when empty
+	Structure      *BookStructure `bson:"structure,omitempty" json:"-"`                             // parsed from the contents whenever they are written
+	Stats          *BookStats     `bson:"stats,omitempty" json:"stats,omitempty"`                   // counted from the contents whenever they are written
+	Terms          []TermCount    `bson:"terms,omitempty" json:"-"`                                 // the TermVectorSize most frequent terms of the contents, for similarity
+}
+
+// Identifier returns the identifier of the book
+func (b *Book) Identifier() BookIdentifier {
+	return BookIdentifier{
+		ID:        b.ID,
+		Name:      b.Name,
+		Author:    b.Author,
+		DeletedAt: b.DeletedAt,
+	}
 }
 
 var ( // Errors
-	NoMatchingBook      = errors.New("no matching book in library")
-	BookAlreadyExists   = errors.New("book already exists library")
-	IncorrectParameters = errors.New("incorrect request parameter")
+	NoMatchingBook        = errors.New("no matching book in library")
+	BookAlreadyExists     = errors.New("book already exists library")
+	IncorrectParameters   = errors.New("incorrect request parameter")
+	IncompleteBook        = errors.New("not enough information to store book")
+	UnsatisfiableRange    = errors.New("requested range not satisfiable")
+	IncorrectTimestamp    = errors.New("timestamps must be RFC 3339, eg 2006-01-02T15:04:05Z")
+	NoMatchingDeletedBook = errors.New("no matching book in trash")
+	QueryTooDeep          = errors.New("query is nested too deeply")
+	QueryTooComplex       = errors.New("query selects too many fields")
+	FragmentCycle         = errors.New("query fragments cannot spread themselves")
 )
@@ -0,0 +1,466 @@
+package lib
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	DiffGranularityLine = "line"
+	DiffGranularityWord = "word"
+
+	DiffEqual  = "equal"
+	DiffInsert = "insert"
+	DiffDelete = "delete"
+
+	DefaultDiffContext = 3 // unchanged lines shown around changes
+	MaxDiffContext     = 100
+	MaxDiffSize        = 16 << 20 // bytes of both texts together
+
+	minDiffCost = 256 // edits searched for a middle snake before settling for the furthest reaching path
+)
+
+var ( // Errors
+	IncorrectDiffGranularity = errors.New("diff granularity must be line or word")
+	IncorrectDiffContext     = errors.New("diff context must be from 0 to 100 lines")
+	DiffTooLarge             = errors.New("texts are too large to diff")
+)
+
+// Diff is the difference between two texts, as hunks of changed lines surrounded by unchanged ones
+type Diff struct {
+	From        int        `json:"from"` // revision numbers of the texts, 0 for no contents
+	To          int        `json:"to"`
+	Granularity string     `json:"granularity"`
+	Insertions  int        `json:"insertions"` // inserted lines, or words for word diffs
+	Deletions   int        `json:"deletions"`
+	Hunks       []DiffHunk `json:"hunks"`
+}
+
+// DiffHunk is a range of lines holding changes. Line diffs have a segment for each line, word diffs have segments of the
+// words and spaces of the lines, line ends included in both.
+type DiffHunk struct {
+	FromLine  int           `json:"fromLine"` // first line of the hunk, counted from 1, in each text
+	FromCount int           `json:"fromCount"`
+	ToLine    int           `json:"toLine"`
+	ToCount   int           `json:"toCount"`
+	Segments  []DiffSegment `json:"segments"`
+}
+
+// DiffSegment is text kept, inserted or deleted
+type DiffSegment struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// DiffTexts compares two texts line by line, and for word diffs word by word within the changed lines. Hunks are
+// surrounded by up to context unchanged lines, hunks closer than twice that are merged.
+func DiffTexts(from, to, granularity string, context int) (*Diff, error) {
+	if granularity != DiffGranularityLine && granularity != DiffGranularityWord {
+		return nil, IncorrectDiffGranularity
+	}
+	if context < 0 || context > MaxDiffContext {
+		return nil, IncorrectDiffContext
+	}
+	if len(from)+len(to) > MaxDiffSize {
+		return nil, DiffTooLarge
+	}
+	fromLines, toLines := splitLines(from), splitLines(to)
+	edits := diffTokens(fromLines, toLines)
+
+	diff := &Diff{Granularity: granularity, Hunks: []DiffHunk{}}
+	for _, span := range hunkSpans(edits, context) {
+		hunk := DiffHunk{FromLine: edits[span[0]].from + 1, ToLine: edits[span[0]].to + 1}
+		var fromText, toText strings.Builder
+		for _, edit := range edits[span[0]:span[1]] {
+			if edit.op != DiffInsert {
+				hunk.FromCount++
+				fromText.WriteString(fromLines[edit.from])
+			}
+			if edit.op != DiffDelete {
+				hunk.ToCount++
+				toText.WriteString(toLines[edit.to])
+			}
+			if granularity == DiffGranularityLine {
+				text := ""
+				if edit.op == DiffInsert {
+					text = toLines[edit.to]
+				} else {
+					text = fromLines[edit.from]
+				}
+				hunk.Segments = append(hunk.Segments, DiffSegment{Op: edit.op, Text: text})
+				diff.count(edit.op)
+			}
+		}
+		if granularity == DiffGranularityWord {
+			hunk.Segments = diff.wordSegments(fromText.String(), toText.String())
+		}
+		// empty ranges are numbered after the line they follow, as unified diffs do
+		if hunk.FromCount == 0 {
+			hunk.FromLine--
+		}
+		if hunk.ToCount == 0 {
+			hunk.ToLine--
+		}
+		diff.Hunks = append(diff.Hunks, hunk)
+	}
+	return diff, nil
+}
+
+// count counts an inserted or deleted line or word
+func (d *Diff) count(op string) {
+	switch op {
+	case DiffInsert:
+		d.Insertions++
+	case DiffDelete:
+		d.Deletions++
+	}
+}
+
+// wordSegments compares the lines of a hunk word by word, merging successive words of the same operation
+func (d *Diff) wordSegments(from, to string) []DiffSegment {
+	fromWords, toWords := splitWords(from), splitWords(to)
+	var segments []DiffSegment
+	for _, edit := range diffTokens(fromWords, toWords) {
+		text := ""
+		if edit.op == DiffInsert {
+			text = toWords[edit.to]
+		} else {
+			text = fromWords[edit.from]
+		}
+		if strings.TrimSpace(text) != "" {
+			d.count(edit.op)
+		}
+		if last := len(segments) - 1; last >= 0 && segments[last].Op == edit.op {
+			segments[last].Text += text
+			continue
+		}
+		segments = append(segments, DiffSegment{Op: edit.op, Text: text})
+	}
+	return segments
+}
+
+// WriteUnified writes the diff in the unified format, word diffs marking changes within lines as [-deleted-] and {+inserted+}
+func (d *Diff) WriteUnified(writer io.Writer, fromName, toName string) error {
+	output := bufio.NewWriter(writer)
+	_, _ = fmt.Fprintf(output, "--- %s\n+++ %s\n", fromName, toName)
+	for _, hunk := range d.Hunks {
+		_, _ = fmt.Fprintf(output, "@@ -%s +%s @@\n", unifiedRange(hunk.FromLine, hunk.FromCount), unifiedRange(hunk.ToLine, hunk.ToCount))
+		if d.Granularity == DiffGranularityWord {
+			writeWordSegments(output, hunk.Segments)
+			continue
+		}
+		for _, segment := range hunk.Segments {
+			prefix := " "
+			switch segment.Op {
+			case DiffInsert:
+				prefix = "+"
+			case DiffDelete:
+				prefix = "-"
+			}
+			_, _ = output.WriteString(prefix + segment.Text)
+			if !strings.HasSuffix(segment.Text, "\n") {
+				_, _ = output.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+	}
+	return output.Flush()
+}
+
+// unifiedRange formats the range of lines of a hunk, its start alone for a single line
+func unifiedRange(start, count int) string {
+	if count == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// writeWordSegments writes the segments of a word diff hunk, ending it with a line end
+func writeWordSegments(output *bufio.Writer, segments []DiffSegment) {
+	text := ""
+	for _, segment := range segments {
+		switch segment.Op {
+		case DiffInsert:
+			text += "{+" + segment.Text + "+}"
+		case DiffDelete:
+			text += "[-" + segment.Text + "-]"
+		default:
+			text += segment.Text
+		}
+	}
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	_, _ = output.WriteString(text)
+}
+
+// splitLines splits a text into lines, each keeping its line end so that a missing last one is a change
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// splitWords splits a text into runs of letters and digits, runs of spaces and single other characters
+func splitWords(text string) []string {
+	var words []string
+	for len(text) > 0 {
+		r, size := utf8.DecodeRuneInString(text)
+		class := wordClass(r)
+		if class != 0 {
+			for size < len(text) {
+				next, nextSize := utf8.DecodeRuneInString(text[size:])
+				if wordClass(next) != class {
+					break
+				}
+				size += nextSize
+			}
+		}
+		words = append(words, text[:size])
+		text = text[size:]
+	}
+	return words
+}
+
+// wordClass classes the characters of words as 1, of spaces as 2 and others as 0
+func wordClass(r rune) int {
+	switch {
+	case unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r):
+		return 1
+	case unicode.IsSpace(r):
+		return 2
+	}
+	return 0
+}
+
+// tokenEdit keeps, inserts or deletes a token, from and to are the indexes of the token in each sequence, the index
+// it would be at for the sequence it is missing from
+type tokenEdit struct {
+	op       string
+	from, to int
+}
+
+// diffTokens returns an edit script from one sequence of tokens to another, deletions before insertions. The script is
+// the shortest unless the sequences differ by too many edits, in which case a longer one is found in near linear time.
+func diffTokens(from, to []string) []tokenEdit {
+	ids := map[string]int{}
+	intern := func(tokens []string) []int {
+		interned := make([]int, len(tokens))
+		for i, token := range tokens {
+			id, found := ids[token]
+			if !found {
+				id = len(ids)
+				ids[token] = id
+			}
+			interned[i] = id
+		}
+		return interned
+	}
+	m := &myers{a: intern(from), b: intern(to), deleted: make([]bool, len(from)), inserted: make([]bool, len(to))}
+	m.compare(0, len(from), 0, len(to))
+	slideChanges(m.a, m.deleted)
+	slideChanges(m.b, m.inserted)
+
+	edits := make([]tokenEdit, 0, max(len(from), len(to)))
+	i, j := 0, 0
+	for i < len(from) || j < len(to) {
+		switch {
+		case i < len(from) && m.deleted[i]:
+			edits = append(edits, tokenEdit{op: DiffDelete, from: i, to: j})
+			i++
+		case j < len(to) && m.inserted[j]:
+			edits = append(edits, tokenEdit{op: DiffInsert, from: i, to: j})
+			j++
+		default:
+			edits = append(edits, tokenEdit{op: DiffEqual, from: i, to: j})
+			i++
+			j++
+		}
+	}
+	return edits
+}
+
+// slideChanges moves runs of changed tokens as late as the tokens after them allow, merging them with the runs they
+// reach, so that among edit scripts as short the one kept ends changes at line and word ends, as GNU diff does
+func slideChanges(tokens []int, changed []bool) {
+	for start := 0; start < len(changed); {
+		if !changed[start] {
+			start++
+			continue
+		}
+		end := start
+		for end < len(changed) && changed[end] {
+			end++
+		}
+		for end < len(changed) && tokens[start] == tokens[end] {
+			changed[start], changed[end] = false, true
+			start++
+			for end < len(changed) && changed[end] {
+				end++
+			}
+		}
+		start = end
+	}
+}
+
+// hunkSpans returns the ranges of edits making hunks, changes with up to context equal edits on each side
+func hunkSpans(edits []tokenEdit, context int) [][2]int {
+	var spans [][2]int
+	for i := 0; i < len(edits); i++ {
+		if edits[i].op == DiffEqual {
+			continue
+		}
+		start := max(i-context, 0)
+		end := i + 1
+		for unchanged := 0; end < len(edits) && unchanged <= 2*context; end++ {
+			if edits[end].op == DiffEqual {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+		}
+		// end is past the last change followed by more than twice the context, or the edits
+		for end > i && edits[end-1].op == DiffEqual {
+			end--
+		}
+		i = end - 1
+		end = min(end+context, len(edits))
+		if last := len(spans) - 1; last >= 0 && start <= spans[last][1] {
+			spans[last][1] = end
+			continue
+		}
+		spans = append(spans, [2]int{start, end})
+	}
+	return spans
+}
+
+// myers finds the tokens deleted from a and inserted in b by the linear space variant of the Myers diff algorithm,
+// splitting the sequences at the middle snake of an optimal edit path and comparing both sides in turn. As GNU diff
+// and git do, the search for a middle snake stops after a number of edits growing with the square root of the
+// sequences, splitting them at the furthest reaching path instead, so that the cost stays near N·sqrt(N) rather than
+// N·D for very different sequences.
+type myers struct {
+	a, b              []int
+	deleted, inserted []bool
+	forward, backward []int // furthest reaching x for each diagonal, reused across comparisons
+	paths             int   // furthest reaching paths computed, the cost of the comparison
+}
+
+// compare marks the edits from a[aLo:aHi] to b[bLo:bHi]
+func (m *myers) compare(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && m.a[aLo] == m.b[bLo] {
+		aLo++
+		bLo++
+	}
+	for aLo < aHi && bLo < bHi && m.a[aHi-1] == m.b[bHi-1] {
+		aHi--
+		bHi--
+	}
+	switch {
+	case aLo == aHi:
+		for j := bLo; j < bHi; j++ {
+			m.inserted[j] = true
+		}
+	case bLo == bHi:
+		for i := aLo; i < aHi; i++ {
+			m.deleted[i] = true
+		}
+	default:
+		// the snake holds at most one edit, compared as a sequence of its own, then the sequences around it
+		x, y, u, v, found := m.middleSnake(aLo, aHi, bLo, bHi)
+		if !found {
+			for i := aLo; i < aHi; i++ {
+				m.deleted[i] = true
+			}
+			for j := bLo; j < bHi; j++ {
+				m.inserted[j] = true
+			}
+			return
+		}
+		m.compare(x, u, y, v)
+		m.compare(aLo, x, bLo, y)
+		m.compare(u, aHi, v, bHi)
+	}
+}
+
+// middleSnake returns the middle snake of an optimal edit path from a[aLo:aHi] to b[bLo:bHi], from (x, y) to (u, v),
+// searching forward from the start and backward from the end until the paths overlap. Past the cost limit it returns
+// the empty snake at the end of the path reaching the furthest instead, not found when no path stays in the sequences.
+func (m *myers) middleSnake(aLo, aHi, bLo, bHi int) (x, y, u, v int, found bool) {
+	width, height := aHi-aLo, bHi-bLo
+	delta := width - height
+	limit := (width + height + 1) / 2
+	cost := minDiffCost
+	for size := width + height; cost*cost < size; {
+		cost *= 2
+	}
+	offset := limit + 1
+	if size := 2*limit + 3; len(m.forward) < size {
+		m.forward = make([]int, size)
+		m.backward = make([]int, size)
+	}
+	forward, backward := m.forward, m.backward // x of forward paths, x of backward paths counted from the end
+	forward[offset+1], backward[offset+1] = 0, 0
+
+	for d := 0; d <= limit; d++ {
+		m.paths += 2 * (d + 1)
+		for k := -d; k <= d; k += 2 {
+			start := forward[offset+k-1] + 1
+			if k == -d || k != d && forward[offset+k-1] < forward[offset+k+1] {
+				start = forward[offset+k+1]
+			}
+			end := start
+			for end < width && end-k < height && m.a[aLo+end] == m.b[bLo+end-k] {
+				end++
+			}
+			forward[offset+k] = end
+			if c := delta - k; delta%2 != 0 && c >= -(d-1) && c <= d-1 && end+backward[offset+c] >= width {
+				return aLo + start, bLo + start - k, aLo + end, bLo + end - k, true
+			}
+		}
+		for c := -d; c <= d; c += 2 {
+			start := backward[offset+c-1] + 1
+			if c == -d || c != d && backward[offset+c-1] < backward[offset+c+1] {
+				start = backward[offset+c+1]
+			}
+			end := start
+			for end < width && end-c < height && m.a[aHi-1-end] == m.b[bHi-1-end+c] {
+				end++
+			}
+			backward[offset+c] = end
+			if k := delta - c; delta%2 == 0 && k >= -d && k <= d && end+forward[offset+k] >= width {
+				return aHi - end, bHi - end + c, aHi - start, bHi - start + c, true
+			}
+		}
+		if d >= cost {
+			x, y = m.furthestReaching(d, offset, width, height)
+			return aLo + x, bLo + y, aLo + x, bLo + y, x+y > 0 && x+y < width+height
+		}
+	}
+	panic("no middle snake") // unreachable, the paths overlap by half of the longest edit path
+}
+
+// furthestReaching returns the end of the forward or backward path with d edits going the furthest from where it
+// started while staying in the sequences, relative to their start, (0, 0) when none does
+func (m *myers) furthestReaching(d, offset, width, height int) (x, y int) {
+	best := 0
+	for k := -d; k <= d; k += 2 {
+		end := m.forward[offset+k]
+		if end <= width && end-k >= 0 && end-k <= height && 2*end-k > best {
+			best, x, y = 2*end-k, end, end-k
+		}
+	}
+	for c := -d; c <= d; c += 2 {
+		end := m.backward[offset+c]
+		if end <= width && end-c >= 0 && end-c <= height && 2*end-c > best {
+			best, x, y = 2*end-c, width-end, height-end+c
+		}
+	}
+	return x, y
+}
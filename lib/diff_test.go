@@ -0,0 +1,197 @@
+package lib
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffTokens(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	randomTokens := func() []string {
+		tokens := make([]string, random.Intn(40))
+		for i := range tokens {
+			tokens[i] = string(rune('a' + random.Intn(4)))
+		}
+		return tokens
+	}
+	for i := 0; i < 2000; i++ {
+		from, to := randomTokens(), randomTokens()
+		changes := checkTokenEdits(t, from, to, diffTokens(from, to))
+
+		// the edits are as few as possible
+		if shortest := len(from) + len(to) - 2*longestCommonSubsequence(from, to); changes != shortest {
+			t.Fatal("expecting", shortest, "edits from", from, "to", to, "got", changes)
+		}
+	}
+}
+
+func TestDiffTokensRewrite(t *testing.T) {
+	// a rewrite keeping a few lines in place and common lines all over is too costly to diff exactly
+	random := rand.New(rand.NewSource(3))
+	randomLines := func() []string {
+		lines := make([]string, 60000)
+		for i := range lines {
+			lines[i] = strings.Repeat(string(rune('a'+random.Intn(26))), 1+random.Intn(10)) + "\n"
+		}
+		return lines
+	}
+	from, to := randomLines(), randomLines()
+	for i := 0; i < len(from); i += 1000 {
+		to[i] = from[i]
+	}
+
+	if changes := checkTokenEdits(t, from, to, diffTokens(from, to)); changes == 0 || changes > len(from)+len(to) {
+		t.Error("expecting changes got", changes)
+	}
+
+	// an exact diff would compute about D² = 10^10 paths
+	m := &myers{a: internTestTokens(from), b: internTestTokens(to), deleted: make([]bool, len(from)), inserted: make([]bool, len(to))}
+	m.compare(0, len(from), 0, len(to))
+	if m.paths > 1e9 {
+		t.Error("expecting the cost of the rewrite bounded got", m.paths, "paths")
+	}
+}
+
+// internTestTokens returns tokens as ids, equal tokens sharing an id
+func internTestTokens(tokens []string) []int {
+	ids := make([]int, len(tokens))
+	for i, token := range tokens {
+		ids[i] = len(token)<<8 | int(token[0]) // tokens repeat a single letter
+	}
+	return ids
+}
+
+// checkTokenEdits checks that edits keep equal tokens and rebuild both sequences, returning the number of changes
+func checkTokenEdits(t *testing.T, from, to []string, edits []tokenEdit) int {
+	t.Helper()
+	var rebuiltFrom, rebuiltTo []string
+	changes := 0
+	for _, edit := range edits {
+		if edit.op != DiffInsert {
+			rebuiltFrom = append(rebuiltFrom, from[edit.from])
+		}
+		if edit.op != DiffDelete {
+			rebuiltTo = append(rebuiltTo, to[edit.to])
+		}
+		if edit.op == DiffEqual && from[edit.from] != to[edit.to] {
+			t.Fatal("expecting equal tokens kept got", from[edit.from], to[edit.to])
+		}
+		if edit.op != DiffEqual {
+			changes++
+		}
+	}
+	if strings.Join(rebuiltFrom, "") != strings.Join(from, "") || strings.Join(rebuiltTo, "") != strings.Join(to, "") {
+		t.Fatal("expecting the edits to rebuild", len(from), len(to), "tokens got", len(rebuiltFrom), len(rebuiltTo))
+	}
+	return changes
+}
+
+// longestCommonSubsequence returns the length of the longest common subsequence of two sequences, by dynamic programming
+func longestCommonSubsequence(a, b []string) int {
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else {
+				lengths[i][j] = max(lengths[i+1][j], lengths[i][j+1])
+			}
+		}
+	}
+	return lengths[0][0]
+}
+
+func TestDiffTexts(t *testing.T) {
+	from := "# The Hobbit\n\nIn a hole in the ground\nthere lived a hobbit.\n\n1\n2\n3\n4\n5\n6\n7\n8\nThe end"
+	to := "# The Hobbit\n\nIn a hole in the earth\nthere lived a hobbit.\n\n1\n2\n3\n4\n5\n6\n7\n8\nThe end\n"
+
+	diff, err := DiffTexts(from, to, DiffGranularityLine, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff.Insertions != 2 || diff.Deletions != 2 || len(diff.Hunks) != 2 {
+		t.Fatal("expecting two changed lines in two hunks got", diff)
+	}
+	expected := DiffHunk{FromLine: 1, FromCount: 5, ToLine: 1, ToCount: 5, Segments: []DiffSegment{
+		{DiffEqual, "# The Hobbit\n"}, {DiffEqual, "\n"}, {DiffDelete, "In a hole in the ground\n"}, {DiffInsert, "In a hole in the earth\n"},
+		{DiffEqual, "there lived a hobbit.\n"}, {DiffEqual, "\n"},
+	}}
+	if !reflect.DeepEqual(diff.Hunks[0], expected) {
+		t.Errorf("expecting %+v got %+v", expected, diff.Hunks[0])
+	}
+
+	var unified strings.Builder
+	err = diff.WriteUnified(&unified, "a/1", "b/2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedUnified := "--- a/1\n+++ b/2\n" +
+		"@@ -1,5 +1,5 @@\n # The Hobbit\n \n-In a hole in the ground\n+In a hole in the earth\n there lived a hobbit.\n \n" +
+		"@@ -12,3 +12,3 @@\n 7\n 8\n-The end\n\\ No newline at end of file\n+The end\n"
+	if unified.String() != expectedUnified {
+		t.Errorf("expecting\n%s\ngot\n%s", expectedUnified, unified.String())
+	}
+
+	// word diffs show the words changed within the lines
+	diff, err = DiffTexts(from, to, DiffGranularityWord, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff.Insertions != 1 || diff.Deletions != 1 || len(diff.Hunks) != 2 {
+		t.Fatal("expecting a changed word in two hunks got", diff)
+	}
+	expected = DiffHunk{FromLine: 3, FromCount: 1, ToLine: 3, ToCount: 1, Segments: []DiffSegment{
+		{DiffEqual, "In a hole in the "}, {DiffDelete, "ground"}, {DiffInsert, "earth"}, {DiffEqual, "\n"},
+	}}
+	if !reflect.DeepEqual(diff.Hunks[0], expected) {
+		t.Errorf("expecting %+v got %+v", expected, diff.Hunks[0])
+	}
+	unified.Reset()
+	_ = diff.WriteUnified(&unified, "a", "b")
+	if !strings.Contains(unified.String(), "@@ -3 +3 @@\nIn a hole in the [-ground-]{+earth+}\n") {
+		t.Error("expecting the word changes marked got", unified.String())
+	}
+
+	// texts added from nothing
+	diff, err = DiffTexts("", "one\ntwo\n", DiffGranularityLine, DefaultDiffContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Hunks) != 1 || diff.Hunks[0].FromLine != 0 || diff.Hunks[0].FromCount != 0 || diff.Hunks[0].ToLine != 1 || diff.Insertions != 2 {
+		t.Error("expecting every line inserted got", diff)
+	}
+	if same, _ := DiffTexts(from, from, DiffGranularityWord, DefaultDiffContext); len(same.Hunks) != 0 {
+		t.Error("expecting no hunks got", same.Hunks)
+	}
+	if _, err = DiffTexts(from, to, "char", DefaultDiffContext); err != IncorrectDiffGranularity {
+		t.Error("expecting", IncorrectDiffGranularity, "got", err)
+	}
+}
+
+func TestDiffLargeTexts(t *testing.T) {
+	random := rand.New(rand.NewSource(2))
+	var from, to strings.Builder
+	for i := 0; i < 50000; i++ {
+		line := strings.Repeat(string(rune('a'+random.Intn(26))), 1+random.Intn(60)) + "\n"
+		from.WriteString(line)
+		switch random.Intn(100) {
+		case 0:
+			to.WriteString("changed " + line)
+		case 1:
+		default:
+			to.WriteString(line)
+		}
+	}
+	diff, err := DiffTexts(from.String(), to.String(), DiffGranularityWord, DefaultDiffContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff.Deletions == 0 || diff.Insertions == 0 {
+		t.Error("expecting changes got", diff.Insertions, diff.Deletions)
+	}
+}
@@ -0,0 +1,426 @@
+package lib
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/language"
+	"html"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+)
+
+const (
+	MediaTypeEPUB = "application/epub+zip"
+
+	epubContainerPath = "META-INF/container.xml"
+	epubPackageDir    = "OEBPS"
+	maxEPUBEntrySize  = 64 << 20  // bytes read from a single file of an EPUB, guarding against zip bombs
+	maxEPUBReadSize   = 128 << 20 // bytes read from all the files of an EPUB together
+)
+
+// epubRelators maps contributor roles to their MARC relator codes, used for roles by EPUB metadata
+var epubRelators = map[string]string{
+	RoleAuthor:    "aut",
+	"editor":      "edt",
+	"translator":  "trl",
+	"illustrator": "ill",
+	"narrator":    "nrt",
+	"contributor": "ctb",
+}
+
+var ( // Errors
+	IncorrectEPUB = errors.New("not a valid EPUB, expecting a zip holding a container, a package document and XHTML chapters")
+	EPUBTooLarge  = errors.New("epub is too large")
+)
+
+// epubContainer is META-INF/container.xml, pointing to the package document
+type epubContainer struct {
+	Rootfiles []struct {
+		FullPath  string `xml:"full-path,attr"`
+		MediaType string `xml:"media-type,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+// epubPackage is the package document of an EPUB 2 or 3, its metadata, files and reading order
+type epubPackage struct {
+	Metadata struct {
+		Titles       []epubMeta   `xml:"title"`
+		Creators     []epubMeta   `xml:"creator"`
+		Contributors []epubMeta   `xml:"contributor"`
+		Languages    []string     `xml:"language"`
+		Identifiers  []epubMeta   `xml:"identifier"`
+		Publishers   []string     `xml:"publisher"`
+		Dates        []string     `xml:"date"`
+		Subjects     []string     `xml:"subject"`
+		Refinements  []epubRefine `xml:"meta"`
+	} `xml:"metadata"`
+	Manifest []struct {
+		ID         string `xml:"id,attr"`
+		Href       string `xml:"href,attr"`
+		MediaType  string `xml:"media-type,attr"`
+		Properties string `xml:"properties,attr"`
+	} `xml:"manifest>item"`
+	Spine []struct {
+		IDRef string `xml:"idref,attr"`
+	} `xml:"spine>itemref"`
+}
+
+// epubMeta is a Dublin Core element, with the EPUB 2 role and scheme attributes
+type epubMeta struct {
+	ID     string `xml:"id,attr"`
+	Role   string `xml:"role,attr"`
+	Scheme string `xml:"scheme,attr"`
+	Value  string `xml:",chardata"`
+}
+
+// epubRefine is an EPUB 3 meta element, refining another element, eg with the role of a creator
+type epubRefine struct {
+	Refines  string `xml:"refines,attr"`
+	Property string `xml:"property,attr"`
+	Value    string `xml:",chardata"`
+}
+
+// ImportEPUB reads a book from an EPUB 2 or 3: its title, creators, language, publisher, date, ISBN and subjects from the
+// package metadata, and its contents from the XHTML documents of the reading order, converted to Markdown
+func ImportEPUB(reader io.ReaderAt, size int64) (*Book, error) {
+	zipReader, err := zip.NewReader(reader, size)
+	if err != nil {
+		return nil, IncorrectEPUB
+	}
+	archive := &epubArchive{reader: zipReader, left: maxEPUBReadSize}
+	container := epubContainer{}
+	err = archive.decode(epubContainerPath, &container)
+	if err != nil || len(container.Rootfiles) == 0 {
+		return nil, IncorrectEPUB
+	}
+	packagePath := container.Rootfiles[0].FullPath
+	pkg := epubPackage{}
+	err = archive.decode(packagePath, &pkg)
+	if err != nil || len(pkg.Spine) == 0 {
+		return nil, IncorrectEPUB
+	}
+
+	book := pkg.book()
+	hrefs := map[string]string{}
+	for _, item := range pkg.Manifest {
+		isDocument := item.MediaType == "application/xhtml+xml" || item.MediaType == "text/html"
+		if isDocument && !strings.Contains(" "+item.Properties+" ", " nav ") {
+			hrefs[item.ID] = item.Href
+		}
+	}
+	var chapters []string
+	read := map[string]bool{}
+	for _, itemRef := range pkg.Spine {
+		href, found := hrefs[itemRef.IDRef]
+		if !found {
+			continue
+		}
+		if unescaped, err := url.PathUnescape(href); err == nil {
+			href = unescaped
+		}
+		name := path.Join(path.Dir(packagePath), href)
+		if read[name] { // a document read over and over would get around the limit on the files of the EPUB
+			return nil, IncorrectEPUB
+		}
+		read[name] = true
+		entry, err := archive.open(name)
+		if err != nil {
+			return nil, err
+		}
+		chapter, err := XHTMLToMarkdown(entry)
+		_ = entry.Close()
+		if err != nil {
+			return nil, err
+		}
+		if chapter != "" {
+			chapters = append(chapters, chapter)
+		}
+	}
+	book.Contents = strings.Join(chapters, "\n\n")
+	return book, nil
+}
+
+// book returns the book described by the package metadata, leaving out values that are not valid book metadata
+func (p *epubPackage) book() *Book {
+	metadata := p.Metadata
+	book := &Book{}
+	if len(metadata.Titles) > 0 {
+		book.Name = collapseSpaces(metadata.Titles[0].Value)
+	}
+	roles := map[string]string{}
+	for _, refine := range metadata.Refinements {
+		if refine.Property == "role" {
+			roles[strings.TrimPrefix(refine.Refines, "#")] = strings.TrimSpace(refine.Value)
+		}
+	}
+	for i, creator := range append(metadata.Creators, metadata.Contributors...) {
+		name := collapseSpaces(creator.Value)
+		relator := creator.Role
+		if refined, found := roles[creator.ID]; found && creator.ID != "" {
+			relator = refined
+		}
+		role := "contributor"
+		for knownRole, code := range epubRelators {
+			if strings.EqualFold(relator, code) || relator == "" && i < len(metadata.Creators) && knownRole == RoleAuthor {
+				role = knownRole
+			}
+		}
+		switch {
+		case name == "":
+		case book.Author == "" && role == RoleAuthor:
+			book.Author = name
+		default:
+			book.Contributors = append(book.Contributors, Contributor{Name: name, Role: role})
+		}
+	}
+	if len(metadata.Languages) > 0 {
+		if tag, err := language.Parse(strings.TrimSpace(metadata.Languages[0])); err == nil {
+			book.Language = tag.String()
+		}
+	}
+	for _, identifier := range metadata.Identifiers {
+		value := strings.TrimSpace(identifier.Value)
+		lower := strings.ToLower(value)
+		if !strings.HasPrefix(lower, "urn:isbn:") && !strings.EqualFold(identifier.Scheme, "isbn") {
+			continue
+		}
+		if isbn10, isbn13, err := NormaliseISBN(value[strings.LastIndexByte(value, ':')+1:]); err == nil {
+			book.ISBN10, book.ISBN13 = isbn10, isbn13
+			break
+		}
+	}
+	if len(metadata.Publishers) > 0 {
+		book.Publisher = collapseSpaces(metadata.Publishers[0])
+	}
+	if len(metadata.Dates) > 0 {
+		date := strings.TrimSpace(metadata.Dates[0])
+		for _, length := range []int{10, 7, 4} {
+			if len(date) >= length && isPublicationDate(date[:length]) {
+				book.PublicationDate = date[:length]
+				break
+			}
+		}
+	}
+	for _, subject := range metadata.Subjects {
+		if subject = collapseSpaces(subject); subject != "" {
+			book.Tags = append(book.Tags, subject)
+		}
+	}
+	book.Tags = NormaliseTags(book.Tags)
+	return book
+}
+
+// epubArchive reads the files of an EPUB, guarding against zip bombs: a file fails to read past maxEPUBEntrySize bytes,
+// and the files together past maxEPUBReadSize bytes
+type epubArchive struct {
+	reader *zip.Reader
+	left   int64 // bytes left to read from all the files
+}
+
+// epubEntry is a file of an EPUB being read
+type epubEntry struct {
+	io.ReadCloser
+	archive *epubArchive
+	left    int64 // bytes left to read from the file
+}
+
+// open opens a file of an EPUB
+func (a *epubArchive) open(name string) (io.ReadCloser, error) {
+	file, err := a.reader.Open(name)
+	if err != nil {
+		return nil, IncorrectEPUB
+	}
+	return &epubEntry{ReadCloser: file, archive: a, left: maxEPUBEntrySize}, nil
+}
+
+// Read reads the file, failing with IncorrectEPUB rather than truncating it once past the bytes left to read
+func (e *epubEntry) Read(p []byte) (int, error) {
+	left := min(e.left, e.archive.left)
+	if int64(len(p)) > left+1 {
+		p = p[:left+1] // one more byte tells a file at its limit from a file past it
+	}
+	n, err := e.ReadCloser.Read(p)
+	if int64(n) > left {
+		return 0, IncorrectEPUB
+	}
+	e.left -= int64(n)
+	e.archive.left -= int64(n)
+	return n, err
+}
+
+// decode decodes an XML file of an EPUB
+func (a *epubArchive) decode(name string, value any) error {
+	entry, err := a.open(name)
+	if err != nil {
+		return err
+	}
+	defer entry.Close()
+	decoder := xml.NewDecoder(entry)
+	decoder.CharsetReader = charsetReader
+	return decoder.Decode(value)
+}
+
+// charsetReader decodes XML declared in another encoding than UTF-8
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	encoding, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, IncorrectEPUB
+	}
+	return encoding.NewDecoder().Reader(input), nil
+}
+
+// ExportEPUB writes a book as an EPUB 3, its contents split into an XHTML document for each top level chapter
+func ExportEPUB(book *Book, contents string, writer io.Writer) error {
+	structure, err := ParseStructure(contents, book.ChapterPattern, len(contents)+1)
+	if err != nil {
+		return err
+	}
+	type epubChapter struct {
+		title string
+		text  string
+	}
+	var chapters []epubChapter
+	start, title := int64(0), book.Name
+	for _, chapter := range append(structure.Chapters, Chapter{Level: 1, Start: structure.Length}) {
+		if chapter.Level != 1 {
+			continue
+		}
+		if text := contents[start:chapter.Start]; strings.TrimSpace(text) != "" {
+			chapters = append(chapters, epubChapter{title: title, text: text})
+		}
+		start, title = chapter.Start, chapter.Title
+	}
+	if len(chapters) == 0 {
+		chapters = append(chapters, epubChapter{title: book.Name})
+	}
+
+	lang := book.Language
+	if lang == "" {
+		lang = "und"
+	}
+	archive := zip.NewWriter(writer)
+	// the mimetype comes first and uncompressed, so that the file can be recognised from its first bytes
+	err = writeEPUBEntry(archive, &zip.FileHeader{Name: "mimetype", Method: zip.Store}, MediaTypeEPUB)
+	if err != nil {
+		return err
+	}
+	err = writeEPUBEntry(archive, &zip.FileHeader{Name: epubContainerPath, Method: zip.Deflate}, xml.Header+
+		`<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">`+"\n"+
+		`  <rootfiles>`+"\n"+
+		`    <rootfile full-path="`+epubPackageDir+`/content.opf" media-type="application/oebps-package+xml"/>`+"\n"+
+		`  </rootfiles>`+"\n"+
+		`</container>`+"\n")
+	if err != nil {
+		return err
+	}
+
+	var manifest, spine, nav strings.Builder
+	for i, chapter := range chapters {
+		href := fmt.Sprintf("chapter-%03d.xhtml", i+1)
+		fmt.Fprintf(&manifest, "    <item id=\"chapter-%03d\" href=\"%s\" media-type=\"application/xhtml+xml\"/>\n", i+1, href)
+		fmt.Fprintf(&spine, "    <itemref idref=\"chapter-%03d\"/>\n", i+1)
+		fmt.Fprintf(&nav, "      <li><a href=\"%s\">%s</a></li>\n", href, html.EscapeString(chapter.title))
+		err = writeEPUBEntry(archive, &zip.FileHeader{Name: epubPackageDir + "/" + href, Method: zip.Deflate},
+			xhtmlDocument(lang, chapter.title, RenderXHTML(chapter.text)))
+		if err != nil {
+			return err
+		}
+	}
+	err = writeEPUBEntry(archive, &zip.FileHeader{Name: epubPackageDir + "/nav.xhtml", Method: zip.Deflate},
+		xhtmlDocument(lang, book.Name, "\n<nav epub:type=\"toc\" id=\"toc\">\n  <h1>"+html.EscapeString(book.Name)+"</h1>\n  <ol>\n"+
+			nav.String()+"  </ol>\n</nav>\n"))
+	if err != nil {
+		return err
+	}
+	err = writeEPUBEntry(archive, &zip.FileHeader{Name: epubPackageDir + "/content.opf", Method: zip.Deflate}, xml.Header+
+		`<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id">`+"\n"+
+		`  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">`+"\n"+
+		epubMetadata(book, lang)+
+		`  </metadata>`+"\n"+
+		`  <manifest>`+"\n"+
+		`    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>`+"\n"+
+		manifest.String()+
+		`  </manifest>`+"\n"+
+		`  <spine>`+"\n"+
+		spine.String()+
+		`  </spine>`+"\n"+
+		`</package>`+"\n")
+	if err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// epubMetadata returns the metadata elements of the package document of a book
+func epubMetadata(book *Book, lang string) string {
+	var metadata strings.Builder
+	element := func(name, id, value string) {
+		if id != "" {
+			id = ` id="` + id + `"`
+		}
+		metadata.WriteString("    <" + name + id + ">" + html.EscapeString(value) + "</" + name + ">\n")
+	}
+	identifier := "urn:library:book:" + book.ID
+	if book.ISBN13 != "" {
+		identifier = "urn:isbn:" + book.ISBN13
+	}
+	element("dc:identifier", "book-id", identifier)
+	element("dc:title", "", book.Name)
+	contributors := append([]Contributor{{Name: book.Author, Role: RoleAuthor}}, book.Contributors...)
+	for i, contributor := range contributors {
+		id := fmt.Sprintf("creator-%d", i+1)
+		if contributor.Role == RoleAuthor {
+			element("dc:creator", id, contributor.Name)
+		} else {
+			element("dc:contributor", id, contributor.Name)
+		}
+		if code, found := epubRelators[contributor.Role]; found {
+			metadata.WriteString(`    <meta refines="#` + id + `" property="role" scheme="marc:relators">` + code + "</meta>\n")
+		}
+	}
+	element("dc:language", "", lang)
+	if book.Publisher != "" {
+		element("dc:publisher", "", book.Publisher)
+	}
+	if book.PublicationDate != "" {
+		element("dc:date", "", book.PublicationDate)
+	}
+	for _, tag := range book.Tags {
+		element("dc:subject", "", tag)
+	}
+	modified := book.UpdatedAt
+	if modified.IsZero() {
+		modified = book.CreatedAt
+	}
+	metadata.WriteString(`    <meta property="dcterms:modified">` + modified.UTC().Format("2006-01-02T15:04:05Z") + "</meta>\n")
+	return metadata.String()
+}
+
+// xhtmlDocument returns an XHTML document of an EPUB with the given title and body
+func xhtmlDocument(lang, title, body string) string {
+	return xml.Header + "<!DOCTYPE html>\n" +
+		`<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="` + html.EscapeString(lang) +
+		`" lang="` + html.EscapeString(lang) + `">` + "\n" +
+		"<head>\n  <title>" + html.EscapeString(title) + "</title>\n</head>\n" +
+		"<body>" + body + "</body>\n</html>\n"
+}
+
+// writeEPUBEntry writes a file of an EPUB
+func writeEPUBEntry(archive *zip.Writer, header *zip.FileHeader, contents string) error {
+	entry, err := archive.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(entry, contents)
+	return err
+}
+
+// collapseSpaces trims a text and replaces its runs of white space with single spaces
+func collapseSpaces(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
@@ -0,0 +1,235 @@
+package lib
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImportEPUB(t *testing.T) {
+	book := importTestEPUB(t, "testdata/hobbit.epub")
+	expected := Book{
+		Name:            "The Hobbit",
+		Author:          "J. R. R. Tolkien",
+		Contributors:    []Contributor{{Name: "Alan Lee", Role: "illustrator"}},
+		Language:        "en-GB",
+		ISBN10:          "0261102214",
+		ISBN13:          "9780261102217",
+		Publisher:       "George Allen & Unwin",
+		PublicationDate: "1937-09-21",
+		Tags:            []string{"dragons", "fantasy"},
+		Contents:        readTestFile(t, "testdata/hobbit.md"),
+	}
+	if !reflect.DeepEqual(*book, expected) {
+		t.Errorf("expecting %+v got %+v", expected, *book)
+	}
+
+	// the chapters are the headings of the documents of the reading order
+	structure, err := ParseStructure(book.Contents, "", 2000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(structure.Chapters) != 3 || structure.Chapters[0].Title != "Chapter I An Unexpected Party" || structure.Chapters[1].Title != "Roast Mutton" {
+		t.Error("expecting the chapters of the documents got", structure.Chapters)
+	}
+}
+
+func TestExportEPUB(t *testing.T) {
+	book := &Book{
+		ID:              "hobbit",
+		Name:            "The Hobbit",
+		Author:          "J. R. R. Tolkien",
+		Contributors:    []Contributor{{Name: "Alan Lee", Role: "illustrator"}, {Name: "Christopher Tolkien", Role: "editor"}},
+		Language:        "en-GB",
+		ISBN10:          "0261102214",
+		ISBN13:          "9780261102217",
+		Publisher:       "George Allen & Unwin",
+		PublicationDate: "1937-09-21",
+		Tags:            []string{"dragons", "fantasy"},
+		UpdatedAt:       time.Date(2024, time.August, 1, 12, 0, 0, 0, time.UTC),
+	}
+	contents := readTestFile(t, "testdata/hobbit.md")
+	var exported bytes.Buffer
+	err := ExportEPUB(book, contents, &exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the mimetype comes first and uncompressed, every document is well formed XML
+	archive, err := zip.NewReader(bytes.NewReader(exported.Bytes()), int64(exported.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first := archive.File[0]; first.Name != "mimetype" || first.Method != zip.Store || !bytes.Contains(exported.Bytes()[:60], []byte(MediaTypeEPUB)) {
+		t.Error("expecting the mimetype first and stored got", first.Name, first.Method)
+	}
+	var names []string
+	for _, file := range archive.File {
+		names = append(names, file.Name)
+		if !strings.HasSuffix(file.Name, ".xml") && !strings.HasSuffix(file.Name, ".opf") && !strings.HasSuffix(file.Name, ".xhtml") {
+			continue
+		}
+		entry, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoder := xml.NewDecoder(entry)
+		for err == nil {
+			_, err = decoder.Token()
+		}
+		if !errors.Is(err, io.EOF) {
+			t.Error("expecting", file.Name, "well formed got", err)
+		}
+	}
+	expectedNames := []string{"mimetype", "META-INF/container.xml", "OEBPS/chapter-001.xhtml", "OEBPS/chapter-002.xhtml", "OEBPS/nav.xhtml", "OEBPS/content.opf"}
+	if !reflect.DeepEqual(names, expectedNames) {
+		t.Error("expecting a document for each chapter got", names)
+	}
+
+	// importing the export gives back the book
+	imported, err := ImportEPUB(bytes.NewReader(exported.Bytes()), int64(exported.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	book.ID, book.UpdatedAt, book.Contents = "", time.Time{}, contents
+	if !reflect.DeepEqual(imported, book) {
+		t.Errorf("expecting %+v got %+v", *book, *imported)
+	}
+}
+
+func TestEPUBRoundTrip(t *testing.T) {
+	book := importTestEPUB(t, "testdata/hobbit.epub")
+	var exported bytes.Buffer
+	err := ExportEPUB(book, book.Contents, &exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, err := ImportEPUB(bytes.NewReader(exported.Bytes()), int64(exported.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(imported, book) {
+		t.Errorf("expecting %+v got %+v", *book, *imported)
+	}
+
+	// contents without chapters are a single document titled by the book
+	exported.Reset()
+	err = ExportEPUB(&Book{Name: "Notes", Author: "Bilbo"}, "There and back again.", &exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, err = ImportEPUB(bytes.NewReader(exported.Bytes()), int64(exported.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported.Contents != "There and back again." || imported.Language != "und" {
+		t.Error("expecting the contents in a single document got", imported)
+	}
+}
+
+func TestImportIncorrectEPUB(t *testing.T) {
+	if _, err := ImportEPUB(strings.NewReader("not a zip"), 9); err != IncorrectEPUB {
+		t.Error("expecting a file that is not a zip refused got", err)
+	}
+	var archive bytes.Buffer
+	writer := zip.NewWriter(&archive)
+	_, _ = writer.Create("chapter.xhtml")
+	_ = writer.Close()
+	if _, err := ImportEPUB(bytes.NewReader(archive.Bytes()), int64(archive.Len())); err != IncorrectEPUB {
+		t.Error("expecting a zip without a container refused got", err)
+	}
+}
+
+func TestImportEPUBLimits(t *testing.T) {
+	container := `<container><rootfiles><rootfile full-path="content.opf"/></rootfiles></container>`
+	opf := `<package><manifest><item id="c1" href="c1.xhtml" media-type="application/xhtml+xml"/>` +
+		`<item id="again" href="c1.xhtml" media-type="application/xhtml+xml"/></manifest><spine>%s</spine></package>`
+	chapter := "<html><body><p>" + strings.Repeat("a", 1000) + "</p></body></html>"
+
+	archive := zipTestFiles(t, map[string]string{epubContainerPath: container, "content.opf": fmt.Sprintf(opf, `<itemref idref="c1"/>`), "c1.xhtml": chapter})
+	if book, err := ImportEPUB(bytes.NewReader(archive), int64(len(archive))); err != nil || len(book.Contents) != 1000 {
+		t.Fatal("expecting the document imported got", err)
+	}
+	// a small document listed over and over by the reading order would be read again and again
+	for _, spine := range []string{`<itemref idref="c1"/><itemref idref="c1"/>`, `<itemref idref="c1"/><itemref idref="again"/>`} {
+		archive := zipTestFiles(t, map[string]string{epubContainerPath: container, "content.opf": fmt.Sprintf(opf, spine), "c1.xhtml": chapter})
+		if _, err := ImportEPUB(bytes.NewReader(archive), int64(len(archive))); err != IncorrectEPUB {
+			t.Error("expecting a repeated document refused got", err)
+		}
+	}
+
+	// the files of an EPUB are read up to a total size, failing past it rather than truncating
+	archive = zipTestFiles(t, map[string]string{"c1.xhtml": chapter, "c2.xhtml": chapter})
+	reader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	epub := &epubArchive{reader: reader, left: int64(len(chapter)*2 - 1)}
+	for i, name := range []string{"c1.xhtml", "c2.xhtml"} {
+		entry, err := epub.open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		read, err := io.ReadAll(entry)
+		if i == 0 && (err != nil || string(read) != chapter) {
+			t.Error("expecting the first file read whole got", len(read), err)
+		}
+		if i == 1 && err != IncorrectEPUB {
+			t.Error("expecting the files past the total size refused got", len(read), err)
+		}
+	}
+}
+
+// zipTestFiles zips files by name
+func zipTestFiles(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var archive bytes.Buffer
+	writer := zip.NewWriter(&archive)
+	for name, contents := range files {
+		file, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = file.Write([]byte(contents))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return archive.Bytes()
+}
+
+// importTestEPUB imports an EPUB fixture
+func importTestEPUB(t *testing.T, name string) *Book {
+	t.Helper()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	book, err := ImportEPUB(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return book
+}
+
+// readTestFile reads a text fixture
+func readTestFile(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,65 @@
+package lib
+
+import (
+	"sort"
+	"strconv"
+)
+
+// Facets count the books matching a filter per value of the fields the catalogue is browsed by, for building filters.
+// Each facet lists its values with the most books first, values with as many books in byte order, up to the facet limit.
+type Facets struct {
+	Books        int64        `bson:"books" json:"books"`               // books matching the filter
+	Authors      []FacetCount `bson:"authors" json:"authors"`           // by primary author
+	Tags         []FacetCount `bson:"tags" json:"tags"`                 // books counted once per tag
+	Languages    []FacetCount `bson:"languages" json:"languages"`       // books without a language left out
+	UpdatedYears []FacetCount `bson:"updatedYears" json:"updatedYears"` // by UTC year of updatedAt, books never updated left out
+}
+
+// FacetCount is a value of a facet with the number of books having it
+type FacetCount struct {
+	Value string `bson:"_id" json:"value"`
+	Count int64  `bson:"count" json:"count"`
+}
+
+// CountFacets counts the facets of books in memory, keeping up to limit values of each facet.
+// Books need their author, tags, language and updatedAt.
+func CountFacets(books []Book, limit int) *Facets {
+	authors, tags, languages, years := map[string]int64{}, map[string]int64{}, map[string]int64{}, map[string]int64{}
+	for _, book := range books {
+		authors[book.Author]++
+		for _, tag := range book.Tags {
+			tags[tag]++
+		}
+		if book.Language != "" {
+			languages[book.Language]++
+		}
+		if !book.UpdatedAt.IsZero() {
+			years[strconv.Itoa(book.UpdatedAt.UTC().Year())]++
+		}
+	}
+	return &Facets{
+		Books:        int64(len(books)),
+		Authors:      topFacetCounts(authors, limit),
+		Tags:         topFacetCounts(tags, limit),
+		Languages:    topFacetCounts(languages, limit),
+		UpdatedYears: topFacetCounts(years, limit),
+	}
+}
+
+// topFacetCounts returns up to limit of the counted values, most books first
+func topFacetCounts(counts map[string]int64, limit int) []FacetCount {
+	facet := make([]FacetCount, 0, len(counts))
+	for value, count := range counts {
+		facet = append(facet, FacetCount{Value: value, Count: count})
+	}
+	sort.Slice(facet, func(i, j int) bool {
+		if facet[i].Count != facet[j].Count {
+			return facet[i].Count > facet[j].Count
+		}
+		return facet[i].Value < facet[j].Value
+	})
+	if len(facet) > limit {
+		facet = facet[:limit]
+	}
+	return facet
+}
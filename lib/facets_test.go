@@ -0,0 +1,34 @@
+package lib
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCountFacets(t *testing.T) {
+	updated := time.Date(2023, time.December, 31, 23, 0, 0, 0, time.FixedZone("UTC-2", -2*60*60))
+	books := []Book{
+		{Author: "Tolkien", Tags: []string{"classic", "fantasy"}, Language: "en", UpdatedAt: updated},
+		{Author: "Tolkien", Tags: []string{"fantasy"}, Language: "en", UpdatedAt: updated.AddDate(-1, 0, 0)},
+		{Author: "Baker"}, // never updated and without language
+	}
+
+	facets := CountFacets(books, 10)
+	expected := &Facets{
+		Books:        3,
+		Authors:      []FacetCount{{Value: "Tolkien", Count: 2}, {Value: "Baker", Count: 1}},
+		Tags:         []FacetCount{{Value: "fantasy", Count: 2}, {Value: "classic", Count: 1}},
+		Languages:    []FacetCount{{Value: "en", Count: 2}},
+		UpdatedYears: []FacetCount{{Value: "2023", Count: 1}, {Value: "2024", Count: 1}}, // in UTC
+	}
+	if !reflect.DeepEqual(facets, expected) {
+		t.Error("expecting", expected, "got", facets)
+	}
+	if facets = CountFacets(books, 1); len(facets.Authors) != 1 || len(facets.Tags) != 1 || facets.Books != 3 {
+		t.Error("expecting one value per facet got", facets)
+	}
+	if facets = CountFacets(nil, 10); facets.Books != 0 || facets.Authors == nil || len(facets.UpdatedYears) != 0 {
+		t.Error("expecting empty facets got", facets)
+	}
+}
@@ -0,0 +1,139 @@
+package lib
+
+import (
+	"golang.org/x/text/cases"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	DefaultSuggestionLimit = 5
+	MinSuggestionScore     = 0.4 // books scoring less are not worth suggesting
+)
+
+// BookSuggestion is a book a lookup may have meant, scored by the similarity of its name and author, from 0 to 1
+type BookSuggestion struct {
+	BookIdentifier
+	Score float64 `json:"score"`
+}
+
+// FoldName returns the key names and authors of books are matched by, ignoring case and accents:
+// the name is decomposed, stripped of its combining marks, recomposed and case folded, so "Brontë", "BRONTE" and "bronte" match.
+// Mongo compares them alike with a strength 1 collation.
+func FoldName(name string) string {
+	folded, _, err := transform.String(transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC, cases.Fold()), name)
+	if err != nil {
+		return strings.ToLower(name)
+	}
+	return folded
+}
+
+// SameName checks whether two names or authors of books match, ignoring case and accents
+func SameName(a, b string) bool {
+	return FoldName(a) == FoldName(b)
+}
+
+// SuggestBooks returns up to limit of the books most similar to the wanted name and author, most similar first.
+// The name weighs twice the author, either being left out of the score when not wanted.
+func SuggestBooks(wanted BookIdentifier, books []BookIdentifier, limit int) []BookSuggestion {
+	name, author := FoldName(wanted.Name), FoldName(wanted.Author)
+	suggestions := []BookSuggestion{}
+	for _, book := range books {
+		var score, weights float64
+		if name != "" {
+			score += 2 * nameSimilarity(name, FoldName(book.Name))
+			weights += 2
+		}
+		if author != "" {
+			score += nameSimilarity(author, FoldName(book.Author))
+			weights++
+		}
+		if weights == 0 || score/weights < MinSuggestionScore {
+			continue
+		}
+		book.DeletedAt = nil
+		suggestions = append(suggestions, BookSuggestion{BookIdentifier: book, Score: score / weights})
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Score != suggestions[j].Score {
+			return suggestions[i].Score > suggestions[j].Score
+		}
+		if suggestions[i].Name != suggestions[j].Name {
+			return suggestions[i].Name < suggestions[j].Name
+		}
+		return suggestions[i].Author < suggestions[j].Author
+	})
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions
+}
+
+// nameSimilarity scores how alike a wanted folded name is to a folded name from 0 to 1, the best of their trigram
+// similarity, which forgives reordered and missing words, and of their edit distance, which forgives typos in short names
+func nameSimilarity(wanted, name string) float64 {
+	if wanted == name {
+		return 1
+	}
+	edits := 0.0
+	if longest := max(len([]rune(wanted)), len([]rune(name))); longest > 0 {
+		edits = 1 - float64(levenshtein(wanted, name))/float64(longest)
+	}
+	return max(trigramSimilarity(wanted, name), edits)
+}
+
+// trigramSimilarity averages the share of the trigrams of both strings they have in common with the share of the
+// trigrams of the wanted string found in the other, so that a few words of a long name are enough to find it.
+// Words are padded as with pg_trgm so that their beginnings weigh more.
+func trigramSimilarity(wanted, name string) float64 {
+	tw, tn := trigrams(wanted), trigrams(name)
+	if len(tw) == 0 || len(tn) == 0 {
+		return 0
+	}
+	shared := 0
+	for trigram := range tw {
+		if tn[trigram] {
+			shared++
+		}
+	}
+	return (float64(shared)/float64(len(tw)+len(tn)-shared) + float64(shared)/float64(len(tw))) / 2
+}
+
+// trigrams returns the set of trigrams of the words of a string, each word padded with two spaces before and one after
+func trigrams(s string) map[string]bool {
+	set := map[string]bool{}
+	words := strings.FieldsFunc(s, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsNumber(r) })
+	for _, word := range words {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			set[string(padded[i:i+3])] = true
+		}
+	}
+	return set
+}
+
+// levenshtein returns the number of runes to insert, delete or substitute to turn a into b
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			substitution := previous[j-1]
+			if ra[i-1] != rb[j-1] {
+				substitution++
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, substitution)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
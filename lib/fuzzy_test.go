@@ -0,0 +1,67 @@
+package lib
+
+import (
+	"testing"
+)
+
+func TestFoldName(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"Emily Brontë", "emily bronte", true},
+		{"Emily Brontë", "EMILY BRONTË", true}, // combining diaeresis
+		{"Die Straße", "die strasse", true},
+		{"Ærø", "ærø", true},
+		{"the hobbit", "the  hobbit", false},
+		{"the hobbit", "the hobbits", false},
+	}
+	for _, test := range tests {
+		if SameName(test.a, test.b) != test.same {
+			t.Error("expecting", test.a, "and", test.b, "the same", test.same, "got", FoldName(test.a), FoldName(test.b))
+		}
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		edits int
+	}{
+		{"", "", 0},
+		{"", "abc", 3},
+		{"kitten", "sitting", 3},
+		{"flaw", "lawn", 2},
+		{"brontë", "bronte", 1},
+	}
+	for _, test := range tests {
+		if edits := levenshtein(test.a, test.b); edits != test.edits {
+			t.Error("expecting", test.edits, "edits from", test.a, "to", test.b, "got", edits)
+		}
+	}
+}
+
+func TestSuggestBooks(t *testing.T) {
+	books := []BookIdentifier{
+		{ID: "1", Name: "Harry Potter and the Philosopher's Stone", Author: "J.K. Rowling"},
+		{ID: "2", Name: "Harry Potter and the Chamber of Secrets", Author: "J.K. Rowling"},
+		{ID: "3", Name: "The Hobbit", Author: "J.R.R. Tolkien"},
+		{ID: "4", Name: "Wuthering Heights", Author: "Emily Brontë"},
+	}
+
+	suggestions := SuggestBooks(BookIdentifier{Name: "Harry Poter and the Chamber of Secret", Author: "Rowling"}, books, DefaultSuggestionLimit)
+	if len(suggestions) != 2 || suggestions[0].ID != "2" || suggestions[1].ID != "1" || suggestions[0].Score >= 1 {
+		t.Error("expecting the chamber of secrets then the philosopher's stone got", suggestions)
+	}
+	suggestions = SuggestBooks(BookIdentifier{Name: "wutherin hieghts"}, books, DefaultSuggestionLimit)
+	if len(suggestions) != 1 || suggestions[0].ID != "4" {
+		t.Error("expecting typos forgiven got", suggestions)
+	}
+	suggestions = SuggestBooks(BookIdentifier{Name: "potter chamber"}, books, 1)
+	if len(suggestions) != 1 || suggestions[0].ID != "2" {
+		t.Error("expecting the most similar book only got", suggestions)
+	}
+	if suggestions = SuggestBooks(BookIdentifier{Name: "cooking with bread", Author: "baker"}, books, DefaultSuggestionLimit); len(suggestions) != 0 {
+		t.Error("expecting no suggestion for unrelated books got", suggestions)
+	}
+}
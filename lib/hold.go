@@ -0,0 +1,84 @@
+package lib
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	JsonBsonTagSequence     = "sequence"
+	JsonBsonTagPlacedAt     = "placedAt"
+	JsonBsonTagReadyAt      = "readyAt"
+	JsonBsonTagExpiresAt    = "expiresAt"
+	JsonBsonTagClosedAt     = "closedAt"
+	JsonBsonTagOnHold       = "onHold"
+	JsonBsonTagWaiting      = "waiting"
+	JsonBsonTagHoldSequence = "holdSequence"
+
+	HoldWaiting   = "waiting"   // queued until a copy comes back
+	HoldReady     = "ready"     // a copy is set aside for the patron until the hold expires
+	HoldFulfilled = "fulfilled" // the patron checked out the copy set aside
+	HoldCancelled = "cancelled"
+	HoldExpired   = "expired" // the copy set aside was not picked up in time
+)
+
+// Hold queues a patron for a book whose copies are all out. Holds of a book are served first come first served:
+// a returned copy is set aside for the oldest waiting hold, which the patron must pick up before it expires.
+type Hold struct {
+	ID        string     `bson:"_id,omitempty" json:"id,omitempty"`
+	Library   string     `bson:"library" json:"library"`
+	BookID    string     `bson:"bookId" json:"bookId"`
+	PatronID  string     `bson:"patronId" json:"patronId"`
+	Status    string     `bson:"status" json:"status"`
+	Sequence  int64      `bson:"sequence" json:"-"` // orders the holds of a book
+	PlacedAt  time.Time  `bson:"placedAt" json:"placedAt"`
+	ReadyAt   *time.Time `bson:"readyAt,omitempty" json:"readyAt,omitempty"`
+	ExpiresAt *time.Time `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"` // pickup deadline of a ready hold
+	ClosedAt  *time.Time `bson:"closedAt,omitempty" json:"closedAt,omitempty"`   // when fulfilled, cancelled or expired
+
+	Position int `bson:"-" json:"position,omitempty"` // place in the queue of a waiting hold, 1 being next
+}
+
+// HoldFilter selects holds, every field is optional
+type HoldFilter struct {
+	BookID   string
+	PatronID string
+	Active   bool // only holds waiting or ready
+}
+
+var ( // Errors
+	NoMatchingHold    = errors.New("no matching hold in library")
+	HoldAlreadyPlaced = errors.New("patron already has a hold on the book")
+	CopyAvailable     = errors.New("a copy of the book is available, check it out instead")
+	HoldNotActive     = errors.New("hold already fulfilled, cancelled or expired")
+)
+
+// IsActive checks whether the hold is still waiting or ready
+func (h *Hold) IsActive() bool {
+	return h.Status == HoldWaiting || h.Status == HoldReady
+}
+
+// SetReady sets a copy aside for the hold from now until the end of the pickup window
+func (h *Hold) SetReady(now time.Time, pickupWindow time.Duration) {
+	expiresAt := now.Add(pickupWindow)
+	h.Status = HoldReady
+	h.ReadyAt = &now
+	h.ExpiresAt = &expiresAt
+}
+
+// Close ends an active hold with the given status
+func (h *Hold) Close(status string, now time.Time) {
+	h.Status = status
+	h.ClosedAt = &now
+}
+
+// Matches checks whether the hold is selected by the filter
+func (f HoldFilter) Matches(hold Hold) bool {
+	if f.BookID != "" && hold.BookID != f.BookID {
+		return false
+	}
+	if f.PatronID != "" && hold.PatronID != f.PatronID {
+		return false
+	}
+	return !f.Active || hold.IsActive()
+}
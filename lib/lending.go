@@ -0,0 +1,129 @@
+package lib
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+const (
+	JsonBsonTagPatronID     = "patronId"
+	JsonBsonTagCheckedOutAt = "checkedOutAt"
+	JsonBsonTagDueAt        = "dueAt"
+	JsonBsonTagReturnedAt   = "returnedAt"
+	JsonBsonTagRenewals     = "renewals"
+	JsonBsonTagCopies       = "copies"
+	JsonBsonTagOnLoan       = "onLoan"
+
+	DefaultCopies = 1 // copies of a book whose count was never set
+)
+
+// Patron is a member of a library who may borrow its books
+type Patron struct {
+	ID        string    `bson:"_id,omitempty" json:"id,omitempty"`
+	Library   string    `bson:"library" json:"library"`
+	Name      string    `bson:"name" json:"name"`
+	Email     string    `bson:"email,omitempty" json:"email,omitempty"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// Loan is a copy of a book checked out by a patron until its due date, kept as history once returned
+type Loan struct {
+	ID           string     `bson:"_id,omitempty" json:"id,omitempty"`
+	Library      string     `bson:"library" json:"library"`
+	BookID       string     `bson:"bookId" json:"bookId"`
+	PatronID     string     `bson:"patronId" json:"patronId"`
+	CheckedOutAt time.Time  `bson:"checkedOutAt" json:"checkedOutAt"`
+	DueAt        time.Time  `bson:"dueAt" json:"dueAt"`
+	ReturnedAt   *time.Time `bson:"returnedAt,omitempty" json:"returnedAt,omitempty"`
+	Renewals     int        `bson:"renewals" json:"renewals"`
+}
+
+// BookCopies counts the copies a library owns of a book, how many of them are on loan or set aside for ready holds,
+// and how many holds wait for a copy
+type BookCopies struct {
+	Library      string `bson:"library" json:"-"`
+	BookID       string `bson:"bookId" json:"bookId"`
+	Copies       int    `bson:"copies" json:"copies"`
+	OnLoan       int    `bson:"onLoan" json:"onLoan"`
+	OnHold       int    `bson:"onHold" json:"onHold"`
+	Waiting      int    `bson:"waiting" json:"waiting"`
+	HoldSequence int64  `bson:"holdSequence" json:"-"` // sequence of the last hold placed on the book
+	Available    int    `bson:"-" json:"available"`
+}
+
+// LoanFilter selects loans, every field is optional
+type LoanFilter struct {
+	BookID    string
+	PatronID  string
+	Active    bool      // only loans not returned yet
+	DueBefore time.Time // only active loans due before this time, the overdue ones when now
+}
+
+var ( // Errors
+	NoMatchingPatron     = errors.New("no matching patron in library")
+	IncompletePatron     = errors.New("patrons need a name and, when given, a valid email")
+	PatronHasActiveLoans = errors.New("patron still has books on loan or on hold")
+	NoMatchingLoan       = errors.New("no matching loan in library")
+	NoCopyAvailable      = errors.New("every copy of the book is on loan")
+	LoanAlreadyReturned  = errors.New("loan already returned")
+	LoanOverdue          = errors.New("overdue loans cannot be renewed, return the book instead")
+	RenewalLimitReached  = errors.New("loan already renewed as many times as allowed")
+	IncorrectCopies      = errors.New("copies cannot be negative")
+	CopiesOnLoan         = errors.New("more copies of the book are on loan or set aside for holds than requested")
+	BookHasActiveLoans   = errors.New("book still has copies on loan or on hold")
+)
+
+// Normalise validates a patron and trims its name and email in place
+func (p *Patron) Normalise() error {
+	p.Name = strings.TrimSpace(p.Name)
+	p.Email = strings.TrimSpace(p.Email)
+	if p.Name == "" {
+		return IncompletePatron
+	}
+	if p.Email != "" {
+		address, err := mail.ParseAddress(p.Email)
+		if err != nil || address.Address != p.Email {
+			return IncompletePatron
+		}
+	}
+	return nil
+}
+
+// IsActive checks whether the loaned copy has not been returned yet
+func (l *Loan) IsActive() bool {
+	return l.ReturnedAt == nil
+}
+
+// IsOverdue checks whether the loaned copy is still out past its due date
+func (l *Loan) IsOverdue(now time.Time) bool {
+	return l.IsActive() && now.After(l.DueAt)
+}
+
+// Matches checks whether the loan is selected by the filter
+func (f LoanFilter) Matches(loan Loan) bool {
+	if f.BookID != "" && loan.BookID != f.BookID {
+		return false
+	}
+	if f.PatronID != "" && loan.PatronID != f.PatronID {
+		return false
+	}
+	if (f.Active || !f.DueBefore.IsZero()) && !loan.IsActive() {
+		return false
+	}
+	return f.DueBefore.IsZero() || loan.DueAt.Before(f.DueBefore)
+}
+
+// Free returns the number of copies neither on loan nor set aside for a hold
+func (c *BookCopies) Free() int {
+	return c.Copies - c.OnLoan - c.OnHold
+}
+
+// SetAvailable sets the number of copies anyone may check out, none while holds wait for a copy
+func (c *BookCopies) SetAvailable() {
+	c.Available = c.Free()
+	if c.Available < 0 || c.Waiting > 0 {
+		c.Available = 0
+	}
+}
@@ -0,0 +1,39 @@
+package lib
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultLibraryName = "default" // library served by the original /api/library routes
+)
+
+// libraryNameRegex limits library names to characters that are safe to use in collection names and url paths. '_' is
+// left out as it separates the collection of a library from the collections kept next to it, eg library_tenant1_reviews.
+var libraryNameRegex = regexp.MustCompile(`^[a-zA-Z0-9-]{1,64}$`)
+
+// reservedLibraryNames are the suffixes of the collections kept next to the collection of a library, which a library
+// of the same name would share with the default library
+var reservedLibraryNames = map[string]bool{
+	"attachments": true, "authors": true, "bookmarks": true, "progress": true, "reviews": true, "revisions": true, "shelves": true,
+}
+
+type Library struct {
+	Name      string    `bson:"name" json:"name"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+var ( // Errors
+	NoMatchingLibrary        = errors.New("no matching library")
+	LibraryAlreadyExists     = errors.New("library already exists")
+	IncorrectLibraryName     = errors.New("library name must be 1-64 letters, digits or '-', other than attachments, authors, bookmarks, progress, reviews, revisions and shelves")
+	CannotDropDefaultLibrary = errors.New("the default library cannot be dropped")
+)
+
+// IsValidLibraryName checks that a library name can be used as a tenant key.
+func IsValidLibraryName(name string) bool {
+	return libraryNameRegex.MatchString(name) && !reservedLibraryNames[strings.ToLower(name)]
+}
@@ -0,0 +1,888 @@
+package lib
+
+import (
+	"errors"
+	"html"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	blockParagraph = iota
+	blockHeading
+	blockCode
+	blockQuote
+	blockList
+	blockRule
+)
+
+const (
+	inlineText = iota
+	inlineCode
+	inlineEmphasis
+	inlineStrong
+	inlineLink
+	inlineImage
+	inlineBreak
+)
+
+const (
+	maxLinkParens = 32 // parentheses a link destination can nest
+)
+
+var (
+	listItemMarker = regexp.MustCompile(`^( {0,3})([-*+]|(\d{1,9})[.)])( +|$)`)
+	codeLanguage   = regexp.MustCompile(`^[A-Za-z0-9_+#.-]+$`)
+	safeSchemes    = map[string]bool{"http": true, "https": true, "mailto": true}
+)
+
+var ( // Errors
+	UnacceptableMediaType = errors.New("contents can only be served as text/markdown, text/html or text/plain")
+)
+
+// markdownBlock is a block of a Markdown document
+type markdownBlock struct {
+	kind     int
+	level    int    // of headings
+	text     string // inline source of paragraphs and headings, code of code blocks
+	language string // of fenced code blocks
+	ordered  bool
+	start    int               // first number of ordered lists
+	items    [][]markdownBlock // of lists
+	children []markdownBlock   // of block quotes
+}
+
+// markdownInline is a span of text within a block
+type markdownInline struct {
+	kind     int
+	text     string // of text and code spans, alternative text of images
+	url      string // of links and images, empty when unsafe
+	title    string
+	children []markdownInline // of emphasis and links
+}
+
+// RenderHTML renders Markdown into HTML. The HTML is safe to embed in a page: every tag is generated by the renderer,
+// raw HTML in the Markdown is escaped, and links and images are only kept for http, https, mailto and relative URLs.
+func RenderHTML(markdown string) string {
+	renderer := htmlRenderer{}
+	renderer.writeBlocks(parseBlocks(markdownLines(markdown)), false)
+	return renderer.out.String()
+}
+
+// RenderXHTML renders Markdown into the body of an XHTML document, as RenderHTML with empty elements closed
+func RenderXHTML(markdown string) string {
+	renderer := htmlRenderer{xhtml: true}
+	renderer.writeBlocks(parseBlocks(markdownLines(markdown)), false)
+	return renderer.out.String()
+}
+
+// RenderPlainText renders Markdown into plain text, the text of the document without its markup
+func RenderPlainText(markdown string) string {
+	var out strings.Builder
+	writeBlocksPlain(&out, parseBlocks(markdownLines(markdown)))
+	return out.String()
+}
+
+// markdownLines splits a document into lines, without line ends and with tabs expanded
+func markdownLines(markdown string) []string {
+	markdown = strings.ReplaceAll(markdown, "\r\n", "\n")
+	markdown = strings.ReplaceAll(markdown, "\t", "    ")
+	return strings.Split(markdown, "\n")
+}
+
+// parseBlocks parses lines into blocks
+func parseBlocks(lines []string) []markdownBlock {
+	var blocks []markdownBlock
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			i++
+		case isFence(line):
+			var block markdownBlock
+			block, i = parseFence(lines, i)
+			blocks = append(blocks, block)
+		case markdownHeading.MatchString(trimmed):
+			match := markdownHeading.FindStringSubmatch(trimmed)
+			blocks = append(blocks, markdownBlock{kind: blockHeading, level: len(match[1]), text: match[2]})
+			i++
+		case isRule(trimmed):
+			blocks = append(blocks, markdownBlock{kind: blockRule})
+			i++
+		case strings.HasPrefix(trimmed, ">"):
+			var quoted []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">"); i++ {
+				content := strings.TrimPrefix(strings.TrimSpace(lines[i]), ">")
+				quoted = append(quoted, strings.TrimPrefix(content, " "))
+			}
+			blocks = append(blocks, markdownBlock{kind: blockQuote, children: parseBlocks(quoted)})
+		case listItemMarker.MatchString(line):
+			var block markdownBlock
+			block, i = parseList(lines, i)
+			blocks = append(blocks, block)
+		default:
+			var paragraph []string
+			for ; i < len(lines) && strings.TrimSpace(lines[i]) != "" && (len(paragraph) == 0 || !startsBlock(lines[i])); i++ {
+				paragraph = append(paragraph, strings.TrimLeft(lines[i], " "))
+			}
+			blocks = append(blocks, markdownBlock{kind: blockParagraph, text: strings.Join(paragraph, "\n")})
+		}
+	}
+	return blocks
+}
+
+// parseFence parses a fenced code block starting at a line, returning it with the line after it
+func parseFence(lines []string, i int) (markdownBlock, int) {
+	opening := strings.TrimSpace(lines[i])
+	fence := opening[:len(opening)-len(strings.TrimLeft(opening, opening[:1]))]
+	block := markdownBlock{kind: blockCode}
+	if info := strings.Fields(opening[len(fence):]); len(info) > 0 && codeLanguage.MatchString(info[0]) {
+		block.language = info[0]
+	}
+	var code []string
+	for i++; i < len(lines); i++ {
+		closing := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(closing, fence) && strings.Trim(closing, fence[:1]) == "" {
+			i++
+			break
+		}
+		code = append(code, lines[i])
+	}
+	block.text = strings.Join(code, "\n")
+	return block, i
+}
+
+// parseList parses a list starting at a line, items ending at a line starting a block less indented than their content
+func parseList(lines []string, i int) (markdownBlock, int) {
+	first := listItemMarker.FindStringSubmatch(lines[i])
+	block := markdownBlock{kind: blockList, ordered: first[3] != ""}
+	if block.ordered {
+		block.start, _ = strconv.Atoi(first[3])
+	}
+	for i < len(lines) {
+		marker := listItemMarker.FindStringSubmatch(lines[i])
+		if marker == nil || (marker[3] != "") != block.ordered {
+			break
+		}
+		indent := len(marker[0])
+		item := []string{lines[i][indent:]}
+		for i++; i < len(lines); i++ {
+			line := lines[i]
+			if strings.TrimSpace(line) == "" {
+				if i+1 < len(lines) && leadingSpaces(lines[i+1]) >= indent && strings.TrimSpace(lines[i+1]) != "" {
+					item = append(item, "")
+					continue
+				}
+				break
+			}
+			if leadingSpaces(line) >= indent {
+				item = append(item, line[indent:])
+				continue
+			}
+			if startsBlock(line) || strings.TrimSpace(item[len(item)-1]) == "" {
+				break
+			}
+			item = append(item, strings.TrimLeft(line, " ")) // lazy continuation of the paragraph
+		}
+		block.items = append(block.items, parseBlocks(item))
+		for i < len(lines) && strings.TrimSpace(lines[i]) == "" && i+1 < len(lines) && listItemMarker.MatchString(lines[i+1]) {
+			i++
+		}
+	}
+	return block, i
+}
+
+// startsBlock checks whether a line starts a block other than a paragraph, ending the paragraph before it
+func startsBlock(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return isFence(line) || markdownHeading.MatchString(trimmed) || isRule(trimmed) || strings.HasPrefix(trimmed, ">") ||
+		listItemMarker.MatchString(line)
+}
+
+// isFence checks whether a line opens or closes a fenced code block
+func isFence(line string) bool {
+	trimmed := strings.TrimLeft(line, " ")
+	return len(line)-len(trimmed) <= 3 && (strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"))
+}
+
+// isRule checks whether a trimmed line is a thematic break, three or more of the same of -, * or _
+func isRule(trimmed string) bool {
+	compact := strings.ReplaceAll(trimmed, " ", "")
+	if len(compact) < 3 {
+		return false
+	}
+	return strings.Trim(compact, compact[:1]) == "" && strings.Contains("-*_", compact[:1])
+}
+
+// leadingSpaces counts the spaces starting a line
+func leadingSpaces(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// parseInline parses the inline source of a block into spans in a single pass. As in the CommonMark reference
+// algorithm, delimiter runs and brackets are kept on stacks and matched as their closers are met, so that parsing
+// stays linear however many of them are left unmatched.
+func parseInline(source string) []markdownInline {
+	parser := &inlineParser{source: source}
+	for parser.pos < len(source) {
+		parser.parseNext()
+	}
+	parser.flush()
+	parser.processEmphasis(nil)
+	return parser.spans.toSpans()
+}
+
+// inlineNode is a span being parsed, linked to its siblings
+type inlineNode struct {
+	markdownInline
+	prev, next *inlineNode
+	children   inlineList
+}
+
+// inlineList is a list of sibling spans being parsed
+type inlineList struct {
+	first, last *inlineNode
+}
+
+// inlineDelimiter is a run of '*' or '_' that may open or close emphasis, on the delimiter stack
+type inlineDelimiter struct {
+	node              *inlineNode // text node of the delimiters left
+	char              byte
+	length            int // delimiters left
+	originalLength    int
+	canOpen, canClose bool
+	order             int // of the run in the source, to compare positions of delimiters
+	prev, next        *inlineDelimiter
+}
+
+// inlineBracket is a '[' or '![' that may open a link or an image, on the bracket stack
+type inlineBracket struct {
+	node       *inlineNode
+	image      bool
+	links      int              // links parsed when the bracket was met, links cannot contain links
+	delimiters *inlineDelimiter // top of the delimiter stack when the bracket was met
+	prev       *inlineBracket
+}
+
+// inlineParser parses the inline source of a block
+type inlineParser struct {
+	source     string
+	pos        int
+	spans      inlineList
+	text       strings.Builder // text not yet added to the spans
+	delimiters *inlineDelimiter
+	brackets   *inlineBracket
+	links      int
+	runs       int           // delimiter runs met
+	backticks  map[int][]int // positions of the backtick runs of each length, found on the first code span
+}
+
+// parseNext parses the span at the position of the parser, adding it to the spans
+func (p *inlineParser) parseNext() {
+	source, i := p.source, p.pos
+	c := source[i]
+	switch {
+	case c == '\\' && i+1 < len(source) && source[i+1] == '\n':
+		p.flush()
+		p.appendNode(markdownInline{kind: inlineBreak})
+		p.pos += 2
+	case c == '\\' && i+1 < len(source) && (unicode.IsPunct(rune(source[i+1])) || unicode.IsSymbol(rune(source[i+1]))):
+		p.text.WriteByte(source[i+1])
+		p.pos += 2
+	case c == '\n':
+		if line := p.text.String(); strings.HasSuffix(line, "  ") {
+			p.text.Reset()
+			p.text.WriteString(strings.TrimRight(line, " "))
+			p.flush()
+			p.appendNode(markdownInline{kind: inlineBreak})
+		} else {
+			p.text.WriteByte('\n')
+		}
+		p.pos++
+	case c == '`':
+		p.parseCodeSpan()
+	case c == '[' || (c == '!' && i+1 < len(source) && source[i+1] == '['):
+		image := c == '!'
+		p.pos += 1 + boolToInt(image)
+		p.flush()
+		node := p.appendNode(markdownInline{kind: inlineText, text: source[i:p.pos]})
+		p.brackets = &inlineBracket{node: node, image: image, links: p.links, delimiters: p.delimiters, prev: p.brackets}
+	case c == ']':
+		p.pos++
+		p.closeBracket()
+	case c == '<':
+		p.parseAutolink()
+	case c == '*' || c == '_':
+		p.parseDelimiterRun(c)
+	default:
+		p.text.WriteByte(c)
+		p.pos++
+	}
+}
+
+// parseCodeSpan parses a code span opening at the position of the parser, its backticks being text when it is not closed
+func (p *inlineParser) parseCodeSpan() {
+	start := p.pos
+	for p.pos < len(p.source) && p.source[p.pos] == '`' {
+		p.pos++
+	}
+	run := p.pos - start
+	closing := p.findBackticks(run, p.pos)
+	if closing < 0 {
+		p.text.WriteString(p.source[start:p.pos])
+		return
+	}
+	p.flush()
+	code := strings.ReplaceAll(p.source[p.pos:closing], "\n", " ")
+	if len(code) > 1 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.TrimSpace(code) != "" {
+		code = code[1 : len(code)-1]
+	}
+	p.appendNode(markdownInline{kind: inlineCode, text: code})
+	p.pos = closing + run
+}
+
+// findBackticks returns the position of the first run of backticks of a length from a position, -1 when there is none.
+// The runs are indexed on the first call, so that unclosed code spans do not each scan the rest of the source.
+func (p *inlineParser) findBackticks(length, from int) int {
+	if p.backticks == nil {
+		p.backticks = map[int][]int{}
+		for i := 0; i < len(p.source); {
+			if p.source[i] != '`' {
+				i++
+				continue
+			}
+			run := len(p.source[i:]) - len(strings.TrimLeft(p.source[i:], "`"))
+			p.backticks[run] = append(p.backticks[run], i)
+			i += run
+		}
+	}
+	positions := p.backticks[length]
+	if found := sort.SearchInts(positions, from); found < len(positions) {
+		return positions[found]
+	}
+	return -1
+}
+
+// parseAutolink parses <scheme:url> at the position of the parser, the '<' being text when it is not an autolink
+func (p *inlineParser) parseAutolink() {
+	i := p.pos
+	end := strings.IndexAny(p.source[i+1:], "> <\n")
+	if end <= 0 || p.source[i+1+end] != '>' {
+		p.text.WriteByte('<')
+		p.pos++
+		return
+	}
+	url := p.source[i+1 : i+1+end]
+	if safeURL(url) == "" || !strings.Contains(url, ":") {
+		p.text.WriteByte('<')
+		p.pos++
+		return
+	}
+	p.flush()
+	p.appendNode(markdownInline{kind: inlineLink, url: url, children: []markdownInline{{kind: inlineText, text: url}}})
+	p.pos = i + end + 2
+}
+
+// parseDelimiterRun parses a run of '*' or '_' as text, pushing it on the delimiter stack when it may open or close emphasis
+func (p *inlineParser) parseDelimiterRun(c byte) {
+	start := p.pos
+	for p.pos < len(p.source) && p.source[p.pos] == c {
+		p.pos++
+	}
+	before, after := ' ', ' '
+	if start > 0 {
+		before, _ = utf8.DecodeLastRuneInString(p.source[:start])
+	}
+	if p.pos < len(p.source) {
+		after, _ = utf8.DecodeRuneInString(p.source[p.pos:])
+	}
+	leftFlanking := !unicode.IsSpace(after) && (!isPunctuation(after) || unicode.IsSpace(before) || isPunctuation(before))
+	rightFlanking := !unicode.IsSpace(before) && (!isPunctuation(before) || unicode.IsSpace(after) || isPunctuation(after))
+	canOpen, canClose := leftFlanking, rightFlanking
+	if c == '_' { // within a word, eg snake_case, '_' neither opens nor closes
+		canOpen = leftFlanking && (!rightFlanking || isPunctuation(before))
+		canClose = rightFlanking && (!leftFlanking || isPunctuation(after))
+	}
+
+	p.flush()
+	node := p.appendNode(markdownInline{kind: inlineText, text: p.source[start:p.pos]})
+	if !canOpen && !canClose {
+		return
+	}
+	p.runs++
+	delimiter := &inlineDelimiter{node: node, char: c, length: p.pos - start, originalLength: p.pos - start,
+		canOpen: canOpen, canClose: canClose, order: p.runs, prev: p.delimiters}
+	if p.delimiters != nil {
+		p.delimiters.next = delimiter
+	}
+	p.delimiters = delimiter
+}
+
+// closeBracket turns the spans since the bracket on top of the bracket stack into a link or an image when the ']' just
+// parsed is followed by its destination, otherwise the ']' is text
+func (p *inlineParser) closeBracket() {
+	opener := p.brackets
+	if opener == nil {
+		p.text.WriteByte(']')
+		return
+	}
+	p.brackets = opener.prev
+	url, title, end, ok := parseLinkDestination(p.source, p.pos)
+	if !ok || (!opener.image && opener.links != p.links) {
+		p.text.WriteByte(']')
+		return
+	}
+	p.flush()
+	p.processEmphasis(opener.delimiters)
+	label := p.spans.cut(opener.node.next, p.spans.last)
+	p.spans.remove(opener.node)
+	if opener.image {
+		p.appendNode(markdownInline{kind: inlineImage, text: plainInline(label.toSpans()), url: safeURL(url), title: title})
+	} else {
+		p.links++
+		p.appendNode(markdownInline{kind: inlineLink, url: safeURL(url), title: title}).children = label
+	}
+	p.pos = end
+}
+
+// processEmphasis matches the delimiters above a bottom of the delimiter stack into emphasis, removing them from the stack.
+// Openers found not to match a kind of closer are remembered, so that no opener is searched twice for the same kind.
+func (p *inlineParser) processEmphasis(bottom *inlineDelimiter) {
+	bottomOrder := 0
+	if bottom != nil {
+		bottomOrder = bottom.order
+	}
+	if p.delimiters == bottom {
+		return
+	}
+	var openersBottom [2][2][3]int // order of the lowest opener to search, by char, closer opening and closer length % 3
+	closer := p.delimiters
+	for closer != nil && closer.prev != bottom {
+		closer = closer.prev
+	}
+	for closer != nil {
+		if !closer.canClose {
+			closer = closer.next
+			continue
+		}
+		openersFloor := &openersBottom[boolToInt(closer.char == '_')][boolToInt(closer.canOpen)][closer.originalLength%3]
+		floor := max(*openersFloor, bottomOrder)
+		opener := closer.prev
+		for opener != nil && opener.order > floor && !opener.matches(closer) {
+			opener = opener.prev
+		}
+		if opener == nil || opener.order <= floor {
+			*openersFloor = closer.order - 1
+			next := closer.next
+			if !closer.canOpen {
+				p.removeDelimiter(closer)
+			}
+			closer = next
+			continue
+		}
+
+		used := 1
+		if opener.length >= 2 && closer.length >= 2 {
+			used = 2
+		}
+		opener.length -= used
+		closer.length -= used
+		opener.node.text = opener.node.text[:opener.length]
+		closer.node.text = closer.node.text[:closer.length]
+		emphasis := &inlineNode{markdownInline: markdownInline{kind: inlineEmphasis}}
+		if used == 2 {
+			emphasis.kind = inlineStrong
+		}
+		if opener.node.next != closer.node {
+			emphasis.children = p.spans.cut(opener.node.next, closer.node.prev)
+		}
+		p.spans.insertAfter(opener.node, emphasis)
+		for between := closer.prev; between != opener; between = between.prev {
+			p.removeDelimiter(between)
+		}
+		if opener.length == 0 {
+			p.spans.remove(opener.node)
+			p.removeDelimiter(opener)
+		}
+		if closer.length == 0 {
+			next := closer.next
+			p.spans.remove(closer.node)
+			p.removeDelimiter(closer)
+			closer = next
+		}
+	}
+	for p.delimiters != bottom {
+		p.removeDelimiter(p.delimiters)
+	}
+}
+
+// matches checks whether a delimiter opens emphasis closed by another, runs that can both open and close only
+// matching when their lengths do not add up to a multiple of 3, unless both are
+func (d *inlineDelimiter) matches(closer *inlineDelimiter) bool {
+	if d.char != closer.char || !d.canOpen {
+		return false
+	}
+	return !(d.canClose || closer.canOpen) || (d.originalLength+closer.originalLength)%3 != 0 ||
+		(d.originalLength%3 == 0 && closer.originalLength%3 == 0)
+}
+
+// removeDelimiter removes a delimiter from the delimiter stack, leaving its text
+func (p *inlineParser) removeDelimiter(delimiter *inlineDelimiter) {
+	if delimiter.prev != nil {
+		delimiter.prev.next = delimiter.next
+	}
+	if delimiter.next != nil {
+		delimiter.next.prev = delimiter.prev
+	} else {
+		p.delimiters = delimiter.prev
+	}
+}
+
+// flush adds the text parsed since the last span as a text span
+func (p *inlineParser) flush() {
+	if p.text.Len() > 0 {
+		p.appendNode(markdownInline{kind: inlineText, text: p.text.String()})
+		p.text.Reset()
+	}
+}
+
+// appendNode adds a span after the spans parsed
+func (p *inlineParser) appendNode(span markdownInline) *inlineNode {
+	node := &inlineNode{markdownInline: span}
+	p.spans.insertAfter(p.spans.last, node)
+	return node
+}
+
+// insertAfter inserts a node after another, first when after is nil
+func (l *inlineList) insertAfter(after, node *inlineNode) {
+	node.prev = after
+	if after == nil {
+		node.next = l.first
+		l.first = node
+	} else {
+		node.next = after.next
+		after.next = node
+	}
+	if node.next == nil {
+		l.last = node
+	} else {
+		node.next.prev = node
+	}
+}
+
+// remove removes a node from the list
+func (l *inlineList) remove(node *inlineNode) {
+	l.cut(node, node)
+}
+
+// cut removes the nodes from first to last from the list, returning them as a list of their own
+func (l *inlineList) cut(first, last *inlineNode) inlineList {
+	if first == nil {
+		return inlineList{}
+	}
+	if first.prev == nil {
+		l.first = last.next
+	} else {
+		first.prev.next = last.next
+	}
+	if last.next == nil {
+		l.last = first.prev
+	} else {
+		last.next.prev = first.prev
+	}
+	first.prev, last.next = nil, nil
+	return inlineList{first: first, last: last}
+}
+
+// toSpans returns the spans of the list, merging adjacent texts
+func (l inlineList) toSpans() []markdownInline {
+	var spans []markdownInline
+	var text strings.Builder
+	for node := l.first; node != nil; node = node.next {
+		if node.kind == inlineText {
+			text.WriteString(node.text)
+			continue
+		}
+		if text.Len() > 0 {
+			spans = append(spans, markdownInline{kind: inlineText, text: text.String()})
+			text.Reset()
+		}
+		span := node.markdownInline
+		if node.children.first != nil {
+			span.children = node.children.toSpans()
+		}
+		spans = append(spans, span)
+	}
+	if text.Len() > 0 {
+		spans = append(spans, markdownInline{kind: inlineText, text: text.String()})
+	}
+	return spans
+}
+
+// parseLinkDestination parses the (url "title") following the label of a link at a position, returning the position
+// after it. Destinations nest at most maxLinkParens parentheses, which bounds the scan of unclosed destinations.
+func parseLinkDestination(source string, i int) (url, title string, end int, ok bool) {
+	if i >= len(source) || source[i] != '(' {
+		return "", "", 0, false
+	}
+	i = skipLinkSpaces(source, i+1)
+	if i < len(source) && source[i] == '<' {
+		stop := strings.IndexAny(source[i+1:], ">\n<")
+		if stop < 0 || source[i+1+stop] != '>' {
+			return "", "", 0, false
+		}
+		url = source[i+1 : i+1+stop]
+		i += stop + 2
+	} else {
+		start, depth := i, 0
+	destination:
+		for ; i < len(source); i++ {
+			switch c := source[i]; {
+			case c == '\\' && i+1 < len(source):
+				i++
+			case c == '(':
+				depth++
+				if depth > maxLinkParens {
+					return "", "", 0, false
+				}
+			case c == ')':
+				if depth == 0 {
+					break destination
+				}
+				depth--
+			case c <= ' ':
+				break destination
+			}
+		}
+		url = source[start:min(i, len(source))]
+	}
+	if spaced := skipLinkSpaces(source, i); spaced > i && spaced < len(source) && strings.IndexByte(`"'(`, source[spaced]) >= 0 {
+		closer := source[spaced]
+		if closer == '(' {
+			closer = ')'
+		}
+		j := spaced + 1
+		for ; j < len(source) && source[j] != closer; j++ {
+			if source[j] == '\\' {
+				j++
+			}
+		}
+		if j >= len(source) {
+			return "", "", 0, false
+		}
+		title, i = source[spaced+1:j], j+1
+	}
+	i = skipLinkSpaces(source, i)
+	if i >= len(source) || source[i] != ')' {
+		return "", "", 0, false
+	}
+	return url, title, i + 1, true
+}
+
+// skipLinkSpaces returns the position of the first byte from a position that is not a space or a line end
+func skipLinkSpaces(source string, i int) int {
+	for i < len(source) && (source[i] == ' ' || source[i] == '\n') {
+		i++
+	}
+	return i
+}
+
+// safeURL returns a URL when safe to link to, http, https, mailto or relative, otherwise an empty string
+func safeURL(url string) string {
+	url = strings.TrimSpace(url)
+	for _, r := range url {
+		if unicode.IsControl(r) || unicode.IsSpace(r) {
+			return ""
+		}
+	}
+	if colon := strings.IndexByte(url, ':'); colon >= 0 && !strings.ContainsAny(url[:colon], "/?#") {
+		if !safeSchemes[strings.ToLower(url[:colon])] {
+			return ""
+		}
+	}
+	return url
+}
+
+// htmlRenderer writes blocks as HTML or XHTML
+type htmlRenderer struct {
+	out   strings.Builder
+	xhtml bool
+}
+
+// emptyTag returns an empty element, closed in XHTML
+func (h *htmlRenderer) emptyTag(tag string) string {
+	if h.xhtml {
+		return "<" + tag + "/>"
+	}
+	return "<" + tag + ">"
+}
+
+// writeBlocks writes blocks, paragraphs of tight list items being written without their paragraph tags
+func (h *htmlRenderer) writeBlocks(blocks []markdownBlock, tight bool) {
+	out := &h.out
+	for i, block := range blocks {
+		if i > 0 || !tight {
+			out.WriteString("\n")
+		}
+		switch block.kind {
+		case blockHeading:
+			level := strconv.Itoa(block.level)
+			out.WriteString("<h" + level + ">")
+			h.writeInline(parseInline(block.text))
+			out.WriteString("</h" + level + ">")
+		case blockParagraph:
+			if tight {
+				h.writeInline(parseInline(block.text))
+				break
+			}
+			out.WriteString("<p>")
+			h.writeInline(parseInline(block.text))
+			out.WriteString("</p>")
+		case blockCode:
+			out.WriteString("<pre><code")
+			if block.language != "" {
+				out.WriteString(` class="language-` + html.EscapeString(block.language) + `"`)
+			}
+			out.WriteString(">" + html.EscapeString(block.text) + "</code></pre>")
+		case blockQuote:
+			out.WriteString("<blockquote>")
+			h.writeBlocks(block.children, false)
+			out.WriteString("</blockquote>")
+		case blockList:
+			tag := "ul"
+			if block.ordered {
+				tag = "ol"
+			}
+			out.WriteString("<" + tag)
+			if block.ordered && block.start != 1 {
+				out.WriteString(` start="` + strconv.Itoa(block.start) + `"`)
+			}
+			out.WriteString(">")
+			for _, item := range block.items {
+				out.WriteString("\n<li>")
+				h.writeBlocks(item, len(item) == 1 && item[0].kind == blockParagraph)
+				out.WriteString("</li>")
+			}
+			out.WriteString("\n</" + tag + ">")
+		case blockRule:
+			out.WriteString(h.emptyTag("hr"))
+		}
+	}
+	if !tight {
+		out.WriteString("\n")
+	}
+}
+
+// writeInline writes spans, escaping every text
+func (h *htmlRenderer) writeInline(spans []markdownInline) {
+	out := &h.out
+	for _, span := range spans {
+		switch span.kind {
+		case inlineText:
+			out.WriteString(html.EscapeString(span.text))
+		case inlineCode:
+			out.WriteString("<code>" + html.EscapeString(span.text) + "</code>")
+		case inlineEmphasis:
+			out.WriteString("<em>")
+			h.writeInline(span.children)
+			out.WriteString("</em>")
+		case inlineStrong:
+			out.WriteString("<strong>")
+			h.writeInline(span.children)
+			out.WriteString("</strong>")
+		case inlineLink:
+			if span.url == "" {
+				h.writeInline(span.children)
+				break
+			}
+			out.WriteString(`<a href="` + html.EscapeString(span.url) + `"`)
+			if span.title != "" {
+				out.WriteString(` title="` + html.EscapeString(span.title) + `"`)
+			}
+			out.WriteString(` rel="nofollow">`)
+			h.writeInline(span.children)
+			out.WriteString("</a>")
+		case inlineImage:
+			if span.url == "" {
+				out.WriteString(html.EscapeString(span.text))
+				break
+			}
+			out.WriteString(`<img src="` + html.EscapeString(span.url) + `" alt="` + html.EscapeString(span.text) + `"`)
+			if span.title != "" {
+				out.WriteString(` title="` + html.EscapeString(span.title) + `"`)
+			}
+			if h.xhtml {
+				out.WriteString("/")
+			}
+			out.WriteString(">")
+		case inlineBreak:
+			out.WriteString(h.emptyTag("br") + "\n")
+		}
+	}
+}
+
+// writeBlocksPlain writes blocks as plain text separated by blank lines
+func writeBlocksPlain(out *strings.Builder, blocks []markdownBlock) {
+	for i, block := range blocks {
+		if i > 0 {
+			out.WriteString("\n\n")
+		}
+		switch block.kind {
+		case blockHeading, blockParagraph:
+			out.WriteString(plainInline(parseInline(block.text)))
+		case blockCode:
+			out.WriteString(block.text)
+		case blockQuote:
+			writeBlocksPlain(out, block.children)
+		case blockList:
+			for j, item := range block.items {
+				if j > 0 {
+					out.WriteString("\n")
+				}
+				bullet := "- "
+				if block.ordered {
+					bullet = strconv.Itoa(block.start+j) + ". "
+				}
+				var itemText strings.Builder
+				writeBlocksPlain(&itemText, item)
+				out.WriteString(bullet + strings.ReplaceAll(itemText.String(), "\n", "\n"+strings.Repeat(" ", len(bullet))))
+			}
+		case blockRule:
+			out.WriteString("* * *")
+		}
+	}
+}
+
+// plainInline returns the text of spans without their markup
+func plainInline(spans []markdownInline) string {
+	var out strings.Builder
+	for _, span := range spans {
+		switch span.kind {
+		case inlineText, inlineCode, inlineImage:
+			out.WriteString(span.text)
+		case inlineEmphasis, inlineStrong, inlineLink:
+			out.WriteString(plainInline(span.children))
+		case inlineBreak:
+			out.WriteString("\n")
+		}
+	}
+	return out.String()
+}
+
+// isPunctuation checks whether a character is punctuation or a symbol, for the flanking of emphasis delimiters
+func isPunctuation(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+// boolToInt returns 1 for true and 0 for false
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,79 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderHTML(t *testing.T) {
+	tests := []struct {
+		markdown string
+		expected string
+	}{
+		{"# Title ##", "\n<h1>Title</h1>\n"},
+		{"Some *emphasis*, **strong** and `code`.", "\n<p>Some <em>emphasis</em>, <strong>strong</strong> and <code>code</code>.</p>\n"},
+		{"snake_case_name and *a **b** c*", "\n<p>snake_case_name and <em>a <strong>b</strong> c</em></p>\n"},
+		{"line one  \nline two", "\n<p>line one<br>\nline two</p>\n"},
+		{"```go\nif a < b {}\n```", "\n<pre><code class=\"language-go\">if a &lt; b {}</code></pre>\n"},
+		{"> quoted\n> text", "\n<blockquote>\n<p>quoted\ntext</p>\n</blockquote>\n"},
+		{"- one\n- two\n\n3. three", "\n<ul>\n<li>one</li>\n<li>two</li>\n</ul>\n<ol start=\"3\">\n<li>three</li>\n</ol>\n"},
+		{"a\n\n* * *\n\nb", "\n<p>a</p>\n<hr>\n<p>b</p>\n"},
+		{"[the shire](https://example.com/shire \"map\") <mailto:bilbo@example.com>",
+			"\n<p><a href=\"https://example.com/shire\" title=\"map\" rel=\"nofollow\">the shire</a> <a href=\"mailto:bilbo@example.com\" rel=\"nofollow\">mailto:bilbo@example.com</a></p>\n"},
+		{"![a ring](ring.png)", "\n<p><img src=\"ring.png\" alt=\"a ring\"></p>\n"},
+		{"\\*not emphasis\\*", "\n<p>*not emphasis*</p>\n"},
+		{"**a* and *b**", "\n<p><em><em>a</em> and <em>b</em></em></p>\n"},
+		{"*a [b* c](d)", "\n<p>*a <a href=\"d\" rel=\"nofollow\">b* c</a></p>\n"},
+		{"[a [b](c) d](e)", "\n<p>[a <a href=\"c\" rel=\"nofollow\">b</a> d](e)</p>\n"},
+		{"![a *b*](c.png)", "\n<p><img src=\"c.png\" alt=\"a b\"></p>\n"},
+	}
+	for _, test := range tests {
+		if rendered := RenderHTML(test.markdown); rendered != test.expected {
+			t.Errorf("expecting %q rendered as %q got %q", test.markdown, test.expected, rendered)
+		}
+	}
+}
+
+func TestRenderHTMLIsSafe(t *testing.T) {
+	attacks := []string{
+		"<script>alert(1)</script>",
+		"<img src=x onerror=alert(1)>",
+		"[click](javascript:alert(1))",
+		"[click](JaVaScRiPt:alert(1))",
+		"[click](java\tscript:alert(1))",
+		"[click](&#106;avascript:alert(1))",
+		"![x](data:text/html;base64,PHNjcmlwdD4=)",
+		"[x](https://example.com\" onmouseover=\"alert(1))",
+		"```\"><script>alert(1)</script>\nx\n```",
+		"<javascript:alert(1)>",
+		"`<script>`",
+	}
+	for _, attack := range attacks {
+		rendered := strings.ToLower(RenderHTML(attack))
+		if strings.Contains(rendered, "<script") || strings.Contains(rendered, "<img src=x") || strings.Contains(rendered, "\" onmouseover") ||
+			strings.Contains(rendered, "href=\"javascript") || strings.Contains(rendered, "src=\"data:") {
+			t.Errorf("expecting %q rendered safely got %q", attack, rendered)
+		}
+	}
+}
+
+func TestRenderPlainText(t *testing.T) {
+	markdown := "# The hobbit\n\nIn a *hole* in the [ground](https://example.com).\n\n- one\n- two\n\n> quoted\n\n```\ncode\n```"
+	expected := "The hobbit\n\nIn a hole in the ground.\n\n- one\n- two\n\nquoted\n\ncode"
+	if rendered := RenderPlainText(markdown); rendered != expected {
+		t.Errorf("expecting %q got %q", expected, rendered)
+	}
+}
+
+func TestRenderHTMLIsLinear(t *testing.T) {
+	// unmatched delimiters and brackets used to each scan the rest of the paragraph, taking tens of seconds
+	for _, unit := range []string{"*a ", "_a ", "**a ", "[a ", "![a](", "[a](b ", "`a ``", "<a ", "a*b*c ", "*a [b](c) "} {
+		markdown := strings.Repeat(unit, 240000/len(unit))
+		start := time.Now()
+		RenderHTML(markdown)
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("expecting a paragraph of %q rendered in linear time got %v", unit, elapsed)
+		}
+	}
+}
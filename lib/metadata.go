@@ -0,0 +1,321 @@
+package lib
+
+import (
+	"errors"
+	"golang.org/x/text/language"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	JsonBsonTagISBN10          = "isbn10"
+	JsonBsonTagISBN13          = "isbn13"
+	JsonBsonTagContributors    = "contributors"
+	JsonBsonTagPublisher       = "publisher"
+	JsonBsonTagPublicationDate = "publicationDate"
+	JsonBsonTagLanguage        = "language"
+	JsonBsonTagPageCount       = "pageCount"
+	JsonBsonTagSeries          = "series"
+	JsonBsonTagVolume          = "volume"
+	JsonBsonTagTags            = "tags"
+
+	RoleAuthor = "author"
+)
+
+// contributorRoles are the roles a contributor to a book may have
+var contributorRoles = map[string]bool{
+	RoleAuthor:    true,
+	"editor":      true,
+	"translator":  true,
+	"illustrator": true,
+	"narrator":    true,
+	"contributor": true,
+}
+
+// publicationDateLayouts are the accepted publication date precisions, year, month or day
+var publicationDateLayouts = []string{"2006", "2006-01", "2006-01-02"}
+
+type Contributor struct {
+	Name string `bson:"name" json:"name"`
+	Role string `bson:"role" json:"role,omitempty"`
+}
+
+// BookFilter narrows the list of books, empty fields match every book
+type BookFilter struct {
+	ISBN      string
+	Author    string // primary author or any contributor
+	AuthorID  string // id of the known primary author
+	Publisher string
+	Language  string
+	Series    string
+	Year      string   // publication year
+	Tags      []string // books must have every tag
+
+	UpdatedAfter  time.Time // zero for no lower bound
+	UpdatedBefore time.Time // zero for no upper bound
+}
+
+var ( // Errors
+	IncorrectISBN            = errors.New("isbn is not a valid ISBN-10 or ISBN-13")
+	MismatchedISBN           = errors.New("isbn10 and isbn13 are not the same book")
+	IncorrectLanguage        = errors.New("language is not a valid BCP 47 tag")
+	IncorrectPublicationDate = errors.New("publication date must be YYYY, YYYY-MM or YYYY-MM-DD")
+	IncorrectContributor     = errors.New("contributors need a name and a role of author, editor, translator, illustrator, narrator or contributor")
+	IncorrectPageCount       = errors.New("page count cannot be negative")
+	VolumeWithoutSeries      = errors.New("volume given without a series")
+)
+
+// NormaliseMetadata validates the bibliographic metadata of a book and normalises it in place:
+// ISBNs are filled in both forms, the language is canonicalised, contributor roles default to author and tags are lower cased, sorted and de-duplicated.
+func (b *Book) NormaliseMetadata() error {
+	err := b.normaliseISBN()
+	if err != nil {
+		return err
+	}
+
+	if b.Language != "" {
+		tag, err := language.Parse(b.Language)
+		if err != nil {
+			return IncorrectLanguage
+		}
+		b.Language = tag.String()
+	}
+
+	if b.PublicationDate != "" && !isPublicationDate(b.PublicationDate) {
+		return IncorrectPublicationDate
+	}
+
+	for i := range b.Contributors {
+		contributor := &b.Contributors[i]
+		contributor.Name = strings.TrimSpace(contributor.Name)
+		contributor.Role = strings.ToLower(strings.TrimSpace(contributor.Role))
+		if contributor.Role == "" {
+			contributor.Role = RoleAuthor
+		}
+		if contributor.Name == "" || !contributorRoles[contributor.Role] {
+			return IncorrectContributor
+		}
+	}
+
+	if b.PageCount < 0 {
+		return IncorrectPageCount
+	}
+	b.Series = strings.TrimSpace(b.Series)
+	if b.Volume != 0 && b.Series == "" {
+		return VolumeWithoutSeries
+	}
+
+	if b.ChapterPattern != "" {
+		_, err = regexp.Compile(b.ChapterPattern)
+		if err != nil {
+			return IncorrectChapterPattern
+		}
+	}
+
+	b.Tags = NormaliseTags(b.Tags)
+	return nil
+}
+
+// Matches checks a book against every set field of the filter
+func (f BookFilter) Matches(book *Book) bool {
+	if f.ISBN != "" && book.ISBN13 != f.ISBN {
+		return false
+	}
+	if f.Author != "" && !book.HasContributor(f.Author) {
+		return false
+	}
+	if f.AuthorID != "" && book.AuthorID != f.AuthorID {
+		return false
+	}
+	if f.Publisher != "" && book.Publisher != f.Publisher {
+		return false
+	}
+	if f.Language != "" && book.Language != f.Language {
+		return false
+	}
+	if f.Series != "" && book.Series != f.Series {
+		return false
+	}
+	if f.Year != "" && !strings.HasPrefix(book.PublicationDate, f.Year) {
+		return false
+	}
+	for _, tag := range f.Tags {
+		if !containsString(book.Tags, tag) {
+			return false
+		}
+	}
+	if !f.UpdatedAfter.IsZero() && !book.UpdatedAt.After(f.UpdatedAfter) {
+		return false
+	}
+	if !f.UpdatedBefore.IsZero() && !book.UpdatedAt.Before(f.UpdatedBefore) {
+		return false
+	}
+	return true
+}
+
+// HasContributor checks whether the name is the primary author or any contributor of the book
+func (b *Book) HasContributor(name string) bool {
+	if b.Author == name {
+		return true
+	}
+	for _, contributor := range b.Contributors {
+		if contributor.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// NormaliseISBN validates an ISBN-10 or ISBN-13, ignoring hyphens and spaces, and returns it in both forms.
+// The ISBN-10 is empty for 979 prefixed ISBN-13s, which have no ISBN-10.
+func NormaliseISBN(isbn string) (isbn10, isbn13 string, err error) {
+	digits := stripISBN(isbn)
+	switch len(digits) {
+	case 10:
+		if !isValidISBN10(digits) {
+			return "", "", IncorrectISBN
+		}
+		body := "978" + digits[:9]
+		return digits, body + isbn13CheckDigit(body), nil
+	case 13:
+		if !isValidISBN13(digits) {
+			return "", "", IncorrectISBN
+		}
+		if strings.HasPrefix(digits, "978") {
+			body := digits[3:12]
+			return body + isbn10CheckDigit(body), digits, nil
+		}
+		return "", digits, nil
+	default:
+		return "", "", IncorrectISBN
+	}
+}
+
+// NormaliseTags lower cases, trims, sorts and de-duplicates tags
+func NormaliseTags(tags []string) []string {
+	var normalised []string
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !containsString(normalised, tag) {
+			normalised = append(normalised, tag)
+		}
+	}
+	sort.Strings(normalised)
+	return normalised
+}
+
+// normaliseISBN fills in both ISBN forms from whichever was given, checking they agree when both were
+func (b *Book) normaliseISBN() error {
+	if b.ISBN10 == "" && b.ISBN13 == "" {
+		return nil
+	}
+	var isbn10, isbn13 string
+	if b.ISBN13 != "" {
+		if len(stripISBN(b.ISBN13)) != 13 {
+			return IncorrectISBN
+		}
+		var err error
+		isbn10, isbn13, err = NormaliseISBN(b.ISBN13)
+		if err != nil {
+			return err
+		}
+	}
+	if b.ISBN10 != "" {
+		if len(stripISBN(b.ISBN10)) != 10 {
+			return IncorrectISBN
+		}
+		given10, given13, err := NormaliseISBN(b.ISBN10)
+		if err != nil {
+			return err
+		}
+		if isbn13 != "" && isbn13 != given13 {
+			return MismatchedISBN
+		}
+		isbn10, isbn13 = given10, given13
+	}
+	b.ISBN10, b.ISBN13 = isbn10, isbn13
+	return nil
+}
+
+// stripISBN removes hyphens and spaces from an ISBN and upper cases a trailing X
+func stripISBN(isbn string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(isbn))
+}
+
+// isValidISBN10 checks the weighted mod 11 checksum of a 10 character ISBN, whose check digit may be X
+func isValidISBN10(isbn string) bool {
+	sum := 0
+	for i, char := range isbn {
+		var value int
+		switch {
+		case char >= '0' && char <= '9':
+			value = int(char - '0')
+		case char == 'X' && i == 9:
+			value = 10
+		default:
+			return false
+		}
+		sum += value * (10 - i)
+	}
+	return sum%11 == 0
+}
+
+// isValidISBN13 checks the alternating 1 and 3 weighted mod 10 checksum of a 13 digit ISBN
+func isValidISBN13(isbn string) bool {
+	for _, char := range isbn {
+		if char < '0' || char > '9' {
+			return false
+		}
+	}
+	return isbn13CheckDigit(isbn[:12]) == isbn[12:]
+}
+
+// isbn10CheckDigit returns the check digit for the first 9 digits of an ISBN-10
+func isbn10CheckDigit(body string) string {
+	sum := 0
+	for i, char := range body {
+		sum += int(char-'0') * (10 - i)
+	}
+	check := (11 - sum%11) % 11
+	if check == 10 {
+		return "X"
+	}
+	return strconv.Itoa(check)
+}
+
+// isbn13CheckDigit returns the check digit for the first 12 digits of an ISBN-13
+func isbn13CheckDigit(body string) string {
+	sum := 0
+	for i, char := range body {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += int(char-'0') * weight
+	}
+	return strconv.Itoa((10 - sum%10) % 10)
+}
+
+// isPublicationDate checks the date is a valid year, month or day
+func isPublicationDate(date string) bool {
+	for _, layout := range publicationDateLayouts {
+		if len(date) == len(layout) {
+			if _, err := time.Parse(layout, date); err == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package lib
+
+import "testing"
+
+func TestNormaliseISBN(t *testing.T) {
+	valid := map[string][2]string{
+		"0-306-40615-2":     {"0306406152", "9780306406157"},
+		"978-0-306-40615-7": {"0306406152", "9780306406157"},
+		"0-8044-2957-x":     {"080442957X", "9780804429573"},
+		"979 10 90636 07 1": {"", "9791090636071"},
+	}
+	for isbn, expected := range valid {
+		isbn10, isbn13, err := NormaliseISBN(isbn)
+		if err != nil {
+			t.Error(isbn, err)
+			continue
+		}
+		if isbn10 != expected[0] || isbn13 != expected[1] {
+			t.Error(isbn, "expecting", expected, "got", isbn10, isbn13)
+		}
+	}
+
+	invalid := []string{"0-306-40615-3", "978-0-306-40615-8", "X306406152", "12345", ""}
+	for _, isbn := range invalid {
+		if _, _, err := NormaliseISBN(isbn); err == nil {
+			t.Error(isbn, "should of failed here")
+		}
+	}
+}
+
+func TestNormaliseMetadata(t *testing.T) {
+	book := &Book{
+		ISBN10:          "0-306-40615-2",
+		Language:        "en-us",
+		PublicationDate: "1998-07",
+		Contributors:    []Contributor{{Name: " Mary GrandPré ", Role: "Illustrator"}, {Name: "JKR"}},
+		Series:          "Harry Potter",
+		Volume:          2,
+		Tags:            []string{"School", "fantasy", "school "},
+	}
+	err := book.NormaliseMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.ISBN13 != "9780306406157" || book.Language != "en-US" {
+		t.Error("unexpected isbn or language", book.ISBN13, book.Language)
+	}
+	if book.Contributors[0].Name != "Mary GrandPré" || book.Contributors[0].Role != "illustrator" || book.Contributors[1].Role != RoleAuthor {
+		t.Error("unexpected contributors", book.Contributors)
+	}
+	if len(book.Tags) != 2 || book.Tags[0] != "fantasy" || book.Tags[1] != "school" {
+		t.Error("unexpected tags", book.Tags)
+	}
+
+	invalid := []Book{
+		{ISBN10: "0306406152", ISBN13: "9791090636071"},
+		{ISBN13: "0306406152"},
+		{Language: "not a language"},
+		{PublicationDate: "1998-13"},
+		{Contributors: []Contributor{{Name: "someone", Role: "ghost"}}},
+		{PageCount: -1},
+		{Volume: 2},
+	}
+	for _, book := range invalid {
+		if err := book.NormaliseMetadata(); err == nil {
+			t.Error(book, "should of failed here")
+		}
+	}
+}
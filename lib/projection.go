@@ -0,0 +1,47 @@
+package lib
+
+// bookFields are the stored fields of a book that may be projected, by bson tag
+var bookFields = map[string]func(from, to *Book){
+	JsonBsonTagName:            func(from, to *Book) { to.Name = from.Name },
+	JsonBsonTagAuthor:          func(from, to *Book) { to.Author = from.Author },
+	JsonBsonTagAuthorID:        func(from, to *Book) { to.AuthorID = from.AuthorID },
+	JsonBsonTagContents:        func(from, to *Book) { to.Contents = from.Contents },
+	JsonBsonTagContentsFile:    func(from, to *Book) { to.ContentsFile = from.ContentsFile },
+	JsonBsonTagContentsLength:  func(from, to *Book) { to.ContentsLength = from.ContentsLength },
+	JsonBsonTagContentsHash:    func(from, to *Book) { to.ContentsHash = from.ContentsHash },
+	JsonBsonTagCreatedAt:       func(from, to *Book) { to.CreatedAt = from.CreatedAt },
+	JsonBsonTagUpdatedAt:       func(from, to *Book) { to.UpdatedAt = from.UpdatedAt },
+	JsonBsonTagDeletedAt:       func(from, to *Book) { to.DeletedAt = from.DeletedAt },
+	"isbn10":                   func(from, to *Book) { to.ISBN10 = from.ISBN10 },
+	JsonBsonTagISBN13:          func(from, to *Book) { to.ISBN13 = from.ISBN13 },
+	JsonBsonTagContributors:    func(from, to *Book) { to.Contributors = from.Contributors },
+	JsonBsonTagPublisher:       func(from, to *Book) { to.Publisher = from.Publisher },
+	JsonBsonTagPublicationDate: func(from, to *Book) { to.PublicationDate = from.PublicationDate },
+	JsonBsonTagLanguage:        func(from, to *Book) { to.Language = from.Language },
+	"pageCount":                func(from, to *Book) { to.PageCount = from.PageCount },
+	JsonBsonTagSeries:          func(from, to *Book) { to.Series = from.Series },
+	"volume":                   func(from, to *Book) { to.Volume = from.Volume },
+	JsonBsonTagTags:            func(from, to *Book) { to.Tags = from.Tags },
+	JsonBsonTagRating:          func(from, to *Book) { to.Rating = from.Rating },
+	JsonBsonTagChapterPattern:  func(from, to *Book) { to.ChapterPattern = from.ChapterPattern },
+	JsonBsonTagStructure:       func(from, to *Book) { to.Structure = from.Structure },
+	JsonBsonTagStats:           func(from, to *Book) { to.Stats = from.Stats },
+	JsonBsonTagTerms:           func(from, to *Book) { to.Terms = from.Terms },
+}
+
+// IsBookField checks whether a bson tag names a stored field of a book that may be projected
+func IsBookField(field string) bool {
+	_, exists := bookFields[field]
+	return exists || field == JsonBsonTagID
+}
+
+// Project returns a copy of the book holding only its id and the given fields, as a storage projection would
+func (b *Book) Project(fields []string) Book {
+	projected := Book{ID: b.ID}
+	for _, field := range fields {
+		if copyField, exists := bookFields[field]; exists {
+			copyField(b, &projected)
+		}
+	}
+	return projected
+}
@@ -0,0 +1,178 @@
+package lib
+
+import (
+	"container/list"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	RouteClassRead  = "read"
+	RouteClassList  = "list" // reads scanning a whole library, such as book lists, facets and GraphQL queries
+	RouteClassWrite = "write"
+
+	JsonBsonTagClient   = "client"
+	JsonBsonTagDay      = "day"
+	JsonBsonTagRequests = "requests"
+
+	maxRateBuckets = 100000 // buckets kept, the least recently used forgotten past it
+)
+
+// RateLimit lets a client make Burst requests at once, then Rate requests a second on average
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateDecision tells whether a request is allowed, and how close its client is to the limit
+type RateDecision struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // until the client is back to its full limit
+	RetryAfter time.Duration // until the next request is allowed, 0 when allowed
+}
+
+// QuotaUsage counts the requests of a client on a UTC day
+type QuotaUsage struct {
+	Client    string    `bson:"client" json:"client"`
+	Day       string    `bson:"day" json:"day"` // YYYY-MM-DD
+	Requests  int64     `bson:"requests" json:"requests"`
+	ExpiresAt time.Time `bson:"expiresAt" json:"-"` // once the day is over, when the usage can be forgotten
+}
+
+var ( // Errors
+	IncorrectRateLimits = errors.New("rate limits must be comma separated class=rate:burst, eg list=2:10, of the read, list and write classes")
+	RateLimitExceeded   = errors.New("too many requests, slow down")
+	QuotaExceeded       = errors.New("daily request quota exceeded")
+)
+
+// routeClasses are the classes rate limits can be set for
+var routeClasses = map[string]bool{RouteClassRead: true, RouteClassList: true, RouteClassWrite: true}
+
+// ParseRateLimits parses rate limits per route class given as comma separated class=rate:burst, rate being requests a
+// second, eg "read=20:40,list=2:10,write=5:20". Classes left out are not limited.
+func ParseRateLimits(limits string) (map[string]RateLimit, error) {
+	parsed := map[string]RateLimit{}
+	for _, limit := range strings.Split(limits, ",") {
+		limit = strings.TrimSpace(limit)
+		if limit == "" {
+			continue
+		}
+		class, value, found := strings.Cut(limit, "=")
+		rate, burst, hasBurst := strings.Cut(value, ":")
+		if !found || !hasBurst || !routeClasses[class] {
+			return nil, IncorrectRateLimits
+		}
+		var err error
+		var parsedLimit RateLimit
+		parsedLimit.Rate, err = strconv.ParseFloat(rate, 64)
+		if err != nil || parsedLimit.Rate <= 0 || math.IsInf(parsedLimit.Rate, 0) {
+			return nil, IncorrectRateLimits
+		}
+		parsedLimit.Burst, err = strconv.Atoi(burst)
+		if err != nil || parsedLimit.Burst < 1 {
+			return nil, IncorrectRateLimits
+		}
+		parsed[class] = parsedLimit
+	}
+	return parsed, nil
+}
+
+// RateLimiter limits the requests of each client per route class with token buckets: a client starts with Burst tokens,
+// each request takes one and tokens come back at Rate a second. The buckets of the clients seen the least recently are
+// forgotten past maxRateBuckets, most of them full again by then. The limiter is safe for concurrent use.
+type RateLimiter struct {
+	lock       sync.Mutex
+	limits     map[string]RateLimit
+	maxBuckets int
+	buckets    map[rateBucketKey]*list.Element
+	recent     *list.List // of tokenBucket, most recently used first
+}
+
+// rateBucketKey is the bucket of a client for a route class
+type rateBucketKey struct {
+	class  string
+	client string
+}
+
+// tokenBucket holds the tokens of a client as of the time they were last counted
+type tokenBucket struct {
+	key     rateBucketKey
+	tokens  float64
+	counted time.Time
+}
+
+// NewRateLimiter returns a rate limiter with limits per route class
+func NewRateLimiter(limits map[string]RateLimit) *RateLimiter {
+	return &RateLimiter{limits: limits, maxBuckets: maxRateBuckets, buckets: map[rateBucketKey]*list.Element{}, recent: list.New()}
+}
+
+// Allow takes a token from the bucket of a client for a route class when it has one. Classes without a limit always allow.
+func (l *RateLimiter) Allow(class, client string, now time.Time) RateDecision {
+	limit, limited := l.limits[class]
+	if !limited {
+		return RateDecision{Allowed: true}
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	bucket := l.bucket(rateBucketKey{class: class, client: client}, limit, now)
+	bucket.refill(limit, now)
+
+	decision := RateDecision{Limit: limit.Burst}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = rateDuration(1-bucket.tokens, limit.Rate)
+	}
+	decision.Remaining = int(bucket.tokens)
+	decision.Reset = rateDuration(float64(limit.Burst)-bucket.tokens, limit.Rate)
+	return decision
+}
+
+// bucket returns the bucket of a key as the most recently used, a full one when new, forgetting the least recently used
+// bucket past the maximum. Caller must hold the lock.
+func (l *RateLimiter) bucket(key rateBucketKey, limit RateLimit, now time.Time) *tokenBucket {
+	if element, found := l.buckets[key]; found {
+		l.recent.MoveToFront(element)
+		return element.Value.(*tokenBucket)
+	}
+	if len(l.buckets) >= l.maxBuckets {
+		oldest := l.recent.Back()
+		l.recent.Remove(oldest)
+		delete(l.buckets, oldest.Value.(*tokenBucket).key)
+	}
+	bucket := &tokenBucket{key: key, tokens: float64(limit.Burst), counted: now}
+	l.buckets[key] = l.recent.PushFront(bucket)
+	return bucket
+}
+
+// refill adds the tokens come back since the bucket was last counted, up to the burst
+func (b *tokenBucket) refill(limit RateLimit, now time.Time) {
+	if elapsed := now.Sub(b.counted); elapsed > 0 {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+elapsed.Seconds()*limit.Rate)
+		b.counted = now
+	}
+}
+
+// rateDuration returns how long tokens take to come back at a rate a second
+func rateDuration(tokens, rate float64) time.Duration {
+	return time.Duration(tokens / rate * float64(time.Second))
+}
+
+// QuotaDay returns the UTC day daily quotas count a request made at a time in, as YYYY-MM-DD
+func QuotaDay(now time.Time) string {
+	return now.UTC().Format(time.DateOnly)
+}
+
+// QuotaReset returns when the daily quotas of the day of a time start again, the next UTC midnight
+func QuotaReset(now time.Time) time.Time {
+	year, month, day := now.UTC().Date()
+	return time.Date(year, month, day+1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,84 @@
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	limits, err := ParseRateLimits("read=20:40, list=0.5:2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(limits) != 2 || limits[RouteClassRead] != (RateLimit{Rate: 20, Burst: 40}) || limits[RouteClassList] != (RateLimit{Rate: 0.5, Burst: 2}) {
+		t.Error("expecting read and list limits got", limits)
+	}
+	if limits, err = ParseRateLimits(""); err != nil || len(limits) != 0 {
+		t.Error("expecting no limits got", limits, err)
+	}
+	for _, incorrect := range []string{"read=20", "reads=1:1", "write=0:1", "write=1:0", "list=x:1", "list"} {
+		if _, err = ParseRateLimits(incorrect); err != IncorrectRateLimits {
+			t.Error("expecting", IncorrectRateLimits, "for", incorrect, "got", err)
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(map[string]RateLimit{RouteClassList: {Rate: 2, Burst: 3}})
+
+	for i := 2; i >= 0; i-- {
+		decision := limiter.Allow(RouteClassList, "ip:10.0.0.1", now)
+		if !decision.Allowed || decision.Remaining != i || decision.Limit != 3 {
+			t.Fatal("expecting the burst allowed got", decision)
+		}
+	}
+	decision := limiter.Allow(RouteClassList, "ip:10.0.0.1", now)
+	if decision.Allowed || decision.RetryAfter != 500*time.Millisecond || decision.Reset != 1500*time.Millisecond {
+		t.Error("expecting a token back in half a second got", decision)
+	}
+	if decision = limiter.Allow(RouteClassList, "ip:10.0.0.2", now); !decision.Allowed {
+		t.Error("expecting clients limited apart got", decision)
+	}
+	if decision = limiter.Allow(RouteClassRead, "ip:10.0.0.1", now); !decision.Allowed || decision.Limit != 0 {
+		t.Error("expecting classes without limit allowed got", decision)
+	}
+
+	// tokens come back at the rate, up to the burst
+	if decision = limiter.Allow(RouteClassList, "ip:10.0.0.1", now.Add(time.Second)); !decision.Allowed || decision.Remaining != 1 {
+		t.Error("expecting two tokens back got", decision)
+	}
+	if decision = limiter.Allow(RouteClassList, "ip:10.0.0.1", now.Add(time.Hour)); !decision.Allowed || decision.Remaining != 2 {
+		t.Error("expecting the bucket full again got", decision)
+	}
+}
+
+func TestRateLimiterForgetsLeastRecent(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(map[string]RateLimit{RouteClassList: {Rate: 1, Burst: 1}})
+	limiter.maxBuckets = 2
+
+	limiter.Allow(RouteClassList, "ip:10.0.0.1", now)
+	limiter.Allow(RouteClassList, "ip:10.0.0.2", now)
+	limiter.Allow(RouteClassList, "ip:10.0.0.1", now) // 10.0.0.2 is now the least recently used
+	limiter.Allow(RouteClassList, "ip:10.0.0.3", now)
+	if len(limiter.buckets) != 2 {
+		t.Error("expecting 2 buckets kept got", len(limiter.buckets))
+	}
+	if decision := limiter.Allow(RouteClassList, "ip:10.0.0.1", now); decision.Allowed {
+		t.Error("expecting the recently used bucket kept got", decision)
+	}
+	if decision := limiter.Allow(RouteClassList, "ip:10.0.0.2", now); !decision.Allowed {
+		t.Error("expecting the least recently used bucket forgotten got", decision)
+	}
+}
+
+func TestQuotaDay(t *testing.T) {
+	now := time.Date(2024, time.February, 29, 23, 30, 0, 0, time.FixedZone("UTC+2", 2*60*60))
+	if day := QuotaDay(now); day != "2024-02-29" {
+		t.Error("expecting the UTC day got", day)
+	}
+	if reset := QuotaReset(now); !reset.Equal(time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Error("expecting the next UTC midnight got", reset)
+	}
+}
@@ -0,0 +1,260 @@
+package lib
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	JsonBsonTagClientID = "clientId"
+	JsonBsonTagStart    = "start"
+
+	anchorContext    = 32   // runes of text kept on each side of a bookmark to re-anchor it
+	maxAnchorMatches = 1000 // occurrences of the anchor text weighed when re-anchoring
+)
+
+// ReadingProgress is how far a user read a book, as a character offset in its contents and the paragraph holding it.
+// Paragraphs are runs of non-blank lines separated by blank lines, counted from 0.
+type ReadingProgress struct {
+	BookID    string    `bson:"bookId" json:"bookId"`
+	UserID    string    `bson:"userId" json:"userId"`
+	Offset    int64     `bson:"offset" json:"offset"` // in characters, not bytes
+	Paragraph int       `bson:"paragraph" json:"paragraph"`
+	Percent   float64   `bson:"percent" json:"percent"`
+	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+// Bookmark marks a place in a book for a user, with a note and a highlighted range of characters from Start to End, End excluded.
+// A bookmark keeps the text it highlights and some text around it, to find its place again when the contents of the book change.
+// Bookmarks whose text is gone are kept where they were and flagged orphaned. Deleted bookmarks leave a tombstone for syncing devices.
+type Bookmark struct {
+	ID        string     `bson:"_id,omitempty" json:"id,omitempty"`
+	BookID    string     `bson:"bookId" json:"bookId"`
+	UserID    string     `bson:"userId" json:"userId"`
+	ClientID  string     `bson:"clientId,omitempty" json:"clientId,omitempty"` // given by the device creating the bookmark, unique per user and book
+	Start     int64      `bson:"start" json:"start"`
+	End       int64      `bson:"end" json:"end"` // equal to start for a bookmark without highlight
+	Note      string     `bson:"note,omitempty" json:"note,omitempty"`
+	Quote     string     `bson:"quote" json:"quote,omitempty"` // the highlighted text
+	Prefix    string     `bson:"prefix" json:"-"`
+	Suffix    string     `bson:"suffix" json:"-"`
+	Orphaned  bool       `bson:"orphaned" json:"orphaned,omitempty"`
+	CreatedAt time.Time  `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time  `bson:"updatedAt" json:"updatedAt"`
+	DeletedAt *time.Time `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
+}
+
+// ReadingState is the reading progress and bookmarks of a user on a book, as synced between the devices of the user
+type ReadingState struct {
+	Progress  *ReadingProgress `json:"progress,omitempty"`
+	Bookmarks []Bookmark       `json:"bookmarks"`
+	Since     *time.Time       `json:"since,omitempty"`    // in a sync request, when the device last synced
+	SyncedAt  time.Time        `json:"syncedAt,omitempty"` // in a response, to be sent as since on the next sync
+}
+
+var ( // Errors
+	NoMatchingProgress     = errors.New("user has not started reading the book")
+	NoMatchingBookmark     = errors.New("no matching bookmark of book")
+	IncompleteReading      = errors.New("reading progress and bookmarks need a user")
+	IncorrectPosition      = errors.New("positions must not be negative")
+	IncorrectBookmarkRange = errors.New("bookmarks must not end before they start")
+)
+
+// ParagraphStarts returns the character offset of the start of every paragraph of a text
+func ParagraphStarts(text string) []int64 {
+	var starts []int64
+	var offset int64
+	inParagraph := false
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			inParagraph = false
+		} else if !inParagraph {
+			starts = append(starts, offset)
+			inParagraph = true
+		}
+		offset += int64(utf8.RuneCountInString(line))
+	}
+	return starts
+}
+
+// Locate completes the progress within the contents of its book: the offset of the start of the paragraph when only
+// a paragraph is given, otherwise the paragraph holding the offset, both being kept within the contents
+func (p *ReadingProgress) Locate(text string) error {
+	if p.Offset < 0 || p.Paragraph < 0 {
+		return IncorrectPosition
+	}
+	length := int64(utf8.RuneCountInString(text))
+	starts := ParagraphStarts(text)
+	if p.Offset == 0 && p.Paragraph > 0 && len(starts) > 0 {
+		p.Paragraph = min(p.Paragraph, len(starts)-1)
+		p.Offset = starts[p.Paragraph]
+	} else {
+		p.Offset = min(p.Offset, length)
+		p.Paragraph = 0
+		for i, start := range starts {
+			if start > p.Offset {
+				break
+			}
+			p.Paragraph = i
+		}
+	}
+	p.Percent = 100
+	if length > 0 {
+		p.Percent = math.Round(float64(p.Offset)*1000/float64(length)) / 10
+	}
+	return nil
+}
+
+// Normalise validates a bookmark and normalises it in place, a range ending at 0 being a bookmark without highlight
+func (b *Bookmark) Normalise() error {
+	b.UserID = strings.TrimSpace(b.UserID)
+	b.Note = strings.TrimSpace(b.Note)
+	if b.UserID == "" {
+		return IncompleteReading
+	}
+	if b.Start < 0 || b.End < 0 {
+		return IncorrectPosition
+	}
+	if b.End == 0 {
+		b.End = b.Start
+	}
+	if b.End < b.Start {
+		return IncorrectBookmarkRange
+	}
+	return nil
+}
+
+// Anchor takes the highlighted text of the bookmark and the text around it from the contents of its book,
+// the range being kept within the contents
+func (b *Bookmark) Anchor(text string) {
+	length := int64(utf8.RuneCountInString(text))
+	b.Start = min(b.Start, length)
+	b.End = min(b.End, length)
+	start, end := byteOffset(text, b.Start), byteOffset(text, b.End)
+	b.Quote = text[start:end]
+	b.Prefix = lastRunes(text[:start], anchorContext)
+	b.Suffix = firstRunes(text[end:], anchorContext)
+	b.Orphaned = false
+}
+
+// Reanchor finds the place of the bookmark in the new contents of its book. The highlighted text is looked for,
+// or the text around a bookmark without highlight, the occurrence with the most matching text around it winning,
+// then the one closest to where the bookmark was. It returns false when the text is gone and the bookmark orphaned.
+func (b *Bookmark) Reanchor(text string) bool {
+	if b.Quote == "" && b.Prefix == "" && b.Suffix == "" {
+		b.Anchor(text)
+		return true
+	}
+	candidates := b.candidates(text)
+	if len(candidates) == 0 {
+		length := int64(utf8.RuneCountInString(text))
+		b.Start = min(b.Start, length)
+		b.End = min(b.End, length)
+		b.Orphaned = true
+		return false
+	}
+
+	// candidates are in text order, their character offsets are counted on from the previous one
+	var bestOffset, offset int64
+	bestScore, bestDistance, previous := -1, int64(math.MaxInt64), 0
+	for _, candidate := range candidates {
+		offset += int64(utf8.RuneCountInString(text[previous:candidate]))
+		previous = candidate
+		score := commonSuffixLength(text[:candidate], b.Prefix) + commonPrefixLength(text[candidate+len(b.Quote):], b.Suffix)
+		distance := offset - b.Start
+		if distance < 0 {
+			distance = -distance
+		}
+		if score > bestScore || (score == bestScore && distance < bestDistance) {
+			bestOffset, bestScore, bestDistance = offset, score, distance
+		}
+	}
+	b.Start = bestOffset
+	b.End = b.Start + int64(utf8.RuneCountInString(b.Quote))
+	b.Anchor(text)
+	return true
+}
+
+// candidates returns the byte offsets the highlighted text of the bookmark may start at in the text
+func (b *Bookmark) candidates(text string) []int {
+	if b.Quote != "" {
+		return occurrences(text, b.Quote, 0)
+	}
+	if candidates := occurrences(text, b.Prefix+b.Suffix, len(b.Prefix)); len(candidates) > 0 {
+		return candidates
+	}
+	if b.Prefix != "" {
+		if candidates := occurrences(text, b.Prefix, len(b.Prefix)); len(candidates) > 0 {
+			return candidates
+		}
+	}
+	if b.Suffix != "" {
+		return occurrences(text, b.Suffix, 0)
+	}
+	return nil
+}
+
+// occurrences returns the byte offsets of the occurrences of a substring in a text, shifted by the given bytes
+func occurrences(text, substring string, shift int) []int {
+	var found []int
+	for from := 0; len(found) < maxAnchorMatches; {
+		index := strings.Index(text[from:], substring)
+		if index < 0 {
+			break
+		}
+		found = append(found, from+index+shift)
+		_, size := utf8.DecodeRuneInString(text[from+index:])
+		from += index + max(size, 1)
+		if from > len(text) {
+			break
+		}
+	}
+	return found
+}
+
+// byteOffset returns the byte offset of a character offset in a text, the length of the text past its end
+func byteOffset(text string, offset int64) int {
+	for i := range text {
+		if offset == 0 {
+			return i
+		}
+		offset--
+	}
+	return len(text)
+}
+
+// lastRunes returns the last characters of a text
+func lastRunes(text string, count int) string {
+	start := len(text)
+	for i := 0; i < count && start > 0; i++ {
+		_, size := utf8.DecodeLastRuneInString(text[:start])
+		start -= size
+	}
+	return text[start:]
+}
+
+// firstRunes returns the first characters of a text
+func firstRunes(text string, count int) string {
+	return text[:byteOffset(text, int64(count))]
+}
+
+// commonPrefixLength returns how many leading bytes two strings share
+func commonPrefixLength(a, b string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+// commonSuffixLength returns how many trailing bytes two strings share
+func commonSuffixLength(a, b string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[len(a)-1-n] == b[len(b)-1-n] {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,74 @@
+package lib
+
+import (
+	"testing"
+)
+
+const readingTestText = "In a hole in the ground there lived a hobbit.\n\n" +
+	"Not a nasty, dirty, wet hole.\n\n" +
+	"It was a hobbit-hole, and that means comfort."
+
+func TestLocate(t *testing.T) {
+	if starts := ParagraphStarts(readingTestText); len(starts) != 3 || starts[0] != 0 || starts[1] != 47 || starts[2] != 78 {
+		t.Error("expecting three paragraphs got", starts)
+	}
+
+	byOffset := &ReadingProgress{Offset: 60}
+	if err := byOffset.Locate(readingTestText); err != nil || byOffset.Paragraph != 1 {
+		t.Error("expecting the offset in the second paragraph got", byOffset, err)
+	}
+	byParagraph := &ReadingProgress{Paragraph: 2}
+	if err := byParagraph.Locate(readingTestText); err != nil || byParagraph.Offset != 78 {
+		t.Error("expecting the start of the third paragraph got", byParagraph, err)
+	}
+	past := &ReadingProgress{Offset: 1000}
+	if err := past.Locate(readingTestText); err != nil || past.Percent != 100 || past.Paragraph != 2 {
+		t.Error("expecting the end of the book got", past, err)
+	}
+	if err := (&ReadingProgress{Offset: -1}).Locate(readingTestText); err != IncorrectPosition {
+		t.Error("expecting a negative offset refused got", err)
+	}
+}
+
+func TestReanchor(t *testing.T) {
+	bookmark := &Bookmark{UserID: "bilbo", Start: 53, End: 58}
+	if err := bookmark.Normalise(); err != nil {
+		t.Fatal(err)
+	}
+	bookmark.Anchor(readingTestText)
+	if bookmark.Quote != "nasty" {
+		t.Fatal("expecting nasty highlighted got", bookmark.Quote)
+	}
+
+	// text inserted before the bookmark moves it
+	moved := "Chapter 1\n\n" + readingTestText
+	if !bookmark.Reanchor(moved) || bookmark.Start != 64 || bookmark.End != 69 || bookmark.Quote != "nasty" {
+		t.Error("expecting the bookmark moved by the chapter title got", bookmark)
+	}
+
+	// of several occurrences the one with the same text around wins
+	repeated := "A nasty day.\n\n" + moved
+	if !bookmark.Reanchor(repeated) || bookmark.Start != 78 {
+		t.Error("expecting the bookmark in the same sentence got", bookmark)
+	}
+
+	// the bookmark stays near its place when its text is gone
+	rewritten := "In a hole in the ground there lived a hobbit.\n\nNot a pleasant hole."
+	if bookmark.Reanchor(rewritten) || !bookmark.Orphaned || bookmark.End > 67 {
+		t.Error("expecting an orphaned bookmark within the text got", bookmark)
+	}
+
+	// bookmarks without highlight follow the text around them
+	place := &Bookmark{UserID: "bilbo", Start: 47}
+	if err := place.Normalise(); err != nil {
+		t.Fatal(err)
+	}
+	place.Anchor(readingTestText)
+	if !place.Reanchor("Chapter 1\n\n"+readingTestText) || place.Start != 58 || place.End != 58 {
+		t.Error("expecting the bookmark at the start of the second paragraph got", place)
+	}
+
+	if err := (&Bookmark{UserID: "bilbo", Start: 10, End: 5}).Normalise(); err != IncorrectBookmarkRange {
+		t.Error("expecting a reversed range refused got", err)
+	}
+}
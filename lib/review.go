@@ -0,0 +1,156 @@
+package lib
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	JsonBsonTagRating    = "rating"
+	JsonBsonTagStars     = "rating" // the stars of a review are named after the rating of the book they count in
+	JsonBsonTagUserID    = "userId"
+	JsonBsonTagHelpful   = "helpful"
+	JsonBsonTagVoters    = "voters"
+	JsonBsonTagCount     = "count"
+	JsonBsonTagSum       = "sum"
+	JsonBsonTagAverage   = "average"
+	JsonBsonTagHistogram = "histogram"
+
+	MinStars = 1
+	MaxStars = 5
+
+	ReviewPending  = "pending"  // published until moderated
+	ReviewApproved = "approved" // published
+	ReviewRejected = "rejected" // hidden and left out of the rating of the book
+
+	ReviewSortRecent  = "recent"  // most recently written first
+	ReviewSortHelpful = "helpful" // most helpful first, then most recent
+)
+
+// Review is the opinion of a user on a book, a user writes at most one review per book.
+// Reviews are published as soon as written and stay so unless rejected by a moderator,
+// the stars of every review not rejected count in the rating of the book.
+type Review struct {
+	ID        string    `bson:"_id,omitempty" json:"id,omitempty"`
+	BookID    string    `bson:"bookId" json:"bookId"`
+	UserID    string    `bson:"userId" json:"userId"`
+	Stars     int       `bson:"rating" json:"rating"` // from MinStars to MaxStars
+	Title     string    `bson:"title,omitempty" json:"title,omitempty"`
+	Text      string    `bson:"text,omitempty" json:"text,omitempty"`
+	Status    string    `bson:"status" json:"status"`
+	Helpful   int       `bson:"helpful" json:"helpful"` // how many users found the review helpful
+	Voters    []string  `bson:"voters,omitempty" json:"-"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+// RatingSummary aggregates the stars of the reviews of a book, it is kept up to date on the book as reviews change
+type RatingSummary struct {
+	Count     int            `bson:"count" json:"count"`
+	Sum       int            `bson:"sum" json:"-"`
+	Average   float64        `bson:"average" json:"average"`
+	Histogram map[string]int `bson:"histogram" json:"histogram"` // review count keyed by stars, "1" to "5"
+}
+
+// ReviewQuery selects a page of the reviews of a book
+type ReviewQuery struct {
+	Status string // only reviews of this status, otherwise every review not rejected
+	Sort   string // ReviewSortRecent by default
+	Offset int
+	Limit  int
+}
+
+// ReviewPage is a page of the reviews of a book along with how many reviews the query selects in all
+type ReviewPage struct {
+	Reviews []Review `json:"reviews"`
+	Total   int      `json:"total"`
+	Offset  int      `json:"offset"`
+	Limit   int      `json:"limit"`
+}
+
+var ( // Errors
+	NoMatchingReview      = errors.New("no matching review of book")
+	ReviewAlreadyExists   = errors.New("user already reviewed the book")
+	IncompleteReview      = errors.New("reviews need a user and a rating from 1 to 5")
+	IncorrectReviewStatus = errors.New("review status must be pending, approved or rejected")
+)
+
+// IsValidReviewStatus checks whether the status is a moderation status of reviews
+func IsValidReviewStatus(status string) bool {
+	return status == ReviewPending || status == ReviewApproved || status == ReviewRejected
+}
+
+// Normalise validates a review written by a user and normalises it in place, trimming its user, title and text
+func (r *Review) Normalise() error {
+	r.UserID = strings.TrimSpace(r.UserID)
+	r.Title = strings.TrimSpace(r.Title)
+	r.Text = strings.TrimSpace(r.Text)
+	if r.UserID == "" || r.Stars < MinStars || r.Stars > MaxStars {
+		return IncompleteReview
+	}
+	return nil
+}
+
+// IsRated checks whether the stars of the review count in the rating of the book
+func (r *Review) IsRated() bool {
+	return r.Status != ReviewRejected
+}
+
+// HasVoted checks whether the user already found the review helpful
+func (r *Review) HasVoted(userID string) bool {
+	for _, voter := range r.Voters {
+		if voter == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// RatingChange returns the change to the number of reviews per stars of a book when a review goes from before to after,
+// either being nil when the review is created or deleted
+func RatingChange(before, after *Review) map[int]int {
+	change := map[int]int{}
+	if before != nil && before.IsRated() {
+		change[before.Stars]--
+	}
+	if after != nil && after.IsRated() {
+		change[after.Stars]++
+	}
+	for stars, delta := range change {
+		if delta == 0 {
+			delete(change, stars)
+		}
+	}
+	return change
+}
+
+// StarsKey returns the histogram key of a number of stars
+func StarsKey(stars int) string {
+	return strconv.Itoa(stars)
+}
+
+// Changed returns a copy of the summary with the number of reviews per stars changed, the summary itself being left untouched
+func (s *RatingSummary) Changed(change map[int]int) *RatingSummary {
+	changed := &RatingSummary{Histogram: map[string]int{}}
+	for stars := MinStars; stars <= MaxStars; stars++ {
+		changed.Histogram[StarsKey(stars)] = 0
+	}
+	if s != nil {
+		changed.Count = s.Count
+		changed.Sum = s.Sum
+		for key, count := range s.Histogram {
+			changed.Histogram[key] = count
+		}
+	}
+	for stars, delta := range change {
+		changed.Count += delta
+		changed.Sum += stars * delta
+		changed.Histogram[StarsKey(stars)] += delta
+	}
+	if changed.Count > 0 {
+		changed.Average = float64(changed.Sum) / float64(changed.Count)
+	}
+	return changed
+}
@@ -0,0 +1,37 @@
+package lib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRatingChange(t *testing.T) {
+	pending := &Review{Stars: 4, Status: ReviewPending}
+	rejected := &Review{Stars: 2, Status: ReviewRejected}
+	if change := RatingChange(nil, pending); !reflect.DeepEqual(change, map[int]int{4: 1}) {
+		t.Error("expecting a new 4 star rating got", change)
+	}
+	if change := RatingChange(pending, &Review{Stars: 4, Status: ReviewApproved}); len(change) != 0 {
+		t.Error("expecting approving to leave the rating got", change)
+	}
+	if change := RatingChange(pending, rejected); !reflect.DeepEqual(change, map[int]int{4: -1}) {
+		t.Error("expecting rejecting to remove the rating got", change)
+	}
+	if change := RatingChange(rejected, nil); len(change) != 0 {
+		t.Error("expecting deleting a rejected review to leave the rating got", change)
+	}
+
+	var summary *RatingSummary
+	summary = summary.Changed(map[int]int{4: 1})
+	changed := summary.Changed(map[int]int{4: -1, 1: 1, 5: 1})
+	if summary.Count != 1 || summary.Average != 4 || summary.Histogram["4"] != 1 {
+		t.Error("expecting the summary left untouched got", summary)
+	}
+	expected := map[string]int{"1": 1, "2": 0, "3": 0, "4": 0, "5": 1}
+	if changed.Count != 2 || changed.Average != 3 || !reflect.DeepEqual(changed.Histogram, expected) {
+		t.Error("expecting two ratings averaging 3 got", changed)
+	}
+	if emptied := changed.Changed(map[int]int{1: -1, 5: -1}); emptied.Count != 0 || emptied.Average != 0 {
+		t.Error("expecting no rating got", emptied)
+	}
+}
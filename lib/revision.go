@@ -0,0 +1,28 @@
+package lib
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	JsonBsonTagNumber       = "number"
+	JsonBsonTagContentsHash = "contentsHash"
+)
+
+// Revision is a version of the contents of a book, kept whenever they are written with changes until the book is purged,
+// or until older than the revision retention when not the latest. Revisions share their contents with the book while the latest.
+type Revision struct {
+	ID             string    `bson:"_id,omitempty" json:"-"`
+	BookID         string    `bson:"bookId" json:"bookId"`
+	Number         int       `bson:"number" json:"number"` // from 1, in the order the contents were written, kept when older ones are purged
+	CreatedAt      time.Time `bson:"createdAt" json:"createdAt"`
+	ContentsHash   string    `bson:"contentsHash" json:"contentsHash"` // hex SHA-256 of the contents
+	ContentsLength int64     `bson:"contentsLength" json:"contentsLength"`
+	Contents       string    `bson:"contents" json:"-"`
+	ContentsFile   string    `bson:"contentsFile" json:"-"` // id of the file holding contents too large to store inline
+}
+
+var ( // Errors
+	NoMatchingRevision = errors.New("no matching revision of book")
+)
@@ -0,0 +1,128 @@
+package lib
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	JsonBsonTagOwnerID    = "ownerId"
+	JsonBsonTagVisibility = "visibility"
+	JsonBsonTagBookIDs    = "bookIds"
+	JsonBsonTagVersion    = "version"
+
+	ShelfPrivate = "private" // only seen by its owner
+	ShelfPublic  = "public"  // seen by everyone, changed by its owner only
+)
+
+// Shelf is a reading collection of a user, an ordered list of books of the library, eg "to read" or "favourites".
+// A book is on a shelf at most once and leaves every shelf when purged, books in the trash being hidden until restored.
+type Shelf struct {
+	ID          string    `bson:"_id,omitempty" json:"id,omitempty"`
+	OwnerID     string    `bson:"ownerId" json:"ownerId"`
+	Name        string    `bson:"name" json:"name"`
+	Description string    `bson:"description,omitempty" json:"description,omitempty"`
+	Visibility  string    `bson:"visibility" json:"visibility"`
+	BookIDs     []string  `bson:"bookIds" json:"bookIds"` // in shelf order
+	Version     int64     `bson:"version" json:"-"`       // incremented on every change, guards concurrent changes of the books
+	CreatedAt   time.Time `bson:"createdAt" json:"createdAt"`
+	UpdatedAt   time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+// ShelfFilter selects shelves, every field is optional
+type ShelfFilter struct {
+	OwnerID   string
+	VisibleTo string // only public shelves and the private ones of this user
+}
+
+var ( // Errors
+	NoMatchingShelf     = errors.New("no matching shelf in library")
+	IncompleteShelf     = errors.New("shelves need an owner and a name")
+	IncorrectVisibility = errors.New("shelf visibility must be public or private")
+	IncorrectShelfOrder = errors.New("order must list every book of the shelf once")
+	ShelfNotOwned       = errors.New("only the owner of a shelf may change it")
+)
+
+// Normalise validates a shelf and normalises it in place, trimming its owner and name, shelves being private unless told otherwise
+func (s *Shelf) Normalise() error {
+	s.OwnerID = strings.TrimSpace(s.OwnerID)
+	s.Name = strings.TrimSpace(s.Name)
+	s.Description = strings.TrimSpace(s.Description)
+	if s.OwnerID == "" || s.Name == "" {
+		return IncompleteShelf
+	}
+	if s.Visibility == "" {
+		s.Visibility = ShelfPrivate
+	}
+	if s.Visibility != ShelfPrivate && s.Visibility != ShelfPublic {
+		return IncorrectVisibility
+	}
+	if s.BookIDs == nil {
+		s.BookIDs = []string{}
+	}
+	return nil
+}
+
+// IsVisibleTo checks whether a user may see the shelf, the owner or anyone when public
+func (s *Shelf) IsVisibleTo(userID string) bool {
+	return s.Visibility == ShelfPublic || (userID != "" && s.OwnerID == userID)
+}
+
+// HasBook checks whether a book is on the shelf
+func (s *Shelf) HasBook(bookID string) bool {
+	return containsString(s.BookIDs, bookID)
+}
+
+// AddBooks inserts books at a position of the shelf, at the end when the position is negative or past it.
+// Books already on the shelf keep their place, books given twice are added once.
+func (s *Shelf) AddBooks(bookIDs []string, position int) {
+	var added []string
+	for _, id := range bookIDs {
+		if !s.HasBook(id) && !containsString(added, id) {
+			added = append(added, id)
+		}
+	}
+	if position < 0 || position > len(s.BookIDs) {
+		position = len(s.BookIDs)
+	}
+	books := make([]string, 0, len(s.BookIDs)+len(added))
+	books = append(books, s.BookIDs[:position]...)
+	books = append(books, added...)
+	s.BookIDs = append(books, s.BookIDs[position:]...)
+}
+
+// RemoveBooks takes books off the shelf, books not on it being ignored
+func (s *Shelf) RemoveBooks(bookIDs []string) {
+	books := make([]string, 0, len(s.BookIDs))
+	for _, id := range s.BookIDs {
+		if !containsString(bookIDs, id) {
+			books = append(books, id)
+		}
+	}
+	s.BookIDs = books
+}
+
+// Reorder puts the books of the shelf in the given order, which must list every book of the shelf once
+func (s *Shelf) Reorder(bookIDs []string) error {
+	if len(bookIDs) != len(s.BookIDs) {
+		return IncorrectShelfOrder
+	}
+	seen := map[string]bool{}
+	for _, id := range bookIDs {
+		if seen[id] || !s.HasBook(id) {
+			return IncorrectShelfOrder
+		}
+		seen[id] = true
+	}
+	s.BookIDs = append([]string{}, bookIDs...)
+	return nil
+}
+
+// Matches checks whether the shelf is selected by the filter
+func (f ShelfFilter) Matches(shelf Shelf) bool {
+	if f.OwnerID != "" && shelf.OwnerID != f.OwnerID {
+		return false
+	}
+	return shelf.IsVisibleTo(f.VisibleTo)
+}
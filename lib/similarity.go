@@ -0,0 +1,177 @@
+package lib
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+const (
+	JsonBsonTagTerms = "terms"
+
+	TermVectorSize = 256 // most frequent terms of a book kept for similarity
+)
+
+// SimilarBook is a book similar to another, scored by the cosine of their TF-IDF vectors, from 0 to 1
+type SimilarBook struct {
+	BookIdentifier
+	Score float64 `json:"score"`
+}
+
+// SimilarityIndex finds books similar to a book among the books of a library, from the term frequencies of their contents.
+// Terms are weighted by 1 + ln of their frequency in a book, times their smoothed inverse document frequency
+// ln((1 + books) / (1 + books using the term)) + 1, so that terms used by every book count the least.
+// The index is safe for concurrent use.
+type SimilarityIndex struct {
+	lock     sync.Mutex
+	books    map[string]*indexedBook     // keyed by book id
+	postings map[string]map[string]int64 // term frequencies keyed by term then book id
+	norms    map[string]float64          // norms of the vectors of books keyed by book id, nil once outdated
+}
+
+// indexedBook is a book of a similarity index, with the version of its contents it was indexed at
+type indexedBook struct {
+	identifier BookIdentifier
+	version    string
+	terms      []TermCount
+}
+
+// NewSimilarityIndex returns an empty similarity index
+func NewSimilarityIndex() *SimilarityIndex {
+	return &SimilarityIndex{books: map[string]*indexedBook{}, postings: map[string]map[string]int64{}}
+}
+
+// Put indexes the terms of a version of the contents of a book, in place of the terms it was indexed with
+func (x *SimilarityIndex) Put(book BookIdentifier, version string, terms []TermCount) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	x.remove(book.ID)
+	book.DeletedAt = nil
+	x.books[book.ID] = &indexedBook{identifier: book, version: version, terms: terms}
+	x.norms = nil
+	for _, term := range terms {
+		if x.postings[term.Term] == nil {
+			x.postings[term.Term] = map[string]int64{}
+		}
+		x.postings[term.Term][book.ID] = term.Count
+	}
+}
+
+// Remove removes a book from the index
+func (x *SimilarityIndex) Remove(bookID string) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	x.remove(bookID)
+}
+
+// remove removes a book from the index. Caller must hold the lock.
+func (x *SimilarityIndex) remove(bookID string) {
+	book, found := x.books[bookID]
+	if !found {
+		return
+	}
+	for _, term := range book.terms {
+		delete(x.postings[term.Term], bookID)
+		if len(x.postings[term.Term]) == 0 {
+			delete(x.postings, term.Term)
+		}
+	}
+	delete(x.books, bookID)
+	x.norms = nil
+}
+
+// Version returns the version of the contents a book was indexed at, and whether it is indexed
+func (x *SimilarityIndex) Version(bookID string) (string, bool) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	book, found := x.books[bookID]
+	if !found {
+		return "", false
+	}
+	return book.version, true
+}
+
+// Rename changes the name and author of an indexed book, which keeps its terms
+func (x *SimilarityIndex) Rename(book BookIdentifier) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	if indexed, found := x.books[book.ID]; found {
+		book.DeletedAt = nil
+		indexed.identifier = book
+	}
+}
+
+// BookIDs lists the ids of the indexed books
+func (x *SimilarityIndex) BookIDs() []string {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	ids := make([]string, 0, len(x.books))
+	for id := range x.books {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Similar returns up to limit of the books most similar to a book, most similar first, leaving out books sharing no
+// term with it
+func (x *SimilarityIndex) Similar(bookID string, limit int) ([]SimilarBook, error) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	book, found := x.books[bookID]
+	if !found {
+		return nil, NoMatchingBook
+	}
+	if x.norms == nil {
+		x.norms = map[string]float64{}
+		for term, books := range x.postings {
+			idf := x.idf(term)
+			for id, count := range books {
+				x.norms[id] += math.Pow(termWeight(count)*idf, 2)
+			}
+		}
+	}
+
+	scores := map[string]float64{}
+	for _, term := range book.terms {
+		idf := x.idf(term.Term)
+		weight := termWeight(term.Count) * idf
+		for id, count := range x.postings[term.Term] {
+			if id != bookID {
+				scores[id] += weight * termWeight(count) * idf
+			}
+		}
+	}
+	similar := []SimilarBook{}
+	for id, score := range scores {
+		if score > 0 {
+			score /= math.Sqrt(x.norms[bookID] * x.norms[id])
+			similar = append(similar, SimilarBook{BookIdentifier: x.books[id].identifier, Score: math.Min(score, 1)})
+		}
+	}
+	sort.Slice(similar, func(i, j int) bool {
+		if similar[i].Score != similar[j].Score {
+			return similar[i].Score > similar[j].Score
+		}
+		return similar[i].ID < similar[j].ID
+	})
+	if len(similar) > limit {
+		similar = similar[:limit]
+	}
+	return similar, nil
+}
+
+// idf returns the smoothed inverse document frequency of a term. Caller must hold the lock.
+func (x *SimilarityIndex) idf(term string) float64 {
+	return math.Log(float64(1+len(x.books))/float64(1+len(x.postings[term]))) + 1
+}
+
+// termWeight dampens the frequency of a term in a book, a term used ten times not weighing ten times one used once
+func termWeight(count int64) float64 {
+	return 1 + math.Log(float64(count))
+}
@@ -0,0 +1,47 @@
+package lib
+
+import (
+	"testing"
+)
+
+func TestSimilarityIndex(t *testing.T) {
+	index := NewSimilarityIndex()
+	index.Put(BookIdentifier{ID: "hobbit", Name: "the hobbit"}, "1", CountStats("dragon hobbit dragon gold mountain dwarves journey").TopTerms)
+	index.Put(BookIdentifier{ID: "lotr", Name: "the lord of the rings"}, "1", CountStats("hobbit ring journey mountain dwarves orcs").TopTerms)
+	index.Put(BookIdentifier{ID: "earthsea", Name: "a wizard of earthsea"}, "1", CountStats("dragon wizard island journey shadow").TopTerms)
+	index.Put(BookIdentifier{ID: "cooking", Name: "cooking"}, "1", CountStats("bread butter flour oven journey").TopTerms)
+
+	similar, err := index.Similar("hobbit", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(similar) != 3 || similar[0].ID != "lotr" || similar[1].ID != "earthsea" || similar[2].ID != "cooking" || similar[0].Name != "the lord of the rings" {
+		t.Fatal("expecting books ranked by shared terms got", similar)
+	}
+	if similar[0].Score <= similar[1].Score || similar[2].Score <= 0 || similar[0].Score > 1 {
+		t.Error("expecting decreasing scores from 0 to 1 got", similar)
+	}
+
+	// terms used by every book weigh the least, books sharing none are left out
+	index.Put(BookIdentifier{ID: "cooking", Name: "cooking"}, "2", CountStats("bread butter flour oven").TopTerms)
+	if similar, _ = index.Similar("hobbit", 10); len(similar) != 2 {
+		t.Error("expecting books sharing terms only got", similar)
+	}
+	if indexed, found := index.Version("cooking"); !found || indexed != "2" {
+		t.Error("expecting the version of the book indexed got", indexed, found)
+	}
+	index.Rename(BookIdentifier{ID: "lotr", Name: "the fellowship of the ring"})
+	if similar, _ = index.Similar("hobbit", 1); len(similar) != 1 || similar[0].Name != "the fellowship of the ring" {
+		t.Error("expecting the most similar book only got", similar)
+	}
+	index.Remove("lotr")
+	if similar, _ = index.Similar("hobbit", 10); len(similar) != 1 || similar[0].ID != "earthsea" {
+		t.Error("expecting removed books left out got", similar)
+	}
+	if len(index.BookIDs()) != 3 {
+		t.Error("expecting three books indexed got", index.BookIDs())
+	}
+	if _, err = index.Similar("lotr", 10); err != NoMatchingBook {
+		t.Error("expecting", NoMatchingBook, "got", err)
+	}
+}
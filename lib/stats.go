@@ -0,0 +1,204 @@
+package lib
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	JsonBsonTagStats = "stats"
+
+	WordsPerMinute = 238 // average silent reading speed of adults, for reading times
+	topTermCount   = 10
+	minTermLength  = 3 // runes of the shortest top term
+)
+
+// stopWords are the frequent English words left out of top terms
+var stopWords = func() map[string]bool {
+	words := map[string]bool{}
+	for _, word := range strings.Fields(`about above after again against all also and any are aren't because been before being
+		below between both but can can't cannot could couldn't did didn't does doesn't doing don't down during each few for from
+		further had hadn't has hasn't have haven't having he'd he'll he's her here here's hers herself him himself his how how's
+		i'd i'll i'm i've into isn't it's its itself let's more most mustn't myself nor not off once only other ought our ours
+		ourselves out over own said same shan't she she'd she'll she's should shouldn't some such than that that's the their
+		theirs them themselves then there there's these they they'd they'll they're they've this those through too under until
+		upon very was wasn't we'd we'll we're we've were weren't what what's when when's where where's which while who who's
+		whom why why's will with won't would wouldn't you you'd you'll you're you've your yours yourself yourselves`) {
+		words[word] = true
+	}
+	return words
+}()
+
+// BookStats are statistics of the contents of a book, computed whenever they are written. Words are runs of letters and
+// digits, joined by apostrophes and hyphens, and are case folded for the vocabulary and top terms.
+type BookStats struct {
+	Words          int64       `bson:"words" json:"words"`
+	Characters     int64       `bson:"characters" json:"characters"`
+	ReadingMinutes int64       `bson:"readingMinutes" json:"readingMinutes"` // at WordsPerMinute, rounded up
+	Vocabulary     int64       `bson:"vocabulary" json:"vocabulary"`         // distinct words
+	TopTerms       []TermCount `bson:"topTerms" json:"topTerms"`             // most frequent words but for stop words, most frequent first
+}
+
+// TermCount is a word with the number of times it is used
+type TermCount struct {
+	Term  string `bson:"term" json:"term"`
+	Count int64  `bson:"count" json:"count"`
+}
+
+// StatsTotals are statistics summed over books
+type StatsTotals struct {
+	Books          int   `json:"books"`
+	Words          int64 `json:"words"`
+	Characters     int64 `json:"characters"`
+	ReadingMinutes int64 `json:"readingMinutes"`
+}
+
+// AuthorStats are the statistics of the books of an author
+type AuthorStats struct {
+	Author string `json:"author"`
+	StatsTotals
+}
+
+// LibraryStats are the statistics of the books of a library, in total and for each primary author
+type LibraryStats struct {
+	StatsTotals
+	AverageWords int64         `json:"averageWords"`
+	Authors      []AuthorStats `json:"authors"`
+}
+
+// StatsCounter computes the statistics of contents written to it, so that they can be counted while contents are stored
+type StatsCounter struct {
+	words      int64
+	characters int64
+	counts     map[string]int64
+
+	pending []byte // start of a character cut by the end of a write
+	word    []byte // word being read
+	joiner  []byte // apostrophe or hyphen read after the word, part of it when followed by a letter
+}
+
+// NewStatsCounter returns a counter of the statistics of contents
+func NewStatsCounter() *StatsCounter {
+	return &StatsCounter{counts: map[string]int64{}}
+}
+
+// Write counts the next bytes of the contents
+func (c *StatsCounter) Write(data []byte) (int, error) {
+	text := data
+	if len(c.pending) > 0 {
+		text = append(c.pending, data...)
+		c.pending = nil
+	}
+	for len(text) > 0 {
+		r, size := utf8.DecodeRune(text)
+		if r == utf8.RuneError && size <= 1 && !utf8.FullRune(text) {
+			c.pending = append([]byte{}, text...)
+			break
+		}
+		c.characters++
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r):
+			c.word = append(c.word, c.joiner...)
+			c.word = append(c.word, text[:size]...)
+			c.joiner = c.joiner[:0]
+		case len(c.word) > 0 && len(c.joiner) == 0 && (r == '\'' || r == '’' || r == '-'):
+			c.joiner = append(c.joiner, text[:size]...)
+		default:
+			c.endWord()
+		}
+		text = text[size:]
+	}
+	return len(data), nil
+}
+
+// endWord counts the word read
+func (c *StatsCounter) endWord() {
+	c.joiner = c.joiner[:0]
+	if len(c.word) == 0 {
+		return
+	}
+	c.words++
+	c.counts[strings.ReplaceAll(strings.ToLower(string(c.word)), "’", "'")]++
+	c.word = c.word[:0]
+}
+
+// Stats ends the counting and returns the statistics of the contents written
+func (c *StatsCounter) Stats() *BookStats {
+	c.characters += int64(len(c.pending)) // invalid bytes ending the contents
+	c.pending = nil
+	c.endWord()
+
+	return &BookStats{
+		Words:          c.words,
+		Characters:     c.characters,
+		ReadingMinutes: (c.words + WordsPerMinute - 1) / WordsPerMinute,
+		Vocabulary:     int64(len(c.counts)),
+		TopTerms:       c.Terms(topTermCount),
+	}
+}
+
+// Terms ends the counting and returns up to limit of the most frequent words of the contents written, most frequent
+// first, but for stop words, short words and numbers
+func (c *StatsCounter) Terms(limit int) []TermCount {
+	c.endWord()
+	terms := []TermCount{}
+	for term, count := range c.counts {
+		if !stopWords[term] && utf8.RuneCountInString(term) >= minTermLength && strings.IndexFunc(term, unicode.IsLetter) >= 0 {
+			terms = append(terms, TermCount{Term: term, Count: count})
+		}
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		if terms[i].Count != terms[j].Count {
+			return terms[i].Count > terms[j].Count
+		}
+		return terms[i].Term < terms[j].Term
+	})
+	if len(terms) > limit {
+		terms = terms[:limit]
+	}
+	return terms
+}
+
+// CountStats computes the statistics of a whole text
+func CountStats(text string) *BookStats {
+	counter := NewStatsCounter()
+	_, _ = counter.Write([]byte(text))
+	return counter.Stats()
+}
+
+// AggregateStats sums the statistics of books in total and for each primary author, sorted by author. Books without
+// statistics are counted as books only.
+func AggregateStats(books []Book) LibraryStats {
+	library := LibraryStats{Authors: []AuthorStats{}}
+	authors := map[string]*AuthorStats{}
+	for _, book := range books {
+		author, found := authors[book.Author]
+		if !found {
+			author = &AuthorStats{Author: book.Author}
+			authors[book.Author] = author
+		}
+		library.StatsTotals.add(book.Stats)
+		author.StatsTotals.add(book.Stats)
+	}
+	for _, author := range authors {
+		library.Authors = append(library.Authors, *author)
+	}
+	sort.Slice(library.Authors, func(i, j int) bool { return library.Authors[i].Author < library.Authors[j].Author })
+	if library.Books > 0 {
+		library.AverageWords = library.Words / int64(library.Books)
+	}
+	return library
+}
+
+// add adds the statistics of a book to the totals
+func (t *StatsTotals) add(stats *BookStats) {
+	t.Books++
+	if stats == nil {
+		return
+	}
+	t.Words += stats.Words
+	t.Characters += stats.Characters
+	t.ReadingMinutes += stats.ReadingMinutes
+}
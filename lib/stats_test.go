@@ -0,0 +1,64 @@
+package lib
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCountStats(t *testing.T) {
+	text := "# The Hobbit\n\nIn a hole in the ground there lived a hobbit. Not a nasty, dirty, wet hole: a hobbit-hole, " +
+		"and that means comfort. The hobbit's name was Bilbo — Bilbo Baggins, a well-to-do hobbit of the Shire. Café au lait - 1937."
+	stats := CountStats(text)
+	if stats.Words != 41 || stats.Characters != int64(len([]rune(text))) || stats.ReadingMinutes != 1 {
+		t.Error("expecting 41 words and 1 minute got", stats.Words, stats.Characters, stats.ReadingMinutes)
+	}
+	if stats.Vocabulary != 29 {
+		t.Error("expecting 29 distinct words got", stats.Vocabulary)
+	}
+	expectedTerms := []TermCount{{"hobbit", 3}, {"bilbo", 2}, {"hole", 2}, {"baggins", 1}, {"café", 1}, {"comfort", 1},
+		{"dirty", 1}, {"ground", 1}, {"hobbit's", 1}, {"hobbit-hole", 1}}
+	if !reflect.DeepEqual(stats.TopTerms, expectedTerms) {
+		t.Error("expecting the most frequent words but for stop words got", stats.TopTerms)
+	}
+
+	// counting as written in chunks, cutting characters, gives the same statistics
+	counter := NewStatsCounter()
+	for i := 0; i < len(text); i += 3 {
+		_, _ = counter.Write([]byte(text[i:min(i+3, len(text))]))
+	}
+	if chunked := counter.Stats(); !reflect.DeepEqual(chunked, stats) {
+		t.Error("expecting the same statistics when written in chunks got", chunked)
+	}
+
+	long := CountStats(strings.Repeat("word ", WordsPerMinute+1))
+	if long.ReadingMinutes != 2 || long.Vocabulary != 1 {
+		t.Error("expecting reading times rounded up got", long.ReadingMinutes)
+	}
+	if empty := CountStats(""); empty.Words != 0 || empty.ReadingMinutes != 0 || len(empty.TopTerms) != 0 {
+		t.Error("expecting no words got", empty)
+	}
+}
+
+func TestAggregateStats(t *testing.T) {
+	books := []Book{
+		{Author: "Tolkien", Stats: &BookStats{Words: 100, Characters: 500, ReadingMinutes: 1}},
+		{Author: "Austen", Stats: &BookStats{Words: 300, Characters: 1500, ReadingMinutes: 2}},
+		{Author: "Tolkien", Stats: &BookStats{Words: 200, Characters: 1000, ReadingMinutes: 1}},
+		{Author: "Tolkien"},
+	}
+	expected := LibraryStats{
+		StatsTotals:  StatsTotals{Books: 4, Words: 600, Characters: 3000, ReadingMinutes: 4},
+		AverageWords: 150,
+		Authors: []AuthorStats{
+			{Author: "Austen", StatsTotals: StatsTotals{Books: 1, Words: 300, Characters: 1500, ReadingMinutes: 2}},
+			{Author: "Tolkien", StatsTotals: StatsTotals{Books: 3, Words: 300, Characters: 1500, ReadingMinutes: 2}},
+		},
+	}
+	if stats := AggregateStats(books); !reflect.DeepEqual(stats, expected) {
+		t.Errorf("expecting %+v got %+v", expected, stats)
+	}
+	if empty := AggregateStats(nil); empty.Books != 0 || empty.AverageWords != 0 || empty.Authors == nil {
+		t.Error("expecting empty statistics got", empty)
+	}
+}
@@ -0,0 +1,269 @@
+package lib
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	JsonBsonTagStructure      = "structure"
+	JsonBsonTagChapterPattern = "chapterPattern"
+
+	maxHeadingLength = 256 // bytes of a line looked at for a chapter heading, longer lines are never headings
+)
+
+// markdownHeading matches an ATX Markdown heading, "## Title ##" giving a level 2 chapter titled Title
+var markdownHeading = regexp.MustCompile(`^(#{1,6})[ \t]+(.*?)(?:[ \t]+#+)?[ \t]*$`)
+
+// Chapter is an entry of the table of contents of a book. Offsets are in bytes of the contents, as for byte ranges,
+// a chapter running until the next chapter of the same or a higher level. Pages are counted from 1.
+type Chapter struct {
+	Title     string `bson:"title" json:"title"`
+	Level     int    `bson:"level" json:"level"` // 1 for chapters, deeper for sections
+	Start     int64  `bson:"start" json:"start"`
+	End       int64  `bson:"end" json:"end"`
+	FirstPage int    `bson:"firstPage" json:"firstPage"`
+	LastPage  int    `bson:"lastPage" json:"lastPage"`
+}
+
+// BookStructure is the table of contents of a book and where its pages start, parsed from the contents when written.
+// Pages hold about PageSize bytes, breaking early at a line end or a space, and every top level chapter starts a page.
+type BookStructure struct {
+	Length   int64     `bson:"length" json:"length"`
+	PageSize int       `bson:"pageSize" json:"pageSize"`
+	Pages    []int64   `bson:"pages" json:"-"` // byte offset of the start of each page
+	Chapters []Chapter `bson:"chapters" json:"chapters"`
+}
+
+// TableOfContents is the chapters of a book with its number of pages
+type TableOfContents struct {
+	PageCount int       `json:"pageCount"`
+	PageSize  int       `json:"pageSize"`
+	Chapters  []Chapter `json:"chapters"`
+}
+
+// BookPage is a page of the contents of a book with the chapter it starts in
+type BookPage struct {
+	Number    int    `json:"number"`
+	PageCount int    `json:"pageCount"`
+	Start     int64  `json:"start"`
+	End       int64  `json:"end"`
+	Chapter   string `json:"chapter,omitempty"`
+	Text      string `json:"text"`
+}
+
+var ( // Errors
+	IncorrectChapterPattern = errors.New("chapter pattern is not a valid regular expression")
+	NoMatchingPage          = errors.New("no such page in book")
+)
+
+// StructureParser parses the structure of contents written to it, so that it can be parsed while contents are stored.
+// Chapters start at Markdown headings, outside of fenced code blocks, or at lines matching a chapter pattern when one is given.
+type StructureParser struct {
+	pattern   *regexp.Regexp
+	pageSize  int64
+	structure BookStructure
+
+	offset    int64
+	pageStart int64
+	lineStart int64
+	lineEnd   int64 // offset after the last line end
+	space     int64 // offset after the last space
+	runeStart int64 // offset of the start of the last character
+	full      bool  // the page is full without a line end or space to break at, it breaks before the last whole character
+
+	line     []byte // start of the current line
+	longLine bool
+	fenced   bool
+}
+
+// NewStructureParser returns a parser breaking pages every pageSize bytes at most, with chapters at lines matching
+// the pattern, or at Markdown headings when the pattern is empty. A pattern with a group takes the title from the group.
+func NewStructureParser(pattern string, pageSize int) (*StructureParser, error) {
+	parser := &StructureParser{pageSize: int64(max(pageSize, 1))}
+	if pattern != "" {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, IncorrectChapterPattern
+		}
+		parser.pattern = compiled
+	}
+	parser.structure = BookStructure{PageSize: int(parser.pageSize), Pages: []int64{0}, Chapters: []Chapter{}}
+	return parser, nil
+}
+
+// Write parses the next bytes of the contents
+func (p *StructureParser) Write(data []byte) (int, error) {
+	for _, c := range data {
+		continuation := c&0xC0 == 0x80
+		if p.full {
+			if continuation && p.runeStart > p.pageStart { // the page ends before the character it cuts
+				p.breakPage(p.runeStart)
+			} else {
+				p.breakPage(p.offset)
+			}
+		}
+		if !continuation {
+			p.runeStart = p.offset
+		}
+		if len(p.line) < maxHeadingLength {
+			p.line = append(p.line, c)
+		} else {
+			p.longLine = true
+		}
+		p.offset++
+		switch c {
+		case '\n':
+			p.endLine()
+		case ' ', '\t':
+			p.space = p.offset
+		}
+		if !p.full && p.offset-p.pageStart >= p.pageSize {
+			p.fillPage()
+		}
+	}
+	return len(data), nil
+}
+
+// Structure ends the parsing and returns the structure of the contents written
+func (p *StructureParser) Structure() *BookStructure {
+	if p.offset > p.lineStart {
+		p.endLine()
+	}
+	structure := p.structure
+	structure.Chapters = append([]Chapter{}, p.structure.Chapters...)
+	if pages := len(structure.Pages); pages > 1 && structure.Pages[pages-1] == p.offset {
+		structure.Pages = structure.Pages[:pages-1]
+	}
+	structure.Length = p.offset
+	for i := range structure.Chapters {
+		chapter := &structure.Chapters[i]
+		chapter.End = structure.Length
+		for _, next := range structure.Chapters[i+1:] {
+			if next.Level <= chapter.Level {
+				chapter.End = next.Start
+				break
+			}
+		}
+		chapter.FirstPage = structure.PageOf(chapter.Start)
+		chapter.LastPage = structure.PageOf(max(chapter.End-1, chapter.Start))
+	}
+	return &structure
+}
+
+// fillPage breaks a full page at its last line end, or its last space, or after its last whole character
+func (p *StructureParser) fillPage() {
+	switch {
+	case p.lineEnd > p.pageStart:
+		p.breakPage(p.lineEnd)
+	case p.space > p.pageStart:
+		p.breakPage(p.space)
+	default:
+		p.full = true
+	}
+}
+
+// breakPage starts a new page at an offset
+func (p *StructureParser) breakPage(offset int64) {
+	p.structure.Pages = append(p.structure.Pages, offset)
+	p.pageStart = offset
+	p.full = false
+}
+
+// endLine looks for a chapter heading in the line just read
+func (p *StructureParser) endLine() {
+	if !p.longLine {
+		if title, level, isHeading := p.heading(strings.TrimRight(string(p.line), "\r\n")); isHeading {
+			if level == 1 && p.lineStart > p.pageStart {
+				p.breakPage(p.lineStart)
+			}
+			p.structure.Chapters = append(p.structure.Chapters, Chapter{Title: title, Level: level, Start: p.lineStart})
+		}
+	}
+	p.lineStart = p.offset
+	p.lineEnd = p.offset
+	p.line = p.line[:0]
+	p.longLine = false
+}
+
+// heading returns the title and level of a line starting a chapter
+func (p *StructureParser) heading(line string) (string, int, bool) {
+	if p.pattern != nil {
+		match := p.pattern.FindStringSubmatch(line)
+		if match == nil {
+			return "", 0, false
+		}
+		title := match[0]
+		if len(match) > 1 {
+			title = match[1]
+		}
+		return strings.TrimSpace(title), 1, true
+	}
+
+	trimmed := strings.TrimSpace(line)
+	if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+		p.fenced = !p.fenced
+		return "", 0, false
+	}
+	if p.fenced {
+		return "", 0, false
+	}
+	match := markdownHeading.FindStringSubmatch(line)
+	if match == nil || match[2] == "" {
+		return "", 0, false
+	}
+	return match[2], len(match[1]), true
+}
+
+// ParseStructure parses the structure of a whole text
+func ParseStructure(text, pattern string, pageSize int) (*BookStructure, error) {
+	parser, err := NewStructureParser(pattern, pageSize)
+	if err != nil {
+		return nil, err
+	}
+	_, _ = parser.Write([]byte(text))
+	return parser.Structure(), nil
+}
+
+// PageCount returns the number of pages of the book
+func (s *BookStructure) PageCount() int {
+	return len(s.Pages)
+}
+
+// PageOf returns the page holding a byte offset, counted from 1
+func (s *BookStructure) PageOf(offset int64) int {
+	return sort.Search(len(s.Pages), func(i int) bool { return s.Pages[i] > offset })
+}
+
+// Page returns the byte range of a page counted from 1, its end excluded
+func (s *BookStructure) Page(number int) (int64, int64, error) {
+	if number < 1 || number > len(s.Pages) {
+		return 0, 0, NoMatchingPage
+	}
+	end := s.Length
+	if number < len(s.Pages) {
+		end = s.Pages[number]
+	}
+	return s.Pages[number-1], end, nil
+}
+
+// ChapterAt returns the title of the innermost chapter holding a byte offset, empty before the first chapter
+func (s *BookStructure) ChapterAt(offset int64) string {
+	title := ""
+	for _, chapter := range s.Chapters {
+		if chapter.Start > offset {
+			break
+		}
+		if chapter.End > offset {
+			title = chapter.Title
+		}
+	}
+	return title
+}
+
+// TableOfContents returns the chapters of the book with its number of pages
+func (s *BookStructure) TableOfContents() TableOfContents {
+	return TableOfContents{PageCount: s.PageCount(), PageSize: s.PageSize, Chapters: s.Chapters}
+}
@@ -0,0 +1,87 @@
+package lib
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestParseStructure(t *testing.T) {
+	text := "Preface.\n\n# Chapter one ##\n\nSome text.\n\n## A section\n\n```\n# not a heading\n```\n\n# Chapter two\n\nThe end."
+	structure, err := ParseStructure(text, "", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for _, chapter := range structure.Chapters {
+		titles = append(titles, chapter.Title)
+	}
+	if !reflect.DeepEqual(titles, []string{"Chapter one", "A section", "Chapter two"}) {
+		t.Fatal("expecting the headings out of code blocks got", titles)
+	}
+	one, section, two := structure.Chapters[0], structure.Chapters[1], structure.Chapters[2]
+	if one.End != two.Start || section.End != two.Start || section.Level != 2 || two.End != int64(len(text)) {
+		t.Error("expecting chapters to run until the next chapter of their level got", structure.Chapters)
+	}
+	if structure.PageCount() != 3 || one.FirstPage != 2 || two.FirstPage != 3 || !strings.HasPrefix(text[two.Start:], "# Chapter two") {
+		t.Error("expecting every chapter on a new page got", structure.Pages, structure.Chapters)
+	}
+
+	// with a pattern, matching lines are chapters titled by the group
+	structure, err = ParseStructure("CHAPTER I. Bilbo\ntext\n# not a chapter\nCHAPTER II. Frodo\ntext", `^CHAPTER [IVX]+\. (.*)$`, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(structure.Chapters) != 2 || structure.Chapters[0].Title != "Bilbo" || structure.Chapters[1].Title != "Frodo" {
+		t.Error("expecting the chapters matching the pattern got", structure.Chapters)
+	}
+	if _, err = ParseStructure(text, "CHAPTER (", 1000); err != IncorrectChapterPattern {
+		t.Error("expecting an incorrect pattern refused got", err)
+	}
+}
+
+func TestPages(t *testing.T) {
+	text := strings.Repeat("a few words on a line\n", 20) + strings.Repeat("wordswithoutspaces", 10) + strings.Repeat("é", 100) + strings.Repeat("€", 100)
+	structure, err := ParseStructure(text, "", 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rebuilt strings.Builder
+	for number := 1; number <= structure.PageCount(); number++ {
+		start, end, err := structure.Page(number)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page := text[start:end]
+		if len(page) == 0 || len(page) > 50 || !utf8.ValidString(page) {
+			t.Errorf("expecting page %d of at most 50 bytes of whole characters got %q", number, page)
+		}
+		if end <= 20*22 && !strings.HasSuffix(page, "\n") {
+			t.Errorf("expecting page %d to end at a line end got %q", number, page)
+		}
+		rebuilt.WriteString(page)
+	}
+	if rebuilt.String() != text {
+		t.Error("expecting the pages to cover the text")
+	}
+	if _, _, err = structure.Page(structure.PageCount() + 1); err != NoMatchingPage {
+		t.Error("expecting no page past the end got", err)
+	}
+
+	// parsing as written in chunks gives the same structure
+	parser, err := NewStructureParser("", 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(text); i += 7 {
+		_, _ = parser.Write([]byte(text[i:min(i+7, len(text))]))
+	}
+	if chunked := parser.Structure(); !reflect.DeepEqual(chunked, structure) {
+		t.Error("expecting the same structure when written in chunks got", chunked.Pages, structure.Pages)
+	}
+
+	if empty, _ := ParseStructure("", "", 50); empty.PageCount() != 1 || len(empty.Chapters) != 0 {
+		t.Error("expecting a single empty page got", empty)
+	}
+}
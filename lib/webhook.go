@@ -0,0 +1,94 @@
+package lib
+
+import (
+	"errors"
+	"net/url"
+	"time"
+)
+
+const (
+	JsonBsonTagLibrary       = "library"
+	JsonBsonTagWebhookID     = "webhookId"
+	JsonBsonTagStatus        = "status"
+	JsonBsonTagNextAttemptAt = "nextAttemptAt"
+	JsonBsonTagFinishedAt    = "finishedAt"
+
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead" // gave up after the last retry, kept in the dead-letter list
+)
+
+// webhookEvents are the book changes a webhook may subscribe to
+var webhookEvents = map[string]bool{
+	ChangeCreated:  true,
+	ChangeUpdated:  true,
+	ChangeDeleted:  true,
+	ChangeRestored: true,
+	ChangePurged:   true,
+}
+
+// Webhook subscribes an url to the book changes of a library. Payloads are signed with an HMAC-SHA256 of the secret.
+type Webhook struct {
+	ID        string    `bson:"_id,omitempty" json:"id,omitempty"`
+	Library   string    `bson:"library" json:"library"`
+	URL       string    `bson:"url" json:"url"`
+	Secret    string    `bson:"secret" json:"secret,omitempty"`           // only returned when the webhook is created
+	Events    []string  `bson:"events,omitempty" json:"events,omitempty"` // every event when empty
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// WebhookPayload is the body posted to a webhook for a book change
+type WebhookPayload struct {
+	Event   string         `bson:"event" json:"event"`
+	Library string         `bson:"library" json:"library"`
+	Book    BookIdentifier `bson:"book" json:"book"`
+	Time    time.Time      `bson:"time" json:"time"`
+}
+
+// Delivery is a queued payload for a webhook, with the log of every attempt to post it
+type Delivery struct {
+	ID            string            `bson:"_id,omitempty" json:"id,omitempty"`
+	WebhookID     string            `bson:"webhookId" json:"webhookId"`
+	Library       string            `bson:"library" json:"library"`
+	Payload       WebhookPayload    `bson:"payload" json:"payload"`
+	Status        string            `bson:"status" json:"status"`
+	Attempts      []DeliveryAttempt `bson:"attempts" json:"attempts"`
+	Failures      int               `bson:"failures" json:"failures"` // failed attempts since queued or last retried by hand
+	NextAttemptAt time.Time         `bson:"nextAttemptAt" json:"nextAttemptAt"`
+	CreatedAt     time.Time         `bson:"createdAt" json:"createdAt"`
+	FinishedAt    *time.Time        `bson:"finishedAt,omitempty" json:"finishedAt,omitempty"` // set once delivered or dead, until retried
+}
+
+// DeliveryAttempt logs a single post of a delivery
+type DeliveryAttempt struct {
+	Time       time.Time `bson:"time" json:"time"`
+	StatusCode int       `bson:"statusCode,omitempty" json:"statusCode,omitempty"`
+	Error      string    `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+var ( // Errors
+	NoMatchingWebhook  = errors.New("no matching webhook in library")
+	IncorrectWebhook   = errors.New("webhooks need an absolute http or https url and events among created, updated, deleted, restored and purged")
+	NoMatchingDelivery = errors.New("no matching delivery for webhook")
+	DeliveryNotDead    = errors.New("only dead deliveries can be retried")
+	PrivateWebhook     = errors.New("webhooks cannot post to private, loopback or link-local addresses")
+)
+
+// Validate checks the url and events of a webhook
+func (w *Webhook) Validate() error {
+	parsed, err := url.Parse(w.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return IncorrectWebhook
+	}
+	for _, event := range w.Events {
+		if !webhookEvents[event] {
+			return IncorrectWebhook
+		}
+	}
+	return nil
+}
+
+// Subscribes checks whether the webhook wants the given event
+func (w *Webhook) Subscribes(event string) bool {
+	return len(w.Events) == 0 || containsString(w.Events, event)
+}
@@ -0,0 +1,351 @@
+package lib
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// droppedElements are the elements whose text is not part of the contents
+var droppedElements = map[string]bool{"head": true, "title": true, "script": true, "style": true, "nav": true, "svg": true, "math": true}
+
+// separatingElements are the block elements not written as Markdown markup, their text is a paragraph
+var separatingElements = map[string]bool{
+	"p": true, "div": true, "section": true, "article": true, "header": true, "footer": true, "aside": true, "main": true,
+	"body": true, "figure": true, "figcaption": true, "address": true, "dl": true, "dt": true, "dd": true, "table": true,
+	"caption": true, "tr": true,
+}
+
+// inlineDelimiters are the Markdown delimiters of inline elements
+var inlineDelimiters = map[string]string{
+	"em": "*", "i": "*", "cite": "*", "dfn": "*", "var": "*", "strong": "**", "b": "**", "code": "`", "kbd": "`", "samp": "`", "a": "",
+}
+
+// markdownConverter writes the blocks of an XHTML document as Markdown
+type markdownConverter struct {
+	out  strings.Builder
+	line []byte // inline Markdown of the block being read
+
+	prefix   string // list marker or heading markup of the block being read
+	heading  bool
+	lists    []markdownList
+	quote    int // depth of block quotes
+	dropped  int // depth within dropped elements
+	pre      int // depth within preformatted text
+	language string
+	inlines  []inlineMark
+
+	wrote     bool
+	lastItem  bool
+	lastQuote int
+}
+
+// markdownList is an open list, with the number of its next item when ordered
+type markdownList struct {
+	ordered bool
+	number  int
+	indent  int // of the content of its items
+}
+
+// inlineMark is an open inline element, starting at an offset of the line
+type inlineMark struct {
+	tag   string
+	start int
+	href  string
+}
+
+// XHTMLToMarkdown converts an XHTML or HTML document into Markdown, keeping its headings, paragraphs, emphasis, code,
+// lists, block quotes, rules and web links, and dropping images, scripts, styles and navigation
+func XHTMLToMarkdown(document io.Reader) (string, error) {
+	decoder := xml.NewDecoder(document)
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+	decoder.CharsetReader = charsetReader
+	converter := &markdownConverter{}
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", IncorrectEPUB
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			converter.start(strings.ToLower(token.Name.Local), token.Attr)
+		case xml.EndElement:
+			converter.end(strings.ToLower(token.Name.Local))
+		case xml.CharData:
+			converter.text(string(token))
+		}
+	}
+	converter.endBlock()
+	return converter.out.String(), nil
+}
+
+// start handles the start of an element
+func (c *markdownConverter) start(tag string, attributes []xml.Attr) {
+	if droppedElements[tag] || c.dropped > 0 {
+		if droppedElements[tag] {
+			c.dropped++
+		}
+		return
+	}
+	if c.pre > 0 {
+		if tag == "code" {
+			for _, attribute := range attributes {
+				if language, found := strings.CutPrefix(attribute.Value, "language-"); attribute.Name.Local == "class" && found {
+					c.language = strings.Fields(language + " ")[0]
+				}
+			}
+		}
+		if tag == "pre" {
+			c.pre++
+		}
+		return
+	}
+	switch {
+	case tag == "pre":
+		c.endBlock()
+		c.pre++
+	case len(tag) == 2 && tag[0] == 'h' && tag[1] >= '1' && tag[1] <= '6':
+		c.endBlock()
+		c.prefix = strings.Repeat("#", int(tag[1]-'0')) + " "
+		c.heading = true
+	case tag == "blockquote":
+		c.endBlock()
+		c.quote++
+	case tag == "ul" || tag == "ol":
+		c.endBlock()
+		indent := 0
+		if len(c.lists) > 0 {
+			indent = c.lists[len(c.lists)-1].indent
+		}
+		c.lists = append(c.lists, markdownList{ordered: tag == "ol", number: 1, indent: indent})
+	case tag == "li":
+		c.endBlock()
+		if len(c.lists) == 0 {
+			c.lists = append(c.lists, markdownList{})
+		}
+		list := &c.lists[len(c.lists)-1]
+		marker := "- "
+		if list.ordered {
+			marker = strconv.Itoa(list.number) + ". "
+			list.number++
+		}
+		outer := 0
+		if len(c.lists) > 1 {
+			outer = c.lists[len(c.lists)-2].indent
+		}
+		list.indent = outer + len(marker)
+		c.prefix = strings.Repeat(" ", outer) + marker
+	case tag == "hr":
+		c.endBlock()
+		c.emit("* * *", "")
+	case tag == "br":
+		if c.heading {
+			c.text(" ")
+		} else if len(c.line) > 0 {
+			c.line = append([]byte(strings.TrimRight(string(c.line), " ")), "\\\n"...)
+		}
+	case tag == "td" || tag == "th":
+		c.text(" ")
+	case separatingElements[tag]:
+		c.endBlock()
+	default:
+		if _, found := inlineDelimiters[tag]; found {
+			mark := inlineMark{tag: tag, start: len(c.line)}
+			for _, attribute := range attributes {
+				if attribute.Name.Local == "href" {
+					mark.href = attribute.Value
+				}
+			}
+			c.inlines = append(c.inlines, mark)
+		}
+	}
+}
+
+// end handles the end of an element
+func (c *markdownConverter) end(tag string) {
+	if c.dropped > 0 {
+		if droppedElements[tag] {
+			c.dropped--
+		}
+		return
+	}
+	if c.pre > 0 {
+		if tag == "pre" {
+			c.pre--
+			if c.pre == 0 {
+				c.endCode()
+			}
+		}
+		return
+	}
+	switch {
+	case len(tag) == 2 && tag[0] == 'h' && tag[1] >= '1' && tag[1] <= '6':
+		c.endBlock()
+		c.prefix, c.heading = "", false
+	case tag == "blockquote":
+		c.endBlock()
+		c.quote = max(c.quote-1, 0)
+	case tag == "ul" || tag == "ol":
+		c.endBlock()
+		if len(c.lists) > 0 {
+			c.lists = c.lists[:len(c.lists)-1]
+		}
+	case tag == "li" || separatingElements[tag]:
+		c.endBlock()
+	default:
+		for i := len(c.inlines) - 1; i >= 0; i-- {
+			if c.inlines[i].tag == tag {
+				c.closeInline(c.inlines[i])
+				c.inlines = c.inlines[:i]
+				break
+			}
+		}
+	}
+}
+
+// text handles text, its white space collapsed and Markdown markup escaped outside of code
+func (c *markdownConverter) text(text string) {
+	if c.dropped > 0 {
+		return
+	}
+	if c.pre > 0 {
+		c.line = append(c.line, text...)
+		return
+	}
+	var collapsed strings.Builder
+	space := len(c.line) == 0 || strings.HasSuffix(string(c.line), " ") || strings.HasSuffix(string(c.line), "\n")
+	code := c.inCode()
+	for _, r := range text {
+		if r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == '\f' {
+			if !space {
+				collapsed.WriteByte(' ')
+			}
+			space = true
+			continue
+		}
+		space = false
+		if !code && strings.ContainsRune("\\*_`[]", r) {
+			collapsed.WriteByte('\\')
+		}
+		collapsed.WriteRune(r)
+	}
+	c.line = append(c.line, collapsed.String()...)
+}
+
+// inCode checks whether the text read is within inline code
+func (c *markdownConverter) inCode() bool {
+	for _, mark := range c.inlines {
+		if inlineDelimiters[mark.tag] == "`" {
+			return true
+		}
+	}
+	return false
+}
+
+// closeInline wraps the text of an inline element in its delimiters, outside of the white space it starts or ends with
+func (c *markdownConverter) closeInline(mark inlineMark) {
+	inner := string(c.line[mark.start:])
+	core := strings.TrimSpace(inner)
+	if core == "" {
+		return
+	}
+	leading := inner[:strings.Index(inner, core)]
+	trailing := inner[len(leading)+len(core):]
+	delimiter := inlineDelimiters[mark.tag]
+	switch {
+	case mark.tag == "a":
+		if href := safeURL(mark.href); href != "" && strings.Contains(href, ":") {
+			core = "[" + core + "](" + href + ")"
+		}
+	case delimiter == "`":
+		if strings.Contains(core, "`") {
+			core = "`` " + core + " ``"
+		} else {
+			core = "`" + core + "`"
+		}
+	case delimiter == "*" && (strings.HasPrefix(core, "*") || strings.HasSuffix(core, "*")):
+		core = "_" + core + "_" // emphasis around strong emphasis
+	default:
+		core = delimiter + core + delimiter
+	}
+	c.line = append(c.line[:mark.start], leading+core+trailing...)
+}
+
+// endBlock writes the block read, an empty block keeping the list marker for the first block of the item
+func (c *markdownConverter) endBlock() {
+	text := strings.TrimSpace(string(c.line))
+	for strings.HasSuffix(text, "\\") { // a line break ending the block
+		text = strings.TrimSpace(strings.TrimSuffix(text, "\\"))
+	}
+	c.line = c.line[:0]
+	c.inlines = c.inlines[:0]
+	if text == "" {
+		return
+	}
+	if !c.heading {
+		text = escapeBlockStart(text)
+	}
+	c.emit(text, c.prefix)
+	if !c.heading {
+		c.prefix = ""
+	}
+}
+
+// endCode writes the preformatted text read as a fenced code block
+func (c *markdownConverter) endCode() {
+	code := strings.TrimPrefix(string(c.line), "\n")
+	code = strings.TrimSuffix(code, "\n")
+	c.line = c.line[:0]
+	fence := "```"
+	if strings.Contains(code, "```") {
+		fence = "~~~~"
+	}
+	c.emit(fence+c.language+"\n"+code+"\n"+fence, "")
+	c.language = ""
+}
+
+// emit writes a block with a prefix, within the open block quotes, list items being kept together
+func (c *markdownConverter) emit(text, prefix string) {
+	item := strings.HasSuffix(prefix, "- ") || strings.HasSuffix(prefix, ". ")
+	quote := strings.Repeat("> ", c.quote)
+	if c.wrote {
+		if item && c.lastItem {
+			c.out.WriteString("\n")
+		} else {
+			c.out.WriteString("\n" + strings.TrimRight(strings.Repeat("> ", min(c.quote, c.lastQuote)), " ") + "\n")
+		}
+	}
+	for i, line := range strings.Split(text, "\n") {
+		lead := quote + prefix
+		if i > 0 {
+			c.out.WriteString("\n")
+			lead = quote + strings.Repeat(" ", len(prefix)) // continuation lines are indented as the first
+		}
+		if line == "" {
+			lead = strings.TrimRight(lead, " ")
+		}
+		c.out.WriteString(lead + line)
+	}
+	c.wrote, c.lastItem, c.lastQuote = true, item, c.quote
+}
+
+// escapeBlockStart escapes the start of a paragraph that would read as Markdown markup: headings, quotes, lists or rules
+func escapeBlockStart(text string) string {
+	if marker := listItemMarker.FindStringSubmatch(text); marker != nil {
+		if marker[3] != "" { // ordered, the dot or parenthesis is escaped
+			return text[:len(marker[3])] + "\\" + text[len(marker[3]):]
+		}
+		return "\\" + text
+	}
+	if strings.HasPrefix(text, "#") || strings.HasPrefix(text, ">") || strings.HasPrefix(text, "~~~") || isRule(text) {
+		return "\\" + text
+	}
+	return text
+}
@@ -0,0 +1,4 @@
+// Package librarypb holds the protobuf messages and gRPC service of the library api, generated from library.proto.
+package librarypb
+
+//go:generate protoc -I.. --go_out=.. --go_opt=paths=source_relative --go-grpc_out=.. --go-grpc_opt=paths=source_relative ../librarypb/library.proto
@@ -0,0 +1,360 @@
+// gRPC api of the book library, mirroring the rest api against the same storage.
+// Regenerate library.pb.go and library_grpc.pb.go with go generate ./librarypb after editing.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v4.25.1
+// source: librarypb/library.proto
+
+package librarypb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Library_ListBooks_FullMethodName   = "/library.v1.Library/ListBooks"
+	Library_SearchBooks_FullMethodName = "/library.v1.Library/SearchBooks"
+	Library_GetBook_FullMethodName     = "/library.v1.Library/GetBook"
+	Library_CreateBook_FullMethodName  = "/library.v1.Library/CreateBook"
+	Library_UpdateBook_FullMethodName  = "/library.v1.Library/UpdateBook"
+	Library_DeleteBook_FullMethodName  = "/library.v1.Library/DeleteBook"
+)
+
+// LibraryClient is the client API for Library service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type LibraryClient interface {
+	// ListBooks streams the identifier of every book of a library
+	ListBooks(ctx context.Context, in *ListBooksRequest, opts ...grpc.CallOption) (Library_ListBooksClient, error)
+	// SearchBooks streams the identifiers of the books of a library matching every set field of the request
+	SearchBooks(ctx context.Context, in *SearchBooksRequest, opts ...grpc.CallOption) (Library_SearchBooksClient, error)
+	GetBook(ctx context.Context, in *GetBookRequest, opts ...grpc.CallOption) (*Book, error)
+	CreateBook(ctx context.Context, in *CreateBookRequest, opts ...grpc.CallOption) (*Book, error)
+	// UpdateBook updates the book of the given id, or of the name and author when no id is given
+	UpdateBook(ctx context.Context, in *UpdateBookRequest, opts ...grpc.CallOption) (*Book, error)
+	// DeleteBook moves a book to the trash
+	DeleteBook(ctx context.Context, in *DeleteBookRequest, opts ...grpc.CallOption) (*DeleteBookResponse, error)
+}
+
+type libraryClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewLibraryClient(cc grpc.ClientConnInterface) LibraryClient {
+	return &libraryClient{cc}
+}
+
+func (c *libraryClient) ListBooks(ctx context.Context, in *ListBooksRequest, opts ...grpc.CallOption) (Library_ListBooksClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Library_ServiceDesc.Streams[0], Library_ListBooks_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &libraryListBooksClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Library_ListBooksClient interface {
+	Recv() (*BookIdentifier, error)
+	grpc.ClientStream
+}
+
+type libraryListBooksClient struct {
+	grpc.ClientStream
+}
+
+func (x *libraryListBooksClient) Recv() (*BookIdentifier, error) {
+	m := new(BookIdentifier)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *libraryClient) SearchBooks(ctx context.Context, in *SearchBooksRequest, opts ...grpc.CallOption) (Library_SearchBooksClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Library_ServiceDesc.Streams[1], Library_SearchBooks_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &librarySearchBooksClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Library_SearchBooksClient interface {
+	Recv() (*BookIdentifier, error)
+	grpc.ClientStream
+}
+
+type librarySearchBooksClient struct {
+	grpc.ClientStream
+}
+
+func (x *librarySearchBooksClient) Recv() (*BookIdentifier, error) {
+	m := new(BookIdentifier)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *libraryClient) GetBook(ctx context.Context, in *GetBookRequest, opts ...grpc.CallOption) (*Book, error) {
+	out := new(Book)
+	err := c.cc.Invoke(ctx, Library_GetBook_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *libraryClient) CreateBook(ctx context.Context, in *CreateBookRequest, opts ...grpc.CallOption) (*Book, error) {
+	out := new(Book)
+	err := c.cc.Invoke(ctx, Library_CreateBook_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *libraryClient) UpdateBook(ctx context.Context, in *UpdateBookRequest, opts ...grpc.CallOption) (*Book, error) {
+	out := new(Book)
+	err := c.cc.Invoke(ctx, Library_UpdateBook_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *libraryClient) DeleteBook(ctx context.Context, in *DeleteBookRequest, opts ...grpc.CallOption) (*DeleteBookResponse, error) {
+	out := new(DeleteBookResponse)
+	err := c.cc.Invoke(ctx, Library_DeleteBook_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// LibraryServer is the server API for Library service.
+// All implementations must embed UnimplementedLibraryServer
+// for forward compatibility
+type LibraryServer interface {
+	// ListBooks streams the identifier of every book of a library
+	ListBooks(*ListBooksRequest, Library_ListBooksServer) error
+	// SearchBooks streams the identifiers of the books of a library matching every set field of the request
+	SearchBooks(*SearchBooksRequest, Library_SearchBooksServer) error
+	GetBook(context.Context, *GetBookRequest) (*Book, error)
+	CreateBook(context.Context, *CreateBookRequest) (*Book, error)
+	// UpdateBook updates the book of the given id, or of the name and author when no id is given
+	UpdateBook(context.Context, *UpdateBookRequest) (*Book, error)
+	// DeleteBook moves a book to the trash
+	DeleteBook(context.Context, *DeleteBookRequest) (*DeleteBookResponse, error)
+	mustEmbedUnimplementedLibraryServer()
+}
+
+// UnimplementedLibraryServer must be embedded to have forward compatible implementations.
+type UnimplementedLibraryServer struct {
+}
+
+func (UnimplementedLibraryServer) ListBooks(*ListBooksRequest, Library_ListBooksServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListBooks not implemented")
+}
+func (UnimplementedLibraryServer) SearchBooks(*SearchBooksRequest, Library_SearchBooksServer) error {
+	return status.Errorf(codes.Unimplemented, "method SearchBooks not implemented")
+}
+func (UnimplementedLibraryServer) GetBook(context.Context, *GetBookRequest) (*Book, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBook not implemented")
+}
+func (UnimplementedLibraryServer) CreateBook(context.Context, *CreateBookRequest) (*Book, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateBook not implemented")
+}
+func (UnimplementedLibraryServer) UpdateBook(context.Context, *UpdateBookRequest) (*Book, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateBook not implemented")
+}
+func (UnimplementedLibraryServer) DeleteBook(context.Context, *DeleteBookRequest) (*DeleteBookResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteBook not implemented")
+}
+func (UnimplementedLibraryServer) mustEmbedUnimplementedLibraryServer() {}
+
+// UnsafeLibraryServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to LibraryServer will
+// result in compilation errors.
+type UnsafeLibraryServer interface {
+	mustEmbedUnimplementedLibraryServer()
+}
+
+func RegisterLibraryServer(s grpc.ServiceRegistrar, srv LibraryServer) {
+	s.RegisterService(&Library_ServiceDesc, srv)
+}
+
+func _Library_ListBooks_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListBooksRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LibraryServer).ListBooks(m, &libraryListBooksServer{stream})
+}
+
+type Library_ListBooksServer interface {
+	Send(*BookIdentifier) error
+	grpc.ServerStream
+}
+
+type libraryListBooksServer struct {
+	grpc.ServerStream
+}
+
+func (x *libraryListBooksServer) Send(m *BookIdentifier) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Library_SearchBooks_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SearchBooksRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LibraryServer).SearchBooks(m, &librarySearchBooksServer{stream})
+}
+
+type Library_SearchBooksServer interface {
+	Send(*BookIdentifier) error
+	grpc.ServerStream
+}
+
+type librarySearchBooksServer struct {
+	grpc.ServerStream
+}
+
+func (x *librarySearchBooksServer) Send(m *BookIdentifier) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Library_GetBook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetBookRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LibraryServer).GetBook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Library_GetBook_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LibraryServer).GetBook(ctx, req.(*GetBookRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Library_CreateBook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateBookRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LibraryServer).CreateBook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Library_CreateBook_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LibraryServer).CreateBook(ctx, req.(*CreateBookRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Library_UpdateBook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateBookRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LibraryServer).UpdateBook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Library_UpdateBook_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LibraryServer).UpdateBook(ctx, req.(*UpdateBookRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Library_DeleteBook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteBookRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LibraryServer).DeleteBook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Library_DeleteBook_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LibraryServer).DeleteBook(ctx, req.(*DeleteBookRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Library_ServiceDesc is the grpc.ServiceDesc for Library service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Library_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "library.v1.Library",
+	HandlerType: (*LibraryServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetBook",
+			Handler:    _Library_GetBook_Handler,
+		},
+		{
+			MethodName: "CreateBook",
+			Handler:    _Library_CreateBook_Handler,
+		},
+		{
+			MethodName: "UpdateBook",
+			Handler:    _Library_UpdateBook_Handler,
+		},
+		{
+			MethodName: "DeleteBook",
+			Handler:    _Library_DeleteBook_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ListBooks",
+			Handler:       _Library_ListBooks_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "SearchBooks",
+			Handler:       _Library_SearchBooks_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "librarypb/library.proto",
+}